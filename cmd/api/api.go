@@ -2,21 +2,62 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
 
 	. "github.com/alireza-karampour/sms/cmd"
 	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/heartbeats"
+	"github.com/alireza-karampour/sms/internal/ingest"
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/certs"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/alireza-karampour/sms/pkg/hlr"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/pkg/mqtt"
 	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/pkg/smtpd"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var (
 	UserController        *controllers.User
 	PhoneNumberController *controllers.PhoneNumber
+	PoolController        *controllers.PhoneNumberPool
 	SmsController         *controllers.Sms
+	QuietHoursController  *controllers.QuietHours
+	BlocklistController   *controllers.Blocklist
+	LookupController      *controllers.Lookup
+	AdminController       *controllers.Admin
+	ApiKeyController      *controllers.ApiKey
+	JobController         *controllers.Job
+	InvoiceController     *controllers.Invoice
+	WebhookController     *controllers.Webhook
+	CallbackController    *controllers.Callback
+	TwilioController      *controllers.Twilio
+	ResellerController    *controllers.Reseller
+	CoverageController    *controllers.Coverage
+	AlertController       *controllers.Alert
+	ForwardController     *controllers.Forward
+	TokenController       *controllers.Token
 )
 
 // ApiCmd represents the api command
@@ -24,49 +65,452 @@ var ApiCmd = &cobra.Command{
 	Use:   "api",
 	Short: "runs the REST Api server",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pool, err := pgxpool.New(context.Background(), fmt.Sprintf("postgresql://%s:%s@%s:%d/postgres?sslmode=disable",
-			viper.GetString("api.postgres.username"),
-			viper.GetString("api.postgres.password"),
-			viper.GetString("api.postgres.address"),
-			viper.GetInt("api.postgres.port"),
-		))
+		ctx, cancel := SignalContext()
+		defer cancel()
+		return Run(ctx)
+	},
+}
+
+// Run serves the api until ctx is cancelled, then stops taking new requests
+// and waits up to api.shutdown.timeout for the running ones.
+func Run(ctx context.Context) error {
+	cluster, err := db.ConnectChecked(ctx, "api")
+	if err != nil {
+		return err
+	}
+	defer cluster.Close()
+
+	natsAddress, err := secrets.Get(ctx, "api.nats.address")
+	if err != nil {
+		return err
+	}
+	natsConn, err := nats.Connect(natsAddress)
+	if err != nil {
+		return err
+	}
+	defer natsConn.Close()
+
+	r := gin.New()
+	r.Use(middlewares.RequestID(), gin.LoggerWithFormatter(middlewares.LogFormatter), gin.Recovery())
+	if cluster.ReadOnly {
+		r.Use(middlewares.ReadOnly())
+	}
+	err = r.SetTrustedProxies(viper.GetStringSlice("api.trustedproxies"))
+	if err != nil {
+		return err
+	}
+	// imports are uploads, they get their own limit in every version
+	uploads := map[string]int64{"/admin/import": viper.GetInt64("api.limits.import")}
+	for n := 1; n <= controllers.LatestVersion; n++ {
+		uploads[fmt.Sprintf("/v%d/admin/import", n)] = viper.GetInt64("api.limits.import")
+	}
+	r.Use(
+		middlewares.SecurityHeaders(viper.GetDuration("api.security.hsts")),
+		middlewares.CORS(middlewares.CORSConfigFromViper()),
+		middlewares.BodyLimits(viper.GetInt64("api.limits.body"), uploads),
+	)
+
+	// Add health check endpoint
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"status":  "healthy",
+			"service": "sms-api",
+		})
+	})
+	checker := health.New()
+	checker.Add("postgres", func(ctx context.Context) error {
+		return cluster.Writer().Ping(ctx)
+	})
+	checker.Add("nats", health.NATS(natsConn))
+	r.GET("/healthz", gin.WrapF(checker.LiveHandler))
+	r.GET("/readyz", gin.WrapF(checker.ReadyHandler))
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	root := r.Group("/")
+	limits := middlewares.NewMemoryStore()
+	root.Use(middlewares.RateLimit(limits,
+		viper.GetInt("api.ratelimit.ip.limit"),
+		viper.GetDuration("api.ratelimit.ip.window"),
+		middlewares.ByIP,
+	))
+	signer, err := auth.SignerFromViper(ctx)
+	if err != nil {
+		return err
+	}
+	if viper.GetBool("api.auth.enabled") {
+		verify, err := auth.ParseVerifyMode(viper.GetString("api.auth.tokens.verify"))
 		if err != nil {
 			return err
 		}
-		err = pool.Ping(context.Background())
+		if verify == auth.VerifyOffline {
+			if signer == nil {
+				return fmt.Errorf("api.auth.tokens.verify %s needs api.auth.tokens.signing keys", auth.VerifyOffline)
+			}
+			tokens := middlewares.NewTokens(signer, controllers.DisabledTokens(cluster))
+			go tokens.Run(ctx, viper.GetDuration("api.auth.tokens.revocations.interval"))
+			root.Use(tokens.Verify)
+		}
+		lastUsed := middlewares.NewLastUsed(controllers.TouchKeys(cluster))
+		go lastUsed.Run(ctx, viper.GetDuration("api.auth.tokens.lastused.interval"))
+		root.Use(
+			middlewares.Authenticate(controllers.LookupApiKey(cluster)),
+			lastUsed.Track,
+			middlewares.RestrictOrigin,
+			middlewares.RateLimitKeys(limits,
+				viper.GetInt("api.ratelimit.key.limit"),
+				viper.GetDuration("api.ratelimit.key.window"),
+			),
+		)
+	}
+	api := controllers.NewVersions(root)
+	if viper.GetBool("api.versions.legacy.enabled") {
+		api.WithLegacy(root, middlewares.Deprecation{
+			At:     controllers.LegacyDeprecatedAt,
+			Sunset: viper.GetTime("api.versions.legacy.sunset"),
+			Link:   viper.GetString("api.versions.legacy.link"),
+		})
+	}
+	UserController = controllers.NewUser(api, cluster)
+	ResellerController = controllers.NewReseller(api, cluster)
+	PhoneNumberController = controllers.NewPhoneNumber(api, cluster)
+	PoolController = controllers.NewPhoneNumberPool(api, cluster)
+	AlertController = controllers.NewAlert(api, cluster)
+	QuietHoursController = controllers.NewQuietHours(api, cluster)
+	BlocklistController = controllers.NewBlocklist(api, cluster)
+	InvoiceController = controllers.NewInvoice(api, cluster)
+	SmsController, err = controllers.NewSms(api, cluster, natsConn)
+	if err != nil {
+		return err
+	}
+
+	provider, err := hlr.New(viper.GetString("hlr.provider"))
+	if err != nil {
+		return err
+	}
+	lookup := hlr.NewCache(provider, viper.GetDuration("hlr.cache.ttl"))
+	LookupController = controllers.NewLookup(api, lookup)
+	SmsController.Lookup = lookup
+	if viper.GetBool("api.compat.twilio.enabled") {
+		TwilioController = controllers.NewTwilio(root, SmsController, cluster)
+	}
+
+	depth := streams.NewDepth(SmsController.Streams())
+	SmsController.Depth = depth
+	if interval := viper.GetDuration("metrics.queuedepth.interval"); interval > 0 {
+		go depth.Run(ctx, interval)
+	}
+	r.GET("/queue/depth", gin.WrapF(depth.Handler))
+
+	prober := health.NewProber(viper.GetDuration("status.probes.timeout"), viper.GetInt("status.probes.history"))
+	prober.Add("hlr", func(ctx context.Context) error {
+		_, err := provider.Lookup(ctx, viper.GetString("status.probes.hlr.number"))
+		return err
+	})
+	probeClient := &http.Client{Timeout: viper.GetDuration("status.probes.timeout")}
+	for name, url := range viper.GetStringMapString("status.probes.http") {
+		prober.Add(name, health.HTTP(probeClient, url))
+	}
+	if interval := viper.GetDuration("status.probes.interval"); interval > 0 {
+		go prober.Start(ctx, interval)
+	}
+	r.GET("/status", controllers.NewStatus(prober, depth).GetStatus)
+	CoverageController, err = controllers.NewCoverage(api, cluster, prober)
+	if err != nil {
+		return err
+	}
+
+	callbackSecrets := make(map[string]string)
+	for name := range viper.GetStringMap("callbacks.providers") {
+		callbackSecrets[name], err = secrets.Get(ctx, "callbacks.providers."+name+".secret")
 		if err != nil {
 			return err
 		}
+	}
+	callbacks := r.Group("/", middlewares.RateLimit(limits,
+		viper.GetInt("api.ratelimit.ip.limit"),
+		viper.GetDuration("api.ratelimit.ip.window"),
+		middlewares.ByIP,
+	))
+	CallbackController, err = controllers.NewCallback(callbacks, cluster, natsConn, callbackSecrets)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := secrets.EnvelopeFromViper(ctx)
+	if err != nil {
+		return err
+	}
+	AdminController, err = controllers.NewAdmin(api, cluster, natsConn, envelope)
+	if err != nil {
+		return err
+	}
+	ForwardController = controllers.NewForward(api, cluster, envelope)
+	ApiKeyController = controllers.NewApiKey(api, cluster)
+	TokenController = controllers.NewToken(api, cluster, signer)
+	store, err := storage.FromViper(ctx)
+	if err != nil {
+		return err
+	}
+	JobController, err = controllers.NewJob(api, cluster, natsConn, store)
+	if err != nil {
+		return err
+	}
+	WebhookController, err = controllers.NewWebhook(api, cluster, natsConn)
+	if err != nil {
+		return err
+	}
 
-		natsConn, err := nats.Connect(viper.GetString("api.nats.address"))
+	// the heartbeats of the workers, listed at /admin/workers
+	heartbeatStore := sqlc.New(cluster.Writer())
+	heartbeatSub, err := heartbeats.Record(ctx, natsConn, heartbeatStore)
+	if err != nil {
+		return err
+	}
+	defer heartbeatSub.Unsubscribe()
+	go heartbeats.Prune(ctx, heartbeatStore, viper.GetDuration("api.workers.retention"), viper.GetDuration("api.workers.prune.interval"))
+
+	var plain http.Handler = r
+	if viper.GetBool("api.h2c") {
+		plain = h2c.NewHandler(r, &http2.Server{})
+	}
+	tlsConfig, plain, err := serverTLS(ctx, plain)
+	if err != nil {
+		return err
+	}
+	servers := []*http.Server{newServer(viper.GetString("api.listen"), plain)}
+	errc := make(chan error, 4)
+	go func() {
+		errc <- servers[0].ListenAndServe()
+	}()
+	if tlsConfig != nil {
+		srv := newServer(viper.GetString("api.tls.listen"), r)
+		srv.TLSConfig = tlsConfig
+		servers = append(servers, srv)
+		go func() {
+			errc <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+	if listen := viper.GetString("api.mqtt.listen"); listen != "" {
+		l, err := mqttListener(listen)
+		if err != nil {
+			return err
+		}
+		// devices' sends are served by the latest version of POST /sms
+		handler := ingest.NewMQTT(cluster, r, fmt.Sprintf("/v%d/sms", controllers.LatestVersion))
+		server := mqtt.NewServer(handler, viper.GetInt("api.mqtt.maxpacket"))
+		go func() {
+			if err := server.Serve(ctx, l); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	if listen := viper.GetString("api.smtp.listen"); listen != "" {
+		domain := viper.GetString("api.smtp.domain")
+		if domain == "" {
+			return fmt.Errorf("api.smtp.domain is required to receive mail")
+		}
+		cfg := smtpd.Config{
+			Hostname: viper.GetString("api.smtp.hostname"),
+			MaxSize:  viper.GetInt64("api.smtp.maxsize"),
+		}
+		if cert, key := viper.GetString("api.smtp.tls.cert"), viper.GetString("api.smtp.tls.key"); cert != "" || key != "" {
+			pair, err := tls.LoadX509KeyPair(cert, key)
+			if err != nil {
+				return err
+			}
+			cfg.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+		}
+		l, err := net.Listen("tcp", listen)
 		if err != nil {
 			return err
 		}
+		handler := ingest.NewEmail(ingest.EmailConfig{
+			Domain:    domain,
+			Subject:   viper.GetBool("api.smtp.subject"),
+			MaxLength: viper.GetInt("api.smtp.maxlength"),
+		}, cluster, controllers.LookupApiKey(cluster), r, fmt.Sprintf("/v%d/sms", controllers.LatestVersion))
+		server := smtpd.NewServer(cfg, handler)
+		go func() {
+			if err := server.Serve(ctx, l); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
 
-		r := gin.Default()
+	// fail readiness first so the endpoint is taken out of the service before we stop listening
+	checker.Drain()
+	logrus.Infof("shutting down api, draining for %s", viper.GetDuration("api.shutdown.delay"))
+	time.Sleep(viper.GetDuration("api.shutdown.delay"))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("api.shutdown.timeout"))
+	defer cancel()
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(shutdownCtx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-		// Add health check endpoint
-		r.GET("/health", func(c *gin.Context) {
-			c.JSON(200, gin.H{
-				"status":  "healthy",
-				"service": "sms-api",
-			})
-		})
+// newServer serves handler on addr with the limits of api.server.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: viper.GetDuration("api.server.readheadertimeout"),
+		ReadTimeout:       viper.GetDuration("api.server.readtimeout"),
+		WriteTimeout:      viper.GetDuration("api.server.writetimeout"),
+		IdleTimeout:       viper.GetDuration("api.server.idletimeout"),
+		MaxHeaderBytes:    viper.GetInt("api.server.maxheaderbytes"),
+	}
+}
 
-		root := r.Group("/")
-		UserController = controllers.NewUser(root, pool)
-		PhoneNumberController = controllers.NewPhoneNumber(root, pool)
-		SmsController, err = controllers.NewSms(root, pool, natsConn)
+// serverTLS is the tls config of api.tls.listen, nil when it is not set. the
+// certificate is read from api.tls.cert and key, reloaded when they change, or
+// obtained from acme for api.tls.acme.domains. with acme, plain is wrapped to
+// answer its http-01 challenges.
+func serverTLS(ctx context.Context, plain http.Handler) (*tls.Config, http.Handler, error) {
+	if viper.GetString("api.tls.listen") == "" {
+		return nil, plain, nil
+	}
+	cert, key := viper.GetString("api.tls.cert"), viper.GetString("api.tls.key")
+	domains := viper.GetStringSlice("api.tls.acme.domains")
+	switch {
+	case len(domains) > 0 && (cert != "" || key != ""):
+		return nil, nil, errors.New("api.tls.acme.domains and api.tls.cert are exclusive")
+	case len(domains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(viper.GetString("api.tls.acme.cache")),
+			Email:      viper.GetString("api.tls.acme.email"),
+		}
+		if url := viper.GetString("api.tls.acme.directory"); url != "" {
+			m.Client = &acme.Client{DirectoryURL: url}
+		}
+		config := certs.Config(m.GetCertificate)
+		// answers tls-alpn-01 challenges on the tls listener itself
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+		return config, m.HTTPHandler(plain), nil
+	default:
+		reloader, err := certs.NewReloader(cert, key)
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		if interval := viper.GetDuration("api.tls.reload"); interval > 0 {
+			go reloader.Run(ctx, interval)
 		}
+		return certs.Config(reloader.GetCertificate), plain, nil
+	}
+}
 
-		return r.Run(viper.GetString("api.listen"))
-	},
+// mqttListener listens on addr, with TLS when api.mqtt.tls.cert and key are set.
+func mqttListener(addr string) (net.Listener, error) {
+	cert, key := viper.GetString("api.mqtt.tls.cert"), viper.GetString("api.mqtt.tls.key")
+	if cert == "" && key == "" {
+		return net.Listen("tcp", addr)
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	})
 }
 
 func init() {
 	RootCmd.AddCommand(ApiCmd)
 
 	viper.SetDefault("api.sms.cost", 5)
+	viper.SetDefault("api.sms.workers", 1)
+	viper.SetDefault("api.phonenumbers.unique", "global")
+	viper.SetDefault("sms.normal.ratelimit", 1000)
+	viper.SetDefault("api.auth.enabled", false)
+	viper.SetDefault("api.auth.tokens.verify", auth.VerifyOnline)
+	viper.SetDefault("api.auth.tokens.defaultttl", "720h")
+	viper.SetDefault("api.auth.tokens.maxttl", "8760h")
+	viper.SetDefault("api.auth.tokens.rotation.grace", "1h")
+	viper.SetDefault("api.auth.tokens.lastused.interval", "1m")
+	viper.SetDefault("api.auth.tokens.revocations.interval", "30s")
+	viper.SetDefault("api.workers.timeout", "1m")
+	viper.SetDefault("api.workers.retention", "24h")
+	viper.SetDefault("api.workers.prune.interval", "10m")
+	viper.SetDefault("api.trustedproxies", []string{})
+	viper.SetDefault("api.versions.legacy.enabled", true)
+	viper.SetDefault("api.compat.twilio.enabled", false)
+	viper.SetDefault("events.stream.enabled", false)
+	viper.SetDefault("api.express.admission.policy", "off")
+	viper.SetDefault("api.express.admission.backlog", 0)
+	viper.SetDefault("api.express.admission.latency", "0s")
+	viper.SetDefault("events.stream.maxage", "72h")
+	viper.SetDefault("api.cors.allowedorigins", []string{})
+	viper.SetDefault("api.cors.allowedmethods", []string{"GET", "POST", "PUT", "DELETE"})
+	viper.SetDefault("api.cors.allowedheaders", []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID"})
+	viper.SetDefault("api.cors.exposedheaders", []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "API-Version", "Deprecation", "Sunset", "Link", "X-Request-ID", "X-Low-Balance"})
+	viper.SetDefault("api.cors.maxage", "10m")
+	viper.SetDefault("api.ratelimit.ip.limit", 600)
+	viper.SetDefault("api.ratelimit.ip.window", "1m")
+	viper.SetDefault("api.ratelimit.key.limit", 300)
+	viper.SetDefault("api.ratelimit.key.window", "1m")
+	viper.SetDefault("api.limits.body", 1<<20)
+	viper.SetDefault("api.limits.import", 256<<20)
+	viper.SetDefault("api.flood.limit", 20)
+	viper.SetDefault("api.postgres.schema.mismatch", "refuse")
+	viper.SetDefault("api.h2c", false)
+	viper.SetDefault("api.tls.reload", "1m")
+	viper.SetDefault("api.tls.acme.cache", "/var/lib/sms/acme")
+	viper.SetDefault("api.server.readheadertimeout", "5s")
+	viper.SetDefault("api.server.readtimeout", "15s")
+	viper.SetDefault("api.server.writetimeout", "30s")
+	viper.SetDefault("api.server.idletimeout", "2m")
+	viper.SetDefault("api.server.maxheaderbytes", 1<<16)
+	viper.SetDefault("api.shutdown.delay", "0s")
+	viper.SetDefault("api.shutdown.timeout", "25s")
+	viper.SetDefault("metrics.queuedepth.interval", "15s")
+	viper.SetDefault("status.probes.interval", "30s")
+	viper.SetDefault("status.probes.timeout", "5s")
+	viper.SetDefault("status.probes.history", 120)
+	viper.SetDefault("status.probes.hlr.number", "+15550000001")
+	viper.SetDefault("status.probes.http", map[string]string{})
+	viper.SetDefault("slo.normal.latency", "5m")
+	viper.SetDefault("slo.normal.target", 0.99)
+	viper.SetDefault("slo.express.latency", "30s")
+	viper.SetDefault("slo.express.target", 0.99)
+	viper.SetDefault("slo.windows", []string{"1h", "6h", "24h"})
+	viper.SetDefault("api.postgres.replica.healthcheck", "5s")
+	viper.SetDefault("sms.validity.max", "72h")
+	viper.SetDefault("sms.dedupe.bucket", "sms_dedupe")
+	viper.SetDefault("sms.ids.generator", "uuidv7")
+	viper.SetDefault("email.cost", "0")
+	viper.SetDefault("push.cost", "0")
+	viper.SetDefault("voice.cost", "0")
+	viper.SetDefault("whatsapp.cost", "0")
+	viper.SetDefault("telegram.cost", "0")
+	viper.SetDefault("sms.footer.normal", true)
+	viper.SetDefault("sms.footer.express", true)
+	viper.SetDefault("hlr.provider", "simulator")
+	viper.SetDefault("hlr.cache.ttl", "24h")
+	viper.SetDefault("storage.local.dir", "storage")
+	viper.SetDefault("jobs.export.linkttl", "1h")
+	viper.SetDefault("webhooks.rotation.grace", "24h")
+	viper.SetDefault("callbacks.tolerance", "5m")
+	viper.SetDefault("api.balance.lowthreshold", 50)
+	viper.SetDefault("api.mqtt.listen", "")
+	viper.SetDefault("api.mqtt.maxpacket", 64<<10)
+	viper.SetDefault("api.smtp.listen", "")
+	viper.SetDefault("api.smtp.maxsize", 1<<20)
+	viper.SetDefault("api.smtp.subject", true)
+	viper.SetDefault("api.smtp.maxlength", 255)
 }
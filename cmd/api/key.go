@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/spf13/cobra"
+)
+
+// KeyCmd creates API keys from the command line, e.g. the first user:admin key
+// before any key exists to call POST /admin/keys with.
+var KeyCmd = &cobra.Command{
+	Use:   "key <username>",
+	Short: "creates an API key for a user and prints it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		scopes, _ := cmd.Flags().GetStringSlice("scopes")
+		cidrs, _ := cmd.Flags().GetStringSlice("allowed-cidrs")
+
+		ctx := context.Background()
+		cluster, err := db.Connect(ctx, "api")
+		if err != nil {
+			return err
+		}
+		defer cluster.Close()
+
+		q := sqlc.New(cluster.Writer())
+		userId, err := q.GetUserId(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to find user %q: %w", args[0], err)
+		}
+		key, _, err := controllers.CreateKey(ctx, q, userId, name, scopes, cidrs)
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	},
+}
+
+func init() {
+	ApiCmd.AddCommand(KeyCmd)
+
+	KeyCmd.Flags().String("name", "cli", "name to recognize the key by")
+	KeyCmd.Flags().StringSlice("scopes", []string{auth.ScopeUserAdmin}, "scopes to grant, one of "+strings.Join(auth.Scopes, ", "))
+	KeyCmd.Flags().StringSlice("allowed-cidrs", nil, "client address ranges the key is restricted to")
+}
@@ -0,0 +1,74 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/apidocs"
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/spf13/cobra"
+)
+
+// the formats export writes
+const (
+	FormatPostman = "postman"
+	FormatHTTPie  = "httpie"
+)
+
+// ApidocsCmd groups the commands about the documentation of the api.
+var ApidocsCmd = &cobra.Command{
+	Use:   "apidocs",
+	Short: "documentation of the api routes",
+}
+
+// ExportCmd writes the routes of a version of the api as a collection ready to
+// import: a Postman collection or a shell script of HTTPie calls, to stdout or
+// --out.
+var ExportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "writes the api routes as a Postman collection or HTTPie script",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		out, _ := cmd.Flags().GetString("out")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		version, _ := cmd.Flags().GetInt("version")
+		if version < 1 || version > controllers.LatestVersion {
+			return fmt.Errorf("no api version %d, the latest is %d", version, controllers.LatestVersion)
+		}
+
+		var w io.Writer = os.Stdout
+		if out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		switch format {
+		case FormatPostman:
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(apidocs.Postman(apidocs.Routes, version, controllers.LatestVersion, baseURL))
+		case FormatHTTPie:
+			return apidocs.HTTPie(w, apidocs.Routes, version, controllers.LatestVersion, baseURL)
+		default:
+			return fmt.Errorf("unknown format %q, want %s or %s", format, FormatPostman, FormatHTTPie)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ApidocsCmd)
+	ApidocsCmd.AddCommand(ExportCmd)
+
+	ExportCmd.Flags().String("format", FormatPostman, "postman or httpie")
+	ExportCmd.Flags().StringP("out", "o", "", "file to write the collection to, stdout when empty")
+	ExportCmd.Flags().String("base-url", "http://localhost:8080", "url of the api the requests are sent to")
+	ExportCmd.Flags().Int("version", controllers.LatestVersion, "version of the api to export")
+}
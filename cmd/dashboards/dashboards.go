@@ -0,0 +1,71 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/dashboards"
+	"github.com/spf13/cobra"
+)
+
+// DashboardsCmd groups the commands about the Grafana dashboards of the gateway.
+var DashboardsCmd = &cobra.Command{
+	Use:   "dashboards",
+	Short: "Grafana dashboards of the gateway metrics",
+}
+
+// ExportCmd writes the dashboards as JSON ready to import in Grafana, one file
+// per dashboard in --out or all of them to stdout.
+var ExportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "writes the Grafana dashboards as JSON",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+
+		all := dashboards.All()
+		files := make([]string, 0, len(all))
+		for file := range all {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		if out == "" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			for _, file := range files {
+				err := enc.Encode(all[file])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		err := os.MkdirAll(out, 0o755)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			b, err := json.MarshalIndent(all[file], "", "  ")
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(filepath.Join(out, file), append(b, '\n'), 0o644)
+			if err != nil {
+				return err
+			}
+			cmd.Println(filepath.Join(out, file))
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(DashboardsCmd)
+	DashboardsCmd.AddCommand(ExportCmd)
+
+	ExportCmd.Flags().StringP("out", "o", "", "directory to write the dashboards to, stdout when empty")
+}
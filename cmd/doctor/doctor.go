@@ -0,0 +1,195 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/doctor"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/internal/workers"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// DoctorCmd checks the config of the gateway and the dependencies it needs, the
+// way the api and the workers see them, and prints a pass/fail report. it exits
+// with an error when a check failed, so it can gate a deployment.
+var DoctorCmd = &cobra.Command{
+	Use:          "doctor",
+	Short:        "checks the config, postgres, nats and the sms providers",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		ctx, cancel := SignalContext()
+		defer cancel()
+
+		report := &doctor.Report{Timeout: timeout}
+		run(ctx, report)
+		err := report.Print(os.Stdout)
+		if err != nil {
+			return err
+		}
+		if n := report.Count(doctor.Fail); n > 0 {
+			return fmt.Errorf("%d checks failed", n)
+		}
+		return nil
+	},
+}
+
+var sections = []string{"api", "worker"}
+
+func run(ctx context.Context, report *doctor.Report) {
+	var mode nats.ReconcileMode
+	var policies workers.AckPolicies
+	report.Run(ctx, "config", func(ctx context.Context) error {
+		var err, ackErr error
+		mode, err = nats.ParseReconcileMode(viper.GetString("nats.reconcile"))
+		_, txErr := db.TxOptionsFromViper("worker")
+		policies, ackErr = workers.AckPoliciesFromViper()
+		_, auditErr := workers.AuditStreams(false)
+		_, admissionErr := policy.ExpressAdmissionFromViper()
+		errs := []error{err, txErr, ackErr, auditErr, admissionErr, workers.CheckPricing()}
+		for _, section := range sections {
+			_, err := db.ParseSchemaMode(viper.GetString(section + ".postgres.schema.mismatch"))
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+
+	clusters := make(map[string]*db.Cluster)
+	for _, section := range sections {
+		report.Run(ctx, "postgres ("+section+")", func(ctx context.Context) error {
+			cluster, err := db.Connect(ctx, section)
+			if err != nil {
+				return err
+			}
+			err = cluster.Writer().Ping(ctx)
+			if err != nil {
+				cluster.Close()
+				return err
+			}
+			clusters[section] = cluster
+			return nil
+		})
+	}
+	defer func() {
+		for _, cluster := range clusters {
+			cluster.Close()
+		}
+	}()
+	if cluster, ok := clusters["worker"]; ok {
+		report.Run(ctx, "schema version", func(ctx context.Context) error {
+			return doctor.CheckSchema(ctx, sqlc.New(cluster.Writer()), db.SchemaVersion)
+		})
+	} else {
+		report.Skip("schema version", "no connection to postgres")
+	}
+
+	conns := make(map[string]*natsgo.Conn)
+	for _, section := range sections {
+		report.Run(ctx, "nats ("+section+")", func(ctx context.Context) error {
+			address, err := secrets.Get(ctx, section+".nats.address")
+			if err != nil {
+				return err
+			}
+			nc, err := nats.Connect(address)
+			if err != nil {
+				return err
+			}
+			conns[section] = nc
+			return nil
+		})
+	}
+	defer func() {
+		for _, nc := range conns {
+			nc.Close()
+		}
+	}()
+
+	// drivers connecting to their carrier, like ucp, stay connected until the
+	// checks are done
+	pctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var router *carrier.Router
+	var envelope *secrets.Envelope
+	providersErr := report.Run(ctx, "providers config", func(ctx context.Context) error {
+		var err error
+		router, err = carrier.FromViper(pctx)
+		if err != nil {
+			return err
+		}
+		envelope, err = secrets.EnvelopeFromViper(ctx)
+		return err
+	})
+	submit := router != nil || envelope != nil
+
+	if nc, ok := conns["worker"]; ok {
+		checkStreams(ctx, report, nc, submit, mode, policies)
+	} else {
+		report.Skip("streams", "no connection to nats")
+	}
+
+	switch {
+	case providersErr != nil:
+		report.Skip("providers", "invalid config")
+	case router == nil:
+		report.Skip("providers", "sms.provider.driver is none")
+	default:
+		for name, c := range router.Checkers() {
+			report.Run(ctx, "provider "+name, c.Check)
+		}
+	}
+}
+
+// checkStreams checks the streams and consumers of the sms worker.
+func checkStreams(ctx context.Context, report *doctor.Report, nc *natsgo.Conn, submit bool, mode nats.ReconcileMode, policies workers.AckPolicies) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		report.Run(ctx, "jetstream", func(ctx context.Context) error { return err })
+		return
+	}
+	checked := make(map[string]bool)
+	checkStream := func(want jetstream.StreamConfig) {
+		if checked[want.Name] {
+			return
+		}
+		checked[want.Name] = true
+		report.Run(ctx, "stream "+want.Name, func(ctx context.Context) error {
+			return doctor.CheckStream(ctx, js, want, mode)
+		})
+	}
+	for _, want := range workers.SmsStreams(submit) {
+		checkStream(want)
+	}
+	for _, config := range workers.SmsConsumers(submit, policies) {
+		checkStream(config.Stream)
+		for _, want := range config.Consumers {
+			report.Run(ctx, "consumer "+config.Stream.Name+"/"+want.Name, func(ctx context.Context) error {
+				return doctor.CheckConsumer(ctx, js, config.Stream.Name, want, mode)
+			})
+		}
+	}
+	// an invalid audit config fails the config check
+	audit, _ := workers.AuditStreams(submit)
+	for _, want := range audit {
+		checkStream(want)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(DoctorCmd)
+
+	DoctorCmd.Flags().Duration("timeout", 10*time.Second, "how long every check may take")
+}
@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/cobra"
+)
+
+// ReplayCmd publishes the messages the audit stream kept of a work queue again,
+// for recovering from a deploy that mishandled them. the workers skip those they
+// already processed, so nobody is charged twice.
+var ReplayCmd = &cobra.Command{
+	Use:          "replay",
+	Short:        "republishes the audited messages of a work queue in a time range",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stream, _ := cmd.Flags().GetString("stream")
+		fromFlag, _ := cmd.Flags().GetString("from")
+		toFlag, _ := cmd.Flags().GetString("to")
+
+		_, err := streams.ReplaySubjects(stream)
+		if err != nil {
+			return err
+		}
+		from, err := parseTime(fromFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+		var to time.Time
+		if toFlag != "" {
+			to, err = parseTime(toFlag)
+			if err != nil {
+				return fmt.Errorf("invalid --to: %w", err)
+			}
+			if to.Before(from) {
+				return errors.New("--to is before --from")
+			}
+		}
+
+		ctx, cancel := SignalContext()
+		defer cancel()
+
+		natsAddress, err := secrets.Get(ctx, "api.nats.address")
+		if err != nil {
+			return err
+		}
+		nc, err := nats.Connect(natsAddress)
+		if err != nil {
+			return err
+		}
+		defer nc.Close()
+		js, err := jetstream.New(nc)
+		if err != nil {
+			return err
+		}
+
+		id := events.NewMessageID()
+		n, err := streams.Replay(ctx, js, streams.ReplayParams{ID: id, Stream: stream, From: from, To: to})
+		cmd.Printf("replay %s: %d messages of %s republished\n", id, n, stream)
+		return err
+	},
+}
+
+// timeLayouts are the layouts --from and --to are parsed with, in UTC unless
+// they carry a zone.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time like 2024-05-01T00:00", s)
+}
+
+func init() {
+	RootCmd.AddCommand(ReplayCmd)
+
+	ReplayCmd.Flags().String("stream", "", "work queue to replay: "+streams.NORMAL_SMS_CONSUMER_NAME+", "+streams.EXPRESS_SMS_CONSUMER_NAME+" or "+streams.SUBMIT_CONSUMER_NAME)
+	ReplayCmd.Flags().String("from", "", "replay the messages stored since, e.g. 2024-05-01T00:00")
+	ReplayCmd.Flags().String("to", "", "replay the messages stored until, now when empty")
+	ReplayCmd.MarkFlagRequired("stream")
+	ReplayCmd.MarkFlagRequired("from")
+}
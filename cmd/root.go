@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -20,6 +25,11 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// SignalContext is cancelled on SIGINT and on the SIGTERM Kubernetes sends before killing a pod.
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -30,13 +40,26 @@ func Execute() {
 }
 
 func init() {
+	RootCmd.PersistentFlags().String("reconcile", "apply", "what to do when a NATS stream or consumer drifted from its config: apply or refuse")
+	viper.BindPFlag("nats.reconcile", RootCmd.PersistentFlags().Lookup("reconcile"))
+
+	// every key can be set from the environment, e.g. SMS_API_NATS_ADDRESS for api.nats.address
+	viper.SetEnvPrefix("SMS")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
 	viper.SetConfigName("SmsGW")
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("$HOME/.config")
 	err := viper.ReadInConfig()
-	if err != nil {
+	var notFound viper.ConfigFileNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		logrus.Info("no config file found, using defaults and environment")
+	case err != nil:
 		logrus.Errorf("viper failed to read config: %s", err)
 		os.Exit(1)
+	default:
+		logrus.Info("config file read")
 	}
-	logrus.Info("config file read")
 }
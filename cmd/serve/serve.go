@@ -0,0 +1,71 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/cmd/api"
+	"github.com/alireza-karampour/sms/cmd/worker"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	ModeApi    = "api"
+	ModeWorker = "worker"
+	ModeAll    = "all"
+)
+
+// ServeCmd represents the serve command
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "runs the api, the worker or both in one process",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := SignalContext()
+		defer cancel()
+		switch mode := viper.GetString("serve.mode"); mode {
+		case ModeApi:
+			return api.Run(ctx)
+		case ModeWorker:
+			return worker.Run(ctx)
+		case ModeAll:
+			return runAll(ctx)
+		default:
+			return fmt.Errorf("unknown serve mode %q, want %s, %s or %s", mode, ModeApi, ModeWorker, ModeAll)
+		}
+	},
+}
+
+// runAll runs the api and the worker side by side. when either of them
+// stops the other one is shut down too, and the first error is returned.
+func runAll(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 2)
+	run := func(name string, fn func(context.Context) error) {
+		err := fn(ctx)
+		if err != nil {
+			logrus.Errorf("%s stopped: %s", name, err)
+		}
+		cancel()
+		errc <- err
+	}
+	go run(ModeApi, api.Run)
+	go run(ModeWorker, worker.Run)
+
+	var first error
+	for range 2 {
+		if err := <-errc; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func init() {
+	RootCmd.AddCommand(ServeCmd)
+	ServeCmd.Flags().String("mode", ModeAll, "what to run: api, worker or all")
+	viper.BindPFlag("serve.mode", ServeCmd.Flags().Lookup("mode"))
+}
@@ -0,0 +1,94 @@
+package tail
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/tail"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// TailCmd prints the sms requests, status updates, errors and lifecycle events
+// going through NATS as they happen, for debugging production traffic. it only
+// listens, so it neither takes messages from the workers nor slows them down.
+var TailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "prints a live feed of the sms traffic",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		users, _ := cmd.Flags().GetInt32Slice("user")
+		priorities, _ := cmd.Flags().GetStringSlice("priority")
+		statuses, _ := cmd.Flags().GetStringSlice("status")
+		noColor, _ := cmd.Flags().GetBool("no-color")
+
+		ctx, cancel := SignalContext()
+		defer cancel()
+
+		filter := &tail.Filter{Users: users, Priorities: priorities, Statuses: statuses}
+		if len(users) > 0 || len(priorities) > 0 {
+			// status updates and events only carry the id of their sms
+			cluster, err := db.Connect(ctx, "api")
+			if err != nil {
+				return err
+			}
+			defer cluster.Close()
+			filter.Owners = sqlc.New(cluster.Reader())
+		}
+
+		natsAddress, err := secrets.Get(ctx, "api.nats.address")
+		if err != nil {
+			return err
+		}
+		nc, err := nats.Connect(natsAddress)
+		if err != nil {
+			return err
+		}
+		defer nc.Close()
+
+		msgs := make(chan *natsgo.Msg, 1024)
+		for _, subject := range tail.Subjects() {
+			sub, err := nc.ChanSubscribe(subject, msgs)
+			if err != nil {
+				return err
+			}
+			defer sub.Unsubscribe()
+		}
+
+		color := !noColor && isTerminal(os.Stdout)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-msgs:
+				e, err := tail.Parse(msg)
+				if err != nil {
+					logrus.Warnf("failed to parse message on %s: %s", msg.Subject, err)
+					continue
+				}
+				if filter.Match(ctx, &e) {
+					fmt.Println(tail.Format(e, color))
+				}
+			}
+		}
+	},
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	RootCmd.AddCommand(TailCmd)
+
+	TailCmd.Flags().Int32Slice("user", nil, "only show the traffic of these user ids")
+	TailCmd.Flags().StringSlice("priority", nil, "only show these priorities: normal or express")
+	TailCmd.Flags().StringSlice("status", nil, "only show these statuses and events, e.g. failed,delivered")
+	TailCmd.Flags().Bool("no-color", false, "don't color the feed even on a terminal")
+}
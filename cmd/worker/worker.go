@@ -2,64 +2,514 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"os/signal"
+	"net/http"
+	"time"
 
 	. "github.com/alireza-karampour/sms/cmd"
+	"github.com/alireza-karampour/sms/internal/bridge"
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/eventsink"
+	"github.com/alireza-karampour/sms/internal/heartbeats"
+	"github.com/alireza-karampour/sms/internal/providers"
+	"github.com/alireza-karampour/sms/internal/streams"
 	"github.com/alireza-karampour/sms/internal/workers"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/chat"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/email"
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/push"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/pkg/voice"
+	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	Worker *workers.Sms
+	Worker        *workers.Sms
+	ExportWorker  *workers.Export
+	ImportWorker  *workers.Import
+	NotifyWorker  *workers.Notify
+	WebhookWorker *workers.Webhook
+	Forwarder     *workers.Forwarder
+	Invoicer      *workers.Invoicer
+	FraudDetector *workers.Fraud
+	Alerter       *workers.Alerter
+	Archiver      *workers.Archiver
+	EventSink     *workers.EventSink
+	KafkaBridge   *workers.KafkaBridge
 )
 
 // WorkerCmd represents the worker command
 var WorkerCmd = &cobra.Command{
 	Use:   "worker",
 	Short: "starts worker node for sms request handling",
-	RunE: func(cmd *cobra.Command, args []string) (err error) {
-		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := SignalContext()
 		defer cancel()
-		logrus.SetLevel(logrus.DebugLevel)
-		logrus.SetFormatter(&logrus.TextFormatter{
-			ForceColors:            true,
-			DisableLevelTruncation: true,
-		})
-		pool, err := pgxpool.New(context.Background(), fmt.Sprintf("postgresql://%s:%s@%s:%d/postgres?sslmode=disable",
-			viper.GetString("worker.postgres.username"),
-			viper.GetString("worker.postgres.password"),
-			viper.GetString("worker.postgres.address"),
-			viper.GetInt("worker.postgres.port"),
-		))
+		return Run(ctx)
+	},
+}
+
+// Run starts the workers and blocks until ctx is cancelled, then drains the
+// consumers for up to worker.shutdown.timeout.
+func Run(ctx context.Context) (err error) {
+	logrus.SetLevel(logrus.DebugLevel)
+	logrus.SetFormatter(&logrus.TextFormatter{
+		ForceColors:            true,
+		DisableLevelTruncation: true,
+	})
+	txOpts, err := db.TxOptionsFromViper("worker")
+	if err != nil {
+		return err
+	}
+	db.SetTxOptions(txOpts)
+	// the worker writes with every message it handles, it has nothing to do read only
+	if mode, _ := db.ParseSchemaMode(viper.GetString("worker.postgres.schema.mismatch")); mode == db.SchemaReadOnly {
+		return fmt.Errorf("worker.postgres.schema.mismatch can't be %s, use %s or %s", mode, db.SchemaRefuse, db.SchemaIgnore)
+	}
+
+	// the worker answers probes while the preflight retries, unready until it passed
+	preflight := workers.NewPreflight(workers.PreflightConfigFromViper())
+	preflightEnabled := viper.GetBool("worker.preflight.enabled")
+	checker := health.New()
+	if preflightEnabled {
+		checker.Add("preflight", preflight.Ready)
+	}
+	metrics.OrgLabels = viper.GetBool("metrics.labels.org")
+	mux := metrics.NewServeMux()
+	checker.Register(mux)
+	if addr := viper.GetString("worker.metrics.listen"); addr != "" {
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			err := srv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Errorf("metrics server stopped: %s", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	natsAddress, err := secrets.Get(ctx, "worker.nats.address")
+	if err != nil {
+		return err
+	}
+	router, err := carrier.FromViper(ctx)
+	if err != nil {
+		return err
+	}
+
+	// nothing is consumed before the config, postgres and the providers are usable,
+	// instead of NAKing every message until they are
+	var cluster *db.Cluster
+	preflight.Add("pricing", func(ctx context.Context) error {
+		err := workers.CheckPricing()
+		if err != nil {
+			return workers.Permanent(err)
+		}
+		return nil
+	})
+	preflight.Add("postgres", func(ctx context.Context) (err error) {
+		cluster, err = db.ConnectChecked(ctx, "worker")
+		return err
+	})
+	if router != nil {
+		for name, c := range router.Checkers() {
+			preflight.Add("provider "+name, c.Check)
+		}
+	}
+	if preflightEnabled {
+		err = preflight.Run(ctx)
+	} else {
+		cluster, err = db.ConnectChecked(ctx, "worker")
+	}
+	if cluster != nil {
+		defer cluster.Close()
+	}
+	if err != nil {
+		return err
+	}
+	checker.Add("postgres", func(ctx context.Context) error {
+		return cluster.Writer().Ping(ctx)
+	})
+	// users may route their sms through accounts of their own once a master key
+	// opens the credentials
+	var accounts *providers.Routes
+	envelope, err := secrets.EnvelopeFromViper(ctx)
+	if err != nil {
+		return err
+	}
+	if envelope != nil {
+		accounts = providers.NewRoutes(ctx, sqlc.New(cluster.Writer()), envelope, viper.GetDuration("worker.byop.cachettl"))
+	}
+	Worker, err = workers.NewSms(ctx, natsAddress, cluster.Writer(), router, accounts)
+	if err != nil {
+		return err
+	}
+	defer Worker.Close()
+	checker.Add("nats", health.NATS(Worker.Conn))
+	depth := streams.NewDepth(Worker.Streams)
+	if interval := viper.GetDuration("metrics.queuedepth.interval"); interval > 0 {
+		go depth.Run(ctx, interval)
+	}
+	mux.HandleFunc("/queue/depth", depth.Handler)
+	err = Worker.Start(ctx)
+	if err != nil {
+		return err
+	}
+
+	if viper.GetBool("worker.jobs.enabled") {
+		store, err := storage.FromViper(ctx)
+		if err != nil {
+			return err
+		}
+		ExportWorker, err = workers.NewExport(ctx, natsAddress, cluster.Writer(), store)
+		if err != nil {
+			return err
+		}
+		defer ExportWorker.Close()
+		err = ExportWorker.Start(ctx)
+		if err != nil {
+			return err
+		}
+		ImportWorker, err = workers.NewImport(ctx, natsAddress, cluster.Writer(), store)
+		if err != nil {
+			return err
+		}
+		defer ImportWorker.Close()
+		err = ImportWorker.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.notify.enabled") {
+		mail, err := email.FromViper(ctx)
 		if err != nil {
 			return err
 		}
-		err = pool.Ping(context.Background())
+		pusher, err := push.FromViper(ctx)
 		if err != nil {
 			return err
 		}
+		caller, err := voice.FromViper(ctx)
+		if err != nil {
+			return err
+		}
+		chats := make(map[string]chat.Driver)
+		for _, channel := range []string{channels.WhatsApp, channels.Telegram} {
+			chats[channel], err = chat.FromViper(ctx, channel)
+			if err != nil {
+				return err
+			}
+		}
+		NotifyWorker, err = workers.NewNotify(ctx, natsAddress, cluster.Writer(), mail, pusher, caller, chats)
+		if err != nil {
+			return err
+		}
+		defer NotifyWorker.Close()
+		err = NotifyWorker.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
 
-		natsAddress := viper.GetString("worker.nats.address")
-		Worker, err = workers.NewSms(ctx, natsAddress, pool)
+	if viper.GetBool("worker.webhooks.enabled") {
+		WebhookWorker, err = workers.NewWebhook(ctx, natsAddress, cluster.Writer())
 		if err != nil {
 			return err
 		}
-		err = Worker.Start(ctx)
+		defer WebhookWorker.Close()
+		err = WebhookWorker.Start(ctx)
 		if err != nil {
 			return err
 		}
+	}
 
-		<-ctx.Done()
-		return nil
-	},
+	if viper.GetBool("worker.forwards.enabled") {
+		if envelope == nil {
+			return fmt.Errorf("worker.forwards.enabled: %w", secrets.ErrNoMasterKey)
+		}
+		Forwarder, err = workers.NewForwarder(ctx, natsAddress, cluster.Writer(), envelope)
+		if err != nil {
+			return err
+		}
+		defer Forwarder.Close()
+		err = Forwarder.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.billing.enabled") {
+		Invoicer = workers.NewInvoicer(cluster.Writer())
+		err = Invoicer.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	sink, err := eventsink.FromViper(ctx)
+	if err != nil {
+		return err
+	}
+	if sink != nil {
+		EventSink, err = workers.NewEventSink(ctx, natsAddress, sink)
+		if err != nil {
+			return err
+		}
+		defer EventSink.Close()
+		err = EventSink.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	kafkaBridge, err := bridge.FromViper(ctx)
+	if err != nil {
+		return err
+	}
+	if kafkaBridge != nil {
+		KafkaBridge, err = workers.NewKafkaBridge(ctx, natsAddress, kafkaBridge)
+		if err != nil {
+			return err
+		}
+		defer KafkaBridge.Close()
+		err = KafkaBridge.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.archive.enabled") {
+		store, err := storage.FromViper(ctx)
+		if err != nil {
+			return err
+		}
+		Archiver = workers.NewArchiver(cluster.Writer(), store)
+		err = Archiver.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.fraud.enabled") {
+		FraudDetector = workers.NewFraud(cluster.Writer())
+		err = FraudDetector.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.alerts.enabled") {
+		mail, err := email.FromViper(ctx)
+		if err != nil {
+			return err
+		}
+		Alerter, err = workers.NewAlerter(ctx, Worker.Conn, cluster.Writer(), mail)
+		if err != nil {
+			return err
+		}
+		err = Alerter.Start(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetBool("worker.heartbeat.enabled") {
+		// the workers that were started, with the lag of their consumers
+		consumers := []heartbeats.Lagger{Worker}
+		if ExportWorker != nil {
+			consumers = append(consumers, ExportWorker, ImportWorker)
+		}
+		if NotifyWorker != nil {
+			consumers = append(consumers, NotifyWorker)
+		}
+		if WebhookWorker != nil {
+			consumers = append(consumers, WebhookWorker)
+		}
+		if Forwarder != nil {
+			consumers = append(consumers, Forwarder)
+		}
+		if EventSink != nil {
+			consumers = append(consumers, EventSink)
+		}
+		if KafkaBridge != nil {
+			consumers = append(consumers, KafkaBridge)
+		}
+		beater := heartbeats.NewBeater(Worker.Conn, workers.Processed, consumers...)
+		go beater.Run(ctx, viper.GetDuration("worker.heartbeat.interval"))
+	}
+
+	<-ctx.Done()
+	checker.Drain()
+	// let in-flight messages finish before the deferred Close calls tear down the connections
+	stopCtx, stop := context.WithTimeout(context.Background(), viper.GetDuration("worker.shutdown.timeout"))
+	defer stop()
+	err = Worker.Stop(stopCtx)
+	if err != nil {
+		logrus.Errorf("failed to drain sms consumers: %s", err)
+	}
+	if ExportWorker != nil {
+		err = ExportWorker.Stop(stopCtx)
+		if err != nil {
+			logrus.Errorf("failed to drain export consumers: %s", err)
+		}
+	}
+	if ImportWorker != nil {
+		err = ImportWorker.Stop(stopCtx)
+		if err != nil {
+			logrus.Errorf("failed to drain import consumers: %s", err)
+		}
+	}
+	if NotifyWorker != nil {
+		err = NotifyWorker.Stop(stopCtx)
+		if err != nil {
+			logrus.Errorf("failed to drain notification consumers: %s", err)
+		}
+	}
+	if WebhookWorker != nil {
+		err = WebhookWorker.Stop(stopCtx)
+		if err != nil {
+			logrus.Errorf("failed to drain webhook consumers: %s", err)
+		}
+	}
+	if Forwarder != nil {
+		err = Forwarder.Stop(stopCtx)
+		if err != nil {
+			logrus.Errorf("failed to drain forwarding consumers: %s", err)
+		}
+	}
+	return nil
 }
 
 func init() {
 	RootCmd.AddCommand(WorkerCmd)
 	viper.SetDefault("sms.normal.ratelimit", 1000)
+	viper.SetDefault("worker.postgres.querytimeout", "5s")
+	viper.SetDefault("worker.postgres.schema.mismatch", "refuse")
+	viper.SetDefault("worker.postgres.tx.maxattempts", 3)
+	viper.SetDefault("worker.postgres.tx.backoff", "10ms")
+	viper.SetDefault("worker.shutdown.timeout", "30s")
+	viper.SetDefault("worker.heartbeat.enabled", true)
+	viper.SetDefault("worker.heartbeat.interval", "15s")
+	viper.SetDefault("worker.preflight.enabled", true)
+	viper.SetDefault("worker.preflight.maxattempts", 0)
+	viper.SetDefault("worker.preflight.backoff.initial", "1s")
+	viper.SetDefault("worker.preflight.backoff.max", "1m")
+	viper.SetDefault("worker.preflight.timeout", "10s")
+	viper.SetDefault("metrics.queuedepth.interval", "15s")
+	viper.SetDefault("metrics.labels.org", false)
+	viper.SetDefault("worker.retry.maxdeliveries", 0)
+	viper.SetDefault("worker.retry.backoff", []string{"1s", "5s", "30s"})
+	viper.SetDefault("worker.recover.maxdeliveries", 3)
+	viper.SetDefault("worker.recover.delay", "5s")
+	viper.SetDefault("worker.backpressure.enabled", true)
+	viper.SetDefault("worker.backpressure.latency", "500ms")
+	viper.SetDefault("worker.backpressure.errorrate", 0.5)
+	viper.SetDefault("worker.backpressure.window", 20)
+	viper.SetDefault("worker.backpressure.probe", "2s")
+	viper.SetDefault("worker.dedupe.retention", "168h")
+	viper.SetDefault("worker.errors.retention", "720h")
+	viper.SetDefault("worker.expiry.interval", "1m")
+	viper.SetDefault("worker.expiry.batchsize", 500)
+	viper.SetDefault("worker.scheduler.enabled", true)
+	viper.SetDefault("worker.scheduler.slots", 1)
+	viper.SetDefault("worker.scheduler.weights.express", 3)
+	viper.SetDefault("worker.scheduler.weights.normal", 1)
+	viper.SetDefault("worker.scheduler.aging", "30s")
+	viper.SetDefault("worker.scheduler.tenantweight", 1)
+	viper.SetDefault("worker.ratelimit.distributed", false)
+	viper.SetDefault("worker.ratelimit.bucket", "sms_ratelimit")
+	viper.SetDefault("worker.ratelimit.burst", 1)
+	viper.SetDefault("worker.ratelimit.credits.normal", 0)
+	viper.SetDefault("worker.ratelimit.credits.express", 0)
+	viper.SetDefault("worker.ratelimit.recharge", "1m")
+	viper.SetDefault("worker.jobs.enabled", true)
+	viper.SetDefault("storage.local.dir", "storage")
+	viper.SetDefault("jobs.export.batchsize", 1000)
+	viper.SetDefault("jobs.import.batchsize", 1000)
+	viper.SetDefault("worker.billing.enabled", true)
+	viper.SetDefault("worker.archive.enabled", false)
+	viper.SetDefault("events.stream.enabled", false)
+	viper.SetDefault("events.stream.maxage", "72h")
+	viper.SetDefault("nats.audit.enabled", false)
+	viper.SetDefault("nats.audit.retention", "interest")
+	viper.SetDefault("nats.audit.maxage", "720h")
+	viper.SetDefault("events.sink.batchsize", 500)
+	viper.SetDefault("events.sink.flushinterval", "5s")
+	viper.SetDefault("events.sink.retry", "30s")
+	viper.SetDefault("events.sink.clickhouse.table", "sms_events")
+	viper.SetDefault("events.sink.bigquery.table", "sms_events")
+	viper.SetDefault("kafka.bridge.enabled", false)
+	viper.SetDefault("kafka.bridge.format", "avro")
+	viper.SetDefault("kafka.bridge.batchsize", 500)
+	viper.SetDefault("kafka.bridge.flushinterval", "1s")
+	viper.SetDefault("kafka.bridge.retry", "30s")
+	viper.SetDefault("kafka.registry.autoregister", true)
+	viper.SetDefault("worker.archive.interval", "1h")
+	viper.SetDefault("worker.archive.delay", "48h")
+	viper.SetDefault("worker.archive.prefix", "archive/sms")
+	viper.SetDefault("worker.archive.batchsize", 1000)
+	viper.SetDefault("worker.submit.maxattempts", 5)
+	viper.SetDefault("worker.submit.backoff.initial", "5s")
+	viper.SetDefault("worker.submit.backoff.max", "5m")
+	viper.SetDefault("worker.deadline.normal", 0)
+	viper.SetDefault("worker.deadline.express", "5s")
+	viper.SetDefault("worker.byop.cachettl", "30s")
+	viper.SetDefault("sms.cost", "5.0")
+	viper.SetDefault("sms.byop.fee", "0.5")
+	viper.SetDefault("worker.notify.enabled", true)
+	viper.SetDefault("worker.notify.maxattempts", 3)
+	viper.SetDefault("email.driver", "log")
+	viper.SetDefault("email.from", "no-reply@localhost")
+	viper.SetDefault("email.subject", "Notification")
+	viper.SetDefault("email.cost", "0")
+	viper.SetDefault("push.driver", "log")
+	viper.SetDefault("push.cost", "0")
+	viper.SetDefault("voice.driver", "log")
+	viper.SetDefault("voice.repeat", 2)
+	viper.SetDefault("voice.cost", "0")
+	viper.SetDefault("whatsapp.driver", "log")
+	viper.SetDefault("whatsapp.cost", "0")
+	viper.SetDefault("telegram.driver", "log")
+	viper.SetDefault("telegram.cost", "0")
+	viper.SetDefault("worker.webhooks.enabled", true)
+	viper.SetDefault("worker.webhooks.maxattempts", 8)
+	viper.SetDefault("worker.webhooks.backoff.initial", "10s")
+	viper.SetDefault("worker.webhooks.backoff.max", "1h")
+	viper.SetDefault("worker.webhooks.timeout", "10s")
+	viper.SetDefault("worker.forwards.enabled", false)
+	viper.SetDefault("worker.forwards.timeout", "5s")
+	viper.SetDefault("worker.forwards.maxattempts", 8)
+	viper.SetDefault("worker.forwards.backoff.initial", "10s")
+	viper.SetDefault("worker.forwards.backoff.max", "1h")
+	viper.SetDefault("worker.billing.interval", "1h")
+	viper.SetDefault("worker.fraud.enabled", true)
+	viper.SetDefault("worker.fraud.interval", "1m")
+	viper.SetDefault("worker.fraud.window", "10m")
+	viper.SetDefault("worker.fraud.baseline", "24h")
+	viper.SetDefault("worker.fraud.minsent", 50)
+	viper.SetDefault("worker.fraud.spikefactor", 10)
+	viper.SetDefault("worker.fraud.maxcountries", 10)
+	viper.SetDefault("worker.fraud.failurerate", 0.5)
+	viper.SetDefault("worker.fraud.action", "throttle")
+	viper.SetDefault("worker.fraud.throttle.limit", 10)
+	viper.SetDefault("worker.fraud.throttle.duration", "1h")
+	viper.SetDefault("worker.alerts.enabled", true)
+	viper.SetDefault("worker.alerts.interval", "5m")
+	viper.SetDefault("worker.alerts.window", "1h")
+	viper.SetDefault("worker.alerts.baseline", "24h")
+	viper.SetDefault("worker.alerts.minsent", 20)
+	viper.SetDefault("worker.alerts.cooldown", "24h")
 }
@@ -0,0 +1,123 @@
+// Package alerts evaluates the usage alert rules users set on their own sends: a
+// daily spend, a share of failed messages or a jump in volume over what they
+// usually send.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// kinds of rules
+const (
+	// KindSpend fires when the user spent more than the threshold in the last day
+	KindSpend = "spend"
+	// KindFailureRate fires when at least the threshold percent of the messages
+	// of the window failed or expired
+	KindFailureRate = "failure_rate"
+	// KindVolumeSpike fires when the messages of the window are more than the
+	// threshold percent above the usual rate
+	KindVolumeSpike = "volume_spike"
+)
+
+// EventAlert is the event type of the alerts posted to the webhooks of the user.
+const EventAlert = "usage.alert"
+
+var (
+	ErrKind      = errors.New("unknown alert rule kind")
+	ErrThreshold = errors.New("alert rule threshold must be positive, and at most 100 for failure_rate")
+)
+
+// ParseKind parses the kind of a rule.
+func ParseKind(s string) (string, error) {
+	switch s {
+	case KindSpend, KindFailureRate, KindVolumeSpike:
+		return s, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s, %s or %s", ErrKind, s, KindSpend, KindFailureRate, KindVolumeSpike)
+	}
+}
+
+// Rule is an alert rule of a user.
+type Rule struct {
+	Kind string
+	// Threshold is an amount for KindSpend and a percentage for the others
+	Threshold float64
+}
+
+// Validate fails unless the kind is known and the threshold fits it.
+func (r Rule) Validate() error {
+	_, err := ParseKind(r.Kind)
+	if err != nil {
+		return err
+	}
+	if r.Threshold <= 0 || (r.Kind == KindFailureRate && r.Threshold > 100) {
+		return ErrThreshold
+	}
+	return nil
+}
+
+type Config struct {
+	// Window is the recent activity the failure rate and volume are taken from
+	Window time.Duration
+	// Baseline is the history before Window the usual send rate is taken from
+	Baseline time.Duration
+	// MinSent spares users that sent fewer messages within Window from the
+	// failure rate and volume rules
+	MinSent int
+	// Cooldown is the least time between two alerts of a rule
+	Cooldown time.Duration
+}
+
+// ConfigFromViper reads the config from worker.alerts.
+func ConfigFromViper() Config {
+	return Config{
+		Window:   viper.GetDuration("worker.alerts.window"),
+		Baseline: viper.GetDuration("worker.alerts.baseline"),
+		MinSent:  viper.GetInt("worker.alerts.minsent"),
+		Cooldown: viper.GetDuration("worker.alerts.cooldown"),
+	}
+}
+
+// Usage is what one user sent.
+type Usage struct {
+	// Spent is the cost of the messages of the last day
+	Spent  float64
+	Sent   int
+	Failed int
+	// Baseline is the number of messages sent during Config.Baseline
+	Baseline int
+}
+
+// Evaluate reports whether u trips r, with a detail for the alert.
+func (c Config) Evaluate(r Rule, u Usage) (string, bool) {
+	switch r.Kind {
+	case KindSpend:
+		if u.Spent > r.Threshold {
+			return fmt.Sprintf("spent %.2f in the last day, over %.2f", u.Spent, r.Threshold), true
+		}
+	case KindFailureRate:
+		if u.Sent == 0 || u.Sent < c.MinSent {
+			return "", false
+		}
+		rate := 100 * float64(u.Failed) / float64(u.Sent)
+		if rate >= r.Threshold {
+			return fmt.Sprintf("%d of %d messages failed in %s (%.0f%%)", u.Failed, u.Sent, c.Window, rate), true
+		}
+	case KindVolumeSpike:
+		if u.Sent == 0 || u.Sent < c.MinSent {
+			return "", false
+		}
+		usual := float64(c.MinSent)
+		if c.Baseline > 0 {
+			usual = max(usual, float64(u.Baseline)*float64(c.Window)/float64(c.Baseline))
+		}
+		if usual > 0 && float64(u.Sent) > usual*(1+r.Threshold/100) {
+			return fmt.Sprintf("%d messages in %s, usually %.0f", u.Sent, c.Window, usual), true
+		}
+	}
+	return "", false
+}
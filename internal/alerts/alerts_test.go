@@ -0,0 +1,53 @@
+package alerts_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/alerts"
+)
+
+var _ = Describe("Evaluate", func() {
+	cfg := Config{Window: time.Hour, Baseline: 24 * time.Hour, MinSent: 20}
+
+	It("should fire spend rules over the threshold", func() {
+		_, ok := cfg.Evaluate(Rule{Kind: KindSpend, Threshold: 100}, Usage{Spent: 100})
+		Expect(ok).To(BeFalse())
+		detail, ok := cfg.Evaluate(Rule{Kind: KindSpend, Threshold: 100}, Usage{Spent: 100.5})
+		Expect(ok).To(BeTrue())
+		Expect(detail).To(ContainSubstring("100.50"))
+	})
+
+	It("should fire failure rate rules at the threshold, above the minimum", func() {
+		rule := Rule{Kind: KindFailureRate, Threshold: 10}
+		_, ok := cfg.Evaluate(rule, Usage{Sent: 19, Failed: 19})
+		Expect(ok).To(BeFalse())
+		_, ok = cfg.Evaluate(rule, Usage{Sent: 100, Failed: 9})
+		Expect(ok).To(BeFalse())
+		_, ok = cfg.Evaluate(rule, Usage{Sent: 100, Failed: 10})
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should fire volume spike rules above the usual rate", func() {
+		rule := Rule{Kind: KindVolumeSpike, Threshold: 50}
+		// 2400 a day is 100 an hour, the spike starts past 150
+		_, ok := cfg.Evaluate(rule, Usage{Sent: 150, Baseline: 2400})
+		Expect(ok).To(BeFalse())
+		detail, ok := cfg.Evaluate(rule, Usage{Sent: 151, Baseline: 2400})
+		Expect(ok).To(BeTrue())
+		Expect(detail).To(Equal("151 messages in 1h0m0s, usually 100"))
+
+		// without history the minimum is the usual rate
+		_, ok = cfg.Evaluate(rule, Usage{Sent: 31})
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should refuse unknown kinds and thresholds out of range", func() {
+		Expect(Rule{Kind: "latency", Threshold: 1}.Validate()).To(MatchError(ErrKind))
+		Expect(Rule{Kind: KindSpend}.Validate()).To(MatchError(ErrThreshold))
+		Expect(Rule{Kind: KindFailureRate, Threshold: 101}.Validate()).To(MatchError(ErrThreshold))
+		Expect(Rule{Kind: KindVolumeSpike, Threshold: 200}.Validate()).To(Succeed())
+	})
+})
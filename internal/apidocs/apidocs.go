@@ -0,0 +1,139 @@
+// Package apidocs describes the routes of the api, with the scopes they require
+// and the fields of their requests, and exports them as collections for API
+// clients. the description is generated from the source of the controllers, see
+// Parse, and a test fails when the generated Routes fell out of sync with them.
+package apidocs
+
+//go:generate go run ./gen
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ControllersDir is where the controllers are parsed from, relative to the module root.
+const ControllersDir = "internal/controllers"
+
+// the types of fields
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	// TypeTime is an RFC 3339 string
+	TypeTime   = "time"
+	TypeArray  = "array"
+	TypeObject = "object"
+)
+
+// Route is a route a controller registers.
+type Route struct {
+	Method string
+	// Path is under the version prefix, or the root when Unversioned
+	Path        string
+	Unversioned bool
+	// From and To are the versions serving the route, To 0 meaning the latest
+	From, To int
+	// Handler is the method serving the route, as Type.Method
+	Handler string
+	// Doc is the doc comment of the handler
+	Doc    string
+	Scopes []string
+	Query  []Field
+	Header []Field
+	Body   []Field
+	// Form is set when the body is url encoded instead of JSON
+	Form bool
+}
+
+// Field is a field of a request.
+type Field struct {
+	Name     string
+	Type     string
+	Required bool
+	// Enum are the values the field is validated against
+	Enum []string
+	// Fields are the fields of an object, or the element of an array
+	Fields []Field
+}
+
+// Serves reports whether version n serves r.
+func (r Route) Serves(n, latest int) bool {
+	to := r.To
+	if to == 0 {
+		to = latest
+	}
+	return r.Unversioned || (r.From <= n && n <= to)
+}
+
+// Public reports whether r is called without an API key, like the delivery
+// callbacks of providers.
+func (r Route) Public() bool {
+	return r.Unversioned && len(r.Scopes) == 0
+}
+
+// Name is the handler of r in words, e.g. "Send Sms".
+func (r Route) Name() string {
+	_, method, _ := strings.Cut(r.Handler, ".")
+	var b strings.Builder
+	for i, c := range method {
+		if i > 0 && unicode.IsUpper(c) && !unicode.IsUpper(rune(method[i-1])) {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// FullPath is the path of r served by version n.
+func (r Route) FullPath(n int) string {
+	if r.Unversioned {
+		return r.Path
+	}
+	return "/v" + strconv.Itoa(n) + r.Path
+}
+
+// Params are the names of the path parameters of r, in order.
+func (r Route) Params() []string {
+	var params []string
+	for _, segment := range strings.Split(r.Path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+		}
+	}
+	return params
+}
+
+// Example is a value of f to show in a request.
+func (f Field) Example() any {
+	if len(f.Enum) > 0 {
+		return f.Enum[0]
+	}
+	switch f.Type {
+	case TypeInteger, TypeNumber:
+		return 0
+	case TypeBoolean:
+		return false
+	case TypeTime:
+		return "2024-01-01T00:00:00Z"
+	case TypeArray:
+		if len(f.Fields) == 0 {
+			return []any{}
+		}
+		return []any{f.Fields[0].Example()}
+	case TypeObject:
+		return Example(f.Fields)
+	default:
+		return ""
+	}
+}
+
+// Example is an object with the example values of fields.
+func Example(fields []Field) map[string]any {
+	m := make(map[string]any, len(fields))
+	for _, f := range fields {
+		m[f.Name] = f.Example()
+	}
+	return m
+}
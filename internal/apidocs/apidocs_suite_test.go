@@ -0,0 +1,13 @@
+package apidocs_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestApidocs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apidocs Suite")
+}
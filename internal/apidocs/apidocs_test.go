@@ -0,0 +1,101 @@
+package apidocs_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/apidocs"
+)
+
+func find(method, path string) apidocs.Route {
+	for _, r := range apidocs.Routes {
+		if r.Method == method && r.Path == path {
+			return r
+		}
+	}
+	Fail("no route " + method + " " + path)
+	return apidocs.Route{}
+}
+
+var _ = Describe("Apidocs", func() {
+	It("should be in sync with the controllers", func() {
+		routes, err := apidocs.Parse("../..")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(routes).To(Equal(apidocs.Routes), "run go generate ./internal/apidocs")
+	})
+
+	It("should parse the scopes and fields of routes", func() {
+		send := find("POST", "/sms")
+		Expect(send.Handler).To(Equal("Sms.SendSms"))
+		Expect(send.Scopes).To(ConsistOf("sms:send"))
+		Expect(send.Body).To(ContainElement(apidocs.Field{Name: "to_phone_number", Type: apidocs.TypeString, Required: true}))
+		Expect(send.Query).To(ContainElement(HaveField("Name", "express")))
+
+		twilio := find("POST", "/twilio/2010-04-01/Accounts/:account/Messages.json")
+		Expect(twilio.Unversioned).To(BeTrue())
+		Expect(twilio.Form).To(BeTrue())
+		Expect(twilio.Params()).To(Equal([]string{"account"}))
+
+		dlr := find("POST", "/callbacks/dlr/:provider")
+		Expect(dlr.Public()).To(BeTrue())
+	})
+
+	It("should only serve routes in their versions", func() {
+		r := apidocs.Route{Path: "/sms", From: 1, To: 2}
+		Expect(r.Serves(1, 3)).To(BeTrue())
+		Expect(r.Serves(3, 3)).To(BeFalse())
+		r.To = 0
+		Expect(r.Serves(3, 3)).To(BeTrue())
+		Expect(r.FullPath(3)).To(Equal("/v3/sms"))
+	})
+
+	It("should export a postman collection", func() {
+		c := apidocs.Postman(apidocs.Routes, 1, 1, "http://sms.example")
+		Expect(c.Info.Schema).To(Equal(apidocs.PostmanSchema))
+		Expect(c.Auth.Type).To(Equal("bearer"))
+		Expect(c.Variable).To(ContainElement(HaveField("Value", "http://sms.example")))
+
+		var send, dlr *apidocs.Request
+		for _, folder := range c.Item {
+			for _, item := range folder.Item {
+				switch item.Request.URL.Raw {
+				case "{{baseUrl}}/v1/sms":
+					if item.Request.Method == "POST" {
+						send = item.Request
+					}
+				case "{{baseUrl}}/callbacks/dlr/:provider":
+					dlr = item.Request
+				}
+			}
+		}
+		Expect(send).ToNot(BeNil())
+		Expect(send.Auth).To(BeNil())
+		Expect(send.Description).To(ContainSubstring("Requires sms:send."))
+		var body map[string]any
+		Expect(json.Unmarshal([]byte(send.Body.Raw), &body)).To(Succeed())
+		Expect(body).To(HaveKeyWithValue("to_phone_number", ""))
+		Expect(body).To(HaveKeyWithValue("category", "transactional"))
+		Expect(send.URL.Query).To(ContainElement(apidocs.Variable{Key: "express", Value: "false", Description: "boolean", Disabled: true}))
+
+		Expect(dlr).ToNot(BeNil())
+		Expect(dlr.Auth.Type).To(Equal("noauth"))
+		Expect(dlr.URL.Variable).To(ConsistOf(apidocs.Variable{Key: "provider"}))
+	})
+
+	It("should export an httpie script", func() {
+		var b strings.Builder
+		Expect(apidocs.HTTPie(&b, apidocs.Routes, 1, 1, "http://sms.example")).To(Succeed())
+		script := b.String()
+		Expect(script).To(ContainSubstring(`: "${SMS_URL:=http://sms.example}"`))
+		Expect(script).To(ContainSubstring("sms_sms_send_sms() {\n"))
+		Expect(script).To(ContainSubstring(`http POST "$SMS_URL/v1/sms" "Authorization:Bearer $SMS_API_KEY" 'user_id:=0' 'to_phone_number=' 'message=' "$@"`))
+		Expect(script).To(ContainSubstring(`http --form POST "$SMS_URL$p"`))
+	})
+
+	It("should name shell functions after handlers", func() {
+		Expect(apidocs.FuncName(apidocs.Route{Handler: "PhoneNumber.AddPhoneNumber"})).To(Equal("sms_phone_number_add_phone_number"))
+	})
+})
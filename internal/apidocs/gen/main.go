@@ -0,0 +1,110 @@
+// Command gen writes routes_gen.go, the routes of the controllers for
+// apidocs.Routes. it runs with go generate in internal/apidocs.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/alireza-karampour/sms/internal/apidocs"
+)
+
+func main() {
+	root, err := moduleRoot()
+	if err != nil {
+		log.Fatal(err)
+	}
+	routes, err := apidocs.Parse(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var b bytes.Buffer
+	b.WriteString("// Code generated by go generate; DO NOT EDIT.\n\npackage apidocs\n\n")
+	b.WriteString("// Routes are the routes of the controllers, see Parse.\nvar Routes = []Route{\n")
+	for _, r := range routes {
+		fmt.Fprintf(&b, "{Method: %q, Path: %q, ", r.Method, r.Path)
+		if r.Unversioned {
+			b.WriteString("Unversioned: true, ")
+		}
+		if r.From != 0 {
+			fmt.Fprintf(&b, "From: %d, ", r.From)
+		}
+		if r.To != 0 {
+			fmt.Fprintf(&b, "To: %d, ", r.To)
+		}
+		fmt.Fprintf(&b, "Handler: %q,\n", r.Handler)
+		if r.Doc != "" {
+			fmt.Fprintf(&b, "Doc: %q,\n", r.Doc)
+		}
+		if r.Scopes != nil {
+			fmt.Fprintf(&b, "Scopes: %#v,\n", r.Scopes)
+		}
+		writeFields(&b, "Query", r.Query)
+		writeFields(&b, "Header", r.Header)
+		writeFields(&b, "Body", r.Body)
+		if r.Form {
+			b.WriteString("Form: true,\n")
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile("routes_gen.go", src, 0o644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeFields(b *bytes.Buffer, name string, fields []apidocs.Field) {
+	if fields == nil {
+		return
+	}
+	fmt.Fprintf(b, "%s: []Field{\n", name)
+	for _, f := range fields {
+		writeField(b, f)
+	}
+	b.WriteString("},\n")
+}
+
+func writeField(b *bytes.Buffer, f apidocs.Field) {
+	fmt.Fprintf(b, "{Name: %q, Type: %q", f.Name, f.Type)
+	if f.Required {
+		b.WriteString(", Required: true")
+	}
+	if f.Enum != nil {
+		fmt.Fprintf(b, ", Enum: %#v", f.Enum)
+	}
+	if f.Fields != nil {
+		b.WriteString(", Fields: []Field{\n")
+		for _, sub := range f.Fields {
+			writeField(b, sub)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("},\n")
+}
+
+// moduleRoot is the nearest directory up from the working directory with a go.mod.
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod above the working directory")
+		}
+		dir = parent
+	}
+}
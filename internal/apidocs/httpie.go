@@ -0,0 +1,127 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// HTTPie writes a shell script of a function per route version n serves, calling
+// it with HTTPie. sourced, the functions send requests to $SMS_URL, baseURL by
+// default, with $SMS_API_KEY: the path parameters are their arguments, in order,
+// and the arguments after them are passed to http, to add or override fields.
+// the required fields are sent with example values, the optional ones are listed
+// in the comment above the function.
+func HTTPie(w io.Writer, routes []Route, n, latest int, baseURL string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# SMS Gateway v%d, generated by sms apidocs export.\n", n)
+	b.WriteString("# source this file, then call the functions, e.g. sms_user_get_user alice\n")
+	fmt.Fprintf(&b, ": \"${SMS_URL:=%s}\"\n", baseURL)
+	b.WriteString(": \"${SMS_API_KEY:=}\"\n")
+	for _, r := range routes {
+		if !r.Serves(n, latest) {
+			continue
+		}
+		err := httpieFunc(&b, r, n)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func httpieFunc(b *strings.Builder, r Route, n int) error {
+	fmt.Fprintf(b, "\n# %s %s", r.Method, r.FullPath(n))
+	if len(r.Scopes) > 0 {
+		fmt.Fprintf(b, ", requires %s", strings.Join(r.Scopes, ", "))
+	}
+	b.WriteByte('\n')
+	var optional []string
+	for _, fields := range [][]Field{r.Query, r.Header, r.Body} {
+		for _, f := range fields {
+			if !f.Required {
+				optional = append(optional, f.Name)
+			}
+		}
+	}
+	if len(optional) > 0 {
+		fmt.Fprintf(b, "# optional: %s\n", strings.Join(optional, ", "))
+	}
+
+	params := r.Params()
+	path := r.FullPath(n)
+	for i, p := range params {
+		path = strings.Replace(path, ":"+p, fmt.Sprintf("$%d", i+1), 1)
+		path = strings.Replace(path, "*"+p, fmt.Sprintf("$%d", i+1), 1)
+	}
+	fmt.Fprintf(b, "%s() {\n", FuncName(r))
+	if len(params) > 0 {
+		fmt.Fprintf(b, "  p=\"%s\"; shift %d\n  ", path, len(params))
+		path = "$p"
+	} else {
+		b.WriteString("  ")
+	}
+	b.WriteString("http")
+	if r.Form {
+		b.WriteString(" --form")
+	}
+	fmt.Fprintf(b, " %s \"$SMS_URL%s\"", r.Method, path)
+	if !r.Public() {
+		b.WriteString(" \"Authorization:Bearer $SMS_API_KEY\"")
+	}
+	for _, q := range r.Query {
+		if q.Required {
+			fmt.Fprintf(b, " %s", shellQuote(fmt.Sprintf("%s==%v", q.Name, q.Example())))
+		}
+	}
+	for _, h := range r.Header {
+		if h.Required {
+			fmt.Fprintf(b, " %s", shellQuote(fmt.Sprintf("%s:%v", h.Name, h.Example())))
+		}
+	}
+	for _, f := range r.Body {
+		if !f.Required {
+			continue
+		}
+		if s, ok := f.Example().(string); ok {
+			fmt.Fprintf(b, " %s", shellQuote(f.Name+"="+s))
+			continue
+		}
+		v, err := json.Marshal(f.Example())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " %s", shellQuote(f.Name+":="+string(v)))
+	}
+	b.WriteString(" \"$@\"\n}\n")
+	return nil
+}
+
+// FuncName is the shell function of r, e.g. sms_sms_send_sms for Sms.SendSms.
+func FuncName(r Route) string {
+	var b strings.Builder
+	b.WriteString("sms_")
+	prev := '.'
+	for _, c := range r.Handler {
+		switch {
+		case c == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(c):
+			if prev != '.' && !unicode.IsUpper(prev) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(c))
+		default:
+			b.WriteRune(c)
+		}
+		prev = c
+	}
+	return b.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,839 @@
+package apidocs
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds how deep handlers are followed into the functions they call,
+// and nested types into their fields
+const maxDepth = 3
+
+// routeMethods are the methods of gin.RouterGroup registering a route
+var routeMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
+// Parse reads the routes the controllers of the module at root register, in the
+// order of their files. a route is registered with the RegisterRoutes or
+// RegisterVersions of a Base made by NewBase in the constructor of a controller,
+// or on a group of the constructor. the scopes are the ones of RequireScopes, the
+// fields of the request are the ones of the variables the handler, or the first
+// function it calls that binds any, binds.
+func Parse(root string) ([]Route, error) {
+	module, err := modulePath(root)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{
+		root:   root,
+		module: module,
+		fset:   token.NewFileSet(),
+		pkgs:   make(map[string]*pkg),
+	}
+	p, err := l.load(module + "/" + ControllersDir)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "New") || fn.Body == nil {
+				continue
+			}
+			c := &constructor{l: l, p: p, f: f, bases: make(map[string]group), types: make(map[string]string)}
+			for _, stmt := range fn.Body.List {
+				c.stmt(stmt)
+			}
+			routes = append(routes, c.routes...)
+		}
+	}
+	return routes, nil
+}
+
+func modulePath(root string) (string, error) {
+	f, err := os.Open(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(s.Text()), "module "); ok {
+			return strings.TrimSpace(module), nil
+		}
+	}
+	return "", fmt.Errorf("no module in %s", filepath.Join(root, "go.mod"))
+}
+
+type loader struct {
+	root   string
+	module string
+	fset   *token.FileSet
+	pkgs   map[string]*pkg
+}
+
+// pkg is a parsed package of the module.
+type pkg struct {
+	l     *loader
+	files []*ast.File
+	// decls are the types, constants and functions by name, methods by
+	// Type.Method, with the file declaring them
+	types  map[string]decl[*ast.TypeSpec]
+	consts map[string]decl[ast.Expr]
+	funcs  map[string]decl[*ast.FuncDecl]
+}
+
+type decl[T any] struct {
+	node T
+	file *ast.File
+}
+
+// load parses the package of the module at path, once.
+func (l *loader) load(path string) (*pkg, error) {
+	if p, ok := l.pkgs[path]; ok {
+		return p, nil
+	}
+	rel, ok := strings.CutPrefix(path, l.module+"/")
+	if !ok {
+		return nil, fmt.Errorf("%s is not a package of %s", path, l.module)
+	}
+	dir := filepath.Join(l.root, filepath.FromSlash(rel))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &pkg{
+		l:      l,
+		types:  make(map[string]decl[*ast.TypeSpec]),
+		consts: make(map[string]decl[ast.Expr]),
+		funcs:  make(map[string]decl[*ast.FuncDecl]),
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".go") && !strings.HasSuffix(e.Name(), "_test.go") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := parser.ParseFile(l.fset, filepath.Join(dir, name), nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		p.add(f)
+	}
+	l.pkgs[path] = p
+	return p, nil
+}
+
+func (p *pkg) add(f *ast.File) {
+	p.files = append(p.files, f)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) == 1 {
+				name = typeName(d.Recv.List[0].Type) + "." + name
+			}
+			p.funcs[name] = decl[*ast.FuncDecl]{d, f}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					p.types[spec.Name.Name] = decl[*ast.TypeSpec]{spec, f}
+				case *ast.ValueSpec:
+					if d.Tok != token.CONST {
+						continue
+					}
+					for i, name := range spec.Names {
+						if i < len(spec.Values) {
+							p.consts[name.Name] = decl[ast.Expr]{spec.Values[i], f}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// imported returns the package of the module f imports as name, if any.
+func (p *pkg) imported(f *ast.File, name string) (*pkg, bool) {
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		alias := path[strings.LastIndexByte(path, '/')+1:]
+		if imp.Name != nil {
+			alias = imp.Name.Name
+		}
+		if alias != name || !strings.HasPrefix(path, p.l.module+"/") {
+			continue
+		}
+		q, err := p.l.load(path)
+		if err != nil {
+			return nil, false
+		}
+		return q, true
+	}
+	return nil, false
+}
+
+// str evaluates a constant string expression of f.
+func (p *pkg) str(f *ast.File, e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.ParenExpr:
+		return p.str(f, e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := p.str(f, e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := p.str(f, e.Y)
+		return x + y, ok
+	case *ast.Ident:
+		c, ok := p.consts[e.Name]
+		if !ok {
+			return "", false
+		}
+		return p.str(c.file, c.node)
+	case *ast.SelectorExpr:
+		x, ok := e.X.(*ast.Ident)
+		if !ok {
+			return "", false
+		}
+		q, ok := p.imported(f, x.Name)
+		if !ok {
+			return "", false
+		}
+		c, ok := q.consts[e.Sel.Name]
+		if !ok {
+			return "", false
+		}
+		return q.str(c.file, c.node)
+	}
+	return "", false
+}
+
+// int evaluates a constant int expression of f.
+func (p *pkg) int(f *ast.File, e ast.Expr) (int, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		n, err := strconv.Atoi(e.Value)
+		return n, e.Kind == token.INT && err == nil
+	case *ast.Ident:
+		c, ok := p.consts[e.Name]
+		if !ok {
+			return 0, false
+		}
+		return p.int(c.file, c.node)
+	}
+	return 0, false
+}
+
+// group is where a constructor registers routes: a Base or a gin group.
+type group struct {
+	path        string
+	unversioned bool
+	scopes      []string
+}
+
+// constructor follows the statements of the constructor of a controller.
+type constructor struct {
+	l *loader
+	p *pkg
+	f *ast.File
+	// bases are the bases and groups by the expression they are held in, like
+	// base or j.Admin
+	bases map[string]group
+	// types are the types of the controllers by variable
+	types  map[string]string
+	routes []Route
+}
+
+func (c *constructor) stmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		for i, lhs := range stmt.Lhs {
+			if i < len(stmt.Rhs) {
+				c.assign(exprString(lhs), stmt.Rhs[i])
+			}
+		}
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return
+		}
+		switch sel.Sel.Name {
+		case "RegisterRoutes", "RegisterVersions":
+			c.register(call, sel)
+		default:
+			if g, ok := c.bases[exprString(sel.X)]; ok && slices.Contains(routeMethods, sel.Sel.Name) {
+				c.route(g, 1, 0, sel.Sel.Name, call.Args)
+			}
+		}
+	case *ast.IfStmt:
+		// routes registered behind a condition are routes all the same
+		for _, s := range stmt.Body.List {
+			c.stmt(s)
+		}
+	}
+}
+
+func (c *constructor) assign(name string, rhs ast.Expr) {
+	if u, ok := rhs.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		rhs = u.X
+	}
+	switch rhs := rhs.(type) {
+	case *ast.CallExpr:
+		if g, ok := c.group(rhs); ok {
+			c.bases[name] = g
+		}
+	case *ast.CompositeLit:
+		t := typeName(rhs.Type)
+		c.types[name] = t
+		fields := c.fieldNames(t)
+		for i, elt := range rhs.Elts {
+			field := ""
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				field, elt = exprString(kv.Key), kv.Value
+			} else if i < len(fields) {
+				field = fields[i]
+			}
+			if call, ok := elt.(*ast.CallExpr); ok && field != "" {
+				if g, ok := c.group(call); ok {
+					c.bases[name+"."+field] = g
+				}
+			}
+		}
+	}
+}
+
+// group returns the group call makes: a NewBase, or the Group of a gin group.
+func (c *constructor) group(call *ast.CallExpr) (group, bool) {
+	if len(call.Args) == 0 {
+		return group{}, false
+	}
+	path, ok := c.p.str(c.f, call.Args[0])
+	if !ok {
+		return group{}, false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if fun.Name != "NewBase" || len(call.Args) < 2 {
+			return group{}, false
+		}
+		return group{path: path, scopes: c.scopes(call.Args[2:])}, true
+	case *ast.SelectorExpr:
+		if fun.Sel.Name != "Group" {
+			return group{}, false
+		}
+		parent, ok := c.bases[exprString(fun.X)]
+		if !ok {
+			parent = group{unversioned: true}
+		}
+		return group{
+			path:        parent.path + path,
+			unversioned: parent.unversioned,
+			scopes:      append(slices.Clip(parent.scopes), c.scopes(call.Args[1:])...),
+		}, true
+	}
+	return group{}, false
+}
+
+// fieldNames are the names of the fields of the struct t of the package, embedded
+// ones by their type.
+func (c *constructor) fieldNames(t string) []string {
+	spec, ok := c.p.types[t]
+	if !ok {
+		return nil
+	}
+	st, ok := spec.node.Type.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			names = append(names, typeName(field.Type))
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// scopes are the scopes the RequireScopes of args require.
+func (c *constructor) scopes(args []ast.Expr) []string {
+	var scopes []string
+	for _, arg := range args {
+		call, ok := arg.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "RequireScopes" {
+			continue
+		}
+		for _, a := range call.Args {
+			if s, ok := c.p.str(c.f, a); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+// register reads the routes of a RegisterRoutes or RegisterVersions call.
+func (c *constructor) register(call *ast.CallExpr, sel *ast.SelectorExpr) {
+	g, ok := c.bases[exprString(sel.X)]
+	if !ok || len(call.Args) == 0 {
+		return
+	}
+	from, to := 1, 0
+	if sel.Sel.Name == "RegisterVersions" {
+		if len(call.Args) != 3 {
+			return
+		}
+		from, _ = c.p.int(c.f, call.Args[0])
+		to, _ = c.p.int(c.f, call.Args[1])
+	}
+	fn, ok := call.Args[len(call.Args)-1].(*ast.FuncLit)
+	if !ok || len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) != 1 {
+		return
+	}
+	gp := fn.Type.Params.List[0].Names[0].Name
+	for _, stmt := range fn.Body.List {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			continue
+		}
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if ok && exprString(sel.X) == gp && slices.Contains(routeMethods, sel.Sel.Name) {
+			c.route(g, from, to, sel.Sel.Name, call.Args)
+		}
+	}
+}
+
+// route adds the route of a GET, POST... call with args.
+func (c *constructor) route(g group, from, to int, method string, args []ast.Expr) {
+	if len(args) < 2 {
+		return
+	}
+	path, ok := c.p.str(c.f, args[0])
+	if !ok {
+		return
+	}
+	r := Route{
+		Method:      method,
+		Path:        g.path + path,
+		Unversioned: g.unversioned,
+		From:        from,
+		To:          to,
+		Scopes:      append(slices.Clip(g.scopes), c.scopes(args[1:len(args)-1])...),
+	}
+	if r.Unversioned {
+		r.From, r.To = 0, 0
+	}
+	sel, ok := args[len(args)-1].(*ast.SelectorExpr)
+	if ok {
+		r.Handler = c.types[exprString(sel.X)] + "." + sel.Sel.Name
+		if fn, ok := c.p.funcs[r.Handler]; ok {
+			r.Doc = strings.TrimSpace(fn.node.Doc.Text())
+			b := &binder{p: c.p, visited: make(map[string]bool)}
+			b.fn(fn, 0)
+			r.Query, r.Header, r.Body, r.Form = b.query, b.header, b.body, b.form
+		}
+	}
+	c.routes = append(c.routes, r)
+}
+
+// binder collects the fields of the variables a handler binds.
+type binder struct {
+	p       *pkg
+	visited map[string]bool
+
+	query, header, body []Field
+	form                bool
+}
+
+func (b *binder) empty() bool {
+	return len(b.query) == 0 && len(b.header) == 0 && len(b.body) == 0
+}
+
+// fn collects the fields fn binds, or else the first function it calls binds.
+func (b *binder) fn(fn decl[*ast.FuncDecl], depth int) {
+	if fn.node.Body == nil {
+		return
+	}
+	recv := ""
+	if fn.node.Recv != nil && len(fn.node.Recv.List[0].Names) == 1 {
+		recv = fn.node.Recv.List[0].Names[0].Name
+	}
+	locals := make(map[string]ast.Expr)
+	var calls []decl[*ast.FuncDecl]
+	ast.Inspect(fn.node.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ValueSpec:
+			for _, name := range n.Names {
+				if n.Type != nil {
+					locals[name.Name] = n.Type
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				if i < len(n.Rhs) {
+					if t := localType(n.Rhs[i]); t != nil {
+						locals[exprString(lhs)] = t
+					}
+				}
+			}
+		case *ast.CallExpr:
+			if v, form, ok := bound(n); ok {
+				if t, ok := locals[v]; ok {
+					b.bind(fn.file, t, form)
+				}
+				return true
+			}
+			callee := ""
+			switch fun := n.Fun.(type) {
+			case *ast.Ident:
+				callee = fun.Name
+			case *ast.SelectorExpr:
+				if x, ok := fun.X.(*ast.Ident); ok && x.Name == recv && recv != "" {
+					callee = typeName(fn.node.Recv.List[0].Type) + "." + fun.Sel.Name
+				}
+			}
+			if d, ok := b.p.funcs[callee]; ok && !b.visited[callee] {
+				b.visited[callee] = true
+				calls = append(calls, d)
+			}
+		}
+		return true
+	})
+	if depth >= maxDepth {
+		return
+	}
+	for _, call := range calls {
+		sub := &binder{p: b.p, visited: b.visited}
+		sub.fn(call, depth+1)
+		if sub.empty() {
+			continue
+		}
+		b.query = merge(b.query, sub.query)
+		b.header = merge(b.header, sub.header)
+		b.body = merge(b.body, sub.body)
+		b.form = b.form || sub.form
+		return
+	}
+}
+
+// bound returns the variable call binds the request into, and whether it binds a
+// url encoded body.
+func bound(call *ast.CallExpr) (string, bool, bool) {
+	var arg ast.Expr
+	form := false
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if (fun.Name == "bind" || fun.Name == "bindRequest") && len(call.Args) == 2 {
+			arg = call.Args[1]
+		}
+	case *ast.SelectorExpr:
+		switch fun.Sel.Name {
+		case "BindJSON", "ShouldBindJSON", "BindQuery", "ShouldBindQuery", "Bind", "ShouldBind":
+			if len(call.Args) == 1 {
+				arg = call.Args[0]
+			}
+		case "ShouldBindWith", "BindWith":
+			if len(call.Args) == 2 {
+				arg = call.Args[0]
+				form = strings.HasSuffix(exprString(call.Args[1]), "Form") || strings.HasSuffix(exprString(call.Args[1]), "FormPost")
+			}
+		}
+	}
+	if arg == nil {
+		return "", false, false
+	}
+	if u, ok := arg.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		arg = u.X
+	}
+	return exprString(arg), form, true
+}
+
+// localType is the type of a variable assigned e, for new(T), T{} and &T{}.
+func localType(e ast.Expr) ast.Expr {
+	if u, ok := e.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		e = u.X
+	}
+	switch e := e.(type) {
+	case *ast.CompositeLit:
+		return e.Type
+	case *ast.CallExpr:
+		if fun, ok := e.Fun.(*ast.Ident); ok && fun.Name == "new" && len(e.Args) == 1 {
+			return e.Args[0]
+		}
+	}
+	return nil
+}
+
+// bind adds the fields of a bound variable of type t: its json fields to the body,
+// its form fields to the query, or to the body when form is set, and its header
+// fields to the headers.
+func (b *binder) bind(f *ast.File, t ast.Expr, form bool) {
+	st, p, f := resolveStruct(b.p, f, t)
+	if st == nil {
+		return
+	}
+	if form {
+		b.body = merge(b.body, p.fields(f, st, "form", 0))
+		b.form = true
+		return
+	}
+	b.body = merge(b.body, p.fields(f, st, "json", 0))
+	b.query = merge(b.query, p.fields(f, st, "form", 0))
+	b.header = merge(b.header, p.fields(f, st, "header", 0))
+}
+
+// merge appends the fields of more that fields don't have yet.
+func merge(fields, more []Field) []Field {
+	for _, m := range more {
+		if !slices.ContainsFunc(fields, func(f Field) bool { return f.Name == m.Name }) {
+			fields = append(fields, m)
+		}
+	}
+	return fields
+}
+
+// resolveStruct returns the struct type t is, with the package and file declaring it.
+func resolveStruct(p *pkg, f *ast.File, t ast.Expr) (*ast.StructType, *pkg, *ast.File) {
+	for range maxDepth + 1 {
+		switch e := t.(type) {
+		case *ast.StructType:
+			return e, p, f
+		case *ast.StarExpr:
+			t = e.X
+		case *ast.Ident:
+			spec, ok := p.types[e.Name]
+			if !ok {
+				return nil, nil, nil
+			}
+			t, f = spec.node.Type, spec.file
+		case *ast.SelectorExpr:
+			x, ok := e.X.(*ast.Ident)
+			if !ok {
+				return nil, nil, nil
+			}
+			q, ok := p.imported(f, x.Name)
+			if !ok {
+				return nil, nil, nil
+			}
+			spec, ok := q.types[e.Sel.Name]
+			if !ok {
+				return nil, nil, nil
+			}
+			p, t, f = q, spec.node.Type, spec.file
+		default:
+			return nil, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// fields are the fields of st read from the part of the request tagged by tag.
+// json reads untagged exported fields by their name, form and header only tagged
+// ones, like bind.
+func (p *pkg) fields(f *ast.File, st *ast.StructType, tag string, depth int) []Field {
+	var fields []Field
+	for _, field := range st.Fields.List {
+		tags := reflect.StructTag("")
+		if field.Tag != nil {
+			s, err := strconv.Unquote(field.Tag.Value)
+			if err == nil {
+				tags = reflect.StructTag(s)
+			}
+		}
+		name, _, _ := strings.Cut(tags.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		if len(field.Names) == 0 {
+			// embedded structs are inlined in json
+			if tag == "json" && name == "" {
+				if st, q, qf := resolveStruct(p, f, field.Type); st != nil && depth < maxDepth {
+					fields = merge(fields, q.fields(qf, st, tag, depth+1))
+				}
+			}
+			continue
+		}
+		for _, n := range field.Names {
+			if !n.IsExported() {
+				continue
+			}
+			fieldName := name
+			if fieldName == "" {
+				// fields tagged for another part aren't read from the body
+				if tag != "json" || tags.Get("form") != "" || tags.Get("header") != "" || tags.Get("uri") != "" {
+					continue
+				}
+				fieldName = n.Name
+			}
+			out := p.typeOf(f, field.Type, depth)
+			out.Name = fieldName
+			out.Required, out.Enum = validation(tags.Get("binding"))
+			fields = append(fields, out)
+		}
+	}
+	return fields
+}
+
+// validation reads whether a binding tag requires a field and the values it allows.
+// the rules after dive are about the elements.
+func validation(binding string) (bool, []string) {
+	required := false
+	var enum []string
+	for _, rule := range strings.Split(binding, ",") {
+		if rule == "dive" {
+			break
+		}
+		if rule == "required" {
+			required = true
+		}
+		if values, ok := strings.CutPrefix(rule, "oneof="); ok {
+			enum = strings.Fields(values)
+		}
+	}
+	return required, enum
+}
+
+// wellKnown are the types of fields of other packages, by package and name
+var wellKnown = map[string]string{
+	"time.Time":          TypeTime,
+	"time.Duration":      TypeString,
+	"json.RawMessage":    TypeObject,
+	"netip.Prefix":       TypeString,
+	"netip.Addr":         TypeString,
+	"pgtype.Numeric":     TypeNumber,
+	"pgtype.Int2":        TypeInteger,
+	"pgtype.Int4":        TypeInteger,
+	"pgtype.Int8":        TypeInteger,
+	"pgtype.Float8":      TypeNumber,
+	"pgtype.Bool":        TypeBoolean,
+	"pgtype.Text":        TypeString,
+	"pgtype.Date":        TypeTime,
+	"pgtype.Timestamp":   TypeTime,
+	"pgtype.Timestamptz": TypeTime,
+	"pgtype.UUID":        TypeString,
+}
+
+// typeOf is the field of a field of type t, without its name.
+func (p *pkg) typeOf(f *ast.File, t ast.Expr, depth int) Field {
+	switch e := t.(type) {
+	case *ast.StarExpr:
+		return p.typeOf(f, e.X, depth)
+	case *ast.ArrayType:
+		if typeName(e.Elt) == "byte" {
+			return Field{Type: TypeString}
+		}
+		if depth >= maxDepth {
+			return Field{Type: TypeArray}
+		}
+		return Field{Type: TypeArray, Fields: []Field{p.typeOf(f, e.Elt, depth+1)}}
+	case *ast.MapType, *ast.InterfaceType:
+		return Field{Type: TypeObject}
+	case *ast.StructType:
+		if depth >= maxDepth {
+			return Field{Type: TypeObject}
+		}
+		return Field{Type: TypeObject, Fields: p.fields(f, e, "json", depth+1)}
+	case *ast.Ident:
+		switch e.Name {
+		case "string":
+			return Field{Type: TypeString}
+		case "bool":
+			return Field{Type: TypeBoolean}
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+			return Field{Type: TypeInteger}
+		case "float32", "float64":
+			return Field{Type: TypeNumber}
+		case "any":
+			return Field{Type: TypeObject}
+		}
+		if spec, ok := p.types[e.Name]; ok {
+			return p.typeOf(spec.file, spec.node.Type, depth)
+		}
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			if known, ok := wellKnown[x.Name+"."+e.Sel.Name]; ok {
+				return Field{Type: known}
+			}
+			if q, ok := p.imported(f, x.Name); ok {
+				if spec, ok := q.types[e.Sel.Name]; ok {
+					return q.typeOf(spec.file, spec.node.Type, depth)
+				}
+			}
+		}
+	}
+	return Field{Type: TypeString}
+}
+
+// typeName is the name of a type expression without its pointer and package.
+func typeName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.StarExpr:
+		return typeName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	case *ast.IndexExpr:
+		return typeName(e.X)
+	}
+	return ""
+}
+
+// exprString is an identifier or a selector of identifiers, like j.Admin.
+func exprString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		x := exprString(e.X)
+		if x == "" {
+			return ""
+		}
+		return x + "." + e.Sel.Name
+	case *ast.ParenExpr:
+		return exprString(e.X)
+	}
+	return ""
+}
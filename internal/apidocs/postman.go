@@ -0,0 +1,185 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PostmanSchema is the version of the collection format Postman imports
+const PostmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// Collection is a Postman collection, with a folder per resource.
+type Collection struct {
+	Info     Info       `json:"info"`
+	Auth     *Auth      `json:"auth,omitempty"`
+	Variable []Variable `json:"variable"`
+	Item     []Item     `json:"item"`
+}
+
+type Info struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      string `json:"schema"`
+}
+
+type Auth struct {
+	Type   string     `json:"type"`
+	Bearer []Variable `json:"bearer,omitempty"`
+}
+
+// Variable is a key and value of a collection, a url or a body.
+type Variable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+}
+
+// Item is a folder of items or a request.
+type Item struct {
+	Name    string   `json:"name"`
+	Item    []Item   `json:"item,omitempty"`
+	Request *Request `json:"request,omitempty"`
+}
+
+type Request struct {
+	Method      string     `json:"method"`
+	Description string     `json:"description,omitempty"`
+	Auth        *Auth      `json:"auth,omitempty"`
+	Header      []Variable `json:"header"`
+	URL         URL        `json:"url"`
+	Body        *Body      `json:"body,omitempty"`
+}
+
+type URL struct {
+	Raw      string     `json:"raw"`
+	Host     []string   `json:"host"`
+	Path     []string   `json:"path"`
+	Query    []Variable `json:"query,omitempty"`
+	Variable []Variable `json:"variable,omitempty"`
+}
+
+type Body struct {
+	Mode       string       `json:"mode"`
+	Raw        string       `json:"raw,omitempty"`
+	URLEncoded []Variable   `json:"urlencoded,omitempty"`
+	Options    *BodyOptions `json:"options,omitempty"`
+}
+
+type BodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+// Postman is the collection of the routes version n serves. requests go to the
+// baseUrl variable, baseURL by default, with the apiKey variable as bearer
+// token. optional query parameters are disabled.
+func Postman(routes []Route, n, latest int, baseURL string) Collection {
+	c := Collection{
+		Info: Info{
+			Name:        fmt.Sprintf("SMS Gateway v%d", n),
+			Description: "Generated by sms apidocs export. Set baseUrl and apiKey before sending.",
+			Schema:      PostmanSchema,
+		},
+		Auth: &Auth{Type: "bearer", Bearer: []Variable{{Key: "token", Value: "{{apiKey}}", Type: "string"}}},
+		Variable: []Variable{
+			{Key: "baseUrl", Value: baseURL, Type: "string"},
+			{Key: "apiKey", Value: "", Type: "string"},
+		},
+	}
+	folders := make(map[string]int)
+	for _, r := range routes {
+		if !r.Serves(n, latest) {
+			continue
+		}
+		folder := Folder(r)
+		i, ok := folders[folder]
+		if !ok {
+			i = len(c.Item)
+			folders[folder] = i
+			c.Item = append(c.Item, Item{Name: folder})
+		}
+		c.Item[i].Item = append(c.Item[i].Item, Item{Name: r.Name(), Request: postmanRequest(r, n)})
+	}
+	return c
+}
+
+// Folder is the resource r belongs to, the first segment of its path.
+func Folder(r Route) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(r.Path, "/"), "/")
+	return first
+}
+
+func postmanRequest(r Route, n int) *Request {
+	path := r.FullPath(n)
+	req := &Request{
+		Method:      r.Method,
+		Description: description(r),
+		Header:      []Variable{},
+		URL: URL{
+			Host: []string{"{{baseUrl}}"},
+			Path: strings.Split(strings.TrimPrefix(path, "/"), "/"),
+		},
+	}
+	if r.Public() {
+		req.Auth = &Auth{Type: "noauth"}
+	}
+	raw := "{{baseUrl}}" + path
+	for i, q := range r.Query {
+		sep := "&"
+		if i == 0 {
+			sep = "?"
+		}
+		value := fmt.Sprint(q.Example())
+		if q.Required {
+			raw += sep + q.Name + "=" + value
+		}
+		req.URL.Query = append(req.URL.Query, Variable{Key: q.Name, Value: value, Description: q.Type, Disabled: !q.Required})
+	}
+	req.URL.Raw = raw
+	for _, p := range r.Params() {
+		req.URL.Variable = append(req.URL.Variable, Variable{Key: p, Value: ""})
+	}
+	for _, h := range r.Header {
+		req.Header = append(req.Header, Variable{Key: h.Name, Value: fmt.Sprint(h.Example()), Disabled: !h.Required})
+	}
+	if len(r.Body) == 0 {
+		return req
+	}
+	if r.Form {
+		req.Body = &Body{Mode: "urlencoded"}
+		for _, f := range r.Body {
+			req.Body.URLEncoded = append(req.Body.URLEncoded, Variable{Key: f.Name, Value: fmt.Sprint(f.Example()), Type: "text", Disabled: !f.Required})
+		}
+		return req
+	}
+	b, _ := json.MarshalIndent(Example(r.Body), "", "  ")
+	req.Header = append(req.Header, Variable{Key: "Content-Type", Value: "application/json"})
+	req.Body = &Body{Mode: "raw", Raw: string(b), Options: &BodyOptions{}}
+	req.Body.Options.Raw.Language = "json"
+	return req
+}
+
+// description is the doc of r with the scopes and the fields it requires.
+func description(r Route) string {
+	var parts []string
+	if r.Doc != "" {
+		parts = append(parts, strings.Join(strings.Fields(r.Doc), " "))
+	}
+	if len(r.Scopes) > 0 {
+		parts = append(parts, "Requires "+strings.Join(r.Scopes, ", ")+".")
+	}
+	var required []string
+	for _, f := range r.Body {
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	if len(required) > 0 {
+		parts = append(parts, "Required fields: "+strings.Join(required, ", ")+".")
+	}
+	return strings.Join(parts, "\n\n")
+}
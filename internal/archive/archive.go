@@ -0,0 +1,189 @@
+// Package archive copies the history of sms to the storage as parquet files, for
+// analytics to query without going through the operational database.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Row is an archived sms. the day it was created and its user are the partitions
+// of its file, see Key, so they aren't repeated in it.
+type Row struct {
+	ID            int32      `parquet:"id"`
+	PhoneNumberID int32      `parquet:"phone_number_id"`
+	ToPhoneNumber string     `parquet:"to_phone_number"`
+	Country       string     `parquet:"country,dict"`
+	Status        string     `parquet:"status,dict"`
+	Category      string     `parquet:"category,dict"`
+	Priority      string     `parquet:"priority,dict"`
+	Cost          float64    `parquet:"cost"`
+	ExternalID    *string    `parquet:"external_id,optional"`
+	Tags          []string   `parquet:"tags,list"`
+	CreatedAt     time.Time  `parquet:"created_at,timestamp(millisecond)"`
+	DeliveredAt   *time.Time `parquet:"delivered_at,optional,timestamp(millisecond)"`
+}
+
+func newRow(sms sqlc.ListSmsToArchiveRow) Row {
+	cost, _ := sms.Cost.Float64Value()
+	row := Row{
+		ID:            sms.ID,
+		PhoneNumberID: sms.PhoneNumberID,
+		ToPhoneNumber: sms.ToPhoneNumber,
+		Country:       phone.CountryCode(sms.ToPhoneNumber),
+		Status:        sms.Status,
+		Category:      sms.Category,
+		Priority:      sms.Priority,
+		Cost:          cost.Float64,
+		Tags:          sms.Tags,
+		CreatedAt:     sms.CreatedAt.Time,
+	}
+	if sms.ExternalID.Valid {
+		row.ExternalID = &sms.ExternalID.String
+	}
+	if sms.DeliveredAt.Valid {
+		row.DeliveredAt = &sms.DeliveredAt.Time
+	}
+	return row
+}
+
+// Key is where the sms of userID created on day are archived under prefix, hive
+// partitioned so DuckDB and Spark prune by date and user, e.g.
+// "archive/sms/date=2026-10-16/user_id=3/sms.parquet".
+func Key(prefix string, day time.Time, userID int32) string {
+	return path.Join(prefix, "date="+day.Format(time.DateOnly), fmt.Sprintf("user_id=%d", userID), "sms.parquet")
+}
+
+// Archiver writes the sms created on a day to the storage, a file per user.
+type Archiver struct {
+	q         *sqlc.Queries
+	store     storage.Storage
+	prefix    string
+	batchSize int32
+}
+
+func NewArchiver(q *sqlc.Queries, store storage.Storage, prefix string, batchSize int32) *Archiver {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	return &Archiver{
+		q:         q,
+		store:     store,
+		prefix:    prefix,
+		batchSize: batchSize,
+	}
+}
+
+// Day archives the sms created on day, in UTC, and returns how many it wrote to
+// how many files. archiving a day again replaces its files.
+func (a *Archiver) Day(ctx context.Context, day time.Time) (rows int64, files int32, err error) {
+	since := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	until := since.AddDate(0, 0, 1)
+	var file *userFile
+	defer func() {
+		if file != nil {
+			file.discard()
+		}
+	}()
+	var afterUser, afterID int32
+	for {
+		batch, err := a.q.ListSmsToArchive(ctx, sqlc.ListSmsToArchiveParams{
+			Since:       pgtype.Timestamp{Time: since, Valid: true},
+			Until:       pgtype.Timestamp{Time: until, Valid: true},
+			AfterUserID: afterUser,
+			AfterID:     afterID,
+			BatchSize:   a.batchSize,
+		})
+		if err != nil {
+			return rows, files, err
+		}
+		for _, sms := range batch {
+			if file != nil && file.userID != sms.UserID {
+				err = a.upload(ctx, file, since)
+				file = nil
+				if err != nil {
+					return rows, files, err
+				}
+				files++
+			}
+			if file == nil {
+				file, err = newUserFile(sms.UserID)
+				if err != nil {
+					return rows, files, err
+				}
+			}
+			_, err = file.writer.Write([]Row{newRow(sms)})
+			if err != nil {
+				return rows, files, err
+			}
+			rows++
+		}
+		if len(batch) < int(a.batchSize) {
+			break
+		}
+		last := batch[len(batch)-1]
+		afterUser, afterID = last.UserID, last.ID
+	}
+	if file != nil {
+		err = a.upload(ctx, file, since)
+		file = nil
+		if err != nil {
+			return rows, files, err
+		}
+		files++
+	}
+	return rows, files, nil
+}
+
+func (a *Archiver) upload(ctx context.Context, file *userFile, day time.Time) error {
+	defer file.discard()
+	err := file.writer.Close()
+	if err != nil {
+		return err
+	}
+	_, err = file.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return a.store.Put(ctx, Key(a.prefix, day, file.userID), file.f, "application/vnd.apache.parquet")
+}
+
+// userFile is the parquet file of a user being written, in a temporary file
+// because the footer is only known once all rows are.
+type userFile struct {
+	userID int32
+	f      *os.File
+	writer *parquet.GenericWriter[Row]
+}
+
+func newUserFile(userID int32) (*userFile, error) {
+	f, err := os.CreateTemp("", "archive-*.parquet")
+	if err != nil {
+		return nil, err
+	}
+	return &userFile{
+		userID: userID,
+		f:      f,
+		writer: NewWriter(f),
+	}, nil
+}
+
+func (u *userFile) discard() {
+	u.f.Close()
+	os.Remove(u.f.Name())
+}
+
+// NewWriter writes archived rows to w as a zstd compressed parquet file.
+func NewWriter(w io.Writer) *parquet.GenericWriter[Row] {
+	return parquet.NewGenericWriter[Row](w, parquet.Compression(&parquet.Zstd))
+}
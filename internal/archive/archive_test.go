@@ -0,0 +1,68 @@
+package archive_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/parquet-go/parquet-go"
+
+	. "github.com/alireza-karampour/sms/internal/archive"
+)
+
+var _ = Describe("Archive", func() {
+	It("should partition the files by date and user", func() {
+		day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+		Expect(Key("archive/sms", day, 3)).To(Equal("archive/sms/date=2026-10-16/user_id=3/sms.parquet"))
+	})
+
+	It("should write rows parquet readers read back", func() {
+		created := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+		delivered := created.Add(4 * time.Second)
+		ref := "order-1"
+		rows := []Row{
+			{
+				ID:            1,
+				PhoneNumberID: 2,
+				ToPhoneNumber: "+989120000000",
+				Country:       "98",
+				Status:        "delivered",
+				Category:      "transactional",
+				Priority:      "express",
+				Cost:          5,
+				ExternalID:    &ref,
+				Tags:          []string{"otp"},
+				CreatedAt:     created,
+				DeliveredAt:   &delivered,
+			},
+			{
+				ID:            2,
+				PhoneNumberID: 2,
+				ToPhoneNumber: "+14155550100",
+				Country:       "1",
+				Status:        "pending",
+				Category:      "marketing",
+				Priority:      "normal",
+				Cost:          0.5,
+				CreatedAt:     created,
+			},
+		}
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err := w.Write(rows)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		read, err := parquet.Read[Row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(HaveLen(2))
+		Expect(read[0].ExternalID).To(HaveValue(Equal("order-1")))
+		Expect(read[0].DeliveredAt).To(HaveValue(BeTemporally("==", delivered)))
+		Expect(read[0].Tags).To(Equal([]string{"otp"}))
+		Expect(read[1].ExternalID).To(BeNil())
+		Expect(read[1].DeliveredAt).To(BeNil())
+		Expect(read[1].CreatedAt).To(BeTemporally("==", created))
+		Expect(read[1].Cost).To(Equal(0.5))
+	})
+})
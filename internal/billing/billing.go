@@ -0,0 +1,157 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LineItem sums the charges of one priority class to one destination country.
+type LineItem struct {
+	Priority string
+	// Country is the calling code of the destination, "" when it is unknown
+	Country  string
+	Quantity int64
+	Cents    int64
+}
+
+// Period returns the calendar month (UTC) t falls in. end is exclusive.
+func Period(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// LineItems groups usage per priority class and destination country, ordered like invoice_items.
+func LineItems(usage []sqlc.GetUsageForInvoiceRow) []LineItem {
+	type key struct{ priority, country string }
+	sums := map[key]*LineItem{}
+	for _, u := range usage {
+		k := key{u.Priority, phone.CountryCode(u.ToPhoneNumber)}
+		item, ok := sums[k]
+		if !ok {
+			item = &LineItem{Priority: k.priority, Country: k.country}
+			sums[k] = item
+		}
+		item.Quantity += u.Quantity
+		item.Cents += u.Cents
+	}
+	items := make([]LineItem, 0, len(sums))
+	for _, item := range sums {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority < items[j].Priority
+		}
+		return items[i].Country < items[j].Country
+	})
+	return items
+}
+
+// Generate closes the month starting at start for userID. created is false when
+// the invoice already existed, so running it twice for a period is harmless.
+func Generate(ctx context.Context, pool *pgxpool.Pool, userID int32, start time.Time) (invoice sqlc.Invoice, created bool, err error) {
+	start, end := Period(start)
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		usage, err := q.GetUsageForInvoice(ctx, sqlc.GetUsageForInvoiceParams{
+			UserID:      userID,
+			PeriodStart: Date(start),
+			PeriodEnd:   Date(end),
+		})
+		if err != nil {
+			return err
+		}
+		items := LineItems(usage)
+		var messages, total int64
+		for _, item := range items {
+			messages += item.Quantity
+			total += item.Cents
+		}
+
+		invoice, err = q.AddInvoice(ctx, sqlc.AddInvoiceParams{
+			UserID:       userID,
+			PeriodStart:  Date(start),
+			PeriodEnd:    Date(end.AddDate(0, 0, -1)),
+			MessageCount: messages,
+			Total:        Amount(total),
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			err = q.AddInvoiceItem(ctx, sqlc.AddInvoiceItemParams{
+				InvoiceID: invoice.ID,
+				Priority:  item.Priority,
+				Country:   item.Country,
+				Quantity:  item.Quantity,
+				Amount:    Amount(item.Cents),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// another worker got there first
+		return sqlc.Invoice{}, false, nil
+	}
+	if err != nil {
+		return sqlc.Invoice{}, false, err
+	}
+	return invoice, true, nil
+}
+
+func Date(t time.Time) pgtype.Date {
+	return pgtype.Date{Time: t, Valid: true}
+}
+
+// Amount turns cents into a DECIMAL(12, 2).
+func Amount(cents int64) pgtype.Numeric {
+	return pgtype.Numeric{Int: big.NewInt(cents), Exp: -2, Valid: true}
+}
+
+// Cents is the inverse of Amount, truncating anything below a cent.
+func Cents(n pgtype.Numeric) int64 {
+	if !n.Valid || n.Int == nil {
+		return 0
+	}
+	v := new(big.Int).Set(n.Int)
+	exp := n.Exp + 2
+	for ; exp > 0; exp-- {
+		v.Mul(v, big.NewInt(10))
+	}
+	for ; exp < 0; exp++ {
+		v.Quo(v, big.NewInt(10))
+	}
+	return v.Int64()
+}
+
+// FormatCents renders cents as a decimal amount, e.g. 1250 as "12.50".
+func FormatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// CountryLabel renders a calling code of a line item for people.
+func CountryLabel(code string) string {
+	if code == "" {
+		return "unknown"
+	}
+	return "+" + code
+}
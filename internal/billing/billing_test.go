@@ -0,0 +1,99 @@
+package billing_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var _ = Describe("Billing", func() {
+	It("should bill calendar months", func() {
+		start, end := Period(time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC))
+		Expect(start).To(Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+		Expect(end).To(Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should group usage per priority and country", func() {
+		items := LineItems([]sqlc.GetUsageForInvoiceRow{
+			{Priority: "normal", ToPhoneNumber: "+491701234567", Quantity: 2, Cents: 1000},
+			{Priority: "normal", ToPhoneNumber: "00491709999999", Quantity: 1, Cents: 500},
+			{Priority: "express", ToPhoneNumber: "+989121234567", Quantity: 1, Cents: 750},
+			{Priority: "normal", ToPhoneNumber: "0999", Quantity: 3, Cents: 1500},
+		})
+		Expect(items).To(Equal([]LineItem{
+			{Priority: "express", Country: "98", Quantity: 1, Cents: 750},
+			{Priority: "normal", Country: "", Quantity: 3, Cents: 1500},
+			{Priority: "normal", Country: "49", Quantity: 3, Cents: 1500},
+		}))
+	})
+
+	It("should convert amounts without losing cents", func() {
+		Expect(Cents(Amount(1250))).To(BeEquivalentTo(1250))
+		var n pgtype.Numeric
+		Expect(n.Scan("12.5")).To(Succeed())
+		Expect(Cents(n)).To(BeEquivalentTo(1250))
+		Expect(FormatCents(1205)).To(Equal("12.05"))
+		Expect(FormatCents(-5)).To(Equal("-0.05"))
+	})
+
+	Describe("Rendering", func() {
+		statement := Statement{
+			Invoice: sqlc.Invoice{
+				ID:           3,
+				UserID:       7,
+				PeriodStart:  Date(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)),
+				PeriodEnd:    Date(time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)),
+				MessageCount: 4,
+				Total:        Amount(2000),
+			},
+			Username: "alice (test)",
+			Items: []sqlc.InvoiceItem{
+				{Priority: "express", Country: "98", Quantity: 1, Amount: Amount(500)},
+				{Priority: "normal", Country: "", Quantity: 3, Amount: Amount(1500)},
+			},
+		}
+
+		It("should write a row per line item", func() {
+			var buf bytes.Buffer
+			Expect(WriteCSV(&buf, statement)).To(Succeed())
+			rows, err := csv.NewReader(&buf).ReadAll()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rows).To(HaveLen(3))
+			Expect(rows[1]).To(Equal([]string{"3", "2024-05-01", "2024-05-31", "express", "+98", "1", "5.00"}))
+			Expect(rows[2][4]).To(Equal("unknown"))
+		})
+
+		It("should write a well formed pdf", func() {
+			var buf bytes.Buffer
+			Expect(WritePDF(&buf, statement)).To(Succeed())
+			out := buf.String()
+			Expect(out).To(HavePrefix("%PDF-1.4"))
+			Expect(out).To(HaveSuffix("%%EOF\n"))
+			Expect(out).To(ContainSubstring(`Customer: alice \(test\) \(id 7\)`))
+			Expect(out).To(ContainSubstring("20.00"))
+
+			// every xref entry has to point at its object
+			xref := out[strings.LastIndex(out, "xref\n"):]
+			entries := strings.Split(xref, "\n")[3:]
+			for i, entry := range entries {
+				if !strings.HasSuffix(entry, " n ") {
+					break
+				}
+				var off int
+				_, err := fmt.Sscan(entry, &off)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(out[off:]).To(HavePrefix(strconv.Itoa(i+1) + " 0 obj"))
+			}
+		})
+	})
+})
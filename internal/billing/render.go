@@ -0,0 +1,74 @@
+package billing
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+const dateLayout = "2006-01-02"
+
+// Statement is an invoice together with everything needed to render it.
+type Statement struct {
+	Invoice  sqlc.Invoice
+	Username string
+	Items    []sqlc.InvoiceItem
+}
+
+var csvHeader = []string{"invoice_id", "period_start", "period_end", "priority", "country", "messages", "amount"}
+
+// WriteCSV writes one row per line item.
+func WriteCSV(w io.Writer, s Statement) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, item := range s.Items {
+		err = cw.Write([]string{
+			strconv.Itoa(int(s.Invoice.ID)),
+			s.Invoice.PeriodStart.Time.Format(dateLayout),
+			s.Invoice.PeriodEnd.Time.Format(dateLayout),
+			item.Priority,
+			CountryLabel(item.Country),
+			strconv.FormatInt(item.Quantity, 10),
+			FormatCents(Cents(item.Amount)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WritePDF renders the statement as a plain monospaced document.
+func WritePDF(w io.Writer, s Statement) error {
+	row := "%-12s %-10s %10s %14s"
+	lines := []string{
+		fmt.Sprintf("INVOICE #%d", s.Invoice.ID),
+		"",
+		fmt.Sprintf("Customer: %s (id %d)", s.Username, s.Invoice.UserID),
+		fmt.Sprintf("Period:   %s - %s", s.Invoice.PeriodStart.Time.Format(dateLayout), s.Invoice.PeriodEnd.Time.Format(dateLayout)),
+		fmt.Sprintf("Issued:   %s", s.Invoice.CreatedAt.Time.Format(dateLayout)),
+		"",
+		fmt.Sprintf(row, "Priority", "Country", "Messages", "Amount"),
+		fmt.Sprintf(row, "--------", "-------", "--------", "------"),
+	}
+	for _, item := range s.Items {
+		lines = append(lines, fmt.Sprintf(row,
+			item.Priority,
+			CountryLabel(item.Country),
+			strconv.FormatInt(item.Quantity, 10),
+			FormatCents(Cents(item.Amount)),
+		))
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf(row, "Total", "", strconv.FormatInt(s.Invoice.MessageCount, 10), FormatCents(Cents(s.Invoice.Total))),
+	)
+	return writePDF(w, lines)
+}
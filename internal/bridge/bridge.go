@@ -0,0 +1,170 @@
+// Package bridge mirrors the published lifecycle events of sms to Kafka, for
+// data platforms built around Kafka rather than NATS.
+package bridge
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/spf13/viper"
+)
+
+// Any routes the events no other route names.
+const Any = "*"
+
+// Producer writes messages to Kafka, kafka.Writer is one.
+type Producer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Bridge writes events to the Kafka topic of their route, keyed by their sms so
+// the events of an sms keep their order. it is an eventsink.Writer.
+type Bridge struct {
+	producer Producer
+	registry *Registry
+	codec    Codec
+	// topics maps event names, or Any, to topics
+	topics map[string]string
+}
+
+func New(producer Producer, registry *Registry, codec Codec, topics map[string]string) *Bridge {
+	return &Bridge{
+		producer: producer,
+		registry: registry,
+		codec:    codec,
+		topics:   topics,
+	}
+}
+
+// Topic is the topic the events named event are written to, "" when they aren't.
+func (b *Bridge) Topic(event string) string {
+	if topic, ok := b.topics[event]; ok {
+		return topic
+	}
+	return b.topics[Any]
+}
+
+// Write writes the events with a topic and skips the others. events of a batch
+// written again after a failure may be in Kafka twice, consumers dedupe on the
+// sms-event-id header.
+func (b *Bridge) Write(ctx context.Context, batch []events.Published) error {
+	msgs := make([]kafka.Message, 0, len(batch))
+	for _, e := range batch {
+		topic := b.Topic(e.Event)
+		if topic == "" {
+			continue
+		}
+		id, err := b.registry.ID(ctx, topic, b.codec)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Topic: topic,
+			Key:   []byte(strconv.Itoa(int(e.SmsID))),
+			Value: b.codec.Encode(id, e),
+			Headers: []kafka.Header{
+				{Key: "sms-event-id", Value: []byte(e.ID)},
+			},
+			Time: e.OccurredAt,
+		})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return b.producer.WriteMessages(ctx, msgs...)
+}
+
+// Close closes the producer, if it can be closed.
+func (b *Bridge) Close() error {
+	if c, ok := b.producer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// FromViper builds the bridge of kafka.bridge, nil when it isn't enabled.
+func FromViper(ctx context.Context) (*Bridge, error) {
+	if !viper.GetBool("kafka.bridge.enabled") {
+		return nil, nil
+	}
+	topics := viper.GetStringMapString("kafka.bridge.topics")
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("kafka.bridge.topics routes no events")
+	}
+	codec, err := NewCodec(viper.GetString("kafka.bridge.format"))
+	if err != nil {
+		return nil, err
+	}
+	transport, err := transportFromViper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	brokers := viper.GetStringSlice("kafka.brokers")
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("kafka.brokers is empty")
+	}
+	producer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// the bridge hands over whole batches, don't wait for more
+		BatchSize:    max(viper.GetInt("kafka.bridge.batchsize"), 1),
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    transport,
+	}
+	registryPassword, err := secrets.Get(ctx, "kafka.registry.password")
+	if err != nil {
+		return nil, err
+	}
+	registry := NewRegistry(
+		viper.GetString("kafka.registry.url"),
+		viper.GetString("kafka.registry.username"),
+		registryPassword,
+		viper.GetBool("kafka.registry.autoregister"),
+	)
+	return New(producer, registry, codec, topics), nil
+}
+
+// transportFromViper reads kafka.tls and kafka.sasl.{mechanism,username,password}.
+func transportFromViper(ctx context.Context) (*kafka.Transport, error) {
+	transport := &kafka.Transport{}
+	if viper.GetBool("kafka.tls") {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	mechanism := strings.ToLower(viper.GetString("kafka.sasl.mechanism"))
+	if mechanism == "" {
+		return transport, nil
+	}
+	username := viper.GetString("kafka.sasl.username")
+	password, err := secrets.Get(ctx, "kafka.sasl.password")
+	if err != nil {
+		return nil, err
+	}
+	var m sasl.Mechanism
+	switch mechanism {
+	case "plain":
+		m = plain.Mechanism{Username: username, Password: password}
+	case "scram-sha-256":
+		m, err = scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		m, err = scram.Mechanism(scram.SHA512, username, password)
+	default:
+		err = fmt.Errorf("unknown kafka.sasl.mechanism %q", mechanism)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = m
+	return transport, nil
+}
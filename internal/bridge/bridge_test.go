@@ -0,0 +1,173 @@
+package bridge_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/bridge"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var event = events.Published{
+	ID:         "42-delivered-1",
+	SmsID:      42,
+	Event:      events.Delivered,
+	Actor:      "worker:1",
+	Provider:   "vonage",
+	Metadata:   json.RawMessage(`{"from":"submitted"}`),
+	OccurredAt: time.Date(2026, 10, 16, 9, 30, 0, 123456000, time.UTC),
+}
+
+type fakeProducer struct {
+	msgs []kafka.Message
+}
+
+func (p *fakeProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+// fakeRegistry answers every request with the id of its subject.
+func fakeRegistry(ids map[string]int32, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		*requests = append(*requests, r.URL.Path+" "+body["schemaType"])
+		for subject, id := range ids {
+			if r.URL.Path == "/subjects/"+subject || r.URL.Path == "/subjects/"+subject+"/versions" {
+				json.NewEncoder(w).Encode(map[string]any{"id": id})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error_code":40401,"message":"Subject not found"}`))
+	}))
+}
+
+var _ = Describe("Codecs", func() {
+	It("should encode avro in the order of the schema", func() {
+		b := Avro{}.Encode(7, event)
+		Expect(b[0]).To(BeZero())
+		Expect(binary.BigEndian.Uint32(b[1:5])).To(BeEquivalentTo(7))
+		b = b[5:]
+		str := func() string {
+			n, size := binary.Varint(b)
+			s := string(b[size : size+int(n)])
+			b = b[size+int(n):]
+			return s
+		}
+		long := func() int64 {
+			v, size := binary.Varint(b)
+			b = b[size:]
+			return v
+		}
+		Expect(str()).To(Equal("42-delivered-1"))
+		Expect(long()).To(BeEquivalentTo(42))
+		Expect(str()).To(Equal("delivered"))
+		Expect(str()).To(Equal("worker:1"))
+		Expect(str()).To(Equal("vonage"))
+		Expect(str()).To(BeEmpty())
+		Expect(str()).To(Equal(`{"from":"submitted"}`))
+		Expect(long()).To(Equal(event.OccurredAt.UnixMicro()))
+		Expect(b).To(BeEmpty())
+	})
+
+	It("should encode protobuf after the message indexes", func() {
+		b := Protobuf{}.Encode(7, event)
+		Expect(binary.BigEndian.Uint32(b[1:5])).To(BeEquivalentTo(7))
+		Expect(b[5]).To(BeZero())
+		b = b[6:]
+		fields := map[protowire.Number]any{}
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			Expect(n).To(BeNumerically(">", 0))
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeString(b)
+				fields[num], b = v, b[n:]
+			case protowire.VarintType:
+				v, n := protowire.ConsumeVarint(b)
+				fields[num], b = int64(v), b[n:]
+			}
+		}
+		Expect(fields).To(Equal(map[protowire.Number]any{
+			1: "42-delivered-1",
+			2: int64(42),
+			3: "delivered",
+			4: "worker:1",
+			5: "vonage",
+			7: `{"from":"submitted"}`,
+			8: event.OccurredAt.UnixMicro(),
+		}))
+	})
+
+	It("should refuse unknown formats", func() {
+		_, err := NewCodec("thrift")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Registry", func() {
+	It("should register the schema once per topic", func() {
+		var requests []string
+		srv := fakeRegistry(map[string]int32{"sms-status-value": 3}, &requests)
+		defer srv.Close()
+		r := NewRegistry(srv.URL, "", "", true)
+		for range 2 {
+			id, err := r.ID(context.Background(), "sms-status", Protobuf{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(BeEquivalentTo(3))
+		}
+		Expect(requests).To(Equal([]string{"/subjects/sms-status-value/versions PROTOBUF"}))
+	})
+
+	It("should only look schemas up without autoregister", func() {
+		var requests []string
+		srv := fakeRegistry(map[string]int32{}, &requests)
+		defer srv.Close()
+		r := NewRegistry(srv.URL, "", "", false)
+		_, err := r.ID(context.Background(), "sms-status", Avro{})
+		Expect(err).To(MatchError(ContainSubstring("Subject not found")))
+		Expect(requests).To(Equal([]string{"/subjects/sms-status-value "}))
+	})
+})
+
+var _ = Describe("Bridge", func() {
+	It("should write routed events to their topic keyed by sms", func() {
+		var requests []string
+		srv := fakeRegistry(map[string]int32{"sms-status-value": 1, "sms-events-value": 2}, &requests)
+		defer srv.Close()
+		p := &fakeProducer{}
+		b := New(p, NewRegistry(srv.URL, "", "", true), Avro{}, map[string]string{
+			events.Delivered: "sms-status",
+			Any:              "sms-events",
+		})
+		created := event
+		created.Event = events.Created
+		Expect(b.Write(context.Background(), []events.Published{event, created})).To(Succeed())
+		Expect(p.msgs).To(HaveLen(2))
+		Expect(p.msgs[0].Topic).To(Equal("sms-status"))
+		Expect(string(p.msgs[0].Key)).To(Equal("42"))
+		Expect(p.msgs[0].Headers[0].Value).To(BeEquivalentTo("42-delivered-1"))
+		Expect(p.msgs[1].Topic).To(Equal("sms-events"))
+		Expect(binary.BigEndian.Uint32(p.msgs[1].Value[1:5])).To(BeEquivalentTo(2))
+	})
+
+	It("should skip events without a route", func() {
+		p := &fakeProducer{}
+		b := New(p, NewRegistry("http://127.0.0.1:0", "", "", true), Avro{}, map[string]string{events.Failed: "sms-status"})
+		Expect(b.Topic(events.Delivered)).To(BeEmpty())
+		Expect(b.Write(context.Background(), []events.Published{event})).To(Succeed())
+		Expect(p.msgs).To(BeEmpty())
+	})
+})
@@ -0,0 +1,132 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec encodes events in the wire format of the Confluent schema registry: a
+// zero magic byte, the id of the schema as a big endian int32, then the payload.
+type Codec interface {
+	// SchemaType is the schemaType the registry knows the schema by
+	SchemaType() string
+	Schema() string
+	Encode(schemaID int32, e events.Published) []byte
+}
+
+// NewCodec returns the codec of format: "avro" or "protobuf".
+func NewCodec(format string) (Codec, error) {
+	switch format {
+	case "", "avro":
+		return Avro{}, nil
+	case "protobuf":
+		return Protobuf{}, nil
+	default:
+		return nil, fmt.Errorf("unknown kafka.bridge.format %q", format)
+	}
+}
+
+func header(schemaID int32) []byte {
+	b := make([]byte, 5, 128)
+	binary.BigEndian.PutUint32(b[1:], uint32(schemaID))
+	return b
+}
+
+// AvroSchema is the schema of the events in avro. metadata is the JSON of the
+// event's metadata, occurred_at is in microseconds since the epoch.
+const AvroSchema = `{"type":"record","name":"SmsEvent","namespace":"sms.events","fields":[` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"sms_id","type":"int"},` +
+	`{"name":"event","type":"string"},` +
+	`{"name":"actor","type":"string"},` +
+	`{"name":"provider","type":"string","default":""},` +
+	`{"name":"request_id","type":"string","default":""},` +
+	`{"name":"metadata","type":"string","default":"{}"},` +
+	`{"name":"occurred_at","type":{"type":"long","logicalType":"timestamp-micros"}}]}`
+
+type Avro struct{}
+
+func (Avro) SchemaType() string { return "AVRO" }
+func (Avro) Schema() string     { return AvroSchema }
+
+// Encode writes the fields in the order of AvroSchema. avro ints, longs and the
+// lengths of strings are zigzag varints, as binary.AppendVarint writes them.
+func (Avro) Encode(schemaID int32, e events.Published) []byte {
+	b := header(schemaID)
+	str := func(s string) {
+		b = binary.AppendVarint(b, int64(len(s)))
+		b = append(b, s...)
+	}
+	str(e.ID)
+	b = binary.AppendVarint(b, int64(e.SmsID))
+	str(e.Event)
+	str(e.Actor)
+	str(e.Provider)
+	str(e.RequestID)
+	str(metadata(e))
+	b = binary.AppendVarint(b, e.OccurredAt.UnixMicro())
+	return b
+}
+
+// ProtobufSchema is the schema of the events in protobuf, with the same fields
+// as AvroSchema.
+const ProtobufSchema = `syntax = "proto3";
+package sms.events;
+
+message SmsEvent {
+  string id = 1;
+  int32 sms_id = 2;
+  string event = 3;
+  string actor = 4;
+  string provider = 5;
+  string request_id = 6;
+  // JSON of the event's metadata
+  string metadata = 7;
+  // microseconds since the epoch
+  int64 occurred_at = 8;
+}
+`
+
+type Protobuf struct{}
+
+func (Protobuf) SchemaType() string { return "PROTOBUF" }
+func (Protobuf) Schema() string     { return ProtobufSchema }
+
+// Encode writes the message indexes of SmsEvent, the first message of the
+// schema, which the registry's format shortens to a single 0, then the message.
+func (Protobuf) Encode(schemaID int32, e events.Published) []byte {
+	b := append(header(schemaID), 0)
+	str := func(num protowire.Number, s string) {
+		if s == "" {
+			return
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	integer := func(num protowire.Number, v int64) {
+		if v == 0 {
+			return
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+	str(1, e.ID)
+	integer(2, int64(e.SmsID))
+	str(3, e.Event)
+	str(4, e.Actor)
+	str(5, e.Provider)
+	str(6, e.RequestID)
+	str(7, metadata(e))
+	integer(8, e.OccurredAt.UnixMicro())
+	return b
+}
+
+func metadata(e events.Published) string {
+	if len(e.Metadata) == 0 {
+		return "{}"
+	}
+	return string(e.Metadata)
+}
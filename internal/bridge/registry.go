@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry resolves the ids of schemas in a Confluent compatible schema
+// registry, under the subject "<topic>-value" of the topic they are written to.
+type Registry struct {
+	url      string
+	username string
+	password string
+	// register registers schemas the registry doesn't know yet instead of failing
+	register bool
+	client   *http.Client
+
+	mu  sync.Mutex
+	ids map[string]int32
+}
+
+func NewRegistry(url, username, password string, register bool) *Registry {
+	return &Registry{
+		url:      strings.TrimSuffix(url, "/"),
+		username: username,
+		password: password,
+		register: register,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		ids:      map[string]int32{},
+	}
+}
+
+// ID returns the id of codec's schema for the values of topic. ids are
+// remembered, the registry never changes the id of a schema.
+func (r *Registry) ID(ctx context.Context, topic string, codec Codec) (int32, error) {
+	subject := topic + "-value"
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.ids[subject]; ok {
+		return id, nil
+	}
+	// POST /subjects/{subject} looks the schema up, .../versions registers it
+	path := "/subjects/" + url.PathEscape(subject)
+	if r.register {
+		path += "/versions"
+	}
+	body := map[string]string{"schema": codec.Schema()}
+	if t := codec.SchemaType(); t != "AVRO" {
+		body["schemaType"] = t
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	var result struct {
+		ID      int32  `json:"id"`
+		Message string `json:"message"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry responded with %s for %s: %s", res.Status, subject, result.Message)
+	}
+	if err != nil {
+		return 0, err
+	}
+	r.ids[subject] = result.ID
+	return result.ID, nil
+}
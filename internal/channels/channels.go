@@ -0,0 +1,210 @@
+package channels
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// channels messages can be sent on
+const (
+	Sms   = SMS
+	Email = EMAIL
+	Push  = PUSH
+	Voice = VOICE
+
+	WhatsApp = WHATSAPP
+	Telegram = TELEGRAM
+)
+
+// All lists every channel, Sms first.
+var All = []string{Sms, Email, Push, Voice, WhatsApp, Telegram}
+
+// statuses of email and push notifications. they are sent synchronously by the
+// workers, so unlike sms they are final as soon as they are stored. voice calls
+// go through the sms statuses instead, from submitted once the call was placed.
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+var (
+	ErrUnknownChannel = errors.New("unknown channel")
+	ErrRecipient      = errors.New("invalid recipient")
+)
+
+// Notification is published on the request subject of its channel to hand an email,
+// push notification, voice call or chat message to the workers.
+type Notification struct {
+	UserID  int32  `json:"user_id"`
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Message string `json:"message"`
+	// Template, Params and MediaURL are only sent on whatsapp and telegram, see pkg/chat
+	Template string   `json:"template,omitempty"`
+	Params   []string `json:"params,omitempty"`
+	MediaURL string   `json:"media_url,omitempty"`
+	// SmsID is the sms this notification falls back for, if any
+	SmsID int32 `json:"sms_id,omitempty"`
+}
+
+// Fallback is where the message of an sms goes when the sms fails.
+type Fallback struct {
+	Channel string `json:"channel" binding:"required,oneof=email push voice whatsapp telegram"`
+	To      string `json:"to" binding:"required,max=255"`
+	Subject string `json:"subject" binding:"max=255"`
+	// After, in seconds, also fires the fallback when the sms isn't delivered by
+	// then, e.g. to read an OTP in a voice call. 0 only falls back on failure.
+	After int32 `json:"after" binding:"min=0,max=86400"`
+}
+
+// FallbackCheck is published on FallbackSubject when an sms with Fallback.After is
+// stored. the worker holds it back until Due and then fires the fallback unless the
+// sms was delivered.
+type FallbackCheck struct {
+	SmsID int32     `json:"sms_id"`
+	Due   time.Time `json:"due"`
+}
+
+// SmsRequest is published on the sms request subjects: the sms and its fallback.
+type SmsRequest struct {
+	sqlc.Sm
+	Fallback *Fallback `json:"fallback,omitempty"`
+	// OTP is the one-time code in the message, if it carries one. it isn't stored,
+	// only handed to the carrier, see carrier.Message.
+	OTP string `json:"otp,omitempty"`
+	// Provider is the driver the sms is submitted through instead of the one its
+	// destination is routed to, admins set it to debug a carrier
+	Provider string `json:"provider,omitempty"`
+}
+
+// telegramChat is a chat id, negative for groups, or the @username of a public channel
+var telegramChat = regexp.MustCompile(`^(-?[0-9]{1,20}|@[A-Za-z][A-Za-z0-9_]{4,31})$`)
+
+// Validate checks that to can receive messages on channel: an email address for
+// email, a device token for push, a phone number for voice and whatsapp and a chat
+// for telegram.
+func Validate(channel, to string) error {
+	switch channel {
+	case Email:
+		addr, err := mail.ParseAddress(to)
+		if err != nil || addr.Address != to {
+			return fmt.Errorf("%w: %q is not an email address", ErrRecipient, to)
+		}
+		return nil
+	case Push:
+		if to == "" {
+			return fmt.Errorf("%w: empty device token", ErrRecipient)
+		}
+		return nil
+	case Voice, WhatsApp:
+		if n := len(phone.Normalize(to)); n < 7 || n > 15 {
+			return fmt.Errorf("%w: %q is not a phone number", ErrRecipient, to)
+		}
+		return nil
+	case Telegram:
+		if !telegramChat.MatchString(to) {
+			return fmt.Errorf("%w: %q is not a telegram chat", ErrRecipient, to)
+		}
+		return nil
+	case Sms:
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownChannel, channel)
+	}
+}
+
+// RequestSubject is where notifications of channel are queued, ErrChannel for
+// channels without a work queue.
+func RequestSubject(channel string) (string, error) {
+	return ChannelSendReq(Channel(channel))
+}
+
+// StatusSubject is where status updates of channel are published. only voice calls
+// have a status after they were sent.
+func StatusSubject(channel string) (string, error) {
+	return ChannelStat(Channel(channel))
+}
+
+// FallbackSubject is where FallbackChecks are queued.
+var FallbackSubject = SmsFallback()
+
+// NotificationStreams are the work queues of every channel but sms, for publishers.
+func NotificationStreams() []jetstream.StreamConfig {
+	return []jetstream.StreamConfig{EmailStream(), PushStream(), VoiceStream(), WhatsAppStream(), TelegramStream()}
+}
+
+// EmailStream is the work queue of email notifications.
+func EmailStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        EMAIL_CONSUMER_NAME,
+		Description: "work queue for sending email",
+		Subjects:    []string{Must(ChannelSendReq(EmailChannel))},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// PushStream is the work queue of push notifications.
+func PushStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        PUSH_CONSUMER_NAME,
+		Description: "work queue for sending push notifications",
+		Subjects:    []string{Must(ChannelSendReq(PushChannel))},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// VoiceStream is the work queue of voice calls and their status updates.
+func VoiceStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        VOICE_CONSUMER_NAME,
+		Description: "work queue for placing voice calls",
+		Subjects:    []string{Must(ChannelSendReq(VoiceChannel)), Must(ChannelStat(VoiceChannel))},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// FallbackStream holds the FallbackChecks of sms waiting to be delivered.
+func FallbackStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        FALLBACK_CONSUMER_NAME,
+		Description: "sms whose fallback fires unless they are delivered in time",
+		Subjects:    []string{FallbackSubject},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// WhatsAppStream is the work queue of whatsapp messages.
+func WhatsAppStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        WHATSAPP_CONSUMER_NAME,
+		Description: "work queue for sending whatsapp messages",
+		Subjects:    []string{Must(ChannelSendReq(WhatsAppChannel))},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// TelegramStream is the work queue of telegram messages.
+func TelegramStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        TELEGRAM_CONSUMER_NAME,
+		Description: "work queue for sending telegram messages",
+		Subjects:    []string{Must(ChannelSendReq(TelegramChannel))},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
@@ -0,0 +1,13 @@
+package channels_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestChannels(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Channels Suite")
+}
@@ -0,0 +1,52 @@
+package channels_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/subjects"
+)
+
+var _ = Describe("Channels", func() {
+	DescribeTable("Validate",
+		func(channel, to string, valid bool) {
+			err := channels.Validate(channel, to)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("email address", channels.Email, "user@example.com", true),
+		Entry("email with a display name", channels.Email, "User <user@example.com>", false),
+		Entry("not an email", channels.Email, "+989121234567", false),
+		Entry("device token", channels.Push, "fcm:abc", true),
+		Entry("empty device token", channels.Push, "", false),
+		Entry("phone number", channels.Voice, "+98 912 123 4567", true),
+		Entry("too short for a phone number", channels.Voice, "12345", false),
+		Entry("whatsapp number", channels.WhatsApp, "+15550101", true),
+		Entry("telegram chat id", channels.Telegram, "-100123456", true),
+		Entry("telegram channel", channels.Telegram, "@sms_gateway", true),
+		Entry("telegram phone number", channels.Telegram, "+15550101", false),
+		Entry("unknown channel", "fax", "123", false),
+	)
+
+	It("queues email and push on their own subjects", func() {
+		Expect(channels.RequestSubject(channels.Email)).To(Equal("email.send.request"))
+		_, err := channels.RequestSubject(channels.Sms)
+		Expect(err).To(MatchError(subjects.ErrChannel))
+		Expect(channels.PushStream().Subjects).To(Equal([]string{"push.send.request"}))
+		Expect(channels.VoiceStream().Subjects).To(Equal([]string{"voice.send.request", "voice.send.status"}))
+		Expect(channels.FallbackStream().Subjects).To(Equal([]string{"sms.fallback"}))
+	})
+
+	It("reads sms requests queued without a fallback", func() {
+		var req channels.SmsRequest
+		Expect(json.Unmarshal([]byte(`{"user_id":1,"to_phone_number":"+1555","message":"hi"}`), &req)).To(Succeed())
+		Expect(req.ToPhoneNumber).To(Equal("+1555"))
+		Expect(req.Fallback).To(BeNil())
+	})
+})
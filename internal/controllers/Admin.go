@@ -0,0 +1,1129 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/internal/providers"
+	"github.com/alireza-karampour/sms/internal/slo"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	mynats "github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrRequestNotFound  = errors.New("no sms events for this request id")
+	ErrNotSuspended     = errors.New("account is not suspended")
+	ErrProviderNotFound = errors.New("provider account not found")
+	ErrProviderExists   = errors.New("the user has a provider account of this name")
+	ErrProviderDefault  = errors.New("another provider account of the user is the default")
+	ErrInvalidPrefix    = errors.New("prefixes must contain digits")
+	ErrUnknownPriority  = errors.New("priority must be normal or express")
+	ErrNoQuotaPolicy    = errors.New("the user has no quota policy for this priority")
+)
+
+// failedStatuses are the sms statuses counted as errors in the stats
+var failedStatuses = []string{"expired", "failed"}
+
+type Admin struct {
+	*Base
+	cluster *db.Cluster
+	nb      *mynats.Manager
+	// envelope seals the credentials of provider accounts, nil when no master key
+	// is configured
+	envelope *secrets.Envelope
+}
+
+type sloWindowView struct {
+	Window    string  `json:"window"`
+	Delivered int64   `json:"delivered"`
+	Slow      int64   `json:"slow"`
+	P50Ms     int64   `json:"p50_ms"`
+	P95Ms     int64   `json:"p95_ms"`
+	P99Ms     int64   `json:"p99_ms"`
+	BurnRate  float64 `json:"burn_rate"`
+}
+
+type sloView struct {
+	LatencyMs int64           `json:"latency_ms"`
+	Target    float64         `json:"target"`
+	Windows   []sloWindowView `json:"windows"`
+}
+
+// workerView is a worker as of its last heartbeat. it is alive while it sent
+// one within api.workers.timeout.
+type workerView struct {
+	InstanceID string            `json:"instance_id"`
+	Hostname   string            `json:"hostname"`
+	Version    string            `json:"version"`
+	Alive      bool              `json:"alive"`
+	StartedAt  time.Time         `json:"started_at"`
+	LastSeenAt time.Time         `json:"last_seen_at"`
+	Processed  int64             `json:"processed"`
+	Lag        map[string]uint64 `json:"lag"`
+	TotalLag   uint64            `json:"total_lag"`
+}
+
+type backlogStats struct {
+	Pending     uint64 `json:"pending"`
+	AckPending  int    `json:"ack_pending"`
+	Redelivered int    `json:"redelivered"`
+}
+
+func NewAdmin(parent *Versions, cluster *db.Cluster, nc *nats.Conn, envelope *secrets.Envelope) (*Admin, error) {
+	base := NewBase("/admin", parent, middlewares.WriteErrorBody)
+	nb, err := mynats.NewManager(context.Background(), nc,
+		mynats.WithReconcile(mynats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		mynats.WithStreams(ParkedStream()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	admin := &Admin{
+		Base:     base,
+		cluster:  cluster,
+		nb:       nb,
+		envelope: envelope,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("/stats", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.GetStats)
+		gp.GET("/requests/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.GetRequest)
+		gp.POST("/users/:id/suspend", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.SuspendUser)
+		gp.DELETE("/users/:id/suspend", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.ResumeUser)
+		gp.POST("/users/:id/close", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.CloseUser)
+		gp.POST("/replay", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.Replay)
+		gp.PUT("/users/:id/limits", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.SetUserLimits)
+		gp.PUT("/users/:id/express-admission", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.SetExpressAdmission)
+		gp.GET("/users/:id/quotas", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.GetQuotaPolicies)
+		gp.PUT("/users/:id/quotas/:priority", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.SetQuotaPolicy)
+		gp.DELETE("/users/:id/quotas/:priority", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.DeleteQuotaPolicy)
+		gp.PUT("/users/:id/reseller", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.EnableReseller)
+		gp.DELETE("/users/:id/reseller", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.DisableReseller)
+		gp.GET("/fraud/alerts", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.ListFraudAlerts)
+		gp.GET("/errors", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.ListErrors)
+		gp.GET("/workers", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.ListWorkers)
+		gp.GET("/slo", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.GetSlo)
+		gp.GET("/providers", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.ListProviders)
+		gp.POST("/providers", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.AddProvider)
+		gp.POST("/providers/rewrap", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.RewrapProviders)
+		gp.POST("/forwards/rewrap", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.RewrapForwards)
+		gp.GET("/providers/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.GetProvider)
+		gp.PUT("/providers/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.UpdateProvider)
+		gp.DELETE("/providers/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.DeleteProvider)
+		gp.PUT("/providers/:id/routing", middlewares.RequireScopes(auth.ScopeUserAdmin), admin.SetProviderRouting)
+	})
+
+	return admin, nil
+}
+
+func (a *Admin) GetStats(ctx *gin.Context) {
+	q := sqlc.New(a.cluster.Reader())
+
+	perMinute, err := q.CountSmsLastMinuteByPriority(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	rate := map[string]int64{"normal": 0, "express": 0}
+	for _, r := range perMinute {
+		rate[r.Priority] = r.Count
+	}
+
+	byStatus, err := q.CountSmsLastHourByStatus(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	statuses := make(map[string]int64, len(byStatus))
+	var total, failed int64
+	for _, r := range byStatus {
+		statuses[r.Status] = r.Count
+		total += r.Count
+		if slices.Contains(failedStatuses, r.Status) {
+			failed += r.Count
+		}
+	}
+	var errorRate float64
+	if total > 0 {
+		errorRate = float64(failed) / float64(total)
+	}
+
+	topSenders, err := q.GetTopSendersToday(ctx, 10)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if topSenders == nil {
+		topSenders = []sqlc.GetTopSendersTodayRow{}
+	}
+
+	revenue, err := q.GetRevenueToday(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	backlog := make(map[string]backlogStats, 2)
+	for priority, name := range map[string]string{
+		"normal":  NORMAL_SMS_CONSUMER_NAME,
+		"express": EXPRESS_SMS_CONSUMER_NAME,
+	} {
+		cons, err := a.nb.Consumer(ctx, name, name)
+		if errors.Is(err, jetstream.ErrStreamNotFound) || errors.Is(err, jetstream.ErrConsumerNotFound) {
+			backlog[priority] = backlogStats{}
+			continue
+		}
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		info, err := cons.Info(ctx)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		backlog[priority] = backlogStats{
+			Pending:     info.NumPending,
+			AckPending:  info.NumAckPending,
+			Redelivered: info.NumRedelivered,
+		}
+	}
+
+	ctx.JSON(200, gin.H{
+		"messages_per_minute": rate,
+		"status_last_hour":    statuses,
+		"error_rate":          errorRate,
+		"backlog":             backlog,
+		"top_senders":         topSenders,
+		"revenue_today":       revenue,
+	})
+}
+
+type requestSmsView struct {
+	SmsID  int32          `json:"sms_id"`
+	Events []smsEventView `json:"events"`
+}
+
+// GetRequest traces an X-Request-ID: the full history of every sms the request
+// sent or changed, including what happened after it in the workers.
+func (a *Admin) GetRequest(ctx *gin.Context) {
+	id := ctx.Param("id")
+	history, err := sqlc.New(a.cluster.Reader()).GetSmsEventsByRequestId(ctx, pgtype.Text{String: id, Valid: true})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if len(history) == 0 {
+		ctx.AbortWithError(http.StatusNotFound, ErrRequestNotFound)
+		return
+	}
+	sms := []requestSmsView{}
+	for _, e := range history {
+		if len(sms) == 0 || sms[len(sms)-1].SmsID != e.SmsID {
+			sms = append(sms, requestSmsView{SmsID: e.SmsID})
+		}
+		last := &sms[len(sms)-1]
+		last.Events = append(last.Events, newSmsEventView(e))
+	}
+	ctx.JSON(200, gin.H{
+		"request_id": id,
+		"sms":        sms,
+	})
+}
+
+// SuspendUser refuses the sends of a user with 403 and parks their queued messages
+// until they are resumed.
+func (a *Admin) SuspendUser(ctx *gin.Context) {
+	user, ok := a.setStatus(ctx, policy.AccountSuspended, policy.AccountActive, policy.AccountSuspended)
+	if !ok {
+		return
+	}
+	ctx.JSON(200, newUserView(user))
+}
+
+// ResumeUser reactivates a suspended user and queues their parked messages again.
+func (a *Admin) ResumeUser(ctx *gin.Context) {
+	user, ok := a.setStatus(ctx, policy.AccountActive, policy.AccountSuspended)
+	if !ok {
+		return
+	}
+	n, err := Resume(ctx, a.nb.JetStream, user.ID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"user":    newUserView(user),
+		"resumed": n,
+	})
+}
+
+// CloseUser closes a user's account for good and drops their parked messages.
+func (a *Admin) CloseUser(ctx *gin.Context) {
+	user, ok := a.setStatus(ctx, policy.AccountClosed, policy.AccountActive, policy.AccountSuspended)
+	if !ok {
+		return
+	}
+	err := Discard(ctx, a.nb.JetStream, user.ID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(200, newUserView(user))
+}
+
+// Replay publishes the messages the audit stream kept of a work queue between
+// from and to again, see streams.Replay.
+func (a *Admin) Replay(ctx *gin.Context) {
+	var req struct {
+		Stream string    `json:"stream" binding:"required"`
+		From   time.Time `json:"from" binding:"required"`
+		To     time.Time `json:"to"`
+	}
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if !req.To.IsZero() && req.To.Before(req.From) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("to is before from"))
+		return
+	}
+	id := events.NewMessageID()
+	n, err := Replay(ctx, a.nb.JetStream, ReplayParams{ID: id, Stream: req.Stream, From: req.From, To: req.To})
+	switch {
+	case errors.Is(err, ErrReplayStream):
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrNoAudit):
+		ctx.AbortWithError(http.StatusConflict, err)
+		return
+	case err != nil:
+		ctx.AbortWithError(http.StatusBadGateway, fmt.Errorf("replay %s stopped after %d messages: %w", id, n, err))
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"replay_id": id,
+		"replayed":  n,
+	})
+}
+
+// SetUserLimits sets how many messages a user may send to one number per hour. a
+// null recipient_hourly_limit falls back to api.flood.limit, 0 lifts the limit.
+func (a *Admin) SetUserLimits(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		RecipientHourlyLimit *int32 `json:"recipient_hourly_limit" binding:"omitempty,min=0"`
+	}
+	err = ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	arg := sqlc.SetRecipientLimitParams{ID: int32(id)}
+	if req.RecipientHourlyLimit != nil {
+		arg.RecipientHourlyLimit = pgtype.Int4{Int32: *req.RecipientHourlyLimit, Valid: true}
+	}
+	user, err := sqlc.New(a.cluster.Writer()).SetRecipientLimit(ctx, arg)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newUserView(user))
+}
+
+// SetExpressAdmission sets what happens to the express sms of a user while the
+// express queue is backed up, see policy.ExpressAdmission. a null policy falls
+// back to api.express.admission.policy.
+func (a *Admin) SetExpressAdmission(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		Policy *string `json:"policy"`
+	}
+	err = ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	arg := sqlc.SetExpressAdmissionParams{ID: int32(id)}
+	if req.Policy != nil {
+		p, err := policy.ParseAdmission(*req.Policy)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		arg.ExpressAdmission = pgtype.Text{String: p, Valid: true}
+	}
+	user, err := sqlc.New(a.cluster.Writer()).SetExpressAdmission(ctx, arg)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newUserView(user))
+}
+
+type quotaPolicyView struct {
+	Priority        string    `json:"priority"`
+	Limit           int32     `json:"limit"`
+	WindowSeconds   int32     `json:"window_seconds"`
+	BurstCredits    int32     `json:"burst_credits"`
+	RechargeSeconds int32     `json:"recharge_seconds"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func newQuotaPolicyView(p sqlc.QuotaPolicy) quotaPolicyView {
+	return quotaPolicyView{
+		Priority:        p.Priority,
+		Limit:           p.SmsLimit,
+		WindowSeconds:   p.WindowSeconds,
+		BurstCredits:    p.BurstCredits,
+		RechargeSeconds: p.RechargeSeconds,
+		UpdatedAt:       p.UpdatedAt.Time,
+	}
+}
+
+// quotaPolicyParams parses the user id and the priority of the quota policy routes.
+func quotaPolicyParams(ctx *gin.Context) (int32, string, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return 0, "", false
+	}
+	priority := ctx.Param("priority")
+	if priority != "normal" && priority != "express" {
+		ctx.AbortWithError(http.StatusBadRequest, ErrUnknownPriority)
+		return 0, "", false
+	}
+	return int32(id), priority, true
+}
+
+func (a *Admin) GetQuotaPolicies(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	policies, err := sqlc.New(a.cluster.Reader()).GetQuotaPolicies(ctx, int32(id))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]quotaPolicyView, 0, len(policies))
+	for _, p := range policies {
+		views = append(views, newQuotaPolicyView(p))
+	}
+	ctx.JSON(200, views)
+}
+
+// SetQuotaPolicy caps the sms a user sends with a priority to limit per window,
+// with burst credits to go over it for a while. see Sms.checkRateQuota.
+func (a *Admin) SetQuotaPolicy(ctx *gin.Context) {
+	id, priority, ok := quotaPolicyParams(ctx)
+	if !ok {
+		return
+	}
+	var req struct {
+		Limit           int32 `json:"limit" binding:"required,min=1"`
+		WindowSeconds   int32 `json:"window_seconds" binding:"required,min=1,max=86400"`
+		BurstCredits    int32 `json:"burst_credits" binding:"min=0"`
+		RechargeSeconds int32 `json:"recharge_seconds" binding:"omitempty,min=1,max=86400"`
+	}
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if req.RechargeSeconds == 0 {
+		req.RechargeSeconds = 60
+	}
+	p, err := sqlc.New(a.cluster.Writer()).SetQuotaPolicy(ctx, sqlc.SetQuotaPolicyParams{
+		Priority:        priority,
+		SmsLimit:        req.Limit,
+		WindowSeconds:   req.WindowSeconds,
+		BurstCredits:    req.BurstCredits,
+		RechargeSeconds: req.RechargeSeconds,
+		UserID:          id,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newQuotaPolicyView(p))
+}
+
+func (a *Admin) DeleteQuotaPolicy(ctx *gin.Context) {
+	id, priority, ok := quotaPolicyParams(ctx)
+	if !ok {
+		return
+	}
+	n, err := sqlc.New(a.cluster.Writer()).DeleteQuotaPolicy(ctx, sqlc.DeleteQuotaPolicyParams{
+		UserID:   id,
+		Priority: priority,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if n == 0 {
+		ctx.AbortWithError(http.StatusNotFound, ErrNoQuotaPolicy)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// EnableReseller lets a user manage customers of its own, see Reseller. its keys
+// need the reseller:admin scope for it.
+func (a *Admin) EnableReseller(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	q := sqlc.New(a.cluster.Writer())
+	_, err = q.GetUserStatus(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	reseller, err := q.AddReseller(ctx, int32(id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(http.StatusConflict, ErrResellerAccount)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newResellerView(reseller))
+}
+
+// DisableReseller takes the reseller rights of a user away. its customers keep
+// their prices, senders and balances, and pay sms.cost where they had no price.
+func (a *Admin) DisableReseller(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	n, err := sqlc.New(a.cluster.Writer()).DeleteReseller(ctx, int32(id))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if n == 0 {
+		ctx.AbortWithError(http.StatusNotFound, ErrNotReseller)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+type fraudAlertView struct {
+	ID        int32     `json:"id"`
+	ApiKeyID  int32     `json:"api_key_id"`
+	UserID    int32     `json:"user_id"`
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListFraudAlerts pages through the keys the fraud detector flagged, newest first.
+func (a *Admin) ListFraudAlerts(ctx *gin.Context) {
+	var query struct {
+		Before int32 `form:"before" binding:"min=0"`
+		Limit  int32 `form:"limit" binding:"omitempty,min=1,max=500"`
+	}
+	err := ctx.BindQuery(&query)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	if query.Before == 0 {
+		query.Before = math.MaxInt32
+	}
+	// one more than asked tells whether there is a next page
+	alerts, err := sqlc.New(a.cluster.Reader()).ListFraudAlerts(ctx, sqlc.ListFraudAlertsParams{
+		Before: query.Before,
+		Lim:    query.Limit + 1,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := gin.H{}
+	if len(alerts) > int(query.Limit) {
+		alerts = alerts[:query.Limit]
+		res["next"] = alerts[len(alerts)-1].ID
+	}
+	views := make([]fraudAlertView, 0, len(alerts))
+	for _, alert := range alerts {
+		views = append(views, fraudAlertView{
+			ID:        alert.ID,
+			ApiKeyID:  alert.ApiKeyID,
+			UserID:    alert.UserID,
+			Reason:    alert.Reason,
+			Detail:    alert.Detail,
+			Action:    alert.Action,
+			CreatedAt: alert.CreatedAt.Time,
+		})
+	}
+	res["alerts"] = views
+	ctx.JSON(200, res)
+}
+
+type workerErrorView struct {
+	ID         int32     `json:"id"`
+	MessageID  string    `json:"message_id"`
+	Subject    string    `json:"subject"`
+	SmsID      *int32    `json:"sms_id,omitempty"`
+	Priority   string    `json:"priority"`
+	Stage      string    `json:"stage"`
+	Class      string    `json:"class"`
+	Error      string    `json:"error"`
+	Attempt    int32     `json:"attempt"`
+	Final      bool      `json:"final"`
+	Worker     string    `json:"worker"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ListErrors pages through the failures the workers reported, newest first,
+// optionally of one stage, class or sms.
+func (a *Admin) ListErrors(ctx *gin.Context) {
+	var query struct {
+		Before int32  `form:"before" binding:"min=0"`
+		Limit  int32  `form:"limit" binding:"omitempty,min=1,max=500"`
+		Stage  string `form:"stage" binding:"max=32"`
+		Class  string `form:"class" binding:"max=32"`
+		SmsID  int32  `form:"sms_id" binding:"min=0"`
+	}
+	err := ctx.BindQuery(&query)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	if query.Before == 0 {
+		query.Before = math.MaxInt32
+	}
+	failures, err := sqlc.New(a.cluster.Reader()).ListWorkerErrors(ctx, sqlc.ListWorkerErrorsParams{
+		Before: query.Before,
+		Stage:  pgtype.Text{String: query.Stage, Valid: query.Stage != ""},
+		Class:  pgtype.Text{String: query.Class, Valid: query.Class != ""},
+		SmsID:  pgtype.Int4{Int32: query.SmsID, Valid: query.SmsID != 0},
+		Lim:    query.Limit + 1,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := gin.H{}
+	if len(failures) > int(query.Limit) {
+		failures = failures[:query.Limit]
+		res["next"] = failures[len(failures)-1].ID
+	}
+	views := make([]workerErrorView, 0, len(failures))
+	for _, f := range failures {
+		view := workerErrorView{
+			ID:         f.ID,
+			MessageID:  f.MessageID,
+			Subject:    f.Subject,
+			Priority:   f.Priority,
+			Stage:      f.Stage,
+			Class:      f.Class,
+			Error:      f.Error,
+			Attempt:    f.Attempt,
+			Final:      f.Final,
+			Worker:     f.Worker,
+			OccurredAt: f.OccurredAt.Time,
+		}
+		if f.SmsID.Valid {
+			view.SmsID = &f.SmsID.Int32
+		}
+		views = append(views, view)
+	}
+	res["errors"] = views
+	ctx.JSON(200, res)
+}
+
+// ListWorkers answers with the workers that sent a heartbeat within
+// api.workers.retention, only the alive ones with alive=true.
+func (a *Admin) ListWorkers(ctx *gin.Context) {
+	var query struct {
+		Alive bool `form:"alive"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	now := time.Now().UTC()
+	since := pgtype.Timestamp{Time: now.Add(-viper.GetDuration("api.workers.retention")), Valid: true}
+	workers, err := sqlc.New(a.cluster.Reader()).ListWorkers(ctx, since)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	timeout := viper.GetDuration("api.workers.timeout")
+	views := make([]workerView, 0, len(workers))
+	alive := 0
+	for _, w := range workers {
+		view := workerView{
+			InstanceID: w.InstanceID,
+			Hostname:   w.Hostname,
+			Version:    w.Version,
+			Alive:      now.Sub(w.LastSeenAt.Time) <= timeout,
+			StartedAt:  w.StartedAt.Time,
+			LastSeenAt: w.LastSeenAt.Time,
+			Processed:  w.Processed,
+		}
+		err = json.Unmarshal(w.Lag, &view.Lag)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		for _, n := range view.Lag {
+			view.TotalLag += n
+		}
+		if view.Alive {
+			alive++
+		} else if query.Alive {
+			continue
+		}
+		views = append(views, view)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"workers": views,
+		"alive":   alive,
+	})
+}
+
+// setStatus moves the user of the :id param to status, answering 409 unless they
+// are in one of from.
+func (a *Admin) setStatus(ctx *gin.Context, status string, from ...string) (sqlc.User, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return sqlc.User{}, false
+	}
+	q := sqlc.New(a.cluster.Writer())
+	current, err := q.GetUserStatus(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return sqlc.User{}, false
+	}
+	if !slices.Contains(from, current) {
+		err = policy.AccountError(current)
+		if current == policy.AccountActive {
+			err = ErrNotSuspended
+		}
+		ctx.AbortWithError(http.StatusConflict, err)
+		return sqlc.User{}, false
+	}
+	user, err := q.SetUserStatus(ctx, sqlc.SetUserStatusParams{Status: status, ID: int32(id)})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return sqlc.User{}, false
+	}
+	return user, true
+}
+
+// GetSlo reports the end-to-end latency of the sms delivered within each of
+// slo.windows by priority, and how fast the slow ones burn the error budget of the
+// priority's objective.
+func (a *Admin) GetSlo(ctx *gin.Context) {
+	q := sqlc.New(a.cluster.Reader())
+	now := time.Now().UTC()
+	windows := slo.WindowsFromViper()
+	priorities := make(map[string]sloView)
+	for priority, o := range slo.ObjectivesFromViper("normal", "express") {
+		view := sloView{
+			LatencyMs: o.Latency.Milliseconds(),
+			Target:    o.Target,
+			Windows:   make([]sloWindowView, 0, len(windows)),
+		}
+		for _, w := range windows {
+			l, err := q.GetDeliveryLatency(ctx, sqlc.GetDeliveryLatencyParams{
+				Threshold: o.Latency.Seconds(),
+				Since:     pgtype.Timestamp{Time: now.Add(-w), Valid: true},
+				Priority:  priority,
+			})
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			view.Windows = append(view.Windows, sloWindowView{
+				Window:    w.String(),
+				Delivered: l.Delivered,
+				Slow:      l.Slow,
+				P50Ms:     int64(l.P50 * 1000),
+				P95Ms:     int64(l.P95 * 1000),
+				P99Ms:     int64(l.P99 * 1000),
+				BurnRate:  o.BurnRate(l.Delivered, l.Slow),
+			})
+		}
+		priorities[priority] = view
+	}
+	ctx.JSON(http.StatusOK, gin.H{"priorities": priorities})
+}
+
+type providerView struct {
+	ID     int32  `json:"id"`
+	UserID int32  `json:"user_id"`
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	// Credentials are the credentials with their secrets masked
+	Credentials json.RawMessage `json:"credentials"`
+	// Prefixes are the destinations the sms of the user go through the account to,
+	// all the others when Default
+	Prefixes  []string  `json:"prefixes"`
+	Default   bool      `json:"default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// providerView opens the credentials of account to show them redacted.
+func (a *Admin) providerView(ctx context.Context, account sqlc.ProviderAccount) (providerView, error) {
+	credentials, err := providers.Open(ctx, a.envelope, account)
+	if err != nil {
+		return providerView{}, err
+	}
+	redacted, err := carrier.RedactAccount(account.Driver, credentials)
+	if err != nil {
+		return providerView{}, err
+	}
+	return providerView{
+		ID:          account.ID,
+		UserID:      account.UserID,
+		Name:        account.Name,
+		Driver:      account.Driver,
+		Credentials: redacted,
+		Prefixes:    account.Prefixes,
+		Default:     account.IsDefault,
+		CreatedAt:   account.CreatedAt.Time,
+		UpdatedAt:   account.UpdatedAt.Time,
+	}, nil
+}
+
+// requireEnvelope answers 501 unless a master key is configured.
+func (a *Admin) requireEnvelope(ctx *gin.Context) bool {
+	if a.envelope == nil {
+		ctx.AbortWithError(http.StatusNotImplemented, secrets.ErrNoMasterKey)
+		return false
+	}
+	return true
+}
+
+// ListProviders lists the provider accounts of the user_id query parameter.
+func (a *Admin) ListProviders(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required,min=1"`
+	}
+	err := ctx.BindQuery(&query)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	accounts, err := sqlc.New(a.cluster.Reader()).ListProviderAccounts(ctx, query.UserID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]providerView, 0, len(accounts))
+	for _, account := range accounts {
+		view, err := a.providerView(ctx, account)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		views = append(views, view)
+	}
+	ctx.JSON(http.StatusOK, gin.H{"providers": views})
+}
+
+// normalizePrefixes keeps the digits of the routing prefixes, like carrier.Route.
+func normalizePrefixes(prefixes []string) ([]string, error) {
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		digits := phone.Normalize(prefix)
+		if digits == "" {
+			return nil, ErrInvalidPrefix
+		}
+		if !slices.Contains(normalized, digits) {
+			normalized = append(normalized, digits)
+		}
+	}
+	return normalized, nil
+}
+
+// AddProvider registers a carrier account of a user. the credentials are the
+// config of the driver, they are validated and stored sealed. the sms of the user
+// are routed through it by prefixes, or all of them when it is the default.
+func (a *Admin) AddProvider(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	var req struct {
+		UserID      int32           `json:"user_id" binding:"required,min=1"`
+		Name        string          `json:"name" binding:"required,max=64,alphanum"`
+		Driver      string          `json:"driver" binding:"required"`
+		Credentials json.RawMessage `json:"credentials" binding:"required"`
+		Prefixes    []string        `json:"prefixes"`
+		Default     bool            `json:"default"`
+	}
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	prefixes, err := normalizePrefixes(req.Prefixes)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = carrier.ValidateAccount(req.Driver, req.Credentials)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	q := sqlc.New(a.cluster.Writer())
+	_, err = q.GetUserStatus(ctx, req.UserID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	sealed, err := providers.Seal(ctx, a.envelope, req.UserID, req.Name, req.Credentials)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	account, err := q.AddProviderAccount(ctx, sqlc.AddProviderAccountParams{
+		UserID:      req.UserID,
+		Name:        req.Name,
+		Driver:      req.Driver,
+		Credentials: sealed.Ciphertext,
+		DataKey:     sealed.DataKey,
+		Prefixes:    prefixes,
+		IsDefault:   req.Default,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, providerConflict(err))
+		return
+	}
+	view, err := a.providerView(ctx, account)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, view)
+}
+
+func (a *Admin) GetProvider(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	account, err := sqlc.New(a.cluster.Reader()).GetProviderAccount(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrProviderNotFound, nil)
+		return
+	}
+	view, err := a.providerView(ctx, account)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+// UpdateProvider replaces the credentials of a provider account, sealing them with
+// a new data key.
+func (a *Admin) UpdateProvider(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		Credentials json.RawMessage `json:"credentials" binding:"required"`
+	}
+	err = ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	q := sqlc.New(a.cluster.Writer())
+	account, err := q.GetProviderAccount(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrProviderNotFound, nil)
+		return
+	}
+	err = carrier.ValidateAccount(account.Driver, req.Credentials)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	sealed, err := providers.Seal(ctx, a.envelope, account.UserID, account.Name, req.Credentials)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	account, err = q.UpdateProviderAccount(ctx, sqlc.UpdateProviderAccountParams{
+		ID:          account.ID,
+		Credentials: sealed.Ciphertext,
+		DataKey:     sealed.DataKey,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrProviderNotFound, nil)
+		return
+	}
+	view, err := a.providerView(ctx, account)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+func (a *Admin) DeleteProvider(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	n, err := sqlc.New(a.cluster.Writer()).DeleteProviderAccount(ctx, int32(id))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if n == 0 {
+		ctx.AbortWithError(http.StatusNotFound, ErrProviderNotFound)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// SetProviderRouting replaces the prefixes routed through a provider account and
+// whether the other destinations of its user are. workers pick the change up
+// within worker.byop.cachettl.
+func (a *Admin) SetProviderRouting(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		Prefixes []string `json:"prefixes"`
+		Default  bool     `json:"default"`
+	}
+	err = ctx.ShouldBindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	prefixes, err := normalizePrefixes(req.Prefixes)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	account, err := sqlc.New(a.cluster.Writer()).SetProviderRouting(ctx, sqlc.SetProviderRoutingParams{
+		ID:        int32(id),
+		Prefixes:  prefixes,
+		IsDefault: req.Default,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrProviderNotFound, ErrProviderDefault)
+		return
+	}
+	view, err := a.providerView(ctx, account)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+// providerConflict tells which of the unique constraints of provider_accounts err
+// violates.
+func providerConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "provider_accounts_default_idx" {
+		return ErrProviderDefault
+	}
+	return ErrProviderExists
+}
+
+// RewrapProviders wraps the data keys of all provider accounts with the current
+// master key, after it was rotated.
+func (a *Admin) RewrapProviders(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	n, err := providers.Rewrap(ctx, sqlc.New(a.cluster.Writer()), a.envelope)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rewrapped": n})
+}
+
+// RewrapForwards wraps the data keys of the credentials of all forwarding targets
+// with the current master key, like RewrapProviders.
+func (a *Admin) RewrapForwards(ctx *gin.Context) {
+	if !a.requireEnvelope(ctx) {
+		return
+	}
+	n, err := forwards.Rewrap(ctx, sqlc.New(a.cluster.Writer()), a.envelope)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rewrapped": n})
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/alerts"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+// Alert manages the usage alert rules of the users and lists the alerts they
+// fired. the worker evaluates the rules, see workers.Alerter.
+type Alert struct {
+	*Base
+	cluster *db.Cluster
+}
+
+type alertRuleView struct {
+	ID        int32      `json:"id"`
+	UserID    int32      `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Threshold float64    `json:"threshold"`
+	Email     string     `json:"email,omitempty"`
+	FiredAt   *time.Time `json:"fired_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func newAlertRuleView(r sqlc.AlertRule) alertRuleView {
+	v := alertRuleView{
+		ID:        r.ID,
+		UserID:    r.UserID,
+		Kind:      r.Kind,
+		Threshold: r.Threshold,
+		Email:     r.Email.String,
+		CreatedAt: r.CreatedAt.Time,
+	}
+	if r.FiredAt.Valid {
+		v.FiredAt = &r.FiredAt.Time
+	}
+	return v
+}
+
+type alertView struct {
+	ID        int32     `json:"id"`
+	RuleID    *int32    `json:"rule_id"`
+	Kind      string    `json:"kind"`
+	Threshold float64   `json:"threshold"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewAlert(parent *Versions, cluster *db.Cluster) *Alert {
+	base := NewBase("/alerts", parent, middlewares.WriteErrorBody)
+	a := &Alert{
+		base,
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), a.ListAlerts)
+		gp.GET("/rules", middlewares.RequireScopes(auth.ScopeUserRead), a.GetRules)
+		gp.POST("/rules", middlewares.RequireScopes(auth.ScopeUserWrite), a.AddRule)
+		gp.DELETE("/rules/:id", middlewares.RequireScopes(auth.ScopeUserWrite), a.DeleteRule)
+	})
+
+	return a
+}
+
+func (a *Alert) AddRule(ctx *gin.Context) {
+	var req struct {
+		UserID    int32   `json:"user_id" binding:"required"`
+		Kind      string  `json:"kind" binding:"required"`
+		Threshold float64 `json:"threshold" binding:"required"`
+		Email     string  `json:"email" binding:"omitempty,email,max=255"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return
+	}
+	err := alerts.Rule{Kind: req.Kind, Threshold: req.Threshold}.Validate()
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	rule, err := sqlc.New(a.cluster.Writer()).AddAlertRule(ctx, sqlc.AddAlertRuleParams{
+		UserID:    req.UserID,
+		Kind:      req.Kind,
+		Threshold: req.Threshold,
+		Email:     pgtype.Text{String: req.Email, Valid: req.Email != ""},
+	})
+	if err != nil {
+		abortDB(ctx, err, nil, ErrUserNotFound)
+		return
+	}
+	ctx.JSON(200, newAlertRuleView(rule))
+}
+
+func (a *Alert) GetRules(ctx *gin.Context) {
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	rules, err := sqlc.New(a.cluster.Reader()).GetAlertRules(ctx, query.UserID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]alertRuleView, 0, len(rules))
+	for _, r := range rules {
+		views = append(views, newAlertRuleView(r))
+	}
+	ctx.JSON(200, views)
+}
+
+func (a *Alert) DeleteRule(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	_, err = sqlc.New(a.cluster.Writer()).DeleteAlertRule(ctx, sqlc.DeleteAlertRuleParams{
+		ID:     int32(id),
+		UserID: query.UserID,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrAlertRuleNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// ListAlerts pages through the alerts the rules of a user fired, newest first.
+func (a *Alert) ListAlerts(ctx *gin.Context) {
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required"`
+		Before int32 `form:"before" binding:"min=0"`
+		Limit  int32 `form:"limit" binding:"omitempty,min=1,max=500"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	if query.Before == 0 {
+		query.Before = math.MaxInt32
+	}
+	// one more than asked tells whether there is a next page
+	list, err := sqlc.New(a.cluster.Reader()).ListAlerts(ctx, sqlc.ListAlertsParams{
+		UserID: query.UserID,
+		Before: query.Before,
+		Lim:    query.Limit + 1,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := gin.H{}
+	if len(list) > int(query.Limit) {
+		list = list[:query.Limit]
+		res["next"] = list[len(list)-1].ID
+	}
+	views := make([]alertView, 0, len(list))
+	for _, alert := range list {
+		v := alertView{
+			ID:        alert.ID,
+			Kind:      alert.Kind,
+			Threshold: alert.Threshold,
+			Detail:    alert.Detail,
+			CreatedAt: alert.CreatedAt.Time,
+		}
+		if alert.RuleID.Valid {
+			v.RuleID = &alert.RuleID.Int32
+		}
+		views = append(views, v)
+	}
+	res["alerts"] = views
+	ctx.JSON(200, res)
+}
@@ -0,0 +1,503 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrApiKeyNotFound      = errors.New("api key not found")
+	ErrMqttDeviceNotFound  = errors.New("mqtt device not found")
+	ErrMqttDeviceExists    = errors.New("a device with this client_id already exists")
+	ErrEmailSenderNotFound = errors.New("email sender not found")
+	ErrEmailSenderExists   = errors.New("the address is already a sender")
+	ErrSenderNumber        = errors.New("api key not found, or the phone number isn't one of its user's")
+)
+
+type ApiKey struct {
+	*Base
+	cluster *db.Cluster
+}
+
+type apiKeyView struct {
+	ID           int32    `json:"id"`
+	Name         string   `json:"name"`
+	Prefix       string   `json:"prefix"`
+	Scopes       []string `json:"scopes"`
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+	CreatedAt    string   `json:"created_at"`
+	Revoked      bool     `json:"revoked"`
+	Suspended    bool     `json:"suspended"`
+	// ThrottleLimit and ThrottledUntil are set while the fraud detector throttles the key
+	ThrottleLimit  int32  `json:"throttle_limit,omitempty"`
+	ThrottledUntil string `json:"throttled_until,omitempty"`
+}
+
+func newApiKeyView(k sqlc.ApiKey) apiKeyView {
+	cidrs := make([]string, 0, len(k.AllowedCidrs))
+	for _, c := range k.AllowedCidrs {
+		cidrs = append(cidrs, c.String())
+	}
+	v := apiKeyView{
+		ID:           k.ID,
+		Name:         k.Name,
+		Prefix:       k.Prefix,
+		Scopes:       k.Scopes,
+		AllowedCIDRs: cidrs,
+		CreatedAt:    k.CreatedAt.Time.UTC().Format(time.RFC3339),
+		Revoked:      k.RevokedAt.Valid,
+		Suspended:    k.SuspendedAt.Valid,
+	}
+	if k.ThrottledUntil.Valid && k.ThrottledUntil.Time.After(time.Now().UTC()) {
+		v.ThrottleLimit = k.ThrottleLimit.Int32
+		v.ThrottledUntil = k.ThrottledUntil.Time.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+func NewApiKey(parent *Versions, cluster *db.Cluster) *ApiKey {
+	base := NewBase("/admin/keys", parent, middlewares.WriteErrorBody, middlewares.RequireScopes(auth.ScopeUserAdmin))
+	k := &ApiKey{
+		base,
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.POST("", k.CreateApiKey)
+		gp.GET("/user/:username", k.GetApiKeys)
+		gp.DELETE("/:id", k.RevokeApiKey)
+		gp.PUT("/:id/allowed-cidrs", k.SetAllowedCidrs)
+		gp.DELETE("/:id/restrictions", k.LiftRestrictions)
+		gp.POST("/:id/devices", k.CreateMqttDevice)
+		gp.GET("/:id/devices", k.GetMqttDevices)
+		gp.DELETE("/:id/devices/:device", k.RevokeMqttDevice)
+		gp.POST("/:id/senders", k.AddEmailSender)
+		gp.GET("/:id/senders", k.GetEmailSenders)
+		gp.DELETE("/:id/senders/:sender", k.DeleteEmailSender)
+	})
+
+	return k
+}
+
+// CreateKey stores a new key for the user and returns it in clear. the clear key is never stored.
+// an empty cidrs leaves the key usable from any address.
+func CreateKey(ctx context.Context, q *sqlc.Queries, userID int32, name string, scopes, cidrs []string) (string, sqlc.ApiKey, error) {
+	err := auth.ValidateScopes(scopes)
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	allowed, err := auth.ParseCIDRs(cidrs)
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	key, prefix, hash, err := auth.GenerateKey()
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	row, err := q.AddApiKey(ctx, sqlc.AddApiKeyParams{
+		UserID:       userID,
+		Name:         name,
+		Prefix:       prefix,
+		KeyHash:      hash,
+		Scopes:       scopes,
+		AllowedCidrs: allowed,
+	})
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	return key, row, nil
+}
+
+// LookupApiKey resolves key hashes against the api_keys table.
+func LookupApiKey(cluster *db.Cluster) middlewares.KeyLookup {
+	return func(ctx context.Context, hash string) (*auth.Principal, error) {
+		row, err := sqlc.New(cluster.Reader()).GetApiKeyByHash(ctx, hash)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, middlewares.ErrInvalidCredentials
+			}
+			return nil, err
+		}
+		if row.Suspended {
+			return nil, middlewares.ErrKeySuspended
+		}
+		subaccounts := make(map[int32]string, len(row.SubaccountIds))
+		for i, id := range row.SubaccountIds {
+			subaccounts[id] = row.SubaccountUsernames[i]
+		}
+		return &auth.Principal{
+			KeyID:        row.ID,
+			UserID:       row.UserID,
+			Username:     row.Username,
+			Scopes:       row.Scopes,
+			AllowedCIDRs: row.AllowedCidrs,
+			RateLimit:    int(row.ThrottleLimit.Int32),
+			Subaccounts:  subaccounts,
+		}, nil
+	}
+}
+
+func (k *ApiKey) CreateApiKey(ctx *gin.Context) {
+	var req struct {
+		Username string   `json:"username" binding:"required"`
+		Name     string   `json:"name" binding:"required,max=255"`
+		Scopes   []string `json:"scopes" binding:"required"`
+		// AllowedCIDRs restricts the key to these client address ranges
+		AllowedCIDRs []string `json:"allowed_cidrs"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = auth.ValidateScopes(req.Scopes)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	_, err = auth.ParseCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	q := sqlc.New(k.cluster.Writer())
+	userId, err := q.GetUserId(ctx, req.Username)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrUserNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	key, row, err := CreateKey(ctx, q, userId, req.Name, req.Scopes, req.AllowedCIDRs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"key":     key,
+		"api_key": newApiKeyView(row),
+	})
+}
+
+func (k *ApiKey) GetApiKeys(ctx *gin.Context) {
+	q := sqlc.New(k.cluster.Reader())
+	userId, err := q.GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrUserNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	keys, err := q.GetApiKeysByUser(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]apiKeyView, 0, len(keys))
+	for _, key := range keys {
+		views = append(views, newApiKeyView(key))
+	}
+	ctx.JSON(200, views)
+}
+
+func (k *ApiKey) RevokeApiKey(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	_, err = sqlc.New(k.cluster.Writer()).RevokeApiKey(ctx, int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrApiKeyNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// LiftRestrictions lifts the suspension or throttling the fraud detector put on a key.
+func (k *ApiKey) LiftRestrictions(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	row, err := sqlc.New(k.cluster.Writer()).LiftApiKeyRestrictions(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrApiKeyNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newApiKeyView(row))
+}
+
+func (k *ApiKey) SetAllowedCidrs(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		AllowedCIDRs []string `json:"allowed_cidrs"`
+	}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	allowed, err := auth.ParseCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	row, err := sqlc.New(k.cluster.Writer()).SetApiKeyAllowedCidrs(ctx, sqlc.SetApiKeyAllowedCidrsParams{
+		AllowedCidrs: allowed,
+		ID:           int32(id),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrApiKeyNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newApiKeyView(row))
+}
+
+type mqttDeviceView struct {
+	ID         int32  `json:"id"`
+	ApiKeyID   int32  `json:"api_key_id"`
+	ClientID   string `json:"client_id"`
+	DailyQuota *int32 `json:"daily_quota"`
+	// UsedToday is how much of the quota the device used today (UTC)
+	UsedToday int32  `json:"used_today"`
+	CreatedAt string `json:"created_at"`
+	Revoked   bool   `json:"revoked"`
+}
+
+func newMqttDeviceView(d sqlc.MqttDevice) mqttDeviceView {
+	v := mqttDeviceView{
+		ID:        d.ID,
+		ApiKeyID:  d.ApiKeyID,
+		ClientID:  d.ClientID,
+		CreatedAt: d.CreatedAt.Time.UTC().Format(time.RFC3339),
+		Revoked:   d.RevokedAt.Valid,
+	}
+	if d.DailyQuota.Valid {
+		v.DailyQuota = &d.DailyQuota.Int32
+	}
+	if d.QuotaDay.Valid && d.QuotaDay.Time.Equal(today()) {
+		v.UsedToday = d.QuotaUsed
+	}
+	return v
+}
+
+func today() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// CreateMqttDevice adds a device that publishes sends over mqtt with the key and
+// returns its secret in clear. the clear secret is never stored.
+func (k *ApiKey) CreateMqttDevice(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		ClientID string `json:"client_id" binding:"required,max=64"`
+		// DailyQuota caps the device's sends per day (UTC), unlimited when unset
+		DailyQuota *int32 `json:"daily_quota" binding:"omitempty,min=1"`
+	}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	secret, _, hash, err := auth.GenerateKey()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	params := sqlc.AddMqttDeviceParams{
+		ApiKeyID:   int32(id),
+		ClientID:   req.ClientID,
+		SecretHash: hash,
+	}
+	if req.DailyQuota != nil {
+		params.DailyQuota = pgtype.Int4{Int32: *req.DailyQuota, Valid: true}
+	}
+	row, err := sqlc.New(k.cluster.Writer()).AddMqttDevice(ctx, params)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			ctx.AbortWithError(http.StatusNotFound, ErrApiKeyNotFound)
+			return
+		}
+		abortDB(ctx, err, ErrApiKeyNotFound, ErrMqttDeviceExists)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"secret": secret,
+		"device": newMqttDeviceView(row),
+	})
+}
+
+func (k *ApiKey) GetMqttDevices(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	devices, err := sqlc.New(k.cluster.Reader()).GetMqttDevicesByApiKey(ctx, int32(id))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]mqttDeviceView, 0, len(devices))
+	for _, d := range devices {
+		views = append(views, newMqttDeviceView(d))
+	}
+	ctx.JSON(200, views)
+}
+
+func (k *ApiKey) RevokeMqttDevice(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	device, err := strconv.ParseInt(ctx.Param("device"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid device"))
+		return
+	}
+	_, err = sqlc.New(k.cluster.Writer()).RevokeMqttDevice(ctx, sqlc.RevokeMqttDeviceParams{
+		ID:       int32(device),
+		ApiKeyID: int32(id),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrMqttDeviceNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+type emailSenderView struct {
+	ID            int32  `json:"id"`
+	ApiKeyID      int32  `json:"api_key_id"`
+	Address       string `json:"address"`
+	PhoneNumberID int32  `json:"phone_number_id"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func newEmailSenderView(s sqlc.EmailSender) emailSenderView {
+	return emailSenderView{
+		ID:            s.ID,
+		ApiKeyID:      s.ApiKeyID,
+		Address:       s.Address,
+		PhoneNumberID: s.PhoneNumberID,
+		CreatedAt:     s.CreatedAt.Time.UTC().Format(time.RFC3339),
+	}
+}
+
+// AddEmailSender lets mails from an address be sent as sms with the key, from
+// one of the numbers of the key's user.
+func (k *ApiKey) AddEmailSender(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		Address       string `json:"address" binding:"required,email,max=254"`
+		PhoneNumberID int32  `json:"phone_number_id" binding:"required"`
+	}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	row, err := sqlc.New(k.cluster.Writer()).AddEmailSender(ctx, sqlc.AddEmailSenderParams{
+		Address:       strings.ToLower(req.Address),
+		ApiKeyID:      int32(id),
+		PhoneNumberID: req.PhoneNumberID,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrSenderNumber, ErrEmailSenderExists)
+		return
+	}
+	ctx.JSON(200, newEmailSenderView(row))
+}
+
+func (k *ApiKey) GetEmailSenders(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	senders, err := sqlc.New(k.cluster.Reader()).GetEmailSendersByApiKey(ctx, int32(id))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]emailSenderView, 0, len(senders))
+	for _, s := range senders {
+		views = append(views, newEmailSenderView(s))
+	}
+	ctx.JSON(200, views)
+}
+
+func (k *ApiKey) DeleteEmailSender(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	sender, err := strconv.ParseInt(ctx.Param("sender"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid sender"))
+		return
+	}
+	_, err = sqlc.New(k.cluster.Writer()).DeleteEmailSender(ctx, sqlc.DeleteEmailSenderParams{
+		ID:       int32(sender),
+		ApiKeyID: int32(id),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrEmailSenderNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
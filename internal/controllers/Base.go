@@ -1,20 +1,109 @@
 package controllers
 
-import "github.com/gin-gonic/gin"
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+// LatestVersion is the newest api version. bump it to ship breaking changes under a
+// new prefix, see Base.RegisterVersions.
+const LatestVersion = 1
+
+// LegacyDeprecatedAt is when the unprefixed routes were deprecated in favor of /v1.
+var LegacyDeprecatedAt = time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+// HeaderAPIVersion tells clients which version answered.
+const HeaderAPIVersion = "API-Version"
+
+const apiVersionKey = "api_version"
+
+// Versions are the groups controllers register their routes in: one per api
+// version, served under /v<N>, and optionally the unprefixed legacy routes, which
+// serve v1 and announce their deprecation.
+type Versions struct {
+	groups []*gin.RouterGroup
+	legacy *gin.RouterGroup
+}
+
+func NewVersions(parent *gin.RouterGroup) *Versions {
+	v := &Versions{}
+	for n := 1; n <= LatestVersion; n++ {
+		v.groups = append(v.groups, parent.Group(fmt.Sprintf("/v%d", n), version(n)))
+	}
+	return v
+}
+
+// WithLegacy serves v1 under the unprefixed paths it was served at before versioning, with d's headers.
+func (v *Versions) WithLegacy(parent *gin.RouterGroup, d middlewares.Deprecation) *Versions {
+	v.legacy = parent.Group("", version(1), middlewares.Deprecated(d))
+	return v
+}
+
+func version(n int) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(apiVersionKey, n)
+		ctx.Header(HeaderAPIVersion, strconv.Itoa(n))
+		ctx.Next()
+	}
+}
+
+// APIVersion is the version serving the request.
+func APIVersion(ctx *gin.Context) int {
+	return ctx.GetInt(apiVersionKey)
+}
+
+// versionPath prefixes path with the version serving the request, for links in responses.
+func versionPath(ctx *gin.Context, path string) string {
+	n := APIVersion(ctx)
+	if n == 0 {
+		n = LatestVersion
+	}
+	return fmt.Sprintf("/v%d%s", n, path)
+}
 
 type Base struct {
 	Prefix string
-	gp     *gin.RouterGroup
+	// groups holds the group of every version, by version
+	groups map[int]*gin.RouterGroup
+	legacy *gin.RouterGroup
 }
 
-func NewBase(self string, parent *gin.RouterGroup, middlewares ...gin.HandlerFunc) *Base {
-	gp := parent.Group(self, middlewares...)
-	return &Base{
+func NewBase(self string, parent *Versions, middlewares ...gin.HandlerFunc) *Base {
+	b := &Base{
 		Prefix: self,
-		gp:     gp,
+		groups: make(map[int]*gin.RouterGroup, len(parent.groups)),
+	}
+	for i, gp := range parent.groups {
+		b.groups[i+1] = gp.Group(self, middlewares...)
 	}
+	if parent.legacy != nil {
+		b.legacy = parent.legacy.Group(self, middlewares...)
+	}
+	return b
 }
 
+// RegisterRoutes registers fn's routes in every version.
 func (b *Base) RegisterRoutes(fn func(gp *gin.RouterGroup)) {
-	fn(b.gp)
+	b.RegisterVersions(1, 0, fn)
+}
+
+// RegisterVersions registers fn's routes in the versions from to to, 0 meaning the
+// latest. a breaking change keeps the old routes up to the last version that had
+// them and registers the new ones from the next version on.
+func (b *Base) RegisterVersions(from, to int, fn func(gp *gin.RouterGroup)) {
+	if to == 0 {
+		to = LatestVersion
+	}
+	for n := from; n <= to; n++ {
+		if gp, ok := b.groups[n]; ok {
+			fn(gp)
+		}
+	}
+	if from == 1 && b.legacy != nil {
+		fn(b.legacy)
+	}
 }
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrBlockNotFound = errors.New("blocking rule not found")
+	ErrBlockExists   = errors.New("destination is already blocked")
+)
+
+// error codes of sends refused by a blocking rule
+const (
+	CodeDestinationBlocked = "destination_blocked"
+	CodeFraudRange         = "fraud_range"
+)
+
+// Blocklist manages the destinations a user refuses to send to, and the global
+// deny-list of fraud ranges under /admin/blocks.
+type Blocklist struct {
+	*Base
+	db      *sqlc.Queries
+	cluster *db.Cluster
+	// Global is the base of the deny-list routes
+	Global *Base
+}
+
+type blockView struct {
+	ID        int32     `json:"id"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	Global    bool      `json:"global"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newBlockView(b sqlc.BlockedDestination) blockView {
+	return blockView{
+		ID:        b.ID,
+		Kind:      b.Kind,
+		Value:     b.Value,
+		Reason:    b.Reason,
+		Global:    !b.UserID.Valid,
+		CreatedAt: b.CreatedAt.Time,
+	}
+}
+
+func newBlockViews(rules []sqlc.BlockedDestination) []blockView {
+	views := make([]blockView, 0, len(rules))
+	for _, r := range rules {
+		views = append(views, newBlockView(r))
+	}
+	return views
+}
+
+func NewBlocklist(parent *Versions, cluster *db.Cluster) *Blocklist {
+	base := NewBase("/user/:username/blocks", parent, middlewares.WriteErrorBody)
+	bl := &Blocklist{
+		base,
+		sqlc.New(cluster.Writer()),
+		cluster,
+		NewBase("/admin/blocks", parent, middlewares.WriteErrorBody),
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), bl.GetBlocks)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserWrite), bl.AddBlock)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), bl.DeleteBlock)
+	})
+	bl.Global.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserAdmin), bl.GetGlobalBlocks)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserAdmin), bl.AddGlobalBlock)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), bl.DeleteGlobalBlock)
+	})
+
+	return bl
+}
+
+func (bl *Blocklist) userId(ctx *gin.Context) (pgtype.Int4, bool) {
+	if !middlewares.OwnsUsername(ctx, ctx.Param("username")) {
+		return pgtype.Int4{}, false
+	}
+	id, err := sqlc.New(bl.cluster.Reader()).GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return pgtype.Int4{}, false
+	}
+	return pgtype.Int4{Int32: id, Valid: true}, true
+}
+
+func (bl *Blocklist) GetBlocks(ctx *gin.Context) {
+	userId, ok := bl.userId(ctx)
+	if !ok {
+		return
+	}
+	rules, err := sqlc.New(bl.cluster.Reader()).GetBlockedDestinations(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newBlockViews(rules))
+}
+
+func (bl *Blocklist) AddBlock(ctx *gin.Context) {
+	arg, ok := bindBlock(ctx)
+	if !ok {
+		return
+	}
+	arg.UserID, ok = bl.userId(ctx)
+	if !ok {
+		return
+	}
+	bl.add(ctx, arg)
+}
+
+func (bl *Blocklist) DeleteBlock(ctx *gin.Context) {
+	id, ok := blockId(ctx)
+	if !ok {
+		return
+	}
+	userId, ok := bl.userId(ctx)
+	if !ok {
+		return
+	}
+	_, err := bl.db.DeleteBlockedDestination(ctx, sqlc.DeleteBlockedDestinationParams{
+		ID:     id,
+		UserID: userId,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrBlockNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+func (bl *Blocklist) GetGlobalBlocks(ctx *gin.Context) {
+	rules, err := sqlc.New(bl.cluster.Reader()).GetGlobalBlockedDestinations(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newBlockViews(rules))
+}
+
+func (bl *Blocklist) AddGlobalBlock(ctx *gin.Context) {
+	arg, ok := bindBlock(ctx)
+	if !ok {
+		return
+	}
+	bl.add(ctx, arg)
+}
+
+func (bl *Blocklist) DeleteGlobalBlock(ctx *gin.Context) {
+	id, ok := blockId(ctx)
+	if !ok {
+		return
+	}
+	_, err := bl.db.DeleteGlobalBlockedDestination(ctx, id)
+	if err != nil {
+		abortDB(ctx, err, ErrBlockNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+func (bl *Blocklist) add(ctx *gin.Context, arg sqlc.AddBlockedDestinationParams) {
+	rule, err := bl.db.AddBlockedDestination(ctx, arg)
+	if err != nil {
+		abortDB(ctx, err, nil, ErrBlockExists)
+		return
+	}
+	ctx.JSON(200, newBlockView(rule))
+}
+
+func bindBlock(ctx *gin.Context) (sqlc.AddBlockedDestinationParams, bool) {
+	var req struct {
+		Kind   string `json:"kind" binding:"required,oneof=country prefix"`
+		Value  string `json:"value" binding:"required,max=32"`
+		Reason string `json:"reason" binding:"max=255"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return sqlc.AddBlockedDestinationParams{}, false
+	}
+	value, err := policy.ParseBlock(req.Kind, req.Value)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return sqlc.AddBlockedDestinationParams{}, false
+	}
+	return sqlc.AddBlockedDestinationParams{
+		Kind:   req.Kind,
+		Value:  value,
+		Reason: req.Reason,
+	}, true
+}
+
+func blockId(ctx *gin.Context) (int32, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return 0, false
+	}
+	return int32(id), true
+}
+
+// blockCode is the error code of a send refused by rule.
+func blockCode(rule sqlc.BlockedDestination) string {
+	if !rule.UserID.Valid {
+		return CodeFraudRange
+	}
+	return CodeDestinationBlocked
+}
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	mynats "github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown provider")
+	ErrVonageDlr       = errors.New("delivery report without client-ref or messageId")
+	ErrDlrMismatch     = errors.New("sms was not submitted through this provider with this message id")
+)
+
+// outcomes of a delivery report, see metrics.DlrCallbacks
+const (
+	dlrAccepted  = "accepted"
+	dlrDuplicate = "duplicate"
+	dlrRejected  = "rejected"
+)
+
+// Callback receives the delivery reports (DLRs) providers post back. a report is
+// only trusted when it is signed with the provider's secret, the way webhooks are
+// signed, within callbacks.tolerance of now. each provider message id is applied
+// once per status, so replayed or spoofed reports can't move an sms again or
+// trigger its webhooks twice.
+type Callback struct {
+	cluster *db.Cluster
+	sp      *mynats.Publisher
+	// secrets are the signing secrets by provider name
+	secrets map[string]string
+}
+
+type dlrRequest struct {
+	MessageID string `json:"message_id" binding:"required,max=128"`
+	SmsID     int32  `json:"sms_id" binding:"required"`
+	Status    string `json:"status" binding:"required,oneof=submitted delivered failed expired"`
+	Reason    string `json:"reason" binding:"max=255"`
+	// FailureReason is the normalized reason of failed and expired reports
+	FailureReason string `json:"failure_reason" binding:"omitempty,oneof=invalid_number absent_subscriber spam_filtered expired unknown"`
+}
+
+// NewCallback registers the callback routes under /callbacks of parent. they are
+// neither versioned nor authenticated with api keys: providers are configured with
+// a fixed url and sign their requests instead.
+func NewCallback(parent *gin.RouterGroup, cluster *db.Cluster, nc *nats.Conn, secrets map[string]string) (*Callback, error) {
+	sp, err := mynats.NewPublisher(context.Background(), nc,
+		mynats.WithReconcile(mynats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		mynats.WithStreams(NormalSmsStream(), ExpressSmsStream()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c := &Callback{
+		cluster: cluster,
+		sp:      sp,
+		secrets: secrets,
+	}
+
+	gp := parent.Group("/callbacks", middlewares.WriteErrorBody)
+	gp.POST("/dlr/:provider", c.ReceiveDlr)
+	gp.Match([]string{http.MethodGet, http.MethodPost}, "/vonage/dlr", c.ReceiveVonageDlr)
+
+	return c, nil
+}
+
+// dlrID is the processed_messages id of a report, the provider message ids aren't
+// bounded in length.
+func dlrID(provider, messageID, st string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", provider, messageID, st)
+	return "dlr-" + hex.EncodeToString(h.Sum(nil))[:60]
+}
+
+// ReceiveDlr verifies a delivery report and queues it as a status.Update on the
+// status subject of the sms' priority.
+func (c *Callback) ReceiveDlr(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+	secret, ok := c.secrets[provider]
+	if !ok || secret == "" {
+		ctx.AbortWithError(http.StatusNotFound, ErrUnknownProvider)
+		return
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = webhooks.Verify(secret,
+		ctx.GetHeader(webhooks.HeaderTimestamp),
+		ctx.GetHeader(webhooks.HeaderSignature),
+		body,
+		viper.GetDuration("callbacks.tolerance"),
+		time.Now(),
+	)
+	if err != nil {
+		metrics.DlrCallbacks.WithLabelValues(provider, dlrRejected).Inc()
+		ctx.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	var req dlrRequest
+	err = binding.JSON.BindBody(body, &req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	duplicate, ok := c.queueDlr(ctx, req.MessageID, status.Update{
+		ID:            req.SmsID,
+		Status:        status.Status(req.Status),
+		Provider:      provider,
+		Reason:        req.Reason,
+		FailureReason: req.FailureReason,
+	})
+	if !ok {
+		return
+	}
+	if duplicate {
+		ctx.JSON(http.StatusOK, gin.H{"msg": "duplicate"})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"msg": "OK"})
+}
+
+// ReceiveVonageDlr verifies a delivery report in the format of the Vonage SMS API,
+// signed with the signature secret of the account (callbacks.providers.vonage.secret),
+// and queues it like ReceiveDlr. the sms is identified by the client-ref the vonage
+// driver submitted it with. Vonage sends the report as query, form or JSON depending
+// on the account's settings and retries it unless answered with 200.
+func (c *Callback) ReceiveVonageDlr(ctx *gin.Context) {
+	const provider = "vonage"
+	secret, ok := c.secrets[provider]
+	if !ok || secret == "" {
+		ctx.AbortWithError(http.StatusNotFound, ErrUnknownProvider)
+		return
+	}
+	params, err := vonageParams(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = carrier.VerifyVonage(secret, params, viper.GetDuration("callbacks.tolerance"), time.Now())
+	if err != nil {
+		metrics.DlrCallbacks.WithLabelValues(provider, dlrRejected).Inc()
+		ctx.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	if params.Get("client-ref") == "" || params.Get("messageId") == "" {
+		ctx.AbortWithError(http.StatusBadRequest, ErrVonageDlr)
+		return
+	}
+	id, ok := c.clientRef(ctx, params.Get("client-ref"))
+	if !ok {
+		return
+	}
+	st, reason, ok := carrier.VonageDlr(params.Get("status"), params.Get("err-code"))
+	if !ok {
+		// intermediate reports, the final one follows
+		ctx.JSON(http.StatusOK, gin.H{"msg": "ignored"})
+		return
+	}
+	_, ok = c.queueDlr(ctx, params.Get("messageId"), status.Update{
+		ID:            id,
+		Status:        st,
+		Provider:      provider,
+		Reason:        reason,
+		Ref:           params.Get("messageId"),
+		FailureReason: carrier.VonageFailureReason(params.Get("err-code")),
+	})
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"msg": "OK"})
+}
+
+// clientRef finds the sms a driver submitted with ref, its message_id or, for sms
+// without one, its id. see carrier.Message.Ref.
+func (c *Callback) clientRef(ctx *gin.Context, ref string) (int32, bool) {
+	if id, err := strconv.ParseInt(ref, 10, 32); err == nil {
+		return int32(id), true
+	}
+	uid, err := uuid.Parse(ref)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, ErrVonageDlr)
+		return 0, false
+	}
+	id, err := sqlc.New(c.cluster.Reader()).GetSmsIdByMessageId(ctx, pgtype.UUID{Bytes: uid, Valid: true})
+	if err != nil {
+		abortDB(ctx, err, ErrSmsNotFound, nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// vonageParams reads the parameters of a Vonage callback from the query, a form or
+// a JSON object of strings.
+func vonageParams(ctx *gin.Context) (url.Values, error) {
+	if ctx.Request.Method == http.MethodGet {
+		return ctx.Request.URL.Query(), nil
+	}
+	if ctx.ContentType() != binding.MIMEJSON {
+		err := ctx.Request.ParseForm()
+		return ctx.Request.PostForm, err
+	}
+	var body map[string]any
+	err := json.NewDecoder(ctx.Request.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+	params := make(url.Values, len(body))
+	for name, v := range body {
+		params.Set(name, fmt.Sprint(v))
+	}
+	return params, nil
+}
+
+// queueDlr queues update, reported with the provider's messageID, on the status
+// subject of the sms' priority unless it was queued before. reports on sms that
+// weren't submitted through the provider, or under another message id when the
+// provider gave one, are refused with 409 so a provider can't move the sms of
+// another. it reports whether it was a duplicate, and false after aborting the
+// request.
+func (c *Callback) queueDlr(ctx *gin.Context, messageID string, update status.Update) (bool, bool) {
+	provider := update.Provider
+	data, err := json.Marshal(update)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return false, false
+	}
+	id := dlrID(provider, messageID, update.Status.String())
+	duplicate := false
+	err = db.WithTx(ctx, c.cluster.Writer(), func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		priority, err := q.GetSmsPriority(ctx, update.ID)
+		if err != nil {
+			return err
+		}
+		submission, err := q.GetSmsSubmission(ctx, update.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrDlrMismatch
+		}
+		if err != nil {
+			return err
+		}
+		if submission.Provider != provider || (submission.Ref != "" && submission.Ref != messageID) {
+			return ErrDlrMismatch
+		}
+		n, err := q.MarkMessageProcessed(ctx, sqlc.MarkMessageProcessedParams{
+			MessageID: id,
+			SmsID:     pgtype.Int4{Int32: update.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		duplicate = n == 0
+		if duplicate {
+			return nil
+		}
+		subject, err := PriorityStat(Priority(priority))
+		if err != nil {
+			return err
+		}
+		// the id also dedupes a publish whose transaction is retried
+		_, err = c.sp.Publish(ctx, subject, data, jetstream.WithMsgID(id))
+		return err
+	})
+	if errors.Is(err, ErrDlrMismatch) {
+		metrics.DlrCallbacks.WithLabelValues(provider, dlrRejected).Inc()
+		ctx.AbortWithError(http.StatusConflict, err)
+		return false, false
+	}
+	if err != nil {
+		abortDB(ctx, err, ErrSmsNotFound, nil)
+		return false, false
+	}
+	if duplicate {
+		metrics.DlrCallbacks.WithLabelValues(provider, dlrDuplicate).Inc()
+		return true, true
+	}
+	metrics.DlrCallbacks.WithLabelValues(provider, dlrAccepted).Inc()
+	return false, true
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+// provider statuses of GET /coverage besides the up and down of GET /status
+const providerUnknown = "unknown"
+
+// Coverage lists the destinations sms.provider routes, what they can be sent and
+// the health of their providers, see carrier.Coverage. the routes are read once,
+// like the worker does.
+type Coverage struct {
+	*Base
+	db       *db.Cluster
+	prober   *health.Prober
+	coverage []carrier.Coverage
+}
+
+type coverageProviderView struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type coverageView struct {
+	Prefix      string                 `json:"prefix,omitempty"`
+	CountryCode string                 `json:"country_code,omitempty"`
+	Price       string                 `json:"price"`
+	SenderTypes []string               `json:"sender_types"`
+	TwoWay      bool                   `json:"two_way"`
+	Providers   []coverageProviderView `json:"providers"`
+}
+
+func NewCoverage(parent *Versions, cluster *db.Cluster, prober *health.Prober) (*Coverage, error) {
+	coverage, err := carrier.CoverageFromViper()
+	if err != nil {
+		return nil, err
+	}
+	base := NewBase("/coverage", parent, middlewares.WriteErrorBody)
+	c := &Coverage{
+		base,
+		cluster,
+		prober,
+		coverage,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeSmsRead), c.GetCoverage)
+	})
+
+	return c, nil
+}
+
+// GetCoverage answers with the routed destinations by prefix, and default for the
+// others, null when they aren't sent. prices are those of user_id when given, see
+// GetSmsPrice, else sms.cost.
+func (c *Coverage) GetCoverage(ctx *gin.Context) {
+	var query struct {
+		UserID int32 `form:"user_id"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	price := cost
+	if query.UserID != 0 {
+		if !middlewares.Owns(ctx, query.UserID) {
+			return
+		}
+		var err error
+		price, err = sqlc.New(c.db.Reader()).GetSmsPrice(ctx, sqlc.GetSmsPriceParams{Cost: cost, UserID: query.UserID})
+		if err != nil {
+			abortDB(ctx, err, ErrUserNotFound, nil)
+			return
+		}
+	}
+	p, _ := price.Float64Value()
+	priceStr := strconv.FormatFloat(p.Float64, 'f', 2, 64)
+
+	probes := c.prober.Results()
+	countries := make([]coverageView, 0, len(c.coverage))
+	var fallback *coverageView
+	for _, cov := range c.coverage {
+		view := coverageView{
+			Prefix:      cov.Prefix,
+			CountryCode: cov.CountryCode(),
+			Price:       priceStr,
+			SenderTypes: cov.SenderTypes(),
+			TwoWay:      cov.TwoWay,
+			Providers:   make([]coverageProviderView, 0, len(cov.Drivers)),
+		}
+		for _, driver := range cov.Drivers {
+			status := providerUnknown
+			if probe, ok := probes[driver]; ok {
+				status = "up"
+				if !probe.Healthy {
+					status = "down"
+				}
+			}
+			view.Providers = append(view.Providers, coverageProviderView{Name: driver, Status: status})
+		}
+		if cov.Prefix == "" {
+			fallback = &view
+			continue
+		}
+		countries = append(countries, view)
+	}
+	ctx.JSON(200, gin.H{
+		"countries": countries,
+		"default":   fallback,
+	})
+}
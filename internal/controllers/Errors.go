@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgres error codes classified by abortDB
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgNumericOverflow     = "22003"
+)
+
+var ErrConflict = errors.New("conflicts with an existing resource")
+
+// abortDB answers a failed query with the status its error calls for: 404 with
+// notFound when no row matched, 409 with conflict when the change violates a
+// unique or foreign key constraint, 500 otherwise. a nil conflict answers ErrConflict.
+func abortDB(ctx *gin.Context, err error, notFound, conflict error) {
+	if conflict == nil {
+		conflict = ErrConflict
+	}
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		ctx.AbortWithError(http.StatusNotFound, notFound)
+	case errors.As(err, &pgErr) && (pgErr.Code == pgUniqueViolation || pgErr.Code == pgForeignKeyViolation):
+		ctx.AbortWithError(http.StatusConflict, conflict)
+	default:
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
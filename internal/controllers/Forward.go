@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrForwardNotFound = errors.New("no forwarding target")
+
+// Forward manages the nats servers users have their delivery events forwarded to.
+// the credentials of the servers are stored sealed, so the routes answer 501
+// unless a master key is configured. the worker forwards, see workers.Forwarder.
+type Forward struct {
+	*Base
+	cluster  *db.Cluster
+	envelope *secrets.Envelope
+}
+
+// forwardView shows how a target authenticates but none of its secrets.
+type forwardView struct {
+	UserID    int32     `json:"user_id"`
+	URL       string    `json:"url"`
+	Subject   string    `json:"subject"`
+	JetStream bool      `json:"jetstream"`
+	Auth      string    `json:"auth"`
+	User      string    `json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func NewForward(parent *Versions, cluster *db.Cluster, envelope *secrets.Envelope) *Forward {
+	base := NewBase("/forwards", parent, middlewares.WriteErrorBody)
+	f := &Forward{
+		base,
+		cluster,
+		envelope,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), f.GetForward)
+		gp.PUT("", middlewares.RequireScopes(auth.ScopeUserWrite), f.SetForward)
+		gp.DELETE("", middlewares.RequireScopes(auth.ScopeUserWrite), f.DeleteForward)
+	})
+
+	return f
+}
+
+// requireEnvelope answers 501 unless a master key is configured.
+func (f *Forward) requireEnvelope(ctx *gin.Context) bool {
+	if f.envelope == nil {
+		ctx.AbortWithError(http.StatusNotImplemented, secrets.ErrNoMasterKey)
+		return false
+	}
+	return true
+}
+
+func (f *Forward) view(ctx *gin.Context, fwd sqlc.NatsForward) (forwardView, error) {
+	target, err := forwards.Open(ctx, f.envelope, fwd)
+	if err != nil {
+		return forwardView{}, err
+	}
+	return forwardView{
+		UserID:    fwd.UserID,
+		URL:       fwd.Url,
+		Subject:   fwd.Subject,
+		JetStream: fwd.Jetstream,
+		Auth:      target.Credentials.Auth(),
+		User:      target.Credentials.User,
+		CreatedAt: fwd.CreatedAt.Time,
+		UpdatedAt: fwd.UpdatedAt.Time,
+	}, nil
+}
+
+func (f *Forward) GetForward(ctx *gin.Context) {
+	if !f.requireEnvelope(ctx) {
+		return
+	}
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	fwd, err := sqlc.New(f.cluster.Reader()).GetNatsForward(ctx, query.UserID)
+	if err != nil {
+		abortDB(ctx, err, ErrForwardNotFound, nil)
+		return
+	}
+	view, err := f.view(ctx, fwd)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+// SetForward sets up or replaces the nats server the delivery events of a user are
+// forwarded to, sealing its credentials with a new data key. the events are
+// published on subject, through jetstream when it is set.
+func (f *Forward) SetForward(ctx *gin.Context) {
+	if !f.requireEnvelope(ctx) {
+		return
+	}
+	var req struct {
+		UserID      int32                `json:"user_id" binding:"required"`
+		URL         string               `json:"url" binding:"required,max=1024"`
+		Subject     string               `json:"subject" binding:"required,max=255"`
+		JetStream   bool                 `json:"jetstream"`
+		Credentials forwards.Credentials `json:"credentials"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return
+	}
+	target := forwards.Target{
+		URL:         req.URL,
+		Subject:     req.Subject,
+		JetStream:   req.JetStream,
+		Credentials: req.Credentials,
+	}
+	err := target.Validate()
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	sealed, err := forwards.Seal(ctx, f.envelope, req.UserID, req.Credentials)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	fwd, err := sqlc.New(f.cluster.Writer()).SetNatsForward(ctx, sqlc.SetNatsForwardParams{
+		Url:         req.URL,
+		Subject:     req.Subject,
+		Jetstream:   req.JetStream,
+		Credentials: sealed.Ciphertext,
+		DataKey:     sealed.DataKey,
+		UserID:      req.UserID,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	view, err := f.view(ctx, fwd)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+func (f *Forward) DeleteForward(ctx *gin.Context) {
+	var query struct {
+		UserID int32 `form:"user_id" binding:"required"`
+	}
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	n, err := sqlc.New(f.cluster.Writer()).DeleteNatsForward(ctx, query.UserID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if n == 0 {
+		ctx.AbortWithError(http.StatusNotFound, ErrForwardNotFound)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+)
+
+type Invoice struct {
+	*Base
+	cluster *db.Cluster
+}
+
+type invoiceItemView struct {
+	Priority string `json:"priority"`
+	Country  string `json:"country"`
+	Messages int64  `json:"messages"`
+	Amount   string `json:"amount"`
+}
+
+type invoiceView struct {
+	ID          int32             `json:"id"`
+	PeriodStart string            `json:"period_start"`
+	PeriodEnd   string            `json:"period_end"`
+	Messages    int64             `json:"messages"`
+	Total       string            `json:"total"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Items       []invoiceItemView `json:"items,omitempty"`
+}
+
+func newInvoiceView(i sqlc.Invoice, items []sqlc.InvoiceItem) invoiceView {
+	v := invoiceView{
+		ID:          i.ID,
+		PeriodStart: i.PeriodStart.Time.Format(time.DateOnly),
+		PeriodEnd:   i.PeriodEnd.Time.Format(time.DateOnly),
+		Messages:    i.MessageCount,
+		Total:       billing.FormatCents(billing.Cents(i.Total)),
+		CreatedAt:   i.CreatedAt.Time,
+	}
+	for _, item := range items {
+		v.Items = append(v.Items, invoiceItemView{
+			Priority: item.Priority,
+			Country:  billing.CountryLabel(item.Country),
+			Messages: item.Quantity,
+			Amount:   billing.FormatCents(billing.Cents(item.Amount)),
+		})
+	}
+	return v
+}
+
+// NewInvoice serves the monthly statements the workers generate. the path
+// shares the :username wildcard with the other per-user routes.
+func NewInvoice(parent *Versions, cluster *db.Cluster) *Invoice {
+	base := NewBase("/user/:username/invoices", parent, middlewares.WriteErrorBody)
+	inv := &Invoice{
+		base,
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), inv.GetInvoices)
+		gp.GET("/:id", middlewares.RequireScopes(auth.ScopeUserRead), inv.GetInvoice)
+	})
+
+	return inv
+}
+
+func (inv *Invoice) userId(ctx *gin.Context) (int32, bool) {
+	if !middlewares.OwnsUsername(ctx, ctx.Param("username")) {
+		return 0, false
+	}
+	id, err := sqlc.New(inv.cluster.Reader()).GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrUserNotFound)
+			return 0, false
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (inv *Invoice) GetInvoices(ctx *gin.Context) {
+	userId, ok := inv.userId(ctx)
+	if !ok {
+		return
+	}
+	invoices, err := sqlc.New(inv.cluster.Reader()).GetInvoicesByUser(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]invoiceView, 0, len(invoices))
+	for _, i := range invoices {
+		views = append(views, newInvoiceView(i, nil))
+	}
+	ctx.JSON(200, views)
+}
+
+// GetInvoice answers with JSON by default, format=csv and format=pdf download the statement.
+func (inv *Invoice) GetInvoice(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	format := ctx.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "pdf" {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("unsupported format %q", format))
+		return
+	}
+	userId, ok := inv.userId(ctx)
+	if !ok {
+		return
+	}
+
+	q := sqlc.New(inv.cluster.Reader())
+	invoice, err := q.GetInvoice(ctx, int32(id))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil || invoice.UserID != userId {
+		ctx.AbortWithError(http.StatusNotFound, ErrInvoiceNotFound)
+		return
+	}
+	items, err := q.GetInvoiceItems(ctx, invoice.ID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	statement := billing.Statement{
+		Invoice:  invoice,
+		Username: ctx.Param("username"),
+		Items:    items,
+	}
+	var buf bytes.Buffer
+	contentType := ""
+	switch format {
+	case "json":
+		ctx.JSON(200, newInvoiceView(invoice, items))
+		return
+	case "csv":
+		contentType = "text/csv"
+		err = billing.WriteCSV(&buf, statement)
+	case "pdf":
+		contentType = "application/pdf"
+		err = billing.WritePDF(&buf, statement)
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%d.%s"`, invoice.ID, format))
+	ctx.Data(200, contentType, buf.Bytes())
+}
@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/jobs"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	mynats "github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobNotDone     = errors.New("job is not done yet")
+	ErrNoJobResult    = errors.New("job has no result to download")
+	ErrImportFormat   = errors.New("format must be csv or jsonl")
+	ErrEmptyImport    = errors.New("import file is empty")
+	ErrImportTooLarge = errors.New("import file is larger than api.limits.import")
+)
+
+// importFormats maps the content types of import uploads to their format
+var importFormats = map[string]string{
+	"text/csv":             jobs.FormatCSV,
+	"application/x-ndjson": jobs.FormatJSONL,
+	"application/jsonl":    jobs.FormatJSONL,
+}
+
+type Job struct {
+	*Base
+	cluster *db.Cluster
+	sp      *mynats.Publisher
+	store   storage.Storage
+	// Admin is the base of the import route
+	Admin *Base
+}
+
+type jobView struct {
+	ID          int32      `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	Progress    int32      `json:"progress"`
+	Rows        int64      `json:"rows"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func (j *Job) newJobView(ctx *gin.Context, job sqlc.Job) jobView {
+	v := jobView{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		Status:    job.Status,
+		Progress:  job.Progress,
+		Rows:      job.RowCount,
+		Error:     job.Error.String,
+		CreatedAt: job.CreatedAt.Time,
+	}
+	if job.FinishedAt.Valid {
+		v.FinishedAt = &job.FinishedAt.Time
+	}
+	if job.Status == jobs.StatusDone && job.Result.Valid {
+		v.DownloadURL = versionPath(ctx, fmt.Sprintf("/jobs/%d/download", job.ID))
+		// hand out a direct link when the storage can sign one
+		u, err := j.store.PresignGet(ctx, job.Result.String, viper.GetDuration("jobs.export.linkttl"))
+		if err == nil {
+			v.DownloadURL = u
+		}
+	}
+	return v
+}
+
+func NewJob(parent *Versions, cluster *db.Cluster, nc *nats.Conn, store storage.Storage) (*Job, error) {
+	base := NewBase("/jobs", parent, middlewares.WriteErrorBody)
+	sp, err := mynats.NewPublisher(context.Background(), nc,
+		mynats.WithReconcile(mynats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		mynats.WithStreams(jobs.StreamConfig()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{
+		Base:    base,
+		cluster: cluster,
+		sp:      sp,
+		store:   store,
+		Admin:   NewBase("/admin", parent, middlewares.WriteErrorBody),
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.POST("/export", middlewares.RequireScopes(auth.ScopeSmsRead), j.CreateExport)
+		gp.GET("/:id", middlewares.RequireScopes(auth.ScopeSmsRead), j.GetJob)
+		gp.GET("/:id/download", middlewares.RequireScopes(auth.ScopeSmsRead), j.Download)
+	})
+	j.Admin.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.POST("/import", middlewares.RequireScopes(auth.ScopeUserAdmin), j.CreateImport)
+	})
+
+	return j, nil
+}
+
+func (j *Job) CreateExport(ctx *gin.Context) {
+	var req struct {
+		UserID int32     `json:"user_id" binding:"required"`
+		From   time.Time `json:"from" binding:"required"`
+		To     time.Time `json:"to"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if !req.From.Before(req.To) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("from must be before to"))
+		return
+	}
+
+	params, err := json.Marshal(jobs.ExportParams{
+		UserID: req.UserID,
+		From:   req.From,
+		To:     req.To,
+		Masked: !middlewares.Unmasked(ctx),
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	job, err := sqlc.New(j.cluster.Writer()).AddJob(ctx, sqlc.AddJobParams{
+		UserID: req.UserID,
+		Kind:   jobs.KindExport,
+		Params: params,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !j.queue(ctx, job) {
+		return
+	}
+	ctx.JSON(http.StatusAccepted, j.newJobView(ctx, job))
+}
+
+// CreateImport stores an upload of historical messages of a user and queues a job
+// importing them. the body is the file, its format is taken from the format query
+// param or the content type.
+func (j *Job) CreateImport(ctx *gin.Context) {
+	var query struct {
+		UserID int32  `form:"user_id" binding:"required"`
+		Format string `form:"format" binding:"omitempty,oneof=csv jsonl"`
+	}
+	err := ctx.BindQuery(&query)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	contentType := ctx.ContentType()
+	if query.Format == "" {
+		query.Format = importFormats[contentType]
+	}
+	if query.Format == "" {
+		ctx.AbortWithError(http.StatusBadRequest, ErrImportFormat)
+		return
+	}
+	q := sqlc.New(j.cluster.Writer())
+	_, err = q.GetUserStatus(ctx, query.UserID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+
+	// spooled to a file, the storage needs to seek the upload
+	f, err := os.CreateTemp("", "import-*")
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, ctx.Request.Body)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		ctx.AbortWithError(http.StatusRequestEntityTooLarge, ErrImportTooLarge)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if size == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, ErrEmptyImport)
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	params, err := json.Marshal(jobs.ImportParams{
+		UserID: query.UserID,
+		Format: query.Format,
+		Size:   size,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	job, err := q.AddJob(ctx, sqlc.AddJobParams{
+		UserID: query.UserID,
+		Kind:   jobs.KindImport,
+		Params: params,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	err = j.store.Put(ctx, jobs.ImportKey(job.ID), f, contentType)
+	if err != nil {
+		j.fail(ctx, job, "failed to store upload")
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !j.queue(ctx, job) {
+		return
+	}
+	ctx.JSON(http.StatusAccepted, j.newJobView(ctx, job))
+}
+
+// queue hands job to the workers. jobs that can't be queued are failed right away.
+func (j *Job) queue(ctx *gin.Context, job sqlc.Job) bool {
+	data, err := json.Marshal(jobs.Request{ID: job.ID})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	subject, err := jobs.RequestSubject(job.Kind)
+	if err == nil {
+		_, err = j.sp.Publish(ctx, subject, data)
+	}
+	if err != nil {
+		j.fail(ctx, job, "failed to queue job")
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func (j *Job) fail(ctx context.Context, job sqlc.Job, reason string) {
+	sqlc.New(j.cluster.Writer()).FailJob(ctx, sqlc.FailJobParams{
+		Error: pgtype.Text{String: reason, Valid: true},
+		ID:    job.ID,
+	})
+}
+
+// job loads the job in the id param and checks the caller may see it.
+func (j *Job) job(ctx *gin.Context) (sqlc.Job, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return sqlc.Job{}, false
+	}
+	// the primary is read so progress is never behind the replica
+	job, err := sqlc.New(j.cluster.Writer()).GetJob(ctx, int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrJobNotFound)
+			return sqlc.Job{}, false
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return sqlc.Job{}, false
+	}
+	if !middlewares.Owns(ctx, job.UserID) {
+		return sqlc.Job{}, false
+	}
+	return job, true
+}
+
+func (j *Job) GetJob(ctx *gin.Context) {
+	job, ok := j.job(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(200, j.newJobView(ctx, job))
+}
+
+func (j *Job) Download(ctx *gin.Context) {
+	job, ok := j.job(ctx)
+	if !ok {
+		return
+	}
+	if job.Status != jobs.StatusDone {
+		ctx.AbortWithError(http.StatusConflict, ErrJobNotDone)
+		return
+	}
+	if !job.Result.Valid {
+		ctx.AbortWithError(http.StatusNotFound, ErrNoJobResult)
+		return
+	}
+	u, err := j.store.PresignGet(ctx, job.Result.String, viper.GetDuration("jobs.export.linkttl"))
+	if err == nil {
+		ctx.Redirect(http.StatusFound, u)
+		return
+	}
+	if !errors.Is(err, storage.ErrPresignUnsupported) {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	r, err := j.store.Open(ctx, job.Result.String)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			ctx.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer r.Close()
+	ctx.DataFromReader(200, -1, "text/csv", r, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, path.Base(job.Result.String)),
+	})
+}
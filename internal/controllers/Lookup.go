@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/hlr"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+type Lookup struct {
+	*Base
+	hlr hlr.Provider
+}
+
+func NewLookup(parent *Versions, provider hlr.Provider) *Lookup {
+	base := NewBase("/lookup", parent, middlewares.WriteErrorBody)
+	l := &Lookup{
+		base,
+		provider,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("/:number", middlewares.RequireScopes(auth.ScopeSmsSend), l.LookupNumber)
+	})
+
+	return l
+}
+
+func (l *Lookup) LookupNumber(ctx *gin.Context) {
+	res, err := l.hlr.Lookup(ctx, ctx.Param("number"))
+	if err != nil {
+		if errors.Is(err, hlr.ErrInvalidNumber) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		ctx.AbortWithError(http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(200, res)
+}
@@ -5,40 +5,57 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
 	"github.com/alireza-karampour/sms/pkg/middlewares"
 	. "github.com/alireza-karampour/sms/pkg/utils"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
 )
 
 var (
 	ErrPhoneNumberAlreadyExists = errors.New("phone number already exists")
 	ErrPhoneNumberNotFound      = errors.New("phone number not found")
+	ErrPhoneNumberTaken         = errors.New("phone number is registered by another user")
 )
 
+// PhoneNumbersUniquePerUser lets users share a phone number, like a short code,
+// when set as api.phonenumbers.unique. by default a number belongs to one user.
+const PhoneNumbersUniquePerUser = "user"
+
 type PhoneNumber struct {
 	*Base
-	db *sqlc.Queries
+	db      *sqlc.Queries
+	cluster *db.Cluster
+	// shared is set when users may register the same phone number
+	shared bool
 }
 
-func NewPhoneNumber(parent *gin.RouterGroup, db *pgxpool.Pool) *PhoneNumber {
+func NewPhoneNumber(parent *Versions, cluster *db.Cluster) *PhoneNumber {
 	base := NewBase("/phone-number", parent, middlewares.WriteErrorBody)
 	pn := &PhoneNumber{
 		base,
-		sqlc.New(db),
+		sqlc.New(cluster.Writer()),
+		cluster,
+		viper.GetString("api.phonenumbers.unique") == PhoneNumbersUniquePerUser,
 	}
 
 	base.RegisterRoutes(func(gp *gin.RouterGroup) {
-		gp.POST("", pn.CreatePhoneNumber)
-		gp.GET("/:id", pn.GetPhoneNumber)
-		gp.DELETE("/:id", pn.DeletePhoneNumber)
-		gp.GET("/user/:username", pn.GetPhoneNumbersByUser)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserWrite), pn.CreatePhoneNumber)
+		gp.GET("/:id", middlewares.RequireScopes(auth.ScopeUserRead), pn.GetPhoneNumber)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), pn.DeletePhoneNumber)
+		gp.GET("/user/:username", middlewares.RequireScopes(auth.ScopeUserRead), pn.GetPhoneNumbersByUser)
 	})
 
 	return pn
 }
 
+func (pn *PhoneNumber) reader() *sqlc.Queries {
+	return sqlc.New(pn.cluster.Reader())
+}
+
 func (pn *PhoneNumber) CreatePhoneNumber(ctx *gin.Context) {
 	request := new(sqlc.PhoneNumber)
 	err := ctx.BindJSON(request)
@@ -46,12 +63,38 @@ func (pn *PhoneNumber) CreatePhoneNumber(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
+	if !middlewares.Owns(ctx, request.UserID) {
+		return
+	}
 
-	err = pn.db.AddPhoneNumber(ctx, sqlc.AddPhoneNumberParams{
-		UserID:      request.UserID,
-		PhoneNumber: request.PhoneNumber,
+	err = db.WithTx(ctx, pn.cluster.Writer(), func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		if !pn.shared {
+			err := q.LockPhoneNumber(ctx, request.PhoneNumber)
+			if err != nil {
+				return err
+			}
+			taken, err := q.IsPhoneNumberTaken(ctx, sqlc.IsPhoneNumberTakenParams{
+				PhoneNumber: request.PhoneNumber,
+				UserID:      request.UserID,
+			})
+			if err != nil {
+				return err
+			}
+			if taken {
+				return ErrPhoneNumberTaken
+			}
+		}
+		return q.AddPhoneNumber(ctx, sqlc.AddPhoneNumberParams{
+			UserID:      request.UserID,
+			PhoneNumber: request.PhoneNumber,
+		})
 	})
 	if err != nil {
+		if errors.Is(err, ErrPhoneNumberTaken) {
+			ctx.AbortWithError(http.StatusConflict, err)
+			return
+		}
 		if ErrContains(err, "duplicate key value") {
 			ctx.AbortWithError(http.StatusConflict, ErrPhoneNumberAlreadyExists)
 			return
@@ -77,11 +120,14 @@ func (pn *PhoneNumber) GetPhoneNumber(ctx *gin.Context) {
 		return
 	}
 
-	phoneNumber, err := pn.db.GetPhoneNumber(ctx, int32(idInt))
+	phoneNumber, err := pn.reader().GetPhoneNumber(ctx, int32(idInt))
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	if !middlewares.Owns(ctx, phoneNumber.UserID) {
+		return
+	}
 
 	ctx.JSON(200, phoneNumber)
 }
@@ -93,6 +139,16 @@ func (pn *PhoneNumber) DeletePhoneNumber(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
 		return
 	}
+	if p, ok := middlewares.Principal(ctx); ok && !p.Has(auth.ScopeUserAdmin) {
+		phoneNumber, err := pn.db.GetPhoneNumber(ctx, int32(idInt))
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !middlewares.Owns(ctx, phoneNumber.UserID) {
+			return
+		}
+	}
 
 	_, err = pn.db.DeletePhoneNumber(ctx, int32(idInt))
 	if err != nil {
@@ -108,7 +164,10 @@ func (pn *PhoneNumber) DeletePhoneNumber(ctx *gin.Context) {
 
 func (pn *PhoneNumber) GetPhoneNumbersByUser(ctx *gin.Context) {
 	username := ctx.Param("username")
-	phoneNumbers, err := pn.db.GetPhoneNumbersByUsername(ctx, username)
+	if !middlewares.OwnsUsername(ctx, username) {
+		return
+	}
+	phoneNumbers, err := pn.reader().GetPhoneNumbersByUsername(ctx, username)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrPoolNotFound    = errors.New("phone number pool not found")
+	ErrPoolExists      = errors.New("the user already has a pool with this name")
+	ErrPoolNumberTaken = errors.New("phone number is already in the pool")
+)
+
+// PhoneNumberPool manages the pools of numbers the sms of a user are sent from
+// in turn, see pickSender.
+type PhoneNumberPool struct {
+	*Base
+	db      *sqlc.Queries
+	cluster *db.Cluster
+}
+
+type poolNumberView struct {
+	PhoneNumberID int32      `json:"phone_number_id"`
+	PhoneNumber   string     `json:"phone_number"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+}
+
+type poolView struct {
+	ID        int32            `json:"id"`
+	UserID    int32            `json:"user_id"`
+	Name      string           `json:"name"`
+	Strategy  string           `json:"strategy"`
+	CreatedAt time.Time        `json:"created_at"`
+	Numbers   []poolNumberView `json:"numbers,omitempty"`
+}
+
+func newPoolView(p sqlc.PhoneNumberPool) poolView {
+	return poolView{
+		ID:        p.ID,
+		UserID:    p.UserID,
+		Name:      p.Name,
+		Strategy:  p.Strategy,
+		CreatedAt: p.CreatedAt.Time,
+	}
+}
+
+func NewPhoneNumberPool(parent *Versions, cluster *db.Cluster) *PhoneNumberPool {
+	base := NewBase("/phone-number/pools", parent, middlewares.WriteErrorBody)
+	pp := &PhoneNumberPool{
+		base,
+		sqlc.New(cluster.Writer()),
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserWrite), pp.CreatePool)
+		gp.GET("/:id", middlewares.RequireScopes(auth.ScopeUserRead), pp.GetPool)
+		gp.PUT("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), pp.SetStrategy)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), pp.DeletePool)
+		gp.POST("/:id/numbers", middlewares.RequireScopes(auth.ScopeUserWrite), pp.AddNumber)
+		gp.DELETE("/:id/numbers/:number", middlewares.RequireScopes(auth.ScopeUserWrite), pp.RemoveNumber)
+		gp.GET("/user/:username", middlewares.RequireScopes(auth.ScopeUserRead), pp.GetPoolsByUser)
+	})
+
+	return pp
+}
+
+func (pp *PhoneNumberPool) reader() *sqlc.Queries {
+	return sqlc.New(pp.cluster.Reader())
+}
+
+// pool loads the pool of the id param and aborts the request unless the caller
+// owns it.
+func (pp *PhoneNumberPool) pool(ctx *gin.Context) (sqlc.PhoneNumberPool, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return sqlc.PhoneNumberPool{}, false
+	}
+	pool, err := pp.db.GetPhoneNumberPool(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrPoolNotFound, nil)
+		return sqlc.PhoneNumberPool{}, false
+	}
+	if !middlewares.Owns(ctx, pool.UserID) {
+		return sqlc.PhoneNumberPool{}, false
+	}
+	return pool, true
+}
+
+func (pp *PhoneNumberPool) CreatePool(ctx *gin.Context) {
+	var req struct {
+		UserID   int32  `json:"user_id" binding:"required"`
+		Name     string `json:"name" binding:"required,max=64"`
+		Strategy string `json:"strategy"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return
+	}
+	strategy, err := policy.ParsePoolStrategy(req.Strategy)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	pool, err := pp.db.AddPhoneNumberPool(ctx, sqlc.AddPhoneNumberPoolParams{
+		UserID:   req.UserID,
+		Name:     req.Name,
+		Strategy: strategy,
+	})
+	if err != nil {
+		abortDB(ctx, err, nil, ErrPoolExists)
+		return
+	}
+	ctx.JSON(200, newPoolView(pool))
+}
+
+func (pp *PhoneNumberPool) GetPool(ctx *gin.Context) {
+	pool, ok := pp.pool(ctx)
+	if !ok {
+		return
+	}
+	numbers, err := pp.reader().GetPoolNumbers(ctx, pool.ID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	view := newPoolView(pool)
+	view.Numbers = make([]poolNumberView, 0, len(numbers))
+	for _, n := range numbers {
+		nv := poolNumberView{PhoneNumberID: n.PhoneNumberID, PhoneNumber: n.PhoneNumber}
+		if n.LastUsedAt.Valid {
+			nv.LastUsedAt = &n.LastUsedAt.Time
+		}
+		view.Numbers = append(view.Numbers, nv)
+	}
+	ctx.JSON(200, view)
+}
+
+func (pp *PhoneNumberPool) GetPoolsByUser(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if !middlewares.OwnsUsername(ctx, username) {
+		return
+	}
+	pools, err := pp.reader().GetPhoneNumberPoolsByUsername(ctx, username)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]poolView, 0, len(pools))
+	for _, p := range pools {
+		views = append(views, newPoolView(p))
+	}
+	ctx.JSON(200, views)
+}
+
+func (pp *PhoneNumberPool) SetStrategy(ctx *gin.Context) {
+	var req struct {
+		Strategy string `json:"strategy" binding:"required"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	strategy, err := policy.ParsePoolStrategy(req.Strategy)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	pool, ok := pp.pool(ctx)
+	if !ok {
+		return
+	}
+	pool, err = pp.db.SetPhoneNumberPoolStrategy(ctx, sqlc.SetPhoneNumberPoolStrategyParams{
+		ID:       pool.ID,
+		Strategy: strategy,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrPoolNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newPoolView(pool))
+}
+
+func (pp *PhoneNumberPool) DeletePool(ctx *gin.Context) {
+	pool, ok := pp.pool(ctx)
+	if !ok {
+		return
+	}
+	_, err := pp.db.DeletePhoneNumberPool(ctx, pool.ID)
+	if err != nil {
+		abortDB(ctx, err, ErrPoolNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// AddNumber adds a phone number of the user of the pool to it.
+func (pp *PhoneNumberPool) AddNumber(ctx *gin.Context) {
+	var req struct {
+		PhoneNumberID int32 `json:"phone_number_id" binding:"required"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	pool, ok := pp.pool(ctx)
+	if !ok {
+		return
+	}
+	_, err := pp.db.AddPoolNumber(ctx, sqlc.AddPoolNumberParams{
+		PoolID:        pool.ID,
+		PhoneNumberID: req.PhoneNumberID,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrPhoneNumberNotFound, ErrPoolNumberTaken)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+func (pp *PhoneNumberPool) RemoveNumber(ctx *gin.Context) {
+	number, err := strconv.ParseInt(ctx.Param("number"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid phone number id"))
+		return
+	}
+	pool, ok := pp.pool(ctx)
+	if !ok {
+		return
+	}
+	_, err = pp.db.DeletePoolNumber(ctx, sqlc.DeletePoolNumberParams{
+		PoolID:        pool.ID,
+		PhoneNumberID: int32(number),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrPhoneNumberNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// pickSender picks the number of pool the sms of userID to to is sent from with
+// the strategy of the pool, through the writer q. it aborts the request with 404
+// when userID doesn't own the pool and 422 when the pool is empty.
+func pickSender(ctx *gin.Context, q *sqlc.Queries, poolID, userID int32, to string) (int32, bool) {
+	pool, err := q.GetPhoneNumberPool(ctx, poolID)
+	if err != nil {
+		abortDB(ctx, err, ErrPoolNotFound, nil)
+		return 0, false
+	}
+	if pool.UserID != userID {
+		ctx.AbortWithError(http.StatusNotFound, ErrPoolNotFound)
+		return 0, false
+	}
+	var number int32
+	if pool.Strategy == policy.PoolLeastRecentlyUsed {
+		number, err = q.TakeLeastRecentlyUsedNumber(ctx, pool.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = policy.ErrPoolEmpty
+		}
+	} else {
+		number, err = pickMember(ctx, q, pool, to)
+	}
+	if errors.Is(err, policy.ErrPoolEmpty) {
+		ctx.AbortWithError(http.StatusUnprocessableEntity, err)
+		return 0, false
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return number, true
+}
+
+// pickMember picks the number of a round robin or sticky pool the sms to to is
+// sent from and records its use.
+func pickMember(ctx context.Context, q *sqlc.Queries, pool sqlc.PhoneNumberPool, to string) (int32, error) {
+	members, err := q.GetPoolNumberIds(ctx, pool.ID)
+	if err != nil {
+		return 0, err
+	}
+	var number int32
+	if pool.Strategy == policy.PoolSticky {
+		number, err = policy.StickyMember(members, to)
+	} else {
+		if len(members) == 0 {
+			return 0, policy.ErrPoolEmpty
+		}
+		var turn int64
+		turn, err = q.NextPoolTurn(ctx, pool.ID)
+		if err != nil {
+			return 0, err
+		}
+		number, err = policy.RoundRobinMember(members, turn)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return number, q.TouchPoolNumber(ctx, sqlc.TouchPoolNumberParams{PoolID: pool.ID, PhoneNumberID: number})
+}
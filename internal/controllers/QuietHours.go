@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrQuietHoursNotFound = errors.New("quiet hours rule not found")
+)
+
+type QuietHours struct {
+	*Base
+	db      *sqlc.Queries
+	cluster *db.Cluster
+}
+
+type quietHoursView struct {
+	ID       int32  `json:"id"`
+	Category string `json:"category"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Action   string `json:"action"`
+}
+
+func newQuietHoursView(r sqlc.QuietHour) quietHoursView {
+	return quietHoursView{
+		ID:       r.ID,
+		Category: r.Category,
+		Start:    policy.FormatClock(r.StartTime),
+		End:      policy.FormatClock(r.EndTime),
+		Action:   r.Action,
+	}
+}
+
+func NewQuietHours(parent *Versions, cluster *db.Cluster) *QuietHours {
+	base := NewBase("/user/:username/quiet-hours", parent, middlewares.WriteErrorBody)
+	qh := &QuietHours{
+		base,
+		sqlc.New(cluster.Writer()),
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), qh.GetQuietHours)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserWrite), qh.AddQuietHours)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), qh.DeleteQuietHours)
+	})
+
+	return qh
+}
+
+func (qh *QuietHours) userId(ctx *gin.Context) (int32, bool) {
+	if !middlewares.OwnsUsername(ctx, ctx.Param("username")) {
+		return 0, false
+	}
+	id, err := sqlc.New(qh.cluster.Reader()).GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrUserNotFound)
+			return 0, false
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (qh *QuietHours) GetQuietHours(ctx *gin.Context) {
+	userId, ok := qh.userId(ctx)
+	if !ok {
+		return
+	}
+	rules, err := sqlc.New(qh.cluster.Reader()).GetQuietHours(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]quietHoursView, 0, len(rules))
+	for _, r := range rules {
+		views = append(views, newQuietHoursView(r))
+	}
+	ctx.JSON(200, views)
+}
+
+func (qh *QuietHours) AddQuietHours(ctx *gin.Context) {
+	var req struct {
+		Category string `json:"category" binding:"omitempty,oneof=transactional marketing"`
+		Start    string `json:"start" binding:"required"`
+		End      string `json:"end" binding:"required"`
+		Action   string `json:"action" binding:"omitempty,oneof=reject defer"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if req.Category == "" {
+		req.Category = policy.CategoryMarketing
+	}
+	if req.Action == "" {
+		req.Action = policy.QuietHoursDefer
+	}
+	start, err := policy.ParseClock(req.Start)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	end, err := policy.ParseClock(req.End)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	userId, ok := qh.userId(ctx)
+	if !ok {
+		return
+	}
+	rule, err := qh.db.AddQuietHours(ctx, sqlc.AddQuietHoursParams{
+		UserID:    userId,
+		Category:  req.Category,
+		StartTime: start,
+		EndTime:   end,
+		Action:    req.Action,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newQuietHoursView(rule))
+}
+
+func (qh *QuietHours) DeleteQuietHours(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	userId, ok := qh.userId(ctx)
+	if !ok {
+		return
+	}
+	_, err = qh.db.DeleteQuietHours(ctx, sqlc.DeleteQuietHoursParams{
+		ID:     int32(id),
+		UserID: userId,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrQuietHoursNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
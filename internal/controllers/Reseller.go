@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrNotReseller      = errors.New("user is not a reseller")
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrResellerScope    = errors.New("customer keys can't be granted admin scopes")
+	ErrResellerAccount  = errors.New("customers and subaccounts can't be resellers")
+	ErrInvalidSender    = errors.New("sender must be a number of up to 15 digits or up to 11 letters and digits")
+)
+
+// senderPattern matches the senders carriers accept: a number, or an
+// alphanumeric sender id
+var senderPattern = regexp.MustCompile(`^(\+?[0-9]{1,15}|[A-Za-z0-9]{1,11})$`)
+
+// checkSender aborts the request with 400 unless sender is empty or one
+// carriers accept.
+func checkSender(ctx *gin.Context, sender string) bool {
+	if sender != "" && !senderPattern.MatchString(sender) {
+		ctx.AbortWithError(http.StatusBadRequest, ErrInvalidSender)
+		return false
+	}
+	return true
+}
+
+// Reseller lets resellers manage the customers they sell the gateway to under
+// their own brand: their prices, senders, balances, keys and usage. resellers
+// are enabled by the platform admins, see Admin.EnableReseller.
+type Reseller struct {
+	*Base
+	cluster *db.Cluster
+}
+
+type resellerView struct {
+	UserID int32 `json:"user_id"`
+	// Margin is the percent added to sms.cost for customers without a price
+	Margin string `json:"margin"`
+	Sender string `json:"sender,omitempty"`
+	Footer string `json:"footer,omitempty"`
+}
+
+func newResellerView(r sqlc.Reseller) resellerView {
+	return resellerView{
+		UserID: r.UserID,
+		Margin: billing.FormatCents(billing.Cents(r.Margin)),
+		Sender: r.Sender.String,
+		Footer: r.Footer.String,
+	}
+}
+
+func NewReseller(parent *Versions, cluster *db.Cluster) *Reseller {
+	base := NewBase("/reseller", parent, middlewares.WriteErrorBody, middlewares.RequireScopes(auth.ScopeReseller))
+	r := &Reseller{
+		base,
+		cluster,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("/:username", r.GetSettings)
+		gp.PATCH("/:username", r.UpdateSettings)
+		gp.GET("/:username/usage", r.GetUsage)
+		gp.GET("/:username/customers", r.ListCustomers)
+		gp.POST("/:username/customers", r.CreateCustomer)
+		gp.PATCH("/:username/customers/:customer", r.UpdateCustomer)
+		gp.POST("/:username/customers/:customer/balance", r.TransferBalance)
+		gp.POST("/:username/customers/:customer/keys", r.CreateCustomerKey)
+	})
+
+	return r
+}
+
+// reseller looks up the reseller of the username parameter and aborts the
+// request unless the caller is that user and it is a reseller.
+func (r *Reseller) reseller(ctx *gin.Context, q *sqlc.Queries) (sqlc.Reseller, bool) {
+	username := ctx.Param("username")
+	if !middlewares.OwnsUsername(ctx, username) {
+		return sqlc.Reseller{}, false
+	}
+	id, err := q.GetUserId(ctx, username)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return sqlc.Reseller{}, false
+	}
+	reseller, err := q.GetReseller(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(http.StatusForbidden, ErrNotReseller)
+		return sqlc.Reseller{}, false
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return sqlc.Reseller{}, false
+	}
+	return reseller, true
+}
+
+// customer looks up the customer of the customer parameter under reseller.
+func (r *Reseller) customer(ctx *gin.Context, q *sqlc.Queries, reseller sqlc.Reseller) (sqlc.User, bool) {
+	customer, err := q.GetCustomer(ctx, sqlc.GetCustomerParams{
+		ResellerID: pgtype.Int4{Int32: reseller.UserID, Valid: true},
+		Username:   ctx.Param("customer"),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrCustomerNotFound, nil)
+		return sqlc.User{}, false
+	}
+	return customer, true
+}
+
+func (r *Reseller) GetSettings(ctx *gin.Context) {
+	reseller, ok := r.reseller(ctx, sqlc.New(r.cluster.Reader()))
+	if !ok {
+		return
+	}
+	ctx.JSON(200, newResellerView(reseller))
+}
+
+// UpdateSettings changes the margin and the branded sender and footer of the
+// reseller. fields left out stay as they are, an empty sender or footer
+// removes it.
+func (r *Reseller) UpdateSettings(ctx *gin.Context) {
+	var req struct {
+		Margin *float64 `json:"margin" binding:"omitempty,min=0,max=999.99"`
+		Sender *string  `json:"sender"`
+		Footer *string  `json:"footer" binding:"omitempty,max=160"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	q := sqlc.New(r.cluster.Writer())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	params := sqlc.UpdateResellerParams{UserID: reseller.UserID}
+	if req.Margin != nil {
+		params.Margin = billing.Amount(int64(math.Round(*req.Margin * 100)))
+	}
+	if req.Sender != nil {
+		if !checkSender(ctx, *req.Sender) {
+			return
+		}
+		params.SetSender = true
+		params.Sender = pgtype.Text{String: *req.Sender, Valid: *req.Sender != ""}
+	}
+	if req.Footer != nil {
+		params.SetFooter = true
+		params.Footer = pgtype.Text{String: *req.Footer, Valid: *req.Footer != ""}
+	}
+	reseller, err := q.UpdateReseller(ctx, params)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, newResellerView(reseller))
+}
+
+func (r *Reseller) ListCustomers(ctx *gin.Context) {
+	q := sqlc.New(r.cluster.Reader())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	customers, err := q.GetCustomers(ctx, pgtype.Int4{Int32: reseller.UserID, Valid: true})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]userView, 0, len(customers))
+	for _, c := range customers {
+		views = append(views, newUserView(c))
+	}
+	ctx.JSON(200, gin.H{
+		"customers": views,
+	})
+}
+
+// customerPrice parses the sms price of a customer, an empty price unsets it.
+func customerPrice(price string) (pgtype.Numeric, error) {
+	if price == "" {
+		return pgtype.Numeric{}, nil
+	}
+	cents, err := parseCents(price)
+	if err != nil {
+		return pgtype.Numeric{}, fmt.Errorf("sms_price %w", err)
+	}
+	if cents <= 0 {
+		return pgtype.Numeric{}, errors.New("sms_price must be a positive decimal")
+	}
+	return billing.Amount(cents), nil
+}
+
+// CreateCustomer adds a user managed by the reseller. it starts with no
+// balance, see TransferBalance.
+func (r *Reseller) CreateCustomer(ctx *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required,alphanum,max=255"`
+		// SmsPrice is what the customer pays per sms, sms.cost plus the margin of the reseller when empty
+		SmsPrice string `json:"sms_price"`
+		Sender   string `json:"sender"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	if !checkSender(ctx, req.Sender) {
+		return
+	}
+	price, err := customerPrice(req.SmsPrice)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	q := sqlc.New(r.cluster.Writer())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	customer, err := q.AddCustomer(ctx, sqlc.AddCustomerParams{
+		Username:   req.Username,
+		ResellerID: pgtype.Int4{Int32: reseller.UserID, Valid: true},
+		SmsPrice:   price,
+		Sender:     pgtype.Text{String: req.Sender, Valid: req.Sender != ""},
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, ErrUserAlreadyExists)
+		return
+	}
+	ctx.JSON(http.StatusCreated, newUserView(customer))
+}
+
+// UpdateCustomer changes the price or the sender of a customer. fields left out
+// stay as they are, empty ones are unset.
+func (r *Reseller) UpdateCustomer(ctx *gin.Context) {
+	var req struct {
+		SmsPrice *string `json:"sms_price"`
+		Sender   *string `json:"sender"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	params := sqlc.UpdateCustomerParams{}
+	if req.SmsPrice != nil {
+		price, err := customerPrice(*req.SmsPrice)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		params.SetSmsPrice, params.SmsPrice = true, price
+	}
+	if req.Sender != nil {
+		if !checkSender(ctx, *req.Sender) {
+			return
+		}
+		params.SetSender = true
+		params.Sender = pgtype.Text{String: *req.Sender, Valid: *req.Sender != ""}
+	}
+	q := sqlc.New(r.cluster.Writer())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	customer, ok := r.customer(ctx, q, reseller)
+	if !ok {
+		return
+	}
+	params.ID = customer.ID
+	customer, err := q.UpdateCustomer(ctx, params)
+	if err != nil {
+		abortDB(ctx, err, ErrCustomerNotFound, nil)
+		return
+	}
+	ctx.JSON(200, newUserView(customer))
+}
+
+// TransferBalance funds a customer out of the balance of the reseller, or
+// returns funds to the reseller when amount is negative.
+func (r *Reseller) TransferBalance(ctx *gin.Context) {
+	var req struct {
+		Amount string `json:"amount" binding:"required"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	amount, ok := transferAmount(ctx, req.Amount)
+	if !ok {
+		return
+	}
+	q := sqlc.New(r.cluster.Writer())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	customer, ok := r.customer(ctx, q, reseller)
+	if !ok {
+		return
+	}
+	balances, ok := moveBalance(ctx, r.cluster, reseller.UserID, customer.ID, amount)
+	if !ok {
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"reseller_balance": billing.FormatCents(billing.Cents(balances[reseller.UserID])),
+		"balance":          billing.FormatCents(billing.Cents(balances[customer.ID])),
+	})
+}
+
+// CreateCustomerKey creates an api key of a customer. it can't carry the admin
+// scopes, a reseller can't hand out more than it holds.
+func (r *Reseller) CreateCustomerKey(ctx *gin.Context) {
+	var req struct {
+		Name         string   `json:"name" binding:"required,max=255"`
+		Scopes       []string `json:"scopes" binding:"required"`
+		AllowedCIDRs []string `json:"allowed_cidrs"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	if slices.Contains(req.Scopes, auth.ScopeUserAdmin) || slices.Contains(req.Scopes, auth.ScopeReseller) {
+		ctx.AbortWithError(http.StatusBadRequest, ErrResellerScope)
+		return
+	}
+	err := auth.ValidateScopes(req.Scopes)
+	if err == nil {
+		_, err = auth.ParseCIDRs(req.AllowedCIDRs)
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	q := sqlc.New(r.cluster.Writer())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	customer, ok := r.customer(ctx, q, reseller)
+	if !ok {
+		return
+	}
+	key, row, err := CreateKey(ctx, q, customer.ID, req.Name, req.Scopes, req.AllowedCIDRs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"key":     key,
+		"api_key": newApiKeyView(row),
+	})
+}
+
+// GetUsage rolls up the messages of every customer of the reseller, their
+// subaccounts included, between from and to.
+func (r *Reseller) GetUsage(ctx *gin.Context) {
+	period, ok := bindPeriod(ctx)
+	if !ok {
+		return
+	}
+	q := sqlc.New(r.cluster.Reader())
+	reseller, ok := r.reseller(ctx, q)
+	if !ok {
+		return
+	}
+	rows, err := q.GetResellerUsage(ctx, sqlc.GetResellerUsageParams{
+		Since:      pgtype.Timestamp{Time: period.From.UTC(), Valid: true},
+		Until:      pgtype.Timestamp{Time: period.To.UTC(), Valid: true},
+		ResellerID: pgtype.Int4{Int32: reseller.UserID, Valid: true},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	accounts := make([]sqlc.GetSubaccountUsageRow, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, sqlc.GetSubaccountUsageRow(row))
+	}
+	writeRollup(ctx, period, accounts)
+}
@@ -2,18 +2,43 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/policy"
 	. "github.com/alireza-karampour/sms/internal/streams"
 	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/chat"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/gsm"
+	"github.com/alireza-karampour/sms/pkg/hlr"
+	"github.com/alireza-karampour/sms/pkg/ids"
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/metrics"
 	"github.com/alireza-karampour/sms/pkg/middlewares"
 	mynats "github.com/alireza-karampour/sms/pkg/nats"
-	. "github.com/alireza-karampour/sms/pkg/utils"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/status"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/spf13/viper"
@@ -23,6 +48,38 @@ var (
 	cost pgtype.Numeric
 )
 
+var (
+	ErrDuplicateSms        = errors.New("identical sms was already sent within the dedupe window")
+	ErrDuplicateExternalID = errors.New("an sms with this external_id was already sent")
+	ErrExpiresInQuietHours = errors.New("sms would expire before the destination's quiet hours end")
+	ErrUnreachable         = errors.New("destination is not reachable")
+	ErrMessageTooLong      = fmt.Errorf("message with footer is longer than %d characters", maxMessageLength)
+	ErrSmsNotFound         = errors.New("sms not found")
+	ErrSmsNotPending       = errors.New("only pending sms can be cancelled")
+	ErrOTPNotInMessage     = errors.New("otp must be part of the message")
+	ErrProviderOverride    = fmt.Errorf("provider can only be chosen with the %s scope", auth.ScopeUserAdmin)
+	ErrRecipientFlood      = middlewares.WithCode(CodeRecipientFlood, errors.New("too many messages to this number, try again later"))
+	ErrQuotaExceeded       = middlewares.WithCode(CodeQuotaExceeded, errors.New("monthly sms quota is used up"))
+	ErrRateQuotaExceeded   = middlewares.WithCode(CodeRateQuotaExceeded, errors.New("sms quota and burst credits are used up, try again later"))
+)
+
+// CodeRecipientFlood tells a recipient's flood limit apart from the request rate limits, which also answer 429.
+const CodeRecipientFlood = "recipient_flood"
+
+// CodeQuotaExceeded tells a subaccount's monthly quota apart from the other 403s.
+const CodeQuotaExceeded = "quota_exceeded"
+
+// CodeRateQuotaExceeded tells a quota policy's refusals apart from the other 429s.
+const CodeRateQuotaExceeded = "rate_quota_exceeded"
+
+// CodeExpressBackedUp tells a refused express sms apart from the other 503s.
+const CodeExpressBackedUp = "express_backed_up"
+
+const (
+	// maxMessageLength is the size of the sms.message column
+	maxMessageLength = 255
+)
+
 func init() {
 	costStr := viper.GetString("sms.cost")
 	if costStr == "" {
@@ -36,160 +93,1118 @@ func init() {
 
 type Sms struct {
 	*Base
-	db *pgxpool.Pool
+	db *db.Cluster
 	sp *mynats.Publisher
+	// Lookup gates sends to the countries listed in hlr.gate.countries on a
+	// successful number lookup. gating is disabled while it is nil.
+	Lookup hlr.Provider
+	// Flood counts the messages each user sends to a number, see checkFlood
+	Flood middlewares.RateLimitStore
+	// Quotas holds the buckets of the quota policies, see checkRateQuota. it is
+	// shared with the workers' rate limits with worker.ratelimit.distributed
+	Quotas mynats.RateLimiter
+	// recorder publishes the recorded events too when events.stream.enabled
+	recorder *events.Publisher
+	// ids makes the public ids of the sms, see sms.ids.generator
+	ids ids.Generator
+	// Depth estimates when sent sms are dispatched, as if the queues were empty
+	// while it is nil
+	Depth *Depth
+	// admission decides on express sms while the express queue is backed up,
+	// see api.express.admission. it needs Depth
+	admission policy.ExpressAdmission
+	// dedupe remembers the identical sms sent within sms.dedupe.window across
+	// both priorities, nil unless sms.dedupe.enabled
+	dedupe *mynats.KVDedupe
 }
 
-func NewSms(parent *gin.RouterGroup, db *pgxpool.Pool, nc *nats.Conn) (*Sms, error) {
+// SmsStreams are the streams the sms routes publish to. the workers bind the same
+// configs, see workers.SmsStreams.
+func SmsStreams() []jetstream.StreamConfig {
+	streams := append([]jetstream.StreamConfig{NormalSmsStream(), ExpressSmsStream()}, channels.NotificationStreams()...)
+	if viper.GetBool("events.stream.enabled") {
+		streams = append(streams, events.StreamConfig())
+	}
+	return streams
+}
+
+func NewSms(parent *Versions, cluster *db.Cluster, nc *nats.Conn) (*Sms, error) {
 	base := NewBase("/sms", parent, middlewares.WriteErrorBody)
-	sp, err := mynats.NewSimplePublisher(nc)
+	sp, err := mynats.NewPublisher(context.Background(), nc,
+		mynats.WithReconcile(mynats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		mynats.WithStreams(SmsStreams()...),
+	)
 	if err != nil {
 		return nil, err
 	}
-
-	sms := &Sms{
-		Base: base,
-		db:   db,
-		sp:   sp,
-	}
-
-	err = sp.BindStreams(context.Background(),
-		jetstream.StreamConfig{
-			Name:        NORMAL_SMS_CONSUMER_NAME,
-			Description: "work queue for handling sms with normal priority",
-			Subjects: []string{
-				MakeSubject(SMS, SEND, REQ),
-				MakeSubject(SMS, SEND, STAT),
-				MakeSubject(SMS, SEND, ERR),
-			},
-			Retention: jetstream.WorkQueuePolicy,
-			Storage:   jetstream.FileStorage,
-		},
-		jetstream.StreamConfig{
-			Name:        EXPRESS_SMS_CONSUMER_NAME,
-			Description: "work queue for handling sms with high priority",
-			Subjects: []string{
-				MakeSubject(SMS, EX, SEND, REQ),
-				MakeSubject(SMS, EX, SEND, STAT),
-				MakeSubject(SMS, EX, SEND, ERR),
-			},
-			Retention: jetstream.WorkQueuePolicy,
-			Storage:   jetstream.FileStorage,
-		},
-	)
+	generator, err := ids.FromViper()
+	if err != nil {
+		return nil, err
+	}
+	admission, err := policy.ExpressAdmissionFromViper()
 	if err != nil {
 		return nil, err
 	}
 
+	var quotas mynats.RateLimiter = mynats.NewMemoryRateLimiter()
+	if viper.GetBool("worker.ratelimit.distributed") {
+		quotas, err = mynats.NewKVRateLimiter(context.Background(), sp.JetStream, viper.GetString("worker.ratelimit.bucket"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dedupe *mynats.KVDedupe
+	if viper.GetBool("sms.dedupe.enabled") {
+		dedupe, err = mynats.NewKVDedupe(context.Background(), sp.JetStream, viper.GetString("sms.dedupe.bucket"), viper.GetDuration("sms.dedupe.window"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sms := &Sms{
+		Base:      base,
+		db:        cluster,
+		sp:        sp,
+		Flood:     middlewares.NewMemoryStore(),
+		Quotas:    quotas,
+		recorder:  events.PublisherFromViper(sp.JetStream),
+		ids:       generator,
+		admission: admission,
+		dedupe:    dedupe,
+	}
+
 	base.RegisterRoutes(func(gp *gin.RouterGroup) {
-		gp.POST("", sms.SendSms)
-		gp.GET("", sms.GetSmsMessages)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeSmsSend), sms.SendSms)
+		gp.GET("", middlewares.RequireScopes(auth.ScopeSmsRead), sms.GetSmsMessages)
+		gp.GET("/usage", middlewares.RequireScopes(auth.ScopeSmsRead), sms.GetUsage)
+		gp.GET("/:id/events", middlewares.RequireScopes(auth.ScopeSmsRead), sms.GetSmsEvents)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeSmsSend), sms.CancelSms)
 	})
 
 	return sms, nil
 }
 
+// Streams returns the work queue streams sms are published to.
+func (s *Sms) Streams() map[mynats.StreamName]jetstream.Stream {
+	return s.sp.Streams
+}
+
+// SendSms queues a message on the channel named in the body: sms (default), email,
+// push, voice, whatsapp or telegram.
 func (s *Sms) SendSms(ctx *gin.Context) {
-	query := new(struct {
-		Express bool `json:"express"`
-	})
-	var subject string
-	if query.Express {
-		subject = MakeSubject(SMS, EX, SEND, REQ)
-	} else {
-		subject = MakeSubject(SMS, SEND, REQ)
+	var head struct {
+		Channel string `json:"channel" binding:"omitempty,oneof=sms email push voice whatsapp telegram"`
+	}
+	if !bind(ctx, &head) {
+		return
+	}
+	if head.Channel == "" || head.Channel == channels.Sms {
+		s.sendSms(ctx)
+		return
+	}
+	s.sendNotification(ctx, head.Channel)
+}
+
+// smsRequest is POST /sms for the sms channel.
+type smsRequest struct {
+	// Express queues the sms with express priority, set by ?express=true
+	Express bool `json:"-" form:"express"`
+
+	UserID        int32  `json:"user_id" binding:"required"`
+	PhoneNumberID int32  `json:"phone_number_id" binding:"required_without=PoolID"`
+	ToPhoneNumber string `json:"to_phone_number" binding:"required"`
+	Message       string `json:"message" binding:"required"`
+	Category      string `json:"category" binding:"omitempty,oneof=transactional marketing"`
+	// PoolID sends the sms from a number of this pool instead of PhoneNumberID,
+	// see pickSender
+	PoolID int32 `json:"pool_id"`
+	// ValidityPeriod is the number of seconds the message may wait for delivery
+	ValidityPeriod int64 `json:"validity_period" binding:"omitempty,min=1"`
+	// Fallback receives the message when the sms fails
+	Fallback *channels.Fallback `json:"fallback"`
+	// ExternalID is the client's own reference, unique per user
+	ExternalID string `json:"external_id" binding:"max=64"`
+	// Metadata is stored with the message and returned as is
+	Metadata map[string]string `json:"metadata" binding:"max=20,dive,keys,min=1,max=40,endkeys,max=500"`
+	// Tags group messages in listings and usage reports
+	Tags []string `json:"tags" binding:"max=10,dive,min=1,max=64"`
+	// OTP is the one-time code in the message, for carriers with a fast path for codes
+	OTP string `json:"otp" binding:"omitempty,alphanum,max=32"`
+	// AllowDowngrade queues an express sms as normal instead of refusing it while
+	// the express queue is backed up, see admitExpress
+	AllowDowngrade bool `json:"allow_downgrade"`
+	// Provider submits the sms through this driver whatever its destination is
+	// routed to, only admin keys may set it
+	Provider string `json:"provider" binding:"max=32"`
+}
+
+// queuedSms is an sms queueSms accepted.
+type queuedSms struct {
+	// MessageID is the public id of the sms, it identifies the request until a
+	// worker stored the sms too, see events.MessageID
+	MessageID string
+	Sms       *sqlc.Sm
+	// Duplicate is set when the sms was dropped as a duplicate, see sms.dedupe.mode
+	Duplicate bool
+	Priority  string
+	// Downgraded is set when an express sms was queued as normal, see admitExpress
+	Downgraded    bool
+	DeferredUntil *time.Time
+	// Price is what the sms is charged, see GetSmsPrice
+	Price pgtype.Numeric
+	// Remaining is the balance left once the sms is charged
+	Remaining float64
+}
+
+func (s *Sms) sendSms(ctx *gin.Context) {
+	var req smsRequest
+	if !bind(ctx, &req) {
+		return
+	}
+	queued, ok := s.queueSms(ctx, &req)
+	if !ok {
+		return
+	}
+	if queued.Duplicate {
+		ctx.JSON(200, gin.H{
+			"msg":       "OK",
+			"duplicate": true,
+		})
+		return
+	}
+	charge, _ := queued.Price.Float64Value()
+	position, dispatchAt := s.estimateDispatch(queued, time.Now())
+	res := gin.H{
+		"msg":                   "OK",
+		"message_id":            queued.MessageID,
+		"priority":              queued.Priority,
+		"segments":              gsm.Segments(queued.Sms.Message),
+		"estimated_cost":        strconv.FormatFloat(charge.Float64, 'f', 2, 64),
+		"queue_position":        position,
+		"estimated_dispatch_at": dispatchAt,
+	}
+	if queued.DeferredUntil != nil {
+		res["deferred_until"] = queued.DeferredUntil
+	}
+	if queued.Downgraded {
+		res["downgraded"] = true
+	}
+	if req.PoolID != 0 {
+		res["phone_number_id"] = queued.Sms.PhoneNumberID
 	}
-	ctx.BindQuery(query)
+	reportBalance(ctx, res, queued.Remaining)
+	ctx.JSON(200, res)
+}
+
+// estimateDispatch estimates the place of queued in the queue of its priority
+// and when a worker dispatches it, from the queue depth and the rate limit of the
+// priority shared by api.sms.workers workers. deferred sms aren't dispatched
+// before their quiet hours end.
+func (s *Sms) estimateDispatch(queued *queuedSms, now time.Time) (position uint64, at time.Time) {
+	var wait time.Duration
+	if s.Depth != nil {
+		workers := viper.GetInt("api.sms.workers")
+		if viper.GetBool("worker.ratelimit.distributed") {
+			// the rate limit is shared by the whole cluster
+			workers = 1
+		}
+		interval := time.Duration(viper.GetUint("sms."+queued.Priority+".ratelimit")) * time.Millisecond
+		position, wait = s.Depth.Wait(queued.Priority, interval, workers)
+	}
+	at = now.Add(wait).UTC()
+	if queued.DeferredUntil != nil && queued.DeferredUntil.After(at) {
+		at = queued.DeferredUntil.UTC()
+	}
+	return position + 1, at
+}
 
-	var req struct {
-		UserID        int32  `json:"user_id" binding:"required"`
-		PhoneNumberID int32  `json:"phone_number_id" binding:"required"`
-		ToPhoneNumber string `json:"to_phone_number" binding:"required"`
-		Message       string `json:"message" binding:"required"`
+// queueSms checks req against the sms policies and publishes it on the queue of
+// its priority. it aborts the request and reports false when the sms is refused.
+func (s *Sms) queueSms(ctx *gin.Context, req *smsRequest) (*queuedSms, bool) {
+	acceptedAt := time.Now()
+	subject := NormalSendReq()
+	priority := "normal"
+	if req.Express {
+		subject = ExpressSendReq()
+		priority = "express"
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return nil, false
+	}
+	if req.Fallback != nil {
+		err := channels.Validate(req.Fallback.Channel, req.Fallback.To)
+		if err != nil {
+			ctx.AbortWithError(400, err)
+			return nil, false
+		}
+	}
+	if req.OTP != "" && !strings.Contains(req.Message, req.OTP) {
+		ctx.AbortWithError(400, ErrOTPNotInMessage)
+		return nil, false
+	}
+	if req.Provider != "" {
+		if p, ok := middlewares.Principal(ctx); ok && !p.Has(auth.ScopeUserAdmin) {
+			ctx.AbortWithError(403, ErrProviderOverride)
+			return nil, false
+		}
+		if !slices.Contains(carrier.Drivers, req.Provider) {
+			ctx.AbortWithError(400, fmt.Errorf("unknown provider %q, want one of %s", req.Provider, strings.Join(carrier.Drivers, ", ")))
+			return nil, false
+		}
+	}
+	if req.Category == "" {
+		req.Category = policy.CategoryTransactional
 	}
-	err := ctx.BindJSON(&req)
+	expiresAt, err := policy.Validity(req.ValidityPeriod, viper.GetDuration("sms.validity.max"), time.Now())
 	if err != nil {
 		ctx.AbortWithError(400, err)
-		return
+		return nil, false
 	}
 
-	q := sqlc.New(s.db)
-	balance, err := q.GetBalance(ctx, req.UserID)
+	q := sqlc.New(s.db.Reader())
+	if !canSend(ctx, q, req.UserID) {
+		return nil, false
+	}
+	if !checkQuota(ctx, q, req.UserID) {
+		return nil, false
+	}
+	downgraded, ok := s.admitExpress(ctx, q, req)
+	if !ok {
+		return nil, false
+	}
+	if downgraded {
+		subject = NormalSendReq()
+		priority = "normal"
+	}
+	var externalID pgtype.Text
+	if req.ExternalID != "" {
+		externalID = pgtype.Text{String: req.ExternalID, Valid: true}
+		if !checkExternalID(ctx, q, req.UserID, externalID) {
+			return nil, false
+		}
+	}
+	to := []string{req.ToPhoneNumber}
+	if req.Fallback != nil && (req.Fallback.Channel == channels.Voice || req.Fallback.Channel == channels.WhatsApp) {
+		to = append(to, req.Fallback.To)
+	}
+	if !checkBlocked(ctx, q, req.UserID, to...) {
+		return nil, false
+	}
+	price, err := q.GetSmsPrice(ctx, sqlc.GetSmsPriceParams{Cost: cost, UserID: req.UserID})
 	if err != nil {
 		ctx.AbortWithError(500, err)
-		return
+		return nil, false
 	}
-	// Compare the actual decimal values, not just the integer parts
-	balanceFloat, _ := balance.Float64Value()
-	costFloat, _ := cost.Float64Value()
-	if balanceFloat.Float64 < costFloat.Float64 {
-		ctx.AbortWithError(403, errors.New("not enough balance"))
-		return
+	remaining, ok := hasBalance(ctx, q, req.UserID, price)
+	if !ok {
+		return nil, false
+	}
+
+	if !s.checkReachable(ctx, req.ToPhoneNumber) {
+		return nil, false
 	}
 
+	rules, err := q.GetQuietHours(ctx, req.UserID)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil, false
+	}
+	var deferredUntil *time.Time
+	if rule, until, ok := policy.QuietHours(rules, req.Category, req.ToPhoneNumber, time.Now()); ok {
+		if rule.Action == policy.QuietHoursReject {
+			ctx.AbortWithError(403, policy.ErrQuietHours)
+			return nil, false
+		}
+		if expiresAt.Valid && until.After(expiresAt.Time) {
+			ctx.AbortWithError(403, ErrExpiresInQuietHours)
+			return nil, false
+		}
+		deferredUntil = &until
+	}
+
+	if viper.GetBool("sms.footer." + priority) {
+		footer, err := q.GetFooter(ctx, req.UserID)
+		if err != nil {
+			ctx.AbortWithError(500, err)
+			return nil, false
+		}
+		req.Message = appendFooter(req.Message, footer.String)
+		if utf8.RuneCountInString(req.Message) > maxMessageLength {
+			ctx.AbortWithError(400, ErrMessageTooLong)
+			return nil, false
+		}
+	}
+
+	if !s.checkFlood(ctx, q, req.UserID, req.ToPhoneNumber) {
+		return nil, false
+	}
+	if !s.checkRateQuota(ctx, q, req.UserID, priority) {
+		return nil, false
+	}
+	if req.PoolID != 0 {
+		// picked last, refused sms don't take a turn
+		req.PhoneNumberID, ok = pickSender(ctx, sqlc.New(s.db.Writer()), req.PoolID, req.UserID, req.ToPhoneNumber)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	uid, err := s.ids.New()
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil, false
+	}
 	sms := &sqlc.Sm{
+		MessageID:     pgtype.UUID{Bytes: uid, Valid: true},
 		UserID:        req.UserID,
 		PhoneNumberID: req.PhoneNumberID,
 		ToPhoneNumber: req.ToPhoneNumber,
 		Message:       req.Message,
-		Status:        "pending",
+		Status:        status.Accepted.String(),
+		Category:      req.Category,
+		ExpiresAt:     expiresAt,
+		ExternalID:    externalID,
+	}
+	if len(req.Metadata) > 0 {
+		sms.Metadata, err = json.Marshal(req.Metadata)
+		if err != nil {
+			ctx.AbortWithError(500, err)
+			return nil, false
+		}
+	}
+	if len(req.Tags) > 0 {
+		slices.Sort(req.Tags)
+		sms.Tags = slices.Compact(req.Tags)
+	}
+
+	smsJson, err := json.Marshal(channels.SmsRequest{Sm: *sms, Fallback: req.Fallback, OTP: req.OTP, Provider: req.Provider})
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return nil, false
 	}
 
-	smsJson, err := json.Marshal(sms)
+	id := uid.String()
+	// a publish retried after its ack was lost is dropped by the message id
+	pubOpts := []jetstream.PublishOpt{jetstream.WithMsgID(id)}
+	var claimed string
+	if externalID.Valid {
+		// concurrent sends with one external id, which the check above can't see
+		pubOpts = []jetstream.PublishOpt{jetstream.WithMsgID(fmt.Sprintf("external:%d:%s", sms.UserID, externalID.String))}
+	} else if s.dedupe != nil {
+		// the duplicate window of a stream can't see the copies sent with the
+		// other priority, or downgraded to it, so the claim is shared by both
+		key := dedupeID(sms)
+		first, err := s.dedupe.Claim(ctx, key)
+		if err != nil {
+			ctx.AbortWithError(500, err)
+			return nil, false
+		}
+		if !first {
+			return s.duplicateSms(ctx, &queuedSms{MessageID: id, Sms: sms, Priority: priority})
+		}
+		claimed = key
+		pubOpts = []jetstream.PublishOpt{jetstream.WithMsgID(key)}
+	}
+	ack, err := s.sp.PublishMsg(ctx, &nats.Msg{
+		Subject: subject,
+		Data:    smsJson,
+		Header:  events.Header(id, actor(ctx), middlewares.GetRequestID(ctx), acceptedAt),
+	}, pubOpts...)
 	if err != nil {
+		if claimed != "" {
+			// not sent, a retry of the request must not be refused as its duplicate
+			err = errors.Join(err, s.dedupe.Release(ctx, claimed))
+		}
 		ctx.AbortWithError(500, err)
+		return nil, false
+	}
+	queued := &queuedSms{
+		MessageID:     id,
+		Sms:           sms,
+		Priority:      priority,
+		Downgraded:    downgraded,
+		DeferredUntil: deferredUntil,
+		Price:         price,
+		Remaining:     remaining,
+	}
+	if ack.Duplicate {
+		if externalID.Valid {
+			ctx.AbortWithError(409, ErrDuplicateExternalID)
+			return nil, false
+		}
+		return s.duplicateSms(ctx, queued)
+	}
+	return queued, true
+}
+
+// duplicateSms answers an sms dropped as a duplicate of one sent within the
+// dedupe window: a 409 or, with sms.dedupe.mode indicate, queued marked as one.
+func (s *Sms) duplicateSms(ctx *gin.Context, queued *queuedSms) (*queuedSms, bool) {
+	if viper.GetString("sms.dedupe.mode") != "indicate" {
+		ctx.AbortWithError(409, ErrDuplicateSms)
+		return nil, false
+	}
+	queued.Duplicate = true
+	return queued, true
+}
+
+// notificationRequest is POST /sms for the other channels.
+type notificationRequest struct {
+	UserID int32 `json:"user_id" binding:"required"`
+	// To is an email address, a device token, a phone number or a telegram chat
+	To      string `json:"to" binding:"required,max=255"`
+	Subject string `json:"subject" binding:"max=255"`
+	Message string `json:"message" binding:"required_without=Template"`
+	// Template names one of <channel>.templates, Params fill its placeholders
+	Template string   `json:"template" binding:"max=64"`
+	Params   []string `json:"params" binding:"max=10,dive,max=1024"`
+	MediaURL string   `json:"media_url" binding:"omitempty,url,max=2048"`
+}
+
+// sendNotification queues an email, push notification, voice call or chat message.
+// they skip the sms policies: quiet hours, footers and number lookups.
+func (s *Sms) sendNotification(ctx *gin.Context, channel string) {
+	acceptedAt := time.Now()
+	var req notificationRequest
+	if !bind(ctx, &req) {
+		return
+	}
+	if !middlewares.Owns(ctx, req.UserID) {
+		return
+	}
+	err := channels.Validate(channel, req.To)
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if req.Template != "" || req.MediaURL != "" {
+		if channel != channels.WhatsApp && channel != channels.Telegram {
+			ctx.AbortWithError(400, fmt.Errorf("templates and media are only sent on %s and %s", channels.WhatsApp, channels.Telegram))
+			return
+		}
+		if req.Template != "" {
+			_, err = chat.LookupTemplate(channel, req.Template)
+			if err != nil {
+				ctx.AbortWithError(400, err)
+				return
+			}
+		}
+	}
+	var channelCost pgtype.Numeric
+	if channelCost.Scan(viper.GetString(channel+".cost")) != nil {
+		channelCost = pgtype.Numeric{Int: big.NewInt(0), Valid: true}
+	}
+	q := sqlc.New(s.db.Reader())
+	if !canSend(ctx, q, req.UserID) {
+		return
+	}
+	phoneChannel := channel == channels.Voice || channel == channels.WhatsApp
+	if phoneChannel && !checkBlocked(ctx, q, req.UserID, req.To) {
+		return
+	}
+	remaining, ok := hasBalance(ctx, q, req.UserID, channelCost)
+	if !ok {
+		return
+	}
+	if phoneChannel && !s.checkFlood(ctx, q, req.UserID, req.To) {
 		return
 	}
 
-	_, err = s.sp.JetStream.Publish(ctx, subject, smsJson)
+	subject, err := channels.RequestSubject(channel)
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	data, err := json.Marshal(channels.Notification{
+		UserID:   req.UserID,
+		Channel:  channel,
+		To:       req.To,
+		Subject:  req.Subject,
+		Message:  req.Message,
+		Template: req.Template,
+		Params:   req.Params,
+		MediaURL: req.MediaURL,
+	})
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return
 	}
-	ctx.JSON(200, gin.H{
-		"msg": "OK",
+	_, err = s.sp.PublishMsg(ctx, &nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  events.Header(events.NewMessageID(), actor(ctx), middlewares.GetRequestID(ctx), acceptedAt),
 	})
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	res := gin.H{
+		"msg":     "OK",
+		"channel": channel,
+	}
+	reportBalance(ctx, res, remaining)
+	ctx.JSON(200, res)
 }
 
-func (s *Sms) GetSmsMessages(ctx *gin.Context) {
-	var query struct {
-		UserID int32 `form:"user_id" binding:"required"`
-		Limit  int32 `form:"limit"`
+// canSend aborts the request with 403 unless the user's account is active.
+func canSend(ctx *gin.Context, q *sqlc.Queries, userID int32) bool {
+	status, err := q.GetUserStatus(ctx, userID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return false
 	}
-	
-	err := ctx.BindQuery(&query)
+	err = policy.AccountError(status)
 	if err != nil {
-		ctx.AbortWithError(400, err)
+		ctx.AbortWithError(403, err)
+		return false
+	}
+	return true
+}
+
+// admitExpress applies the express admission policy of the user to an express
+// req while the express queue is backed up, as of the last refresh of Depth. it
+// reports whether req is downgraded to normal priority, and aborts the request
+// with 503 and reports false when it is refused.
+func (s *Sms) admitExpress(ctx *gin.Context, q *sqlc.Queries, req *smsRequest) (downgraded bool, ok bool) {
+	if !req.Express || s.Depth == nil {
+		return false, true
+	}
+	own, err := q.GetExpressAdmission(ctx, req.UserID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return false, false
+	}
+	backlog := s.Depth.Depths()["express"]
+	latency := s.Depth.Latencies(time.Now())["express"]
+	downgraded, err = s.admission.Admit(own.String, s.admission.BackedUp(backlog, latency), req.AllowDowngrade)
+	if err != nil {
+		metrics.ExpressAdmissions.WithLabelValues("rejected").Inc()
+		ctx.AbortWithError(503, middlewares.WithCode(CodeExpressBackedUp, err))
+		return false, false
+	}
+	if downgraded {
+		metrics.ExpressAdmissions.WithLabelValues("downgraded").Inc()
+	}
+	return downgraded, true
+}
+
+// checkQuota aborts the request with 403 once the user sent its monthly_quota of
+// sms this month.
+func checkQuota(ctx *gin.Context, q *sqlc.Queries, userID int32) bool {
+	usage, err := q.GetQuotaUsage(ctx, userID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return false
+	}
+	if usage.MonthlyQuota.Valid && usage.Used >= int64(usage.MonthlyQuota.Int32) {
+		ctx.AbortWithError(403, ErrQuotaExceeded)
+		return false
+	}
+	return true
+}
+
+// hasBalance aborts the request unless the user can afford cost. it returns the
+// balance left once cost is charged.
+func hasBalance(ctx *gin.Context, q *sqlc.Queries, userID int32, cost pgtype.Numeric) (float64, bool) {
+	balance, err := q.GetBalance(ctx, userID)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return 0, false
+	}
+	// Compare the actual decimal values, not just the integer parts
+	balanceFloat, _ := balance.Float64Value()
+	costFloat, _ := cost.Float64Value()
+	if balanceFloat.Float64 < costFloat.Float64 {
+		ctx.AbortWithError(403, errors.New("not enough balance"))
+		return 0, false
+	}
+	return balanceFloat.Float64 - costFloat.Float64, true
+}
+
+// checkExternalID aborts the request with 409 when the user already sent an sms
+// with externalID. the worker skips the ones this can't see yet, accepted but not
+// stored.
+func checkExternalID(ctx *gin.Context, q *sqlc.Queries, userID int32, externalID pgtype.Text) bool {
+	_, err := q.GetSmsByExternalId(ctx, sqlc.GetSmsByExternalIdParams{
+		UserID:     userID,
+		ExternalID: externalID,
+	})
+	if err == nil {
+		ctx.AbortWithError(409, ErrDuplicateExternalID)
+		return false
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(500, err)
+		return false
+	}
+	return true
+}
+
+// checkBlocked aborts the request with 403 when one of the numbers to is blocked by
+// the user's rules or the global deny-list.
+func checkBlocked(ctx *gin.Context, q *sqlc.Queries, userID int32, to ...string) bool {
+	rules, err := q.GetBlockingRules(ctx, pgtype.Int4{Int32: userID, Valid: true})
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return false
+	}
+	for _, number := range to {
+		if rule, ok := policy.Blocked(rules, number); ok {
+			ctx.AbortWithError(403, middlewares.WithCode(blockCode(rule), policy.BlockError(rule)))
+			return false
+		}
+	}
+	return true
+}
+
+// checkFlood counts a message to the number to against the user's limit per
+// recipient, users.recipient_hourly_limit or api.flood.limit, and aborts the request
+// with 429 once the hour's messages are used up. a limit of 0 disables it.
+func (s *Sms) checkFlood(ctx *gin.Context, q *sqlc.Queries, userID int32, to string) bool {
+	limit := viper.GetInt("api.flood.limit")
+	custom, err := q.GetRecipientLimit(ctx, userID)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return false
+	}
+	if custom.Valid {
+		limit = int(custom.Int32)
+	}
+	if limit <= 0 {
+		return true
+	}
+	ok, _, reset := s.Flood.Take(fmt.Sprintf("flood:%d:%s", userID, phone.Normalize(to)), limit, time.Hour)
+	if !ok {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+		ctx.AbortWithError(429, ErrRecipientFlood)
+		return false
+	}
+	return true
+}
+
+// HeaderBurstCredits is the number of burst credits left under the quota policy of
+// the priority, set while the user has one.
+const HeaderBurstCredits = "X-Burst-Credits"
+
+// checkRateQuota takes a message of priority from the user's quota policy, see
+// SetQuotaPolicy, and aborts the request with 429 once the limit of the window and
+// the burst credits are both used up. users without a policy aren't limited.
+func (s *Sms) checkRateQuota(ctx *gin.Context, q *sqlc.Queries, userID int32, priority string) bool {
+	if s.Quotas == nil {
+		return true
+	}
+	p, err := q.GetQuotaPolicy(ctx, sqlc.GetQuotaPolicyParams{UserID: userID, Priority: priority})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return false
+	}
+	admission, err := s.Quotas.Admit(ctx, QuotaKey(userID, priority), quotaRate(p))
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return false
+	}
+	if !admission.OK {
+		metrics.RateQuotas.WithLabelValues(priority, "refused").Inc()
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(admission.RetryAfter.Seconds()))))
+		ctx.AbortWithError(429, ErrRateQuotaExceeded)
+		return false
+	}
+	if admission.Credit {
+		metrics.RateQuotas.WithLabelValues(priority, "credit").Inc()
+	}
+	ctx.Header(HeaderBurstCredits, strconv.Itoa(admission.Credits))
+	return true
+}
+
+// QuotaKey is the limiter key of the quota policy of a user on priority.
+func QuotaKey(userID int32, priority string) string {
+	return fmt.Sprintf("quota.%d.%s", userID, priority)
+}
+
+// quotaRate converts a quota policy into the bucket of the limiter: the limit of
+// the window refills evenly over it, the burst credits one every recharge_seconds.
+func quotaRate(p sqlc.QuotaPolicy) mynats.Rate {
+	return mynats.Rate{
+		PerSecond: float64(p.SmsLimit) / float64(p.WindowSeconds),
+		Burst:     float64(p.SmsLimit),
+		Credits:   float64(p.BurstCredits),
+		Recharge:  mynats.RechargeEvery(time.Duration(p.RechargeSeconds) * time.Second),
+	}
+}
+
+// HeaderLowBalance is set when the balance fell below api.balance.lowthreshold.
+const HeaderLowBalance = "X-Low-Balance"
+
+// reportBalance adds the remaining balance to res and flags it when it runs low.
+func reportBalance(ctx *gin.Context, res gin.H, remaining float64) {
+	res["remaining_balance"] = strconv.FormatFloat(remaining, 'f', 2, 64)
+	if remaining < viper.GetFloat64("api.balance.lowthreshold") {
+		ctx.Header(HeaderLowBalance, "true")
+	}
+}
+
+// actor names the caller in the sms history.
+func actor(ctx *gin.Context) string {
+	p, ok := middlewares.Principal(ctx)
+	if !ok {
+		return events.ActorApi
+	}
+	return fmt.Sprintf("key:%d", p.KeyID)
+}
+
+// appendFooter adds the user's required footer on a new line unless the message already carries it.
+func appendFooter(message, footer string) string {
+	footer = strings.TrimSpace(footer)
+	if footer == "" || strings.Contains(message, footer) {
+		return message
+	}
+	return message + "\n" + footer
+}
+
+// checkReachable looks up destinations in gated countries and aborts the request
+// unless the subscriber is reachable.
+func (s *Sms) checkReachable(ctx *gin.Context, to string) bool {
+	if s.Lookup == nil {
+		return true
+	}
+	if !slices.Contains(viper.GetStringSlice("hlr.gate.countries"), phone.CountryCode(to)) {
+		return true
+	}
+	res, err := s.Lookup.Lookup(ctx, to)
+	if err != nil {
+		if errors.Is(err, hlr.ErrInvalidNumber) {
+			ctx.AbortWithError(400, err)
+			return false
+		}
+		ctx.AbortWithError(502, err)
+		return false
+	}
+	if !res.Reachable {
+		ctx.AbortWithError(422, ErrUnreachable)
+		return false
+	}
+	return true
+}
+
+// dedupeID identifies a message by sender, destination and body. JetStream drops
+// publishes carrying an id it has already seen within the stream's Duplicates window.
+func dedupeID(sms *sqlc.Sm) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s", sms.UserID, sms.ToPhoneNumber, sms.Message)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// smsListRequest is GET /sms.
+type smsListRequest struct {
+	UserID int32 `form:"user_id" binding:"required"`
+	Limit  int32 `form:"limit"`
+	// ExternalID looks up the message the client sent with this reference
+	ExternalID string `form:"external_id" binding:"max=64"`
+	// Tag only lists the messages carrying it
+	Tag string `form:"tag" binding:"max=64"`
+}
+
+func (s *Sms) GetSmsMessages(ctx *gin.Context) {
+	var query smsListRequest
+	if !bind(ctx, &query) {
 		return
 	}
-	
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+
 	// Set default limit if not provided
 	if query.Limit <= 0 {
 		query.Limit = 10 // Default to 10 messages
 	}
-	
+
 	// Set maximum limit to prevent abuse
 	if query.Limit > 100 {
 		query.Limit = 100
 	}
-	
-	q := sqlc.New(s.db)
-	messages, err := q.GetLastSmsMessages(ctx, sqlc.GetLastSmsMessagesParams{
-		UserID: query.UserID,
-		Limit:  query.Limit,
-	})
+
+	q := sqlc.New(s.db.Reader())
+	if query.ExternalID != "" {
+		s.getSmsByExternalID(ctx, q, query.UserID, query.ExternalID)
+		return
+	}
+	var messages []sqlc.Sm
+	var err error
+	if query.Tag != "" {
+		messages, err = q.GetLastSmsMessagesByTag(ctx, sqlc.GetLastSmsMessagesByTagParams{
+			UserID: query.UserID,
+			Tag:    query.Tag,
+			Limit:  query.Limit,
+		})
+	} else {
+		messages, err = q.GetLastSmsMessages(ctx, sqlc.GetLastSmsMessagesParams{
+			UserID: query.UserID,
+			Limit:  query.Limit,
+		})
+	}
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return
 	}
-	
+
 	// Ensure messages is never nil
 	if messages == nil {
 		messages = []sqlc.Sm{}
 	}
-	
+	maskSms(ctx, messages)
+
 	ctx.JSON(200, gin.H{
 		"messages": messages,
 		"count":    len(messages),
 	})
 }
+
+type tagUsageView struct {
+	Tag       string `json:"tag"`
+	Messages  int64  `json:"messages"`
+	Delivered int64  `json:"delivered"`
+	Failed    int64  `json:"failed"`
+	Cost      string `json:"cost"`
+}
+
+// usageRequest is GET /sms/usage.
+type usageRequest struct {
+	UserID int32     `form:"user_id" binding:"required"`
+	From   time.Time `form:"from" binding:"required"`
+	To     time.Time `form:"to"`
+}
+
+// GetUsage reports a user's messages sent between from and to per tag. a message
+// counts towards every tag it carries. failures counts the failed and expired ones
+// by their normalized reason, see status.FailureReason.
+func (s *Sms) GetUsage(ctx *gin.Context) {
+	var query usageRequest
+	if !bind(ctx, &query) {
+		return
+	}
+	if !middlewares.Owns(ctx, query.UserID) {
+		return
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if !query.To.After(query.From) {
+		ctx.AbortWithError(400, errors.New("to must be after from"))
+		return
+	}
+
+	q := sqlc.New(s.db.Reader())
+	since := pgtype.Timestamp{Time: query.From.UTC(), Valid: true}
+	until := pgtype.Timestamp{Time: query.To.UTC(), Valid: true}
+	rows, err := q.GetTagUsage(ctx, sqlc.GetTagUsageParams{
+		UserID: query.UserID,
+		Since:  since,
+		Until:  until,
+	})
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	tags := make([]tagUsageView, 0, len(rows))
+	for _, row := range rows {
+		tags = append(tags, tagUsageView{
+			Tag:       row.Tag,
+			Messages:  row.Messages,
+			Delivered: row.Delivered,
+			Failed:    row.Failed,
+			Cost:      billing.FormatCents(row.Cents),
+		})
+	}
+	reasons, err := q.GetFailureReasons(ctx, sqlc.GetFailureReasonsParams{
+		UserID: query.UserID,
+		Since:  since,
+		Until:  until,
+	})
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	failures := make(map[string]int64, len(reasons))
+	for _, row := range reasons {
+		failures[row.Reason] = row.Messages
+	}
+	ctx.JSON(200, gin.H{
+		"from":     query.From.UTC(),
+		"to":       query.To.UTC(),
+		"tags":     tags,
+		"failures": failures,
+	})
+}
+
+// getSmsByExternalID answers GET /sms?external_id= with the one message carrying
+// the id, or none.
+func (s *Sms) getSmsByExternalID(ctx *gin.Context, q *sqlc.Queries, userID int32, externalID string) {
+	messages := []sqlc.Sm{}
+	sms, err := q.GetSmsByExternalId(ctx, sqlc.GetSmsByExternalIdParams{
+		UserID:     userID,
+		ExternalID: pgtype.Text{String: externalID, Valid: true},
+	})
+	if err == nil {
+		messages = append(messages, sms)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	maskSms(ctx, messages)
+	ctx.JSON(200, gin.H{
+		"messages": messages,
+		"count":    len(messages),
+	})
+}
+
+// maskSms masks the destinations and bodies of messages unless the caller may
+// see them, see middlewares.Unmasked.
+func maskSms(ctx *gin.Context, messages []sqlc.Sm) {
+	if middlewares.Unmasked(ctx) {
+		return
+	}
+	for i := range messages {
+		messages[i].ToPhoneNumber = mask.Phone(messages[i].ToPhoneNumber)
+		messages[i].Message = mask.Body(messages[i].Message)
+	}
+}
+
+type smsEventView struct {
+	Event      string          `json:"event"`
+	Actor      string          `json:"actor"`
+	Provider   string          `json:"provider,omitempty"`
+	Metadata   json.RawMessage `json:"metadata"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+func newSmsEventView(e sqlc.SmsEvent) smsEventView {
+	return smsEventView{
+		Event:      e.Event,
+		Actor:      e.Actor,
+		Provider:   e.Provider.String,
+		Metadata:   e.Metadata,
+		OccurredAt: e.OccurredAt.Time,
+		RequestID:  e.RequestID.String,
+	}
+}
+
+// smsID reads the :id of the path, the id of an sms or its message_id.
+func smsID(ctx *gin.Context, q *sqlc.Queries) (int32, bool) {
+	param := ctx.Param("id")
+	if id, err := strconv.ParseInt(param, 10, 32); err == nil {
+		return int32(id), true
+	}
+	uid, err := uuid.Parse(param)
+	if err != nil {
+		ctx.AbortWithError(400, errors.New("invalid id"))
+		return 0, false
+	}
+	id, err := q.GetSmsIdByMessageId(ctx, pgtype.UUID{Bytes: uid, Valid: true})
+	if err != nil {
+		abortDB(ctx, err, ErrSmsNotFound, nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// GetSmsEvents returns the lifecycle of an sms in the order it happened.
+func (s *Sms) GetSmsEvents(ctx *gin.Context) {
+	q := sqlc.New(s.db.Reader())
+	id, ok := smsID(ctx, q)
+	if !ok {
+		return
+	}
+	owner, err := q.GetSmsOwner(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(404, ErrSmsNotFound)
+			return
+		}
+		ctx.AbortWithError(500, err)
+		return
+	}
+	if !middlewares.Owns(ctx, owner) {
+		return
+	}
+	history, err := q.GetSmsEvents(ctx, id)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	views := make([]smsEventView, 0, len(history))
+	for _, e := range history {
+		views = append(views, newSmsEventView(e))
+	}
+	ctx.JSON(200, gin.H{
+		"sms_id": id,
+		"events": views,
+	})
+}
+
+// CancelSms cancels an sms that is still pending, i.e. not submitted to a carrier
+// yet, and refunds what the user was charged for it.
+func (s *Sms) CancelSms(ctx *gin.Context) {
+	w := sqlc.New(s.db.Writer())
+	id, ok := smsID(ctx, w)
+	if !ok {
+		return
+	}
+	owner, err := w.GetSmsOwner(ctx, id)
+	if err != nil {
+		abortDB(ctx, err, ErrSmsNotFound, nil)
+		return
+	}
+	if !middlewares.Owns(ctx, owner) {
+		return
+	}
+	var refund pgtype.Numeric
+	err = db.WithTx(ctx, s.db.Writer(), func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		cancelled, err := q.CancelSms(ctx, id)
+		if err != nil {
+			return err
+		}
+		_, err = q.RefundBalance(ctx, sqlc.RefundBalanceParams{
+			Amount: cancelled.Cost,
+			UserID: cancelled.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		refund = cancelled.Cost
+		requestID := middlewares.GetRequestID(ctx)
+		return s.recorder.Record(ctx, q, id,
+			events.Event{
+				Name:      events.Cancelled,
+				Actor:     actor(ctx),
+				RequestID: requestID,
+				Metadata:  map[string]any{"from": status.Pending},
+			},
+			events.Event{
+				Name:      events.Refunded,
+				Actor:     actor(ctx),
+				RequestID: requestID,
+				Metadata:  map[string]any{"amount": cancelled.Cost},
+			},
+		)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(409, ErrSmsNotPending)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	refunded, _ := refund.Float64Value()
+	ctx.JSON(200, gin.H{
+		"sms_id":   id,
+		"status":   status.Cancelled,
+		"refunded": strconv.FormatFloat(refunded.Float64, 'f', 2, 64),
+	})
+}
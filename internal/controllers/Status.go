@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/gin-gonic/gin"
+)
+
+// overall statuses of GET /status
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+)
+
+// Status serves the data of a public status page: the health of the probed
+// providers and how long messages wait in the queues. probe errors are left out,
+// they may name internal endpoints; the prober logs them.
+type Status struct {
+	prober *health.Prober
+	depth  *streams.Depth
+}
+
+type providerStatusView struct {
+	Status    string    `json:"status"`
+	LatencyMs int64     `json:"latency_ms"`
+	Uptime    float64   `json:"uptime"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type queueStatusView struct {
+	Depth     uint64 `json:"depth"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+func NewStatus(prober *health.Prober, depth *streams.Depth) *Status {
+	return &Status{
+		prober: prober,
+		depth:  depth,
+	}
+}
+
+func (s *Status) GetStatus(ctx *gin.Context) {
+	overall := StatusOperational
+	providers := make(map[string]providerStatusView)
+	for name, p := range s.prober.Results() {
+		status := "up"
+		if !p.Healthy {
+			status = "down"
+			overall = StatusDegraded
+		}
+		providers[name] = providerStatusView{
+			Status:    status,
+			LatencyMs: p.Latency.Milliseconds(),
+			Uptime:    p.Uptime,
+			CheckedAt: p.CheckedAt,
+		}
+	}
+	latencies := s.depth.Latencies(time.Now())
+	queues := make(map[string]queueStatusView)
+	for priority, n := range s.depth.Depths() {
+		queues[priority] = queueStatusView{
+			Depth:     n,
+			LatencyMs: latencies[priority].Milliseconds(),
+		}
+	}
+	ctx.JSON(200, gin.H{
+		"status":    overall,
+		"providers": providers,
+		"queues":    queues,
+	})
+}
@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrNestedSubaccount  = errors.New("subaccounts can't have subaccounts")
+	ErrSubaccountPooled  = errors.New("pooled subaccounts spend the balance of their parent")
+	ErrNotEnoughBalance  = errors.New("not enough balance")
+	ErrBalanceOverflow   = errors.New("the balance can't hold more than 99999999.99")
+	ErrSubaccountMissing = errors.New("subaccount not found")
+)
+
+// subaccountRequest is POST /user/{username}/subaccounts.
+type subaccountRequest struct {
+	Username string `json:"username" binding:"required,alphanum,max=255"`
+	// Pooled subaccounts are charged from the balance of their parent
+	Pooled bool `json:"pooled"`
+	// MonthlyQuota caps the sms the subaccount sends per month
+	MonthlyQuota *int32 `json:"monthly_quota" binding:"omitempty,min=1"`
+}
+
+// parent looks up the user of the username parameter, who must not be a
+// subaccount, and aborts the request when it can't have subaccounts.
+func (u *User) parent(ctx *gin.Context, q *sqlc.Queries) (sqlc.User, bool) {
+	username := ctx.Param("username")
+	if !middlewares.OwnsUsername(ctx, username) {
+		return sqlc.User{}, false
+	}
+	parent, err := q.GetUser(ctx, username)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return sqlc.User{}, false
+	}
+	if parent.ParentID.Valid {
+		ctx.AbortWithError(http.StatusConflict, ErrNestedSubaccount)
+		return sqlc.User{}, false
+	}
+	return parent, true
+}
+
+// subaccount looks up the subaccount of the sub parameter under parent.
+func (u *User) subaccount(ctx *gin.Context, q *sqlc.Queries, parent sqlc.User) (sqlc.User, bool) {
+	sub, err := q.GetSubaccount(ctx, sqlc.GetSubaccountParams{
+		ParentID: pgtype.Int4{Int32: parent.ID, Valid: true},
+		Username: ctx.Param("sub"),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrSubaccountMissing, nil)
+		return sqlc.User{}, false
+	}
+	return sub, true
+}
+
+// CreateSubaccount adds a user under the user of the path. its api keys are
+// created like any user's, the parent's keys act on its resources too.
+func (u *User) CreateSubaccount(ctx *gin.Context) {
+	var req subaccountRequest
+	if !bind(ctx, &req) {
+		return
+	}
+	parent, ok := u.parent(ctx, u.db)
+	if !ok {
+		return
+	}
+	params := sqlc.AddSubaccountParams{
+		Username: req.Username,
+		Pooled:   req.Pooled,
+		ParentID: parent.ID,
+	}
+	if req.MonthlyQuota != nil {
+		params.MonthlyQuota = pgtype.Int4{Int32: *req.MonthlyQuota, Valid: true}
+	}
+	sub, err := u.db.AddSubaccount(ctx, params)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, ErrUserAlreadyExists)
+		return
+	}
+	ctx.JSON(http.StatusCreated, newUserView(sub))
+}
+
+// ListSubaccounts lists the subaccounts of the user of the path.
+func (u *User) ListSubaccounts(ctx *gin.Context) {
+	q := u.reader()
+	parent, ok := u.parent(ctx, q)
+	if !ok {
+		return
+	}
+	subs, err := q.GetSubaccounts(ctx, pgtype.Int4{Int32: parent.ID, Valid: true})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]userView, 0, len(subs))
+	for _, sub := range subs {
+		views = append(views, newUserView(sub))
+	}
+	ctx.JSON(200, gin.H{
+		"subaccounts": views,
+	})
+}
+
+// UpdateSubaccount switches a subaccount between pooled and separate balances or
+// changes its quota. fields left out stay as they are, a monthly_quota of 0
+// lifts the quota.
+func (u *User) UpdateSubaccount(ctx *gin.Context) {
+	var req struct {
+		Pooled       *bool  `json:"pooled"`
+		MonthlyQuota *int32 `json:"monthly_quota" binding:"omitempty,min=0"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	parent, ok := u.parent(ctx, u.db)
+	if !ok {
+		return
+	}
+	sub, ok := u.subaccount(ctx, u.db, parent)
+	if !ok {
+		return
+	}
+	params := sqlc.UpdateSubaccountParams{ID: sub.ID}
+	if req.Pooled != nil {
+		params.Pooled = pgtype.Bool{Bool: *req.Pooled, Valid: true}
+	}
+	if req.MonthlyQuota != nil {
+		params.SetMonthlyQuota = true
+		if *req.MonthlyQuota > 0 {
+			params.MonthlyQuota = pgtype.Int4{Int32: *req.MonthlyQuota, Valid: true}
+		}
+	}
+	sub, err := u.db.UpdateSubaccount(ctx, params)
+	if err != nil {
+		abortDB(ctx, err, ErrSubaccountMissing, nil)
+		return
+	}
+	ctx.JSON(200, newUserView(sub))
+}
+
+// TransferBalance moves amount from the parent to a subaccount holding its own
+// balance, or back to the parent when amount is negative.
+func (u *User) TransferBalance(ctx *gin.Context) {
+	var req struct {
+		Amount string `json:"amount" binding:"required"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	amount, ok := transferAmount(ctx, req.Amount)
+	if !ok {
+		return
+	}
+	parent, ok := u.parent(ctx, u.db)
+	if !ok {
+		return
+	}
+	sub, ok := u.subaccount(ctx, u.db, parent)
+	if !ok {
+		return
+	}
+	if sub.Pooled {
+		ctx.AbortWithError(http.StatusConflict, ErrSubaccountPooled)
+		return
+	}
+	balances, ok := moveBalance(ctx, u.cluster, parent.ID, sub.ID, amount)
+	if !ok {
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"parent_balance": billing.FormatCents(billing.Cents(balances[parent.ID])),
+		"balance":        billing.FormatCents(billing.Cents(balances[sub.ID])),
+	})
+}
+
+var (
+	errAmountFormat = errors.New("must be a decimal with at most 2 decimals")
+	// the range of a DECIMAL(10, 2) balance
+	errAmountRange = errors.New("must be at most 99999999.99")
+	amountPattern  = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]{1,2})?$`)
+)
+
+// parseCents parses a decimal amount like "12.50" into cents. amounts with
+// fractions of a cent, or too large for a balance, are refused rather than
+// rounded.
+func parseCents(amount string) (int64, error) {
+	if !amountPattern.MatchString(amount) {
+		return 0, errAmountFormat
+	}
+	sign := int64(1)
+	switch amount[0] {
+	case '-':
+		sign, amount = -1, amount[1:]
+	case '+':
+		amount = amount[1:]
+	}
+	whole, frac, _ := strings.Cut(amount, ".")
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole) > 8 {
+		return 0, errAmountRange
+	}
+	cents, err := strconv.ParseInt(whole+(frac + "00")[:2], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sign * cents, nil
+}
+
+// transferAmount parses the amount of a balance transfer in cents, aborting with
+// 400 unless it is a non-zero decimal parseCents takes.
+func transferAmount(ctx *gin.Context, amount string) (int64, bool) {
+	cents, err := parseCents(amount)
+	if err == nil && cents == 0 {
+		err = errors.New("must not be zero")
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("amount %w", err))
+		return 0, false
+	}
+	return cents, true
+}
+
+// moveBalance moves cents from the user from to the user to in one transaction,
+// or the other way when cents is negative. it reports the new balance of both
+// by their id, and aborts with 409 when the user the funds leave doesn't hold
+// them or either is pooled.
+func moveBalance(ctx *gin.Context, cluster *db.Cluster, from, to int32, cents int64) (map[int32]pgtype.Numeric, bool) {
+	if cents < 0 {
+		from, to, cents = to, from, -cents
+	}
+	amount := billing.Amount(cents)
+	balances := make(map[int32]pgtype.Numeric, 2)
+	err := db.WithTx(ctx, cluster.Writer(), func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		var err error
+		balances[from], err = q.WithdrawBalance(ctx, sqlc.WithdrawBalanceParams{Amount: amount, UserID: from})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotEnoughBalance
+		}
+		if err != nil {
+			return err
+		}
+		balances[to], err = q.DepositBalance(ctx, sqlc.DepositBalanceParams{Amount: amount, UserID: to})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrSubaccountPooled
+		}
+		// the sum exceeds the DECIMAL(10, 2) of the balance
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgNumericOverflow {
+			return ErrBalanceOverflow
+		}
+		return err
+	})
+	if errors.Is(err, ErrNotEnoughBalance) || errors.Is(err, ErrSubaccountPooled) || errors.Is(err, ErrBalanceOverflow) {
+		ctx.AbortWithError(http.StatusConflict, err)
+		return nil, false
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return balances, true
+}
+
+type accountUsageView struct {
+	UserID    int32  `json:"user_id"`
+	Username  string `json:"username"`
+	Messages  int64  `json:"messages"`
+	Delivered int64  `json:"delivered"`
+	Failed    int64  `json:"failed"`
+	Cost      string `json:"cost"`
+}
+
+// usagePeriod is the period of a usage rollup, to defaults to now.
+type usagePeriod struct {
+	From time.Time `form:"from" binding:"required"`
+	To   time.Time `form:"to"`
+}
+
+// bindPeriod binds the period of a usage rollup and aborts with 400 when it
+// doesn't bind or ends before it starts.
+func bindPeriod(ctx *gin.Context) (usagePeriod, bool) {
+	var period usagePeriod
+	if !bind(ctx, &period) {
+		return period, false
+	}
+	if period.To.IsZero() {
+		period.To = time.Now()
+	}
+	if !period.To.After(period.From) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("to must be after from"))
+		return period, false
+	}
+	return period, true
+}
+
+// writeRollup answers with the usage of every account in rows and their total.
+func writeRollup(ctx *gin.Context, period usagePeriod, rows []sqlc.GetSubaccountUsageRow) {
+	accounts := make([]accountUsageView, 0, len(rows))
+	var total accountUsageView
+	var totalCents int64
+	for _, row := range rows {
+		accounts = append(accounts, accountUsageView{
+			UserID:    row.UserID,
+			Username:  row.Username,
+			Messages:  row.Messages,
+			Delivered: row.Delivered,
+			Failed:    row.Failed,
+			Cost:      billing.FormatCents(row.Cents),
+		})
+		total.Messages += row.Messages
+		total.Delivered += row.Delivered
+		total.Failed += row.Failed
+		totalCents += row.Cents
+	}
+	ctx.JSON(200, gin.H{
+		"from":     period.From.UTC(),
+		"to":       period.To.UTC(),
+		"accounts": accounts,
+		"total": gin.H{
+			"messages":  total.Messages,
+			"delivered": total.Delivered,
+			"failed":    total.Failed,
+			"cost":      billing.FormatCents(totalCents),
+		},
+	})
+}
+
+// GetSubaccountUsage rolls up the messages the user of the path and its
+// subaccounts sent between from and to, per account and in total.
+func (u *User) GetSubaccountUsage(ctx *gin.Context) {
+	period, ok := bindPeriod(ctx)
+	if !ok {
+		return
+	}
+	q := u.reader()
+	parent, ok := u.parent(ctx, q)
+	if !ok {
+		return
+	}
+	rows, err := q.GetSubaccountUsage(ctx, sqlc.GetSubaccountUsageParams{
+		Since:    pgtype.Timestamp{Time: period.From.UTC(), Valid: true},
+		Until:    pgtype.Timestamp{Time: period.To.UTC(), Valid: true},
+		ParentID: parent.ID,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	writeRollup(ctx, period, rows)
+}
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrTokenNotFound = errors.New("service token not found")
+	ErrNotAToken     = errors.New("only service tokens can rotate themselves")
+)
+
+// Token manages service tokens: API keys for infrastructure tooling that expire,
+// can be rotated, and can be verified without the database when they are signed,
+// see middlewares.Tokens. they are stored in api_keys like every key, so scopes,
+// allowed cidrs, rate limits and the fraud detector apply to them the same.
+type Token struct {
+	*Base
+	cluster *db.Cluster
+	signer  *auth.Signer
+}
+
+type tokenView struct {
+	ID           int32    `json:"id"`
+	Name         string   `json:"name"`
+	Hint         string   `json:"hint"`
+	Scopes       []string `json:"scopes"`
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+	CreatedAt    string   `json:"created_at"`
+	ExpiresAt    string   `json:"expires_at"`
+	LastUsedAt   string   `json:"last_used_at,omitempty"`
+	Revoked      bool     `json:"revoked"`
+	Suspended    bool     `json:"suspended"`
+	// RotatedFrom is the token this one replaced
+	RotatedFrom int32 `json:"rotated_from,omitempty"`
+}
+
+func newTokenView(k sqlc.ApiKey) tokenView {
+	cidrs := make([]string, 0, len(k.AllowedCidrs))
+	for _, c := range k.AllowedCidrs {
+		cidrs = append(cidrs, c.String())
+	}
+	v := tokenView{
+		ID:           k.ID,
+		Name:         k.Name,
+		Hint:         k.Prefix,
+		Scopes:       k.Scopes,
+		AllowedCIDRs: cidrs,
+		CreatedAt:    k.CreatedAt.Time.UTC().Format(time.RFC3339),
+		ExpiresAt:    k.ExpiresAt.Time.UTC().Format(time.RFC3339),
+		Revoked:      k.RevokedAt.Valid,
+		Suspended:    k.SuspendedAt.Valid,
+		RotatedFrom:  k.RotatedFrom.Int32,
+	}
+	if k.LastUsedAt.Valid {
+		v.LastUsedAt = k.LastUsedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// NewToken issues signed tokens when signer is set, random ones otherwise.
+func NewToken(parent *Versions, cluster *db.Cluster, signer *auth.Signer) *Token {
+	base := NewBase("/tokens", parent, middlewares.WriteErrorBody)
+	t := &Token{
+		base,
+		cluster,
+		signer,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.POST("/rotate", t.RotateSelf)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserAdmin), t.CreateToken)
+		gp.GET("/user/:username", middlewares.RequireScopes(auth.ScopeUserAdmin), t.GetTokens)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserAdmin), t.RevokeToken)
+		gp.POST("/:id/rotate", middlewares.RequireScopes(auth.ScopeUserAdmin), t.RotateToken)
+	})
+
+	return t
+}
+
+// ttl is the lifetime of a token asked for in seconds, api.auth.tokens.defaultttl
+// when zero.
+func ttl(seconds int64) time.Duration {
+	if seconds == 0 {
+		return viper.GetDuration("api.auth.tokens.defaultttl")
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// issue stores a new service token and returns it in clear. the id is reserved
+// first so signed tokens can carry it.
+func (t *Token) issue(ctx context.Context, q *sqlc.Queries, claims auth.Claims, name string, rotatedFrom pgtype.Int4) (string, sqlc.ApiKey, error) {
+	id, err := q.NextApiKeyId(ctx)
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	claims.ID = id
+	token, hint, hash, err := auth.GenerateToken(t.signer, claims)
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	allowed, err := auth.ParseCIDRs(claims.AllowedCIDRs)
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	row, err := q.AddServiceToken(ctx, sqlc.AddServiceTokenParams{
+		ID:           id,
+		UserID:       claims.UserID,
+		Name:         name,
+		Prefix:       hint,
+		KeyHash:      hash,
+		Scopes:       claims.Scopes,
+		AllowedCidrs: allowed,
+		ExpiresAt:    pgtype.Timestamp{Time: time.Unix(claims.ExpiresAt, 0).UTC(), Valid: true},
+		RotatedFrom:  rotatedFrom,
+	})
+	if err != nil {
+		return "", sqlc.ApiKey{}, err
+	}
+	return token, row, nil
+}
+
+// CreateToken issues a service token to a user, expiring after ttl_seconds, or
+// api.auth.tokens.defaultttl. no token outlives api.auth.tokens.maxttl.
+func (t *Token) CreateToken(ctx *gin.Context) {
+	var req struct {
+		Username string   `json:"username" binding:"required"`
+		Name     string   `json:"name" binding:"required,max=255"`
+		Scopes   []string `json:"scopes" binding:"required"`
+		// AllowedCIDRs restricts the token to these client address ranges
+		AllowedCIDRs []string `json:"allowed_cidrs"`
+		TTLSeconds   int64    `json:"ttl_seconds" binding:"omitempty,min=60"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	err := auth.ValidateScopes(req.Scopes)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	_, err = auth.ParseCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	q := sqlc.New(t.cluster.Writer())
+	userId, err := q.GetUserId(ctx, req.Username)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	now := time.Now()
+	token, row, err := t.issue(ctx, q, auth.Claims{
+		UserID:       userId,
+		Username:     req.Username,
+		Scopes:       req.Scopes,
+		AllowedCIDRs: req.AllowedCIDRs,
+		IssuedAt:     now.Unix(),
+		ExpiresAt:    auth.TokenExpiry(now, ttl(req.TTLSeconds), viper.GetDuration("api.auth.tokens.maxttl")).Unix(),
+	}, req.Name, pgtype.Int4{})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"token":         token,
+		"service_token": newTokenView(row),
+	})
+}
+
+func (t *Token) GetTokens(ctx *gin.Context) {
+	q := sqlc.New(t.cluster.Reader())
+	userId, err := q.GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	tokens, err := q.GetServiceTokensByUser(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]tokenView, 0, len(tokens))
+	for _, token := range tokens {
+		views = append(views, newTokenView(token))
+	}
+	ctx.JSON(200, views)
+}
+
+func (t *Token) RevokeToken(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	q := sqlc.New(t.cluster.Writer())
+	_, err = q.GetServiceToken(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrTokenNotFound, nil)
+		return
+	}
+	_, err = q.RevokeApiKey(ctx, int32(id))
+	if err != nil {
+		abortDB(ctx, err, ErrTokenNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// RotateToken replaces a service token with a new one with the same name, scopes
+// and allowed cidrs. see rotate.
+func (t *Token) RotateToken(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	t.rotate(ctx, int32(id))
+}
+
+// RotateSelf replaces the service token of the request, so tooling can rotate its
+// own credentials without an admin key.
+func (t *Token) RotateSelf(ctx *gin.Context) {
+	p, ok := middlewares.Principal(ctx)
+	if !ok || p.KeyID == 0 {
+		ctx.AbortWithError(http.StatusForbidden, ErrNotAToken)
+		return
+	}
+	t.rotate(ctx, p.KeyID)
+}
+
+// rotate issues the replacement of the token id and has the old one expire after
+// grace_seconds, or api.auth.tokens.rotation.grace, so both work while the new one
+// is rolled out. the new one lives as long as the old one did unless ttl_seconds
+// is set. rotating a revoked or expired token fails with 404.
+func (t *Token) rotate(ctx *gin.Context, id int32) {
+	var req struct {
+		TTLSeconds   int64  `json:"ttl_seconds" binding:"omitempty,min=60"`
+		GraceSeconds *int32 `json:"grace_seconds" binding:"omitempty,min=0,max=604800"`
+	}
+	if !bind(ctx, &req) {
+		return
+	}
+	grace := viper.GetDuration("api.auth.tokens.rotation.grace")
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+
+	var token string
+	var row sqlc.ApiKey
+	err := db.WithTx(ctx, t.cluster.Writer(), func(tx pgx.Tx) error {
+		q := sqlc.New(tx)
+		old, err := q.GetServiceToken(ctx, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if old.RevokedAt.Valid || !old.ExpiresAt.Time.After(now) {
+			return pgx.ErrNoRows
+		}
+		lifetime := old.ExpiresAt.Time.Sub(old.CreatedAt.Time)
+		if req.TTLSeconds != 0 {
+			lifetime = ttl(req.TTLSeconds)
+		}
+		cidrs := make([]string, 0, len(old.AllowedCidrs))
+		for _, c := range old.AllowedCidrs {
+			cidrs = append(cidrs, c.String())
+		}
+		token, row, err = t.issue(ctx, q, auth.Claims{
+			UserID:       old.UserID,
+			Username:     old.Username,
+			Scopes:       old.Scopes,
+			AllowedCIDRs: cidrs,
+			IssuedAt:     now.Unix(),
+			ExpiresAt:    auth.TokenExpiry(now, lifetime, viper.GetDuration("api.auth.tokens.maxttl")).Unix(),
+		}, old.Name, pgtype.Int4{Int32: old.ID, Valid: true})
+		if err != nil {
+			return err
+		}
+		_, err = q.ExpireApiKey(ctx, sqlc.ExpireApiKeyParams{
+			ExpiresAt: pgtype.Timestamp{Time: now.Add(grace).UTC(), Valid: true},
+			ID:        old.ID,
+		})
+		return err
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrTokenNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"token":         token,
+		"service_token": newTokenView(row),
+	})
+}
+
+// DisabledTokens lists the service tokens that were revoked, suspended or rotated
+// out, see middlewares.Tokens. tokens older than api.auth.tokens.maxttl have
+// expired by their signature already.
+func DisabledTokens(cluster *db.Cluster) middlewares.DisabledTokens {
+	return func(ctx context.Context) ([]int32, error) {
+		issuedAfter := time.Now().UTC().Add(-viper.GetDuration("api.auth.tokens.maxttl"))
+		return sqlc.New(cluster.Reader()).GetDisabledServiceTokens(ctx, pgtype.Timestamp{Time: issuedAfter, Valid: true})
+	}
+}
+
+// TouchKeys records when keys were last used, see middlewares.LastUsed.
+func TouchKeys(cluster *db.Cluster) middlewares.TouchKeys {
+	return func(ctx context.Context, ids []int32, at []time.Time) error {
+		used := make([]pgtype.Timestamp, len(at))
+		for i, t := range at {
+			used[i] = pgtype.Timestamp{Time: t.UTC(), Valid: true}
+		}
+		return sqlc.New(cluster.Writer()).TouchApiKeys(ctx, sqlc.TouchApiKeysParams{
+			Ids:    ids,
+			UsedAt: used,
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/gsm"
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// TwilioAPIVersion is the version of the Twilio api the compatibility routes mimic.
+const TwilioAPIVersion = "2010-04-01"
+
+// twilio error codes, see https://www.twilio.com/docs/api/errors
+const (
+	twilioInvalidParameter = 20001
+	twilioPermissionDenied = 20003
+	twilioNotFound         = 20404
+	twilioTooManyRequests  = 20429
+	twilioInternalError    = 20500
+	twilioBodyRequired     = 21602
+	twilioFromRequired     = 21603
+	twilioToRequired       = 21604
+	twilioFromNotOwned     = 21606
+	twilioRecipientBlocked = 21610
+	twilioBodyTooLong      = 21617
+	twilioValidityExpired  = 30036
+)
+
+var (
+	ErrTwilioBody    = &twilioError{Code: twilioBodyRequired, Err: errors.New("message body is required")}
+	ErrTwilioFrom    = &twilioError{Code: twilioFromRequired, Err: errors.New("a 'From' phone number is required")}
+	ErrTwilioTo      = &twilioError{Code: twilioToRequired, Err: errors.New("a 'To' phone number is required")}
+	ErrTwilioSender  = &twilioError{Code: twilioFromNotOwned, Err: errors.New("the 'From' phone number is not one of the account's numbers")}
+	ErrTwilioLength  = &twilioError{Code: twilioBodyTooLong, Err: ErrMessageTooLong}
+	ErrTwilioMessage = &twilioError{Code: twilioNotFound, Err: ErrSmsNotFound}
+)
+
+// twilioError tags err with the Twilio error code twilioErrorBody reports.
+type twilioError struct {
+	Code int
+	Err  error
+}
+
+func (e *twilioError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *twilioError) Unwrap() error {
+	return e.Err
+}
+
+// Twilio serves the messages resource of Twilio's REST api, for clients migrating
+// from Twilio that can only change the base url. the account sid in the path is the
+// username, the auth token an api key (see middlewares.Authenticate). requests go
+// through the same checks as POST /sms.
+type Twilio struct {
+	sms *Sms
+	db  *db.Cluster
+}
+
+type twilioMessage struct {
+	Sid          string  `json:"sid"`
+	AccountSid   string  `json:"account_sid"`
+	From         string  `json:"from"`
+	To           string  `json:"to"`
+	Body         string  `json:"body"`
+	Status       string  `json:"status"`
+	NumSegments  string  `json:"num_segments"`
+	Direction    string  `json:"direction"`
+	APIVersion   string  `json:"api_version"`
+	Price        *string `json:"price"`
+	ErrorCode    *int    `json:"error_code"`
+	ErrorMessage *string `json:"error_message"`
+	DateCreated  string  `json:"date_created"`
+	DateUpdated  string  `json:"date_updated"`
+	DateSent     *string `json:"date_sent"`
+	URI          string  `json:"uri"`
+}
+
+// NewTwilio registers the compatibility routes under /twilio/2010-04-01 of parent,
+// outside the versioned api: their shape is Twilio's.
+func NewTwilio(parent *gin.RouterGroup, sms *Sms, cluster *db.Cluster) *Twilio {
+	t := &Twilio{
+		sms: sms,
+		db:  cluster,
+	}
+
+	gp := parent.Group("/twilio/"+TwilioAPIVersion+"/Accounts/:account", twilioErrorBody)
+	gp.POST("/Messages.json", middlewares.RequireScopes(auth.ScopeSmsSend), t.CreateMessage)
+	gp.GET("/Messages/:sid", middlewares.RequireScopes(auth.ScopeSmsRead), t.GetMessage)
+
+	return t
+}
+
+// CreateMessage queues the form encoded message of a Twilio client as a normal sms.
+func (t *Twilio) CreateMessage(ctx *gin.Context) {
+	var form struct {
+		To   string `form:"To"`
+		From string `form:"From"`
+		Body string `form:"Body"`
+		// ValidityPeriod is in seconds, like validity_period
+		ValidityPeriod int64 `form:"ValidityPeriod" binding:"min=0"`
+	}
+	err := ctx.ShouldBindWith(&form, binding.Form)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case form.To == "":
+		ctx.AbortWithError(http.StatusBadRequest, ErrTwilioTo)
+		return
+	case form.From == "":
+		ctx.AbortWithError(http.StatusBadRequest, ErrTwilioFrom)
+		return
+	case form.Body == "":
+		ctx.AbortWithError(http.StatusBadRequest, ErrTwilioBody)
+		return
+	case utf8.RuneCountInString(form.Body) > maxMessageLength:
+		ctx.AbortWithError(http.StatusBadRequest, ErrTwilioLength)
+		return
+	}
+	account := ctx.Param("account")
+	userID, ok := t.account(ctx, account)
+	if !ok {
+		return
+	}
+	phoneID, err := sqlc.New(t.db.Reader()).GetPhoneNumberId(ctx, sqlc.GetPhoneNumberIdParams{
+		UserID:      userID,
+		PhoneNumber: form.From,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.AbortWithError(http.StatusBadRequest, ErrTwilioSender)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	queued, ok := t.sms.queueSms(ctx, &smsRequest{
+		UserID:         userID,
+		PhoneNumberID:  phoneID,
+		ToPhoneNumber:  form.To,
+		Message:        form.Body,
+		ValidityPeriod: form.ValidityPeriod,
+	})
+	if !ok {
+		return
+	}
+	// twilio has no status for duplicates dropped in indicate mode, they are queued
+	// as far as the client can tell
+	now := time.Now().UTC().Format(time.RFC1123Z)
+	// twilio sids are SM and 32 hex digits
+	sid := "SM" + strings.ReplaceAll(queued.MessageID, "-", "")
+	ctx.JSON(http.StatusCreated, twilioMessage{
+		Sid:         sid,
+		AccountSid:  account,
+		From:        form.From,
+		To:          queued.Sms.ToPhoneNumber,
+		Body:        queued.Sms.Message,
+		Status:      "queued",
+		NumSegments: strconv.Itoa(gsm.Segments(queued.Sms.Message)),
+		Direction:   "outbound-api",
+		APIVersion:  TwilioAPIVersion,
+		DateCreated: now,
+		DateUpdated: now,
+		URI:         twilioMessageURI(account, sid),
+	})
+}
+
+// GetMessage looks up a message by the sid CreateMessage returned. messages the
+// workers haven't stored yet aren't found.
+func (t *Twilio) GetMessage(ctx *gin.Context) {
+	account := ctx.Param("account")
+	userID, ok := t.account(ctx, account)
+	if !ok {
+		return
+	}
+	sid := strings.TrimSuffix(ctx.Param("sid"), ".json")
+	id, ok := strings.CutPrefix(sid, "SM")
+	if !ok {
+		ctx.AbortWithError(http.StatusNotFound, ErrTwilioMessage)
+		return
+	}
+	// sms accepted before they had a public id were sent with a random message id
+	messageIDs := []string{id}
+	if uid, err := uuid.Parse(id); err == nil {
+		messageIDs = append(messageIDs, uid.String())
+	}
+	sms, err := sqlc.New(t.db.Reader()).GetSmsByMessageId(ctx, sqlc.GetSmsByMessageIdParams{
+		MessageIds: messageIDs,
+		UserID:     userID,
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrTwilioMessage, nil)
+		return
+	}
+
+	st := status.Status(sms.Status)
+	created := sms.DeliveredAt.Time.Format(time.RFC1123Z)
+	msg := twilioMessage{
+		Sid:         sid,
+		AccountSid:  account,
+		From:        sms.PhoneNumber,
+		To:          sms.ToPhoneNumber,
+		Body:        sms.Message,
+		Status:      twilioStatus(st),
+		NumSegments: strconv.Itoa(gsm.Segments(sms.Message)),
+		Direction:   "outbound-api",
+		APIVersion:  TwilioAPIVersion,
+		DateCreated: created,
+		DateUpdated: created,
+		URI:         twilioMessageURI(account, sid),
+	}
+	if !middlewares.Unmasked(ctx) {
+		msg.To, msg.Body = mask.Phone(msg.To), mask.Body(msg.Body)
+	}
+	if cents := billing.Cents(sms.Cost); cents > 0 {
+		// twilio reports what was charged as a negative amount
+		price := billing.FormatCents(-cents)
+		msg.Price = &price
+	}
+	if st == status.Submitted || st == status.Delivered || st == status.Failed {
+		msg.DateSent = &created
+	}
+	if st == status.Expired {
+		code, reason := twilioValidityExpired, "validity period expired"
+		msg.ErrorCode, msg.ErrorMessage = &code, &reason
+	}
+	ctx.JSON(http.StatusOK, msg)
+}
+
+// account resolves the account sid in the path, the username, to the user's id. it
+// aborts the request unless the caller owns the account.
+func (t *Twilio) account(ctx *gin.Context, account string) (int32, bool) {
+	if !middlewares.OwnsUsername(ctx, account) {
+		return 0, false
+	}
+	userID, err := sqlc.New(t.db.Reader()).GetUserId(ctx, account)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return 0, false
+	}
+	return userID, true
+}
+
+func twilioMessageURI(account, sid string) string {
+	return fmt.Sprintf("/%s/Accounts/%s/Messages/%s.json", TwilioAPIVersion, account, sid)
+}
+
+// twilioStatus maps an sms status to the closest Twilio message status.
+func twilioStatus(st status.Status) string {
+	switch st {
+	case status.Submitted:
+		return "sent"
+	case status.Delivered:
+		return "delivered"
+	case status.Failed, status.Expired:
+		return "failed"
+	case status.Cancelled:
+		return "canceled"
+	}
+	return "queued"
+}
+
+// twilioErrorBody answers errors the way Twilio does, with the Twilio error code
+// closest to the error.
+func twilioErrorBody(ctx *gin.Context) {
+	ctx.Next()
+	if len(ctx.Errors) == 0 {
+		return
+	}
+	code := ctx.Writer.Status()
+	err := ctx.Errors[0].Err
+	twilioCode := twilioErrorCode(code, err)
+	ctx.JSON(code, gin.H{
+		"code":      twilioCode,
+		"message":   err.Error(),
+		"more_info": fmt.Sprintf("https://www.twilio.com/docs/errors/%d", twilioCode),
+		"status":    code,
+	})
+}
+
+func twilioErrorCode(httpStatus int, err error) int {
+	var tErr *twilioError
+	if errors.As(err, &tErr) {
+		return tErr.Code
+	}
+	var coded *middlewares.CodedError
+	if errors.As(err, &coded) {
+		switch coded.Code {
+		case CodeDestinationBlocked, CodeFraudRange:
+			return twilioRecipientBlocked
+		case CodeRecipientFlood:
+			return twilioTooManyRequests
+		}
+	}
+	switch {
+	case httpStatus == http.StatusUnauthorized || httpStatus == http.StatusForbidden:
+		return twilioPermissionDenied
+	case httpStatus == http.StatusNotFound:
+		return twilioNotFound
+	case httpStatus == http.StatusTooManyRequests:
+		return twilioTooManyRequests
+	case httpStatus >= http.StatusInternalServerError:
+		return twilioInternalError
+	}
+	return twilioInvalidParameter
+}
@@ -3,34 +3,107 @@ package controllers
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
 	"github.com/alireza-karampour/sms/pkg/middlewares"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/viper"
 )
 
 var (
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrUserNotFound      = errors.New("user not found")
 )
 
 type User struct {
 	*Base
-	db *sqlc.Queries
+	db      *sqlc.Queries
+	cluster *db.Cluster
+	// Users is the base of the routes on the collection of users
+	Users *Base
 }
 
-func NewUser(parent *gin.RouterGroup, db *pgxpool.Pool) *User {
+type userView struct {
+	ID       int32  `json:"id"`
+	Username string `json:"username"`
+	Balance  string `json:"balance"`
+	Footer   string `json:"footer,omitempty"`
+	Status   string `json:"status"`
+	// RecipientHourlyLimit is only set when it overrides api.flood.limit
+	RecipientHourlyLimit *int32 `json:"recipient_hourly_limit,omitempty"`
+	// ParentID is set on subaccounts
+	ParentID *int32 `json:"parent_id,omitempty"`
+	// Pooled subaccounts are charged from the balance of their parent
+	Pooled       bool   `json:"pooled,omitempty"`
+	MonthlyQuota *int32 `json:"monthly_quota,omitempty"`
+	// ResellerID, SmsPrice and Sender are set on the customers of resellers
+	ResellerID *int32 `json:"reseller_id,omitempty"`
+	SmsPrice   string `json:"sms_price,omitempty"`
+	Sender     string `json:"sender,omitempty"`
+	// ExpressAdmission is only set when it overrides api.express.admission.policy
+	ExpressAdmission string `json:"express_admission,omitempty"`
+}
+
+func newUserView(u sqlc.User) userView {
+	balance, _ := u.Balance.Float64Value()
+	v := userView{
+		ID:       u.ID,
+		Username: u.Username,
+		Balance:  strconv.FormatFloat(balance.Float64, 'f', 2, 64),
+		Footer:   u.Footer.String,
+		Status:   u.Status,
+		Pooled:   u.Pooled,
+	}
+	if u.RecipientHourlyLimit.Valid {
+		v.RecipientHourlyLimit = &u.RecipientHourlyLimit.Int32
+	}
+	if u.ParentID.Valid {
+		v.ParentID = &u.ParentID.Int32
+	}
+	if u.MonthlyQuota.Valid {
+		v.MonthlyQuota = &u.MonthlyQuota.Int32
+	}
+	if u.ResellerID.Valid {
+		v.ResellerID = &u.ResellerID.Int32
+	}
+	if u.SmsPrice.Valid {
+		v.SmsPrice = billing.FormatCents(billing.Cents(u.SmsPrice))
+	}
+	v.Sender = u.Sender.String
+	v.ExpressAdmission = u.ExpressAdmission.String
+	return v
+}
+
+func NewUser(parent *Versions, cluster *db.Cluster) *User {
 	base := NewBase("/user", parent, middlewares.WriteErrorBody)
 	user := &User{
 		base,
-		sqlc.New(db),
+		sqlc.New(cluster.Writer()),
+		cluster,
+		NewBase("/users", parent, middlewares.WriteErrorBody),
 	}
 
 	base.RegisterRoutes(func(gp *gin.RouterGroup) {
-		gp.GET("/:username", user.GetUserId)
-		gp.POST("", user.CreateNewUser)
-		gp.PUT("/balance", user.AddBalance)
+		gp.GET("/:username", middlewares.RequireScopes(auth.ScopeUserRead), user.GetUser)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserAdmin), user.CreateNewUser)
+		gp.PATCH("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), user.UpdateUser)
+		gp.PUT("/balance", middlewares.RequireScopes(auth.ScopeUserAdmin), user.AddBalance)
+		gp.GET("/:username/balance", middlewares.RequireScopes(auth.ScopeUserRead), user.GetBalance)
+		gp.PUT("/:username/footer", middlewares.RequireScopes(auth.ScopeUserWrite), user.SetFooter)
+		gp.GET("/:username/subaccounts", middlewares.RequireScopes(auth.ScopeUserRead), user.ListSubaccounts)
+		gp.POST("/:username/subaccounts", middlewares.RequireScopes(auth.ScopeUserWrite), user.CreateSubaccount)
+		gp.GET("/:username/subaccounts/usage", middlewares.RequireScopes(auth.ScopeUserRead), user.GetSubaccountUsage)
+		gp.PATCH("/:username/subaccounts/:sub", middlewares.RequireScopes(auth.ScopeUserWrite), user.UpdateSubaccount)
+		gp.POST("/:username/subaccounts/:sub/transfer", middlewares.RequireScopes(auth.ScopeUserWrite), user.TransferBalance)
+	})
+	user.Users.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserAdmin), user.ListUsers)
 	})
 
 	return user
@@ -46,20 +119,20 @@ func (u *User) CreateNewUser(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
-	
+
 	balance := pgtype.Numeric{}
 	err = balance.Scan(req.Balance)
 	if err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
-	
+
 	err = u.db.AddUser(ctx, sqlc.AddUserParams{
 		Username: req.Username,
 		Balance:  balance,
 	})
 	if err != nil {
-		ctx.AbortWithError(500, err)
+		abortDB(ctx, err, ErrUserNotFound, ErrUserAlreadyExists)
 		return
 	}
 
@@ -79,7 +152,7 @@ func (u *User) AddBalance(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
-	
+
 	balance := pgtype.Numeric{}
 	err = balance.Scan(req.Balance)
 	if err != nil {
@@ -92,7 +165,7 @@ func (u *User) AddBalance(ctx *gin.Context) {
 		Username: req.Username,
 	})
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+		abortDB(ctx, err, ErrUserNotFound, nil)
 		return
 	}
 
@@ -104,19 +177,150 @@ func (u *User) AddBalance(ctx *gin.Context) {
 	return
 }
 
-func (u *User) GetUserId(ctx *gin.Context) {
+// GetBalance returns what the user has left to spend.
+func (u *User) GetBalance(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if !middlewares.OwnsUsername(ctx, username) {
+		return
+	}
+	balance, err := u.reader().GetBalanceByUsername(ctx, username)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	f, _ := balance.Float64Value()
+	low := f.Float64 < viper.GetFloat64("api.balance.lowthreshold")
+	if low {
+		ctx.Header(HeaderLowBalance, "true")
+	}
+	ctx.JSON(200, gin.H{
+		"username":    username,
+		"balance":     strconv.FormatFloat(f.Float64, 'f', 2, 64),
+		"low_balance": low,
+	})
+}
+
+func (u *User) reader() *sqlc.Queries {
+	return sqlc.New(u.cluster.Reader())
+}
+
+func (u *User) GetUser(ctx *gin.Context) {
 	username := ctx.Param("username")
 	if username == "" {
 		ctx.AbortWithError(400, errors.New("username can't be empty"))
 		return
 	}
-	id, err := u.db.GetUserId(ctx, username)
+	if !middlewares.OwnsUsername(ctx, username) {
+		return
+	}
+	user, err := u.reader().GetUser(ctx, username)
 	if err != nil {
-		ctx.AbortWithError(500, err)
+		abortDB(ctx, err, ErrUserNotFound, nil)
 		return
 	}
-	ctx.JSON(200, gin.H{
-		"id": id,
+	ctx.JSON(200, newUserView(user))
+}
+
+// ListUsers pages through all users by id. pass the returned next as after to get the following page.
+func (u *User) ListUsers(ctx *gin.Context) {
+	var query struct {
+		After int32 `form:"after" binding:"min=0"`
+		Limit int32 `form:"limit" binding:"omitempty,min=1,max=500"`
+	}
+	err := ctx.BindQuery(&query)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	// one more than asked tells whether there is a next page
+	users, err := u.reader().ListUsers(ctx, sqlc.ListUsersParams{
+		After: query.After,
+		Lim:   query.Limit + 1,
 	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := gin.H{}
+	if len(users) > int(query.Limit) {
+		users = users[:query.Limit]
+		res["next"] = users[len(users)-1].ID
+	}
+	views := make([]userView, 0, len(users))
+	for _, user := range users {
+		views = append(views, newUserView(user))
+	}
+	res["users"] = views
+	ctx.JSON(200, res)
+}
+
+// UpdateUser renames a user or changes their footer. fields left out stay as they are.
+func (u *User) UpdateUser(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	if !middlewares.Owns(ctx, int32(id)) {
+		return
+	}
+	var req struct {
+		Username *string `json:"username" binding:"omitempty,alphanum,max=255"`
+		Footer   *string `json:"footer" binding:"omitempty,max=160"`
+	}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	params := sqlc.UpdateUserParams{ID: int32(id)}
+	if req.Username != nil {
+		params.Username = pgtype.Text{String: *req.Username, Valid: true}
+	}
+	if req.Footer != nil {
+		params.SetFooter = true
+		if f := strings.TrimSpace(*req.Footer); f != "" {
+			params.Footer = pgtype.Text{String: f, Valid: true}
+		}
+	}
+	user, err := u.db.UpdateUser(ctx, params)
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, ErrUserAlreadyExists)
+		return
+	}
+	ctx.JSON(200, newUserView(user))
+}
+
+func (u *User) SetFooter(ctx *gin.Context) {
+	var req struct {
+		Footer string `json:"footer" binding:"max=160"`
+	}
+	if !middlewares.OwnsUsername(ctx, ctx.Param("username")) {
+		return
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
 
+	footer := pgtype.Text{}
+	if f := strings.TrimSpace(req.Footer); f != "" {
+		footer = pgtype.Text{String: f, Valid: true}
+	}
+	_, err = u.db.SetFooter(ctx, sqlc.SetFooterParams{
+		Footer:   footer,
+		Username: ctx.Param("username"),
+	})
+	if err != nil {
+		abortDB(ctx, err, ErrUserNotFound, nil)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"footer": footer.String,
+	})
 }
@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	mynats "github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrWebhookNotFound        = errors.New("webhook not found")
+	ErrWebhookFailureNotFound = errors.New("webhook failure not found")
+)
+
+type Webhook struct {
+	*Base
+	cluster *db.Cluster
+	sp      *mynats.Publisher
+}
+
+type webhookView struct {
+	ID        int32     `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	// Secret is only shown when it is created or rotated
+	Secret                  string     `json:"secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+}
+
+// newWebhookView hides the secrets of h, and the expiry of its previous secret once it passed.
+func newWebhookView(h sqlc.Webhook) webhookView {
+	v := webhookView{ID: h.ID, URL: h.Url, CreatedAt: h.CreatedAt.Time}
+	if exp := h.PreviousSecretExpiresAt.Time; h.PreviousSecret.Valid && exp.After(time.Now()) {
+		v.PreviousSecretExpiresAt = &exp
+	}
+	return v
+}
+
+type webhookFailureView struct {
+	ID        int32           `json:"id"`
+	WebhookID int32           `json:"webhook_id"`
+	URL       string          `json:"url"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int32           `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+func NewWebhook(parent *Versions, cluster *db.Cluster, nc *nats.Conn) (*Webhook, error) {
+	base := NewBase("/user/:username/webhooks", parent, middlewares.WriteErrorBody)
+	sp, err := mynats.NewPublisher(context.Background(), nc,
+		mynats.WithReconcile(mynats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		mynats.WithStreams(webhooks.StreamConfig()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		Base:    base,
+		cluster: cluster,
+		sp:      sp,
+	}
+
+	base.RegisterRoutes(func(gp *gin.RouterGroup) {
+		gp.GET("", middlewares.RequireScopes(auth.ScopeUserRead), w.GetWebhooks)
+		gp.POST("", middlewares.RequireScopes(auth.ScopeUserWrite), w.AddWebhook)
+		gp.DELETE("/:id", middlewares.RequireScopes(auth.ScopeUserWrite), w.DeleteWebhook)
+		gp.POST("/:id/rotate", middlewares.RequireScopes(auth.ScopeUserWrite), w.RotateSecret)
+		gp.GET("/failures", middlewares.RequireScopes(auth.ScopeUserRead), w.GetFailures)
+		gp.POST("/failures/:id/retry", middlewares.RequireScopes(auth.ScopeUserWrite), w.RetryFailure)
+	})
+
+	return w, nil
+}
+
+func (w *Webhook) userId(ctx *gin.Context) (int32, bool) {
+	if !middlewares.OwnsUsername(ctx, ctx.Param("username")) {
+		return 0, false
+	}
+	id, err := sqlc.New(w.cluster.Reader()).GetUserId(ctx, ctx.Param("username"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrUserNotFound)
+			return 0, false
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (w *Webhook) GetWebhooks(ctx *gin.Context) {
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	hooks, err := sqlc.New(w.cluster.Reader()).GetWebhooks(ctx, userId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]webhookView, 0, len(hooks))
+	for _, h := range hooks {
+		views = append(views, newWebhookView(h))
+	}
+	ctx.JSON(200, views)
+}
+
+func (w *Webhook) AddWebhook(ctx *gin.Context) {
+	var req struct {
+		URL string `json:"url" binding:"required,url,max=2048"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && !(u.Scheme == "http" && viper.GetBool("webhooks.allowhttp"))) {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("webhook url must use https"))
+		return
+	}
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	hook, err := sqlc.New(w.cluster.Writer()).AddWebhook(ctx, sqlc.AddWebhookParams{
+		UserID: userId,
+		Url:    req.URL,
+		Secret: secret,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	view := newWebhookView(hook)
+	view.Secret = hook.Secret
+	ctx.JSON(200, view)
+}
+
+// RotateSecret replaces the signing secret of a webhook. deliveries are signed with
+// the previous secret as well until the grace period ends, so receivers can switch
+// without dropping any. rotating again within the grace period ends it early.
+func (w *Webhook) RotateSecret(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	var req struct {
+		GraceSeconds *int32 `json:"grace_seconds" binding:"omitempty,min=0,max=604800"`
+	}
+	if ctx.Request.ContentLength != 0 {
+		err = ctx.BindJSON(&req)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	grace := viper.GetDuration("webhooks.rotation.grace")
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	hook, err := sqlc.New(w.cluster.Writer()).RotateWebhookSecret(ctx, sqlc.RotateWebhookSecretParams{
+		ID:                      int32(id),
+		UserID:                  userId,
+		PreviousSecretExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(grace), Valid: true},
+		Secret:                  secret,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrWebhookNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	view := newWebhookView(hook)
+	view.Secret = hook.Secret
+	ctx.JSON(200, view)
+}
+
+func (w *Webhook) DeleteWebhook(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	_, err = sqlc.New(w.cluster.Writer()).DeleteWebhook(ctx, sqlc.DeleteWebhookParams{
+		ID:     int32(id),
+		UserID: userId,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrWebhookNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"status": 200,
+		"msg":    "OK",
+	})
+}
+
+// GetFailures lists the dead letters of the user's webhooks, newest first.
+func (w *Webhook) GetFailures(ctx *gin.Context) {
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "50"), 10, 32)
+	if err != nil || limit < 1 || limit > 500 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit must be between 1 and 500"))
+		return
+	}
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	failures, err := sqlc.New(w.cluster.Reader()).GetWebhookFailures(ctx, sqlc.GetWebhookFailuresParams{
+		UserID: userId,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]webhookFailureView, 0, len(failures))
+	for _, f := range failures {
+		views = append(views, webhookFailureView{
+			ID:        f.ID,
+			WebhookID: f.WebhookID,
+			URL:       f.Url,
+			Event:     f.Event,
+			Payload:   f.Payload,
+			Attempts:  f.Attempts,
+			LastError: f.LastError,
+			FailedAt:  f.FailedAt.Time,
+		})
+	}
+	ctx.JSON(200, views)
+}
+
+// RetryFailure queues a dead letter again, to the current url of its webhook, with a
+// fresh set of attempts. it is removed from the dead letters once queued.
+func (w *Webhook) RetryFailure(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	userId, ok := w.userId(ctx)
+	if !ok {
+		return
+	}
+	q := sqlc.New(w.cluster.Writer())
+	failure, err := q.GetWebhookFailure(ctx, sqlc.GetWebhookFailureParams{
+		ID:     int32(id),
+		UserID: userId,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			ctx.AbortWithError(http.StatusNotFound, ErrWebhookFailureNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var event struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(failure.Payload, &event)
+	data, err := json.Marshal(webhooks.Delivery{
+		WebhookID: failure.WebhookID,
+		URL:       failure.Url,
+		EventID:   event.ID,
+		Event:     failure.Event,
+		Payload:   failure.Payload,
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	msgID := fmt.Sprintf("webhook-retry-%d", failure.ID)
+	_, err = w.sp.PublishMsg(ctx, &nats.Msg{
+		Subject: webhooks.DeliverSubject,
+		Data:    data,
+		Header:  events.Header(msgID, actor(ctx), middlewares.GetRequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	err = q.DeleteWebhookFailure(ctx, failure.ID)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"status": http.StatusAccepted,
+		"msg":    "OK",
+	})
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// bind fills the typed request req from every part of the request and validates
+// it once all of them are read: the JSON body into its json fields, the query
+// into its form fields and the headers into its header fields. only the fields
+// tagged for a part are read from it, gin and encoding/json would fill the others
+// by their name, letting ?Message= override the body or {"UserID": 7} stand in
+// for ?user_id. binding the parts one after the other
+// with gin would validate req before the later parts are read, failing their
+// required fields or acting on zero values. the body is cached, so a
+// request can be bound more than once, e.g. to peek at its channel first.
+// it aborts with 400 and reports false when the request doesn't bind.
+func bind(ctx *gin.Context, req any) bool {
+	err := bindRequest(ctx, req)
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return false
+	}
+	return true
+}
+
+func bindRequest(ctx *gin.Context, req any) error {
+	body, err := bodyBytes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(jsonOnly(req, body), req)
+		if err != nil {
+			return err
+		}
+	}
+	err = binding.MapFormWithTag(req, tagged(req, "form", ctx.Request.URL.Query(), func(key string) string { return key }), "form")
+	if err != nil {
+		return err
+	}
+	err = binding.MapFormWithTag(req, tagged(req, "header", ctx.Request.Header, textproto.CanonicalMIMEHeaderKey), "header")
+	if err != nil {
+		return err
+	}
+	if binding.Validator == nil {
+		return nil
+	}
+	return binding.Validator.ValidateStruct(req)
+}
+
+// tagged picks the values of the fields of req tagged with tag out of values,
+// where a tag is found under key(tag).
+func tagged(req any, tag string, values map[string][]string, key func(string) string) map[string][]string {
+	res := make(map[string][]string)
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return res
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get(tag), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if v, ok := values[key(name)]; ok {
+			res[name] = v
+		}
+	}
+	return res
+}
+
+// jsonOnly drops the keys of body encoding/json would fill the query and header
+// fields of req with: it matches fields without a json tag by their name,
+// case-insensitively, so {"UserID": 7} would satisfy a required ?user_id. keys
+// naming a json field too are kept. bodies that aren't objects are left to
+// json.Unmarshal to refuse.
+func jsonOnly(req any, body []byte) []byte {
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return body
+	}
+	var visible, hidden []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+				visible = append(visible, name)
+			}
+			continue
+		}
+		if f.Tag.Get("form") != "" || f.Tag.Get("header") != "" {
+			hidden = append(hidden, f.Name)
+			continue
+		}
+		visible = append(visible, f.Name)
+	}
+	if len(hidden) == 0 {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	matches := func(names []string, key string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, key) })
+	}
+	for key := range fields {
+		if matches(hidden, key) && !matches(visible, key) {
+			delete(fields, key)
+		}
+	}
+	filtered, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return filtered
+}
+
+// bodyBytes reads the body once and caches it where ShouldBindBodyWith does.
+func bodyBytes(ctx *gin.Context) ([]byte, error) {
+	if cached, ok := ctx.Get(gin.BodyBytesKey); ok {
+		if body, ok := cached.([]byte); ok {
+			return body, nil
+		}
+	}
+	if ctx.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Set(gin.BodyBytesKey, body)
+	return body, nil
+}
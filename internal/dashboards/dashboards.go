@@ -0,0 +1,313 @@
+// Package dashboards builds Grafana dashboards of the metrics the gateway
+// exports, ready to import. the queries name the metrics through their
+// collectors, so a renamed metric renames the panels along with it.
+package dashboards
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Datasource is the input Grafana asks for on import, the panels query the
+// Prometheus datasource picked for it.
+const Datasource = "DS_PROMETHEUS"
+
+// Dashboard is the JSON model of a Grafana dashboard, with the inputs of the
+// import format.
+type Dashboard struct {
+	Inputs        []Input    `json:"__inputs"`
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          Range      `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+type Input struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	PluginID string `json:"pluginId"`
+}
+
+type Range struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard variable filtering the panels by a label.
+type Variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource *DataSource `json:"datasource,omitempty"`
+	Multi      bool        `json:"multi"`
+	IncludeAll bool        `json:"includeAll"`
+	AllValue   string      `json:"allValue,omitempty"`
+	Refresh    int         `json:"refresh"`
+}
+
+type DataSource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type Panel struct {
+	ID          int         `json:"id"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	GridPos     GridPos     `json:"gridPos"`
+	Datasource  DataSource  `json:"datasource"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+	Targets     []Target    `json:"targets"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+type Target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+var fqName = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// Name is the name c is exported under.
+func Name(c prometheus.Collector) string {
+	descs := make(chan *prometheus.Desc, 1)
+	go func() {
+		c.Describe(descs)
+		close(descs)
+	}()
+	var name string
+	for desc := range descs {
+		if m := fqName.FindStringSubmatch(desc.String()); m != nil && name == "" {
+			name = m[1]
+		}
+	}
+	return name
+}
+
+// filter matches the series picked in the variables of labels.
+func filter(labels ...string) string {
+	matchers := make([]string, 0, len(labels))
+	for _, label := range labels {
+		matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, label, label))
+	}
+	return strings.Join(matchers, ",")
+}
+
+// board lays panels out two per row and numbers them.
+type board struct {
+	Dashboard
+}
+
+func newBoard(uid, title string, variables ...Variable) *board {
+	ds := &DataSource{Type: "prometheus", UID: "${" + Datasource + "}"}
+	for i := range variables {
+		variables[i].Datasource = ds
+	}
+	return &board{Dashboard{
+		Inputs: []Input{{
+			Name:     Datasource,
+			Label:    "Prometheus",
+			Type:     "datasource",
+			PluginID: "prometheus",
+		}},
+		UID:           uid,
+		Title:         title,
+		Tags:          []string{"sms"},
+		Timezone:      "browser",
+		Refresh:       "30s",
+		SchemaVersion: 39,
+		Time:          Range{From: "now-6h", To: "now"},
+		Templating:    Templating{List: variables},
+	}}
+}
+
+func (b *board) add(title, unit string, targets ...Target) {
+	n := len(b.Panels)
+	for i := range targets {
+		targets[i].RefID = string(rune('A' + i))
+	}
+	b.Panels = append(b.Panels, Panel{
+		ID:          n + 1,
+		Type:        "timeseries",
+		Title:       title,
+		GridPos:     GridPos{H: 8, W: 12, X: n % 2 * 12, Y: n / 2 * 8},
+		Datasource:  DataSource{Type: "prometheus", UID: "${" + Datasource + "}"},
+		FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: unit}},
+		Targets:     targets,
+	})
+}
+
+// labelVariable lets the panels be filtered by label, all values by default.
+func labelVariable(label, metric string) Variable {
+	return Variable{
+		Name:       label,
+		Label:      label,
+		Type:       "query",
+		Query:      fmt.Sprintf("label_values(%s, %s)", metric, label),
+		Multi:      true,
+		IncludeAll: true,
+		AllValue:   ".*",
+		Refresh:    2,
+	}
+}
+
+// Overview charts the sms going through the gateway, by the org, priority and
+// provider labels.
+func Overview() Dashboard {
+	submissions := Name(metrics.Submissions)
+	stored := Name(metrics.SmsStored)
+	changes := Name(metrics.StatusChanges)
+	latency := Name(metrics.DeliveryLatency) + "_bucket"
+	breaches := Name(metrics.DeadlineBreaches)
+	depth := Name(metrics.QueueDepth)
+	dlr := Name(metrics.DlrCallbacks)
+
+	sms := filter(metrics.LabelOrg, metrics.LabelPriority)
+	all := filter(metrics.LabelOrg, metrics.LabelPriority, metrics.LabelProvider)
+	b := newBoard("sms-overview", "SMS Gateway",
+		labelVariable(metrics.LabelOrg, submissions),
+		labelVariable(metrics.LabelPriority, submissions),
+		labelVariable(metrics.LabelProvider, submissions),
+	)
+	b.add("Stored sms", "reqps", Target{
+		Expr:         fmt.Sprintf("sum by (priority) (rate(%s{%s}[5m]))", stored, sms),
+		LegendFormat: "{{priority}}",
+	})
+	b.add("Submissions", "reqps", Target{
+		Expr:         fmt.Sprintf("sum by (provider, outcome) (rate(%s{%s}[5m]))", submissions, all),
+		LegendFormat: "{{provider}} {{outcome}}",
+	})
+	b.add("Status changes", "reqps", Target{
+		Expr:         fmt.Sprintf("sum by (status) (rate(%s{%s}[5m]))", changes, all),
+		LegendFormat: "{{status}}",
+	})
+	b.add("Delivery ratio", "percentunit", Target{
+		Expr: fmt.Sprintf(`sum by (provider) (rate(%s{%s,status="delivered"}[1h])) / sum by (provider) (rate(%s{%s,status=~"delivered|failed"}[1h]))`,
+			changes, all, changes, all),
+		LegendFormat: "{{provider}}",
+	})
+	b.add("End to end latency p95", "s", Target{
+		Expr:         fmt.Sprintf(`histogram_quantile(0.95, sum by (le, priority) (rate(%s{%s,stage="end_to_end"}[5m])))`, latency, all),
+		LegendFormat: "{{priority}}",
+	})
+	b.add("Latency p95 by stage", "s", Target{
+		Expr:         fmt.Sprintf(`histogram_quantile(0.95, sum by (le, stage) (rate(%s{%s,stage!="end_to_end"}[5m])))`, latency, all),
+		LegendFormat: "{{stage}}",
+	})
+	// every process reports the same depth
+	b.add("Queue depth", "short", Target{
+		Expr:         fmt.Sprintf(`max by (priority) (%s{priority=~"$priority"})`, depth),
+		LegendFormat: "{{priority}}",
+	})
+	b.add("Deadline breaches", "short", Target{
+		Expr:         fmt.Sprintf("sum by (priority) (increase(%s{%s}[5m]))", breaches, sms),
+		LegendFormat: "{{priority}}",
+	})
+	b.add("Delivery reports", "reqps", Target{
+		Expr:         fmt.Sprintf(`sum by (provider, outcome) (rate(%s{provider=~"$provider"}[5m]))`, dlr),
+		LegendFormat: "{{provider}} {{outcome}}",
+	})
+	b.add("Top orgs", "reqps", Target{
+		Expr:         fmt.Sprintf("topk(10, sum by (org) (rate(%s{%s}[5m])))", stored, sms),
+		LegendFormat: "{{org}}",
+	})
+	return b.Dashboard
+}
+
+// Worker charts the health of the workers: their transactions, handlers and
+// backpressure, and the database pools.
+func Worker() Dashboard {
+	messages := Name(metrics.WorkerMessages)
+	duration := Name(metrics.WorkerMessageDuration) + "_bucket"
+
+	b := newBoard("sms-worker", "SMS Workers", Variable{
+		Name:       "subject",
+		Label:      "subject",
+		Type:       "query",
+		Query:      fmt.Sprintf("label_values(%s, subject)", messages),
+		Multi:      true,
+		IncludeAll: true,
+		AllValue:   ".*",
+		Refresh:    2,
+	})
+	b.add("Messages", "reqps", Target{
+		Expr:         fmt.Sprintf(`sum by (subject, outcome) (rate(%s{subject=~"$subject"}[5m]))`, messages),
+		LegendFormat: "{{subject}} {{outcome}}",
+	})
+	b.add("Handler latency p95", "s", Target{
+		Expr:         fmt.Sprintf(`histogram_quantile(0.95, sum by (le, subject) (rate(%s{subject=~"$subject"}[5m])))`, duration),
+		LegendFormat: "{{subject}}",
+	})
+	b.add("Transactions", "reqps", Target{
+		Expr:         fmt.Sprintf("sum(rate(%s_count[5m]))", Name(metrics.WorkerTxDuration)),
+		LegendFormat: "committed",
+	}, Target{
+		Expr:         fmt.Sprintf("sum(rate(%s[5m]))", Name(metrics.WorkerTxErrors)),
+		LegendFormat: "failed",
+	}, Target{
+		Expr:         fmt.Sprintf("sum by (reason) (rate(%s[5m]))", Name(metrics.DBTxRetries)),
+		LegendFormat: "retried {{reason}}",
+	})
+	b.add("Transaction latency p95", "s", Target{
+		Expr:         fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket[5m])))", Name(metrics.WorkerTxDuration)),
+		LegendFormat: "p95",
+	})
+	b.add("Backpressure", "short", Target{
+		Expr:         fmt.Sprintf("sum(%s)", Name(metrics.WorkerPaused)),
+		LegendFormat: "paused workers",
+	}, Target{
+		Expr:         fmt.Sprintf("sum(increase(%s[5m]))", Name(metrics.WorkerPauses)),
+		LegendFormat: "pauses",
+	})
+	b.add("Handler panics", "short", Target{
+		Expr:         fmt.Sprintf(`sum by (subject) (increase(%s{subject=~"$subject"}[5m]))`, Name(metrics.WorkerPanics)),
+		LegendFormat: "{{subject}}",
+	})
+	b.add("Aged boosts", "short", Target{
+		Expr:         fmt.Sprintf("sum(increase(%s[5m]))", Name(metrics.WorkerBoosted)),
+		LegendFormat: "boosted",
+	})
+	b.add("Webhook deliveries", "reqps", Target{
+		Expr:         fmt.Sprintf("sum by (outcome) (rate(%s[5m]))", Name(metrics.WebhookDeliveries)),
+		LegendFormat: "{{outcome}}",
+	})
+	return b.Dashboard
+}
+
+// All returns every dashboard by the name of its file.
+func All() map[string]Dashboard {
+	return map[string]Dashboard{
+		"sms-overview.json": Overview(),
+		"sms-worker.json":   Worker(),
+	}
+}
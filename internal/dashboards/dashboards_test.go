@@ -0,0 +1,75 @@
+package dashboards_test
+
+import (
+	"encoding/json"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/dashboards"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Dashboards", func() {
+	It("should name metrics after their collectors", func() {
+		Expect(dashboards.Name(metrics.Submissions)).To(Equal("sms_worker_submissions_total"))
+		Expect(dashboards.Name(metrics.QueueDepth)).To(Equal("sms_queue_depth"))
+	})
+
+	It("should only query metrics the gateway exports", func() {
+		known := make(map[string]bool)
+		for _, c := range []prometheus.Collector{
+			metrics.Submissions, metrics.SmsStored, metrics.StatusChanges, metrics.DeliveryLatency,
+			metrics.DeadlineBreaches, metrics.QueueDepth, metrics.DlrCallbacks, metrics.WorkerMessages,
+			metrics.WorkerMessageDuration, metrics.WorkerTxDuration, metrics.WorkerTxErrors,
+			metrics.DBTxRetries, metrics.WorkerPaused, metrics.WorkerPauses, metrics.WorkerPanics,
+			metrics.WorkerBoosted, metrics.WebhookDeliveries,
+		} {
+			name := dashboards.Name(c)
+			for _, suffix := range []string{"", "_bucket", "_count", "_sum"} {
+				known[name+suffix] = true
+			}
+		}
+		names := regexp.MustCompile(`\bsms_[a-z_]+`)
+		for file, d := range dashboards.All() {
+			Expect(d.Panels).NotTo(BeEmpty(), file)
+			for _, p := range d.Panels {
+				Expect(p.Targets).NotTo(BeEmpty(), p.Title)
+				for _, t := range p.Targets {
+					used := names.FindAllString(t.Expr, -1)
+					Expect(used).NotTo(BeEmpty(), t.Expr)
+					for _, name := range used {
+						Expect(known).To(HaveKey(name), t.Expr)
+					}
+				}
+			}
+		}
+	})
+
+	It("should filter the overview by org, priority and provider", func() {
+		d := dashboards.Overview()
+		var variables []string
+		for _, v := range d.Templating.List {
+			variables = append(variables, v.Name)
+			Expect(v.Datasource.UID).To(Equal("${DS_PROMETHEUS}"))
+		}
+		Expect(variables).To(Equal([]string{metrics.LabelOrg, metrics.LabelPriority, metrics.LabelProvider}))
+		Expect(d.Panels[1].Targets[0].Expr).To(ContainSubstring(`org=~"$org",priority=~"$priority",provider=~"$provider"`))
+	})
+
+	It("should marshal to the import format", func() {
+		b, err := json.Marshal(dashboards.Worker())
+		Expect(err).NotTo(HaveOccurred())
+		var d map[string]interface{}
+		Expect(json.Unmarshal(b, &d)).To(Succeed())
+		Expect(d["__inputs"]).To(ConsistOf(HaveKeyWithValue("name", "DS_PROMETHEUS")))
+		ids := make(map[float64]bool)
+		for _, p := range d["panels"].([]interface{}) {
+			id := p.(map[string]interface{})["id"].(float64)
+			Expect(ids).NotTo(HaveKey(id))
+			ids[id] = true
+		}
+	})
+})
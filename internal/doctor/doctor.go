@@ -0,0 +1,173 @@
+// Package doctor runs the self-tests of the doctor command, checking that a
+// deployment can reach its dependencies and agrees with them, and reports the
+// outcome of every check.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// outcomes of a check
+const (
+	Pass = "PASS"
+	// Warn is a check that found something to look at which doesn't keep the
+	// gateway from starting, e.g. a stream it creates on its own
+	Warn = "WARN"
+	Fail = "FAIL"
+	// Skip is a check that couldn't run because one it depends on failed
+	Skip = "SKIP"
+)
+
+// Result is the outcome of a check.
+type Result struct {
+	Name   string
+	Status string
+	Detail string
+	Took   time.Duration
+}
+
+type warning struct {
+	err error
+}
+
+func (w *warning) Error() string { return w.err.Error() }
+func (w *warning) Unwrap() error { return w.err }
+
+// Warning makes a check with err end in Warn instead of Fail.
+func Warning(err error) error {
+	return &warning{err: err}
+}
+
+// Report runs the checks one after the other and collects their results.
+type Report struct {
+	// Timeout bounds every check, no limit when zero
+	Timeout time.Duration
+	Results []Result
+}
+
+// Run runs check and records its result. it returns the error of the check, nil
+// for warnings, for the checks depending on it to be skipped.
+func (r *Report) Run(ctx context.Context, name string, check func(ctx context.Context) error) error {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := check(ctx)
+	res := Result{Name: name, Status: Pass, Took: time.Since(start)}
+	var w *warning
+	switch {
+	case errors.As(err, &w):
+		res.Status, res.Detail, err = Warn, err.Error(), nil
+	case err != nil:
+		res.Status, res.Detail = Fail, err.Error()
+	}
+	r.Results = append(r.Results, res)
+	return err
+}
+
+// Skip records name as skipped for reason.
+func (r *Report) Skip(name, reason string) {
+	r.Results = append(r.Results, Result{Name: name, Status: Skip, Detail: reason})
+}
+
+// Count is the number of results with status.
+func (r *Report) Count(status string) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Print writes a line per check and a summary to w.
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, res := range r.Results {
+		took := ""
+		if res.Status != Skip {
+			took = res.Took.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Status, res.Name, took, res.Detail)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		r.Count(Pass), r.Count(Warn), r.Count(Fail), r.Count(Skip))
+	return err
+}
+
+// SchemaVersioner reads the version of the schema applied to the database.
+type SchemaVersioner = db.SchemaVersioner
+
+// CheckSchema fails unless the database is at version want of schema.sql, see
+// db.CheckSchema.
+func CheckSchema(ctx context.Context, q SchemaVersioner, want int32) error {
+	return db.CheckSchema(ctx, q, want)
+}
+
+// Streams looks up the streams on the server, see jetstream.JetStream.
+type Streams interface {
+	Stream(ctx context.Context, name string) (jetstream.Stream, error)
+}
+
+// CheckStream compares the stream on the server with want. a missing stream is
+// a warning, it is created when the gateway binds it, and so is drift unless
+// mode refuses to apply it.
+func CheckStream(ctx context.Context, js Streams, want jetstream.StreamConfig, mode nats.ReconcileMode) error {
+	s, err := js.Stream(ctx, want.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return Warning(errors.New("missing, it is created on start"))
+	}
+	if err != nil {
+		return err
+	}
+	return drifted(&nats.DriftError{Kind: "stream", Name: want.Name, Drifts: nats.StreamDrift(want, s.CachedInfo().Config)}, mode)
+}
+
+// CheckConsumer is CheckStream for a consumer of stream.
+func CheckConsumer(ctx context.Context, js Streams, stream string, want jetstream.ConsumerConfig, mode nats.ReconcileMode) error {
+	s, err := js.Stream(ctx, stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return Warning(errors.New("missing along with its stream, both are created on start"))
+	}
+	if err != nil {
+		return err
+	}
+	name := want.Name
+	if name == "" {
+		name = want.Durable
+	}
+	c, err := s.Consumer(ctx, name)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return Warning(errors.New("missing, it is created on start"))
+	}
+	if err != nil {
+		return err
+	}
+	return drifted(&nats.DriftError{Kind: "consumer", Name: stream + "/" + name, Drifts: nats.ConsumerDrift(want, c.CachedInfo().Config)}, mode)
+}
+
+func drifted(d *nats.DriftError, mode nats.ReconcileMode) error {
+	if len(d.Drifts) == 0 {
+		return nil
+	}
+	if mode == nats.ReconcileRefuse {
+		return d
+	}
+	return Warning(fmt.Errorf("%w, it is updated on start", d))
+}
@@ -0,0 +1,138 @@
+package doctor_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/doctor"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeVersion struct {
+	version int32
+	err     error
+}
+
+func (f fakeVersion) GetSchemaVersion(ctx context.Context) (int32, error) {
+	return f.version, f.err
+}
+
+// fakeStream only implements what the checks call, the rest panics
+type fakeStream struct {
+	jetstream.Stream
+	config    jetstream.StreamConfig
+	consumers map[string]jetstream.ConsumerConfig
+}
+
+func (s *fakeStream) CachedInfo() *jetstream.StreamInfo {
+	return &jetstream.StreamInfo{Config: s.config}
+}
+
+func (s *fakeStream) Consumer(ctx context.Context, name string) (jetstream.Consumer, error) {
+	c, ok := s.consumers[name]
+	if !ok {
+		return nil, jetstream.ErrConsumerNotFound
+	}
+	return &fakeConsumer{config: c}, nil
+}
+
+type fakeConsumer struct {
+	jetstream.Consumer
+	config jetstream.ConsumerConfig
+}
+
+func (c *fakeConsumer) CachedInfo() *jetstream.ConsumerInfo {
+	return &jetstream.ConsumerInfo{Config: c.config}
+}
+
+type fakeStreams map[string]*fakeStream
+
+func (f fakeStreams) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	s, ok := f[name]
+	if !ok {
+		return nil, jetstream.ErrStreamNotFound
+	}
+	return s, nil
+}
+
+var _ = Describe("Doctor", func() {
+	ctx := context.Background()
+
+	It("records the outcome of every check and skips the rest", func() {
+		report := &doctor.Report{Timeout: time.Second}
+		Expect(report.Run(ctx, "config", func(ctx context.Context) error { return nil })).To(Succeed())
+		Expect(report.Run(ctx, "postgres", func(ctx context.Context) error {
+			return errors.New("connection refused")
+		})).To(MatchError("connection refused"))
+		Expect(report.Run(ctx, "stream SMS", func(ctx context.Context) error {
+			return doctor.Warning(errors.New("missing"))
+		})).To(Succeed())
+		report.Skip("schema version", "no connection to postgres")
+
+		Expect(report.Results).To(HaveLen(4))
+		Expect(report.Results[1].Status).To(Equal(doctor.Fail))
+		Expect(report.Results[1].Detail).To(Equal("connection refused"))
+		Expect(report.Results[2].Status).To(Equal(doctor.Warn))
+		Expect(report.Count(doctor.Pass)).To(Equal(1))
+
+		var out bytes.Buffer
+		Expect(report.Print(&out)).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`FAIL  postgres +\S+ +connection refused\n`))
+		Expect(out.String()).To(ContainSubstring("SKIP  schema version"))
+		Expect(out.String()).To(HaveSuffix("\n1 passed, 1 warnings, 1 failed, 1 skipped\n"))
+	})
+
+	It("bounds every check by the timeout", func() {
+		report := &doctor.Report{Timeout: 10 * time.Millisecond}
+		err := report.Run(ctx, "nats", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("compares the schema version with the one of the build", func() {
+		Expect(doctor.CheckSchema(ctx, fakeVersion{version: 3}, 3)).To(Succeed())
+		Expect(doctor.CheckSchema(ctx, fakeVersion{version: 2}, 3)).To(MatchError(ContainSubstring("apply schema.sql")))
+		Expect(doctor.CheckSchema(ctx, fakeVersion{version: 4}, 3)).To(MatchError(ContainSubstring("newer")))
+		Expect(doctor.CheckSchema(ctx, fakeVersion{err: errors.New("relation does not exist")}, 3)).To(HaveOccurred())
+	})
+
+	Context("streams", func() {
+		want := jetstream.StreamConfig{Name: "SMS", Subjects: []string{"sms.send.>"}, Retention: jetstream.WorkQueuePolicy}
+		consumer := jetstream.ConsumerConfig{Name: "Sms", Durable: "Sms", AckPolicy: jetstream.AckExplicitPolicy}
+
+		It("warns about streams and consumers created on start", func() {
+			var w interface{ Unwrap() error }
+			err := doctor.CheckStream(ctx, fakeStreams{}, want, nats.ReconcileRefuse)
+			Expect(errors.As(err, &w)).To(BeTrue())
+			err = doctor.CheckConsumer(ctx, fakeStreams{"SMS": {config: want}}, "SMS", consumer, nats.ReconcileRefuse)
+			Expect(errors.As(err, &w)).To(BeTrue())
+		})
+
+		It("fails on drift only when it isn't applied on start", func() {
+			have := want
+			have.Retention = jetstream.LimitsPolicy
+			js := fakeStreams{"SMS": {config: have, consumers: map[string]jetstream.ConsumerConfig{"Sms": consumer}}}
+
+			err := doctor.CheckStream(ctx, js, want, nats.ReconcileRefuse)
+			var drift *nats.DriftError
+			Expect(errors.As(err, &drift)).To(BeTrue())
+			Expect(drift.Drifts[0].Field).To(Equal("Retention"))
+
+			report := &doctor.Report{}
+			Expect(report.Run(ctx, "stream SMS", func(ctx context.Context) error {
+				return doctor.CheckStream(ctx, js, want, nats.ReconcileApply)
+			})).To(Succeed())
+			Expect(report.Results[0].Status).To(Equal(doctor.Warn))
+
+			Expect(doctor.CheckConsumer(ctx, js, "SMS", consumer, nats.ReconcileRefuse)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mynats "github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// lifecycle events recorded in sms_events
+const (
+	// Created is the api accepting the request
+	Created = "created"
+	// Queued is the request being stored on its JetStream work queue
+	Queued = "queued"
+	// Stored is a worker persisting the message and charging the user
+	Stored    = "stored"
+	Submitted = "submitted"
+	Delivered = "delivered"
+	Failed    = "failed"
+	Expired   = "expired"
+	Refunded  = "refunded"
+	Cancelled = "cancelled"
+	// Fallback is a failed sms being handed to its fallback channel
+	Fallback = "fallback"
+	// Breached is an sms not submitted within the deadline of its priority
+	Breached = "breached"
+)
+
+// headers the api attaches to sms requests so workers can record who sent them and when
+const (
+	// HeaderMessageID identifies a request across redeliveries
+	HeaderMessageID = "Sms-Message-Id"
+	HeaderActor     = "Sms-Actor"
+	HeaderCreatedAt = "Sms-Created-At"
+	// HeaderRequestID ties a message to the http request it originates from
+	HeaderRequestID = mynats.HeaderRequestID
+)
+
+// ActorApi is recorded for requests accepted without credentials, i.e. while auth is disabled.
+const ActorApi = "api"
+
+// Event is one entry of an sms history.
+type Event struct {
+	Name string
+	// Actor is who caused the transition: an api key, "api" or a worker
+	Actor string
+	// Provider is the carrier involved, if any
+	Provider string
+	// RequestID is the http request behind the transition. Record takes it from ctx when empty
+	RequestID  string
+	Metadata   map[string]any
+	OccurredAt time.Time
+}
+
+// NewMessageID returns a random id for a new request.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MessageID identifies msg across redeliveries. requests published without the
+// api headers fall back to their position in the stream.
+func MessageID(msg jetstream.Msg) string {
+	if h := msg.Headers(); h != nil {
+		if id := h.Get(HeaderMessageID); id != "" {
+			return id
+		}
+	}
+	md, err := msg.Metadata()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", md.Stream, md.Sequence.Stream)
+}
+
+// Header carries the id, actor, http request id and acceptance time of a request to the workers.
+func Header(id, actor, requestID string, createdAt time.Time) nats.Header {
+	h := nats.Header{}
+	h.Set(HeaderMessageID, id)
+	h.Set(HeaderActor, actor)
+	h.Set(HeaderCreatedAt, createdAt.UTC().Format(time.RFC3339Nano))
+	if requestID != "" {
+		h.Set(HeaderRequestID, requestID)
+	}
+	return h
+}
+
+// Accepted reconstructs the created and queued events of msg. requests published
+// without the api headers fall back to the time JetStream stored them.
+func Accepted(msg jetstream.Msg) []Event {
+	var queuedAt time.Time
+	queued := map[string]any{"subject": msg.Subject()}
+	if md, err := msg.Metadata(); err == nil {
+		queuedAt = md.Timestamp
+		queued["stream"] = md.Stream
+		queued["sequence"] = md.Sequence.Stream
+		queued["deliveries"] = md.NumDelivered
+	}
+
+	actor := ActorApi
+	createdAt := queuedAt
+	var requestID string
+	if h := msg.Headers(); h != nil {
+		requestID = h.Get(HeaderRequestID)
+		if a := h.Get(HeaderActor); a != "" {
+			actor = a
+		}
+		if t, err := time.Parse(time.RFC3339Nano, h.Get(HeaderCreatedAt)); err == nil {
+			createdAt = t
+		}
+	}
+	created := map[string]any{}
+	if id := MessageID(msg); id != "" {
+		created["message_id"] = id
+	}
+	return []Event{
+		{Name: Created, Actor: actor, Metadata: created, OccurredAt: createdAt, RequestID: requestID},
+		{Name: Queued, Actor: actor, Metadata: queued, OccurredAt: queuedAt, RequestID: requestID},
+	}
+}
+
+// Record appends events to the history of sms id. q should be bound to the
+// transaction that changed the message so history and state can't disagree.
+// events without a request id get the one ctx carries, see mynats.Trace.
+func Record(ctx context.Context, q *sqlc.Queries, id int32, events ...Event) error {
+	for _, e := range stamp(ctx, events) {
+		metadata, err := e.metadata()
+		if err != nil {
+			return err
+		}
+		err = q.AddSmsEvent(ctx, sqlc.AddSmsEventParams{
+			SmsID:      id,
+			Event:      e.Name,
+			Actor:      e.Actor,
+			Provider:   pgtype.Text{String: e.Provider, Valid: e.Provider != ""},
+			Metadata:   metadata,
+			OccurredAt: pgtype.Timestamp{Time: e.OccurredAt, Valid: true},
+			RequestID:  pgtype.Text{String: e.RequestID, Valid: e.RequestID != ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stamp is events with the time and request id they are recorded with: now and
+// the one ctx carries when they have none.
+func stamp(ctx context.Context, events []Event) []Event {
+	stamped := make([]Event, len(events))
+	for i, e := range events {
+		if e.OccurredAt.IsZero() {
+			e.OccurredAt = time.Now()
+		}
+		e.OccurredAt = e.OccurredAt.UTC()
+		if e.RequestID == "" {
+			e.RequestID = mynats.RequestID(ctx)
+		}
+		stamped[i] = e
+	}
+	return stamped
+}
+
+func (e Event) metadata() ([]byte, error) {
+	if len(e.Metadata) == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(e.Metadata)
+}
@@ -0,0 +1,67 @@
+package events_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/events"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeMsg implements the parts of jetstream.Msg Accepted reads.
+type fakeMsg struct {
+	jetstream.Msg
+	header nats.Header
+	stored time.Time
+}
+
+func (m *fakeMsg) Subject() string      { return "sms.send.request" }
+func (m *fakeMsg) Headers() nats.Header { return m.header }
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	if m.stored.IsZero() {
+		return nil, errors.New("not a jetstream message")
+	}
+	return &jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Stream: 42},
+		NumDelivered: 1,
+		Stream:       "Sms",
+		Timestamp:    m.stored,
+	}, nil
+}
+
+var _ = Describe("Events", func() {
+	stored := time.Date(2024, 6, 1, 10, 0, 1, 0, time.UTC)
+
+	It("should take actor and creation time from the api headers", func() {
+		created := time.Date(2024, 6, 1, 10, 0, 0, 500, time.UTC)
+		events := Accepted(&fakeMsg{header: Header("0a1b", "key:7", "req-1", created), stored: stored})
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal(Created))
+		Expect(events[0].Actor).To(Equal("key:7"))
+		Expect(events[0].RequestID).To(Equal("req-1"))
+		Expect(events[1].RequestID).To(Equal("req-1"))
+		Expect(events[0].OccurredAt).To(BeTemporally("==", created))
+		Expect(events[0].Metadata).To(HaveKeyWithValue("message_id", "0a1b"))
+		Expect(events[1].Name).To(Equal(Queued))
+		Expect(events[1].OccurredAt).To(BeTemporally("==", stored))
+		Expect(events[1].Metadata).To(HaveKeyWithValue("sequence", uint64(42)))
+	})
+
+	It("should fall back to the stream timestamp without headers", func() {
+		events := Accepted(&fakeMsg{stored: stored})
+		Expect(events[0].Actor).To(Equal(ActorApi))
+		Expect(events[0].RequestID).To(BeEmpty())
+		Expect(events[0].OccurredAt).To(BeTemporally("==", stored))
+	})
+
+	It("should identify messages across redeliveries", func() {
+		Expect(MessageID(&fakeMsg{header: Header("0a1b", "api", "", stored), stored: stored})).To(Equal("0a1b"))
+		Expect(MessageID(&fakeMsg{stored: stored})).To(Equal("Sms:42"))
+		Expect(MessageID(&fakeMsg{})).To(BeEmpty())
+		Expect(NewMessageID()).To(HaveLen(32))
+	})
+})
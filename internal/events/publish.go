@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// SubjectOf is where the events named name are published, e.g. "sms.events.delivered".
+func SubjectOf(name string) string {
+	return SmsEvent(name)
+}
+
+// StreamConfig keeps the published events for events.stream.maxage, for the sinks
+// reading them at their own pace, see Publisher.
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        EVENTS_STREAM_NAME,
+		Description: "lifecycle events of sms, for analytics",
+		Subjects:    []string{SmsEventsAll()},
+		Retention:   jetstream.LimitsPolicy,
+		Storage:     jetstream.FileStorage,
+		MaxAge:      viper.GetDuration("events.stream.maxage"),
+	}
+}
+
+// Published is an event as it is published, on the subject SubjectOf its name.
+type Published struct {
+	// ID is the same for every publication of the event, sinks can dedupe on it
+	ID         string          `json:"id"`
+	SmsID      int32           `json:"sms_id"`
+	Event      string          `json:"event"`
+	Actor      string          `json:"actor"`
+	Provider   string          `json:"provider,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Metadata   json.RawMessage `json:"metadata"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// Publisher records events like Record and publishes them too, see SubjectOf.
+// a nil Publisher only records them.
+type Publisher struct {
+	js jetstream.JetStream
+}
+
+func NewPublisher(js jetstream.JetStream) *Publisher {
+	return &Publisher{js: js}
+}
+
+// PublisherFromViper returns a Publisher when events.stream.enabled, nil otherwise.
+func PublisherFromViper(js jetstream.JetStream) *Publisher {
+	if !viper.GetBool("events.stream.enabled") {
+		return nil
+	}
+	return NewPublisher(js)
+}
+
+// Record records events and publishes them. like the other messages published
+// from a transaction, events of a transaction rolled back afterwards are
+// published anyway.
+func (p *Publisher) Record(ctx context.Context, q *sqlc.Queries, id int32, events ...Event) error {
+	events = stamp(ctx, events)
+	err := Record(ctx, q, id, events...)
+	if err != nil || p == nil {
+		return err
+	}
+	for _, e := range events {
+		metadata, err := e.metadata()
+		if err != nil {
+			return err
+		}
+		published := Published{
+			ID:         fmt.Sprintf("%d-%s-%d", id, e.Name, e.OccurredAt.UnixNano()),
+			SmsID:      id,
+			Event:      e.Name,
+			Actor:      e.Actor,
+			Provider:   e.Provider,
+			RequestID:  e.RequestID,
+			Metadata:   metadata,
+			OccurredAt: e.OccurredAt,
+		}
+		data, err := json.Marshal(published)
+		if err != nil {
+			return err
+		}
+		_, err = p.js.PublishMsg(ctx, &nats.Msg{
+			Subject: SubjectOf(e.Name),
+			Data:    data,
+		}, jetstream.WithMsgID(published.ID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
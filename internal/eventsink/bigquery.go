@@ -0,0 +1,180 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/spf13/viper"
+)
+
+const (
+	bigQueryEndpoint = "https://bigquery.googleapis.com"
+	// metadataToken is the token of the service account of the instance on GCP
+	metadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+type BigQueryConfig struct {
+	Project string
+	Dataset string
+	Table   string
+	// Token is an OAuth2 access token. when empty the token of the instance's
+	// service account is fetched from the metadata server of GCP
+	Token string
+	// Endpoint defaults to https://bigquery.googleapis.com
+	Endpoint string
+	Timeout  time.Duration
+}
+
+// BigQuery streams events into a table with the insertAll API. the id of every
+// event is its insertId, BigQuery drops rows inserted again within a minute.
+type BigQuery struct {
+	cfg    BigQueryConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewBigQuery(cfg BigQueryConfig) (*BigQuery, error) {
+	if cfg.Project == "" || cfg.Dataset == "" || cfg.Table == "" {
+		return nil, fmt.Errorf("bigquery needs a project, a dataset and a table")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = bigQueryEndpoint
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &BigQuery{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// bigQueryFromViper reads events.sink.bigquery.{project,dataset,table,token,endpoint,timeout}.
+func bigQueryFromViper(ctx context.Context) (*BigQuery, error) {
+	token, err := secrets.Get(ctx, "events.sink.bigquery.token")
+	if err != nil {
+		return nil, err
+	}
+	return NewBigQuery(BigQueryConfig{
+		Project:  viper.GetString("events.sink.bigquery.project"),
+		Dataset:  viper.GetString("events.sink.bigquery.dataset"),
+		Table:    viper.GetString("events.sink.bigquery.table"),
+		Token:    token,
+		Endpoint: viper.GetString("events.sink.bigquery.endpoint"),
+		Timeout:  viper.GetDuration("events.sink.bigquery.timeout"),
+	})
+}
+
+type bigQueryRow struct {
+	InsertID string `json:"insertId"`
+	JSON     row    `json:"json"`
+}
+
+func (b *BigQuery) Write(ctx context.Context, batch []events.Published) error {
+	rows := make([]bigQueryRow, len(batch))
+	for i, e := range batch {
+		rows[i] = bigQueryRow{InsertID: e.ID, JSON: newRow(e)}
+	}
+	data, err := json.Marshal(map[string]any{"rows": rows})
+	if err != nil {
+		return err
+	}
+	token, err := b.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a bigquery token: %w", err)
+	}
+	url := fmt.Sprintf("%s/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll",
+		strings.TrimSuffix(b.cfg.Endpoint, "/"), b.cfg.Project, b.cfg.Dataset, b.cfg.Table)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var body struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bigquery responded with %s: %s", res.Status, body.Error.Message)
+	}
+	if err != nil {
+		return err
+	}
+	if len(body.InsertErrors) > 0 {
+		// the rows that were inserted are dropped as duplicates on the retry
+		first := body.InsertErrors[0]
+		reason := "unknown"
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("bigquery refused %d of %d rows, row %d: %s", len(body.InsertErrors), len(rows), first.Index, reason)
+	}
+	return nil
+}
+
+// accessToken is the configured token, or the one of the instance's service
+// account until shortly before it expires.
+func (b *BigQuery) accessToken(ctx context.Context) (string, error) {
+	if b.cfg.Token != "" {
+		return b.cfg.Token, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Now().Before(b.expires) {
+		return b.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server responded with %s", res.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("metadata server returned no token")
+	}
+	b.token = token.AccessToken
+	b.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return b.token, nil
+}
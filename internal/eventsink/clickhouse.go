@@ -0,0 +1,99 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/spf13/viper"
+)
+
+type ClickHouseConfig struct {
+	// URL is the HTTP interface, e.g. "http://clickhouse:8123"
+	URL      string
+	Database string
+	Table    string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// ClickHouse inserts events over the HTTP interface of ClickHouse, a batch per
+// INSERT. ClickHouse drops a batch inserted again into a replicated table, and
+// a ReplacingMergeTree ordered by id dedupes the rest.
+type ClickHouse struct {
+	cfg    ClickHouseConfig
+	client *http.Client
+}
+
+func NewClickHouse(cfg ClickHouseConfig) (*ClickHouse, error) {
+	if cfg.URL == "" || cfg.Table == "" {
+		return nil, fmt.Errorf("clickhouse needs a url and a table")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &ClickHouse{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// clickHouseFromViper reads events.sink.clickhouse.{url,database,table,username,password,timeout}.
+func clickHouseFromViper(ctx context.Context) (*ClickHouse, error) {
+	password, err := secrets.Get(ctx, "events.sink.clickhouse.password")
+	if err != nil {
+		return nil, err
+	}
+	return NewClickHouse(ClickHouseConfig{
+		URL:      viper.GetString("events.sink.clickhouse.url"),
+		Database: viper.GetString("events.sink.clickhouse.database"),
+		Table:    viper.GetString("events.sink.clickhouse.table"),
+		Username: viper.GetString("events.sink.clickhouse.username"),
+		Password: password,
+		Timeout:  viper.GetDuration("events.sink.clickhouse.timeout"),
+	})
+}
+
+func (c *ClickHouse) Write(ctx context.Context, batch []events.Published) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range batch {
+		err := enc.Encode(newRow(e))
+		if err != nil {
+			return err
+		}
+	}
+	table := c.cfg.Table
+	if c.cfg.Database != "" {
+		table = c.cfg.Database + "." + table
+	}
+	query := url.Values{}
+	query.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.cfg.URL, "/")+"/?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	if c.cfg.Username != "" {
+		req.Header.Set("X-ClickHouse-User", c.cfg.Username)
+		req.Header.Set("X-ClickHouse-Key", c.cfg.Password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("clickhouse responded with %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package eventsink copies the published lifecycle events of sms into an
+// analytics store, in batches, off the path of the sms themselves.
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Writer inserts a batch of events into an analytics store. a batch that failed
+// is written again, so writers dedupe on the id of the events where the store can.
+type Writer interface {
+	Write(ctx context.Context, batch []events.Published) error
+}
+
+// FromViper builds the writer of events.sink.driver: "clickhouse" or "bigquery".
+// it returns nil when no driver is set.
+func FromViper(ctx context.Context) (Writer, error) {
+	switch driver := viper.GetString("events.sink.driver"); driver {
+	case "":
+		return nil, nil
+	case "clickhouse":
+		return clickHouseFromViper(ctx)
+	case "bigquery":
+		return bigQueryFromViper(ctx)
+	default:
+		return nil, fmt.Errorf("unknown events.sink.driver %q", driver)
+	}
+}
+
+// row is an event as the stores receive it. the metadata is a JSON string, both
+// stores query it with their JSON functions.
+type row struct {
+	ID         string `json:"id"`
+	SmsID      int32  `json:"sms_id"`
+	Event      string `json:"event"`
+	Actor      string `json:"actor"`
+	Provider   string `json:"provider"`
+	RequestID  string `json:"request_id"`
+	Metadata   string `json:"metadata"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+func newRow(e events.Published) row {
+	return row{
+		ID:         e.ID,
+		SmsID:      e.SmsID,
+		Event:      e.Event,
+		Actor:      e.Actor,
+		Provider:   e.Provider,
+		RequestID:  e.RequestID,
+		Metadata:   string(e.Metadata),
+		OccurredAt: e.OccurredAt.UTC().Format("2006-01-02 15:04:05.000000"),
+	}
+}
+
+// Buffer collects the event messages it is handed and writes them in batches of
+// size, or every interval when fewer arrived. messages are acked once their batch
+// is written and handed back to be redelivered when it failed, so every event
+// is written at least once.
+type Buffer struct {
+	w        Writer
+	size     int
+	interval time.Duration
+	// retry is how long failed messages wait before they are redelivered
+	retry time.Duration
+
+	mu    sync.Mutex
+	msgs  []jetstream.Msg
+	batch []events.Published
+	full  chan struct{}
+}
+
+func NewBuffer(w Writer, size int, interval, retry time.Duration) *Buffer {
+	if size <= 0 {
+		size = 500
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Buffer{
+		w:        w,
+		size:     size,
+		interval: interval,
+		retry:    retry,
+		full:     make(chan struct{}, 1),
+	}
+}
+
+// Add buffers the event of msg, it fits nats.Handler.
+func (b *Buffer) Add(ctx context.Context, msg jetstream.Msg) {
+	var e events.Published
+	err := json.Unmarshal(msg.Data(), &e)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	b.mu.Lock()
+	b.msgs = append(b.msgs, msg)
+	b.batch = append(b.batch, e)
+	full := len(b.batch) >= b.size
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run writes the buffered events until ctx is done.
+func (b *Buffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		err := b.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to write events: %s", err)
+		}
+	}
+}
+
+// Flush writes the buffered events, in batches of at most size.
+func (b *Buffer) Flush(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		n := min(len(b.batch), b.size)
+		msgs, batch := b.msgs[:n:n], b.batch[:n:n]
+		b.msgs, b.batch = b.msgs[n:], b.batch[n:]
+		b.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		err := b.w.Write(ctx, batch)
+		if err != nil {
+			for _, msg := range msgs {
+				msg.NakWithDelay(b.retry)
+			}
+			return err
+		}
+		for _, msg := range msgs {
+			msg.Ack()
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package eventsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestEventsink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Eventsink Suite")
+}
@@ -0,0 +1,213 @@
+package eventsink_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	. "github.com/alireza-karampour/sms/internal/eventsink"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeMsg records how the buffer settled it.
+type fakeMsg struct {
+	jetstream.Msg
+	data []byte
+
+	mu      sync.Mutex
+	outcome string
+}
+
+func (m *fakeMsg) Data() []byte { return m.data }
+func (m *fakeMsg) Ack() error   { return m.settle("ack") }
+func (m *fakeMsg) NakWithDelay(time.Duration) error {
+	return m.settle("nak")
+}
+func (m *fakeMsg) TermWithReason(string) error { return m.settle("term") }
+
+func (m *fakeMsg) settle(outcome string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcome = outcome
+	return nil
+}
+
+func (m *fakeMsg) Outcome() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outcome
+}
+
+func newMsg(id string) *fakeMsg {
+	data, _ := json.Marshal(events.Published{
+		ID:         id,
+		SmsID:      42,
+		Event:      events.Delivered,
+		Actor:      "worker:1",
+		Provider:   "vonage",
+		Metadata:   json.RawMessage(`{"from":"submitted"}`),
+		OccurredAt: time.Date(2026, 10, 16, 9, 30, 0, 123456000, time.UTC),
+	})
+	return &fakeMsg{data: data}
+}
+
+type fakeWriter struct {
+	mu      sync.Mutex
+	batches [][]events.Published
+	err     error
+}
+
+func (w *fakeWriter) Write(ctx context.Context, batch []events.Published) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.batches = append(w.batches, batch)
+	return nil
+}
+
+var _ = Describe("Buffer", func() {
+	var (
+		w   *fakeWriter
+		buf *Buffer
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		w = &fakeWriter{}
+		buf = NewBuffer(w, 2, time.Hour, time.Second)
+		ctx = context.Background()
+	})
+
+	It("should write in batches and ack the messages once written", func() {
+		msgs := []*fakeMsg{newMsg("a"), newMsg("b"), newMsg("c")}
+		for _, m := range msgs {
+			buf.Add(ctx, m)
+		}
+		Expect(msgs[0].Outcome()).To(BeEmpty())
+
+		Expect(buf.Flush(ctx)).To(Succeed())
+		Expect(w.batches).To(HaveLen(2))
+		Expect(w.batches[0]).To(HaveLen(2))
+		Expect(w.batches[1][0].ID).To(Equal("c"))
+		for _, m := range msgs {
+			Expect(m.Outcome()).To(Equal("ack"))
+		}
+	})
+
+	It("should hand the messages of a failed batch back", func() {
+		w.err = errors.New("down")
+		m := newMsg("a")
+		buf.Add(ctx, m)
+		Expect(buf.Flush(ctx)).ToNot(Succeed())
+		Expect(m.Outcome()).To(Equal("nak"))
+	})
+
+	It("should terminate messages that aren't events", func() {
+		m := &fakeMsg{data: []byte("nope")}
+		buf.Add(ctx, m)
+		Expect(m.Outcome()).To(Equal("term"))
+	})
+
+	It("should flush once a batch is full", func() {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go buf.Run(runCtx)
+		a, b := newMsg("a"), newMsg("b")
+		buf.Add(ctx, a)
+		buf.Add(ctx, b)
+		Eventually(b.Outcome).Should(Equal("ack"))
+	})
+})
+
+var _ = Describe("Writers", func() {
+	batch := []events.Published{
+		{
+			ID:         "42-delivered-1",
+			SmsID:      42,
+			Event:      events.Delivered,
+			Actor:      "worker:1",
+			Metadata:   json.RawMessage(`{"from":"submitted"}`),
+			OccurredAt: time.Date(2026, 10, 16, 9, 30, 0, 123456000, time.UTC),
+		},
+	}
+
+	It("should insert into clickhouse as JSONEachRow", func() {
+		var query, user string
+		var rows []map[string]any
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query().Get("query")
+			user = r.Header.Get("X-ClickHouse-User")
+			sc := bufio.NewScanner(r.Body)
+			for sc.Scan() {
+				var row map[string]any
+				json.Unmarshal(sc.Bytes(), &row)
+				rows = append(rows, row)
+			}
+		}))
+		defer srv.Close()
+
+		ch, err := NewClickHouse(ClickHouseConfig{URL: srv.URL, Database: "analytics", Table: "sms_events", Username: "sms", Password: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ch.Write(context.Background(), batch)).To(Succeed())
+		Expect(query).To(Equal("INSERT INTO analytics.sms_events FORMAT JSONEachRow"))
+		Expect(user).To(Equal("sms"))
+		Expect(rows).To(HaveLen(1))
+		Expect(rows[0]).To(HaveKeyWithValue("occurred_at", "2026-10-16 09:30:00.123456"))
+		Expect(rows[0]).To(HaveKeyWithValue("metadata", `{"from":"submitted"}`))
+	})
+
+	It("should fail on errors of clickhouse", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Code: 60. DB::Exception: Table doesn't exist", http.StatusNotFound)
+		}))
+		defer srv.Close()
+		ch, err := NewClickHouse(ClickHouseConfig{URL: srv.URL, Table: "sms_events"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ch.Write(context.Background(), batch)).To(MatchError(ContainSubstring("Table doesn't exist")))
+	})
+
+	It("should stream into bigquery with the event ids as insert ids", func() {
+		var path, auth string
+		var body struct {
+			Rows []struct {
+				InsertID string         `json:"insertId"`
+				JSON     map[string]any `json:"json"`
+			} `json:"rows"`
+		}
+		insertErrors := false
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			auth = r.Header.Get("Authorization")
+			json.NewDecoder(r.Body).Decode(&body)
+			if insertErrors {
+				w.Write([]byte(`{"insertErrors":[{"index":0,"errors":[{"reason":"invalid","message":"no such field"}]}]}`))
+				return
+			}
+			w.Write([]byte(`{"kind":"bigquery#tableDataInsertAllResponse"}`))
+		}))
+		defer srv.Close()
+
+		bq, err := NewBigQuery(BigQueryConfig{Project: "acme", Dataset: "sms", Table: "events", Token: "t0k3n", Endpoint: srv.URL})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bq.Write(context.Background(), batch)).To(Succeed())
+		Expect(path).To(Equal("/bigquery/v2/projects/acme/datasets/sms/tables/events/insertAll"))
+		Expect(auth).To(Equal("Bearer t0k3n"))
+		Expect(body.Rows).To(HaveLen(1))
+		Expect(body.Rows[0].InsertID).To(Equal("42-delivered-1"))
+		Expect(body.Rows[0].JSON).To(HaveKeyWithValue("event", "delivered"))
+
+		insertErrors = true
+		Expect(bq.Write(context.Background(), batch)).To(MatchError(ContainSubstring("no such field")))
+	})
+})
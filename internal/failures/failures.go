@@ -0,0 +1,159 @@
+// Package failures publishes the errors the workers run into on the error
+// subjects of the sms work queues, for operators to see why messages are
+// retried or dropped without going through the logs of every worker.
+package failures
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// stages of the workers a failure happened in
+const (
+	// StageDecode is reading the message itself
+	StageDecode   = "decode"
+	StageAccount  = "account"
+	StageStore    = "store"
+	StageStatus   = "status"
+	StageEvents   = "events"
+	StageWebhooks = "webhooks"
+	StageForwards = "forwards"
+	StageFallback = "fallback"
+	StageSubmit   = "submit"
+)
+
+// classes of failures
+const (
+	// ClassInvalid is a message that can never be processed, it is terminated
+	ClassInvalid = "invalid"
+	// ClassDatabase is postgres failing, the message is redelivered
+	ClassDatabase = "database"
+	// ClassQueue is NATS failing to take a follow-up message, the message is redelivered
+	ClassQueue = "queue"
+	// ClassProvider is the carrier failing to take the sms
+	ClassProvider = "provider"
+	// ClassTimeout is a call running out of time, the message is redelivered
+	ClassTimeout = "timeout"
+)
+
+// ClassOf classifies err, a failure to talk to whatever fallback names unless
+// err says otherwise.
+func ClassOf(err error, fallback string) string {
+	var jsErr jetstream.JetStreamError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.As(err, &jsErr), errors.Is(err, nats.ErrTimeout), errors.Is(err, nats.ErrNoResponders), errors.Is(err, nats.ErrConnectionClosed):
+		return ClassQueue
+	default:
+		return fallback
+	}
+}
+
+// SubjectOf is the error subject of the work queue of priority, e.g. "sms.ex.send.error".
+// failures of messages without a priority go to the normal queue.
+func SubjectOf(priority string) (string, error) {
+	if priority == "" {
+		return NormalErr(), nil
+	}
+	return PriorityErr(Priority(priority))
+}
+
+// Failure is a failure of a worker to process a message, as it is published on
+// SubjectOf its priority.
+type Failure struct {
+	// MessageID identifies the failed message across redeliveries, see events.MessageID
+	MessageID string `json:"message_id"`
+	// Subject is the subject of the failed message
+	Subject  string `json:"subject"`
+	SmsID    int32  `json:"sms_id,omitempty"`
+	Priority string `json:"priority"`
+	Stage    string `json:"stage"`
+	Class    string `json:"class"`
+	Error    string `json:"error"`
+	// Attempt is the delivery of the message that failed, from 1
+	Attempt uint64 `json:"attempt"`
+	// Final is set when the message is dropped rather than redelivered
+	Final      bool      `json:"final"`
+	Worker     string    `json:"worker"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ID is the same for every publication of the failure, JetStream dedupes on it.
+func (f Failure) ID() string {
+	return fmt.Sprintf("error-%s-%s-%d", f.MessageID, f.Stage, f.Attempt)
+}
+
+// Reporter publishes failures. a nil Reporter reports nothing.
+type Reporter struct {
+	js     jetstream.JetStream
+	worker string
+}
+
+// NewReporter publishes the failures of worker, e.g. "worker:sms-worker-0".
+func NewReporter(js jetstream.JetStream, worker string) *Reporter {
+	return &Reporter{js: js, worker: worker}
+}
+
+// Report fills f in from msg and publishes it. failing to publish it is only
+// logged, the failure is handled the same either way.
+func (r *Reporter) Report(ctx context.Context, msg jetstream.Msg, f Failure) {
+	if r == nil {
+		return
+	}
+	f.MessageID = events.MessageID(msg)
+	f.Subject = msg.Subject()
+	if md, err := msg.Metadata(); err == nil {
+		f.Attempt = md.NumDelivered
+	}
+	f.Worker = r.worker
+	f.OccurredAt = time.Now().UTC()
+	subject, err := SubjectOf(f.Priority)
+	if err != nil {
+		logrus.Errorf("failed to publish failure of %s: %s", f.MessageID, err)
+		return
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		logrus.Errorf("failed to encode failure of %s: %s", f.MessageID, err)
+		return
+	}
+	// a worker that is shutting down still reports why it gave up on the message
+	pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err = r.js.PublishMsg(pctx, &nats.Msg{
+		Subject: subject,
+		Data:    data,
+	}, jetstream.WithMsgID(f.ID()))
+	if err != nil {
+		logrus.Errorf("failed to publish failure of %s: %s", f.MessageID, err)
+	}
+}
+
+// Save stores f in worker_errors. saving a failure again is a no-op.
+func Save(ctx context.Context, q *sqlc.Queries, f Failure) error {
+	return q.AddWorkerError(ctx, sqlc.AddWorkerErrorParams{
+		MessageID:  f.MessageID,
+		Subject:    f.Subject,
+		SmsID:      pgtype.Int4{Int32: f.SmsID, Valid: f.SmsID != 0},
+		Priority:   f.Priority,
+		Stage:      f.Stage,
+		Class:      f.Class,
+		Error:      f.Error,
+		Attempt:    int32(f.Attempt),
+		Final:      f.Final,
+		Worker:     f.Worker,
+		OccurredAt: pgtype.Timestamp{Time: f.OccurredAt.UTC(), Valid: true},
+	})
+}
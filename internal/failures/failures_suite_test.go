@@ -0,0 +1,13 @@
+package failures_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFailures(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Failures Suite")
+}
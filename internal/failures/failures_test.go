@@ -0,0 +1,88 @@
+package failures_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/failures"
+	"github.com/alireza-karampour/sms/internal/subjects"
+)
+
+type fakeMsg struct {
+	jetstream.Msg
+	subject string
+	header  nats.Header
+}
+
+func (m *fakeMsg) Subject() string      { return m.subject }
+func (m *fakeMsg) Headers() nats.Header { return m.header }
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: 3}, nil
+}
+
+type fakeJetStream struct {
+	jetstream.JetStream
+	msgs []*nats.Msg
+}
+
+func (js *fakeJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.msgs = append(js.msgs, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+var _ = Describe("SubjectOf", func() {
+	It("should pick the error subject of the priority", func() {
+		Expect(failures.SubjectOf("express")).To(Equal("sms.ex.send.error"))
+		Expect(failures.SubjectOf("normal")).To(Equal("sms.send.error"))
+		Expect(failures.SubjectOf("")).To(Equal("sms.send.error"))
+		_, err := failures.SubjectOf("urgent")
+		Expect(err).To(MatchError(subjects.ErrPriority))
+	})
+})
+
+var _ = Describe("ClassOf", func() {
+	It("should tell timeouts and queue failures apart", func() {
+		Expect(failures.ClassOf(fmt.Errorf("query: %w", context.DeadlineExceeded), failures.ClassDatabase)).To(Equal(failures.ClassTimeout))
+		Expect(failures.ClassOf(fmt.Errorf("publish: %w", nats.ErrNoResponders), failures.ClassDatabase)).To(Equal(failures.ClassQueue))
+		Expect(failures.ClassOf(errors.New("connection refused"), failures.ClassDatabase)).To(Equal(failures.ClassDatabase))
+	})
+})
+
+var _ = Describe("Reporter", func() {
+	It("should publish failures with the message they are about", func() {
+		js := &fakeJetStream{}
+		r := failures.NewReporter(js, "worker:test")
+		msg := &fakeMsg{subject: "sms.ex.send.request", header: nats.Header{events.HeaderMessageID: []string{"0a1b"}}}
+		r.Report(context.Background(), msg, failures.Failure{
+			Priority: "express",
+			Stage:    failures.StageStore,
+			Class:    failures.ClassDatabase,
+			Error:    "connection refused",
+		})
+		Expect(js.msgs).To(HaveLen(1))
+		Expect(js.msgs[0].Subject).To(Equal("sms.ex.send.error"))
+
+		var f failures.Failure
+		Expect(json.Unmarshal(js.msgs[0].Data, &f)).To(Succeed())
+		Expect(f.MessageID).To(Equal("0a1b"))
+		Expect(f.Subject).To(Equal("sms.ex.send.request"))
+		Expect(f.Attempt).To(BeEquivalentTo(3))
+		Expect(f.Worker).To(Equal("worker:test"))
+		Expect(f.OccurredAt).ToNot(BeZero())
+		// redeliveries of the failure are deduped, failures of later attempts aren't
+		Expect(f.ID()).To(Equal("error-0a1b-store-3"))
+	})
+
+	It("should report nothing when nil", func() {
+		var r *failures.Reporter
+		r.Report(context.Background(), &fakeMsg{}, failures.Failure{})
+	})
+})
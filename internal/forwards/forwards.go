@@ -0,0 +1,222 @@
+// Package forwards forwards the delivery events of users to nats servers of their
+// own, with the credentials of the servers sealed with a secrets.Envelope.
+package forwards
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// headers sent with every forwarded event
+const (
+	HeaderEvent = "Sms-Event"
+	// HeaderEventID is the same for every attempt of one event, subscribers can
+	// dedupe on it. jetstream targets dedupe on it themselves
+	HeaderEventID = "Sms-Event-Id"
+)
+
+// the ways to authenticate with a target, see Credentials.Auth
+const (
+	AuthNone     = "none"
+	AuthPassword = "password"
+	AuthToken    = "token"
+	AuthJWT      = "jwt"
+)
+
+var (
+	ErrInvalidTarget      = errors.New("invalid forwarding target")
+	ErrInvalidCredentials = errors.New("invalid forwarding credentials")
+)
+
+// Credentials authenticate the forwarder with a target, with at most one of a user
+// and password, a token, or the jwt and nkey seed of a .creds file.
+type Credentials struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	JWT      string `json:"jwt,omitempty"`
+	Seed     string `json:"seed,omitempty"`
+}
+
+// Auth is how c authenticates, one of the Auth constants.
+func (c Credentials) Auth() string {
+	switch {
+	case c.JWT != "" || c.Seed != "":
+		return AuthJWT
+	case c.Token != "":
+		return AuthToken
+	case c.User != "" || c.Password != "":
+		return AuthPassword
+	default:
+		return AuthNone
+	}
+}
+
+func (c Credentials) Validate() error {
+	set := 0
+	for _, ok := range []bool{c.User != "" || c.Password != "", c.Token != "", c.JWT != "" || c.Seed != ""} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set > 1:
+		return fmt.Errorf("%w: use one of user and password, token, or jwt and seed", ErrInvalidCredentials)
+	case (c.User == "") != (c.Password == ""):
+		return fmt.Errorf("%w: user and password go together", ErrInvalidCredentials)
+	case (c.JWT == "") != (c.Seed == ""):
+		return fmt.Errorf("%w: jwt and seed go together", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+// Options are the options connecting with c.
+func (c Credentials) Options() []natsgo.Option {
+	switch c.Auth() {
+	case AuthJWT:
+		return []natsgo.Option{natsgo.UserJWTAndSeed(c.JWT, c.Seed)}
+	case AuthToken:
+		return []natsgo.Option{natsgo.Token(c.Token)}
+	case AuthPassword:
+		return []natsgo.Option{natsgo.UserInfo(c.User, c.Password)}
+	default:
+		return nil
+	}
+}
+
+// Target is where the events of a user are forwarded: published on Subject of the
+// servers at URL, through jetstream when JetStream is set.
+type Target struct {
+	URL         string
+	Subject     string
+	JetStream   bool
+	Credentials Credentials
+}
+
+// Validate checks that URL lists nats servers and Subject is a subject events can
+// be published on, without wildcards.
+func (t Target) Validate() error {
+	if t.URL == "" {
+		return fmt.Errorf("%w: no url", ErrInvalidTarget)
+	}
+	for _, server := range strings.Split(t.URL, ",") {
+		u, err := url.Parse(strings.TrimSpace(server))
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%w: malformed url %q", ErrInvalidTarget, server)
+		}
+		switch u.Scheme {
+		case "nats", "tls", "ws", "wss":
+		default:
+			return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidTarget, u.Scheme)
+		}
+		if u.User != nil {
+			return fmt.Errorf("%w: pass the credentials apart from the url", ErrInvalidTarget)
+		}
+	}
+	if t.Subject == "" || strings.ContainsAny(t.Subject, " \t\r\n") {
+		return fmt.Errorf("%w: malformed subject %q", ErrInvalidTarget, t.Subject)
+	}
+	for _, token := range strings.Split(t.Subject, ".") {
+		if token == "" || token == "*" || token == ">" {
+			return fmt.Errorf("%w: malformed subject %q", ErrInvalidTarget, t.Subject)
+		}
+	}
+	return t.Credentials.Validate()
+}
+
+// Forward is published on DeliverSubject to hand one event of a user to the
+// forwarder. Payload is the body of the webhooks of the event.
+type Forward struct {
+	UserID  int32           `json:"user_id"`
+	EventID string          `json:"event_id"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DeliverSubject is where events wait for the forwarder.
+var DeliverSubject = ForwardsDeliver()
+
+// StreamConfig is the work queue of the events to forward. events wait in it
+// between retries.
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        FORWARDS_CONSUMER_NAME,
+		Description: "work queue for events forwarded to the nats servers of users",
+		Subjects:    []string{DeliverSubject},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// aad binds sealed credentials to the user they were sealed for, so they can't be
+// copied to another row.
+func aad(userID int32) []byte {
+	return fmt.Appendf(nil, "nats_forwards:%d", userID)
+}
+
+// Seal encrypts the credentials of the target of userID.
+func Seal(ctx context.Context, env *secrets.Envelope, userID int32, c Credentials) (secrets.Sealed, error) {
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return secrets.Sealed{}, err
+	}
+	return env.Seal(ctx, plaintext, aad(userID))
+}
+
+// Open decrypts the credentials of fwd and returns its target.
+func Open(ctx context.Context, env *secrets.Envelope, fwd sqlc.NatsForward) (Target, error) {
+	plaintext, err := env.Open(ctx, sealed(fwd), aad(fwd.UserID))
+	if err != nil {
+		return Target{}, err
+	}
+	t := Target{URL: fwd.Url, Subject: fwd.Subject, JetStream: fwd.Jetstream}
+	err = json.Unmarshal(plaintext, &t.Credentials)
+	if err != nil {
+		return Target{}, err
+	}
+	return t, nil
+}
+
+// Rewrap wraps the data keys of the targets that weren't with the current master
+// key of env with it, and returns how many it rewrapped, like providers.Rewrap.
+func Rewrap(ctx context.Context, q *sqlc.Queries, env *secrets.Envelope) (int, error) {
+	fwds, err := q.ListNatsForwards(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	for _, fwd := range fwds {
+		if env.Current(sealed(fwd)) {
+			continue
+		}
+		s, err := env.Rewrap(ctx, sealed(fwd))
+		if err != nil {
+			return rewrapped, fmt.Errorf("forward of user %d: %w", fwd.UserID, err)
+		}
+		n, err := q.RewrapNatsForward(ctx, sqlc.RewrapNatsForwardParams{
+			DataKey:  s.DataKey,
+			UserID:   fwd.UserID,
+			Previous: fwd.DataKey,
+		})
+		if err != nil {
+			return rewrapped, err
+		}
+		rewrapped += int(n)
+	}
+	return rewrapped, nil
+}
+
+func sealed(fwd sqlc.NatsForward) secrets.Sealed {
+	return secrets.Sealed{Ciphertext: fwd.Credentials, DataKey: fwd.DataKey}
+}
@@ -0,0 +1,13 @@
+package forwards_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestForwards(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Forwards Suite")
+}
@@ -0,0 +1,79 @@
+package forwards_test
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+var _ = Describe("Forwards", func() {
+	Context("Target", func() {
+		target := Target{URL: "nats://nats.example.com:4222", Subject: "acme.dlr"}
+
+		It("should accept nats servers and a plain subject", func() {
+			Expect(target.Validate()).To(Succeed())
+			t := target
+			t.URL = "tls://a.example.com:4222, tls://b.example.com:4222"
+			t.Credentials = Credentials{Token: "s3cret"}
+			Expect(t.Validate()).To(Succeed())
+		})
+
+		DescribeTable("should refuse",
+			func(change func(*Target)) {
+				t := target
+				change(&t)
+				Expect(t.Validate()).NotTo(Succeed())
+			},
+			Entry("another scheme", func(t *Target) { t.URL = "http://nats.example.com" }),
+			Entry("credentials in the url", func(t *Target) { t.URL = "nats://u:p@nats.example.com" }),
+			Entry("wildcards", func(t *Target) { t.Subject = "acme.>" }),
+			Entry("empty tokens", func(t *Target) { t.Subject = "acme..dlr" }),
+			Entry("two ways to authenticate", func(t *Target) { t.Credentials = Credentials{Token: "t", User: "u", Password: "p"} }),
+			Entry("a user without a password", func(t *Target) { t.Credentials = Credentials{User: "u"} }),
+			Entry("a jwt without a seed", func(t *Target) { t.Credentials = Credentials{JWT: "eyJ"} }),
+		)
+	})
+
+	It("should tell how the credentials authenticate", func() {
+		Expect(Credentials{}.Auth()).To(Equal(AuthNone))
+		Expect(Credentials{}.Options()).To(BeEmpty())
+		Expect(Credentials{User: "u", Password: "p"}.Auth()).To(Equal(AuthPassword))
+		Expect(Credentials{Token: "t"}.Auth()).To(Equal(AuthToken))
+		Expect(Credentials{JWT: "eyJ", Seed: "SUA"}.Auth()).To(Equal(AuthJWT))
+		Expect(Credentials{JWT: "eyJ", Seed: "SUA"}.Options()).To(HaveLen(1))
+	})
+
+	It("should seal the credentials for their user only", func() {
+		keys, err := secrets.NewLocalKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		env := secrets.NewEnvelope(keys)
+		ctx := context.Background()
+
+		creds := Credentials{User: "acme", Password: "s3cret"}
+		sealed, err := Seal(ctx, env, 7, creds)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(sealed.Ciphertext)).NotTo(ContainSubstring("s3cret"))
+
+		fwd := sqlc.NatsForward{
+			UserID:      7,
+			Url:         "nats://nats.example.com",
+			Subject:     "acme.dlr",
+			Jetstream:   true,
+			Credentials: sealed.Ciphertext,
+			DataKey:     sealed.DataKey,
+		}
+		target, err := Open(ctx, env, fwd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(Target{URL: fwd.Url, Subject: fwd.Subject, JetStream: true, Credentials: creds}))
+
+		fwd.UserID = 8
+		_, err = Open(ctx, env, fwd)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,138 @@
+package forwards
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var ErrNoTarget = errors.New("user forwards no events")
+
+// Targets publishes the forwarded events of every user on their own target, over
+// a connection per user kept until the target changes or is deleted.
+type Targets struct {
+	q       *sqlc.Queries
+	env     *secrets.Envelope
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conns map[int32]*conn
+}
+
+type conn struct {
+	// dataKey is different for every sealing of the target
+	dataKey string
+	target  Target
+	nc      *natsgo.Conn
+	js      jetstream.JetStream
+}
+
+// NewTargets opens the credentials of the targets with env. timeout bounds
+// connecting to a target and every publish on it.
+func NewTargets(q *sqlc.Queries, env *secrets.Envelope, timeout time.Duration) *Targets {
+	return &Targets{
+		q:       q,
+		env:     env,
+		timeout: timeout,
+		conns:   make(map[int32]*conn),
+	}
+}
+
+// Publish publishes f on the target of its user. it fails with ErrNoTarget once
+// the user deleted the target.
+func (t *Targets) Publish(ctx context.Context, f Forward) error {
+	c, err := t.conn(ctx, f.UserID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	msg := &natsgo.Msg{
+		Subject: c.target.Subject,
+		Data:    f.Payload,
+		Header:  natsgo.Header{},
+	}
+	msg.Header.Set(HeaderEvent, f.Event)
+	msg.Header.Set(HeaderEventID, f.EventID)
+	if c.js != nil {
+		_, err = c.js.PublishMsg(ctx, msg, jetstream.WithMsgID(f.EventID))
+		return err
+	}
+	err = c.nc.PublishMsg(msg)
+	if err != nil {
+		return err
+	}
+	// a core publish is only known to have left once the server answered a ping
+	return c.nc.FlushWithContext(ctx)
+}
+
+// conn returns the connection to the target of userID, connecting again when the
+// target changed since.
+func (t *Targets) conn(ctx context.Context, userID int32) (*conn, error) {
+	fwd, err := t.q.GetNatsForward(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		t.drop(userID)
+		return nil, ErrNoTarget
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.conns[userID]; ok && c.dataKey == fwd.DataKey && !c.nc.IsClosed() {
+		return c, nil
+	}
+	target, err := Open(ctx, t.env, fwd)
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]natsgo.Option{
+		natsgo.Name("sms-forwarder"),
+		natsgo.Timeout(t.timeout),
+	}, target.Credentials.Options()...)
+	nc, err := natsgo.Connect(target.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{dataKey: fwd.DataKey, target: target, nc: nc}
+	if target.JetStream {
+		c.js, err = jetstream.New(nc)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if old, ok := t.conns[userID]; ok {
+		old.nc.Close()
+	}
+	t.conns[userID] = c
+	return c, nil
+}
+
+// drop closes the connection to a deleted target.
+func (t *Targets) drop(userID int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.conns[userID]; ok {
+		c.nc.Close()
+		delete(t.conns, userID)
+	}
+}
+
+// Close closes the connections to every target.
+func (t *Targets) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userID, c := range t.conns {
+		c.nc.Close()
+		delete(t.conns, userID)
+	}
+}
@@ -0,0 +1,132 @@
+// Package fraud flags API keys whose recent sends look like abuse: a send rate far
+// above the key's usual one, messages spread over many countries or a high share
+// of failed messages.
+package fraud
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/spf13/viper"
+)
+
+// reasons a key is flagged for
+const (
+	ReasonRateSpike    = "rate_spike"
+	ReasonDestinations = "destination_mix"
+	ReasonFailures     = "failure_rate"
+)
+
+// actions taken on flagged keys
+const (
+	// ActionAlert only records and reports the finding
+	ActionAlert = "alert"
+	// ActionThrottle lowers the key's rate limit for a while
+	ActionThrottle = "throttle"
+	// ActionSuspend refuses the key until an admin lifts the suspension
+	ActionSuspend = "suspend"
+)
+
+// EventAlert is the event type of the alerts posted to worker.fraud.alerts.url.
+const EventAlert = "fraud.alert"
+
+type Config struct {
+	// Window is the recent activity looked at on every run
+	Window time.Duration
+	// Baseline is the history before Window the usual send rate is taken from
+	Baseline time.Duration
+	// MinSent spares keys that sent fewer messages within Window
+	MinSent int
+	// SpikeFactor flags keys that sent this many times their usual rate
+	SpikeFactor float64
+	// MaxCountries flags keys that sent to more destination countries within Window
+	MaxCountries int
+	// FailureRate flags keys with at least this share of failed messages
+	FailureRate float64
+	Action      string
+	// ThrottleLimit is the rate limit of throttled keys, per api.ratelimit.key.window
+	ThrottleLimit int
+	ThrottleFor   time.Duration
+}
+
+// ConfigFromViper reads the config from worker.fraud. unknown actions only alert.
+func ConfigFromViper() Config {
+	c := Config{
+		Window:        viper.GetDuration("worker.fraud.window"),
+		Baseline:      viper.GetDuration("worker.fraud.baseline"),
+		MinSent:       viper.GetInt("worker.fraud.minsent"),
+		SpikeFactor:   viper.GetFloat64("worker.fraud.spikefactor"),
+		MaxCountries:  viper.GetInt("worker.fraud.maxcountries"),
+		FailureRate:   viper.GetFloat64("worker.fraud.failurerate"),
+		Action:        viper.GetString("worker.fraud.action"),
+		ThrottleLimit: viper.GetInt("worker.fraud.throttle.limit"),
+		ThrottleFor:   viper.GetDuration("worker.fraud.throttle.duration"),
+	}
+	if !slices.Contains([]string{ActionAlert, ActionThrottle, ActionSuspend}, c.Action) {
+		c.Action = ActionAlert
+	}
+	return c
+}
+
+// Activity is what one key sent within the window.
+type Activity struct {
+	KeyID  int32
+	UserID int32
+	Sent   int
+	Failed int
+	// Destinations counts the messages by destination number
+	Destinations map[string]int
+	// Baseline is the number of messages the key sent during Config.Baseline
+	Baseline int
+}
+
+// Finding is a key flagged by Detect.
+type Finding struct {
+	KeyID  int32
+	UserID int32
+	Reason string
+	Detail string
+}
+
+// Detect flags a's key when its activity trips one of the rules, checked in the
+// order failures, rate spike, destinations.
+func (c Config) Detect(a Activity) (Finding, bool) {
+	f := Finding{KeyID: a.KeyID, UserID: a.UserID}
+	if a.Sent < c.MinSent || a.Sent == 0 {
+		return f, false
+	}
+	if rate := float64(a.Failed) / float64(a.Sent); c.FailureRate > 0 && rate >= c.FailureRate {
+		f.Reason = ReasonFailures
+		f.Detail = fmt.Sprintf("%d of %d messages failed", a.Failed, a.Sent)
+		return f, true
+	}
+	if c.SpikeFactor > 0 {
+		usual := float64(c.MinSent)
+		if c.Baseline > 0 {
+			usual = max(usual, float64(a.Baseline)*float64(c.Window)/float64(c.Baseline))
+		}
+		if float64(a.Sent) > c.SpikeFactor*usual {
+			f.Reason = ReasonRateSpike
+			f.Detail = fmt.Sprintf("%d messages in %s, usually %.0f", a.Sent, c.Window, usual)
+			return f, true
+		}
+	}
+	if countries := Countries(a.Destinations); c.MaxCountries > 0 && countries > c.MaxCountries {
+		f.Reason = ReasonDestinations
+		f.Detail = fmt.Sprintf("messages to %d countries in %s", countries, c.Window)
+		return f, true
+	}
+	return f, false
+}
+
+// Countries counts the destination countries of numbers. numbers of unknown
+// countries count as one.
+func Countries(numbers map[string]int) int {
+	seen := make(map[string]struct{})
+	for n := range numbers {
+		seen[phone.CountryCode(n)] = struct{}{}
+	}
+	return len(seen)
+}
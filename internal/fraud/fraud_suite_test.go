@@ -0,0 +1,13 @@
+package fraud_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFraud(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fraud Suite")
+}
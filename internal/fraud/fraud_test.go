@@ -0,0 +1,84 @@
+package fraud_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/fraud"
+)
+
+var _ = Describe("Detect", func() {
+	var cfg Config
+
+	BeforeEach(func() {
+		cfg = Config{
+			Window:       10 * time.Minute,
+			Baseline:     24 * time.Hour,
+			MinSent:      20,
+			SpikeFactor:  5,
+			MaxCountries: 3,
+			FailureRate:  0.5,
+		}
+	})
+
+	activity := func(sent, failed, baseline int, numbers ...string) Activity {
+		a := Activity{KeyID: 1, UserID: 2, Sent: sent, Failed: failed, Baseline: baseline, Destinations: map[string]int{}}
+		for _, n := range numbers {
+			a.Destinations[n]++
+		}
+		return a
+	}
+
+	It("should spare keys below the minimum", func() {
+		_, ok := cfg.Detect(activity(19, 19, 0, "+491511", "+989121", "+447700", "+331234"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should flag a high failure rate first", func() {
+		f, ok := cfg.Detect(activity(200, 100, 0))
+		Expect(ok).To(BeTrue())
+		Expect(f.Reason).To(Equal(ReasonFailures))
+		Expect(f.KeyID).To(Equal(int32(1)))
+		Expect(f.UserID).To(Equal(int32(2)))
+	})
+
+	It("should flag sends far above the usual rate", func() {
+		// 14400 a day is 100 per 10 minutes
+		_, ok := cfg.Detect(activity(500, 0, 14400))
+		Expect(ok).To(BeFalse())
+		f, ok := cfg.Detect(activity(501, 0, 14400))
+		Expect(ok).To(BeTrue())
+		Expect(f.Reason).To(Equal(ReasonRateSpike))
+	})
+
+	It("should hold new keys to the minimum as their usual rate", func() {
+		_, ok := cfg.Detect(activity(100, 0, 0))
+		Expect(ok).To(BeFalse())
+		f, ok := cfg.Detect(activity(101, 0, 0))
+		Expect(ok).To(BeTrue())
+		Expect(f.Reason).To(Equal(ReasonRateSpike))
+	})
+
+	It("should flag messages spread over many countries", func() {
+		numbers := []string{"+4915112345678", "+989121234567", "+447700900123"}
+		_, ok := cfg.Detect(activity(30, 0, 14400, numbers...))
+		Expect(ok).To(BeFalse())
+
+		f, ok := cfg.Detect(activity(30, 0, 14400, append(numbers, "+33612345678")...))
+		Expect(ok).To(BeTrue())
+		Expect(f.Reason).To(Equal(ReasonDestinations))
+		Expect(f.Detail).To(ContainSubstring("4 countries"))
+	})
+
+	It("should count numbers of unknown countries as one country", func() {
+		numbers := map[string]int{}
+		for i := range 5 {
+			numbers[fmt.Sprintf("+99912345%d", i)] = 1
+		}
+		numbers["+4915112345678"] = 1
+		Expect(Countries(numbers)).To(Equal(2))
+	})
+})
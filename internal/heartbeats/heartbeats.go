@@ -0,0 +1,202 @@
+// Package heartbeats lets operators see which workers are alive and how they are
+// doing: every worker publishes a heartbeat on subjects.WorkersHeartbeat, and the
+// api records the latest one of every worker in the workers table.
+package heartbeats
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// Heartbeat is what a worker reports about itself.
+type Heartbeat struct {
+	// InstanceID tells the worker processes apart, it changes with every start
+	InstanceID string    `json:"instance_id"`
+	Hostname   string    `json:"hostname"`
+	Version    string    `json:"version"`
+	StartedAt  time.Time `json:"started_at"`
+	SentAt     time.Time `json:"sent_at"`
+	// Processed is the number of messages the worker handled since it started
+	Processed uint64 `json:"processed"`
+	// Lag is the number of messages waiting for each consumer of the worker, by name
+	Lag map[string]uint64 `json:"lag"`
+}
+
+// Version is the version of the build, its module version or else its vcs
+// revision.
+var Version = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value[:min(len(s.Value), 12)]
+		}
+	}
+	return "devel"
+})
+
+// Lagger reports the lag of consumers, see nats.Consumer.Lag.
+type Lagger interface {
+	Lag(ctx context.Context) (map[string]uint64, error)
+}
+
+// Beater publishes the heartbeats of a worker.
+type Beater struct {
+	nc        *natsgo.Conn
+	self      Heartbeat
+	processed func() uint64
+	consumers []Lagger
+}
+
+// NewBeater reports processed and the lag of consumers in the heartbeats it
+// publishes on nc.
+func NewBeater(nc *natsgo.Conn, processed func() uint64, consumers ...Lagger) *Beater {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Beater{
+		nc: nc,
+		self: Heartbeat{
+			InstanceID: uuid.NewString(),
+			Hostname:   host,
+			Version:    Version(),
+			StartedAt:  time.Now().UTC(),
+		},
+		processed: processed,
+		consumers: consumers,
+	}
+}
+
+// Heartbeat is the heartbeat of the worker as of now. the lag of consumers the
+// server didn't answer for is left out.
+func (b *Beater) Heartbeat(ctx context.Context) Heartbeat {
+	hb := b.self
+	hb.SentAt = time.Now().UTC()
+	hb.Processed = b.processed()
+	hb.Lag = make(map[string]uint64)
+	for _, c := range b.consumers {
+		lag, err := c.Lag(ctx)
+		if err != nil {
+			logrus.Warnf("heartbeat without the lag of some consumers: %s", err)
+		}
+		for name, n := range lag {
+			hb.Lag[name] = n
+		}
+	}
+	return hb
+}
+
+// Beat publishes a heartbeat.
+func (b *Beater) Beat(ctx context.Context) error {
+	data, err := json.Marshal(b.Heartbeat(ctx))
+	if err != nil {
+		return err
+	}
+	return b.nc.Publish(WorkersHeartbeat(), data)
+}
+
+// Run publishes a heartbeat right away and then every interval until ctx is done.
+func (b *Beater) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		bctx, cancel := context.WithTimeout(ctx, interval)
+		err := b.Beat(bctx)
+		cancel()
+		if err != nil {
+			logrus.Errorf("failed to publish heartbeat: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Store keeps the latest heartbeat of every worker.
+type Store interface {
+	RecordWorkerHeartbeat(ctx context.Context, arg sqlc.RecordWorkerHeartbeatParams) error
+	DeleteWorkersSeenBefore(ctx context.Context, seenBefore pgtype.Timestamp) (int64, error)
+}
+
+// Params are what hb is recorded with.
+func Params(hb Heartbeat) (sqlc.RecordWorkerHeartbeatParams, error) {
+	if hb.Lag == nil {
+		hb.Lag = map[string]uint64{}
+	}
+	lag, err := json.Marshal(hb.Lag)
+	if err != nil {
+		return sqlc.RecordWorkerHeartbeatParams{}, err
+	}
+	return sqlc.RecordWorkerHeartbeatParams{
+		InstanceID: hb.InstanceID,
+		Hostname:   hb.Hostname,
+		Version:    hb.Version,
+		StartedAt:  pgtype.Timestamp{Time: hb.StartedAt.UTC(), Valid: true},
+		LastSeenAt: pgtype.Timestamp{Time: hb.SentAt.UTC(), Valid: true},
+		Processed:  int64(hb.Processed),
+		Lag:        lag,
+	}, nil
+}
+
+// Record records the heartbeats published on nc in store until the returned
+// subscription is unsubscribed. every api instance may record them, recording
+// a heartbeat twice changes nothing.
+func Record(ctx context.Context, nc *natsgo.Conn, store Store) (*natsgo.Subscription, error) {
+	return nc.Subscribe(WorkersHeartbeat(), func(msg *natsgo.Msg) {
+		var hb Heartbeat
+		err := json.Unmarshal(msg.Data, &hb)
+		if err != nil || hb.InstanceID == "" {
+			logrus.Warnf("dropping invalid heartbeat: %v", err)
+			return
+		}
+		params, err := Params(hb)
+		if err == nil {
+			err = store.RecordWorkerHeartbeat(ctx, params)
+		}
+		if err != nil {
+			logrus.Errorf("failed to record the heartbeat of worker %s: %s", hb.InstanceID, err)
+		}
+	})
+}
+
+// Prune deletes the workers that sent no heartbeat for retention, every
+// interval until ctx is done.
+func Prune(ctx context.Context, store Store, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		before := pgtype.Timestamp{Time: time.Now().UTC().Add(-retention), Valid: true}
+		n, err := store.DeleteWorkersSeenBefore(ctx, before)
+		if err != nil {
+			logrus.Errorf("failed to prune workers: %s", err)
+			continue
+		}
+		if n > 0 {
+			logrus.Infof("pruned %d workers without heartbeats for %s", n, retention)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package heartbeats_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHeartbeats(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Heartbeats Suite")
+}
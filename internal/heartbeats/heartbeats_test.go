@@ -0,0 +1,140 @@
+package heartbeats_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/heartbeats"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type lagger struct {
+	lag map[string]uint64
+	err error
+}
+
+func (l lagger) Lag(context.Context) (map[string]uint64, error) {
+	return l.lag, l.err
+}
+
+type store struct {
+	mu      sync.Mutex
+	pruned  []pgtype.Timestamp
+	deleted int64
+}
+
+func (s *store) RecordWorkerHeartbeat(context.Context, sqlc.RecordWorkerHeartbeatParams) error {
+	return nil
+}
+
+func (s *store) DeleteWorkersSeenBefore(_ context.Context, seenBefore pgtype.Timestamp) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruned = append(s.pruned, seenBefore)
+	return s.deleted, nil
+}
+
+func (s *store) Pruned() []pgtype.Timestamp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pgtype.Timestamp(nil), s.pruned...)
+}
+
+var _ = Describe("Heartbeats", func() {
+	It("has a version", func() {
+		Expect(Version()).NotTo(BeEmpty())
+	})
+
+	Describe("Beater", func() {
+		It("reports processed and the lag of all consumers", func() {
+			processed := uint64(41)
+			b := NewBeater(nil, func() uint64 { processed++; return processed },
+				lagger{lag: map[string]uint64{"normal": 3}},
+				lagger{lag: map[string]uint64{"express": 1, "export": 0}},
+			)
+			hb := b.Heartbeat(context.Background())
+			Expect(hb.InstanceID).NotTo(BeEmpty())
+			Expect(hb.Version).To(Equal(Version()))
+			Expect(hb.Processed).To(BeEquivalentTo(42))
+			Expect(hb.Lag).To(Equal(map[string]uint64{"normal": 3, "express": 1, "export": 0}))
+			Expect(hb.SentAt).NotTo(BeTemporally("<", hb.StartedAt))
+
+			again := b.Heartbeat(context.Background())
+			Expect(again.InstanceID).To(Equal(hb.InstanceID))
+			Expect(again.StartedAt).To(Equal(hb.StartedAt))
+			Expect(again.Processed).To(BeEquivalentTo(43))
+		})
+
+		It("leaves out the lag of consumers that failed", func() {
+			b := NewBeater(nil, func() uint64 { return 0 },
+				lagger{err: errors.New("timeout")},
+				lagger{lag: map[string]uint64{"normal": 7}},
+			)
+			Expect(b.Heartbeat(context.Background()).Lag).To(Equal(map[string]uint64{"normal": 7}))
+		})
+
+		It("tells instances apart", func() {
+			a := NewBeater(nil, func() uint64 { return 0 }).Heartbeat(context.Background())
+			b := NewBeater(nil, func() uint64 { return 0 }).Heartbeat(context.Background())
+			Expect(a.InstanceID).NotTo(Equal(b.InstanceID))
+		})
+	})
+
+	Describe("Params", func() {
+		It("records the heartbeat in UTC with the lag as json", func() {
+			tehran := time.FixedZone("IRST", 3*60*60+30*60)
+			hb := Heartbeat{
+				InstanceID: "i-1",
+				Hostname:   "worker-0",
+				Version:    "v1.2.3",
+				StartedAt:  time.Date(2026, 10, 17, 12, 0, 0, 0, tehran),
+				SentAt:     time.Date(2026, 10, 17, 12, 5, 0, 0, tehran),
+				Processed:  9,
+				Lag:        map[string]uint64{"normal": 2},
+			}
+			params, err := Params(hb)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params.InstanceID).To(Equal("i-1"))
+			Expect(params.Hostname).To(Equal("worker-0"))
+			Expect(params.Version).To(Equal("v1.2.3"))
+			Expect(params.StartedAt).To(Equal(pgtype.Timestamp{Time: time.Date(2026, 10, 17, 8, 30, 0, 0, time.UTC), Valid: true}))
+			Expect(params.LastSeenAt).To(Equal(pgtype.Timestamp{Time: time.Date(2026, 10, 17, 8, 35, 0, 0, time.UTC), Valid: true}))
+			Expect(params.Processed).To(BeEquivalentTo(9))
+
+			var lag map[string]uint64
+			Expect(json.Unmarshal(params.Lag, &lag)).To(Succeed())
+			Expect(lag).To(Equal(hb.Lag))
+		})
+
+		It("records no lag as an empty object", func() {
+			params, err := Params(Heartbeat{InstanceID: "i-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(params.Lag)).To(Equal("{}"))
+		})
+	})
+
+	Describe("Prune", func() {
+		It("deletes the workers not seen for retention every interval", func() {
+			s := &store{deleted: 1}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				Prune(ctx, s, time.Hour, 10*time.Millisecond)
+			}()
+			Eventually(func() int { return len(s.Pruned()) }).Should(BeNumerically(">=", 2))
+			cancel()
+			Eventually(done).Should(BeClosed())
+
+			before := s.Pruned()[0]
+			Expect(before.Valid).To(BeTrue())
+			Expect(before.Time).To(BeTemporally("~", time.Now().UTC().Add(-time.Hour), time.Minute))
+		})
+	})
+})
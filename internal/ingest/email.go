@@ -0,0 +1,180 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/smtpd"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoSuchNumber     = &smtpd.Error{Code: 550, Message: "no such number, mail <number>@<domain>"}
+	ErrSenderNotAllowed = &smtpd.Error{Code: 550, Message: "sender isn't allowed to send sms"}
+)
+
+// EmailConfig is how mails are turned into sms.
+type EmailConfig struct {
+	// Domain is the domain of the addresses mails are sent to, e.g. sms.example.com
+	Domain string
+	// Subject puts the subject of a mail before its body
+	Subject bool
+	// MaxLength cuts the texts of mails to this many characters
+	MaxLength int
+}
+
+// Email is the smtpd.Handler of mails to sms: a mail from an address in
+// email_senders to "+4912345@<domain>" is sent to +4912345 as POST /sms with the
+// sender's api key, from its number. mails may have several recipients.
+type Email struct {
+	cfg     EmailConfig
+	cluster *db.Cluster
+	lookup  middlewares.KeyLookup
+	api     http.Handler
+	// path is where POST /sms is served, e.g. "/v1/sms"
+	path string
+}
+
+func NewEmail(cfg EmailConfig, cluster *db.Cluster, lookup middlewares.KeyLookup, api http.Handler, path string) *Email {
+	return &Email{cfg: cfg, cluster: cluster, lookup: lookup, api: api, path: path}
+}
+
+// Number returns the number addr is addressed to, if it is one of domain.
+func Number(addr, domain string) (string, bool) {
+	local, host, ok := strings.Cut(addr, "@")
+	if !ok || !strings.EqualFold(host, domain) {
+		return "", false
+	}
+	digits := phone.Normalize(local)
+	// E.164 numbers have at most 15 digits, short ones don't cross borders
+	if len(digits) < 6 || len(digits) > 15 || digits != strings.TrimPrefix(strings.TrimPrefix(local, "+"), "00") {
+		return "", false
+	}
+	return "+" + digits, true
+}
+
+func (e *Email) sender(ctx context.Context, from string) (sqlc.GetEmailSenderRow, error) {
+	sender, err := sqlc.New(e.cluster.Reader()).GetEmailSender(ctx, strings.ToLower(from))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sender, ErrSenderNotAllowed
+	}
+	return sender, err
+}
+
+func (e *Email) Rcpt(ctx context.Context, from, to string) error {
+	if _, ok := Number(to, e.cfg.Domain); !ok {
+		return ErrNoSuchNumber
+	}
+	_, err := e.sender(ctx, from)
+	return err
+}
+
+// Deliver sends the mail to every recipient. it is refused when no sms was
+// accepted, with 451 when the api might accept them later, e.g. once a rate
+// limit reset, so the relay tries again. sms accepted for some recipients aren't
+// sent twice when it does: the id of the mail is their external_id.
+func (e *Email) Deliver(ctx context.Context, env smtpd.Envelope) error {
+	sender, err := e.sender(ctx, env.From)
+	if err != nil {
+		return err
+	}
+	principal, err := e.lookup(ctx, sender.KeyHash)
+	if err != nil {
+		if errors.Is(err, middlewares.ErrKeySuspended) || errors.Is(err, middlewares.ErrInvalidCredentials) {
+			metrics.EmailMessages.WithLabelValues("forbidden").Inc()
+			return &smtpd.Error{Code: 554, Message: err.Error()}
+		}
+		return err
+	}
+	text, err := Text(env.Data, e.cfg.Subject, e.cfg.MaxLength)
+	if err != nil {
+		metrics.EmailMessages.WithLabelValues("refused").Inc()
+		return &smtpd.Error{Code: 554, Message: err.Error()}
+	}
+	mailID := messageID(env.Data)
+
+	var accepted int
+	var refusal *smtpd.Error
+	for _, to := range env.To {
+		number, _ := Number(to, e.cfg.Domain)
+		body, err := json.Marshal(map[string]any{
+			"user_id":         sender.UserID,
+			"phone_number_id": sender.PhoneNumberID,
+			"to_phone_number": number,
+			"message":         text,
+			"external_id":     externalID(mailID, number),
+		})
+		if err != nil {
+			return err
+		}
+		r, err := http.NewRequestWithContext(middlewares.WithPrincipal(ctx, principal), http.MethodPost, e.path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = env.RemoteAddr.String()
+		w := newResponse()
+		e.api.ServeHTTP(w, r)
+
+		switch {
+		case w.code >= 200 && w.code <= 299, w.code == http.StatusConflict && mailID != "":
+			// conflicts are the sms of a mail delivered before
+			accepted++
+			metrics.EmailMessages.WithLabelValues("accepted").Inc()
+		default:
+			logrus.Warnf("refused sms from %s to %s by mail: %d %s", env.From, number, w.code, w.body.String())
+			metrics.EmailMessages.WithLabelValues("refused").Inc()
+			if refusal == nil {
+				refusal = &smtpd.Error{Code: 554, Message: "refused: " + apiError(w)}
+				if w.code == http.StatusTooManyRequests || w.code >= 500 {
+					refusal.Code = 451
+				}
+			}
+		}
+	}
+	if accepted == 0 && refusal != nil {
+		return refusal
+	}
+	return nil
+}
+
+// messageID identifies a mail across deliveries by its Message-ID header.
+func messageID(data []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(msg.Header.Get("Message-ID"))
+}
+
+func externalID(mailID, number string) string {
+	if mailID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(mailID + " " + number))
+	return "mail:" + hex.EncodeToString(sum[:16])
+}
+
+// apiError is the first error the api answered with.
+func apiError(w *response) string {
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	if json.Unmarshal(w.body.Bytes(), &body) == nil && len(body.Errors) > 0 {
+		return body.Errors[0]
+	}
+	return http.StatusText(w.code)
+}
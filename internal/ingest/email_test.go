@@ -0,0 +1,67 @@
+package ingest_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/ingest"
+)
+
+var _ = Describe("Number", func() {
+	DescribeTable("should take numbers of the domain only",
+		func(addr, number string, ok bool) {
+			n, found := Number(addr, "sms.example.com")
+			Expect(found).To(Equal(ok))
+			Expect(n).To(Equal(number))
+		},
+		Entry("international", "+4912345678@sms.example.com", "+4912345678", true),
+		Entry("without plus", "4912345678@SMS.example.com", "+4912345678", true),
+		Entry("00 prefix", "004912345678@sms.example.com", "+4912345678", true),
+		Entry("other domain", "+4912345678@example.com", "", false),
+		Entry("formatted", "+49-123-45678@sms.example.com", "", false),
+		Entry("short code", "1234@sms.example.com", "", false),
+		Entry("name", "ops@sms.example.com", "", false),
+	)
+})
+
+var _ = Describe("Text", func() {
+	It("should put the subject before the collapsed body", func() {
+		msg := "Subject: Server   down\n\nweb-1 is\nnot responding.\n\n> earlier mail\n-- \nOps team\n"
+		text, err := Text([]byte(msg), true, 255)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal("Server down\nweb-1 is not responding."))
+
+		text, err = Text([]byte(msg), false, 255)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal("web-1 is not responding."))
+	})
+
+	It("should decode encoded subjects and parts", func() {
+		msg := "Subject: =?UTF-8?B?R3LDvMOfZQ==?=\n" +
+			"Content-Type: multipart/alternative; boundary=b\n\n" +
+			"--b\nContent-Type: text/html; charset=utf-8\n\n<p>html &amp; more</p>\n" +
+			"--b\nContent-Type: text/plain; charset=iso-8859-1\nContent-Transfer-Encoding: quoted-printable\n\nSch=F6n=\n warm\n" +
+			"--b--\n"
+		text, err := Text([]byte(msg), true, 255)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal("Grüße\nSchön warm"))
+	})
+
+	It("should fall back to html without tags", func() {
+		msg := "Content-Type: text/html\nContent-Transfer-Encoding: base64\n\nPHN0eWxlPnB7fTwvc3R5bGU+PHA+UmVib290ICZhbXA7IHJldHJ5PC9wPg==\n"
+		text, err := Text([]byte(msg), false, 255)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal("Reboot & retry"))
+	})
+
+	It("should cut long texts", func() {
+		text, err := Text([]byte("Subject: ü\n\n"+"0123456789"), true, 5)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal("ü\n012"))
+	})
+
+	It("should refuse mails without text", func() {
+		_, err := Text([]byte("Content-Type: image/png\n\nxyz"), false, 255)
+		Expect(err).To(MatchError(ErrNoText))
+	})
+})
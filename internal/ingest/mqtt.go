@@ -0,0 +1,192 @@
+// Package ingest accepts send requests over protocols other than HTTP and hands
+// them to the api as POST /sms requests, so they go through the same checks.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/mqtt"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SendTopic prefixes the topics devices publish send requests to, followed by an api key
+	SendTopic = "sms/send/"
+	// ResultTopic prefixes the topic of every device's results, followed by its client id
+	ResultTopic = "sms/result/"
+)
+
+var (
+	ErrTopic       = errors.New("publish to sms/send/{apikey}")
+	ErrDeviceKey   = errors.New("the api key isn't the one of the device")
+	ErrDeviceQuota = errors.New("the device's daily quota is used up")
+)
+
+// Result is published to a device on its ResultTopic for every send request.
+type Result struct {
+	// Ref is the ref of the request, for the device to match them up
+	Ref    string `json:"ref,omitempty"`
+	Status int    `json:"status"`
+	// Response is the body POST /sms answered with
+	Response json.RawMessage `json:"response"`
+}
+
+// MQTT is the mqtt.Handler of devices sending sms. devices connect with their
+// client id and secret, see mqtt_devices, and publish the body of POST /sms to
+// SendTopic with the api key they were created for. user_id defaults to the
+// key's user, "express" sends with express priority and "ref" is echoed in the
+// Result.
+type MQTT struct {
+	cluster *db.Cluster
+	api     http.Handler
+	// path is where POST /sms is served, e.g. "/v1/sms"
+	path string
+}
+
+func NewMQTT(cluster *db.Cluster, api http.Handler, path string) *MQTT {
+	return &MQTT{cluster: cluster, api: api, path: path}
+}
+
+func (m *MQTT) Connect(ctx context.Context, c *mqtt.Client, username, password string) byte {
+	if username != "" && username != c.ID {
+		return mqtt.RefusedCredentials
+	}
+	device, err := sqlc.New(m.cluster.Reader()).GetMqttDevice(ctx, c.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return mqtt.RefusedCredentials
+		}
+		logrus.Errorf("failed to look up mqtt device %s: %s", c.ID, err)
+		return mqtt.RefusedUnavailable
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashKey(password)), []byte(device.SecretHash)) != 1 {
+		return mqtt.RefusedCredentials
+	}
+	if device.Suspended {
+		return mqtt.RefusedNotAuthorized
+	}
+	c.Session = device
+	return mqtt.Accepted
+}
+
+// Subscribe lets devices subscribe to their own results only.
+func (m *MQTT) Subscribe(c *mqtt.Client, filter string) bool {
+	return filter == ResultTopic+c.ID
+}
+
+func (m *MQTT) Publish(ctx context.Context, c *mqtt.Client, topic string, payload []byte) {
+	device := c.Session.(sqlc.GetMqttDeviceRow)
+	var req map[string]any
+	if err := json.Unmarshal(payload, &req); err != nil {
+		m.reply(c, "", http.StatusBadRequest, err)
+		metrics.MqttPublishes.WithLabelValues("refused").Inc()
+		return
+	}
+	ref, _ := req["ref"].(string)
+	key, ok := strings.CutPrefix(topic, SendTopic)
+	if !ok || key == "" {
+		m.reply(c, ref, http.StatusNotFound, ErrTopic)
+		metrics.MqttPublishes.WithLabelValues("refused").Inc()
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashKey(key)), []byte(device.KeyHash)) != 1 {
+		m.reply(c, ref, http.StatusForbidden, ErrDeviceKey)
+		metrics.MqttPublishes.WithLabelValues("forbidden").Inc()
+		return
+	}
+
+	q := sqlc.New(m.cluster.Writer())
+	_, err := q.TakeMqttDeviceQuota(ctx, device.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			m.reply(c, ref, http.StatusTooManyRequests, ErrDeviceQuota)
+			metrics.MqttPublishes.WithLabelValues("quota").Inc()
+			return
+		}
+		m.reply(c, ref, http.StatusInternalServerError, err)
+		return
+	}
+
+	path := m.path
+	if express, _ := req["express"].(bool); express {
+		path += "?express=true"
+	}
+	delete(req, "ref")
+	delete(req, "express")
+	if _, ok := req["user_id"]; !ok {
+		req["user_id"] = device.UserID
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		m.reply(c, ref, http.StatusInternalServerError, err)
+		return
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		m.reply(c, ref, http.StatusInternalServerError, err)
+		return
+	}
+	r.Header.Set("Authorization", "Bearer "+key)
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = c.RemoteAddr.String()
+	w := newResponse()
+	m.api.ServeHTTP(w, r)
+
+	if w.code < 200 || w.code > 299 {
+		// refused sends don't count against the quota
+		err = q.ReleaseMqttDeviceQuota(ctx, device.ID)
+		if err != nil {
+			logrus.Errorf("failed to release the quota of mqtt device %s: %s", c.ID, err)
+		}
+		metrics.MqttPublishes.WithLabelValues("refused").Inc()
+	} else {
+		metrics.MqttPublishes.WithLabelValues("accepted").Inc()
+	}
+	m.publish(c, Result{Ref: ref, Status: w.code, Response: w.body.Bytes()})
+}
+
+// reply publishes a refusal of the ingest itself, shaped like the api's errors.
+func (m *MQTT) reply(c *mqtt.Client, ref string, status int, err error) {
+	body, _ := json.Marshal(map[string]any{"status": status, "errors": []string{err.Error()}})
+	m.publish(c, Result{Ref: ref, Status: status, Response: body})
+}
+
+func (m *MQTT) publish(c *mqtt.Client, res Result) {
+	if !json.Valid(res.Response) {
+		res.Response = json.RawMessage("null")
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	err = c.Publish(ResultTopic+c.ID, data)
+	if err != nil {
+		logrus.Debugf("failed to publish a result to mqtt device %s: %s", c.ID, err)
+	}
+}
+
+// response records what the api answers.
+type response struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newResponse() *response {
+	return &response{header: http.Header{}, code: http.StatusOK}
+}
+
+func (r *response) Header() http.Header         { return r.header }
+func (r *response) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *response) WriteHeader(code int)        { r.code = code }
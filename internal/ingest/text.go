@@ -0,0 +1,159 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrNoText = errors.New("the mail has no text")
+
+var (
+	htmlSkip  = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	htmlBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	htmlTag   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Text is the text of a mail as an sms: its subject unless withSubject is off,
+// then its body on a line of its own. the body is the plain text part, or the
+// html one without tags, without quoted replies and signature and with its
+// whitespace collapsed. the text is cut to max characters.
+func Text(data []byte, withSubject bool, max int) (string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	var subject string
+	if withSubject {
+		subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil {
+			return "", err
+		}
+		subject = strings.Join(strings.Fields(subject), " ")
+	}
+	body, err := textOf(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil && !errors.Is(err, ErrNoText) {
+		return "", err
+	}
+	body = strings.Join(strings.Fields(stripReplies(body)), " ")
+	text := strings.TrimSpace(subject + "\n" + body)
+	if text == "" {
+		return "", ErrNoText
+	}
+	if max > 0 && utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max])
+	}
+	return text, nil
+}
+
+// textOf returns the text of a part, preferring plain text over html in
+// multipart ones.
+func textOf(h textproto.MIMEHeader, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// parts without a content type are plain us-ascii text
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(r, params["boundary"])
+		var alternative string
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			if strings.HasPrefix(p.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+			text, err := textOf(p.Header, p)
+			if errors.Is(err, ErrNoText) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			if t, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); t == "text/html" {
+				if alternative == "" {
+					alternative = text
+				}
+				continue
+			}
+			return text, nil
+		}
+		if alternative == "" {
+			return "", ErrNoText
+		}
+		return alternative, nil
+	case mediaType == "text/plain" || mediaType == "text/html":
+		text, err := decode(h.Get("Content-Transfer-Encoding"), params["charset"], r)
+		if err != nil {
+			return "", err
+		}
+		if mediaType == "text/html" {
+			text = htmlSkip.ReplaceAllString(text, "")
+			text = htmlBreak.ReplaceAllString(text, "\n")
+			text = html.UnescapeString(htmlTag.ReplaceAllString(text, ""))
+		}
+		return text, nil
+	default:
+		return "", ErrNoText
+	}
+}
+
+func decode(encoding, charset string, r io.Reader) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return strings.ToValidUTF8(string(b), "�"), nil
+	case "iso-8859-1", "latin1", "windows-1252":
+		// latin-1 bytes are the code points of their characters; windows-1252
+		// only differs in punctuation mostly
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("unsupported charset %q", charset)
+	}
+}
+
+// stripReplies drops quoted lines and everything after the signature delimiter.
+func stripReplies(text string) string {
+	var b strings.Builder
+	for line := range strings.Lines(text) {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "-- " || trimmed == "--" {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
@@ -0,0 +1,240 @@
+package jobs
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/status"
+)
+
+// formats of import files
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// importColumns are the columns every csv import has to have. delivered_at, cost,
+// priority and category are optional.
+var importColumns = []string{"external_id", "from", "to", "message", "status"}
+
+// ImportParams describes the upload of an import job.
+type ImportParams struct {
+	UserID int32  `json:"user_id"`
+	Format string `json:"format"`
+	// Size of the upload in bytes, progress is reported by how much of it was read
+	Size int64 `json:"size"`
+}
+
+// ImportKey is where the upload of import job id is stored until it ran.
+func ImportKey(id int32) string {
+	return fmt.Sprintf("imports/import-%d", id)
+}
+
+// ImportErrorsKey is where the rejected records of import job id are stored, as the job's result.
+func ImportErrorsKey(id int32) string {
+	return fmt.Sprintf("imports/import-%d-errors.csv", id)
+}
+
+// ImportRecord is one historical sms of an import file.
+type ImportRecord struct {
+	// ExternalID identifies the message in the system it is imported from. records
+	// whose id was imported for the user before are skipped.
+	ExternalID string `json:"external_id"`
+	// From is one of the user's numbers
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Message     string    `json:"message"`
+	Status      string    `json:"status"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Cost        float64   `json:"cost"`
+	Priority    string    `json:"priority"`
+	Category    string    `json:"category"`
+}
+
+// Validate checks r and fills in the defaults. only final statuses can be imported,
+// nothing would ever move a message out of the others.
+func (r *ImportRecord) Validate() error {
+	switch {
+	case r.ExternalID == "" || len(r.ExternalID) > 64:
+		return errors.New("external_id must be 1 to 64 characters")
+	case r.From == "":
+		return errors.New("from is required")
+	case phone.Normalize(r.To) == "":
+		return errors.New("to is not a phone number")
+	case r.Message == "" || utf8.RuneCountInString(r.Message) > 255:
+		return errors.New("message must be 1 to 255 characters")
+	case r.Cost < 0:
+		return errors.New("cost must not be negative")
+	}
+	st, err := status.Parse(r.Status)
+	if err != nil {
+		return err
+	}
+	if !st.Final() {
+		return fmt.Errorf("status %s is not final", st)
+	}
+	if r.Priority == "" {
+		r.Priority = "normal"
+	}
+	if r.Priority != "normal" && r.Priority != "express" {
+		return errors.New("priority must be normal or express")
+	}
+	if r.Category == "" {
+		r.Category = "transactional"
+	}
+	if r.Category != "transactional" && r.Category != "marketing" {
+		return errors.New("category must be transactional or marketing")
+	}
+	if r.DeliveredAt.IsZero() {
+		return errors.New("delivered_at is required")
+	}
+	return nil
+}
+
+// RecordError is a record of an import file that can't be imported. the records
+// after it can still be read.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReader reads the records of an import file.
+type ImportReader struct {
+	csv     *csv.Reader
+	columns map[string]int
+	lines   *bufio.Scanner
+	line    int
+}
+
+// NewImportReader reads r in format. csv files start with a header naming the
+// columns, in any order.
+func NewImportReader(r io.Reader, format string) (*ImportReader, error) {
+	switch format {
+	case FormatJSONL:
+		lines := bufio.NewScanner(r)
+		lines.Buffer(make([]byte, 64*1024), 1<<20)
+		return &ImportReader{lines: lines}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.TrimSpace(name)] = i
+		}
+		for _, name := range importColumns {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("csv header has no %s column", name)
+			}
+		}
+		return &ImportReader{csv: cr, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// Next returns the next valid record. invalid ones are returned as *RecordError,
+// io.EOF ends the file and any other error is fatal.
+func (r *ImportReader) Next() (ImportRecord, error) {
+	var rec ImportRecord
+	var err error
+	if r.csv != nil {
+		err = r.nextCSV(&rec)
+	} else {
+		err = r.nextJSON(&rec)
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = rec.Validate()
+	if err != nil {
+		return rec, &RecordError{Line: r.line, Err: err}
+	}
+	return rec, nil
+}
+
+// Line is the line of the record Next returned last.
+func (r *ImportReader) Line() int {
+	return r.line
+}
+
+func (r *ImportReader) nextJSON(rec *ImportRecord) error {
+	for r.lines.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.lines.Text())
+		if line == "" {
+			continue
+		}
+		err := json.Unmarshal([]byte(line), rec)
+		if err != nil {
+			return &RecordError{Line: r.line, Err: err}
+		}
+		return nil
+	}
+	err := r.lines.Err()
+	if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (r *ImportReader) nextCSV(rec *ImportRecord) error {
+	fields, err := r.csv.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		r.line = parseErr.Line
+		return &RecordError{Line: parseErr.Line, Err: parseErr.Err}
+	}
+	if err != nil {
+		return err
+	}
+	r.line, _ = r.csv.FieldPos(0)
+	raw := func(name string) string {
+		i, ok := r.columns[name]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return fields[i]
+	}
+	field := func(name string) string {
+		return strings.TrimSpace(raw(name))
+	}
+	rec.ExternalID = field("external_id")
+	rec.From = field("from")
+	rec.To = field("to")
+	rec.Message = raw("message")
+	rec.Status = field("status")
+	rec.Priority = field("priority")
+	rec.Category = field("category")
+	if s := field("delivered_at"); s != "" {
+		rec.DeliveredAt, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return &RecordError{Line: r.line, Err: errors.New("delivered_at is not an RFC 3339 time")}
+		}
+	}
+	if s := field("cost"); s != "" {
+		rec.Cost, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return &RecordError{Line: r.line, Err: errors.New("cost is not a number")}
+		}
+	}
+	return nil
+}
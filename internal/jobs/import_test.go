@@ -0,0 +1,81 @@
+package jobs_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/jobs"
+)
+
+var _ = Describe("ImportReader", func() {
+	// readAll returns the valid records and the lines of the invalid ones
+	readAll := func(r *ImportReader) ([]ImportRecord, []int) {
+		var records []ImportRecord
+		var rejected []int
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				return records, rejected
+			}
+			var recErr *RecordError
+			if errors.As(err, &recErr) {
+				rejected = append(rejected, recErr.Line)
+				continue
+			}
+			Expect(err).NotTo(HaveOccurred())
+			records = append(records, rec)
+		}
+	}
+
+	It("should read csv columns in any order and reject invalid rows", func() {
+		r, err := NewImportReader(strings.NewReader(
+			"status,external_id,from,to,message,delivered_at,cost\n"+
+				"delivered,a1,+15550001,+15550100, hello ,2024-06-01T10:00:00Z,0.05\n"+
+				"pending,a2,+15550001,+15550100,hi,2024-06-01T10:00:00Z,0.05\n"+
+				"failed,a3,+15550001,+15550100,hi,yesterday,0.05\n"+
+				"expired,a4,+15550001,+15550100,hi,2024-06-01T11:00:00Z,\n",
+		), FormatCSV)
+		Expect(err).NotTo(HaveOccurred())
+
+		records, rejected := readAll(r)
+		Expect(rejected).To(Equal([]int{3, 4}))
+		Expect(records).To(HaveLen(2))
+		Expect(records[0]).To(Equal(ImportRecord{
+			ExternalID:  "a1",
+			From:        "+15550001",
+			To:          "+15550100",
+			Message:     " hello ",
+			Status:      "delivered",
+			DeliveredAt: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+			Cost:        0.05,
+			Priority:    "normal",
+			Category:    "transactional",
+		}))
+		Expect(records[1].ExternalID).To(Equal("a4"))
+	})
+
+	It("should refuse csv files without a required column", func() {
+		_, err := NewImportReader(strings.NewReader("external_id,to,message,status\n"), FormatCSV)
+		Expect(err).To(MatchError(ContainSubstring("from")))
+	})
+
+	It("should read jsonl records line by line", func() {
+		r, err := NewImportReader(strings.NewReader(
+			`{"external_id":"b1","from":"+15550001","to":"+15550100","message":"hi","status":"failed","delivered_at":"2024-06-01T10:00:00Z","priority":"express"}`+"\n"+
+				"\n"+
+				`{"external_id":"b2",`+"\n"+
+				`{"external_id":"b3","from":"+15550001","to":"+15550100","message":"hi","status":"delivered"}`+"\n",
+		), FormatJSONL)
+		Expect(err).NotTo(HaveOccurred())
+
+		records, rejected := readAll(r)
+		Expect(rejected).To(Equal([]int{3, 4}))
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Priority).To(Equal("express"))
+	})
+})
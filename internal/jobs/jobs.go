@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// job kinds
+const (
+	KindExport = string(ExportJob)
+	KindImport = string(ImportJob)
+)
+
+// job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Request is published on the jobs stream to hand a job to the workers.
+type Request struct {
+	ID int32 `json:"id"`
+}
+
+// Progress is published on the job's progress subject while it runs.
+type Progress struct {
+	ID       int32  `json:"id"`
+	Status   string `json:"status"`
+	Progress int32  `json:"progress"`
+	Rows     int64  `json:"rows"`
+}
+
+// ExportParams selects the messages of an export job.
+type ExportParams struct {
+	UserID int32     `json:"user_id"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Masked exports the destinations and bodies masked, for callers without
+	// the sms:read:full scope
+	Masked bool `json:"masked,omitempty"`
+}
+
+// RequestSubject is where jobs of kind are queued, ErrJobKind for kinds
+// the workers don't run.
+func RequestSubject(kind string) (string, error) {
+	return JobReq(JobKind(kind))
+}
+
+// ProgressSubject is where the progress of job id is published.
+func ProgressSubject(id int32) string {
+	return JobProgress(id)
+}
+
+// StreamConfig is the work queue jobs are handed to the workers through.
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        JOBS_CONSUMER_NAME,
+		Description: "work queue for long running jobs such as exports and imports",
+		Subjects:    JobSubjects(),
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
@@ -0,0 +1,32 @@
+package jobs_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/jobs"
+	"github.com/alireza-karampour/sms/internal/subjects"
+	. "github.com/alireza-karampour/sms/pkg/utils"
+)
+
+var _ = Describe("Jobs", func() {
+	It("should queue requests on subjects of the jobs stream", func() {
+		for _, kind := range []string{KindExport, KindImport} {
+			subject, err := RequestSubject(kind)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(StreamConfig().Subjects).To(ContainElement(subject))
+		}
+		Expect(RequestSubject(KindExport)).To(Equal("jobs.export.request"))
+		_, err := RequestSubject("backup")
+		Expect(err).To(MatchError(subjects.ErrJobKind))
+	})
+
+	It("should publish progress outside of the jobs stream", func() {
+		Expect(ProgressSubject(7)).To(Equal("jobs.progress.7"))
+		for _, subject := range StreamConfig().Subjects {
+			Expect(Subject(ProgressSubject(7)).Filter(strings.Split(subject, ".")...)).To(BeFalse())
+		}
+	})
+})
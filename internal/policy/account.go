@@ -0,0 +1,32 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// statuses of an account
+const (
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+	AccountClosed    = "closed"
+)
+
+var (
+	ErrAccountSuspended = errors.New("account is suspended")
+	ErrAccountClosed    = errors.New("account is closed")
+)
+
+// AccountError is why an account in status may not send, nil when it may.
+func AccountError(status string) error {
+	switch status {
+	case AccountActive:
+		return nil
+	case AccountSuspended:
+		return ErrAccountSuspended
+	case AccountClosed:
+		return ErrAccountClosed
+	default:
+		return fmt.Errorf("unknown account status %q", status)
+	}
+}
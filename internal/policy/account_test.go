@@ -0,0 +1,23 @@
+package policy_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+)
+
+var _ = Describe("AccountError", func() {
+	It("should let active accounts send", func() {
+		Expect(AccountError(AccountActive)).To(Succeed())
+	})
+
+	It("should refuse suspended and closed accounts", func() {
+		Expect(AccountError(AccountSuspended)).To(MatchError(ErrAccountSuspended))
+		Expect(AccountError(AccountClosed)).To(MatchError(ErrAccountClosed))
+	})
+
+	It("should refuse unknown statuses", func() {
+		Expect(AccountError("frozen")).To(MatchError(ContainSubstring("frozen")))
+	})
+})
@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+// kinds of blocking rules
+const (
+	// BlockCountry blocks a country calling code, e.g. 98
+	BlockCountry = "country"
+	// BlockPrefix blocks a number range, e.g. 1900 for premium-rate numbers in the US
+	BlockPrefix = "prefix"
+)
+
+var (
+	ErrDestinationBlocked = errors.New("destination is blocked by the account's rules")
+	ErrFraudRange         = errors.New("destination is in a blocked fraud range")
+)
+
+// ParseBlock validates a rule and returns its value as the digits numbers are matched against.
+func ParseBlock(kind, value string) (string, error) {
+	digits := phone.Normalize(value)
+	notDigit := func(r rune) bool { return r < '0' || r > '9' }
+	if digits == "" || strings.ContainsFunc(strings.TrimPrefix(strings.TrimSpace(value), "+"), notDigit) {
+		return "", fmt.Errorf("invalid %s %q, expected digits", kind, value)
+	}
+	switch kind {
+	case BlockCountry:
+		if len(digits) > 3 {
+			return "", fmt.Errorf("invalid country calling code %q", value)
+		}
+	case BlockPrefix:
+		if len(digits) > 15 {
+			return "", fmt.Errorf("prefix %q is longer than a number", value)
+		}
+	default:
+		return "", fmt.Errorf("unknown rule kind %q", kind)
+	}
+	return digits, nil
+}
+
+// Blocked returns the first rule blocking the number to. rules without a user are
+// the global deny-list.
+func Blocked(rules []sqlc.BlockedDestination, to string) (sqlc.BlockedDestination, bool) {
+	digits := phone.Normalize(to)
+	for _, r := range rules {
+		if strings.HasPrefix(digits, r.Value) {
+			return r, true
+		}
+	}
+	return sqlc.BlockedDestination{}, false
+}
+
+// BlockError is why rule refuses a destination.
+func BlockError(rule sqlc.BlockedDestination) error {
+	if !rule.UserID.Valid {
+		return ErrFraudRange
+	}
+	return ErrDestinationBlocked
+}
@@ -0,0 +1,49 @@
+package policy_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var _ = Describe("Blocklist", func() {
+	DescribeTable("ParseBlock",
+		func(kind, value, want string, valid bool) {
+			got, err := ParseBlock(kind, value)
+			if !valid {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(want))
+		},
+		Entry("country code", BlockCountry, "+98", "98", true),
+		Entry("country code with 00", BlockCountry, "0044", "44", true),
+		Entry("too long for a country code", BlockCountry, "4915", "", false),
+		Entry("premium-rate prefix", BlockPrefix, "+1900", "1900", true),
+		Entry("formatting characters", BlockPrefix, "+1 (900)", "", false),
+		Entry("empty", BlockPrefix, "+", "", false),
+		Entry("unknown kind", "operator", "98", "", false),
+	)
+
+	It("should match destinations by prefix and tell global rules apart", func() {
+		rules := []sqlc.BlockedDestination{
+			{ID: 1, Kind: BlockPrefix, Value: "1900"},
+			{ID: 2, UserID: pgtype.Int4{Int32: 7, Valid: true}, Kind: BlockCountry, Value: "98"},
+		}
+
+		rule, ok := Blocked(rules, "+1 900 555 0100")
+		Expect(ok).To(BeTrue())
+		Expect(BlockError(rule)).To(Equal(ErrFraudRange))
+
+		rule, ok = Blocked(rules, "00989121234567")
+		Expect(ok).To(BeTrue())
+		Expect(BlockError(rule)).To(Equal(ErrDestinationBlocked))
+
+		_, ok = Blocked(rules, "+15550100")
+		Expect(ok).To(BeFalse())
+	})
+})
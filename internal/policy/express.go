@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// what happens to an express sms while the express queue is backed up
+const (
+	// AdmissionOff accepts it as express anyway
+	AdmissionOff = "off"
+	// AdmissionReject refuses it, unless the request consents to a downgrade
+	AdmissionReject = "reject"
+	// AdmissionDowngrade queues it as a normal sms, the org consented once for all
+	AdmissionDowngrade = "downgrade"
+)
+
+var (
+	ErrAdmission       = errors.New("unknown express admission policy")
+	ErrExpressBackedUp = errors.New("the express queue is backed up, retry later or send with normal priority")
+	errAdmissionLimits = errors.New("express admission needs a backlog or a latency limit")
+	errNegativeLatency = errors.New("express admission latency can't be negative")
+)
+
+// ParseAdmission parses an express admission policy, "" is AdmissionOff.
+func ParseAdmission(s string) (string, error) {
+	switch s {
+	case AdmissionOff, AdmissionReject, AdmissionDowngrade:
+		return s, nil
+	case "":
+		return AdmissionOff, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s, %s or %s", ErrAdmission, s, AdmissionOff, AdmissionReject, AdmissionDowngrade)
+	}
+}
+
+// ExpressAdmission decides which express sms are accepted as express while the
+// express queue is backed up: more than Backlog messages wait in it, or the
+// oldest has waited longer than Latency. zero limits don't apply.
+type ExpressAdmission struct {
+	// Policy applies to the orgs without a policy of their own
+	Policy  string
+	Backlog uint64
+	Latency time.Duration
+}
+
+// Validate fails unless the policy is known and, when it isn't off, a limit is set.
+func (a ExpressAdmission) Validate() error {
+	policy, err := ParseAdmission(a.Policy)
+	if err != nil {
+		return err
+	}
+	if a.Latency < 0 {
+		return errNegativeLatency
+	}
+	if policy != AdmissionOff && a.Backlog == 0 && a.Latency == 0 {
+		return errAdmissionLimits
+	}
+	return nil
+}
+
+// ExpressAdmissionFromViper reads api.express.admission and fails unless it is valid.
+func ExpressAdmissionFromViper() (ExpressAdmission, error) {
+	a := ExpressAdmission{
+		Policy:  viper.GetString("api.express.admission.policy"),
+		Backlog: viper.GetUint64("api.express.admission.backlog"),
+		Latency: viper.GetDuration("api.express.admission.latency"),
+	}
+	err := a.Validate()
+	if err != nil {
+		return ExpressAdmission{}, fmt.Errorf("api.express.admission: %w", err)
+	}
+	return a, nil
+}
+
+// BackedUp reports whether the express queue, with backlog messages of which the
+// oldest waited latency, is past the limits.
+func (a ExpressAdmission) BackedUp(backlog uint64, latency time.Duration) bool {
+	return (a.Backlog > 0 && backlog > a.Backlog) || (a.Latency > 0 && latency > a.Latency)
+}
+
+// Admit decides on an express sms of an org with policy, its own or a.Policy when
+// empty, while the queue is backedUp. it reports whether the sms is downgraded to
+// normal priority, and ErrExpressBackedUp when it is refused. consent is set when
+// the request accepts a downgrade over a refusal.
+func (a ExpressAdmission) Admit(policy string, backedUp, consent bool) (bool, error) {
+	if policy == "" {
+		policy = a.Policy
+	}
+	if !backedUp {
+		return false, nil
+	}
+	switch policy {
+	case AdmissionReject:
+		if consent {
+			return true, nil
+		}
+		return false, ErrExpressBackedUp
+	case AdmissionDowngrade:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,53 @@
+package policy_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+)
+
+var _ = Describe("ExpressAdmission", func() {
+	a := ExpressAdmission{Policy: AdmissionReject, Backlog: 100, Latency: 10 * time.Second}
+
+	It("should count the queue as backed up past either limit", func() {
+		Expect(a.BackedUp(100, 10*time.Second)).To(BeFalse())
+		Expect(a.BackedUp(101, 0)).To(BeTrue())
+		Expect(a.BackedUp(0, 11*time.Second)).To(BeTrue())
+		Expect(ExpressAdmission{Backlog: 100}.BackedUp(0, time.Hour)).To(BeFalse())
+	})
+
+	It("should admit every express sms while the queue isn't backed up", func() {
+		downgraded, err := a.Admit("", false, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downgraded).To(BeFalse())
+	})
+
+	It("should refuse express sms unless the request consents to a downgrade", func() {
+		_, err := a.Admit("", true, false)
+		Expect(err).To(MatchError(ErrExpressBackedUp))
+
+		downgraded, err := a.Admit("", true, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downgraded).To(BeTrue())
+	})
+
+	It("should apply the policy of the org over the default", func() {
+		downgraded, err := a.Admit(AdmissionDowngrade, true, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downgraded).To(BeTrue())
+
+		downgraded, err = a.Admit(AdmissionOff, true, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(downgraded).To(BeFalse())
+	})
+
+	It("should refuse unknown policies and policies without a limit", func() {
+		Expect(ExpressAdmission{Policy: "drop", Backlog: 1}.Validate()).To(MatchError(ErrAdmission))
+		Expect(ExpressAdmission{Policy: AdmissionReject}.Validate()).To(HaveOccurred())
+		Expect(ExpressAdmission{}.Validate()).To(Succeed())
+		Expect(a.Validate()).To(Succeed())
+	})
+})
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+)
+
+// how a pool picks the number each sms is sent from
+const (
+	// PoolRoundRobin takes the numbers in turn
+	PoolRoundRobin = "round_robin"
+	// PoolLeastRecentlyUsed takes the number that sent nothing for the longest
+	PoolLeastRecentlyUsed = "lru"
+	// PoolSticky sends every recipient the sms from the same number
+	PoolSticky = "sticky"
+)
+
+var (
+	ErrPoolStrategy = errors.New("unknown pool strategy")
+	ErrPoolEmpty    = errors.New("the pool has no phone numbers")
+)
+
+// ParsePoolStrategy parses the strategy of a pool, "" is PoolRoundRobin.
+func ParsePoolStrategy(s string) (string, error) {
+	switch s {
+	case PoolRoundRobin, PoolLeastRecentlyUsed, PoolSticky:
+		return s, nil
+	case "":
+		return PoolRoundRobin, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s, %s or %s", ErrPoolStrategy, s, PoolRoundRobin, PoolLeastRecentlyUsed, PoolSticky)
+	}
+}
+
+// RoundRobinMember is the member of a round robin pool the sms number cursor,
+// counting from 1, is sent from.
+func RoundRobinMember(members []int32, cursor int64) (int32, error) {
+	if len(members) == 0 {
+		return 0, ErrPoolEmpty
+	}
+	n := (cursor - 1) % int64(len(members))
+	if n < 0 {
+		n += int64(len(members))
+	}
+	return members[n], nil
+}
+
+// StickyMember is the member of a sticky pool the sms to to are sent from. it
+// hashes the recipient with every member and takes the highest (rendezvous
+// hashing), so a number added to or removed from the pool only moves the
+// recipients it takes or leaves.
+func StickyMember(members []int32, to string) (int32, error) {
+	if len(members) == 0 {
+		return 0, ErrPoolEmpty
+	}
+	digits := phone.Normalize(to)
+	var best int32
+	var bestScore uint64
+	for i, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(strconv.Itoa(int(m))))
+		h.Write([]byte{0})
+		h.Write([]byte(digits))
+		score := mix(h.Sum64())
+		if i == 0 || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best, nil
+}
+
+// mix spreads the bits of the fnv hash of recipients a digit apart.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,60 @@
+package policy_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+)
+
+var _ = Describe("Pool", func() {
+	members := []int32{4, 7, 9}
+
+	It("should take the numbers in turn", func() {
+		var picked []int32
+		for cursor := int64(1); cursor <= 4; cursor++ {
+			m, err := RoundRobinMember(members, cursor)
+			Expect(err).NotTo(HaveOccurred())
+			picked = append(picked, m)
+		}
+		Expect(picked).To(Equal([]int32{4, 7, 9, 4}))
+	})
+
+	It("should keep every recipient on one number", func() {
+		counts := map[int32]int{}
+		for i := range 900 {
+			to := fmt.Sprintf("+98912%07d", i)
+			m, err := StickyMember(members, to)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(StickyMember(members, "0098912"+to[6:])).To(Equal(m))
+			counts[m]++
+		}
+		for _, m := range members {
+			Expect(counts[m]).To(BeNumerically("~", 300, 75))
+		}
+	})
+
+	It("should only move the recipients of a removed number", func() {
+		for i := range 100 {
+			to := fmt.Sprintf("+98912%07d", i)
+			before, _ := StickyMember(members, to)
+			after, _ := StickyMember([]int32{4, 7}, to)
+			if before != 9 {
+				Expect(after).To(Equal(before))
+			}
+		}
+	})
+
+	It("should refuse empty pools and unknown strategies", func() {
+		_, err := RoundRobinMember(nil, 1)
+		Expect(err).To(MatchError(ErrPoolEmpty))
+		_, err = StickyMember(nil, "+989121234567")
+		Expect(err).To(MatchError(ErrPoolEmpty))
+
+		_, err = ParsePoolStrategy("random")
+		Expect(err).To(MatchError(ErrPoolStrategy))
+		Expect(ParsePoolStrategy("")).To(Equal(PoolRoundRobin))
+	})
+})
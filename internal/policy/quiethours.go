@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	CategoryTransactional = "transactional"
+	CategoryMarketing     = "marketing"
+
+	QuietHoursReject = "reject"
+	QuietHoursDefer  = "defer"
+)
+
+var (
+	ErrQuietHours = errors.New("destination is in quiet hours")
+)
+
+// QuietHours returns the first rule of the given category that forbids sending to
+// the destination at now (evaluated in the destination's local time) and the
+// moment the rule stops applying.
+func QuietHours(rules []sqlc.QuietHour, category, to string, now time.Time) (sqlc.QuietHour, time.Time, bool) {
+	local := now.In(phone.Timezone(to))
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range rules {
+		if r.Category != category {
+			continue
+		}
+		start, end := minuteOfDay(r.StartTime), minuteOfDay(r.EndTime)
+		if !inWindow(minute, start, end) {
+			continue
+		}
+		until := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+		if !until.After(local) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return r, until, true
+	}
+	return sqlc.QuietHour{}, time.Time{}, false
+}
+
+func inWindow(minute, start, end int) bool {
+	if start == end {
+		return false
+	}
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// window wraps around midnight, e.g. 21:00-08:00
+	return minute >= start || minute < end
+}
+
+func minuteOfDay(t pgtype.Time) int {
+	return int(t.Microseconds / int64(time.Minute/time.Microsecond))
+}
+
+// ParseClock parses "HH:MM" into a pgtype.Time.
+func ParseClock(s string) (pgtype.Time, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return pgtype.Time{}, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return pgtype.Time{Microseconds: d.Microseconds(), Valid: true}, nil
+}
+
+// FormatClock renders a pgtype.Time as "HH:MM".
+func FormatClock(t pgtype.Time) string {
+	m := minuteOfDay(t)
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
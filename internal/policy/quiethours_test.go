@@ -0,0 +1,66 @@
+package policy_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+var _ = Describe("QuietHours", func() {
+	var rules []sqlc.QuietHour
+
+	BeforeEach(func() {
+		start, err := ParseClock("21:00")
+		Expect(err).NotTo(HaveOccurred())
+		end, err := ParseClock("08:00")
+		Expect(err).NotTo(HaveOccurred())
+		rules = []sqlc.QuietHour{{
+			ID:        1,
+			Category:  CategoryMarketing,
+			StartTime: start,
+			EndTime:   end,
+			Action:    QuietHoursDefer,
+		}}
+	})
+
+	It("should block marketing inside a window wrapping midnight", func() {
+		// 22:30 in Berlin (CET, UTC+1)
+		now := time.Date(2024, time.January, 10, 21, 30, 0, 0, time.UTC)
+		rule, until, ok := QuietHours(rules, CategoryMarketing, "+4915112345678", now)
+		Expect(ok).To(BeTrue())
+		Expect(rule.ID).To(Equal(int32(1)))
+		Expect(until.UTC()).To(Equal(time.Date(2024, time.January, 11, 7, 0, 0, 0, time.UTC)))
+	})
+
+	It("should block after midnight until the window ends the same day", func() {
+		// 03:00 in Berlin
+		now := time.Date(2024, time.January, 10, 2, 0, 0, 0, time.UTC)
+		_, until, ok := QuietHours(rules, CategoryMarketing, "+4915112345678", now)
+		Expect(ok).To(BeTrue())
+		Expect(until.UTC()).To(Equal(time.Date(2024, time.January, 10, 7, 0, 0, 0, time.UTC)))
+	})
+
+	It("should use the destination timezone", func() {
+		// 12:00 UTC is 15:30 in Tehran and 07:00 in New York
+		now := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC)
+		_, _, ok := QuietHours(rules, CategoryMarketing, "+989121234567", now)
+		Expect(ok).To(BeFalse())
+		_, _, ok = QuietHours(rules, CategoryMarketing, "+12125551234", now)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should not apply to other categories", func() {
+		now := time.Date(2024, time.January, 10, 21, 30, 0, 0, time.UTC)
+		_, _, ok := QuietHours(rules, CategoryTransactional, "+4915112345678", now)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should reject malformed clock values", func() {
+		_, err := ParseClock("25:00")
+		Expect(err).To(HaveOccurred())
+	})
+})
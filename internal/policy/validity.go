@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrValidityTooLong = errors.New("validity_period is too long")
+
+// Validity is when an sms accepted at now expires given its validity period in
+// seconds, never when the period is not positive. periods longer than max are
+// refused unless max is 0.
+func Validity(seconds int64, max time.Duration, now time.Time) (pgtype.Timestamp, error) {
+	if seconds <= 0 {
+		return pgtype.Timestamp{}, nil
+	}
+	// compared in seconds, the period as a duration may overflow
+	if max > 0 && seconds > int64(max/time.Second) {
+		return pgtype.Timestamp{}, fmt.Errorf("%w: at most %s", ErrValidityTooLong, max)
+	}
+	return pgtype.Timestamp{Time: now.UTC().Add(time.Duration(seconds) * time.Second), Valid: true}, nil
+}
+
+// Expired reports whether a validity period ending at expiresAt ends before at.
+func Expired(expiresAt pgtype.Timestamp, at time.Time) bool {
+	return expiresAt.Valid && at.After(expiresAt.Time)
+}
@@ -0,0 +1,63 @@
+package policy_test
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/policy"
+)
+
+var _ = Describe("Validity", func() {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	It("should expire the sms after its validity period", func() {
+		Expect(Validity(90, 72*time.Hour, now)).To(Equal(pgtype.Timestamp{Time: now.Add(90 * time.Second), Valid: true}))
+	})
+
+	It("should never expire sms without a validity period", func() {
+		Expect(Validity(0, 72*time.Hour, now)).To(Equal(pgtype.Timestamp{}))
+		Expect(Validity(-5, 72*time.Hour, now)).To(Equal(pgtype.Timestamp{}))
+	})
+
+	It("should take periods up to the maximum", func() {
+		Expect(Validity(72*3600, 72*time.Hour, now)).To(Equal(pgtype.Timestamp{Time: now.Add(72 * time.Hour), Valid: true}))
+	})
+
+	It("should refuse periods beyond the maximum", func() {
+		_, err := Validity(72*3600+1, 72*time.Hour, now)
+		Expect(err).To(MatchError(ErrValidityTooLong))
+		_, err = Validity(1<<62, 72*time.Hour, now)
+		Expect(err).To(MatchError(ErrValidityTooLong))
+	})
+
+	It("should take any period without a maximum", func() {
+		Expect(Validity(30*24*3600, 0, now)).To(Equal(pgtype.Timestamp{Time: now.Add(30 * 24 * time.Hour), Valid: true}))
+	})
+
+	It("should store the expiry in UTC", func() {
+		local := now.In(time.FixedZone("IRST", 3*3600+1800))
+		expiresAt, err := Validity(60, 0, local)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expiresAt.Time.Location()).To(Equal(time.UTC))
+	})
+})
+
+var _ = Describe("Expired", func() {
+	expiresAt := pgtype.Timestamp{Time: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), Valid: true}
+
+	It("should expire sms after their expiry", func() {
+		Expect(Expired(expiresAt, expiresAt.Time.Add(time.Second))).To(BeTrue())
+	})
+
+	It("should not expire sms before or at their expiry", func() {
+		Expect(Expired(expiresAt, expiresAt.Time.Add(-time.Second))).To(BeFalse())
+		Expect(Expired(expiresAt, expiresAt.Time)).To(BeFalse())
+	})
+
+	It("should never expire sms without a validity period", func() {
+		Expect(Expired(pgtype.Timestamp{}, time.Now().AddDate(10, 0, 0))).To(BeFalse())
+	})
+})
@@ -0,0 +1,62 @@
+// Package providers keeps the carrier accounts users bring, their credentials
+// sealed with a secrets.Envelope.
+package providers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+// aad binds sealed credentials to the account they were sealed for, so they can't
+// be copied to another row.
+func aad(userID int32, name string) []byte {
+	return fmt.Appendf(nil, "provider_accounts:%d:%s", userID, name)
+}
+
+// Seal encrypts the credentials of the account name of userID.
+func Seal(ctx context.Context, env *secrets.Envelope, userID int32, name string, credentials []byte) (secrets.Sealed, error) {
+	return env.Seal(ctx, credentials, aad(userID, name))
+}
+
+// Open decrypts the credentials of account.
+func Open(ctx context.Context, env *secrets.Envelope, account sqlc.ProviderAccount) ([]byte, error) {
+	return env.Open(ctx, sealed(account), aad(account.UserID, account.Name))
+}
+
+// Rewrap wraps the data keys of the accounts that weren't with the current master
+// key of env with it, and returns how many it rewrapped. the credentials stay as
+// they are, so the master keys they were wrapped with can be retired afterwards.
+func Rewrap(ctx context.Context, q *sqlc.Queries, env *secrets.Envelope) (int, error) {
+	accounts, err := q.ListAllProviderAccounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rewrapped := 0
+	for _, account := range accounts {
+		if env.Current(sealed(account)) {
+			continue
+		}
+		s, err := env.Rewrap(ctx, sealed(account))
+		if err != nil {
+			return rewrapped, fmt.Errorf("account %d: %w", account.ID, err)
+		}
+		// an account updated meanwhile was sealed with the current key anyway
+		n, err := q.RewrapProviderAccount(ctx, sqlc.RewrapProviderAccountParams{
+			DataKey:  s.DataKey,
+			ID:       account.ID,
+			Previous: account.DataKey,
+		})
+		if err != nil {
+			return rewrapped, err
+		}
+		rewrapped += int(n)
+	}
+	return rewrapped, nil
+}
+
+func sealed(account sqlc.ProviderAccount) secrets.Sealed {
+	return secrets.Sealed{Ciphertext: account.Credentials, DataKey: account.DataKey}
+}
@@ -0,0 +1,172 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrAccountNotFound = errors.New("provider account not found")
+
+// Provider is the name the sms submitted through account are recorded with, e.g.
+// "vonage/3", so their reports are told apart from the platform's drivers.
+func Provider(account sqlc.ProviderAccount) string {
+	return fmt.Sprintf("%s/%d", account.Driver, account.ID)
+}
+
+// Routes routes the sms of users through the provider accounts they brought. the
+// routing of a user is cached for ttl, the driver of an account until its
+// credentials change.
+type Routes struct {
+	ctx context.Context
+	q   *sqlc.Queries
+	env *secrets.Envelope
+	ttl time.Duration
+	// report returns the handler of the delivery reports of the drivers
+	// receiving their own, by provider name
+	report func(provider string) func(context.Context, carrier.Report) error
+
+	mu      sync.Mutex
+	users   map[int32]userRoutes
+	drivers map[int32]*driver
+}
+
+type userRoutes struct {
+	routes   []sqlc.ListProviderRoutesRow
+	loadedAt time.Time
+}
+
+type driver struct {
+	// dataKey is different for every sealing of the credentials
+	dataKey  string
+	provider string
+	sender   carrier.Sender
+	cancel   context.CancelFunc
+}
+
+// NewRoutes opens the credentials of accounts with env. the drivers keeping
+// connections to their carrier keep them until ctx is done.
+func NewRoutes(ctx context.Context, q *sqlc.Queries, env *secrets.Envelope, ttl time.Duration) *Routes {
+	return &Routes{
+		ctx:     ctx,
+		q:       q,
+		env:     env,
+		ttl:     ttl,
+		users:   make(map[int32]userRoutes),
+		drivers: make(map[int32]*driver),
+	}
+}
+
+// OnReport passes the reports the drivers of accounts receive themselves to the
+// handler report returns for their provider name, see carrier.Reporter.
+func (r *Routes) OnReport(report func(provider string) func(context.Context, carrier.Report) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report = report
+}
+
+// Route returns the account the sms of userID to to is sent through, 0 when it
+// goes through the platform's drivers.
+func (r *Routes) Route(ctx context.Context, userID int32, to string) (int32, error) {
+	r.mu.Lock()
+	cached, ok := r.users[userID]
+	r.mu.Unlock()
+	if !ok || time.Since(cached.loadedAt) > r.ttl {
+		routes, err := r.q.ListProviderRoutes(ctx, userID)
+		if err != nil {
+			return 0, err
+		}
+		cached = userRoutes{routes: routes, loadedAt: time.Now()}
+		r.mu.Lock()
+		r.users[userID] = cached
+		r.mu.Unlock()
+	}
+	return Match(cached.routes, to), nil
+}
+
+// Match picks the account of the longest prefix matching to, or the default
+// account when none does.
+func Match(routes []sqlc.ListProviderRoutesRow, to string) int32 {
+	digits := phone.Normalize(to)
+	var id, fallback int32
+	longest := 0
+	for _, route := range routes {
+		if route.IsDefault {
+			fallback = route.ID
+		}
+		for _, prefix := range route.Prefixes {
+			if len(prefix) > longest && strings.HasPrefix(digits, prefix) {
+				id, longest = route.ID, len(prefix)
+			}
+		}
+	}
+	if id == 0 {
+		return fallback
+	}
+	return id
+}
+
+// Driver returns the provider name and driver of account id, built from its
+// credentials. it fails with ErrAccountNotFound once the account is deleted.
+func (r *Routes) Driver(ctx context.Context, id int32) (string, carrier.Sender, error) {
+	if r == nil {
+		return "", nil, secrets.ErrNoMasterKey
+	}
+	account, err := r.q.GetProviderAccount(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		r.drop(id)
+		return "", nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.drivers[id]; ok && d.dataKey == account.DataKey {
+		return d.provider, d.sender, nil
+	}
+	credentials, err := Open(ctx, r.env, account)
+	if err != nil {
+		return "", nil, err
+	}
+	dctx, cancel := context.WithCancel(r.ctx)
+	sender, err := carrier.NewAccount(dctx, account.Driver, credentials)
+	if err != nil {
+		cancel()
+		return "", nil, err
+	}
+	d := &driver{
+		dataKey:  account.DataKey,
+		provider: Provider(account),
+		sender:   sender,
+		cancel:   cancel,
+	}
+	if rep, ok := sender.(carrier.Reporter); ok && r.report != nil {
+		rep.OnReport(r.report(d.provider))
+	}
+	if old, ok := r.drivers[id]; ok {
+		old.cancel()
+	}
+	r.drivers[id] = d
+	return d.provider, d.sender, nil
+}
+
+// drop stops the driver of a deleted account.
+func (r *Routes) drop(id int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.drivers[id]; ok {
+		d.cancel()
+		delete(r.drivers, id)
+	}
+}
@@ -0,0 +1,32 @@
+package providers_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/providers"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+var _ = Describe("Routes", func() {
+	routes := []sqlc.ListProviderRoutesRow{
+		{ID: 1, IsDefault: true},
+		{ID: 2, Prefixes: []string{"98", "4477"}},
+		{ID: 3, Prefixes: []string{"989"}},
+	}
+
+	It("should route through the account of the longest matching prefix", func() {
+		Expect(Match(routes, "+98 912 000 0000")).To(Equal(int32(3)))
+		Expect(Match(routes, "00982100000000")).To(Equal(int32(2)))
+		Expect(Match(routes, "+447700900000")).To(Equal(int32(2)))
+	})
+
+	It("should route the other destinations through the default account", func() {
+		Expect(Match(routes, "+14155550100")).To(Equal(int32(1)))
+	})
+
+	It("should leave the sms to the platform without a default account", func() {
+		Expect(Match(routes[1:], "+14155550100")).To(BeZero())
+		Expect(Match(nil, "+989120000000")).To(BeZero())
+	})
+})
@@ -0,0 +1,100 @@
+// Package slo measures how long messages take from acceptance to their delivery
+// report, stage by stage, and how fast slow deliveries burn the error budget of the
+// latency objective of their priority.
+package slo
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// stages of the latency of a message, see Lifecycle.Stages
+const (
+	// StagePickup is from the api accepting the message to a worker storing it
+	StagePickup = "pickup"
+	// StageSubmit is from storing the message to submitting it to the provider
+	StageSubmit = "submit"
+	// StageDlr is from the submit to the provider reporting the delivery
+	StageDlr = "dlr"
+	// StageEndToEnd is from the api accepting the message to its delivery
+	StageEndToEnd = "end_to_end"
+)
+
+// defaultTarget replaces targets that leave no error budget or make no sense
+const defaultTarget = 0.99
+
+// Objective is the latency objective of a priority.
+type Objective struct {
+	// Latency is how long delivering a message may take end to end
+	Latency time.Duration
+	// Target is the share of delivered messages that must make it within Latency
+	Target float64
+}
+
+// ObjectivesFromViper reads slo.<priority>.latency and slo.<priority>.target of
+// every priority. targets outside (0, 1) fall back to 0.99.
+func ObjectivesFromViper(priorities ...string) map[string]Objective {
+	objectives := make(map[string]Objective, len(priorities))
+	for _, p := range priorities {
+		o := Objective{
+			Latency: viper.GetDuration("slo." + p + ".latency"),
+			Target:  viper.GetFloat64("slo." + p + ".target"),
+		}
+		if o.Target <= 0 || o.Target >= 1 {
+			o.Target = defaultTarget
+		}
+		objectives[p] = o
+	}
+	return objectives
+}
+
+// WindowsFromViper reads slo.windows, the windows burn rates are computed over.
+// entries that aren't durations are dropped.
+func WindowsFromViper() []time.Duration {
+	var windows []time.Duration
+	for _, s := range viper.GetStringSlice("slo.windows") {
+		d, err := time.ParseDuration(s)
+		if err == nil && d > 0 {
+			windows = append(windows, d)
+		}
+	}
+	return windows
+}
+
+// BurnRate is the share of slow messages over the error budget 1 - Target: at 1
+// the budget lasts exactly the SLO period, at 10 it is gone in a tenth of it.
+func (o Objective) BurnRate(delivered, slow int64) float64 {
+	if delivered == 0 {
+		return 0
+	}
+	return float64(slow) / float64(delivered) / (1 - o.Target)
+}
+
+// Lifecycle holds when a message went through each stage. stages it didn't reach, or
+// that weren't recorded, are zero.
+type Lifecycle struct {
+	Created   time.Time
+	Stored    time.Time
+	Submitted time.Time
+	Delivered time.Time
+}
+
+// Stages returns the latency of every stage both ends of which are known.
+func (l Lifecycle) Stages() map[string]time.Duration {
+	stages := make(map[string]time.Duration)
+	for _, s := range []struct {
+		name     string
+		from, to time.Time
+	}{
+		{StagePickup, l.Created, l.Stored},
+		{StageSubmit, l.Stored, l.Submitted},
+		{StageDlr, l.Submitted, l.Delivered},
+		{StageEndToEnd, l.Created, l.Delivered},
+	} {
+		if !s.from.IsZero() && !s.to.IsZero() {
+			stages[s.name] = s.to.Sub(s.from)
+		}
+	}
+	return stages
+}
@@ -0,0 +1,13 @@
+package slo_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSlo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SLO Suite")
+}
@@ -0,0 +1,51 @@
+package slo_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	. "github.com/alireza-karampour/sms/internal/slo"
+)
+
+var _ = Describe("Objective", func() {
+	It("should burn the budget in proportion to slow messages", func() {
+		o := Objective{Latency: time.Minute, Target: 0.99}
+		Expect(o.BurnRate(1000, 10)).To(BeNumerically("~", 1, 1e-9))
+		Expect(o.BurnRate(1000, 100)).To(BeNumerically("~", 10, 1e-9))
+		Expect(o.BurnRate(0, 0)).To(BeZero())
+	})
+
+	It("should read the objectives of each priority", func() {
+		viper.Set("slo.express.latency", "30s")
+		viper.Set("slo.express.target", 0.999)
+		viper.Set("slo.normal.latency", "5m")
+		viper.Set("slo.normal.target", 1)
+
+		objectives := ObjectivesFromViper("express", "normal")
+		Expect(objectives["express"]).To(Equal(Objective{Latency: 30 * time.Second, Target: 0.999}))
+		Expect(objectives["normal"]).To(Equal(Objective{Latency: 5 * time.Minute, Target: 0.99}))
+	})
+
+	It("should drop windows that aren't durations", func() {
+		viper.Set("slo.windows", []string{"1h", "soon", "-5m", "24h"})
+		Expect(WindowsFromViper()).To(Equal([]time.Duration{time.Hour, 24 * time.Hour}))
+	})
+})
+
+var _ = Describe("Lifecycle", func() {
+	It("should measure the stages with both ends known", func() {
+		created := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+		l := Lifecycle{
+			Created:   created,
+			Stored:    created.Add(2 * time.Second),
+			Delivered: created.Add(time.Minute),
+		}
+		Expect(l.Stages()).To(Equal(map[string]time.Duration{
+			StagePickup:   2 * time.Second,
+			StageEndToEnd: time.Minute,
+		}))
+	})
+})
@@ -0,0 +1,61 @@
+package streams
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+const (
+	// AuditRetentionInterest keeps audit copies until every consumer of the audit
+	// stream acked them, or nats.audit.maxage passed
+	AuditRetentionInterest = "interest"
+	// AuditRetentionLimits keeps audit copies for nats.audit.maxage whether or not
+	// they were consumed
+	AuditRetentionLimits = "limits"
+)
+
+var ErrAuditRetention = errors.New("unknown audit retention")
+
+// ParseAuditRetention parses nats.audit.retention, "" is AuditRetentionInterest.
+func ParseAuditRetention(s string) (jetstream.RetentionPolicy, error) {
+	switch s {
+	case AuditRetentionInterest, "":
+		return jetstream.InterestPolicy, nil
+	case AuditRetentionLimits:
+		return jetstream.LimitsPolicy, nil
+	default:
+		return 0, fmt.Errorf("%w %q, want %s or %s", ErrAuditRetention, s, AuditRetentionInterest, AuditRetentionLimits)
+	}
+}
+
+// AuditStream copies every message of the sms work queues, and of SubmitStream
+// when submit, so an auditable copy remains after the workers consumed them.
+// its sources read the work queues without consuming them.
+func AuditStream(submit bool) (jetstream.StreamConfig, error) {
+	retention, err := ParseAuditRetention(viper.GetString("nats.audit.retention"))
+	if err != nil {
+		return jetstream.StreamConfig{}, err
+	}
+	maxAge := viper.GetDuration("nats.audit.maxage")
+	if maxAge < 0 {
+		return jetstream.StreamConfig{}, errors.New("nats.audit.maxage can't be negative")
+	}
+	sources := []*jetstream.StreamSource{
+		{Name: NORMAL_SMS_CONSUMER_NAME},
+		{Name: EXPRESS_SMS_CONSUMER_NAME},
+	}
+	if submit {
+		sources = append(sources, &jetstream.StreamSource{Name: SUBMIT_CONSUMER_NAME})
+	}
+	return jetstream.StreamConfig{
+		Name:        AUDIT_STREAM_NAME,
+		Description: "copies of the sms work queues, for audits",
+		Sources:     sources,
+		Retention:   retention,
+		Storage:     jetstream.FileStorage,
+		MaxAge:      maxAge,
+	}, nil
+}
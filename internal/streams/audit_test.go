@@ -0,0 +1,60 @@
+package streams_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("AuditStream", func() {
+	AfterEach(func() {
+		viper.Set("nats.audit.retention", nil)
+		viper.Set("nats.audit.maxage", nil)
+	})
+
+	sources := func(config jetstream.StreamConfig) []string {
+		var names []string
+		for _, s := range config.Sources {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+
+	It("should source the work queues and keep their copies for maxage", func() {
+		viper.Set("nats.audit.maxage", "720h")
+		config, err := streams.AuditStream(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Name).To(Equal(streams.AUDIT_STREAM_NAME))
+		Expect(config.Subjects).To(BeEmpty())
+		Expect(config.Retention).To(Equal(jetstream.InterestPolicy))
+		Expect(config.MaxAge).To(Equal(720 * time.Hour))
+		Expect(sources(config)).To(Equal([]string{streams.NORMAL_SMS_CONSUMER_NAME, streams.EXPRESS_SMS_CONSUMER_NAME}))
+
+		config, err = streams.AuditStream(true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources(config)).To(ContainElement(streams.SUBMIT_CONSUMER_NAME))
+	})
+
+	It("should keep copies whether or not they were consumed with limits retention", func() {
+		viper.Set("nats.audit.retention", "limits")
+		config, err := streams.AuditStream(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Retention).To(Equal(jetstream.LimitsPolicy))
+	})
+
+	It("should refuse an unknown retention or a negative maxage", func() {
+		viper.Set("nats.audit.retention", "workqueue")
+		_, err := streams.AuditStream(false)
+		Expect(err).To(MatchError(streams.ErrAuditRetention))
+
+		viper.Set("nats.audit.retention", nil)
+		viper.Set("nats.audit.maxage", "-1h")
+		_, err = streams.AuditStream(false)
+		Expect(err).To(HaveOccurred())
+	})
+})
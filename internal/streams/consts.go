@@ -1,6 +1,25 @@
 package streams
 
 const (
-	EXPRESS_SMS_CONSUMER_NAME string = "SmsExpress"
-	NORMAL_SMS_CONSUMER_NAME  string = "Sms"
+	EXPRESS_SMS_CONSUMER_NAME  string = "SmsExpress"
+	NORMAL_SMS_CONSUMER_NAME   string = "Sms"
+	JOBS_CONSUMER_NAME         string = "Jobs"
+	IMPORT_CONSUMER_NAME       string = "JobsImport"
+	EMAIL_CONSUMER_NAME        string = "Email"
+	PUSH_CONSUMER_NAME         string = "Push"
+	VOICE_CONSUMER_NAME        string = "Voice"
+	FALLBACK_CONSUMER_NAME     string = "SmsFallback"
+	SUBMIT_CONSUMER_NAME       string = "SmsSubmit"
+	WHATSAPP_CONSUMER_NAME     string = "WhatsApp"
+	TELEGRAM_CONSUMER_NAME     string = "Telegram"
+	WEBHOOKS_CONSUMER_NAME     string = "Webhooks"
+	FORWARDS_CONSUMER_NAME     string = "Forwards"
+	PARKED_STREAM_NAME         string = "SmsParked"
+	EVENTS_STREAM_NAME         string = "SmsEvents"
+	EVENTS_SINK_CONSUMER_NAME  string = "SmsEventsSink"
+	KAFKA_BRIDGE_CONSUMER_NAME string = "SmsKafkaBridge"
+	AUDIT_STREAM_NAME          string = "SmsAudit"
+
+	SMS_ERRORS_CONSUMER_NAME         string = "SmsErrors"
+	EXPRESS_SMS_ERRORS_CONSUMER_NAME string = "SmsExpressErrors"
 )
@@ -0,0 +1,141 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// PriorityStreams is the work queue stream of each sms priority
+var PriorityStreams = map[string]string{
+	"normal":  NORMAL_SMS_CONSUMER_NAME,
+	"express": EXPRESS_SMS_CONSUMER_NAME,
+}
+
+// Depth tracks how many messages wait in each priority's work queue, as a
+// scaling signal for the workers. the streams use work queue retention, so
+// every message in them is either pending or being handled.
+type Depth struct {
+	streams map[string]jetstream.Stream
+
+	mu   sync.RWMutex
+	last map[string]uint64
+	// oldest is when the oldest message of each priority was queued, zero while empty
+	oldest map[string]time.Time
+}
+
+// NewDepth tracks the priority streams found in streams, e.g. the Streams of a nats.Manager.
+func NewDepth(streams map[string]jetstream.Stream) *Depth {
+	d := &Depth{
+		streams: make(map[string]jetstream.Stream),
+		last:    make(map[string]uint64),
+		oldest:  make(map[string]time.Time),
+	}
+	for priority, name := range PriorityStreams {
+		if s, ok := streams[name]; ok {
+			d.streams[priority] = s
+		}
+	}
+	return d
+}
+
+// Refresh reads the depth of every stream and updates the QueueDepth gauge.
+// streams that fail keep their last depth.
+func (d *Depth) Refresh(ctx context.Context) error {
+	var firstErr error
+	for priority, s := range d.streams {
+		info, err := s.Info(ctx)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		d.mu.Lock()
+		d.last[priority] = info.State.Msgs
+		d.oldest[priority] = time.Time{}
+		if info.State.Msgs > 0 {
+			d.oldest[priority] = info.State.FirstTime
+		}
+		d.mu.Unlock()
+		metrics.QueueDepth.WithLabelValues(priority).Set(float64(info.State.Msgs))
+	}
+	return firstErr
+}
+
+// Run refreshes the depth every interval until ctx is done.
+func (d *Depth) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := d.Refresh(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to read queue depth: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Latencies returns how long the oldest message of every priority has been waiting
+// at now, as of the last refresh. empty queues have no latency.
+func (d *Depth) Latencies(now time.Time) map[string]time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	res := make(map[string]time.Duration, len(d.oldest))
+	for priority, oldest := range d.oldest {
+		if oldest.IsZero() {
+			res[priority] = 0
+			continue
+		}
+		res[priority] = max(now.Sub(oldest), 0)
+	}
+	return res
+}
+
+// Depths returns the last depth of every priority.
+func (d *Depth) Depths() map[string]uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	res := make(map[string]uint64, len(d.last))
+	for priority, n := range d.last {
+		res[priority] = n
+	}
+	return res
+}
+
+// Wait estimates how long a message queued now with priority waits before it is
+// dispatched: the messages ahead of it, as of the last refresh, each taking one
+// interval of the priority's rate limit on one of workers. ahead is the number
+// of those messages.
+func (d *Depth) Wait(priority string, interval time.Duration, workers int) (ahead uint64, wait time.Duration) {
+	d.mu.RLock()
+	ahead = d.last[priority]
+	d.mu.RUnlock()
+	if workers < 1 {
+		workers = 1
+	}
+	return ahead, time.Duration(ahead) * interval / time.Duration(workers)
+}
+
+// Handler answers the last depth of every priority and their total as JSON,
+// e.g. {"normal":120,"express":4,"total":124}, for KEDA's metrics-api scaler.
+func (d *Depth) Handler(w http.ResponseWriter, r *http.Request) {
+	res := d.Depths()
+	var total uint64
+	for _, n := range res {
+		total += n
+	}
+	res["total"] = total
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
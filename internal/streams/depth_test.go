@@ -0,0 +1,94 @@
+package streams_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeStream struct {
+	jetstream.Stream
+	msgs  uint64
+	first time.Time
+	err   error
+}
+
+func (s *fakeStream) Info(ctx context.Context, opts ...jetstream.StreamInfoOpt) (*jetstream.StreamInfo, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &jetstream.StreamInfo{State: jetstream.StreamState{Msgs: s.msgs, FirstTime: s.first}}, nil
+}
+
+var _ = Describe("Depth", func() {
+	var (
+		normal, express *fakeStream
+		depth           *streams.Depth
+	)
+
+	body := func() map[string]uint64 {
+		rec := httptest.NewRecorder()
+		depth.Handler(rec, httptest.NewRequest(http.MethodGet, "/queue/depth", nil))
+		res := map[string]uint64{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+		return res
+	}
+
+	BeforeEach(func() {
+		normal = &fakeStream{msgs: 120}
+		express = &fakeStream{msgs: 4}
+		depth = streams.NewDepth(map[string]jetstream.Stream{
+			streams.NORMAL_SMS_CONSUMER_NAME:  normal,
+			streams.EXPRESS_SMS_CONSUMER_NAME: express,
+			streams.JOBS_CONSUMER_NAME:        &fakeStream{msgs: 1},
+		})
+	})
+
+	It("reports the depth of every priority", func() {
+		Expect(depth.Refresh(context.Background())).To(Succeed())
+		Expect(body()).To(Equal(map[string]uint64{"normal": 120, "express": 4, "total": 124}))
+		Expect(testutil.ToFloat64(metrics.QueueDepth.WithLabelValues("express"))).To(Equal(4.0))
+	})
+
+	It("keeps the last depth of streams it can't read", func() {
+		Expect(depth.Refresh(context.Background())).To(Succeed())
+		normal.err = errors.New("timeout")
+		normal.msgs = 0
+		express.msgs = 6
+		Expect(depth.Refresh(context.Background())).To(MatchError("timeout"))
+		Expect(body()).To(Equal(map[string]uint64{"normal": 120, "express": 6, "total": 126}))
+	})
+
+	It("estimates the wait from the depth and the rate limit", func() {
+		Expect(depth.Refresh(context.Background())).To(Succeed())
+		ahead, wait := depth.Wait("normal", time.Second, 4)
+		Expect(ahead).To(BeEquivalentTo(120))
+		Expect(wait).To(Equal(30 * time.Second))
+		ahead, wait = depth.Wait("express", 50*time.Millisecond, 0)
+		Expect(ahead).To(BeEquivalentTo(4))
+		Expect(wait).To(Equal(200 * time.Millisecond))
+	})
+
+	It("reports how long the oldest message of every priority waits", func() {
+		now := time.Now()
+		normal.first = now.Add(-90 * time.Second)
+		express.msgs = 0
+		express.first = now.Add(-time.Hour)
+		Expect(depth.Refresh(context.Background())).To(Succeed())
+		Expect(depth.Latencies(now)).To(Equal(map[string]time.Duration{
+			"normal":  90 * time.Second,
+			"express": 0,
+		}))
+	})
+})
@@ -0,0 +1,97 @@
+package streams_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/internal/jobs"
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/internal/workers"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// overlap reports whether a subject matches both a and b, which nats refuses
+// for two streams.
+func overlap(a, b string) bool {
+	at, bt := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if at[i] == ">" || bt[i] == ">" {
+			return true
+		}
+		if at[i] != bt[i] && at[i] != "*" && bt[i] != "*" {
+			return false
+		}
+	}
+	return len(at) == len(bt)
+}
+
+var _ = Describe("Stream definitions", func() {
+	var api, worker []jetstream.StreamConfig
+
+	BeforeEach(func() {
+		for _, key := range []string{"events.stream.enabled", "worker.forwards.enabled", "nats.audit.enabled"} {
+			DeferCleanup(viper.Set, key, viper.GetBool(key))
+			viper.Set(key, true)
+		}
+
+		// what the api binds, see the controllers publishing
+		api = append(controllers.SmsStreams(), streams.ParkedStream(), webhooks.StreamConfig(), jobs.StreamConfig())
+
+		// what the workers bind, see cmd/worker
+		worker = workers.SmsStreams(true)
+		for _, config := range workers.SmsConsumers(true, workers.AckPolicies{}) {
+			worker = append(worker, config.Stream)
+		}
+		audit, err := workers.AuditStreams(true)
+		Expect(err).NotTo(HaveOccurred())
+		worker = append(worker, audit...)
+		worker = append(worker, channels.NotificationStreams()...)
+		worker = append(worker, jobs.StreamConfig(), webhooks.StreamConfig(), events.StreamConfig(), forwards.StreamConfig())
+	})
+
+	It("should give a stream the same config wherever it is bound", func() {
+		byName := make(map[string]jetstream.StreamConfig)
+		for _, config := range append(api, worker...) {
+			want, ok := byName[config.Name]
+			if !ok {
+				byName[config.Name] = config
+				continue
+			}
+			Expect(nats.StreamDrift(want, config)).To(BeEmpty(), "stream %s drifted", config.Name)
+			Expect(config).To(Equal(want), "stream %s drifted", config.Name)
+		}
+		for _, config := range api {
+			Expect(worker).To(ContainElement(HaveField("Name", config.Name)), "no worker binds stream %s", config.Name)
+		}
+	})
+
+	It("should not bind a subject in two streams", func() {
+		owner := make(map[string]string)
+		for _, config := range append(api, worker...) {
+			for _, subject := range config.Subjects {
+				for other, name := range owner {
+					if name != config.Name {
+						Expect(overlap(subject, other)).To(BeFalse(), "%s of %s overlaps %s of %s", subject, config.Name, other, name)
+					}
+				}
+				owner[subject] = config.Name
+			}
+		}
+	})
+
+	It("should allow direct gets on the sms work queues", func() {
+		for _, config := range []jetstream.StreamConfig{streams.NormalSmsStream(), streams.ExpressSmsStream()} {
+			Expect(config.AllowDirect).To(BeTrue(), config.Name)
+			Expect(config.Retention).To(Equal(jetstream.WorkQueuePolicy), config.Name)
+		}
+	})
+})
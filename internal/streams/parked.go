@@ -0,0 +1,104 @@
+package streams
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// HeaderParkedSubject is the subject a parked message is resumed on.
+const HeaderParkedSubject = "Sms-Parked-Subject"
+
+// ParkedStream holds the queued messages of suspended users until they are
+// resumed. it has no consumer, messages only leave it through Resume.
+func ParkedStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        PARKED_STREAM_NAME,
+		Description: "queued messages of suspended users",
+		Subjects:    []string{SmsParkedAll()},
+		Retention:   jetstream.LimitsPolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// ParkedSubject is where the messages of userID are parked.
+func ParkedSubject(userID int32) string {
+	return SmsParked(userID)
+}
+
+// Parked copies msg, headers included, onto the parked subject of userID. the
+// JetStream message id is dropped, the copy would otherwise be deduplicated
+// against the original when it is resumed within the duplicates window.
+func Parked(msg jetstream.Msg, userID int32) *nats.Msg {
+	h := nats.Header{}
+	for k, v := range msg.Headers() {
+		if k == jetstream.MsgIDHeader {
+			continue
+		}
+		h[k] = v
+	}
+	h.Set(HeaderParkedSubject, msg.Subject())
+	return &nats.Msg{
+		Subject: ParkedSubject(userID),
+		Header:  h,
+		Data:    msg.Data(),
+	}
+}
+
+// Resume publishes the parked messages of userID back on the subjects they were
+// parked from, in the order they were parked, and deletes them from the parked
+// stream. it returns how many were resumed.
+func Resume(ctx context.Context, js jetstream.JetStream, userID int32) (int, error) {
+	stream, err := js.Stream(ctx, PARKED_STREAM_NAME)
+	if err != nil {
+		return 0, err
+	}
+	subject := ParkedSubject(userID)
+	var n int
+	for seq := uint64(1); ; {
+		parked, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(subject))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		_, err = js.PublishMsg(ctx, resumed(parked))
+		if err != nil {
+			return n, err
+		}
+		err = stream.DeleteMsg(ctx, parked.Sequence)
+		if err != nil {
+			return n, err
+		}
+		n++
+		seq = parked.Sequence + 1
+	}
+}
+
+// Discard deletes the parked messages of userID.
+func Discard(ctx context.Context, js jetstream.JetStream, userID int32) error {
+	stream, err := js.Stream(ctx, PARKED_STREAM_NAME)
+	if err != nil {
+		return err
+	}
+	return stream.Purge(ctx, jetstream.WithPurgeSubject(ParkedSubject(userID)))
+}
+
+// resumed is the message parked was parked from.
+func resumed(parked *jetstream.RawStreamMsg) *nats.Msg {
+	h := nats.Header{}
+	for k, v := range parked.Header {
+		h[k] = v
+	}
+	subject := h.Get(HeaderParkedSubject)
+	h.Del(HeaderParkedSubject)
+	return &nats.Msg{
+		Subject: subject,
+		Header:  h,
+		Data:    parked.Data,
+	}
+}
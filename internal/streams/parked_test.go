@@ -0,0 +1,102 @@
+package streams_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeMsg struct {
+	jetstream.Msg
+	subject string
+	header  nats.Header
+	data    []byte
+}
+
+func (m *fakeMsg) Subject() string      { return m.subject }
+func (m *fakeMsg) Headers() nats.Header { return m.header }
+func (m *fakeMsg) Data() []byte         { return m.data }
+
+// parkedStream keeps its messages by sequence
+type parkedStream struct {
+	jetstream.Stream
+	msgs map[uint64]*jetstream.RawStreamMsg
+}
+
+func (s *parkedStream) GetMsg(ctx context.Context, seq uint64, opts ...jetstream.GetMsgOpt) (*jetstream.RawStreamMsg, error) {
+	next := uint64(0)
+	for n := range s.msgs {
+		if n >= seq && (next == 0 || n < next) {
+			next = n
+		}
+	}
+	if next == 0 {
+		return nil, jetstream.ErrMsgNotFound
+	}
+	return s.msgs[next], nil
+}
+
+func (s *parkedStream) DeleteMsg(ctx context.Context, seq uint64) error {
+	delete(s.msgs, seq)
+	return nil
+}
+
+type fakeJetStream struct {
+	jetstream.JetStream
+	stream    *parkedStream
+	published []*nats.Msg
+}
+
+func (js *fakeJetStream) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	return js.stream, nil
+}
+
+func (js *fakeJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.published = append(js.published, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+var _ = Describe("Parked", func() {
+	It("should copy the message onto the user's parked subject", func() {
+		msg := &fakeMsg{
+			subject: "sms.send.request",
+			header:  nats.Header{"Sms-Message-Id": {"abc"}, jetstream.MsgIDHeader: {"dedupe"}},
+			data:    []byte("{}"),
+		}
+		parked := streams.Parked(msg, 7)
+		Expect(parked.Subject).To(Equal("sms.parked.7"))
+		Expect(parked.Data).To(Equal(msg.data))
+		Expect(parked.Header.Get("Sms-Message-Id")).To(Equal("abc"))
+		Expect(parked.Header.Get(streams.HeaderParkedSubject)).To(Equal("sms.send.request"))
+		Expect(parked.Header.Get(jetstream.MsgIDHeader)).To(BeEmpty())
+	})
+
+	It("should resume parked messages in order on their original subjects", func() {
+		park := func(seq uint64, subject string) *jetstream.RawStreamMsg {
+			return &jetstream.RawStreamMsg{
+				Subject:  "sms.parked.7",
+				Sequence: seq,
+				Header:   nats.Header{streams.HeaderParkedSubject: {subject}},
+				Data:     []byte(subject),
+			}
+		}
+		js := &fakeJetStream{stream: &parkedStream{msgs: map[uint64]*jetstream.RawStreamMsg{
+			3: park(3, "sms.send.request"),
+			9: park(9, "email.send.request"),
+		}}}
+
+		n, err := streams.Resume(context.Background(), js, 7)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+		Expect(js.published).To(HaveLen(2))
+		Expect(js.published[0].Subject).To(Equal("sms.send.request"))
+		Expect(js.published[1].Subject).To(Equal("email.send.request"))
+		Expect(js.published[0].Header.Get(streams.HeaderParkedSubject)).To(BeEmpty())
+		Expect(js.stream.msgs).To(BeEmpty())
+	})
+})
@@ -0,0 +1,140 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// HeaderReplay marks a message republished by Replay, with the id of the replay.
+	HeaderReplay = "Sms-Replay"
+	// headerStreamSource is where the audit stream keeps the stream and sequence a
+	// copy was sourced from
+	headerStreamSource = "Nats-Stream-Source"
+	// headerMessageID is events.HeaderMessageID, which imports this package
+	headerMessageID = "Sms-Message-Id"
+)
+
+// replayWait is how long Replay waits for the next copy, they are all stored already.
+const replayWait = 5 * time.Second
+
+var (
+	ErrReplayStream = errors.New("unknown replay stream")
+	ErrNoAudit      = errors.New("the audit stream is not enabled, see nats.audit")
+)
+
+// ReplaySubjects are the subjects of the work queue stream, whose copies in the
+// audit stream can be replayed.
+func ReplaySubjects(stream string) ([]string, error) {
+	for _, config := range []jetstream.StreamConfig{NormalSmsStream(), ExpressSmsStream(), SubmitStream()} {
+		if config.Name == stream {
+			return config.Subjects, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q, want %s, %s or %s", ErrReplayStream, stream,
+		NORMAL_SMS_CONSUMER_NAME, EXPRESS_SMS_CONSUMER_NAME, SUBMIT_CONSUMER_NAME)
+}
+
+type ReplayParams struct {
+	// ID tells the messages of this replay apart, see HeaderReplay
+	ID string
+	// Stream is the work queue whose messages are replayed
+	Stream string
+	// From and To bound when the copies were stored, a zero To doesn't
+	From, To time.Time
+}
+
+// Replay publishes the copies of the messages of p.Stream the audit stream kept
+// between p.From and p.To again, in order, on their original subjects. their
+// message ids are kept, so the workers skip those they already processed
+// instead of charging them twice. it returns how many were replayed.
+func Replay(ctx context.Context, js jetstream.JetStream, p ReplayParams) (int, error) {
+	subjects, err := ReplaySubjects(p.Stream)
+	if err != nil {
+		return 0, err
+	}
+	audit, err := js.Stream(ctx, AUDIT_STREAM_NAME)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return 0, ErrNoAudit
+	}
+	if err != nil {
+		return 0, err
+	}
+	from := p.From
+	cons, err := audit.CreateConsumer(ctx, jetstream.ConsumerConfig{
+		Description:       "replay " + p.ID,
+		FilterSubjects:    subjects,
+		DeliverPolicy:     jetstream.DeliverByStartTimePolicy,
+		OptStartTime:      &from,
+		AckPolicy:         jetstream.AckNonePolicy,
+		InactiveThreshold: time.Minute,
+	})
+	if err != nil {
+		return 0, err
+	}
+	info := cons.CachedInfo()
+	defer audit.DeleteConsumer(context.WithoutCancel(ctx), info.Name)
+
+	var n int
+	for remaining := info.NumPending; remaining > 0; remaining-- {
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+		msg, err := cons.Next(jetstream.FetchMaxWait(replayWait))
+		if err != nil {
+			return n, err
+		}
+		md, err := msg.Metadata()
+		if err != nil {
+			return n, err
+		}
+		if !p.To.IsZero() && md.Timestamp.After(p.To) {
+			return n, nil
+		}
+		_, err = js.PublishMsg(ctx, replayed(msg, p.ID))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// replayed is the message the audit copy msg was sourced from, marked with the
+// replay id. the JetStream message id is dropped, it would be deduplicated
+// within the duplicates window otherwise. messages published without the api
+// headers get the id the workers knew them by, their original position.
+func replayed(msg jetstream.Msg, id string) *nats.Msg {
+	h := nats.Header{}
+	for k, v := range msg.Headers() {
+		if k == jetstream.MsgIDHeader || k == headerStreamSource {
+			continue
+		}
+		h[k] = v
+	}
+	if h.Get(headerMessageID) == "" {
+		// "<stream>[:<domain hash>] <sequence> ..."
+		source := strings.Fields(msg.Headers().Get(headerStreamSource))
+		if len(source) >= 2 {
+			stream, _, _ := strings.Cut(source[0], ":")
+			h.Set(headerMessageID, stream+":"+source[1])
+		}
+	}
+	h.Set(HeaderReplay, id)
+	return &nats.Msg{
+		Subject: msg.Subject(),
+		Header:  h,
+		Data:    msg.Data(),
+	}
+}
+
+// ReplayID is the id of the replay that published msg, "" when it wasn't replayed.
+func ReplayID(msg jetstream.Msg) string {
+	return msg.Headers().Get(HeaderReplay)
+}
@@ -0,0 +1,134 @@
+package streams_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type auditMsg struct {
+	fakeMsg
+	stored time.Time
+}
+
+func (m *auditMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{Stream: streams.AUDIT_STREAM_NAME, Timestamp: m.stored}, nil
+}
+
+// auditConsumer delivers the copies stored since the start time of its config
+type auditConsumer struct {
+	jetstream.Consumer
+	msgs []*auditMsg
+}
+
+func (c *auditConsumer) CachedInfo() *jetstream.ConsumerInfo {
+	return &jetstream.ConsumerInfo{Name: "replay", NumPending: uint64(len(c.msgs))}
+}
+
+func (c *auditConsumer) Next(opts ...jetstream.FetchOpt) (jetstream.Msg, error) {
+	msg := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return msg, nil
+}
+
+type auditStream struct {
+	jetstream.Stream
+	msgs    []*auditMsg
+	config  jetstream.ConsumerConfig
+	deleted bool
+}
+
+func (s *auditStream) CreateConsumer(ctx context.Context, config jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	s.config = config
+	c := &auditConsumer{}
+	for _, msg := range s.msgs {
+		if !msg.stored.Before(*config.OptStartTime) {
+			c.msgs = append(c.msgs, msg)
+		}
+	}
+	return c, nil
+}
+
+func (s *auditStream) DeleteConsumer(ctx context.Context, name string) error {
+	s.deleted = true
+	return nil
+}
+
+type auditJetStream struct {
+	jetstream.JetStream
+	stream    *auditStream
+	published []*nats.Msg
+}
+
+func (js *auditJetStream) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	if js.stream == nil {
+		return nil, jetstream.ErrStreamNotFound
+	}
+	return js.stream, nil
+}
+
+func (js *auditJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.published = append(js.published, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+var _ = Describe("Replay", func() {
+	var (
+		ctx   = context.Background()
+		start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	copyOf := func(stored time.Time, header nats.Header) *auditMsg {
+		return &auditMsg{
+			fakeMsg: fakeMsg{subject: "sms.send.request", header: header, data: []byte("{}")},
+			stored:  stored,
+		}
+	}
+
+	It("should republish the copies of the time range with their message ids", func() {
+		js := &auditJetStream{stream: &auditStream{msgs: []*auditMsg{
+			copyOf(start.Add(-time.Hour), nats.Header{"Sms-Message-Id": {"before"}}),
+			copyOf(start.Add(time.Minute), nats.Header{
+				"Sms-Message-Id":      {"abc"},
+				jetstream.MsgIDHeader: {"dedupe"},
+				"Nats-Stream-Source":  {"Sms 12 > >"},
+			}),
+			copyOf(start.Add(2*time.Minute), nats.Header{"Nats-Stream-Source": {"Sms:a1b2 13 > >"}}),
+			copyOf(start.Add(2*time.Hour), nats.Header{"Sms-Message-Id": {"after"}}),
+		}}}
+
+		n, err := streams.Replay(ctx, js, streams.ReplayParams{
+			ID:     "r1",
+			Stream: streams.NORMAL_SMS_CONSUMER_NAME,
+			From:   start,
+			To:     start.Add(time.Hour),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+		Expect(js.stream.config.FilterSubjects).To(Equal(streams.NormalSmsStream().Subjects))
+		Expect(js.stream.deleted).To(BeTrue())
+
+		Expect(js.published).To(HaveLen(2))
+		Expect(js.published[0].Subject).To(Equal("sms.send.request"))
+		Expect(js.published[0].Header.Get("Sms-Message-Id")).To(Equal("abc"))
+		Expect(js.published[0].Header.Get(jetstream.MsgIDHeader)).To(BeEmpty())
+		Expect(js.published[0].Header.Get("Nats-Stream-Source")).To(BeEmpty())
+		Expect(js.published[0].Header.Get(streams.HeaderReplay)).To(Equal("r1"))
+		// published without the api headers, known by their original position
+		Expect(js.published[1].Header.Get("Sms-Message-Id")).To(Equal("Sms:13"))
+	})
+
+	It("should refuse unknown streams and a missing audit stream", func() {
+		_, err := streams.Replay(ctx, &auditJetStream{stream: &auditStream{}}, streams.ReplayParams{Stream: "NORMAL"})
+		Expect(err).To(MatchError(streams.ErrReplayStream))
+
+		_, err = streams.Replay(ctx, &auditJetStream{}, streams.ReplayParams{Stream: streams.SUBMIT_CONSUMER_NAME})
+		Expect(err).To(MatchError(streams.ErrNoAudit))
+	})
+})
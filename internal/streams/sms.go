@@ -0,0 +1,50 @@
+package streams
+
+import (
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// NormalSmsStream is the work queue of normal priority sms. the api and the
+// workers both bind it, so they must agree on its config.
+func NormalSmsStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        NORMAL_SMS_CONSUMER_NAME,
+		Description: "work queue for handling sms with normal priority",
+		Subjects:    NormalSubjects(),
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+		AllowDirect: true,
+		Duplicates:  viper.GetDuration("sms.dedupe.window"),
+	}
+}
+
+// ExpressSmsStream is NormalSmsStream for high priority sms.
+func ExpressSmsStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        EXPRESS_SMS_CONSUMER_NAME,
+		Description: "work queue for handling sms with high priority",
+		Subjects:    ExpressSubjects(),
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+		AllowDirect: true,
+		Duplicates:  viper.GetDuration("sms.dedupe.window"),
+	}
+}
+
+// SubmitSubject is where the workers queue stored sms for submission to the carrier.
+var SubmitSubject = SmsSubmit()
+
+// SubmitStream holds the sms waiting to be submitted to the carrier, and between
+// the retries of refused submissions. it is only used when the workers submit sms
+// themselves, see carrier.FromViper.
+func SubmitStream() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        SUBMIT_CONSUMER_NAME,
+		Description: "work queue for submitting stored sms to the carrier",
+		Subjects:    []string{SubmitSubject},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
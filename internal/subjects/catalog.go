@@ -0,0 +1,210 @@
+package subjects
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the catalog of the subjects the gateway publishes and consumes on. subjects are
+// only built by these functions, never by joining the tokens above where they're
+// used, so a typo fails to compile instead of publishing on a subject no stream
+// holds. the priorities, channels and job kinds subjects are built for are
+// declared below, any other is refused with an error. the streams take their
+// subject lists from here too.
+
+var (
+	ErrPriority = errors.New("unknown priority")
+	ErrChannel  = errors.New("unknown channel")
+	ErrJobKind  = errors.New("unknown job kind")
+)
+
+// Priority is the priority of an sms, every one has a work queue of its own.
+type Priority string
+
+const (
+	NormalPriority  Priority = "normal"
+	ExpressPriority Priority = "express"
+)
+
+// Priorities are the priorities with a work queue.
+var Priorities = []Priority{NormalPriority, ExpressPriority}
+
+// Channel is a channel notifications other than sms are sent through.
+type Channel string
+
+const (
+	EmailChannel    Channel = EMAIL
+	PushChannel     Channel = PUSH
+	VoiceChannel    Channel = VOICE
+	WhatsAppChannel Channel = WHATSAPP
+	TelegramChannel Channel = TELEGRAM
+)
+
+// Channels are the channels with a work queue.
+var Channels = []Channel{EmailChannel, PushChannel, VoiceChannel, WhatsAppChannel, TelegramChannel}
+
+// JobKind is a kind of job the workers run.
+type JobKind string
+
+const (
+	ExportJob JobKind = "export"
+	ImportJob JobKind = "import"
+)
+
+// JobKinds are the kinds of jobs with subjects.
+var JobKinds = []JobKind{ExportJob, ImportJob}
+
+// Must is the subject of a declared priority, channel or job kind, it panics on
+// err. for the subjects of stream and consumer definitions, like template.Must.
+func Must(subject string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return subject
+}
+
+func join(tokens ...string) string {
+	return strings.Join(tokens, ".")
+}
+
+// NormalSendReq is where the api queues normal priority sms.
+func NormalSendReq() string { return join(SMS, SEND, REQ) }
+
+// NormalStat is where the status updates of normal priority sms are queued.
+func NormalStat() string { return join(SMS, SEND, STAT) }
+
+// NormalErr is where the workers report failures of normal priority sms.
+func NormalErr() string { return join(SMS, SEND, ERR) }
+
+// ExpressSendReq is NormalSendReq for high priority sms.
+func ExpressSendReq() string { return join(SMS, EX, SEND, REQ) }
+
+// ExpressStat is NormalStat for high priority sms.
+func ExpressStat() string { return join(SMS, EX, SEND, STAT) }
+
+// ExpressErr is NormalErr for high priority sms.
+func ExpressErr() string { return join(SMS, EX, SEND, ERR) }
+
+// prioritySubject picks the subject of priority out of its normal and express
+// variants.
+func prioritySubject(priority Priority, normal, express func() string) (string, error) {
+	switch priority {
+	case NormalPriority:
+		return normal(), nil
+	case ExpressPriority:
+		return express(), nil
+	}
+	return "", fmt.Errorf("%w %q", ErrPriority, priority)
+}
+
+// PrioritySendReq is the NormalSendReq or ExpressSendReq of priority.
+func PrioritySendReq(priority Priority) (string, error) {
+	return prioritySubject(priority, NormalSendReq, ExpressSendReq)
+}
+
+// PriorityStat is the NormalStat or ExpressStat of priority.
+func PriorityStat(priority Priority) (string, error) {
+	return prioritySubject(priority, NormalStat, ExpressStat)
+}
+
+// PriorityErr is the NormalErr or ExpressErr of priority.
+func PriorityErr(priority Priority) (string, error) {
+	return prioritySubject(priority, NormalErr, ExpressErr)
+}
+
+// PrioritySubjects are the subjects of the work queue of priority.
+func PrioritySubjects(priority Priority) ([]string, error) {
+	subjects := make([]string, 0, 3)
+	for _, subject := range []func(Priority) (string, error){PrioritySendReq, PriorityStat, PriorityErr} {
+		s, err := subject(priority)
+		if err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects, nil
+}
+
+// NormalSubjects are the subjects of the normal priority work queue.
+func NormalSubjects() []string {
+	subjects, err := PrioritySubjects(NormalPriority)
+	if err != nil {
+		panic(err)
+	}
+	return subjects
+}
+
+// ExpressSubjects are the subjects of the high priority work queue.
+func ExpressSubjects() []string {
+	subjects, err := PrioritySubjects(ExpressPriority)
+	if err != nil {
+		panic(err)
+	}
+	return subjects
+}
+
+// SmsSubmit is where stored sms wait for submission to the carrier.
+func SmsSubmit() string { return join(SMS, SUBMIT) }
+
+// SmsFallback is where the fallback checks of sms are queued.
+func SmsFallback() string { return join(SMS, FALLBACK) }
+
+// SmsParked is where the queued messages of userID are parked.
+func SmsParked(userID int32) string { return join(SMS, PARKED, strconv.Itoa(int(userID))) }
+
+// SmsParkedAll matches the parked messages of every user.
+func SmsParkedAll() string { return join(SMS, PARKED, ANY) }
+
+// SmsEvent is where the events named name are published.
+func SmsEvent(name string) string { return join(SMS, EVENTS, name) }
+
+// SmsEventsAll matches every event.
+func SmsEventsAll() string { return join(SMS, EVENTS, ANY) }
+
+// ChannelSendReq is where the notifications of channel are queued.
+func ChannelSendReq(channel Channel) (string, error) {
+	if !slices.Contains(Channels, channel) {
+		return "", fmt.Errorf("%w %q", ErrChannel, channel)
+	}
+	return join(string(channel), SEND, REQ), nil
+}
+
+// ChannelStat is where the status updates of channel are published.
+func ChannelStat(channel Channel) (string, error) {
+	if !slices.Contains(Channels, channel) {
+		return "", fmt.Errorf("%w %q", ErrChannel, channel)
+	}
+	return join(string(channel), SEND, STAT), nil
+}
+
+// JobReq is where the jobs of kind are queued.
+func JobReq(kind JobKind) (string, error) {
+	if !slices.Contains(JobKinds, kind) {
+		return "", fmt.Errorf("%w %q", ErrJobKind, kind)
+	}
+	return join(JOBS, string(kind), REQ), nil
+}
+
+// JobSubjects are the subjects of the jobs of every kind.
+func JobSubjects() []string {
+	subjects := make([]string, 0, len(JobKinds))
+	for _, kind := range JobKinds {
+		subjects = append(subjects, Must(JobReq(kind)))
+	}
+	return subjects
+}
+
+// JobProgress is where the progress of job id is published.
+func JobProgress(id int32) string { return join(JOBS, PROGRESS, strconv.Itoa(int(id))) }
+
+// WebhooksDeliver is where webhook deliveries are queued.
+func WebhooksDeliver() string { return join(WEBHOOKS, DELIVER) }
+
+// ForwardsDeliver is where the events to forward to the nats servers of users wait.
+func ForwardsDeliver() string { return join(FORWARDS, DELIVER) }
+
+// WorkersHeartbeat is where the workers publish their heartbeats.
+func WorkersHeartbeat() string { return join(WORKERS, HEARTBEAT) }
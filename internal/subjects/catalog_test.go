@@ -0,0 +1,77 @@
+package subjects_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/subjects"
+)
+
+var _ = Describe("Catalog", func() {
+	// messages already queued stay on the subjects they were published on, so
+	// the catalog must keep building the same ones
+	DescribeTable("should build the subjects on the wire",
+		func(subject, want string) {
+			Expect(subject).To(Equal(want))
+		},
+		Entry("normal request", subjects.NormalSendReq(), "sms.send.request"),
+		Entry("normal status", subjects.NormalStat(), "sms.send.status"),
+		Entry("normal error", subjects.NormalErr(), "sms.send.error"),
+		Entry("express request", subjects.ExpressSendReq(), "sms.ex.send.request"),
+		Entry("express status", subjects.ExpressStat(), "sms.ex.send.status"),
+		Entry("express error", subjects.ExpressErr(), "sms.ex.send.error"),
+		Entry("submit", subjects.SmsSubmit(), "sms.submit"),
+		Entry("fallback", subjects.SmsFallback(), "sms.fallback"),
+		Entry("parked", subjects.SmsParked(42), "sms.parked.42"),
+		Entry("parked of every user", subjects.SmsParkedAll(), "sms.parked.*"),
+		Entry("event", subjects.SmsEvent("delivered"), "sms.events.delivered"),
+		Entry("every event", subjects.SmsEventsAll(), "sms.events.*"),
+		Entry("channel request", subjects.Must(subjects.ChannelSendReq(subjects.EmailChannel)), "email.send.request"),
+		Entry("channel status", subjects.Must(subjects.ChannelStat(subjects.VoiceChannel)), "voice.send.status"),
+		Entry("job", subjects.Must(subjects.JobReq(subjects.ExportJob)), "jobs.export.request"),
+		Entry("job progress", subjects.JobProgress(7), "jobs.progress.7"),
+		Entry("webhooks", subjects.WebhooksDeliver(), "webhooks.deliver"),
+		Entry("forwards", subjects.ForwardsDeliver(), "forwards.deliver"),
+		Entry("heartbeats", subjects.WorkersHeartbeat(), "workers.heartbeat"),
+	)
+
+	It("should pick the subjects of a priority", func() {
+		Expect(subjects.PrioritySendReq(subjects.ExpressPriority)).To(Equal(subjects.ExpressSendReq()))
+		Expect(subjects.PriorityStat(subjects.ExpressPriority)).To(Equal(subjects.ExpressStat()))
+		Expect(subjects.PriorityErr(subjects.ExpressPriority)).To(Equal(subjects.ExpressErr()))
+		Expect(subjects.PrioritySendReq(subjects.NormalPriority)).To(Equal(subjects.NormalSendReq()))
+		Expect(subjects.PriorityStat(subjects.NormalPriority)).To(Equal(subjects.NormalStat()))
+		Expect(subjects.PriorityErr(subjects.NormalPriority)).To(Equal(subjects.NormalErr()))
+	})
+
+	It("should refuse priorities, channels and job kinds it doesn't declare", func() {
+		_, err := subjects.PrioritySendReq("expres")
+		Expect(err).To(MatchError(subjects.ErrPriority))
+		_, err = subjects.PriorityStat("")
+		Expect(err).To(MatchError(subjects.ErrPriority))
+		_, err = subjects.PrioritySubjects("urgent")
+		Expect(err).To(MatchError(subjects.ErrPriority))
+		_, err = subjects.ChannelSendReq("emial")
+		Expect(err).To(MatchError(subjects.ErrChannel))
+		// sms have work queues of their own, see PrioritySendReq
+		_, err = subjects.ChannelSendReq(subjects.SMS)
+		Expect(err).To(MatchError(subjects.ErrChannel))
+		_, err = subjects.ChannelStat("fax")
+		Expect(err).To(MatchError(subjects.ErrChannel))
+		_, err = subjects.JobReq("exprot")
+		Expect(err).To(MatchError(subjects.ErrJobKind))
+		Expect(func() { subjects.Must(subjects.JobReq("*")) }).To(Panic())
+	})
+
+	It("should list the subjects of the work queues", func() {
+		Expect(subjects.NormalSubjects()).To(Equal([]string{"sms.send.request", "sms.send.status", "sms.send.error"}))
+		Expect(subjects.ExpressSubjects()).To(Equal([]string{"sms.ex.send.request", "sms.ex.send.status", "sms.ex.send.error"}))
+		Expect(subjects.JobSubjects()).To(Equal([]string{"jobs.export.request", "jobs.import.request"}))
+		for _, priority := range subjects.Priorities {
+			Expect(subjects.PrioritySubjects(priority)).To(HaveLen(3))
+		}
+		for _, channel := range subjects.Channels {
+			Expect(subjects.ChannelSendReq(channel)).To(Equal(string(channel) + ".send.request"))
+		}
+	})
+})
@@ -1,11 +1,30 @@
 package subjects
 
 const (
-	SMS  = "sms"
-	SEND = "send"
-	REQ  = "request"
-	STAT = "status"
-	ERR  = "error"
-	EX   = "ex"
-	ANY  = "*"
+	SMS   = "sms"
+	EMAIL = "email"
+	PUSH  = "push"
+	VOICE = "voice"
+
+	WHATSAPP = "whatsapp"
+	TELEGRAM = "telegram"
+	SEND     = "send"
+	REQ      = "request"
+	STAT     = "status"
+	ERR      = "error"
+	EX       = "ex"
+	ANY      = "*"
+
+	JOBS     = "jobs"
+	FALLBACK = "fallback"
+	SUBMIT   = "submit"
+	WEBHOOKS = "webhooks"
+	FORWARDS = "forwards"
+	DELIVER  = "deliver"
+	PROGRESS = "progress"
+	PARKED   = "parked"
+	EVENTS   = "events"
+
+	WORKERS   = "workers"
+	HEARTBEAT = "heartbeat"
 )
@@ -0,0 +1,13 @@
+package subjects_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSubjects(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Subjects Suite")
+}
@@ -0,0 +1,293 @@
+// Package tail turns the messages flowing through the sms subjects into a live,
+// filterable feed, for watching production traffic from a terminal.
+package tail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/failures"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/nats-io/nats.go"
+)
+
+// kinds of entries, by the subject they were published on
+const (
+	KindRequest = "request"
+	KindStatus  = "status"
+	KindError   = "error"
+	KindEvent   = "event"
+)
+
+const (
+	priorityNormal  = "normal"
+	priorityExpress = "express"
+)
+
+// Subjects are the subjects the feed is made of.
+func Subjects() []string {
+	return []string{
+		NormalSendReq(),
+		ExpressSendReq(),
+		NormalStat(),
+		ExpressStat(),
+		NormalErr(),
+		ExpressErr(),
+		SmsEventsAll(),
+	}
+}
+
+// Entry is a message of the feed. fields the message doesn't carry are zero.
+type Entry struct {
+	Time     time.Time
+	Kind     string
+	SmsID    int32
+	UserID   int32
+	Priority string
+	// Status is the status of status updates, the name of events and the status
+	// a request is stored with
+	Status string
+	Detail string
+}
+
+// Parse makes an entry of a message published on one of the Subjects.
+func Parse(msg *nats.Msg) (Entry, error) {
+	e := Entry{Time: time.Now()}
+	parts := strings.Split(msg.Subject, ".")
+	if len(parts) == 3 && parts[1] == EVENTS {
+		var published events.Published
+		err := json.Unmarshal(msg.Data, &published)
+		if err != nil {
+			return e, err
+		}
+		e.Kind = KindEvent
+		e.Time = published.OccurredAt
+		e.SmsID = published.SmsID
+		e.Status = published.Event
+		e.Detail = detail("actor", published.Actor, "provider", published.Provider, "request", published.RequestID)
+		return e, nil
+	}
+
+	e.Priority = priorityNormal
+	if slices.Contains(parts, EX) {
+		e.Priority = priorityExpress
+	}
+	if created := msg.Header.Get(events.HeaderCreatedAt); created != "" {
+		if t, err := time.Parse(time.RFC3339Nano, created); err == nil {
+			e.Time = t
+		}
+	}
+	switch parts[len(parts)-1] {
+	case REQ:
+		var req channels.SmsRequest
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			return e, err
+		}
+		e.Kind = KindRequest
+		e.UserID = req.UserID
+		e.Status = req.Status
+		e.Detail = detail("to", mask(req.ToPhoneNumber), "from", fmt.Sprint(req.PhoneNumberID), "actor", msg.Header.Get(events.HeaderActor), "request", msg.Header.Get(events.HeaderRequestID))
+	case STAT:
+		var update status.Update
+		err := json.Unmarshal(msg.Data, &update)
+		if err != nil {
+			return e, err
+		}
+		e.Kind = KindStatus
+		e.SmsID = update.ID
+		e.Status = update.Status.String()
+		e.Detail = detail("provider", update.Provider, "reason", update.Reason)
+	case ERR:
+		var f failures.Failure
+		err := json.Unmarshal(msg.Data, &f)
+		if err != nil {
+			return e, err
+		}
+		e.Kind = KindError
+		e.Time = f.OccurredAt
+		e.SmsID = f.SmsID
+		e.Detail = detail("stage", f.Stage, "class", f.Class, "attempt", fmt.Sprint(f.Attempt), "error", f.Error)
+		if f.Final {
+			e.Detail += " final"
+		}
+	default:
+		return e, fmt.Errorf("unexpected subject %q", msg.Subject)
+	}
+	return e, nil
+}
+
+// detail joins the non-empty pairs of kv as key=value.
+func detail(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(kv[i] + "=" + kv[i+1])
+	}
+	return b.String()
+}
+
+// mask keeps the first and the last 3 digits of a number, the feed is for
+// watching traffic, not for reading who is texted.
+func mask(number string) string {
+	if len(number) <= 6 {
+		return number
+	}
+	return number[:3] + strings.Repeat("*", len(number)-6) + number[len(number)-3:]
+}
+
+// Owners looks up the user and priority of sms, for entries that don't carry them.
+type Owners interface {
+	GetSmsOwner(ctx context.Context, id int32) (int32, error)
+	GetSmsPriority(ctx context.Context, id int32) (string, error)
+}
+
+type owner struct {
+	userID   int32
+	priority string
+}
+
+// Filter keeps the entries of some users, priorities and statuses. an empty
+// list keeps them all.
+type Filter struct {
+	Users      []int32
+	Priorities []string
+	Statuses   []string
+	// Owners completes entries when filtering by user or priority, unresolved
+	// entries are dropped. without it they are dropped right away.
+	Owners Owners
+
+	mu    sync.Mutex
+	cache map[int32]owner
+}
+
+// Match reports whether e is kept, completing it with its user and priority
+// when the filter needs them.
+func (f *Filter) Match(ctx context.Context, e *Entry) bool {
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, e.Status) {
+		return false
+	}
+	if len(f.Users) == 0 && len(f.Priorities) == 0 {
+		return true
+	}
+	if (e.UserID == 0 || e.Priority == "") && e.SmsID != 0 {
+		o, ok := f.owner(ctx, e.SmsID)
+		if ok {
+			if e.UserID == 0 {
+				e.UserID = o.userID
+			}
+			if e.Priority == "" {
+				e.Priority = o.priority
+			}
+		}
+	}
+	if len(f.Users) > 0 && !slices.Contains(f.Users, e.UserID) {
+		return false
+	}
+	if len(f.Priorities) > 0 && !slices.Contains(f.Priorities, e.Priority) {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) owner(ctx context.Context, id int32) (owner, bool) {
+	if f.Owners == nil {
+		return owner{}, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if o, ok := f.cache[id]; ok {
+		return o, true
+	}
+	userID, err := f.Owners.GetSmsOwner(ctx, id)
+	if err != nil {
+		return owner{}, false
+	}
+	priority, err := f.Owners.GetSmsPriority(ctx, id)
+	if err != nil {
+		return owner{}, false
+	}
+	if f.cache == nil || len(f.cache) >= 10000 {
+		f.cache = map[int32]owner{}
+	}
+	o := owner{userID: userID, priority: priority}
+	f.cache[id] = o
+	return o, true
+}
+
+// ANSI colors of the kinds and statuses
+const (
+	reset  = "\x1b[0m"
+	dim    = "\x1b[2m"
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	blue   = "\x1b[34m"
+	cyan   = "\x1b[36m"
+)
+
+func colorOf(e Entry) string {
+	switch {
+	case e.Kind == KindError:
+		return red
+	case e.Status == status.Delivered.String():
+		return green
+	case e.Status == status.Failed.String(), e.Status == status.Expired.String(), e.Status == status.Cancelled.String():
+		return red
+	case e.Kind == KindRequest:
+		return cyan
+	case e.Kind == KindEvent:
+		return blue
+	default:
+		return yellow
+	}
+}
+
+// Format renders e as a line of the feed, colored for terminals when color is set.
+func Format(e Entry, color bool) string {
+	field := func(s string, width int, c string) string {
+		s = fmt.Sprintf("%-*s", width, s)
+		if color && c != "" {
+			return c + s + reset
+		}
+		return s
+	}
+	id, user := "-", "-"
+	if e.SmsID != 0 {
+		id = fmt.Sprint(e.SmsID)
+	}
+	if e.UserID != 0 {
+		user = fmt.Sprint(e.UserID)
+	}
+	priority := e.Priority
+	if priority == "" {
+		priority = "-"
+	}
+	state := e.Status
+	if state == "" {
+		state = "-"
+	}
+	line := strings.Join([]string{
+		field(e.Time.Local().Format("15:04:05.000"), 12, dim),
+		field(strings.ToUpper(e.Kind), 7, colorOf(e)),
+		field("sms "+id, 12, ""),
+		field("user "+user, 10, ""),
+		field(priority, 7, ""),
+		field(state, 10, colorOf(e)),
+		e.Detail,
+	}, " ")
+	return strings.TrimRight(line, " ")
+}
@@ -0,0 +1,147 @@
+package tail_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/tail"
+)
+
+type fakeOwners struct {
+	calls int
+}
+
+func (f *fakeOwners) GetSmsOwner(ctx context.Context, id int32) (int32, error) {
+	f.calls++
+	if id == 404 {
+		return 0, errors.New("no rows")
+	}
+	return 3, nil
+}
+
+func (f *fakeOwners) GetSmsPriority(ctx context.Context, id int32) (string, error) {
+	return "express", nil
+}
+
+var _ = Describe("Parse", func() {
+	created := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+
+	It("should parse requests with their user and a masked recipient", func() {
+		e, err := tail.Parse(&nats.Msg{
+			Subject: "sms.ex.send.request",
+			Header:  events.Header("0a1b", "key:7", "req-1", created),
+			Data:    []byte(`{"user_id":3,"phone_number_id":1,"to_phone_number":"+4912345678","status":"accepted"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Kind).To(Equal(tail.KindRequest))
+		Expect(e.Time).To(Equal(created))
+		Expect(e.UserID).To(BeEquivalentTo(3))
+		Expect(e.Priority).To(Equal("express"))
+		Expect(e.Status).To(Equal("accepted"))
+		Expect(e.Detail).To(Equal("to=+49*****678 from=1 actor=key:7 request=req-1"))
+	})
+
+	It("should parse status updates", func() {
+		e, err := tail.Parse(&nats.Msg{
+			Subject: "sms.send.status",
+			Data:    []byte(`{"id":42,"status":"failed","provider":"twilio","reason":"unreachable"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Kind).To(Equal(tail.KindStatus))
+		Expect(e.SmsID).To(BeEquivalentTo(42))
+		Expect(e.UserID).To(BeZero())
+		Expect(e.Priority).To(Equal("normal"))
+		Expect(e.Status).To(Equal("failed"))
+		Expect(e.Detail).To(Equal("provider=twilio reason=unreachable"))
+	})
+
+	It("should parse lifecycle events", func() {
+		e, err := tail.Parse(&nats.Msg{
+			Subject: "sms.events.delivered",
+			Data:    []byte(`{"id":"42-delivered-1","sms_id":42,"event":"delivered","actor":"worker","metadata":{},"occurred_at":"2026-10-17T09:30:00Z"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Kind).To(Equal(tail.KindEvent))
+		Expect(e.Time).To(Equal(created))
+		Expect(e.Priority).To(BeEmpty())
+		Expect(e.Status).To(Equal("delivered"))
+	})
+
+	It("should parse worker failures", func() {
+		e, err := tail.Parse(&nats.Msg{
+			Subject: "sms.ex.send.error",
+			Data:    []byte(`{"message_id":"0a1b","sms_id":42,"priority":"express","stage":"store","class":"database","error":"connection refused","attempt":2,"occurred_at":"2026-10-17T09:30:00Z"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Kind).To(Equal(tail.KindError))
+		Expect(e.Time).To(Equal(created))
+		Expect(e.SmsID).To(BeEquivalentTo(42))
+		Expect(e.Priority).To(Equal("express"))
+		Expect(e.Detail).To(Equal("stage=store class=database attempt=2 error=connection refused"))
+	})
+
+	It("should refuse unknown subjects", func() {
+		_, err := tail.Parse(&nats.Msg{Subject: "sms.submit", Data: []byte(`{}`)})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Filter", func() {
+	ctx := context.Background()
+
+	It("should keep everything without criteria", func() {
+		f := &tail.Filter{}
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindStatus, SmsID: 1})).To(BeTrue())
+	})
+
+	It("should filter by status", func() {
+		f := &tail.Filter{Statuses: []string{"failed"}}
+		Expect(f.Match(ctx, &tail.Entry{Status: "failed"})).To(BeTrue())
+		Expect(f.Match(ctx, &tail.Entry{Status: "delivered"})).To(BeFalse())
+	})
+
+	It("should look up the owners of sms once", func() {
+		owners := &fakeOwners{}
+		f := &tail.Filter{Users: []int32{3}, Priorities: []string{"express"}, Owners: owners}
+		e := &tail.Entry{Kind: tail.KindEvent, SmsID: 42}
+		Expect(f.Match(ctx, e)).To(BeTrue())
+		Expect(e.UserID).To(BeEquivalentTo(3))
+		Expect(e.Priority).To(Equal("express"))
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindStatus, SmsID: 42, Priority: "express"})).To(BeTrue())
+		Expect(owners.calls).To(Equal(1))
+
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindStatus, SmsID: 404})).To(BeFalse())
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindRequest, UserID: 4, Priority: "express"})).To(BeFalse())
+	})
+
+	It("should drop entries it can't resolve without owners", func() {
+		f := &tail.Filter{Users: []int32{3}}
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindStatus, SmsID: 42})).To(BeFalse())
+		Expect(f.Match(ctx, &tail.Entry{Kind: tail.KindRequest, UserID: 3})).To(BeTrue())
+	})
+})
+
+var _ = Describe("Format", func() {
+	e := tail.Entry{
+		Time:     time.Date(2026, 10, 17, 9, 30, 0, 0, time.Local),
+		Kind:     tail.KindStatus,
+		SmsID:    42,
+		Priority: "normal",
+		Status:   "delivered",
+		Detail:   "provider=twilio",
+	}
+
+	It("should align the fields", func() {
+		Expect(tail.Format(e, false)).To(Equal("09:30:00.000 STATUS  sms 42       user -     normal  delivered  provider=twilio"))
+	})
+
+	It("should color for terminals", func() {
+		Expect(tail.Format(e, true)).To(ContainSubstring("\x1b[32mdelivered \x1b[0m"))
+	})
+})
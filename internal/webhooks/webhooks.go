@@ -0,0 +1,139 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// event types
+const (
+	EventSmsStatus = "sms.status"
+)
+
+// headers sent with every delivery
+const (
+	HeaderEvent   = "Sms-Webhook-Event"
+	HeaderEventID = "Sms-Webhook-Id"
+	// HeaderTimestamp is the unix time the delivery was signed at
+	HeaderTimestamp = "Sms-Webhook-Timestamp"
+	// HeaderSignature holds one v1=<hex> signature per valid secret, comma separated
+	HeaderSignature = "Sms-Webhook-Signature"
+)
+
+// Event is the body posted to webhook endpoints.
+type Event struct {
+	// ID is the same for every delivery of one event, receivers can dedupe on it
+	ID         string    `json:"id"`
+	Type       string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// SmsStatus is the data of EventSmsStatus.
+type SmsStatus struct {
+	SmsID    int32  `json:"sms_id"`
+	Status   string `json:"status"`
+	From     string `json:"from"`
+	Provider string `json:"provider,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// FailureReason is the normalized reason of failed and expired sms, see
+	// status.FailureReason
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// Delivery is published on DeliverSubject to hand one event for one endpoint to the dispatcher.
+type Delivery struct {
+	WebhookID int32           `json:"webhook_id"`
+	URL       string          `json:"url"`
+	EventID   string          `json:"event_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// DeliverSubject is where deliveries are queued.
+var DeliverSubject = WebhooksDeliver()
+
+// StreamConfig is the work queue of webhook deliveries. deliveries wait in it
+// between retries, see RetryPolicy.
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        WEBHOOKS_CONSUMER_NAME,
+		Description: "work queue for webhook deliveries and their retries",
+		Subjects:    []string{DeliverSubject},
+		Retention:   jetstream.WorkQueuePolicy,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// RetryPolicy decides how often and when failed deliveries are retried.
+type RetryPolicy struct {
+	// MaxAttempts moves a delivery to the dead letters once it failed that often
+	MaxAttempts uint64
+	// Initial is the delay after the first failure. it doubles with every attempt up to Max
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// RetryPolicyFromViper reads the policy from worker.webhooks.
+func RetryPolicyFromViper() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: viper.GetUint64("worker.webhooks.maxattempts"),
+		Initial:     viper.GetDuration("worker.webhooks.backoff.initial"),
+		Max:         viper.GetDuration("worker.webhooks.backoff.max"),
+	}
+}
+
+// Backoff is the delay after the given failed attempt, counting from 1.
+func (p RetryPolicy) Backoff(attempt uint64) time.Duration {
+	d := p.Initial
+	for i := uint64(1); i < attempt && (p.Max <= 0 || d < p.Max); i++ {
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// Exhausted reports whether a delivery that failed on attempt goes to the dead letters.
+func (p RetryPolicy) Exhausted(attempt uint64) bool {
+	return attempt >= p.MaxAttempts
+}
+
+// Post delivers d, signed with every one of secrets. endpoints must answer 2xx, anything else is an error.
+func Post(ctx context.Context, client *http.Client, d Delivery, secrets ...string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sms-webhooks")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderEventID, d.EventID)
+	now := time.Now()
+	if sig := Sign(now, d.Payload, secrets...); sig != "" {
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(HeaderSignature, sig)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %s", res.Status)
+	}
+	return nil
+}
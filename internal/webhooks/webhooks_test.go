@@ -0,0 +1,114 @@
+package webhooks_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/webhooks"
+)
+
+var _ = Describe("Webhooks", func() {
+	policy := webhooks.RetryPolicy{MaxAttempts: 5, Initial: time.Second, Max: 5 * time.Second}
+
+	It("doubles the backoff up to the max", func() {
+		Expect(policy.Backoff(1)).To(Equal(time.Second))
+		Expect(policy.Backoff(2)).To(Equal(2 * time.Second))
+		Expect(policy.Backoff(3)).To(Equal(4 * time.Second))
+		Expect(policy.Backoff(4)).To(Equal(5 * time.Second))
+		Expect(policy.Backoff(200)).To(Equal(5 * time.Second))
+	})
+
+	It("gives up after the max attempts", func() {
+		Expect(policy.Exhausted(4)).To(BeFalse())
+		Expect(policy.Exhausted(5)).To(BeTrue())
+	})
+
+	Context("Signatures", func() {
+		body := []byte(`{"id":"sms-1-delivered"}`)
+		at := time.Unix(1717236003, 0)
+		ts := "1717236003"
+
+		It("signs the timestamp and the body", func() {
+			sig := webhooks.Sign(at, body, "secret")
+			Expect(sig).To(HavePrefix("v1="))
+			Expect(webhooks.Verify("secret", ts, sig, body, time.Minute, at)).To(Succeed())
+			Expect(webhooks.Verify("secret", ts, sig, []byte(`{}`), time.Minute, at)).To(MatchError(webhooks.ErrSignature))
+			Expect(webhooks.Verify("other", ts, sig, body, time.Minute, at)).To(MatchError(webhooks.ErrSignature))
+			Expect(webhooks.Verify("secret", "1717236004", sig, body, time.Minute, at)).To(MatchError(webhooks.ErrSignature))
+		})
+
+		It("skips empty secrets", func() {
+			Expect(webhooks.Sign(at, body, "", "secret")).NotTo(ContainSubstring(","))
+			Expect(webhooks.Sign(at, body, "")).To(BeEmpty())
+		})
+
+		It("rejects old and future timestamps", func() {
+			sig := webhooks.Sign(at, body, "secret")
+			Expect(webhooks.Verify("secret", ts, sig, body, time.Minute, at.Add(2*time.Minute))).To(MatchError(webhooks.ErrTimestamp))
+			Expect(webhooks.Verify("secret", ts, sig, body, time.Minute, at.Add(-2*time.Minute))).To(MatchError(webhooks.ErrTimestamp))
+			Expect(webhooks.Verify("secret", "yesterday", sig, body, time.Minute, at)).To(MatchError(webhooks.ErrTimestamp))
+		})
+
+		It("creates distinct secrets", func() {
+			a, err := webhooks.NewSecret()
+			Expect(err).NotTo(HaveOccurred())
+			b, err := webhooks.NewSecret()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(a).To(HavePrefix("whsec_"))
+			Expect(len(a)).To(BeNumerically("<=", 64))
+			Expect(a).NotTo(Equal(b))
+		})
+	})
+
+	Context("Post", func() {
+		d := webhooks.Delivery{
+			EventID: "sms-1-delivered",
+			Event:   webhooks.EventSmsStatus,
+			Payload: []byte(`{"id":"sms-1-delivered"}`),
+		}
+
+		It("posts the payload with the event headers", func() {
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			DeferCleanup(srv.Close)
+
+			d.URL = srv.URL
+			Expect(webhooks.Post(context.Background(), srv.Client(), d)).To(Succeed())
+			Expect(header.Get(webhooks.HeaderEvent)).To(Equal("sms.status"))
+			Expect(header.Get(webhooks.HeaderEventID)).To(Equal("sms-1-delivered"))
+			Expect(header.Get(webhooks.HeaderSignature)).To(BeEmpty())
+		})
+
+		It("signs the payload with every secret", func() {
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+			}))
+			DeferCleanup(srv.Close)
+
+			d.URL = srv.URL
+			Expect(webhooks.Post(context.Background(), srv.Client(), d, "new", "old")).To(Succeed())
+			ts, sig := header.Get(webhooks.HeaderTimestamp), header.Get(webhooks.HeaderSignature)
+			Expect(webhooks.Verify("new", ts, sig, d.Payload, time.Minute, time.Now())).To(Succeed())
+			Expect(webhooks.Verify("old", ts, sig, d.Payload, time.Minute, time.Now())).To(Succeed())
+		})
+
+		It("fails on answers other than 2xx", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			DeferCleanup(srv.Close)
+
+			d.URL = srv.URL
+			Expect(webhooks.Post(context.Background(), srv.Client(), d)).To(MatchError(ContainSubstring("502")))
+		})
+	})
+})
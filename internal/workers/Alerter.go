@@ -0,0 +1,224 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/alerts"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/email"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// UsageAlert is the data of the usage.alert events posted to the webhooks of the user.
+type UsageAlert struct {
+	AlertID   int32   `json:"alert_id"`
+	RuleID    int32   `json:"rule_id"`
+	UserID    int32   `json:"user_id"`
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold"`
+	Detail    string  `json:"detail"`
+}
+
+// Alerter evaluates the usage alert rules of the users and fires the tripped ones
+// at the webhooks of their user and at their email. every worker may run one: a
+// rule is only fired by the worker whose update claims it.
+type Alerter struct {
+	pool     *pgxpool.Pool
+	sp       *nats.Publisher
+	mail     email.Sender
+	cfg      alerts.Config
+	interval time.Duration
+}
+
+func NewAlerter(ctx context.Context, nc *natsgo.Conn, pool *pgxpool.Pool, mail email.Sender) (*Alerter, error) {
+	sp, err := nats.NewPublisher(ctx, nc,
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		nats.WithStreams(webhooks.StreamConfig()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	interval := viper.GetDuration("worker.alerts.interval")
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &Alerter{
+		pool:     pool,
+		sp:       sp,
+		mail:     mail,
+		cfg:      alerts.ConfigFromViper(),
+		interval: interval,
+	}, nil
+}
+
+func (a *Alerter) Start(ctx context.Context) error {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			err := a.Run(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logrus.Errorf("failed to evaluate alert rules: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Run evaluates every rule against what its user sent before now.
+func (a *Alerter) Run(ctx context.Context, now time.Time) error {
+	q := sqlc.New(a.pool)
+	rules, err := q.ListAlertRules(ctx)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	day := now.Add(-24 * time.Hour)
+	since := now.Add(-a.cfg.Window)
+	baseline := since.Add(-a.cfg.Baseline)
+	earliest := day
+	if baseline.Before(day) {
+		earliest = baseline
+	}
+	rows, err := q.GetAlertUsage(ctx, sqlc.GetAlertUsageParams{
+		Day:      pgtype.Timestamp{Time: day.UTC(), Valid: true},
+		Since:    pgtype.Timestamp{Time: since.UTC(), Valid: true},
+		Baseline: pgtype.Timestamp{Time: baseline.UTC(), Valid: true},
+		Earliest: pgtype.Timestamp{Time: earliest.UTC(), Valid: true},
+		Until:    pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	usage := make(map[int32]alerts.Usage, len(rows))
+	for _, row := range rows {
+		usage[row.UserID] = alerts.Usage{
+			Spent:    row.Spent,
+			Sent:     int(row.Sent),
+			Failed:   int(row.Failed),
+			Baseline: int(row.Baseline),
+		}
+	}
+
+	for _, rule := range rules {
+		detail, ok := a.cfg.Evaluate(alerts.Rule{Kind: rule.Kind, Threshold: rule.Threshold}, usage[rule.UserID])
+		if !ok {
+			continue
+		}
+		err = a.fire(ctx, q, rule, detail, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fire records the alert of rule unless it fired within the cooldown, then posts
+// it to the webhooks of its user and mails it to the email of the rule. both are
+// tried once, the alert stays listed under /alerts either way.
+func (a *Alerter) fire(ctx context.Context, q *sqlc.Queries, rule sqlc.AlertRule, detail string, now time.Time) error {
+	_, err := q.ClaimAlertRule(ctx, sqlc.ClaimAlertRuleParams{
+		FiredAt:  pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		ID:       rule.ID,
+		Cooldown: pgtype.Timestamp{Time: now.Add(-a.cfg.Cooldown).UTC(), Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	alert, err := q.AddAlert(ctx, sqlc.AddAlertParams{
+		RuleID:    pgtype.Int4{Int32: rule.ID, Valid: true},
+		UserID:    rule.UserID,
+		Kind:      rule.Kind,
+		Threshold: rule.Threshold,
+		Detail:    detail,
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("alert rule %d of user %d fired: %s", rule.ID, rule.UserID, detail)
+
+	err = a.postAlert(ctx, q, alert)
+	if err != nil {
+		logrus.Errorf("failed to queue the webhooks of alert %d: %s", alert.ID, err)
+	}
+	if rule.Email.Valid {
+		err = a.mail.Send(ctx, email.Message{
+			From:    viper.GetString("email.from"),
+			To:      rule.Email.String,
+			Subject: fmt.Sprintf("Usage alert: %s", alert.Kind),
+			Body:    detail,
+		})
+		if err != nil {
+			logrus.Errorf("failed to mail alert %d: %s", alert.ID, err)
+		}
+	}
+	return nil
+}
+
+// postAlert queues a delivery of alert for every webhook of its user, the webhook
+// worker signs and retries them like the status updates.
+func (a *Alerter) postAlert(ctx context.Context, q *sqlc.Queries, alert sqlc.Alert) error {
+	hooks, err := q.GetWebhooks(ctx, alert.UserID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	event := webhooks.Event{
+		ID:         fmt.Sprintf("alert-%d", alert.ID),
+		Type:       alerts.EventAlert,
+		OccurredAt: alert.CreatedAt.Time.UTC(),
+		Data: UsageAlert{
+			AlertID:   alert.ID,
+			RuleID:    alert.RuleID.Int32,
+			UserID:    alert.UserID,
+			Kind:      alert.Kind,
+			Threshold: alert.Threshold,
+			Detail:    alert.Detail,
+		},
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		data, err := json.Marshal(webhooks.Delivery{
+			WebhookID: hook.ID,
+			URL:       hook.Url,
+			EventID:   event.ID,
+			Event:     event.Type,
+			Payload:   payload,
+		})
+		if err != nil {
+			return err
+		}
+		msgID := fmt.Sprintf("webhook-%d-%s", hook.ID, event.ID)
+		_, err = a.sp.PublishMsg(ctx, &natsgo.Msg{
+			Subject: webhooks.DeliverSubject,
+			Data:    data,
+			Header:  events.Header(msgID, workerActor(), "", time.Now()),
+		}, jetstream.WithMsgID(msgID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
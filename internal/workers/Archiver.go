@@ -0,0 +1,85 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/archive"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Archiver copies the sms of every day to the storage as parquet, once
+// worker.archive.delay passed since the day ended so most delivery reports are in.
+// every worker may run one, archiving a day twice writes the same files.
+type Archiver struct {
+	pool     *pgxpool.Pool
+	archiver *archive.Archiver
+	interval time.Duration
+	delay    time.Duration
+}
+
+func NewArchiver(pool *pgxpool.Pool, store storage.Storage) *Archiver {
+	interval := viper.GetDuration("worker.archive.interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Archiver{
+		pool:     pool,
+		archiver: archive.NewArchiver(sqlc.New(pool), store, viper.GetString("worker.archive.prefix"), viper.GetInt32("worker.archive.batchsize")),
+		interval: interval,
+		delay:    viper.GetDuration("worker.archive.delay"),
+	}
+}
+
+func (a *Archiver) Start(ctx context.Context) error {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			err := a.Run(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logrus.Errorf("failed to archive sms: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Run archives the days after the last archived one that ended delay before now.
+func (a *Archiver) Run(ctx context.Context, now time.Time) error {
+	q := sqlc.New(a.pool)
+	start, err := q.GetArchiveStart(ctx)
+	if err != nil {
+		return err
+	}
+	if !start.Valid {
+		// no sms yet
+		return nil
+	}
+	for day := start.Time; !day.AddDate(0, 0, 1).Add(a.delay).After(now.UTC()); day = day.AddDate(0, 0, 1) {
+		rows, files, err := a.archiver.Day(ctx, day)
+		if err != nil {
+			return err
+		}
+		err = q.AddSmsArchive(ctx, sqlc.AddSmsArchiveParams{
+			Day:       pgtype.Date{Time: day, Valid: true},
+			RowCount:  rows,
+			FileCount: files,
+		})
+		if err != nil {
+			return err
+		}
+		logrus.Infof("archived %d sms of %s to %d files", rows, day.Format(time.DateOnly), files)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/eventsink"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// EventSink copies the published lifecycle events of sms into an analytics
+// store, see eventsink.Buffer. every worker may run one, they share the consumer.
+type EventSink struct {
+	*nats.Consumer
+	buffer *eventsink.Buffer
+}
+
+func NewEventSink(ctx context.Context, natsAddress string, w eventsink.Writer) (*EventSink, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	size := viper.GetInt("events.sink.batchsize")
+	interval := viper.GetDuration("events.sink.flushinterval")
+	worker := &EventSink{
+		Consumer: c,
+		buffer:   eventsink.NewBuffer(w, size, interval, viper.GetDuration("events.sink.retry")),
+	}
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: events.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:        EVENTS_SINK_CONSUMER_NAME,
+				Durable:     EVENTS_SINK_CONSUMER_NAME,
+				Description: "copies sms events to the analytics store",
+				// buffered events wait for their batch unacked
+				AckWait:       2*interval + time.Minute,
+				MaxAckPending: 2 * size,
+			},
+		},
+		Handlers: map[string]nats.Handler{
+			EVENTS_SINK_CONSUMER_NAME: worker.buffer.Add,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+// Start consumes the events and writes them until ctx is done. the messages are
+// settled by the buffer once their batch is written, not by the handler, so the
+// worker middlewares settling unsettled messages aren't used.
+func (e *EventSink) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("EventSinkConsumerError: %s\n", err)
+	}
+	go e.buffer.Run(ctx)
+	return e.StartConsumers(ctx, nil, errHandlerOpt)
+}
@@ -0,0 +1,282 @@
+package workers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/jobs"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var exportHeader = []string{"id", "to_phone_number", "message", "status", "category", "priority", "cost", "delivered_at", "expires_at", "message_id"}
+
+// Export runs export jobs: it dumps the selected messages of a user into a CSV file
+// in batches, reporting progress after each batch, and uploads it to the storage.
+type Export struct {
+	*nats.Consumer
+	*sqlc.Queries
+	store     storage.Storage
+	batchSize int32
+}
+
+func NewExport(ctx context.Context, natsAddress string, pool *pgxpool.Pool, store storage.Storage) (*Export, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &Export{
+		Consumer:  c,
+		Queries:   sqlc.New(pool),
+		store:     store,
+		batchSize: viper.GetInt32("jobs.export.batchsize"),
+	}
+	if worker.batchSize <= 0 {
+		worker.batchSize = 1000
+	}
+
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: jobs.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:          JOBS_CONSUMER_NAME,
+				Durable:       JOBS_CONSUMER_NAME,
+				Description:   "runs export jobs",
+				FilterSubject: subjects.Must(jobs.RequestSubject(jobs.KindExport)),
+				// batches extend the deadline with InProgress
+				AckWait: time.Minute,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (e *Export) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("ExportConsumerError: %s\n", err)
+	}
+	e.Use(middlewares()...)
+	return e.StartConsumers(ctx, e.handler, errHandlerOpt)
+}
+
+func (e *Export) handler(ctx context.Context, msg jetstream.Msg) {
+	var req jobs.Request
+	err := json.Unmarshal(msg.Data(), &req)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+
+	job, err := e.StartJob(ctx, req.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// already finished or deleted
+			msg.Ack()
+			return
+		}
+		logrus.Errorf("failed to start job %d: %s", req.ID, err)
+		msg.NakWithDelay(time.Second)
+		return
+	}
+	publishProgress(e.Conn, job.ID, jobs.StatusRunning, 0, 0)
+
+	path, rows, err := e.export(ctx, msg, job)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutting down, let another worker restart the job
+			msg.Nak()
+			return
+		}
+		logrus.Errorf("export job %d failed: %s", job.ID, err)
+		err = e.FailJob(ctx, sqlc.FailJobParams{
+			Error: pgtype.Text{String: err.Error(), Valid: true},
+			ID:    job.ID,
+		})
+		if err != nil {
+			logrus.Errorf("failed to mark job %d as failed: %s", job.ID, err)
+			msg.NakWithDelay(time.Second)
+			return
+		}
+		publishProgress(e.Conn, job.ID, jobs.StatusFailed, 0, 0)
+		msg.Ack()
+		return
+	}
+
+	err = e.FinishJob(ctx, sqlc.FinishJobParams{
+		RowCount: rows,
+		Result:   pgtype.Text{String: path, Valid: true},
+		ID:       job.ID,
+	})
+	if err != nil {
+		logrus.Errorf("failed to mark job %d as done: %s", job.ID, err)
+		msg.NakWithDelay(time.Second)
+		return
+	}
+	publishProgress(e.Conn, job.ID, jobs.StatusDone, 100, rows)
+	msg.Ack()
+}
+
+func (e *Export) export(ctx context.Context, msg jetstream.Msg, job sqlc.Job) (string, int64, error) {
+	var params jobs.ExportParams
+	err := json.Unmarshal(job.Params, &params)
+	if err != nil {
+		return "", 0, err
+	}
+	from := pgtype.Timestamp{Time: params.From.UTC(), Valid: true}
+	to := pgtype.Timestamp{Time: params.To.UTC(), Valid: true}
+
+	total, err := e.CountSmsForExport(ctx, sqlc.CountSmsForExportParams{
+		UserID:        params.UserID,
+		DeliveredAt:   from,
+		DeliveredAt_2: to,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	f, err := os.CreateTemp("", "export-*.csv")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	err = w.Write(exportHeader)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var after int32
+	var rows int64
+	for {
+		batch, err := e.GetSmsForExport(ctx, sqlc.GetSmsForExportParams{
+			UserID:        params.UserID,
+			DeliveredAt:   from,
+			DeliveredAt_2: to,
+			ID:            after,
+			Limit:         e.batchSize,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		for _, sms := range batch {
+			if params.Masked {
+				sms.ToPhoneNumber, sms.Message = mask.Phone(sms.ToPhoneNumber), mask.Body(sms.Message)
+			}
+			err = w.Write(exportRecord(sms))
+			if err != nil {
+				return "", 0, err
+			}
+		}
+		rows += int64(len(batch))
+		if int32(len(batch)) < e.batchSize {
+			break
+		}
+		after = batch[len(batch)-1].ID
+
+		progress := int32(99)
+		if total > 0 && rows < total {
+			progress = int32(rows * 100 / total)
+		}
+		err = e.UpdateJobProgress(ctx, sqlc.UpdateJobProgressParams{
+			Progress: progress,
+			RowCount: rows,
+			ID:       job.ID,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		publishProgress(e.Conn, job.ID, jobs.StatusRunning, progress, rows)
+		msg.InProgress()
+	}
+
+	w.Flush()
+	err = w.Error()
+	if err != nil {
+		return "", 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+	key := ExportKey(job.ID)
+	err = e.store.Put(ctx, key, f, "text/csv")
+	if err != nil {
+		return "", 0, err
+	}
+	return key, rows, nil
+}
+
+// ExportKey is where the result of export job id is stored.
+func ExportKey(id int32) string {
+	return fmt.Sprintf("exports/export-%d.csv", id)
+}
+
+func exportRecord(sms sqlc.Sm) []string {
+	cost, _ := sms.Cost.Float64Value()
+	expiresAt := ""
+	if sms.ExpiresAt.Valid {
+		expiresAt = sms.ExpiresAt.Time.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		strconv.Itoa(int(sms.ID)),
+		sms.ToPhoneNumber,
+		sms.Message,
+		sms.Status,
+		sms.Category,
+		sms.Priority,
+		strconv.FormatFloat(cost.Float64, 'f', 2, 64),
+		sms.DeliveredAt.Time.UTC().Format(time.RFC3339),
+		expiresAt,
+		sms.MessageID.String(),
+	}
+}
+
+// publishProgress informs subscribers of jobs.ProgressSubject. progress is best effort,
+// the jobs table stays the source of truth.
+func publishProgress(nc *natsgo.Conn, id int32, status string, progress int32, rows int64) {
+	data, err := json.Marshal(jobs.Progress{
+		ID:       id,
+		Status:   status,
+		Progress: progress,
+		Rows:     rows,
+	})
+	if err != nil {
+		return
+	}
+	err = nc.Publish(jobs.ProgressSubject(id), data)
+	if err != nil {
+		logrus.Warnf("failed to publish progress of job %d: %s", id, err)
+	}
+}
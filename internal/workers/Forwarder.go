@@ -0,0 +1,184 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/forwards"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// laneSize is how many events of one user wait for their target, the next ones
+// are retried later
+const laneSize = 64
+
+// Forwarder forwards the delivery events of users to the nats servers they set up,
+// see forwards.Targets. every user gets a lane of their own, so a target that is
+// slow or down only holds back the events of its user. failed events are retried
+// with backoff and dropped once their attempts are used up.
+type Forwarder struct {
+	*nats.Consumer
+	targets *forwards.Targets
+	policy  webhooks.RetryPolicy
+
+	mu    sync.Mutex
+	lanes map[int32]chan forwarded
+}
+
+type forwarded struct {
+	msg jetstream.Msg
+	f   forwards.Forward
+}
+
+func NewForwarder(ctx context.Context, natsAddress string, pool *pgxpool.Pool, env *secrets.Envelope) (*Forwarder, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := viper.GetDuration("worker.forwards.timeout")
+	worker := &Forwarder{
+		Consumer: c,
+		targets:  forwards.NewTargets(sqlc.New(pool), env, timeout),
+		policy: webhooks.RetryPolicy{
+			MaxAttempts: viper.GetUint64("worker.forwards.maxattempts"),
+			Initial:     viper.GetDuration("worker.forwards.backoff.initial"),
+			Max:         viper.GetDuration("worker.forwards.backoff.max"),
+		},
+		lanes: make(map[int32]chan forwarded),
+	}
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: forwards.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:        FORWARDS_CONSUMER_NAME,
+				Durable:     FORWARDS_CONSUMER_NAME,
+				Description: "forwards events to the nats servers of users",
+				// events wait in their lane unacked
+				AckWait: laneSize*2*timeout + time.Minute,
+			},
+		},
+		Handlers: map[string]nats.Handler{
+			FORWARDS_CONSUMER_NAME: worker.dispatch,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+// Start forwards events until ctx is done. the messages are settled by the lanes,
+// not by the handler, so the worker middlewares settling unsettled messages aren't
+// used.
+func (w *Forwarder) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("ForwarderConsumerError: %s\n", err)
+	}
+	return w.StartConsumers(ctx, nil, errHandlerOpt)
+}
+
+// Close stops consuming and closes the connections to the targets.
+func (w *Forwarder) Close() error {
+	err := w.Consumer.Close()
+	w.targets.Close()
+	return err
+}
+
+// dispatch hands an event to the lane of its user, or retries it later when the
+// lane is full.
+func (w *Forwarder) dispatch(ctx context.Context, msg jetstream.Msg) {
+	var f forwards.Forward
+	err := json.Unmarshal(msg.Data(), &f)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	select {
+	case w.lane(ctx, f.UserID) <- forwarded{msg: msg, f: f}:
+	default:
+		err = msg.NakWithDelay(w.policy.Backoff(1))
+		if err != nil {
+			logrus.Errorf("failed to NAK msg: %s\n", err.Error())
+		}
+	}
+}
+
+// lane returns the lane of userID, starting it the first time. lanes run until
+// ctx is done.
+func (w *Forwarder) lane(ctx context.Context, userID int32) chan forwarded {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lane, ok := w.lanes[userID]
+	if ok {
+		return lane
+	}
+	lane = make(chan forwarded, laneSize)
+	w.lanes[userID] = lane
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case next := <-lane:
+				w.forward(ctx, next.msg, next.f)
+			}
+		}
+	}()
+	return lane
+}
+
+// forward publishes f on the target of its user. it is NAKed with the backoff of
+// its attempt when the target fails, and dropped once the attempts are used up or
+// the user deleted the target.
+func (w *Forwarder) forward(ctx context.Context, msg jetstream.Msg, f forwards.Forward) {
+	org := metrics.Org(f.UserID)
+	err := w.targets.Publish(ctx, f)
+	if err == nil {
+		metrics.ForwardedEvents.WithLabelValues(org, "forwarded").Inc()
+		msg.DoubleAck(ctx)
+		return
+	}
+	if errors.Is(err, forwards.ErrNoTarget) {
+		metrics.ForwardedEvents.WithLabelValues(org, "dropped").Inc()
+		msg.TermWithReason(err.Error())
+		return
+	}
+	var attempt uint64 = 1
+	if md, err := msg.Metadata(); err == nil {
+		attempt = md.NumDelivered
+	}
+	if !w.policy.Exhausted(attempt) {
+		delay := w.policy.Backoff(attempt)
+		logrus.Warnf("forwarding event %s of user %d attempt %d failed, retrying in %s: %s", f.EventID, f.UserID, attempt, delay, err)
+		metrics.ForwardedEvents.WithLabelValues(org, "retried").Inc()
+		nakErr := msg.NakWithDelay(delay)
+		if nakErr != nil {
+			logrus.Errorf("failed to NAK msg: %s\n", nakErr.Error())
+		}
+		return
+	}
+	logrus.Errorf("forwarding event %s of user %d failed after %d attempts: %s", f.EventID, f.UserID, attempt, err)
+	metrics.ForwardedEvents.WithLabelValues(org, "failed").Inc()
+	msg.TermWithReason(err.Error())
+}
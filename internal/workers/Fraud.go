@@ -0,0 +1,211 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/fraud"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// FraudAlert is the data of the fraud.alert events posted to worker.fraud.alerts.url.
+type FraudAlert struct {
+	AlertID  int32  `json:"alert_id"`
+	ApiKeyID int32  `json:"api_key_id"`
+	UserID   int32  `json:"user_id"`
+	Reason   string `json:"reason"`
+	Detail   string `json:"detail"`
+	Action   string `json:"action"`
+}
+
+// Fraud watches the sends of every API key and acts on the keys fraud.Config.Detect
+// flags. every worker may run one: a key is only acted on, and alerted about, by
+// the worker whose update restricts it.
+type Fraud struct {
+	pool     *pgxpool.Pool
+	cfg      fraud.Config
+	interval time.Duration
+	client   *http.Client
+	// alertURL receives the alerts when set, signed with alertSecret
+	alertURL    string
+	alertSecret string
+}
+
+func NewFraud(pool *pgxpool.Pool) *Fraud {
+	interval := viper.GetDuration("worker.fraud.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Fraud{
+		pool:        pool,
+		cfg:         fraud.ConfigFromViper(),
+		interval:    interval,
+		client:      &http.Client{Timeout: viper.GetDuration("worker.webhooks.timeout")},
+		alertURL:    viper.GetString("worker.fraud.alerts.url"),
+		alertSecret: viper.GetString("worker.fraud.alerts.secret"),
+	}
+}
+
+func (f *Fraud) Start(ctx context.Context) error {
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			err := f.Run(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logrus.Errorf("failed to check api keys for fraud: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Run checks the activity of every key within the window before now.
+func (f *Fraud) Run(ctx context.Context, now time.Time) error {
+	q := sqlc.New(f.pool)
+	since := now.Add(-f.cfg.Window)
+	rows, err := q.GetKeyActivity(ctx, sqlc.GetKeyActivityParams{
+		Since: pgtype.Timestamp{Time: since.UTC(), Valid: true},
+		Until: pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	baseline, err := q.GetKeySendCounts(ctx, sqlc.GetKeySendCountsParams{
+		Since: pgtype.Timestamp{Time: since.Add(-f.cfg.Baseline).UTC(), Valid: true},
+		Until: pgtype.Timestamp{Time: since.UTC(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	activity := make(map[int32]*fraud.Activity)
+	for _, row := range rows {
+		a, ok := activity[row.ApiKeyID]
+		if !ok {
+			a = &fraud.Activity{KeyID: row.ApiKeyID, UserID: row.UserID, Destinations: make(map[string]int)}
+			activity[row.ApiKeyID] = a
+		}
+		a.Sent += int(row.Sent)
+		a.Failed += int(row.Failed)
+		a.Destinations[row.ToPhoneNumber] += int(row.Sent)
+	}
+	for _, row := range baseline {
+		if a, ok := activity[row.ApiKeyID]; ok {
+			a.Baseline = int(row.Sent)
+		}
+	}
+
+	for _, a := range activity {
+		finding, ok := f.cfg.Detect(*a)
+		if !ok {
+			continue
+		}
+		err = f.act(ctx, q, finding, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// act restricts the flagged key as worker.fraud.action says, then records and
+// reports the alert. keys already restricted are left alone.
+func (f *Fraud) act(ctx context.Context, q *sqlc.Queries, finding fraud.Finding, now time.Time) error {
+	var err error
+	switch f.cfg.Action {
+	case fraud.ActionAlert:
+		// nothing changes on the key, so alert at most once per window
+		var alerted bool
+		alerted, err = q.HasFraudAlertSince(ctx, sqlc.HasFraudAlertSinceParams{
+			ApiKeyID:  finding.KeyID,
+			CreatedAt: pgtype.Timestamp{Time: now.Add(-f.cfg.Window).UTC(), Valid: true},
+		})
+		if err == nil && alerted {
+			return nil
+		}
+	case fraud.ActionSuspend:
+		_, err = q.SuspendApiKey(ctx, finding.KeyID)
+	case fraud.ActionThrottle:
+		_, err = q.ThrottleApiKey(ctx, sqlc.ThrottleApiKeyParams{
+			ThrottleLimit:  pgtype.Int4{Int32: int32(f.cfg.ThrottleLimit), Valid: true},
+			ThrottledUntil: pgtype.Timestamp{Time: now.Add(f.cfg.ThrottleFor).UTC(), Valid: true},
+			ID:             finding.KeyID,
+		})
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	alert, err := q.AddFraudAlert(ctx, sqlc.AddFraudAlertParams{
+		ApiKeyID: finding.KeyID,
+		UserID:   finding.UserID,
+		Reason:   finding.Reason,
+		Detail:   finding.Detail,
+		Action:   f.cfg.Action,
+	})
+	if err != nil {
+		return err
+	}
+	metrics.FraudAlerts.WithLabelValues(finding.Reason, f.cfg.Action).Inc()
+	logrus.Warnf("api key %d of user %d flagged for %s (%s): %s", finding.KeyID, finding.UserID, finding.Reason, finding.Detail, f.cfg.Action)
+	if f.alertURL == "" {
+		return nil
+	}
+	err = f.postAlert(ctx, alert)
+	if err != nil {
+		logrus.Errorf("failed to post fraud alert %d: %s", alert.ID, err)
+	}
+	return nil
+}
+
+// postAlert posts alert to worker.fraud.alerts.url in the webhook format. it is
+// tried once, the alert stays listed under /admin/fraud/alerts either way.
+func (f *Fraud) postAlert(ctx context.Context, alert sqlc.FraudAlert) error {
+	event := webhooks.Event{
+		ID:         fmt.Sprintf("fraud-alert-%d", alert.ID),
+		Type:       fraud.EventAlert,
+		OccurredAt: alert.CreatedAt.Time.UTC(),
+		Data: FraudAlert{
+			AlertID:  alert.ID,
+			ApiKeyID: alert.ApiKeyID,
+			UserID:   alert.UserID,
+			Reason:   alert.Reason,
+			Detail:   alert.Detail,
+			Action:   alert.Action,
+		},
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var secrets []string
+	if f.alertSecret != "" {
+		secrets = append(secrets, f.alertSecret)
+	}
+	return webhooks.Post(ctx, f.client, webhooks.Delivery{
+		URL:     f.alertURL,
+		EventID: event.ID,
+		Event:   event.Type,
+		Payload: payload,
+	}, secrets...)
+}
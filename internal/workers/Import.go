@@ -0,0 +1,350 @@
+package workers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/jobs"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/storage"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var importErrorsHeader = []string{"line", "external_id", "error"}
+
+var errNotUserNumber = errors.New("from is not a number of the user")
+
+// Import runs import jobs: it reads an uploaded file of historical messages and
+// copies the valid records into sms in batches, reporting progress after each
+// batch. records whose external id was imported for the user before are skipped, so
+// a failed import can simply be run again. rejected records are stored as a CSV,
+// the job's result.
+type Import struct {
+	*nats.Consumer
+	*sqlc.Queries
+	db        *pgxpool.Pool
+	store     storage.Storage
+	batchSize int
+}
+
+// importStats counts what happened to the records of an import.
+type importStats struct {
+	imported   int64
+	duplicates int64
+	rejected   int64
+}
+
+// countingReader counts the bytes of the upload read so far, for progress.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func NewImport(ctx context.Context, natsAddress string, pool *pgxpool.Pool, store storage.Storage) (*Import, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &Import{
+		Consumer:  c,
+		Queries:   sqlc.New(pool),
+		db:        pool,
+		store:     store,
+		batchSize: viper.GetInt("jobs.import.batchsize"),
+	}
+	if worker.batchSize <= 0 {
+		worker.batchSize = 1000
+	}
+
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: jobs.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:          IMPORT_CONSUMER_NAME,
+				Durable:       IMPORT_CONSUMER_NAME,
+				Description:   "runs import jobs",
+				FilterSubject: subjects.Must(jobs.RequestSubject(jobs.KindImport)),
+				// batches extend the deadline with InProgress
+				AckWait: time.Minute,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (i *Import) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("ImportConsumerError: %s\n", err)
+	}
+	i.Use(middlewares()...)
+	return i.StartConsumers(ctx, i.handler, errHandlerOpt)
+}
+
+func (i *Import) handler(ctx context.Context, msg jetstream.Msg) {
+	var req jobs.Request
+	err := json.Unmarshal(msg.Data(), &req)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+
+	job, err := i.StartJob(ctx, req.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// already finished or deleted
+			msg.Ack()
+			return
+		}
+		logrus.Errorf("failed to start job %d: %s", req.ID, err)
+		msg.NakWithDelay(time.Second)
+		return
+	}
+	publishProgress(i.Conn, job.ID, jobs.StatusRunning, 0, 0)
+
+	result, stats, err := i.run(ctx, msg, job)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutting down, the batches done so far are skipped when another worker restarts it
+			msg.Nak()
+			return
+		}
+		logrus.Errorf("import job %d failed: %s", job.ID, err)
+		err = i.FailJob(ctx, sqlc.FailJobParams{
+			Error: pgtype.Text{String: err.Error(), Valid: true},
+			ID:    job.ID,
+		})
+		if err != nil {
+			logrus.Errorf("failed to mark job %d as failed: %s", job.ID, err)
+			msg.NakWithDelay(time.Second)
+			return
+		}
+		publishProgress(i.Conn, job.ID, jobs.StatusFailed, 0, stats.imported)
+		msg.Ack()
+		return
+	}
+
+	err = i.FinishJob(ctx, sqlc.FinishJobParams{
+		RowCount: stats.imported,
+		Result:   pgtype.Text{String: result, Valid: result != ""},
+		ID:       job.ID,
+	})
+	if err != nil {
+		logrus.Errorf("failed to mark job %d as done: %s", job.ID, err)
+		msg.NakWithDelay(time.Second)
+		return
+	}
+	logrus.Infof("import job %d: %d imported, %d imported before, %d rejected",
+		job.ID, stats.imported, stats.duplicates, stats.rejected)
+	publishProgress(i.Conn, job.ID, jobs.StatusDone, 100, stats.imported)
+	msg.Ack()
+
+	err = i.store.Delete(ctx, jobs.ImportKey(job.ID))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		logrus.Warnf("failed to delete the upload of import job %d: %s", job.ID, err)
+	}
+}
+
+// run imports the upload of job and returns the key of the rejected records, empty
+// when there were none.
+func (i *Import) run(ctx context.Context, msg jetstream.Msg, job sqlc.Job) (string, importStats, error) {
+	var stats importStats
+	var params jobs.ImportParams
+	err := json.Unmarshal(job.Params, &params)
+	if err != nil {
+		return "", stats, err
+	}
+
+	numbers, err := i.GetPhoneNumbersByUserId(ctx, params.UserID)
+	if err != nil {
+		return "", stats, err
+	}
+	senders := make(map[string]int32, len(numbers))
+	for _, n := range numbers {
+		senders[phone.Normalize(n.PhoneNumber)] = n.ID
+	}
+
+	upload, err := i.store.Open(ctx, jobs.ImportKey(job.ID))
+	if err != nil {
+		return "", stats, err
+	}
+	defer upload.Close()
+	counted := &countingReader{r: upload}
+	r, err := jobs.NewImportReader(counted, params.Format)
+	if err != nil {
+		return "", stats, err
+	}
+
+	f, err := os.CreateTemp("", "import-errors-*.csv")
+	if err != nil {
+		return "", stats, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	rejects := csv.NewWriter(f)
+	err = rejects.Write(importErrorsHeader)
+	if err != nil {
+		return "", stats, err
+	}
+	reject := func(line int, rec jobs.ImportRecord, err error) error {
+		stats.rejected++
+		return rejects.Write([]string{strconv.Itoa(line), rec.ExternalID, err.Error()})
+	}
+
+	batch := make([]sqlc.AddImportRowsParams, 0, i.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := i.importBatch(ctx, job.ID, params.UserID, batch)
+		if err != nil {
+			return err
+		}
+		stats.imported += n
+		stats.duplicates += int64(len(batch)) - n
+		batch = batch[:0]
+
+		progress := int32(99)
+		if params.Size > 0 && counted.n < params.Size {
+			progress = int32(counted.n * 100 / params.Size)
+		}
+		err = i.UpdateJobProgress(ctx, sqlc.UpdateJobProgressParams{
+			Progress: progress,
+			RowCount: stats.imported,
+			ID:       job.ID,
+		})
+		if err != nil {
+			return err
+		}
+		publishProgress(i.Conn, job.ID, jobs.StatusRunning, progress, stats.imported)
+		msg.InProgress()
+		return nil
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		var recErr *jobs.RecordError
+		if errors.As(err, &recErr) {
+			err = reject(recErr.Line, rec, recErr.Err)
+			if err != nil {
+				return "", stats, err
+			}
+			continue
+		}
+		if err != nil {
+			return "", stats, err
+		}
+		from, ok := senders[phone.Normalize(rec.From)]
+		if !ok {
+			err = reject(r.Line(), rec, errNotUserNumber)
+			if err != nil {
+				return "", stats, err
+			}
+			continue
+		}
+		var cost pgtype.Numeric
+		err = cost.Scan(strconv.FormatFloat(rec.Cost, 'f', 2, 64))
+		if err != nil {
+			return "", stats, err
+		}
+		batch = append(batch, sqlc.AddImportRowsParams{
+			JobID:         job.ID,
+			ExternalID:    rec.ExternalID,
+			PhoneNumberID: from,
+			ToPhoneNumber: rec.To,
+			Message:       rec.Message,
+			Status:        rec.Status,
+			DeliveredAt:   pgtype.Timestamp{Time: rec.DeliveredAt.UTC(), Valid: true},
+			Cost:          cost,
+			Priority:      rec.Priority,
+			Category:      rec.Category,
+		})
+		if len(batch) == i.batchSize {
+			err = flush()
+			if err != nil {
+				return "", stats, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return "", stats, err
+	}
+
+	if stats.rejected == 0 {
+		return "", stats, nil
+	}
+	rejects.Flush()
+	err = rejects.Error()
+	if err != nil {
+		return "", stats, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", stats, err
+	}
+	key := jobs.ImportErrorsKey(job.ID)
+	err = i.store.Put(ctx, key, f, "text/csv")
+	if err != nil {
+		return "", stats, err
+	}
+	return key, stats, nil
+}
+
+// importBatch copies batch into the staging table and moves it to sms in one
+// transaction. it returns how many messages were new.
+func (i *Import) importBatch(ctx context.Context, jobID, userID int32, batch []sqlc.AddImportRowsParams) (int64, error) {
+	var n int64
+	err := db.WithTx(ctx, i.db, func(tx pgx.Tx) error {
+		q := i.WithTx(tx)
+		_, err := q.AddImportRows(ctx, batch)
+		if err != nil {
+			return err
+		}
+		n, err = q.MoveImportRows(ctx, sqlc.MoveImportRowsParams{
+			UserID: userID,
+			JobID:  jobID,
+		})
+		if err != nil {
+			return err
+		}
+		return q.ClearImportRows(ctx, jobID)
+	})
+	return n, err
+}
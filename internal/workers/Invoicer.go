@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Invoicer closes the previous month for every user that was charged in it.
+// every worker may run one, invoices are only created once per user and month.
+type Invoicer struct {
+	pool     *pgxpool.Pool
+	interval time.Duration
+}
+
+func NewInvoicer(pool *pgxpool.Pool) *Invoicer {
+	interval := viper.GetDuration("worker.billing.interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Invoicer{
+		pool:     pool,
+		interval: interval,
+	}
+}
+
+func (i *Invoicer) Start(ctx context.Context) error {
+	go func() {
+		ticker := time.NewTicker(i.interval)
+		defer ticker.Stop()
+		for {
+			err := i.Run(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				logrus.Errorf("failed to generate invoices: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Run invoices the month before now.
+func (i *Invoicer) Run(ctx context.Context, now time.Time) error {
+	current, _ := billing.Period(now)
+	start, end := billing.Period(current.AddDate(0, -1, 0))
+	users, err := sqlc.New(i.pool).GetUsersToInvoice(ctx, sqlc.GetUsersToInvoiceParams{
+		PeriodStart: billing.Date(start),
+		PeriodEnd:   billing.Date(end),
+	})
+	if err != nil {
+		return err
+	}
+	for _, userID := range users {
+		invoice, created, err := billing.Generate(ctx, i.pool, userID, start)
+		if err != nil {
+			return err
+		}
+		if created {
+			logrus.Infof("created invoice %d of user %d for %s", invoice.ID, userID, start.Format("2006-01"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/bridge"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/eventsink"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// KafkaBridge mirrors the published lifecycle events of sms to Kafka in batches,
+// like EventSink does to an analytics store, from a consumer of its own.
+type KafkaBridge struct {
+	*nats.Consumer
+	bridge *bridge.Bridge
+	buffer *eventsink.Buffer
+}
+
+func NewKafkaBridge(ctx context.Context, natsAddress string, b *bridge.Bridge) (*KafkaBridge, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	size := viper.GetInt("kafka.bridge.batchsize")
+	interval := viper.GetDuration("kafka.bridge.flushinterval")
+	worker := &KafkaBridge{
+		Consumer: c,
+		bridge:   b,
+		buffer:   eventsink.NewBuffer(b, size, interval, viper.GetDuration("kafka.bridge.retry")),
+	}
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: events.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:          KAFKA_BRIDGE_CONSUMER_NAME,
+				Durable:       KAFKA_BRIDGE_CONSUMER_NAME,
+				Description:   "mirrors sms events to kafka",
+				AckWait:       2*interval + time.Minute,
+				MaxAckPending: 2 * size,
+			},
+		},
+		Handlers: map[string]nats.Handler{
+			KAFKA_BRIDGE_CONSUMER_NAME: worker.buffer.Add,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+// Start mirrors the events until ctx is done, see EventSink.Start.
+func (k *KafkaBridge) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("KafkaBridgeConsumerError: %s\n", err)
+	}
+	go k.buffer.Run(ctx)
+	return k.StartConsumers(ctx, nil, errHandlerOpt)
+}
+
+// Close stops consuming and closes the kafka producer.
+func (k *KafkaBridge) Close() error {
+	return errors.Join(k.Consumer.Close(), k.bridge.Close())
+}
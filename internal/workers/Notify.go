@@ -0,0 +1,445 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/events"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/chat"
+	"github.com/alireza-karampour/sms/pkg/email"
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/push"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/alireza-karampour/sms/pkg/voice"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Notify sends email, push and chat messages and places voice calls, among them the
+// fallbacks of sms. unlike sms, which are handed to carriers outside the gateway,
+// they are sent by the worker itself through the configured drivers.
+type Notify struct {
+	*nats.Consumer
+	*sqlc.Queries
+	db    *pgxpool.Pool
+	email email.Sender
+	push  push.Sender
+	voice voice.Caller
+	// chat are the drivers of whatsapp and telegram
+	chat         map[string]chat.Driver
+	queryTimeout time.Duration
+}
+
+func NewNotify(ctx context.Context, natsAddress string, pool *pgxpool.Pool, mail email.Sender, pusher push.Sender, caller voice.Caller, chats map[string]chat.Driver) (*Notify, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		nats.WithStreams(ParkedStream()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &Notify{
+		Consumer:     c,
+		Queries:      sqlc.New(pool),
+		db:           pool,
+		email:        mail,
+		push:         pusher,
+		voice:        caller,
+		chat:         chats,
+		queryTimeout: viper.GetDuration("worker.postgres.querytimeout"),
+	}
+	configs := []*nats.StreamConsumersConfig{
+		{
+			Stream: channels.EmailStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        EMAIL_CONSUMER_NAME,
+					Durable:     EMAIL_CONSUMER_NAME,
+					Description: "sends email",
+				},
+			},
+			Handlers: map[string]nats.Handler{
+				EMAIL_CONSUMER_NAME: worker.Handler(channels.Email),
+			},
+		},
+		{
+			Stream: channels.PushStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        PUSH_CONSUMER_NAME,
+					Durable:     PUSH_CONSUMER_NAME,
+					Description: "sends push notifications",
+				},
+			},
+			Handlers: map[string]nats.Handler{
+				PUSH_CONSUMER_NAME: worker.Handler(channels.Push),
+			},
+		},
+		{
+			Stream: channels.VoiceStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        VOICE_CONSUMER_NAME,
+					Durable:     VOICE_CONSUMER_NAME,
+					Description: "places voice calls and tracks their status",
+				},
+			},
+			Handlers: map[string]nats.Handler{
+				VOICE_CONSUMER_NAME: worker.Handler(channels.Voice),
+			},
+		},
+	}
+	chatStreams := []struct {
+		channel string
+		stream  jetstream.StreamConfig
+	}{
+		{channels.WhatsApp, channels.WhatsAppStream()},
+		{channels.Telegram, channels.TelegramStream()},
+	}
+	for _, cs := range chatStreams {
+		configs = append(configs, &nats.StreamConsumersConfig{
+			Stream: cs.stream,
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        cs.stream.Name,
+					Durable:     cs.stream.Name,
+					Description: "sends " + cs.channel + " messages",
+				},
+			},
+			Handlers: map[string]nats.Handler{
+				cs.stream.Name: worker.Handler(cs.channel),
+			},
+		})
+	}
+	err = worker.BindConsumers(ctx, configs...)
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (n *Notify) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("ConsumerError: %s\n", err)
+	}
+	n.Use(middlewares()...)
+	return n.StartConsumers(ctx, nil, errHandlerOpt)
+}
+
+// Handler sends the notifications queued on channel, one with a work queue, and
+// applies their status updates.
+func (n *Notify) Handler(channel string) nats.Handler {
+	statusSubject := subjects.Must(channels.StatusSubject(channel))
+	return func(ctx context.Context, msg jetstream.Msg) {
+		if msg.Subject() == statusSubject {
+			n.processStatus(ctx, msg)
+			return
+		}
+		n.process(ctx, msg, channel)
+	}
+}
+
+// process sends a notification and stores it with its outcome. sending is retried
+// until worker.notify.maxattempts, after which the notification is stored as failed.
+// the user is only charged for notifications that were sent. voice calls are stored
+// as submitted with the provider's id of the call, their outcome arrives as a status update.
+func (n *Notify) process(ctx context.Context, msg jetstream.Msg, channel string) {
+	var no channels.Notification
+	err := json.Unmarshal(msg.Data(), &no)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	no.Channel = channel
+	err = channels.Validate(channel, no.To)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	qctx, cancel := n.queryCtx(ctx)
+	account, err := n.GetUserStatus(qctx, no.UserID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		msg.TermWithReason(fmt.Sprintf("user %d not found", no.UserID))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get account status: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+	if hold(ctx, n.JetStream, msg, no.UserID, account) {
+		return
+	}
+
+	qctx, cancel = n.queryCtx(ctx)
+	tx, err := n.db.Begin(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to begin tx: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		tx.Rollback(rctx)
+	}()
+	q := n.WithTx(tx)
+
+	smsID := pgtype.Int4{Int32: no.SmsID, Valid: no.SmsID > 0}
+	if id := events.MessageID(msg); id != "" {
+		qctx, cancel = n.queryCtx(ctx)
+		rows, err := q.MarkMessageProcessed(qctx, sqlc.MarkMessageProcessedParams{MessageID: id, SmsID: smsID})
+		cancel()
+		if err != nil {
+			logrus.Errorf("failed to mark message processed: %s\n", err.Error())
+			nak(ctx, msg)
+			return
+		}
+		if rows == 0 {
+			logrus.Infof("message %s was already processed, skipping redelivery", id)
+			msg.DoubleAck(ctx)
+			return
+		}
+	}
+
+	params := sqlc.AddNotificationParams{
+		UserID:    no.UserID,
+		Channel:   channel,
+		Recipient: no.To,
+		Subject:   no.Subject,
+		Message:   notificationText(no),
+		Status:    channels.StatusSent,
+		SmsID:     smsID,
+		Cost:      notificationCost(channel),
+	}
+	ref, err := n.send(ctx, no)
+	if err == nil && channel == channels.Voice {
+		params.Status = status.Submitted.String()
+		params.ProviderRef = pgtype.Text{String: ref, Valid: ref != ""}
+	}
+	if err != nil {
+		var delivery uint64
+		if md, err := msg.Metadata(); err == nil {
+			delivery = md.NumDelivered
+		}
+		if delivery < viper.GetUint64("worker.notify.maxattempts") {
+			logrus.Warnf("failed to send %s to %s, retrying: %s", channel, mask.Phone(no.To), err)
+			nak(ctx, msg)
+			return
+		}
+		logrus.Errorf("failed to send %s to %s: %s", channel, mask.Phone(no.To), err)
+		params.Status = channels.StatusFailed
+		params.Error = pgtype.Text{String: err.Error(), Valid: true}
+		params.Cost = pgtype.Numeric{Int: big.NewInt(0), Valid: true}
+	}
+
+	qctx, cancel = n.queryCtx(ctx)
+	_, err = q.AddNotification(qctx, params)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to add notification: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+	if params.Status != channels.StatusFailed {
+		qctx, cancel = n.queryCtx(ctx)
+		_, err = q.SubBalance(qctx, sqlc.SubBalanceParams{
+			Amount: params.Cost,
+			UserID: no.UserID,
+		})
+		cancel()
+		if err != nil {
+			logrus.Errorf("failed to subtract balance: %s\n", err.Error())
+			nak(ctx, msg)
+			return
+		}
+	}
+
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+		return
+	}
+	qctx, cancel = n.queryCtx(ctx)
+	err = tx.Commit(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to commit notification: %s\n", err.Error())
+	}
+}
+
+// send hands no to the driver of its channel. it returns the provider's id of voice calls.
+func (n *Notify) send(ctx context.Context, no channels.Notification) (string, error) {
+	switch no.Channel {
+	case channels.Email:
+		subject := no.Subject
+		if subject == "" {
+			subject = viper.GetString("email.subject")
+		}
+		return "", n.email.Send(ctx, email.Message{
+			From:    viper.GetString("email.from"),
+			To:      no.To,
+			Subject: subject,
+			Body:    no.Message,
+		})
+	case channels.Push:
+		return "", n.push.Send(ctx, push.Notification{
+			Token: no.To,
+			Title: no.Subject,
+			Body:  no.Message,
+		})
+	case channels.WhatsApp, channels.Telegram:
+		d, ok := n.chat[no.Channel]
+		if !ok {
+			return "", fmt.Errorf("no %s driver", no.Channel)
+		}
+		msg := chat.Message{To: no.To, Text: no.Message, MediaURL: no.MediaURL, Params: no.Params}
+		if no.Template != "" {
+			t, err := chat.LookupTemplate(no.Channel, no.Template)
+			if err != nil {
+				return "", err
+			}
+			msg.Template = t
+		}
+		return "", chat.Send(ctx, d, msg)
+	case channels.Voice:
+		return n.voice.Call(ctx, voice.Call{
+			From:     viper.GetString("voice.from"),
+			To:       no.To,
+			Text:     no.Message,
+			Language: viper.GetString("voice.language"),
+			Repeat:   viper.GetInt("voice.repeat"),
+		})
+	default:
+		return "", fmt.Errorf("%w %q", channels.ErrUnknownChannel, no.Channel)
+	}
+}
+
+// processStatus applies a status.Update to a stored voice call, like the sms worker
+// does for sms. the reason of failed calls is stored as their error.
+func (n *Notify) processStatus(ctx context.Context, msg jetstream.Msg) {
+	var update status.Update
+	err := json.Unmarshal(msg.Data(), &update)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	to, err := status.Parse(string(update.Status))
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+
+	qctx, cancel := n.queryCtx(ctx)
+	tx, err := n.db.Begin(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to begin tx: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		tx.Rollback(rctx)
+	}()
+	q := n.WithTx(tx)
+
+	qctx, cancel = n.queryCtx(ctx)
+	current, err := q.GetNotificationStatusForUpdate(qctx, update.ID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		msg.TermWithReason(fmt.Sprintf("notification %d not found", update.ID))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get notification status: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+	from := status.Status(current)
+	if from == to {
+		msg.DoubleAck(ctx)
+		return
+	}
+	err = from.Transition(to)
+	if err != nil {
+		logrus.Warnf("notification %d: %s", update.ID, err)
+		msg.TermWithReason(err.Error())
+		return
+	}
+
+	qctx, cancel = n.queryCtx(ctx)
+	err = q.SetNotificationStatus(qctx, sqlc.SetNotificationStatusParams{
+		Status: to.String(),
+		Error:  pgtype.Text{String: update.Reason, Valid: to == status.Failed && update.Reason != ""},
+		ID:     update.ID,
+	})
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to set notification status: %s\n", err.Error())
+		nak(ctx, msg)
+		return
+	}
+
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+		return
+	}
+	qctx, cancel = n.queryCtx(ctx)
+	err = tx.Commit(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to commit status of notification %d: %s\n", update.ID, err.Error())
+	}
+}
+
+// notificationText is what is stored as the message of no: its message, or the
+// template and params of chat messages sent without one.
+func notificationText(no channels.Notification) string {
+	if no.Message != "" || no.Template == "" {
+		return no.Message
+	}
+	return strings.TrimSpace("template " + no.Template + " " + strings.Join(no.Params, ", "))
+}
+
+// notificationCost is what sending one notification on channel costs, from <channel>.cost.
+func notificationCost(channel string) pgtype.Numeric {
+	var cost pgtype.Numeric
+	err := cost.Scan(viper.GetString(channel + ".cost"))
+	if err != nil {
+		return pgtype.Numeric{Int: big.NewInt(0), Valid: true}
+	}
+	return cost
+}
+
+func (n *Notify) queryCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if n.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, n.queryTimeout)
+}
@@ -3,23 +3,41 @@ package workers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/failures"
+	"github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/internal/providers"
+	"github.com/alireza-karampour/sms/internal/slo"
 	. "github.com/alireza-karampour/sms/internal/streams"
 	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/metrics"
 	"github.com/alireza-karampour/sms/pkg/nats"
-	. "github.com/alireza-karampour/sms/pkg/utils"
+	"github.com/alireza-karampour/sms/pkg/status"
 	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	natsgo "github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 var (
-	cost pgtype.Numeric
+	cost            pgtype.Numeric
 	costInitialized bool
 )
 
@@ -35,81 +53,259 @@ func getSMSCost() pgtype.Numeric {
 	return cost
 }
 
+// getBYOPFee is what the sms sent through the provider account of their user are
+// charged instead of sms.cost, the carrier bills the user for the rest. it is read
+// once, the workers charge concurrently.
+var getBYOPFee = sync.OnceValue(func() pgtype.Numeric {
+	var fee pgtype.Numeric
+	err := fee.Scan(viper.GetString("sms.byop.fee"))
+	if err != nil {
+		fee.Scan("0.5")
+	}
+	return fee
+})
+
+const (
+	PriorityNormal  = string(NormalPriority)
+	PriorityExpress = string(ExpressPriority)
+)
+
 type Sms struct {
 	*nats.Consumer
 	*sqlc.Queries
-	db *pgxpool.Pool
+	db           *pgxpool.Pool
+	bp           *Backpressure
+	queryTimeout time.Duration
+	// limiter holds the token bucket of every priority, shared between all workers
+	// with worker.ratelimit.distributed. when nil the sms aren't rate limited.
+	limiter nats.RateLimiter
+	// reserved is the reserved rate of the tenants with a reservation, see throttle
+	reserved map[int32]float64
+	// sched shares the worker between the priorities. when nil they run unscheduled.
+	sched *Scheduler
+	// carrier routes the stored sms to the driver submitting them, see processSubmit.
+	// when nil they stay pending until carriers outside the gateway report on them.
+	carrier *carrier.Router
+	// accounts routes the sms of users who brought their own provider account
+	// through it, nil when no master key opens their credentials
+	accounts     *providers.Routes
+	submitPolicy webhooks.RetryPolicy
+	// recorder publishes the recorded events too when events.stream.enabled
+	recorder *events.Publisher
+	// reporter publishes why messages failed on the error subjects
+	reporter *failures.Reporter
+	// deadlines escalate the sms submitted too late, see processSubmit
+	deadlines Deadlines
+	// ackPolicies are how the consumers redeliver, see worker.consumers
+	ackPolicies AckPolicies
+	// audit copies the work queues when nats.audit.enabled, see AuditStreams
+	audit []jetstream.StreamConfig
+	// forwards queues the status changes of the users with a nats server of their
+	// own for the Forwarder, with worker.forwards.enabled
+	forwards bool
 }
 
-func NewSms(ctx context.Context, natsAddress string, pool *pgxpool.Pool) (*Sms, error) {
+func NewSms(ctx context.Context, natsAddress string, pool *pgxpool.Pool, router *carrier.Router, accounts *providers.Routes) (*Sms, error) {
+	ackPolicies, err := AckPoliciesFromViper()
+	if err != nil {
+		return nil, err
+	}
+	audit, err := AuditStreams(router != nil || accounts != nil)
+	if err != nil {
+		return nil, err
+	}
 	nc, err := nats.Connect(natsAddress)
 	if err != nil {
 		return nil, err
 	}
 
-	sc, err := nats.NewConsumer(nc)
+	sc, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+		nats.WithStreams(SmsStreams(router != nil || accounts != nil)...),
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	worker := &Sms{
-		Consumer: sc,
-		Queries:  sqlc.New(pool),
-		db:       pool,
+		Consumer:     sc,
+		Queries:      sqlc.New(pool),
+		db:           pool,
+		queryTimeout: viper.GetDuration("worker.postgres.querytimeout"),
+		carrier:      router,
+		accounts:     accounts,
+		recorder:     events.PublisherFromViper(sc.JetStream),
+		reporter:     failures.NewReporter(sc.JetStream, workerActor()),
+		deadlines:    DeadlinesFromViper(),
+		ackPolicies:  ackPolicies,
+		audit:        audit,
+		forwards:     viper.GetBool("worker.forwards.enabled"),
+		submitPolicy: webhooks.RetryPolicy{
+			MaxAttempts: viper.GetUint64("worker.submit.maxattempts"),
+			Initial:     viper.GetDuration("worker.submit.backoff.initial"),
+			Max:         viper.GetDuration("worker.submit.backoff.max"),
+		},
+	}
+	if viper.GetBool("worker.backpressure.enabled") {
+		worker.bp = NewBackpressure(BackpressureConfigFromViper())
+	}
+	worker.reserved, err = ReservationsFromViper()
+	if err != nil {
+		return nil, err
+	}
+	if viper.GetBool("worker.scheduler.enabled") {
+		conf, err := SchedulerConfigFromViper()
+		if err != nil {
+			return nil, err
+		}
+		worker.sched = NewScheduler(conf)
+	}
+	worker.limiter = nats.NewMemoryRateLimiter()
+	if viper.GetBool("worker.ratelimit.distributed") {
+		worker.limiter, err = nats.NewKVRateLimiter(ctx, sc.JetStream, viper.GetString("worker.ratelimit.bucket"))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err = worker.bindConsumer(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if router != nil {
+		for name, r := range router.Reporters() {
+			r.OnReport(worker.receiveReport(name))
+		}
+	}
+	if accounts != nil {
+		accounts.OnReport(worker.receiveReport)
+	}
 
 	return worker, nil
 }
 
-func (s *Sms) bindConsumer(ctx context.Context) error {
-	normalSms := &nats.StreamConsumersConfig{
-		Stream: jetstream.StreamConfig{
-			Name:        NORMAL_SMS_CONSUMER_NAME,
-			Description: "work queue for handling sms with normal priority",
-			Subjects: []string{
-				MakeSubject(SMS, SEND, REQ),
-				MakeSubject(SMS, SEND, STAT),
-				MakeSubject(SMS, SEND, ERR),
+// SmsStreams are the streams the sms worker publishes to besides those it
+// consumes. submit is set when it submits the sms to carriers itself.
+func SmsStreams(submit bool) []jetstream.StreamConfig {
+	// failed sms are handed to their fallback channel, status changes to webhooks
+	streams := append(channels.NotificationStreams(), webhooks.StreamConfig(), ParkedStream())
+	if submit {
+		streams = append(streams, SubmitStream())
+	}
+	if viper.GetBool("events.stream.enabled") {
+		streams = append(streams, events.StreamConfig())
+	}
+	if viper.GetBool("worker.forwards.enabled") {
+		streams = append(streams, forwards.StreamConfig())
+	}
+	return streams
+}
+
+// AuditStreams is the audit copy of the work queues of the sms worker when
+// nats.audit.enabled, see AuditStream. it sources the work queues, so it is
+// bound after them.
+func AuditStreams(submit bool) ([]jetstream.StreamConfig, error) {
+	if !viper.GetBool("nats.audit.enabled") {
+		return nil, nil
+	}
+	audit, err := AuditStream(submit)
+	if err != nil {
+		return nil, fmt.Errorf("nats.audit: %w", err)
+	}
+	return []jetstream.StreamConfig{audit}, nil
+}
+
+// SmsConsumers are the consumers of the sms worker, without their handlers,
+// with the ack policies of their class.
+func SmsConsumers(submit bool, policies AckPolicies) []*nats.StreamConsumersConfig {
+	configs := []*nats.StreamConsumersConfig{
+		{
+			Stream: NormalSmsStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        NORMAL_SMS_CONSUMER_NAME,
+					Durable:     NORMAL_SMS_CONSUMER_NAME,
+					Description: "consumes normal sms work queue",
+					// the error subject has a consumer of its own
+					FilterSubjects: []string{NormalSendReq(), NormalStat()},
+				},
+				{
+					Name:          SMS_ERRORS_CONSUMER_NAME,
+					Durable:       SMS_ERRORS_CONSUMER_NAME,
+					Description:   "stores the failures of the workers",
+					FilterSubject: NormalErr(),
+				},
 			},
-			Retention:   jetstream.WorkQueuePolicy,
-			Storage:     jetstream.FileStorage,
-			AllowDirect: true,
 		},
-		Consumers: []jetstream.ConsumerConfig{
-			{
-				Name:        NORMAL_SMS_CONSUMER_NAME,
-				Durable:     NORMAL_SMS_CONSUMER_NAME,
-				Description: "consumes normal sms work queue",
+		{
+			Stream: ExpressSmsStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        EXPRESS_SMS_CONSUMER_NAME,
+					Durable:     EXPRESS_SMS_CONSUMER_NAME,
+					Description: "consumes high priority sms work queue",
+					// the error subject has a consumer of its own
+					FilterSubjects: []string{ExpressSendReq(), ExpressStat()},
+				},
+				{
+					Name:          EXPRESS_SMS_ERRORS_CONSUMER_NAME,
+					Durable:       EXPRESS_SMS_ERRORS_CONSUMER_NAME,
+					Description:   "stores the failures of the workers",
+					FilterSubject: ExpressErr(),
+				},
 			},
 		},
-	}
-	expressSms := &nats.StreamConsumersConfig{
-		Stream: jetstream.StreamConfig{
-			Name:        EXPRESS_SMS_CONSUMER_NAME,
-			Description: "work queue for handling sms with high priority",
-			Subjects: []string{
-				MakeSubject(SMS, EX, SEND, REQ),
-				MakeSubject(SMS, EX, SEND, STAT),
-				MakeSubject(SMS, EX, SEND, ERR),
+		{
+			Stream: channels.FallbackStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        FALLBACK_CONSUMER_NAME,
+					Durable:     FALLBACK_CONSUMER_NAME,
+					Description: "fires the fallback of sms that weren't delivered in time",
+				},
 			},
-			Retention:   jetstream.WorkQueuePolicy,
-			Storage:     jetstream.FileStorage,
-			AllowDirect: true,
 		},
-		Consumers: []jetstream.ConsumerConfig{
-			{
-				Name:        EXPRESS_SMS_CONSUMER_NAME,
-				Durable:     EXPRESS_SMS_CONSUMER_NAME,
-				Description: "consumes high priority sms work queue",
+	}
+	if submit {
+		configs = append(configs, &nats.StreamConsumersConfig{
+			Stream: SubmitStream(),
+			Consumers: []jetstream.ConsumerConfig{
+				{
+					Name:        SUBMIT_CONSUMER_NAME,
+					Durable:     SUBMIT_CONSUMER_NAME,
+					Description: "submits stored sms to the carrier",
+				},
 			},
-		},
+		})
+	}
+	for _, config := range configs {
+		policies.apply(config.Consumers)
 	}
-	return s.BindConsumers(ctx, normalSms, expressSms)
+	return configs
+}
+
+func (s *Sms) bindConsumer(ctx context.Context) error {
+	handlers := map[string]nats.Handler{
+		NORMAL_SMS_CONSUMER_NAME:         s.Handler(PriorityNormal),
+		SMS_ERRORS_CONSUMER_NAME:         s.processFailure,
+		EXPRESS_SMS_CONSUMER_NAME:        s.Handler(PriorityExpress),
+		EXPRESS_SMS_ERRORS_CONSUMER_NAME: s.processFailure,
+		FALLBACK_CONSUMER_NAME:           s.processFallbackCheck,
+		SUBMIT_CONSUMER_NAME:             s.processSubmit,
+	}
+	configs := SmsConsumers(s.carrier != nil || s.accounts != nil, s.ackPolicies)
+	for _, config := range configs {
+		config.Handlers = make(map[string]nats.Handler)
+		for _, c := range config.Consumers {
+			config.Handlers[c.Name] = handlers[c.Name]
+		}
+	}
+	err := s.BindConsumers(ctx, configs...)
+	if err != nil {
+		return err
+	}
+	return s.BindStreams(ctx, s.audit...)
 }
 
 func (s *Sms) Start(ctx context.Context) error {
@@ -117,189 +313,1075 @@ func (s *Sms) Start(ctx context.Context) error {
 	opts := []jetstream.PullConsumeOpt{
 		errHandlerOpt,
 	}
-	err := s.StartConsumers(ctx, s.handler, opts...)
+	s.Use(middlewares()...)
+	// every consumer was bound with its own handler
+	err := s.StartConsumers(ctx, nil, opts...)
 	if err != nil {
 		return err
 	}
+	go s.purgeProcessed(ctx)
+	go s.purgeFailures(ctx)
+	go s.expireSubmitted(ctx)
 	return nil
 }
 
-func (s *Sms) handler(msg jetstream.Msg) {
-	sub := Subject(msg.Subject())
-	switch {
-	case sub.Filter(SMS, SEND, ANY):
-		s.handleNormalSms(msg)
-	case sub.Filter(SMS, EX, ANY, ANY):
-		s.handleExpressSms(msg)
+// Handler processes the work queue of priority, one of the declared priorities.
+// every priority has a token bucket of its own, see throttle, so a busy queue
+// never slows down the other.
+func (s *Sms) Handler(priority string) nats.Handler {
+	requestSubject := Must(PrioritySendReq(Priority(priority)))
+	statusSubject := Must(PriorityStat(Priority(priority)))
+	return func(ctx context.Context, msg jetstream.Msg) {
+		switch msg.Subject() {
+		case requestSubject:
+			s.processSms(ctx, msg, priority)
+		case statusSubject:
+			s.processStatus(ctx, msg, priority)
+		}
 	}
 }
 
-func (s *Sms) handleNormalSms(msg jetstream.Msg) {
-	rate := sync.OnceValue(func() uint {
-		return viper.GetUint("sms.normal.ratelimit")
-	})()
-
-	t := sync.OnceValue(func() *time.Timer {
-		return time.NewTimer(time.Millisecond * time.Duration(rate))
-	})()
-	t.Reset(time.Millisecond * time.Duration(rate))
+// processSms stores the sms and charges the user in one transaction.
+func (s *Sms) processSms(ctx context.Context, msg jetstream.Msg, priority string) {
+	req := new(channels.SmsRequest)
+	err := json.Unmarshal(msg.Data(), req)
+	if err != nil {
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageDecode, Class: failures.ClassInvalid, Error: err.Error()})
+		return
+	}
+	sms := &req.Sm
 
-	var sub Subject = Subject(msg.Subject())
-	switch {
-	case sub.Filter(ANY, ANY, REQ):
-		logrus.Debugf("Msg: %s\n", string(msg.Data()))
-		sms := new(sqlc.Sm)
-		err := json.Unmarshal(msg.Data(), sms)
-		if err != nil {
-			msg.TermWithReason(err.Error())
+	err = requestStatus(sms).Transition(status.Pending)
+	if err != nil {
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageDecode, Class: failures.ClassInvalid, Error: err.Error()})
+		return
+	}
+	if sms.Category == "" {
+		sms.Category = policy.CategoryTransactional
+	}
+	sms.Priority = priority
+	qctx, cancel := s.queryCtx(ctx)
+	account, err := s.GetUserStatus(qctx, sms.UserID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageAccount, Class: failures.ClassInvalid, Error: fmt.Sprintf("user %d not found", sms.UserID)})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get account status: %s\n", err.Error())
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageAccount, Class: failures.ClassOf(err, failures.ClassDatabase), Error: err.Error()})
+		return
+	}
+	if hold(ctx, s.JetStream, msg, sms.UserID, account) {
+		return
+	}
+	if policy.Expired(sms.ExpiresAt, time.Now()) {
+		s.expireSms(ctx, msg, sms)
+		return
+	}
+	if until, ok := s.quietUntil(ctx, sms); ok {
+		if policy.Expired(sms.ExpiresAt, until) {
+			s.expireSms(ctx, msg, sms)
 			return
 		}
-
-		tx, err := s.db.Begin(context.Background())
+		logrus.Debugf("sms to %s deferred by quiet hours until %s", mask.Phone(sms.ToPhoneNumber), until)
+		err := msg.NakWithDelay(time.Until(until))
 		if err != nil {
-			logrus.Errorf("failed to begin tx: %s\n", err.Error())
-			err := msg.NakWithDelay(time.Second)
-			if err != nil {
-				logrus.Errorf("failed to NAK: %s\n", err.Error())
-			}
-			return
+			logrus.Errorf("failed to NAK msg: %s\n", err.Error())
 		}
-		defer tx.Rollback(context.Background())
-		q := s.WithTx(tx)
-		err = q.AddSms(context.Background(), sqlc.AddSmsParams{
-			UserID:        sms.UserID,
-			PhoneNumberID: sms.PhoneNumberID,
-			ToPhoneNumber: sms.ToPhoneNumber,
-			Status:        sms.Status,
-			Message:       sms.Message,
+		return
+	}
+
+	if !s.throttle(ctx, msg, priority, sms.UserID) {
+		return
+	}
+	release, ok := s.schedule(ctx, msg, priority, sms.UserID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	err = db.WithTx(ctx, s.db, func(tx pgx.Tx) error {
+		return s.storeSms(ctx, s.WithTx(tx), msg, req)
+	})
+	if errors.Is(err, errDuplicate) {
+		s.observeTx(ctx, start, nil)
+		s.ackDuplicate(ctx, msg)
+		return
+	}
+	if errors.Is(err, errNoBalance) {
+		s.observeTx(ctx, start, nil)
+		s.refuseSms(ctx, msg, sms, status.Failed, events.Event{
+			Name:     events.Failed,
+			Actor:    workerActor(),
+			Metadata: map[string]any{"reason": "not enough balance"},
 		})
+		return
+	}
+	s.observeTx(ctx, start, err)
+	if err != nil {
+		logrus.Errorf("%s\n", err.Error())
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageStore, Class: failures.ClassOf(err, failures.ClassDatabase), Error: err.Error()})
+		return
+	}
+	metrics.SmsStored.WithLabelValues(metrics.Org(sms.UserID), priority).Inc()
+	// committed first: a redelivery after a failed ack is skipped as a duplicate
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+	return
+}
+
+// errNoBalance rolls back the transaction of an sms the user can't pay for.
+var errNoBalance = errors.New("not enough balance")
+
+// storeSms stores the sms of req and charges the user through q. it may run more
+// than once for the same message when the transaction has to be retried.
+func (s *Sms) storeSms(ctx context.Context, q *sqlc.Queries, msg jetstream.Msg, req *channels.SmsRequest) error {
+	sms := &req.Sm
+	var account int32
+	var err error
+	if req.Provider == "" {
+		account, err = s.routeAccount(ctx, sms.UserID, sms.ToPhoneNumber)
 		if err != nil {
-			logrus.Errorf("failed to add sms: %s\n", err.Error())
-			err = msg.NakWithDelay(time.Second)
-			if err != nil {
-				logrus.Errorf("failed to NAK msg: %s\n", err.Error())
-			}
-			return
+			return fmt.Errorf("failed to route sms: %w", err)
 		}
-		newBalance, err := q.SubBalance(context.Background(), sqlc.SubBalanceParams{
-			Amount: getSMSCost(),
-			UserID: sms.UserID,
+	}
+	charge := getBYOPFee()
+	if account == 0 {
+		qctx, cancel := s.queryCtx(ctx)
+		charge, err = q.GetSmsPrice(qctx, sqlc.GetSmsPriceParams{Cost: getSMSCost(), UserID: sms.UserID})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to price sms: %w", err)
+		}
+	}
+	qctx, cancel := s.queryCtx(ctx)
+	id, err := q.AddSms(qctx, sqlc.AddSmsParams{
+		UserID:        sms.UserID,
+		PhoneNumberID: sms.PhoneNumberID,
+		ToPhoneNumber: sms.ToPhoneNumber,
+		Status:        status.Pending.String(),
+		Message:       sms.Message,
+		Category:      sms.Category,
+		ExpiresAt:     sms.ExpiresAt,
+		Priority:      sms.Priority,
+		Cost:          charge,
+		ExternalID:    sms.ExternalID,
+		Metadata:      sms.Metadata,
+		Tags:          sms.Tags,
+		MessageID:     sms.MessageID,
+	})
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the message id is taken by an earlier delivery of this message, or the
+		// user's external id by another message the api accepted before this one
+		// was stored
+		return errDuplicate
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add sms: %w", err)
+	}
+
+	first, err := s.markProcessed(ctx, q, msg, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark message processed: %w", err)
+	}
+	if !first {
+		return errDuplicate
+	}
+
+	if fb := req.Fallback; fb != nil {
+		qctx, cancel = s.queryCtx(ctx)
+		err = q.AddSmsFallback(qctx, sqlc.AddSmsFallbackParams{
+			SmsID:        id,
+			Channel:      fb.Channel,
+			Recipient:    fb.To,
+			Subject:      fb.Subject,
+			AfterSeconds: fb.After,
 		})
+		cancel()
 		if err != nil {
-			logrus.Errorf("failed to subtract balance: %s\n", err.Error())
-			err = msg.NakWithDelay(time.Second)
+			return fmt.Errorf("failed to add sms fallback: %w", err)
+		}
+		if fb.After > 0 {
+			// a check of an sms that was rolled back finds nothing to fire
+			err = s.scheduleFallback(ctx, id, time.Duration(fb.After)*time.Second)
 			if err != nil {
-				logrus.Errorf("failed to NAK msg: %s\n", err.Error())
+				return fmt.Errorf("failed to schedule sms fallback: %w", err)
 			}
-			return
 		}
-		num, err := newBalance.Float64Value()
+	}
+
+	// charged only if the balance still covers it, concurrent sends may have
+	// spent what the api saw when it accepted the sms
+	qctx, cancel = s.queryCtx(ctx)
+	newBalance, err := q.ChargeBalance(qctx, sqlc.ChargeBalanceParams{
+		Amount: charge,
+		UserID: sms.UserID,
+	})
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNoBalance
+	}
+	if err != nil {
+		return fmt.Errorf("failed to subtract balance: %w", err)
+	}
+	num, err := newBalance.Float64Value()
+	if err != nil {
+		logrus.Error("failed to convert balance to float64")
+	} else {
+		logrus.Debugf("UserID: %d NewBalance: %f\n", sms.UserID, num.Float64)
+	}
+
+	metadata := map[string]any{
+		"priority": sms.Priority,
+		"category": sms.Category,
+		"cost":     charge,
+	}
+	if account != 0 {
+		metadata["provider_account"] = account
+	}
+	if req.Provider != "" {
+		metadata["provider_override"] = req.Provider
+	}
+	if replay := ReplayID(msg); replay != "" {
+		metadata["replay"] = replay
+	}
+	qctx, cancel = s.queryCtx(ctx)
+	defer cancel()
+	err = s.recorder.Record(qctx, q, id, append(events.Accepted(msg), events.Event{
+		Name:     events.Stored,
+		Actor:    workerActor(),
+		Metadata: metadata,
+	})...)
+	if err != nil {
+		return fmt.Errorf("failed to record sms events: %w", err)
+	}
+	if s.carrier != nil || account != 0 || req.Provider != "" {
+		err = s.scheduleSubmit(ctx, submitRequest{SmsID: id, OTP: req.OTP, AccountID: account, Provider: req.Provider})
 		if err != nil {
-			logrus.Error("failed to convert balance to float64")
-		} else {
-			logrus.Debugf("UserID: %d NewBalance: %f\n", sms.UserID, num.Float64)
+			return fmt.Errorf("failed to queue sms for submission: %w", err)
 		}
+	}
+	return nil
+}
+
+// schedule waits for the scheduler to grant msg a slot, after the rate limit so a
+// throttled priority doesn't hold slots the other could use. it reports false
+// when the worker stopped waiting, after handing msg back.
+func (s *Sms) schedule(ctx context.Context, msg jetstream.Msg, priority string, tenant int32) (func(), bool) {
+	if s.sched == nil {
+		return func() {}, true
+	}
+	var queuedAt time.Time
+	if md, err := msg.Metadata(); err == nil {
+		queuedAt = md.Timestamp
+	}
+	class := s.sched.Class(priority, queuedAt, time.Now())
+	if class != priority {
+		metrics.WorkerBoosted.Inc()
+	}
+	start := time.Now()
+	release, err := s.sched.Acquire(ctx, class, tenant)
+	if err != nil {
+		nak(ctx, msg)
+		return nil, false
+	}
+	metrics.TenantSlotWait.WithLabelValues(metrics.Org(tenant), class).Observe(time.Since(start).Seconds())
+	return release, true
+}
 
-		err = msg.DoubleAck(context.Background())
+// throttle waits for a token of the rate limit of priority, before the sms is
+// stored and queued for submission. long waits keep
+// the message in progress so it isn't redelivered meanwhile. it reports false when
+// the message was handed back instead. sms of a tenant with a reservation don't
+// wait while its own bucket has tokens: they still take theirs from the priority,
+// pushing the other tenants back, so reservations are slices of the rate limit.
+func (s *Sms) throttle(ctx context.Context, msg jetstream.Msg, priority string, tenant int32) bool {
+	if s.limiter == nil {
+		return true
+	}
+	reserved := s.takeReserved(ctx, tenant)
+	wait, err := s.limiter.Reserve(ctx, RateLimitKey(priority, ProviderDefault), rateOf(priority))
+	if err != nil {
+		logrus.Errorf("failed to reserve rate limit token: %s", err)
+		nak(ctx, msg)
+		return false
+	}
+	share := "shared"
+	if reserved {
+		share = "reserved"
+		wait = 0
+	}
+	metrics.TenantSms.WithLabelValues(metrics.Org(tenant), priority, share).Inc()
+	if wait <= 0 {
+		return true
+	}
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	progress := time.NewTicker(inProgressInterval)
+	defer progress.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			nak(ctx, msg)
+			return false
+		case <-progress.C:
+			msg.InProgress()
+		case <-deadline.C:
+			return true
+		}
+	}
+}
+
+// takeReserved takes a token of the reservation of tenant, reporting false when
+// it has none or it is used up. a failed limiter falls back to the shared rate.
+func (s *Sms) takeReserved(ctx context.Context, tenant int32) bool {
+	rate, ok := s.reserved[tenant]
+	if !ok {
+		return false
+	}
+	// a second of the reservation may be sent at once
+	admission, err := s.limiter.Admit(ctx, ReservationKey(tenant), nats.Rate{PerSecond: rate, Burst: rate})
+	if err != nil {
+		logrus.Errorf("failed to take reserved token of user %d: %s", tenant, err)
+		return false
+	}
+	return admission.OK
+}
+
+// ReservationKey is the KV key of the reserved rate of tenant.
+func ReservationKey(tenant int32) string {
+	return fmt.Sprintf("reservation.%d", tenant)
+}
+
+// ProviderDefault keys the rate limits of messages not routed to a specific provider.
+const ProviderDefault = "default"
+
+// inProgressInterval is how often throttled messages extend their ack deadline
+const inProgressInterval = 10 * time.Second
+
+// RateLimitKey is the KV key of the shared rate limit of priority on provider.
+func RateLimitKey(priority, provider string) string {
+	return "sms." + priority + "." + provider
+}
+
+// rateOf converts the sms.<priority>.ratelimit interval into a token rate, with
+// the burst credits of the priority.
+func rateOf(priority string) nats.Rate {
+	interval := time.Duration(viper.GetUint("sms."+priority+".ratelimit")) * time.Millisecond
+	if interval <= 0 {
+		return nats.Rate{}
+	}
+	return nats.Rate{
+		PerSecond: float64(time.Second) / float64(interval),
+		Burst:     viper.GetFloat64("worker.ratelimit.burst"),
+		Credits:   viper.GetFloat64("worker.ratelimit.credits." + priority),
+		Recharge:  nats.RechargeEvery(viper.GetDuration("worker.ratelimit.recharge")),
+	}
+}
+
+// requestStatus is the status an sms request was queued with. requests queued
+// before the api set status.Accepted carry "pending" or nothing.
+func requestStatus(sms *sqlc.Sm) status.Status {
+	if sms.Status == "" || sms.Status == status.Pending.String() {
+		return status.Accepted
+	}
+	return status.Status(sms.Status)
+}
+
+// processStatus applies a status.Update to a stored sms and records the transition.
+// updates that aren't allowed by the state machine are terminated, repeated ones acked.
+func (s *Sms) processStatus(ctx context.Context, msg jetstream.Msg, priority string) {
+	var update status.Update
+	err := json.Unmarshal(msg.Data(), &update)
+	if err != nil {
+		s.fail(ctx, msg, failures.Failure{Priority: priority, Stage: failures.StageDecode, Class: failures.ClassInvalid, Error: err.Error()})
+		return
+	}
+	failure := func(stage, class string, err error) failures.Failure {
+		return failures.Failure{SmsID: update.ID, Priority: priority, Stage: stage, Class: failures.ClassOf(err, class), Error: err.Error()}
+	}
+	to, err := status.Parse(string(update.Status))
+	if err != nil {
+		s.fail(ctx, msg, failure(failures.StageDecode, failures.ClassInvalid, err))
+		return
+	}
+
+	qctx, cancel := s.queryCtx(ctx)
+	tx, err := s.db.Begin(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to begin tx: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassDatabase, err))
+		return
+	}
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		tx.Rollback(rctx)
+	}()
+	q := s.WithTx(tx)
+
+	qctx, cancel = s.queryCtx(ctx)
+	current, err := q.GetSmsStatusForUpdate(qctx, update.ID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassInvalid, fmt.Errorf("sms %d not found", update.ID)))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get sms status: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassDatabase, err))
+		return
+	}
+	from := status.Status(current.Status)
+	if from == to {
+		msg.DoubleAck(ctx)
+		return
+	}
+	if from == status.Cancelled {
+		// cancelled and refunded before the carrier took it, a carrier that
+		// submits it anyway must not move it again
+		logrus.Infof("sms %d was cancelled, ignoring %s", update.ID, to)
+		msg.DoubleAck(ctx)
+		return
+	}
+	err = from.Transition(to)
+	if err != nil {
+		logrus.Warnf("sms %d: %s", update.ID, err)
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassInvalid, err))
+		return
+	}
+
+	failureReason := status.FailureReason(to, update.FailureReason)
+	qctx, cancel = s.queryCtx(ctx)
+	err = q.SetSmsStatus(qctx, sqlc.SetSmsStatusParams{
+		Status:        to.String(),
+		ID:            update.ID,
+		FailureReason: pgtype.Text{String: failureReason, Valid: failureReason != ""},
+	})
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to set sms status: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassDatabase, err))
+		return
+	}
+	metadata := map[string]any{"from": from}
+	if update.Reason != "" {
+		metadata["reason"] = update.Reason
+	}
+	if failureReason != "" {
+		metadata["failure_reason"] = failureReason
+	}
+	if update.Ref != "" {
+		metadata["ref"] = update.Ref
+	}
+	if replay := ReplayID(msg); replay != "" {
+		metadata["replay"] = replay
+	}
+	qctx, cancel = s.queryCtx(ctx)
+	err = s.recorder.Record(qctx, q, update.ID, events.Event{
+		Name:     to.String(),
+		Actor:    workerActor(),
+		Provider: update.Provider,
+		Metadata: metadata,
+	})
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to record sms events: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.StageEvents, failures.ClassDatabase, err))
+		return
+	}
+	event := statusEvent(update, from, to)
+	err = s.notifyWebhooks(ctx, q, update.ID, event)
+	if err != nil {
+		logrus.Errorf("failed to queue webhooks of sms %d: %s\n", update.ID, err.Error())
+		s.fail(ctx, msg, failure(failures.StageWebhooks, failures.ClassDatabase, err))
+		return
+	}
+	err = s.forwardStatus(ctx, q, update.ID, event)
+	if err != nil {
+		logrus.Errorf("failed to queue forwarding of sms %d: %s\n", update.ID, err.Error())
+		s.fail(ctx, msg, failure(failures.StageForwards, failures.ClassDatabase, err))
+		return
+	}
+	var lifecycle *sqlc.GetSmsLifecycleRow
+	if to == status.Delivered {
+		qctx, cancel = s.queryCtx(ctx)
+		l, err := q.GetSmsLifecycle(qctx, update.ID)
+		cancel()
 		if err != nil {
-			logrus.Errorf("failed to DoubleAck: %s", err.Error())
-			return
+			// only the latency metrics miss it
+			logrus.Warnf("failed to get lifecycle of sms %d: %s", update.ID, err)
+		} else {
+			lifecycle = &l
 		}
-		tx.Commit(context.Background())
-		<-t.C
-	case sub.Filter(ANY, ANY, STAT):
-		logrus.Debugf("NORMAL Subject: %s -- Msg: %s\n", msg.Subject(), string(msg.Data()))
-		err := msg.DoubleAck(context.Background())
+	}
+	if to == status.Failed {
+		err = s.fallback(ctx, q, update.ID, "failed")
 		if err != nil {
-			logrus.Errorf("failed to DoubleAck: %s", err)
+			logrus.Errorf("failed to fall back sms %d: %s\n", update.ID, err.Error())
+			s.fail(ctx, msg, failure(failures.StageFallback, failures.ClassDatabase, err))
 			return
 		}
 	}
 
+	qctx, cancel = s.queryCtx(ctx)
+	err = tx.Commit(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to commit status of sms %d: %s\n", update.ID, err.Error())
+		s.fail(ctx, msg, failure(failures.StageStatus, failures.ClassDatabase, err))
+		return
+	}
+	// committed first: a redelivery after a failed ack finds the sms in its new
+	// status and is acked without changing it
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+	metrics.StatusChanges.WithLabelValues(metrics.Org(current.UserID), priority, update.Provider, to.String()).Inc()
+	if lifecycle != nil {
+		observeLatency(*lifecycle)
+	}
 }
 
-func (s *Sms) handleExpressSms(msg jetstream.Msg) {
-	rate := sync.OnceValue(func() uint {
-		return viper.GetUint("sms.express.ratelimit")
-	})()
+// observeLatency records the stage latencies of a delivered sms.
+func observeLatency(l sqlc.GetSmsLifecycleRow) {
+	stages := slo.Lifecycle{
+		Created:   l.Created.Time,
+		Stored:    l.Stored.Time,
+		Submitted: l.Submitted.Time,
+		Delivered: l.Delivered.Time,
+	}.Stages()
+	for stage, d := range stages {
+		metrics.DeliveryLatency.WithLabelValues(metrics.Org(l.UserID), l.Priority, l.Provider.String, stage).Observe(d.Seconds())
+	}
+}
 
-	t := sync.OnceValue(func() *time.Timer {
-		return time.NewTimer(time.Millisecond * time.Duration(rate))
-	})()
-	t.Reset(time.Millisecond * time.Duration(rate))
+// fallback hands the message of an sms to its fallback channel, if it has one that
+// didn't fire yet. the notification is published with an id derived from the sms, so
+// redeliveries of the message that fired it don't send it twice. reason is recorded
+// with the fallback event.
+func (s *Sms) fallback(ctx context.Context, q *sqlc.Queries, id int32, reason string) error {
+	qctx, cancel := s.queryCtx(ctx)
+	fb, err := q.FireSmsFallback(qctx, id)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	subject, err := channels.RequestSubject(fb.Channel)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(channels.Notification{
+		UserID:  fb.UserID,
+		Channel: fb.Channel,
+		To:      fb.Recipient,
+		Subject: fb.Subject,
+		Message: fb.Message,
+		SmsID:   id,
+	})
+	if err != nil {
+		return err
+	}
+	msgID := fmt.Sprintf("fallback-%d", id)
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	if err != nil {
+		return err
+	}
+	qctx, cancel = s.queryCtx(ctx)
+	defer cancel()
+	return s.recorder.Record(qctx, q, id, events.Event{
+		Name:     events.Fallback,
+		Actor:    workerActor(),
+		Metadata: map[string]any{"channel": fb.Channel, "reason": reason},
+	})
+}
 
-	var sub Subject = Subject(msg.Subject())
-	switch {
-	case sub.Filter(ANY, ANY, ANY, REQ):
-		logrus.Debugf("EXPRESS Subject: %s -- Msg: %s\n", msg.Subject(), string(msg.Data()))
-		sms := new(sqlc.Sm)
-		err := json.Unmarshal(msg.Data(), sms)
+// statusEvent is the event of the status change of an sms, its id derived from
+// the change.
+func statusEvent(update status.Update, from, to status.Status) webhooks.Event {
+	return webhooks.Event{
+		ID:         fmt.Sprintf("sms-%d-%s", update.ID, to),
+		Type:       webhooks.EventSmsStatus,
+		OccurredAt: time.Now().UTC(),
+		Data: webhooks.SmsStatus{
+			SmsID:         update.ID,
+			Status:        to.String(),
+			From:          from.String(),
+			Provider:      update.Provider,
+			Reason:        update.Reason,
+			FailureReason: status.FailureReason(to, update.FailureReason),
+		},
+	}
+}
+
+// notifyWebhooks queues a delivery of the status change event of sms id for every
+// webhook of its owner. deliveries are published with ids derived from the event,
+// so redeliveries of the status update don't queue them twice.
+func (s *Sms) notifyWebhooks(ctx context.Context, q *sqlc.Queries, id int32, event webhooks.Event) error {
+	qctx, cancel := s.queryCtx(ctx)
+	hooks, err := q.GetWebhooksForSms(qctx, id)
+	cancel()
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		data, err := json.Marshal(webhooks.Delivery{
+			WebhookID: hook.ID,
+			URL:       hook.Url,
+			EventID:   event.ID,
+			Event:     event.Type,
+			Payload:   payload,
+		})
 		if err != nil {
-			msg.TermWithReason(err.Error())
-			return
+			return err
 		}
+		msgID := fmt.Sprintf("webhook-%d-%s", hook.ID, event.ID)
+		_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+			Subject: webhooks.DeliverSubject,
+			Data:    data,
+			Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+		}, jetstream.WithMsgID(msgID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardStatus queues the status change event of sms id for the Forwarder when
+// its owner set up a nats server of their own, with an id derived from the event
+// like notifyWebhooks.
+func (s *Sms) forwardStatus(ctx context.Context, q *sqlc.Queries, id int32, event webhooks.Event) error {
+	if !s.forwards {
+		return nil
+	}
+	qctx, cancel := s.queryCtx(ctx)
+	userID, err := q.GetNatsForwardUserForSms(qctx, id)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(forwards.Forward{
+		UserID:  userID,
+		EventID: event.ID,
+		Event:   event.Type,
+		Payload: payload,
+	})
+	if err != nil {
+		return err
+	}
+	msgID := "forward-" + event.ID
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: forwards.DeliverSubject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	return err
+}
 
-		tx, err := s.db.Begin(context.Background())
+// scheduleFallback queues a FallbackCheck that fires the fallback of sms id after
+// the given delay unless the sms was delivered by then.
+func (s *Sms) scheduleFallback(ctx context.Context, id int32, after time.Duration) error {
+	data, err := json.Marshal(channels.FallbackCheck{SmsID: id, Due: time.Now().Add(after)})
+	if err != nil {
+		return err
+	}
+	msgID := fmt.Sprintf("fallback-check-%d", id)
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: channels.FallbackSubject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// processFallbackCheck holds a FallbackCheck back until it is due, then fires the
+// fallback of its sms unless the sms was delivered. failed sms fired it already.
+func (s *Sms) processFallbackCheck(ctx context.Context, msg jetstream.Msg) {
+	var check channels.FallbackCheck
+	err := json.Unmarshal(msg.Data(), &check)
+	if err != nil {
+		s.fail(ctx, msg, failures.Failure{Stage: failures.StageDecode, Class: failures.ClassInvalid, Error: err.Error()})
+		return
+	}
+	// the priority of the sms isn't known here, its failures go to the normal queue
+	failure := func(class string, err error) failures.Failure {
+		return failures.Failure{SmsID: check.SmsID, Stage: failures.StageFallback, Class: failures.ClassOf(err, class), Error: err.Error()}
+	}
+	if wait := time.Until(check.Due); wait > 0 {
+		err = msg.NakWithDelay(wait)
 		if err != nil {
-			logrus.Errorf("failed to begin tx: %s\n", err.Error())
-			err := msg.NakWithDelay(time.Second)
-			if err != nil {
-				logrus.Errorf("failed to NAK: %s\n", err.Error())
-			}
+			logrus.Errorf("failed to NAK msg: %s\n", err.Error())
+		}
+		return
+	}
+
+	qctx, cancel := s.queryCtx(ctx)
+	tx, err := s.db.Begin(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to begin tx: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.ClassDatabase, err))
+		return
+	}
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		tx.Rollback(rctx)
+	}()
+	q := s.WithTx(tx)
+
+	qctx, cancel = s.queryCtx(ctx)
+	current, err := q.GetSmsStatusForUpdate(qctx, check.SmsID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.fail(ctx, msg, failure(failures.ClassInvalid, fmt.Errorf("sms %d not found", check.SmsID)))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get sms status: %s\n", err.Error())
+		s.fail(ctx, msg, failure(failures.ClassDatabase, err))
+		return
+	}
+	if st := status.Status(current.Status); st != status.Delivered && st != status.Cancelled {
+		err = s.fallback(ctx, q, check.SmsID, "undelivered")
+		if err != nil {
+			logrus.Errorf("failed to fall back sms %d: %s\n", check.SmsID, err.Error())
+			s.fail(ctx, msg, failure(failures.ClassDatabase, err))
 			return
 		}
-		defer tx.Rollback(context.Background())
+	}
+
+	qctx, cancel = s.queryCtx(ctx)
+	err = tx.Commit(qctx)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to commit fallback of sms %d: %s\n", check.SmsID, err.Error())
+		s.fail(ctx, msg, failure(failures.ClassDatabase, err))
+		return
+	}
+	// committed first: a redelivery after a failed ack finds the fallback fired
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+}
+
+// expireSms records an sms whose validity period passed before it could be sent.
+func (s *Sms) expireSms(ctx context.Context, msg jetstream.Msg, sms *sqlc.Sm) {
+	logrus.Debugf("sms to %s expired at %s", mask.Phone(sms.ToPhoneNumber), sms.ExpiresAt.Time)
+	s.refuseSms(ctx, msg, sms, status.Expired, events.Event{
+		Name:     events.Expired,
+		Actor:    workerActor(),
+		Metadata: map[string]any{"expires_at": sms.ExpiresAt.Time},
+	})
+}
+
+// errDuplicate rolls back the transaction of a message that was already processed.
+var errDuplicate = errors.New("message was already processed")
+
+// refuseSms records an sms that won't be sent in st, with event as the reason. the
+// user is not charged for it.
+func (s *Sms) refuseSms(ctx context.Context, msg jetstream.Msg, sms *sqlc.Sm, st status.Status, event events.Event) {
+	err := db.WithTx(ctx, s.db, func(tx pgx.Tx) error {
 		q := s.WithTx(tx)
-		err = q.AddSms(context.Background(), sqlc.AddSmsParams{
+		qctx, cancel := s.queryCtx(ctx)
+		id, err := q.AddSms(qctx, sqlc.AddSmsParams{
 			UserID:        sms.UserID,
 			PhoneNumberID: sms.PhoneNumberID,
 			ToPhoneNumber: sms.ToPhoneNumber,
-			Status:        sms.Status,
+			Status:        st.String(),
 			Message:       sms.Message,
+			Category:      sms.Category,
+			ExpiresAt:     sms.ExpiresAt,
+			Priority:      sms.Priority,
+			Cost:          pgtype.Numeric{Int: big.NewInt(0), Valid: true},
+			ExternalID:    sms.ExternalID,
+			Metadata:      sms.Metadata,
+			Tags:          sms.Tags,
+			MessageID:     sms.MessageID,
 		})
+		cancel()
+		if errors.Is(err, pgx.ErrNoRows) {
+			// the user's external id is taken, see storeSms
+			return errDuplicate
+		}
 		if err != nil {
-			logrus.Errorf("failed to add sms: %s\n", err.Error())
-			err = msg.NakWithDelay(time.Second)
-			if err != nil {
-				logrus.Errorf("failed to NAK msg: %s\n", err.Error())
-			}
+			return fmt.Errorf("failed to add %s sms: %w", st, err)
+		}
+		first, err := s.markProcessed(ctx, q, msg, id)
+		if err != nil {
+			return fmt.Errorf("failed to mark message processed: %w", err)
+		}
+		if !first {
+			return errDuplicate
+		}
+		qctx, cancel = s.queryCtx(ctx)
+		defer cancel()
+		err = s.recorder.Record(qctx, q, id, append(events.Accepted(msg), event)...)
+		if err != nil {
+			return fmt.Errorf("failed to record sms events: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(err, errDuplicate) {
+		s.ackDuplicate(ctx, msg)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("%s\n", err.Error())
+		s.fail(ctx, msg, failures.Failure{Priority: sms.Priority, Stage: failures.StageStore, Class: failures.ClassOf(err, failures.ClassDatabase), Error: err.Error()})
+		return
+	}
+	// committed first: a redelivery after a failed ack is skipped as a duplicate
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+	return
+}
+
+// markProcessed records msg in processed_messages within the transaction of q. it
+// reports false when an earlier delivery of msg was already committed, in which
+// case the transaction must be rolled back so the user isn't charged twice.
+func (s *Sms) markProcessed(ctx context.Context, q *sqlc.Queries, msg jetstream.Msg, smsID int32) (bool, error) {
+	id := events.MessageID(msg)
+	if id == "" {
+		return true, nil
+	}
+	qctx, cancel := s.queryCtx(ctx)
+	defer cancel()
+	n, err := q.MarkMessageProcessed(qctx, sqlc.MarkMessageProcessedParams{
+		MessageID: id,
+		SmsID:     pgtype.Int4{Int32: smsID, Valid: true},
+	})
+	return n > 0, err
+}
+
+// ackDuplicate acknowledges a redelivery of a message that was already processed.
+func (s *Sms) ackDuplicate(ctx context.Context, msg jetstream.Msg) {
+	logrus.Infof("message %s was already processed, skipping redelivery", events.MessageID(msg))
+	err := msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+}
+
+// purgeProcessed forgets processed messages older than worker.dedupe.retention,
+// long after JetStream stopped redelivering them.
+func (s *Sms) purgeProcessed(ctx context.Context) {
+	retention := viper.GetDuration("worker.dedupe.retention")
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		qctx, cancel := s.queryCtx(ctx)
+		n, err := s.PurgeProcessedMessages(qctx, pgtype.Timestamp{Time: time.Now().UTC().Add(-retention), Valid: true})
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to purge processed messages: %s", err)
+		} else if n > 0 {
+			logrus.Debugf("purged %d processed messages", n)
+		}
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		newBalance, err := q.SubBalance(context.Background(), sqlc.SubBalanceParams{
-			Amount: getSMSCost(),
-			UserID: sms.UserID,
+// expireSubmitted expires the submitted sms whose validity period ended without a
+// final report, every worker.expiry.interval. the expiry is published as a status
+// update, so it is applied like a report of the carrier; every worker may run the
+// sweep, the msg id keeps an sms from being expired twice.
+func (s *Sms) expireSubmitted(ctx context.Context) {
+	interval := viper.GetDuration("worker.expiry.interval")
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		qctx, cancel := s.queryCtx(ctx)
+		overdue, err := s.GetOverdueSubmittedSms(qctx, sqlc.GetOverdueSubmittedSmsParams{
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+			Limit:     viper.GetInt32("worker.expiry.batchsize"),
 		})
-
-		if err != nil {
-			logrus.Errorf("failed to subtract balance: %s\n", err.Error())
-			err = msg.NakWithDelay(time.Second)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to get overdue sms: %s", err)
+		}
+		for _, sms := range overdue {
+			err := s.publishExpiry(ctx, sms.ID, sms.Priority)
 			if err != nil {
-				logrus.Errorf("failed to NAK msg: %s\n", err.Error())
+				logrus.Errorf("failed to expire sms %d: %s", sms.ID, err)
+				break
 			}
-			return
 		}
-		num, err := newBalance.Float64Value()
-		if err != nil {
-			logrus.Error("failed to convert balance to float64")
-		} else {
-			logrus.Debugf("UserID: %d NewBalance: %f\n", sms.UserID, num.Float64)
+		if len(overdue) > 0 {
+			logrus.Debugf("queued the expiry of %d overdue sms", len(overdue))
 		}
-
-		err = msg.DoubleAck(context.Background())
-		if err != nil {
-			logrus.Errorf("failed to DoubleAck: %s", err.Error())
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
-		tx.Commit(context.Background())
-		<-t.C
+	}
+}
 
-	case sub.Filter(ANY, ANY, ANY, STAT):
-		logrus.Debugf("EXPRESS Subject: %s -- Msg: %s\n", msg.Subject(), string(msg.Data()))
-		err := msg.DoubleAck(context.Background())
-		if err != nil {
-			logrus.Errorf("failed to DoubleAck: %s", err)
+// publishExpiry publishes the expiry of a submitted sms on the status subject of
+// its priority.
+func (s *Sms) publishExpiry(ctx context.Context, id int32, priority string) error {
+	data, err := json.Marshal(status.Update{
+		ID:            id,
+		Status:        status.Expired,
+		Reason:        "validity period ended",
+		FailureReason: status.ReasonExpired,
+	})
+	if err != nil {
+		return err
+	}
+	subject := NormalStat()
+	if priority == PriorityExpress {
+		subject = ExpressStat()
+	}
+	msgID := fmt.Sprintf("expire-%d", id)
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// workerActor identifies this worker in sms_events.
+var workerActor = sync.OnceValue(func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "worker"
+	}
+	return "worker:" + host
+})
+
+// quietUntil reports whether the destination is in one of the user's quiet hours
+// windows and when the window ends. rules are enforced as deferrals at this stage
+// because the api already accepted the message.
+func (s *Sms) quietUntil(ctx context.Context, sms *sqlc.Sm) (time.Time, bool) {
+	qctx, cancel := s.queryCtx(ctx)
+	defer cancel()
+	rules, err := s.GetQuietHours(qctx, sms.UserID)
+	if err != nil {
+		logrus.Errorf("failed to get quiet hours: %s", err)
+		return time.Time{}, false
+	}
+	_, until, ok := policy.QuietHours(rules, sms.Category, sms.ToPhoneNumber, time.Now())
+	return until, ok
+}
+
+// queryCtx derives the context for a single statement from the consume context,
+// bounded by worker.postgres.querytimeout.
+func (s *Sms) queryCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// nak asks for redelivery. when the worker is shutting down the message is handed
+// back immediately so another worker can pick it up.
+func nak(ctx context.Context, msg jetstream.Msg) {
+	var err error
+	if ctx.Err() != nil {
+		err = msg.Nak()
+	} else {
+		err = msg.NakWithDelay(time.Second)
+	}
+	if err != nil {
+		logrus.Errorf("failed to NAK msg: %s\n", err.Error())
+	}
+}
+
+// fail reports why msg failed, then terminates it when it is invalid and asks for
+// its redelivery otherwise.
+func (s *Sms) fail(ctx context.Context, msg jetstream.Msg, f failures.Failure) {
+	f.Final = f.Class == failures.ClassInvalid
+	s.reporter.Report(ctx, msg, f)
+	if f.Final {
+		msg.TermWithReason(f.Error)
+		return
+	}
+	nak(ctx, msg)
+}
+
+// processFailure stores a failure published on the error subjects. failures of
+// storing one aren't reported, that would only add to them.
+func (s *Sms) processFailure(ctx context.Context, msg jetstream.Msg) {
+	var f failures.Failure
+	err := json.Unmarshal(msg.Data(), &f)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	qctx, cancel := s.queryCtx(ctx)
+	err = failures.Save(qctx, s.Queries, f)
+	cancel()
+	if err != nil {
+		logrus.Errorf("failed to store failure of %s: %s\n", f.MessageID, err.Error())
+		nak(ctx, msg)
+		return
+	}
+	msg.Ack()
+}
+
+// purgeFailures forgets the stored failures older than worker.errors.retention.
+func (s *Sms) purgeFailures(ctx context.Context) {
+	retention := viper.GetDuration("worker.errors.retention")
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		qctx, cancel := s.queryCtx(ctx)
+		n, err := s.PurgeWorkerErrors(qctx, pgtype.Timestamp{Time: time.Now().UTC().Add(-retention), Valid: true})
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to purge worker errors: %s", err)
+		} else if n > 0 {
+			logrus.Debugf("purged %d worker errors", n)
+		}
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
 	}
 }
@@ -0,0 +1,325 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/internal/failures"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/internal/providers"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/subjects"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// outcomes of a submission, see metrics.Submissions
+const (
+	submitSubmitted = "submitted"
+	submitRetried   = "retried"
+	submitFailed    = "failed"
+	submitExpired   = "expired"
+)
+
+// submitRequest queues a stored sms for submission to the carrier.
+type submitRequest struct {
+	SmsID int32  `json:"sms_id"`
+	OTP   string `json:"otp,omitempty"`
+	// AccountID is the provider account of the user the sms goes through, it was
+	// charged the platform fee for it
+	AccountID int32 `json:"account_id,omitempty"`
+	// Provider is the driver an admin chose for the sms, see channels.SmsRequest
+	Provider string `json:"provider,omitempty"`
+	// PickedUp is when a worker stored the sms, its deadline runs from then
+	PickedUp time.Time `json:"picked_up,omitzero"`
+}
+
+// routeAccount returns the provider account the sms of userID to to goes through,
+// 0 when it goes through the platform's drivers.
+func (s *Sms) routeAccount(ctx context.Context, userID int32, to string) (int32, error) {
+	if s.accounts == nil {
+		return 0, nil
+	}
+	qctx, cancel := s.queryCtx(ctx)
+	defer cancel()
+	return s.accounts.Route(qctx, userID, to)
+}
+
+// scheduleSubmit queues the sms of req for submission. it is published from the
+// transaction that stores the sms, with an id derived from it so retried
+// transactions queue it once.
+func (s *Sms) scheduleSubmit(ctx context.Context, req submitRequest) error {
+	req.PickedUp = time.Now()
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	msgID := fmt.Sprintf("submit-%d", req.SmsID)
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: SubmitSubject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// processSubmit submits a pending sms through the driver its destination is routed
+// to. the outcome is published as a status.Update on the status subject of the sms'
+// priority, so it is applied like a delivery report. submissions the carrier may
+// accept later are retried with backoff until worker.submit.maxattempts, then the
+// sms fails. sms that aren't pending anymore, e.g. cancelled ones, are skipped and
+// those no route matches are left pending, for carriers outside the gateway. sms
+// routed to a provider account of their user are submitted through its driver, and
+// fail once the account is deleted. sms past the deadline of their priority are
+// escalated: their submission is reported on the express status subject and
+// retried without backing off further.
+func (s *Sms) processSubmit(ctx context.Context, msg jetstream.Msg) {
+	var req submitRequest
+	err := json.Unmarshal(msg.Data(), &req)
+	if err != nil {
+		s.fail(ctx, msg, failures.Failure{Stage: failures.StageDecode, Class: failures.ClassInvalid, Error: err.Error()})
+		return
+	}
+	var attempt uint64 = 1
+	if md, err := msg.Metadata(); err == nil {
+		attempt = md.NumDelivered
+	}
+
+	qctx, cancel := s.queryCtx(ctx)
+	sms, err := s.GetSmsForSubmit(qctx, req.SmsID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the transaction that stored it may not have committed yet
+		if s.submitPolicy.Exhausted(attempt) {
+			s.fail(ctx, msg, failures.Failure{SmsID: req.SmsID, Stage: failures.StageSubmit, Class: failures.ClassInvalid, Error: fmt.Sprintf("sms %d not found", req.SmsID)})
+			return
+		}
+		msg.NakWithDelay(s.submitPolicy.Backoff(attempt))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to get sms for submission: %s\n", err.Error())
+		s.fail(ctx, msg, failures.Failure{SmsID: req.SmsID, Stage: failures.StageSubmit, Class: failures.ClassOf(err, failures.ClassDatabase), Error: err.Error()})
+		return
+	}
+	if status.Status(sms.Status) != status.Pending {
+		logrus.Debugf("sms %d is %s, not submitting it", sms.ID, sms.Status)
+		msg.DoubleAck(ctx)
+		return
+	}
+	if policy.Expired(sms.ExpiresAt, time.Now()) {
+		s.expireSubmit(ctx, msg, sms)
+		return
+	}
+
+	escalated := s.checkDeadline(ctx, sms, req)
+
+	provider, sender, err := s.driverOf(ctx, req, sms.ToPhoneNumber)
+	if err == nil && sender == nil {
+		msg.DoubleAck(ctx)
+		return
+	}
+
+	update := status.Update{
+		ID:       sms.ID,
+		Status:   status.Submitted,
+		Provider: provider,
+	}
+	if err == nil {
+		update.Ref, err = sender.Send(ctx, carrier.Message{
+			ID:        sms.ID,
+			MessageID: sms.MessageID.String(),
+			From:      sms.PhoneNumber,
+			To:        sms.ToPhoneNumber,
+			Text:      sms.Message,
+			OTP:       req.OTP,
+		})
+	}
+	if err != nil {
+		// the account of the sms won't come back, other failures to open it may pass
+		retry := carrier.Retryable(err) && !errors.Is(err, providers.ErrAccountNotFound) && !s.submitPolicy.Exhausted(attempt)
+		// the sms fails rather than the message, which is acked
+		s.reporter.Report(ctx, msg, failures.Failure{
+			SmsID:    sms.ID,
+			Priority: sms.Priority,
+			Stage:    failures.StageSubmit,
+			Class:    failures.ClassOf(err, failures.ClassProvider),
+			Error:    err.Error(),
+			Final:    !retry,
+		})
+		if retry {
+			metrics.Submissions.WithLabelValues(metrics.Org(sms.UserID), sms.Priority, provider, submitRetried).Inc()
+			delay := s.submitPolicy.Backoff(attempt)
+			if escalated {
+				delay = s.submitPolicy.Backoff(1)
+			}
+			logrus.Warnf("failed to submit sms %d, retrying in %s: %s", sms.ID, delay, err)
+			msg.NakWithDelay(delay)
+			return
+		}
+		logrus.Errorf("failed to submit sms %d: %s", sms.ID, err)
+		update.Status = status.Failed
+		update.Reason = err.Error()
+		update.FailureReason = carrier.FailureReasonOf(err)
+	}
+	outcome := submitSubmitted
+	if update.Status == status.Failed {
+		outcome = submitFailed
+	}
+	metrics.Submissions.WithLabelValues(metrics.Org(sms.UserID), sms.Priority, provider, outcome).Inc()
+
+	data, err := json.Marshal(update)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	subject := NormalStat()
+	if sms.Priority == PriorityExpress || escalated {
+		subject = ExpressStat()
+	}
+	msgID := fmt.Sprintf("submit-%d-%s", sms.ID, update.Status)
+	_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+	}, jetstream.WithMsgID(msgID))
+	if err != nil {
+		// the carrier has it, submitting it again is the lesser evil than leaving it pending
+		logrus.Errorf("failed to publish submission of sms %d: %s\n", sms.ID, err.Error())
+		s.fail(ctx, msg, failures.Failure{SmsID: sms.ID, Priority: sms.Priority, Stage: failures.StageSubmit, Class: failures.ClassOf(err, failures.ClassQueue), Error: err.Error()})
+		return
+	}
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+}
+
+// driverOf returns the name and driver the sms of req to to is submitted through,
+// nil when it isn't submitted by the gateway. sms sent with a provider the worker
+// has no route to fail.
+func (s *Sms) driverOf(ctx context.Context, req submitRequest, to string) (string, carrier.Sender, error) {
+	if req.Provider != "" {
+		if s.carrier != nil {
+			if sender, ok := s.carrier.Driver(req.Provider); ok {
+				return req.Provider, sender, nil
+			}
+		}
+		return req.Provider, nil, &carrier.Error{
+			Code:   "unrouted",
+			Reason: fmt.Sprintf("no route of sms.provider uses %s", req.Provider),
+		}
+	}
+	if req.AccountID != 0 {
+		return s.accounts.Driver(ctx, req.AccountID)
+	}
+	if s.carrier == nil {
+		return "", nil, nil
+	}
+	provider, sender := s.carrier.Route(to)
+	return provider, sender, nil
+}
+
+// receiveReport queues the delivery reports provider receives itself like the
+// callbacks queue theirs. the sms is found by the ref its submission was recorded
+// with, a report that arrives before that is refused and sent again by the carrier.
+func (s *Sms) receiveReport(provider string) func(context.Context, carrier.Report) error {
+	return func(ctx context.Context, r carrier.Report) error {
+		qctx, cancel := s.queryCtx(ctx)
+		sms, err := s.GetSmsByRef(qctx, sqlc.GetSmsByRefParams{
+			Provider: provider,
+			Ref:      r.Ref,
+		})
+		cancel()
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("no sms submitted as %s", r.Ref)
+		}
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(status.Update{
+			ID:            sms.ID,
+			Status:        r.Status,
+			Provider:      provider,
+			Reason:        r.Reason,
+			Ref:           r.Ref,
+			FailureReason: r.FailureReason,
+		})
+		if err != nil {
+			return err
+		}
+		subject, err := PriorityStat(Priority(sms.Priority))
+		if err != nil {
+			return err
+		}
+		msgID := fmt.Sprintf("dlr-%d-%s", sms.ID, r.Status)
+		_, err = s.JetStream.PublishMsg(ctx, &natsgo.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  events.Header(msgID, workerActor(), nats.RequestID(ctx), time.Now()),
+		}, jetstream.WithMsgID(msgID))
+		return err
+	}
+}
+
+// expireSubmit expires a stored sms whose validity period passed while it waited
+// for submission, refunding what it was charged like a cancelled one.
+func (s *Sms) expireSubmit(ctx context.Context, msg jetstream.Msg, sms sqlc.GetSmsForSubmitRow) {
+	logrus.Debugf("sms %d expired at %s before submission", sms.ID, sms.ExpiresAt.Time)
+	err := db.WithTx(ctx, s.db, func(tx pgx.Tx) error {
+		q := s.WithTx(tx)
+		qctx, cancel := s.queryCtx(ctx)
+		defer cancel()
+		pending, err := q.ExpirePendingSms(qctx, sms.ID)
+		if err != nil {
+			return err
+		}
+		_, err = q.RefundBalance(qctx, sqlc.RefundBalanceParams{
+			Amount: pending.Cost,
+			UserID: pending.UserID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to refund expired sms: %w", err)
+		}
+		return s.recorder.Record(qctx, q, sms.ID,
+			events.Event{
+				Name:     events.Expired,
+				Actor:    workerActor(),
+				Metadata: map[string]any{"from": status.Pending, "expires_at": sms.ExpiresAt.Time},
+			},
+			events.Event{
+				Name:     events.Refunded,
+				Actor:    workerActor(),
+				Metadata: map[string]any{"amount": pending.Cost},
+			},
+		)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// cancelled or expired by an earlier delivery in the meantime
+		msg.DoubleAck(ctx)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to expire sms %d: %s\n", sms.ID, err.Error())
+		s.fail(ctx, msg, failures.Failure{SmsID: sms.ID, Priority: sms.Priority, Stage: failures.StageSubmit, Class: failures.ClassOf(err, failures.ClassDatabase), Error: err.Error()})
+		return
+	}
+	metrics.Submissions.WithLabelValues(metrics.Org(sms.UserID), sms.Priority, "", submitExpired).Inc()
+	// committed first: a redelivery after a failed ack finds the sms no longer pending
+	err = msg.DoubleAck(ctx)
+	if err != nil {
+		logrus.Errorf("failed to DoubleAck: %s", err.Error())
+	}
+}
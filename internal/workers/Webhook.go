@@ -0,0 +1,162 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Webhook dispatches webhook deliveries. failed deliveries stay in the stream and are
+// retried with exponential backoff, the ones that fail on every attempt are kept as
+// dead letters in webhook_failures, from where users can retry them by hand.
+type Webhook struct {
+	*nats.Consumer
+	*sqlc.Queries
+	client       *http.Client
+	policy       webhooks.RetryPolicy
+	queryTimeout time.Duration
+}
+
+func NewWebhook(ctx context.Context, natsAddress string, pool *pgxpool.Pool) (*Webhook, error) {
+	nc, err := nats.Connect(natsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nats.NewConsumer(ctx, nc,
+		nats.WithOwnedConn(),
+		nats.WithReconcile(nats.ReconcileMode(viper.GetString("nats.reconcile"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &Webhook{
+		Consumer:     c,
+		Queries:      sqlc.New(pool),
+		client:       &http.Client{Timeout: viper.GetDuration("worker.webhooks.timeout")},
+		policy:       webhooks.RetryPolicyFromViper(),
+		queryTimeout: viper.GetDuration("worker.postgres.querytimeout"),
+	}
+	err = worker.BindConsumers(ctx, &nats.StreamConsumersConfig{
+		Stream: webhooks.StreamConfig(),
+		Consumers: []jetstream.ConsumerConfig{
+			{
+				Name:        WEBHOOKS_CONSUMER_NAME,
+				Durable:     WEBHOOKS_CONSUMER_NAME,
+				Description: "delivers webhooks",
+			},
+		},
+		Handlers: map[string]nats.Handler{
+			WEBHOOKS_CONSUMER_NAME: worker.deliver,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (w *Webhook) Start(ctx context.Context) error {
+	var errHandlerOpt jetstream.ConsumeErrHandler = func(_ jetstream.ConsumeContext, err error) {
+		logrus.Errorf("ConsumerError: %s\n", err)
+	}
+	w.Use(middlewares()...)
+	return w.StartConsumers(ctx, nil, errHandlerOpt)
+}
+
+// deliver posts a delivery. it is NAKed with the backoff of its attempt when the
+// endpoint fails, and stored as a dead letter once the attempts are used up.
+func (w *Webhook) deliver(ctx context.Context, msg jetstream.Msg) {
+	var d webhooks.Delivery
+	err := json.Unmarshal(msg.Data(), &d)
+	if err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+
+	secrets, ok := w.secrets(ctx, msg, d.WebhookID)
+	if !ok {
+		return
+	}
+	err = webhooks.Post(ctx, w.client, d, secrets...)
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		msg.DoubleAck(ctx)
+		return
+	}
+	var attempt uint64 = 1
+	if md, err := msg.Metadata(); err == nil {
+		attempt = md.NumDelivered
+	}
+	if !w.policy.Exhausted(attempt) {
+		delay := w.policy.Backoff(attempt)
+		logrus.Warnf("webhook %d attempt %d failed, retrying in %s: %s", d.WebhookID, attempt, delay, err)
+		metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+		nakErr := msg.NakWithDelay(delay)
+		if nakErr != nil {
+			logrus.Errorf("failed to NAK msg: %s\n", nakErr.Error())
+		}
+		return
+	}
+
+	logrus.Errorf("webhook %d failed after %d attempts: %s", d.WebhookID, attempt, err)
+	qctx, cancel := w.queryCtx(ctx)
+	_, dbErr := w.AddWebhookFailure(qctx, sqlc.AddWebhookFailureParams{
+		WebhookID: d.WebhookID,
+		Event:     d.Event,
+		Payload:   d.Payload,
+		Attempts:  int32(attempt),
+		LastError: err.Error(),
+	})
+	cancel()
+	if dbErr != nil {
+		logrus.Errorf("failed to add webhook failure: %s\n", dbErr.Error())
+		nak(ctx, msg)
+		return
+	}
+	metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+	msg.DoubleAck(ctx)
+}
+
+// secrets returns the secrets a delivery is signed with: the current one, and the
+// previous one while it is in its grace window. deliveries of deleted webhooks are dropped.
+func (w *Webhook) secrets(ctx context.Context, msg jetstream.Msg, webhookID int32) ([]string, bool) {
+	qctx, cancel := w.queryCtx(ctx)
+	defer cancel()
+	s, err := w.GetWebhookSecrets(qctx, webhookID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			msg.TermWithReason("webhook was deleted")
+			return nil, false
+		}
+		logrus.Errorf("failed to get webhook secrets: %s\n", err.Error())
+		nak(ctx, msg)
+		return nil, false
+	}
+	secrets := []string{s.Secret}
+	if s.PreviousSecret.Valid && s.PreviousSecretExpiresAt.Time.After(time.Now()) {
+		secrets = append(secrets, s.PreviousSecret.String)
+	}
+	return secrets, true
+}
+
+func (w *Webhook) queryCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if w.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, w.queryTimeout)
+}
@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// ClassSubmit is the class of the consumer submitting stored sms to the carrier,
+// next to the priorities, see AckPolicies.
+const ClassSubmit = "submit"
+
+// AckPolicy is how JetStream redelivers the messages of a consumer. zero fields
+// keep the defaults of the server.
+type AckPolicy struct {
+	// AckWait is how long a delivered message may go unacked before it is delivered again
+	AckWait time.Duration
+	// MaxDeliver caps the deliveries of a message, -1 doesn't
+	MaxDeliver int
+	// MaxAckPending caps the messages delivered and not acked yet, -1 doesn't
+	MaxAckPending int
+	// BackOff are the delays of the redeliveries of unacked messages instead of
+	// AckWait, the last one repeats
+	BackOff []time.Duration
+}
+
+// Validate fails on policies the server would refuse, or change on its own and
+// so drift from their config on every start.
+func (p AckPolicy) Validate() error {
+	var errs []error
+	if p.AckWait < 0 {
+		errs = append(errs, errors.New("ackwait can't be negative"))
+	}
+	if p.MaxDeliver < -1 {
+		errs = append(errs, errors.New("maxdeliver must be -1, 0 or more"))
+	}
+	if p.MaxAckPending < -1 {
+		errs = append(errs, errors.New("maxackpending must be -1, 0 or more"))
+	}
+	for _, d := range p.BackOff {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("backoff %s must be positive", d))
+		}
+	}
+	if len(p.BackOff) > 0 {
+		if p.AckWait > 0 {
+			// the server takes the first delay as the ack wait
+			errs = append(errs, errors.New("ackwait can't be set along with backoff, it is the first delay"))
+		}
+		if p.MaxDeliver > 0 && p.MaxDeliver <= len(p.BackOff) {
+			errs = append(errs, fmt.Errorf("maxdeliver (%d) must be more than the %d backoff delays", p.MaxDeliver, len(p.BackOff)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p AckPolicy) apply(c *jetstream.ConsumerConfig) {
+	c.AckWait = p.AckWait
+	c.MaxDeliver = p.MaxDeliver
+	c.MaxAckPending = p.MaxAckPending
+	c.BackOff = p.BackOff
+}
+
+// AckPolicies are the ack policies of the consumers of the sms worker by class:
+// the priority of the queue they consume, or ClassSubmit.
+type AckPolicies map[string]AckPolicy
+
+// ackClasses is the class of the consumers that have one.
+var ackClasses = map[string]string{
+	NORMAL_SMS_CONSUMER_NAME:  PriorityNormal,
+	EXPRESS_SMS_CONSUMER_NAME: PriorityExpress,
+	SUBMIT_CONSUMER_NAME:      ClassSubmit,
+}
+
+// AckPoliciesFromViper reads worker.consumers.<class> of every class and fails
+// unless they are valid.
+func AckPoliciesFromViper() (AckPolicies, error) {
+	policies := make(AckPolicies)
+	var errs []error
+	for _, class := range []string{PriorityNormal, PriorityExpress, ClassSubmit} {
+		prefix := "worker.consumers." + class + "."
+		p := AckPolicy{
+			AckWait:       viper.GetDuration(prefix + "ackwait"),
+			MaxDeliver:    viper.GetInt(prefix + "maxdeliver"),
+			MaxAckPending: viper.GetInt(prefix + "maxackpending"),
+		}
+		err := func() error {
+			for _, s := range viper.GetStringSlice(prefix + "backoff") {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("invalid backoff %q: %w", s, err)
+				}
+				p.BackOff = append(p.BackOff, d)
+			}
+			return p.Validate()
+		}()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("worker.consumers.%s: %w", class, err))
+			continue
+		}
+		policies[class] = p
+	}
+	return policies, errors.Join(errs...)
+}
+
+// apply sets the policy of their class on consumers.
+func (p AckPolicies) apply(consumers []jetstream.ConsumerConfig) {
+	for i := range consumers {
+		if policy, ok := p[ackClasses[consumers[i].Name]]; ok {
+			policy.apply(&consumers[i])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package workers_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/streams"
+	. "github.com/alireza-karampour/sms/internal/workers"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("AckPolicy", func() {
+	DescribeTable("validating",
+		func(p AckPolicy, valid bool) {
+			if valid {
+				Expect(p.Validate()).To(Succeed())
+			} else {
+				Expect(p.Validate()).To(HaveOccurred())
+			}
+		},
+		Entry("server defaults", AckPolicy{}, true),
+		Entry("no caps", AckPolicy{MaxDeliver: -1, MaxAckPending: -1}, true),
+		Entry("backoff", AckPolicy{MaxDeliver: 5, BackOff: []time.Duration{time.Second, time.Minute}}, true),
+		Entry("negative ack wait", AckPolicy{AckWait: -time.Second}, false),
+		Entry("max deliver below -1", AckPolicy{MaxDeliver: -2}, false),
+		Entry("max deliver within the backoff", AckPolicy{MaxDeliver: 2, BackOff: []time.Duration{time.Second, time.Minute}}, false),
+		Entry("ack wait along with backoff", AckPolicy{AckWait: time.Second, BackOff: []time.Duration{time.Second}}, false),
+		Entry("zero delay", AckPolicy{BackOff: []time.Duration{0}}, false),
+	)
+
+	Context("from viper", func() {
+		AfterEach(func() {
+			viper.Set("worker.consumers", nil)
+		})
+
+		It("should set the policy of their class on the consumers", func() {
+			viper.Set("worker.consumers.express.ackwait", "10s")
+			viper.Set("worker.consumers.express.maxackpending", 50)
+			viper.Set("worker.consumers.submit.maxdeliver", 10)
+			viper.Set("worker.consumers.submit.backoff", []string{"1s", "1m"})
+			policies, err := AckPoliciesFromViper()
+			Expect(err).NotTo(HaveOccurred())
+
+			consumers := make(map[string]jetstream.ConsumerConfig)
+			for _, config := range SmsConsumers(true, policies) {
+				for _, c := range config.Consumers {
+					consumers[c.Name] = c
+				}
+			}
+			Expect(consumers[EXPRESS_SMS_CONSUMER_NAME].AckWait).To(Equal(10 * time.Second))
+			Expect(consumers[EXPRESS_SMS_CONSUMER_NAME].MaxAckPending).To(Equal(50))
+			Expect(consumers[SUBMIT_CONSUMER_NAME].MaxDeliver).To(Equal(10))
+			Expect(consumers[SUBMIT_CONSUMER_NAME].BackOff).To(Equal([]time.Duration{time.Second, time.Minute}))
+			Expect(consumers[NORMAL_SMS_CONSUMER_NAME].AckWait).To(BeZero())
+			Expect(consumers[SMS_ERRORS_CONSUMER_NAME].AckWait).To(BeZero())
+		})
+
+		It("should name the class of invalid policies", func() {
+			viper.Set("worker.consumers.normal.backoff", []string{"soon"})
+			_, err := AckPoliciesFromViper()
+			Expect(err).To(MatchError(ContainSubstring("worker.consumers.normal")))
+		})
+	})
+})
@@ -0,0 +1,170 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type BackpressureConfig struct {
+	// Latency is the average transaction latency above which the worker pauses
+	Latency time.Duration
+	// ErrorRate is the ratio of failed transactions (0..1) above which the worker pauses
+	ErrorRate float64
+	// Window is the number of recent transactions the averages are computed over
+	Window int
+	// Probe is how often the database is pinged while paused
+	Probe time.Duration
+}
+
+func BackpressureConfigFromViper() BackpressureConfig {
+	return BackpressureConfig{
+		Latency:   viper.GetDuration("worker.backpressure.latency"),
+		ErrorRate: viper.GetFloat64("worker.backpressure.errorrate"),
+		Window:    viper.GetInt("worker.backpressure.window"),
+		Probe:     viper.GetDuration("worker.backpressure.probe"),
+	}
+}
+
+type txSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// Backpressure watches transaction latency and error rate over a sliding window
+// and tells the worker when to stop pulling messages.
+type Backpressure struct {
+	conf    BackpressureConfig
+	mu      sync.Mutex
+	samples []txSample
+	next    int
+	paused  bool
+}
+
+func NewBackpressure(conf BackpressureConfig) *Backpressure {
+	if conf.Window <= 0 {
+		conf.Window = 1
+	}
+	return &Backpressure{
+		conf:    conf,
+		samples: make([]txSample, 0, conf.Window),
+	}
+}
+
+// Observe records one transaction and reports whether the worker should pause.
+// it returns true only once per degradation; Reset must be called after recovery.
+func (b *Backpressure) Observe(latency time.Duration, err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := txSample{latency: latency, failed: err != nil}
+	if len(b.samples) < b.conf.Window {
+		b.samples = append(b.samples, s)
+	} else {
+		b.samples[b.next] = s
+	}
+	b.next = (b.next + 1) % b.conf.Window
+
+	if b.paused || len(b.samples) < b.conf.Window {
+		return false
+	}
+	if b.degraded() {
+		b.paused = true
+		return true
+	}
+	return false
+}
+
+func (b *Backpressure) degraded() bool {
+	var total time.Duration
+	var failed int
+	for _, s := range b.samples {
+		total += s.latency
+		if s.failed {
+			failed++
+		}
+	}
+	avg := total / time.Duration(len(b.samples))
+	rate := float64(failed) / float64(len(b.samples))
+	if b.conf.Latency > 0 && avg > b.conf.Latency {
+		return true
+	}
+	if b.conf.ErrorRate > 0 && rate > b.conf.ErrorRate {
+		return true
+	}
+	return false
+}
+
+// Reset forgets collected samples and clears the paused state.
+func (b *Backpressure) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.samples = b.samples[:0]
+	b.next = 0
+	b.paused = false
+}
+
+// WaitHealthy pings the database every Probe interval until a ping completes
+// faster than the configured latency threshold or ctx is done.
+func (b *Backpressure) WaitHealthy(ctx context.Context, pool *pgxpool.Pool) error {
+	t := time.NewTicker(b.conf.Probe)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		start := time.Now()
+		pingCtx, cancel := context.WithTimeout(ctx, b.conf.Probe)
+		err := pool.Ping(pingCtx)
+		cancel()
+		took := time.Since(start)
+		if err != nil {
+			logrus.Warnf("backpressure: database still unavailable: %s", err)
+			continue
+		}
+		if b.conf.Latency > 0 && took > b.conf.Latency {
+			logrus.Warnf("backpressure: database still slow: ping took %s", took)
+			continue
+		}
+		return nil
+	}
+}
+
+func (s *Sms) observeTx(ctx context.Context, start time.Time, err error) {
+	took := time.Since(start)
+	metrics.WorkerTxDuration.Observe(took.Seconds())
+	if err != nil {
+		metrics.WorkerTxErrors.Inc()
+	}
+	if s.bp == nil || !s.bp.Observe(took, err) {
+		return
+	}
+
+	logrus.Warn("backpressure: database degraded, pausing consumers")
+	s.Pause()
+	metrics.WorkerPauses.Inc()
+	metrics.WorkerPaused.Set(1)
+	go func() {
+		for {
+			err := s.bp.WaitHealthy(ctx, s.db)
+			if err != nil {
+				return
+			}
+			err = s.Resume()
+			if err == nil {
+				break
+			}
+			logrus.Errorf("backpressure: failed to resume consumers: %s", err)
+		}
+		s.bp.Reset()
+		metrics.WorkerPaused.Set(0)
+		logrus.Info("backpressure: database recovered, consumers resumed")
+	}()
+}
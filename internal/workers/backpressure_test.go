@@ -0,0 +1,61 @@
+package workers_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/workers"
+)
+
+var _ = Describe("Backpressure", func() {
+	var bp *Backpressure
+
+	BeforeEach(func() {
+		bp = NewBackpressure(BackpressureConfig{
+			Latency:   100 * time.Millisecond,
+			ErrorRate: 0.5,
+			Window:    4,
+		})
+	})
+
+	It("should not pause before the window is full", func() {
+		for range 3 {
+			Expect(bp.Observe(time.Second, nil)).To(BeFalse())
+		}
+	})
+
+	It("should pause when average latency is too high", func() {
+		for range 3 {
+			bp.Observe(time.Second, nil)
+		}
+		Expect(bp.Observe(time.Second, nil)).To(BeTrue())
+	})
+
+	It("should pause when error rate is too high", func() {
+		bp.Observe(time.Millisecond, nil)
+		bp.Observe(time.Millisecond, errors.New("boom"))
+		bp.Observe(time.Millisecond, errors.New("boom"))
+		Expect(bp.Observe(time.Millisecond, errors.New("boom"))).To(BeTrue())
+	})
+
+	It("should report a degradation only once until reset", func() {
+		for range 4 {
+			bp.Observe(time.Second, nil)
+		}
+		Expect(bp.Observe(time.Second, nil)).To(BeFalse())
+		bp.Reset()
+		for range 3 {
+			bp.Observe(time.Second, nil)
+		}
+		Expect(bp.Observe(time.Second, nil)).To(BeTrue())
+	})
+
+	It("should stay running on a healthy database", func() {
+		for range 10 {
+			Expect(bp.Observe(time.Millisecond, nil)).To(BeFalse())
+		}
+	})
+})
@@ -0,0 +1,76 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/events"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Deadlines are how long the sms of each priority may take from a worker picking
+// them up, i.e. storing them, to their submission to the carrier. priorities
+// without a deadline, or with 0, have none.
+type Deadlines map[string]time.Duration
+
+// DeadlinesFromViper reads worker.deadline.<priority> of every priority.
+func DeadlinesFromViper() Deadlines {
+	return Deadlines{
+		PriorityNormal:  viper.GetDuration("worker.deadline." + PriorityNormal),
+		PriorityExpress: viper.GetDuration("worker.deadline." + PriorityExpress),
+	}
+}
+
+// Breached reports whether an sms of priority picked up at pickedUp is past its
+// deadline at now.
+func (d Deadlines) Breached(priority string, pickedUp, now time.Time) bool {
+	deadline := d[priority]
+	return deadline > 0 && !pickedUp.IsZero() && now.Sub(pickedUp) > deadline
+}
+
+// checkDeadline reports whether sms missed the deadline of its priority, and so
+// is escalated. the breach is recorded and counted once, however often the
+// submission is retried after it.
+func (s *Sms) checkDeadline(ctx context.Context, sms sqlc.GetSmsForSubmitRow, req submitRequest) bool {
+	now := time.Now()
+	if !s.deadlines.Breached(sms.Priority, req.PickedUp, now) {
+		return false
+	}
+	var first bool
+	err := db.WithTx(ctx, s.db, func(tx pgx.Tx) error {
+		q := s.WithTx(tx)
+		qctx, cancel := s.queryCtx(ctx)
+		defer cancel()
+		n, err := q.MarkMessageProcessed(qctx, sqlc.MarkMessageProcessedParams{
+			MessageID: fmt.Sprintf("deadline-%d", sms.ID),
+			SmsID:     pgtype.Int4{Int32: sms.ID, Valid: true},
+		})
+		first = n > 0
+		if err != nil || !first {
+			return err
+		}
+		return s.recorder.Record(qctx, q, sms.ID, events.Event{
+			Name:  events.Breached,
+			Actor: workerActor(),
+			Metadata: map[string]any{
+				"deadline": s.deadlines[sms.Priority].String(),
+				"elapsed":  now.Sub(req.PickedUp).String(),
+			},
+		})
+	})
+	if err != nil {
+		// it is escalated all the same
+		logrus.Errorf("failed to record deadline breach of sms %d: %s", sms.ID, err)
+	} else if first {
+		logrus.Warnf("sms %d missed the %s deadline of %s sms", sms.ID, s.deadlines[sms.Priority], sms.Priority)
+		metrics.DeadlineBreaches.WithLabelValues(metrics.Org(sms.UserID), sms.Priority).Inc()
+	}
+	return true
+}
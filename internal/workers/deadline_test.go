@@ -0,0 +1,28 @@
+package workers_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/workers"
+)
+
+var _ = Describe("Deadlines", func() {
+	deadlines := Deadlines{PriorityExpress: 5 * time.Second}
+	pickedUp := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+
+	It("should breach once the deadline passed", func() {
+		Expect(deadlines.Breached(PriorityExpress, pickedUp, pickedUp.Add(5*time.Second))).To(BeFalse())
+		Expect(deadlines.Breached(PriorityExpress, pickedUp, pickedUp.Add(6*time.Second))).To(BeTrue())
+	})
+
+	It("should not breach priorities without a deadline", func() {
+		Expect(deadlines.Breached(PriorityNormal, pickedUp, pickedUp.Add(time.Hour))).To(BeFalse())
+	})
+
+	It("should not breach sms queued without a pickup time", func() {
+		Expect(deadlines.Breached(PriorityExpress, time.Time{}, pickedUp)).To(BeFalse())
+	})
+})
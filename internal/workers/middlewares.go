@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/nats"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func SettlePolicyFromViper() nats.SettlePolicy {
+	p := nats.SettlePolicy{
+		MaxDeliveries: viper.GetUint64("worker.retry.maxdeliveries"),
+	}
+	for _, s := range viper.GetStringSlice("worker.retry.backoff") {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			logrus.Warnf("ignoring invalid worker.retry.backoff entry %q: %s", s, err)
+			continue
+		}
+		p.Backoff = append(p.Backoff, d)
+	}
+	return p
+}
+
+// processed counts the messages the workers of this process handled
+var processed atomic.Uint64
+
+// Processed is the number of messages the workers of this process handled since
+// it started, see heartbeats.Beater.
+func Processed() uint64 {
+	return processed.Load()
+}
+
+// middlewares wraps the handlers of every worker. Settle and Recover are
+// innermost so the outer ones see the outcome they settle messages with.
+func middlewares() []nats.Middleware {
+	return []nats.Middleware{
+		nats.Count(&processed),
+		nats.Trace(),
+		nats.Logger(),
+		nats.Metrics(),
+		nats.Settle(SettlePolicyFromViper()),
+		nats.Recover(viper.GetUint64("worker.recover.maxdeliveries"), viper.GetDuration("worker.recover.delay")),
+	}
+}
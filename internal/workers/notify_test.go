@@ -0,0 +1,37 @@
+package workers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	. "github.com/alireza-karampour/sms/internal/workers"
+)
+
+var _ = Describe("Notify handlers", func() {
+	It("terminates notifications to invalid recipients", func() {
+		msg := &fakeMsg{data: []byte(`{"user_id":1,"to":"not an address","message":"hi"}`)}
+		(&Notify{}).Handler(channels.Email)(context.Background(), msg)
+		Expect(msg.termed).To(ContainSubstring("invalid recipient"))
+	})
+
+	It("terminates malformed notifications", func() {
+		msg := &fakeMsg{data: []byte(`{`)}
+		(&Notify{}).Handler(channels.Push)(context.Background(), msg)
+		Expect(msg.termed).NotTo(BeEmpty())
+	})
+
+	It("terminates calls to invalid numbers", func() {
+		msg := &fakeMsg{subject: "voice.send.request", data: []byte(`{"user_id":1,"to":"12","message":"code 1234"}`)}
+		(&Notify{}).Handler(channels.Voice)(context.Background(), msg)
+		Expect(msg.termed).To(ContainSubstring("not a phone number"))
+	})
+
+	It("applies status updates of voice calls", func() {
+		msg := &fakeMsg{subject: "voice.send.status", data: []byte(`{"id":1,"status":"bogus"}`)}
+		(&Notify{}).Handler(channels.Voice)(context.Background(), msg)
+		Expect(msg.termed).To(ContainSubstring("unknown sms status"))
+	})
+})
@@ -0,0 +1,38 @@
+package workers
+
+import (
+	"context"
+
+	"github.com/alireza-karampour/sms/internal/policy"
+	. "github.com/alireza-karampour/sms/internal/streams"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// hold settles msg when the account of userID may not send: the messages of
+// suspended users are parked until an admin resumes them, those of closed
+// accounts are dropped. it reports whether msg was settled.
+func hold(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, userID int32, account string) bool {
+	switch account {
+	case policy.AccountActive:
+		return false
+	case policy.AccountSuspended:
+		_, err := js.PublishMsg(ctx, Parked(msg, userID))
+		if err != nil {
+			logrus.Errorf("failed to park message: %s", err)
+			nak(ctx, msg)
+			return true
+		}
+		logrus.Debugf("parked message of suspended user %d", userID)
+		// a redelivery parks it twice, resuming both is harmless since the workers
+		// skip messages they already processed
+		err = msg.DoubleAck(ctx)
+		if err != nil {
+			logrus.Errorf("failed to DoubleAck: %s", err)
+		}
+		return true
+	default:
+		msg.TermWithReason(policy.AccountError(account).Error())
+		return true
+	}
+}
@@ -0,0 +1,157 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/channels"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrPreflight is what the readiness check of a Preflight fails with until it passed
+var ErrPreflight = errors.New("preflight checks haven't passed yet")
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string { return p.err.Error() }
+func (p *permanent) Unwrap() error { return p.err }
+
+// Permanent makes a preflight check with err fail at once instead of being
+// retried, for errors retrying doesn't fix like an invalid config.
+func Permanent(err error) error {
+	return &permanent{err: err}
+}
+
+type PreflightConfig struct {
+	// Backoff is the wait before the first retry, doubled before every other
+	// up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is how often the checks run before the worker gives up, 0
+	// retries until it is stopped
+	MaxAttempts int
+	// Timeout bounds every check, no limit when zero
+	Timeout time.Duration
+}
+
+func PreflightConfigFromViper() PreflightConfig {
+	return PreflightConfig{
+		Backoff:     viper.GetDuration("worker.preflight.backoff.initial"),
+		MaxBackoff:  viper.GetDuration("worker.preflight.backoff.max"),
+		MaxAttempts: viper.GetInt("worker.preflight.maxattempts"),
+		Timeout:     viper.GetDuration("worker.preflight.timeout"),
+	}
+}
+
+type preflightCheck struct {
+	name   string
+	check  func(ctx context.Context) error
+	passed bool
+}
+
+// Preflight verifies what the worker needs before it consumes, so an sms isn't
+// pulled only to be NAKed because the database or the provider is unusable. the
+// checks run in the order they were added, later ones may depend on earlier
+// ones, and a check that passed doesn't run again.
+type Preflight struct {
+	conf   PreflightConfig
+	checks []*preflightCheck
+	passed atomic.Bool
+}
+
+func NewPreflight(conf PreflightConfig) *Preflight {
+	return &Preflight{conf: conf}
+}
+
+// Add appends a check named name.
+func (p *Preflight) Add(name string, check func(ctx context.Context) error) {
+	p.checks = append(p.checks, &preflightCheck{name: name, check: check})
+}
+
+// Ready fails with ErrPreflight until every check passed, it is a readiness
+// check of the worker.
+func (p *Preflight) Ready(ctx context.Context) error {
+	if !p.passed.Load() {
+		return ErrPreflight
+	}
+	return nil
+}
+
+// Run runs the checks until all of them passed, waiting with backoff after a
+// failed one. it gives up on a Permanent error, after MaxAttempts or once ctx is
+// done.
+func (p *Preflight) Run(ctx context.Context) error {
+	backoff := p.conf.Backoff
+	for attempt := 1; ; attempt++ {
+		c, err := p.attempt(ctx)
+		if err == nil {
+			p.passed.Store(true)
+			return nil
+		}
+		err = fmt.Errorf("preflight %s: %w", c.name, err)
+		var perm *permanent
+		if errors.As(err, &perm) || (p.conf.MaxAttempts > 0 && attempt >= p.conf.MaxAttempts) {
+			return err
+		}
+		logrus.Warnf("%s, retrying in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, p.conf.MaxBackoff)
+	}
+}
+
+// attempt runs the checks that didn't pass yet up to the first failing one.
+func (p *Preflight) attempt(ctx context.Context) (*preflightCheck, error) {
+	for _, c := range p.checks {
+		if c.passed {
+			continue
+		}
+		err := p.run(ctx, c)
+		if err != nil {
+			return c, err
+		}
+		c.passed = true
+		logrus.Infof("preflight %s passed", c.name)
+	}
+	return nil, nil
+}
+
+func (p *Preflight) run(ctx context.Context, c *preflightCheck) error {
+	if p.conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.conf.Timeout)
+		defer cancel()
+	}
+	return c.check(ctx)
+}
+
+// CheckPricing fails when sms.cost, sms.byop.fee or the <channel>.cost of a
+// channel isn't a non negative decimal. the worker would charge a default price
+// instead of what was configured otherwise.
+func CheckPricing() error {
+	keys := []string{"sms.cost", "sms.byop.fee"}
+	for _, channel := range channels.All {
+		if channel != channels.Sms {
+			keys = append(keys, channel+".cost")
+		}
+	}
+	var errs []error
+	for _, key := range keys {
+		var n pgtype.Numeric
+		err := n.Scan(viper.GetString(key))
+		if err != nil || !n.Valid || n.NaN || n.InfinityModifier != pgtype.Finite || n.Int.Sign() < 0 {
+			errs = append(errs, fmt.Errorf("%s must be a non negative decimal, got %q", key, viper.GetString(key)))
+		}
+	}
+	return errors.Join(errs...)
+}
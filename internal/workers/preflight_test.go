@@ -0,0 +1,102 @@
+package workers_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	. "github.com/alireza-karampour/sms/internal/workers"
+)
+
+var _ = Describe("Preflight", func() {
+	conf := PreflightConfig{Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+	errDown := errors.New("down")
+
+	It("should retry failing checks without running passed ones again", func() {
+		p := NewPreflight(conf)
+		var first, second int
+		p.Add("first", func(ctx context.Context) error {
+			first++
+			return nil
+		})
+		p.Add("second", func(ctx context.Context) error {
+			second++
+			if second < 3 {
+				return errDown
+			}
+			return nil
+		})
+		Expect(p.Ready(context.Background())).To(MatchError(ErrPreflight))
+		Expect(p.Run(context.Background())).To(Succeed())
+		Expect(first).To(Equal(1))
+		Expect(second).To(Equal(3))
+		Expect(p.Ready(context.Background())).To(Succeed())
+	})
+
+	It("should not run the checks after a failing one", func() {
+		c := conf
+		c.MaxAttempts = 2
+		p := NewPreflight(c)
+		ran := false
+		p.Add("postgres", func(ctx context.Context) error { return errDown })
+		p.Add("provider", func(ctx context.Context) error {
+			ran = true
+			return nil
+		})
+		err := p.Run(context.Background())
+		Expect(err).To(MatchError(errDown))
+		Expect(err).To(MatchError(ContainSubstring("preflight postgres")))
+		Expect(ran).To(BeFalse())
+		Expect(p.Ready(context.Background())).To(MatchError(ErrPreflight))
+	})
+
+	It("should give up on permanent errors", func() {
+		p := NewPreflight(conf)
+		attempts := 0
+		p.Add("pricing", func(ctx context.Context) error {
+			attempts++
+			return Permanent(errDown)
+		})
+		Expect(p.Run(context.Background())).To(MatchError(errDown))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should stop retrying once stopped", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		p := NewPreflight(PreflightConfig{Backoff: time.Hour, MaxBackoff: time.Hour})
+		p.Add("provider", func(ctx context.Context) error {
+			cancel()
+			return errDown
+		})
+		Expect(p.Run(ctx)).To(MatchError(context.Canceled))
+	})
+
+	Context("CheckPricing", func() {
+		BeforeEach(func() {
+			for _, key := range []string{"sms.cost", "sms.byop.fee", "email.cost", "push.cost", "voice.cost", "whatsapp.cost", "telegram.cost"} {
+				DeferCleanup(viper.Set, key, viper.GetString(key))
+				viper.Set(key, "0")
+			}
+		})
+
+		It("should accept non negative decimals", func() {
+			viper.Set("sms.cost", "5.25")
+			Expect(CheckPricing()).To(Succeed())
+		})
+
+		It("should name every invalid price", func() {
+			viper.Set("sms.cost", "five")
+			viper.Set("voice.cost", "-1")
+			viper.Set("push.cost", "NaN")
+			err := CheckPricing()
+			Expect(err).To(MatchError(ContainSubstring("sms.cost")))
+			Expect(err).To(MatchError(ContainSubstring("voice.cost")))
+			Expect(err).To(MatchError(ContainSubstring("push.cost")))
+			Expect(err).ToNot(MatchError(ContainSubstring("email.cost")))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/sqlc"
+)
+
+// blockedDB is a database whose every statement hangs until its context is done,
+// like one stuck behind a lock.
+type blockedDB struct{}
+
+func (blockedDB) Exec(ctx context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	<-ctx.Done()
+	return pgconn.CommandTag{}, ctx.Err()
+}
+
+func (blockedDB) Query(ctx context.Context, _ string, _ ...any) (pgx.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockedDB) QueryRow(ctx context.Context, _ string, _ ...any) pgx.Row {
+	return blockedRow{ctx: ctx}
+}
+
+func (blockedDB) CopyFrom(ctx context.Context, _ pgx.Identifier, _ []string, _ pgx.CopyFromSource) (int64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+type blockedRow struct {
+	ctx context.Context
+}
+
+func (r blockedRow) Scan(...any) error {
+	<-r.ctx.Done()
+	return r.ctx.Err()
+}
+
+// settledMsg records how a handler settled it.
+type settledMsg struct {
+	jetstream.Msg
+	data   []byte
+	acked  bool
+	naked  bool
+	termed bool
+}
+
+func (m *settledMsg) Subject() string        { return streams.SubmitSubject }
+func (m *settledMsg) Data() []byte           { return m.data }
+func (m *settledMsg) Headers() natsgo.Header { return nil }
+func (m *settledMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return nil, errors.New("not a jetstream message")
+}
+func (m *settledMsg) Ack() error                       { m.acked = true; return nil }
+func (m *settledMsg) DoubleAck(context.Context) error  { m.acked = true; return nil }
+func (m *settledMsg) Nak() error                       { m.naked = true; return nil }
+func (m *settledMsg) NakWithDelay(time.Duration) error { m.naked = true; return nil }
+func (m *settledMsg) Term() error                      { m.termed = true; return nil }
+func (m *settledMsg) TermWithReason(string) error      { m.termed = true; return nil }
+func (m *settledMsg) InProgress() error                { return nil }
+
+var _ = Describe("query timeouts", func() {
+	var worker *Sms
+
+	BeforeEach(func() {
+		worker = &Sms{
+			Queries:      sqlc.New(blockedDB{}),
+			queryTimeout: 50 * time.Millisecond,
+		}
+	})
+
+	It("should NAK a submission whose query timed out", func() {
+		msg := &settledMsg{data: []byte(`{"sms_id":1}`)}
+		start := time.Now()
+		worker.processSubmit(context.Background(), msg)
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(msg.naked).To(BeTrue())
+		Expect(msg.acked).To(BeFalse())
+		Expect(msg.termed).To(BeFalse())
+	})
+
+	It("should NAK a failure whose query timed out", func() {
+		msg := &settledMsg{data: []byte(`{"message_id":"m","stage":"store","class":"database","error":"boom"}`)}
+		worker.processFailure(context.Background(), msg)
+		Expect(msg.naked).To(BeTrue())
+		Expect(msg.acked).To(BeFalse())
+		Expect(msg.termed).To(BeFalse())
+	})
+
+	It("should not bound queries without a timeout", func() {
+		worker.queryTimeout = 0
+		ctx, cancel := worker.queryCtx(context.Background())
+		defer cancel()
+		_, ok := ctx.Deadline()
+		Expect(ok).To(BeFalse())
+	})
+})
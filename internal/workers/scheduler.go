@@ -0,0 +1,293 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var ErrReservation = errors.New("reservations need a user_id and a positive rate")
+
+// Reservation is the least throughput guaranteed to the sms of a tenant.
+type Reservation struct {
+	UserID int32 `mapstructure:"user_id"`
+	// Rate is in sms per second
+	Rate float64 `mapstructure:"rate"`
+}
+
+// ReservationsFromViper reads worker.reservations into the reserved rate of every tenant.
+func ReservationsFromViper() (map[int32]float64, error) {
+	var list []Reservation
+	err := viper.UnmarshalKey("worker.reservations", &list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read worker.reservations: %w", err)
+	}
+	reserved := make(map[int32]float64, len(list))
+	for _, r := range list {
+		if r.UserID <= 0 || r.Rate <= 0 {
+			return nil, fmt.Errorf("%w, got %+v", ErrReservation, r)
+		}
+		reserved[r.UserID] = r.Rate
+	}
+	return reserved, nil
+}
+
+type SchedulerConfig struct {
+	// Slots is how many sms the priorities may process at once, together
+	Slots int
+	// Weights is how many slots a priority is granted per round while others wait
+	Weights map[string]int
+	// Aging is how long a normal sms may wait in its queue before it is scheduled
+	// as express. 0 disables aging
+	Aging time.Duration
+	// Reservations weigh the tenants within a priority by their reserved rate
+	Reservations map[int32]float64
+	// TenantWeight is the weight of the tenants without a reservation
+	TenantWeight float64
+}
+
+func SchedulerConfigFromViper() (SchedulerConfig, error) {
+	reserved, err := ReservationsFromViper()
+	if err != nil {
+		return SchedulerConfig{}, err
+	}
+	return SchedulerConfig{
+		Slots: viper.GetInt("worker.scheduler.slots"),
+		Weights: map[string]int{
+			PriorityExpress: viper.GetInt("worker.scheduler.weights.express"),
+			PriorityNormal:  viper.GetInt("worker.scheduler.weights.normal"),
+		},
+		Aging:        viper.GetDuration("worker.scheduler.aging"),
+		Reservations: reserved,
+		TenantWeight: viper.GetFloat64("worker.scheduler.tenantweight"),
+	}, nil
+}
+
+// schedulingOrder is the order priorities are served in within a round
+var schedulingOrder = []string{PriorityExpress, PriorityNormal}
+
+// Scheduler shares the worker's capacity between the priorities by weighted round
+// robin: while several priorities wait, each round grants every priority as many
+// slots as its weight, so a busy express queue can't starve the normal one. within
+// a priority, the slots are shared between the tenants by weighted fair queuing,
+// see fairQueue.
+type Scheduler struct {
+	conf SchedulerConfig
+
+	mu      sync.Mutex
+	free    int
+	credits map[string]int
+	waiting map[string]*fairQueue
+}
+
+// waiter is an sms waiting for a slot.
+type waiter struct {
+	ready chan struct{}
+	// start and finish are the virtual times the sms is served from and by, see
+	// fairQueue.push
+	start, finish float64
+	seq           uint64
+}
+
+// fairQueue queues the sms of a priority per tenant and serves the one with the
+// earliest virtual finish time: every sms of a tenant advances the tenant's finish
+// time by 1/weight, so while several tenants wait, each is served in proportion
+// to its weight, and a tenant with nothing waiting doesn't bank any share.
+type fairQueue struct {
+	vtime   float64
+	seq     uint64
+	len     int
+	tenants map[int32][]*waiter
+	// last is the finish time of the last sms queued per tenant
+	last map[int32]float64
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{tenants: make(map[int32][]*waiter), last: make(map[int32]float64)}
+}
+
+func (q *fairQueue) push(tenant int32, weight float64) *waiter {
+	q.seq++
+	start := max(q.vtime, q.last[tenant])
+	w := &waiter{
+		ready:  make(chan struct{}),
+		start:  start,
+		finish: start + 1/weight,
+		seq:    q.seq,
+	}
+	q.last[tenant] = w.finish
+	q.tenants[tenant] = append(q.tenants[tenant], w)
+	q.len++
+	return w
+}
+
+// pop takes the waiter with the earliest finish time, the first queued on ties.
+func (q *fairQueue) pop() *waiter {
+	var (
+		best   *waiter
+		tenant int32
+	)
+	for t, list := range q.tenants {
+		if w := list[0]; best == nil || w.finish < best.finish || (w.finish == best.finish && w.seq < best.seq) {
+			best, tenant = w, t
+		}
+	}
+	q.remove(tenant, best)
+	q.vtime = max(q.vtime, best.start)
+	// forget the tenants that are idle and caught up with the virtual time
+	for t, last := range q.last {
+		if _, ok := q.tenants[t]; !ok && last <= q.vtime {
+			delete(q.last, t)
+		}
+	}
+	return best
+}
+
+func (q *fairQueue) remove(tenant int32, w *waiter) bool {
+	list := q.tenants[tenant]
+	for i, x := range list {
+		if x == w {
+			list = append(list[:i], list[i+1:]...)
+			if len(list) == 0 {
+				delete(q.tenants, tenant)
+			} else {
+				q.tenants[tenant] = list
+			}
+			q.len--
+			return true
+		}
+	}
+	return false
+}
+
+func NewScheduler(conf SchedulerConfig) *Scheduler {
+	if conf.Slots <= 0 {
+		conf.Slots = 1
+	}
+	weights := make(map[string]int, len(schedulingOrder))
+	for _, p := range schedulingOrder {
+		weights[p] = max(conf.Weights[p], 1)
+	}
+	conf.Weights = weights
+	if conf.TenantWeight <= 0 {
+		conf.TenantWeight = 1
+	}
+	s := &Scheduler{
+		conf:    conf,
+		free:    conf.Slots,
+		credits: make(map[string]int),
+		waiting: make(map[string]*fairQueue, len(schedulingOrder)),
+	}
+	for _, p := range schedulingOrder {
+		s.waiting[p] = newFairQueue()
+	}
+	s.refill()
+	return s
+}
+
+// Class is the priority an sms of priority that was queued at queuedAt is
+// scheduled as. normal sms older than Aging are boosted to express.
+func (s *Scheduler) Class(priority string, queuedAt, now time.Time) string {
+	if priority == PriorityNormal && s.conf.Aging > 0 && !queuedAt.IsZero() && now.Sub(queuedAt) > s.conf.Aging {
+		return PriorityExpress
+	}
+	return priority
+}
+
+// Weight is the share of tenant within its priority: its reserved rate, or
+// TenantWeight without a reservation.
+func (s *Scheduler) Weight(tenant int32) float64 {
+	if rate, ok := s.conf.Reservations[tenant]; ok {
+		return rate
+	}
+	return s.conf.TenantWeight
+}
+
+// Acquire waits for a slot for an sms of tenant scheduled as class. the returned
+// func releases it.
+func (s *Scheduler) Acquire(ctx context.Context, class string, tenant int32) (func(), error) {
+	s.mu.Lock()
+	queue, ok := s.waiting[class]
+	if !ok {
+		queue = newFairQueue()
+		s.waiting[class] = queue
+	}
+	w := queue.push(tenant, s.Weight(tenant))
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if queue.remove(tenant, w) {
+			return nil, ctx.Err()
+		}
+		// granted while giving up, hand the slot on
+		s.free++
+		s.dispatch()
+		return nil, ctx.Err()
+	}
+}
+
+// Waiting is the number of sms of class waiting for a slot.
+func (s *Scheduler) Waiting(class string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if queue, ok := s.waiting[class]; ok {
+		return queue.len
+	}
+	return 0
+}
+
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.free++
+	s.dispatch()
+}
+
+// dispatch grants free slots to the waiting priorities that have credits left in
+// this round, starting a new round once none has.
+func (s *Scheduler) dispatch() {
+	for s.free > 0 {
+		class, ok := s.next()
+		if !ok {
+			return
+		}
+		w := s.waiting[class].pop()
+		s.credits[class]--
+		s.free--
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) next() (string, bool) {
+	waiting := false
+	for _, p := range schedulingOrder {
+		if s.waiting[p].len == 0 {
+			continue
+		}
+		waiting = true
+		if s.credits[p] > 0 {
+			return p, true
+		}
+	}
+	if !waiting {
+		return "", false
+	}
+	s.refill()
+	return s.next()
+}
+
+func (s *Scheduler) refill() {
+	for p, w := range s.conf.Weights {
+		s.credits[p] = w
+	}
+}
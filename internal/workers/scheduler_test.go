@@ -0,0 +1,135 @@
+package workers_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/internal/workers"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Scheduler", func() {
+	var sched *Scheduler
+
+	BeforeEach(func() {
+		sched = NewScheduler(SchedulerConfig{
+			Slots:   1,
+			Weights: map[string]int{PriorityExpress: 3, PriorityNormal: 1},
+			Aging:   time.Minute,
+		})
+	})
+
+	It("should grant a free slot right away", func() {
+		release, err := sched.Acquire(context.Background(), PriorityNormal, 1)
+		Expect(err).NotTo(HaveOccurred())
+		release()
+	})
+
+	It("should share the slots by weight while both priorities wait", func() {
+		hold, err := sched.Acquire(context.Background(), PriorityExpress, 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		var (
+			mu     sync.Mutex
+			order  []string
+			served sync.WaitGroup
+		)
+		for _, class := range []string{PriorityExpress, PriorityNormal} {
+			for range 4 {
+				served.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer served.Done()
+					release, err := sched.Acquire(context.Background(), class, 1)
+					Expect(err).NotTo(HaveOccurred())
+					mu.Lock()
+					order = append(order, class)
+					mu.Unlock()
+					release()
+				}()
+			}
+		}
+		Eventually(func() int {
+			return sched.Waiting(PriorityExpress) + sched.Waiting(PriorityNormal)
+		}).Should(Equal(8))
+
+		hold()
+		served.Wait()
+		e, n := PriorityExpress, PriorityNormal
+		// the held slot spent one express credit of the first round
+		Expect(order).To(Equal([]string{e, e, n, e, e, n, n, n}))
+	})
+
+	It("should share a priority between the tenants by their reservations", func() {
+		sched = NewScheduler(SchedulerConfig{
+			Slots:        1,
+			Reservations: map[int32]float64{7: 5},
+			TenantWeight: 2,
+		})
+		hold, err := sched.Acquire(context.Background(), PriorityNormal, 9)
+		Expect(err).NotTo(HaveOccurred())
+
+		var (
+			mu     sync.Mutex
+			order  []int32
+			served sync.WaitGroup
+		)
+		for _, tenant := range []int32{7, 8} {
+			for range 4 {
+				served.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer served.Done()
+					release, err := sched.Acquire(context.Background(), PriorityNormal, tenant)
+					Expect(err).NotTo(HaveOccurred())
+					mu.Lock()
+					order = append(order, tenant)
+					mu.Unlock()
+					release()
+				}()
+			}
+		}
+		Eventually(func() int { return sched.Waiting(PriorityNormal) }).Should(Equal(8))
+
+		hold()
+		served.Wait()
+		// tenant 7 is served 5 times for every 2 of tenant 8 while both wait
+		Expect(order).To(Equal([]int32{7, 7, 8, 7, 7, 8, 8, 8}))
+		Expect(sched.Weight(7)).To(Equal(5.0))
+		Expect(sched.Weight(8)).To(Equal(2.0))
+	})
+
+	It("should read the reservations from viper", func() {
+		DeferCleanup(viper.Set, "worker.reservations", []map[string]any{})
+		viper.Set("worker.reservations", []map[string]any{{"user_id": 7, "rate": 50}})
+		reserved, err := ReservationsFromViper()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reserved).To(Equal(map[int32]float64{7: 50}))
+
+		viper.Set("worker.reservations", []map[string]any{{"user_id": 7}})
+		_, err = ReservationsFromViper()
+		Expect(err).To(MatchError(ErrReservation))
+	})
+
+	It("should give up waiting when ctx is done", func() {
+		hold, err := sched.Acquire(context.Background(), PriorityExpress, 1)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = sched.Acquire(ctx, PriorityNormal, 1)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(sched.Waiting(PriorityNormal)).To(BeZero())
+		hold()
+	})
+
+	It("should boost normal sms that waited longer than the aging", func() {
+		now := time.Now()
+		Expect(sched.Class(PriorityNormal, now.Add(-2*time.Minute), now)).To(Equal(PriorityExpress))
+		Expect(sched.Class(PriorityNormal, now.Add(-time.Second), now)).To(Equal(PriorityNormal))
+		Expect(sched.Class(PriorityExpress, now.Add(-2*time.Minute), now)).To(Equal(PriorityExpress))
+	})
+})
@@ -0,0 +1,53 @@
+package workers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	. "github.com/alireza-karampour/sms/internal/workers"
+)
+
+// fakeMsg records how a handler settled it.
+type fakeMsg struct {
+	jetstream.Msg
+	subject string
+	data    []byte
+	termed  string
+}
+
+func (m *fakeMsg) Subject() string { return m.subject }
+func (m *fakeMsg) Data() []byte    { return m.data }
+func (m *fakeMsg) TermWithReason(reason string) error {
+	m.termed = reason
+	return nil
+}
+
+var _ = Describe("Sms handlers", func() {
+	var worker *Sms
+
+	BeforeEach(func() {
+		worker = &Sms{}
+	})
+
+	It("processes requests of its own priority", func() {
+		msg := &fakeMsg{subject: "sms.ex.send.request", data: []byte("not json")}
+		worker.Handler(PriorityExpress)(context.Background(), msg)
+		Expect(msg.termed).NotTo(BeEmpty())
+	})
+
+	It("processes status updates of its own priority", func() {
+		msg := &fakeMsg{subject: "sms.send.status", data: []byte(`{"id":1,"status":"bogus"}`)}
+		worker.Handler(PriorityNormal)(context.Background(), msg)
+		Expect(msg.termed).To(ContainSubstring("unknown sms status"))
+	})
+
+	It("ignores subjects of the other priority", func() {
+		msg := &fakeMsg{subject: "sms.send.request", data: []byte("not json")}
+		worker.Handler(PriorityExpress)(context.Background(), msg)
+		Expect(msg.termed).To(BeEmpty())
+	})
+})
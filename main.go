@@ -3,6 +3,12 @@ package main
 import (
 	"github.com/alireza-karampour/sms/cmd"
 	_ "github.com/alireza-karampour/sms/cmd/api"
+	_ "github.com/alireza-karampour/sms/cmd/apidocs"
+	_ "github.com/alireza-karampour/sms/cmd/dashboards"
+	_ "github.com/alireza-karampour/sms/cmd/doctor"
+	_ "github.com/alireza-karampour/sms/cmd/replay"
+	_ "github.com/alireza-karampour/sms/cmd/serve"
+	_ "github.com/alireza-karampour/sms/cmd/tail"
 	_ "github.com/alireza-karampour/sms/cmd/worker"
 )
 
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// scopes granted to API keys
+const (
+	ScopeSmsSend   = "sms:send"
+	ScopeSmsRead   = "sms:read"
+	ScopeUserRead  = "user:read"
+	ScopeUserWrite = "user:write"
+	// ScopeUserAdmin grants every other scope and access to any user's resources
+	ScopeUserAdmin = "user:admin"
+	// ScopeReseller lets a reseller manage its customers, see /reseller
+	ScopeReseller = "reseller:admin"
+	// ScopeSmsReadFull shows destination numbers and message bodies unmasked,
+	// see Unmasked in middlewares
+	ScopeSmsReadFull = "sms:read:full"
+)
+
+// Scopes lists every known scope.
+var Scopes = []string{
+	ScopeSmsSend,
+	ScopeSmsRead,
+	ScopeSmsReadFull,
+	ScopeUserRead,
+	ScopeUserWrite,
+	ScopeUserAdmin,
+	ScopeReseller,
+}
+
+var (
+	ErrUnknownScope = errors.New("unknown scope")
+	ErrNoScopes     = errors.New("at least one scope is required")
+	ErrInvalidCIDR  = errors.New("invalid cidr")
+)
+
+const (
+	// KeyPrefix starts every API key so leaked keys are easy to recognize
+	KeyPrefix = "sms_"
+	// prefixLen is how many leading characters of a key are stored in clear to identify it
+	prefixLen = 12
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	KeyID    int32
+	UserID   int32
+	Username string
+	Scopes   []string
+	// AllowedCIDRs restricts the client addresses the key may be used from. empty allows any.
+	AllowedCIDRs []netip.Prefix
+	// RateLimit replaces api.ratelimit.key.limit while positive, e.g. for keys the fraud detector throttled
+	RateLimit int
+	// Subaccounts are the usernames of the subaccounts of the user by their id,
+	// the user acts on their resources too
+	Subaccounts map[int32]string
+}
+
+// Has reports whether the principal was granted scope, either directly or through ScopeUserAdmin.
+func (p *Principal) Has(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeUserAdmin)
+}
+
+// Owns reports whether the principal may act on the resources of the given user.
+func (p *Principal) Owns(userID int32) bool {
+	_, sub := p.Subaccounts[userID]
+	return p.UserID == userID || sub || p.Has(ScopeUserAdmin)
+}
+
+// OwnsUsername is Owns for the user named username.
+func (p *Principal) OwnsUsername(username string) bool {
+	if p.Username == username || p.Has(ScopeUserAdmin) {
+		return true
+	}
+	for _, sub := range p.Subaccounts {
+		if sub == username {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP reports whether the key may be used from ip.
+func (p *Principal) AllowsIP(ip netip.Addr) bool {
+	if len(p.AllowedCIDRs) == 0 {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, c := range p.AllowedCIDRs {
+		if c.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses CIDR ranges, accepting bare addresses as single host ranges.
+// host bits are cleared, as PostgreSQL's cidr type rejects them.
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(c)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, c)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		res = append(res, p.Masked())
+	}
+	return res, nil
+}
+
+// ValidateScopes checks that scopes is non empty and only holds known scopes.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	for _, s := range scopes {
+		if !slices.Contains(Scopes, s) {
+			return fmt.Errorf("%w: %q", ErrUnknownScope, s)
+		}
+	}
+	return nil
+}
+
+// GenerateKey returns a new random API key together with its display prefix and the hash to store.
+func GenerateKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", "", err
+	}
+	key = KeyPrefix + hex.EncodeToString(b)
+	return key, key[:prefixLen], HashKey(key), nil
+}
+
+// HashKey returns the hex encoded SHA-256 of key. only hashes are persisted.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package auth_test
+
+import (
+	"net/netip"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/auth"
+)
+
+var _ = Describe("Auth", func() {
+	It("should only grant listed scopes", func() {
+		p := &Principal{UserID: 1, Scopes: []string{ScopeSmsSend}}
+		Expect(p.Has(ScopeSmsSend)).To(BeTrue())
+		Expect(p.Has(ScopeSmsRead)).To(BeFalse())
+		Expect(p.Owns(1)).To(BeTrue())
+		Expect(p.Owns(2)).To(BeFalse())
+	})
+
+	It("should grant everything to user:admin", func() {
+		p := &Principal{UserID: 1, Scopes: []string{ScopeUserAdmin}}
+		Expect(p.Has(ScopeSmsRead)).To(BeTrue())
+		Expect(p.Owns(2)).To(BeTrue())
+	})
+
+	It("should own the resources of subaccounts", func() {
+		p := &Principal{UserID: 1, Username: "reseller", Subaccounts: map[int32]string{3: "shop"}}
+		Expect(p.Owns(3)).To(BeTrue())
+		Expect(p.OwnsUsername("shop")).To(BeTrue())
+		Expect(p.OwnsUsername("reseller")).To(BeTrue())
+		Expect(p.Owns(2)).To(BeFalse())
+		Expect(p.OwnsUsername("other")).To(BeFalse())
+	})
+
+	It("should reject unknown or missing scopes", func() {
+		Expect(ValidateScopes([]string{ScopeSmsSend, ScopeUserRead})).To(Succeed())
+		Expect(ValidateScopes(nil)).To(MatchError(ErrNoScopes))
+		Expect(ValidateScopes([]string{"sms:delete"})).To(MatchError(ErrUnknownScope))
+	})
+
+	It("should restrict keys to their allowed cidrs", func() {
+		cidrs, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"})
+		Expect(err).NotTo(HaveOccurred())
+		p := &Principal{AllowedCIDRs: cidrs}
+		Expect(p.AllowsIP(netip.MustParseAddr("10.1.2.3"))).To(BeTrue())
+		Expect(p.AllowsIP(netip.MustParseAddr("::ffff:10.1.2.3"))).To(BeTrue())
+		Expect(p.AllowsIP(netip.MustParseAddr("192.168.1.7"))).To(BeTrue())
+		Expect(p.AllowsIP(netip.MustParseAddr("192.168.1.8"))).To(BeFalse())
+		Expect(p.AllowsIP(netip.MustParseAddr("2001:db8::1"))).To(BeTrue())
+
+		Expect((&Principal{}).AllowsIP(netip.MustParseAddr("8.8.8.8"))).To(BeTrue())
+	})
+
+	It("should normalize and validate cidrs", func() {
+		cidrs, err := ParseCIDRs([]string{"10.1.2.3/8"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cidrs[0].String()).To(Equal("10.0.0.0/8"))
+
+		_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+		Expect(err).To(MatchError(ErrInvalidCIDR))
+	})
+
+	It("should generate distinct keys identified by their hash", func() {
+		key, prefix, hash, err := GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(HavePrefix(KeyPrefix))
+		Expect(strings.HasPrefix(key, prefix)).To(BeTrue())
+		Expect(hash).To(Equal(HashKey(key)))
+
+		other, _, _, err := GenerateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(key))
+	})
+})
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/spf13/viper"
+)
+
+// TokenPrefix starts every service token, so they are told apart from API keys
+const TokenPrefix = "smst_"
+
+// how the api verifies signed service tokens, see api.auth.tokens.verify
+const (
+	// VerifyOnline looks every token up like an API key
+	VerifyOnline = "online"
+	// VerifyOffline checks the signature and expiry of signed tokens instead
+	VerifyOffline = "offline"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid service token")
+	ErrTokenExpired = errors.New("service token expired")
+	ErrVerifyMode   = errors.New("unknown token verification mode")
+)
+
+// ParseVerifyMode parses api.auth.tokens.verify, "" is VerifyOnline.
+func ParseVerifyMode(s string) (string, error) {
+	switch s {
+	case VerifyOnline, "":
+		return VerifyOnline, nil
+	case VerifyOffline:
+		return VerifyOffline, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s or %s", ErrVerifyMode, s, VerifyOnline, VerifyOffline)
+	}
+}
+
+// Claims are what a signed service token carries, enough to authenticate it
+// without the database.
+type Claims struct {
+	// ID is the id of the token in api_keys
+	ID           int32    `json:"id"`
+	UserID       int32    `json:"uid"`
+	Username     string   `json:"usr"`
+	Scopes       []string `json:"scp"`
+	AllowedCIDRs []string `json:"cidrs,omitempty"`
+	IssuedAt     int64    `json:"iat"`
+	ExpiresAt    int64    `json:"exp"`
+	// Nonce keeps two tokens with the same claims apart
+	Nonce string `json:"nonce"`
+}
+
+// Principal is the caller c authenticates. signed tokens don't carry the
+// subaccounts of their user.
+func (c Claims) Principal() (*Principal, error) {
+	cidrs, err := ParseCIDRs(c.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		KeyID:        c.ID,
+		UserID:       c.UserID,
+		Username:     c.Username,
+		Scopes:       c.Scopes,
+		AllowedCIDRs: cidrs,
+	}, nil
+}
+
+// Signer signs service tokens with Ed25519 keys, by id. it signs with the current
+// key and verifies with any, so keys can be rotated while the tokens they signed
+// are still valid.
+type Signer struct {
+	current string
+	keys    map[string]ed25519.PrivateKey
+}
+
+// NewSigner signs with the key current of seeds, 32 bytes each.
+func NewSigner(current string, seeds map[string][]byte) (*Signer, error) {
+	if _, ok := seeds[current]; !ok {
+		return nil, fmt.Errorf("no token signing key %q", current)
+	}
+	s := &Signer{current: current, keys: make(map[string]ed25519.PrivateKey, len(seeds))}
+	for id, seed := range seeds {
+		if len(seed) != ed25519.SeedSize || id == "" || strings.ContainsAny(id, "._") {
+			return nil, fmt.Errorf("token signing key %q must be 32 bytes with an id without '.' or '_'", id)
+		}
+		s.keys[id] = ed25519.NewKeyFromSeed(seed)
+	}
+	return s, nil
+}
+
+// SignerFromViper reads api.auth.tokens.signing: keys, by id the base64 of the
+// seed of every key or a reference to it, and current, the id of the one signing
+// new tokens. it is nil when no key is configured, tokens are random then.
+func SignerFromViper(ctx context.Context) (*Signer, error) {
+	ids := viper.GetStringMap("api.auth.tokens.signing.keys")
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	seeds := make(map[string][]byte, len(ids))
+	for id := range ids {
+		value, err := secrets.Get(ctx, "api.auth.tokens.signing.keys."+id)
+		if err != nil {
+			return nil, err
+		}
+		seeds[id], err = base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("token signing key %q is not base64: %w", id, err)
+		}
+	}
+	return NewSigner(viper.GetString("api.auth.tokens.signing.current"), seeds)
+}
+
+// Sign returns the token of c: TokenPrefix, the key id, the claims and their
+// signature, separated by dots.
+func (s *Signer) Sign(c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := TokenPrefix + s.current + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(s.keys[s.current], []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Signed reports whether token looks like a signed token, without verifying it.
+func Signed(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix) && strings.Count(token, ".") == 2
+}
+
+// Verify checks the signature of token and that it didn't expire before now, and
+// returns its claims.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	if !Signed(token) {
+		return Claims{}, ErrInvalidToken
+	}
+	i := strings.LastIndexByte(token, '.')
+	signed, encodedSig := token[:i], token[i+1:]
+	id, payload, _ := strings.Cut(strings.TrimPrefix(signed, TokenPrefix), ".")
+	key, ok := s.keys[id]
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte(signed), sig) {
+		return Claims{}, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return c, nil
+}
+
+// GenerateToken returns a new service token for c together with its display hint
+// and the hash to store. it is signed by s, or random when s is nil.
+func GenerateToken(s *Signer, c Claims) (token, hint, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", "", err
+	}
+	if s == nil {
+		token = TokenPrefix + hex.EncodeToString(b)
+	} else {
+		c.Nonce = hex.EncodeToString(b[:16])
+		token, err = s.Sign(c)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+	return token, TokenHint(token), HashKey(token), nil
+}
+
+// TokenHint is what identifies a token in listings: signed tokens start alike, so
+// it is their last characters.
+func TokenHint(token string) string {
+	return TokenPrefix + "..." + token[len(token)-6:]
+}
+
+// TokenExpiry returns when a token issued at now with the given ttl expires,
+// capped at maxTTL when positive.
+func TokenExpiry(now time.Time, ttl, maxTTL time.Duration) time.Time {
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return now.Add(ttl).Truncate(time.Second)
+}
@@ -0,0 +1,102 @@
+package auth_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/auth"
+)
+
+var _ = Describe("Tokens", func() {
+	var signer *Signer
+	now := time.Unix(1_700_000_000, 0)
+	claims := Claims{
+		ID:           7,
+		UserID:       3,
+		Username:     "terraform",
+		Scopes:       []string{ScopeUserAdmin},
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+		IssuedAt:     now.Unix(),
+		ExpiresAt:    now.Add(time.Hour).Unix(),
+	}
+
+	BeforeEach(func() {
+		var err error
+		signer, err = NewSigner("k2", map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should verify the tokens it signed until they expire", func() {
+		token, hint, hash, err := GenerateToken(signer, claims)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(HavePrefix(TokenPrefix + "k2."))
+		Expect(Signed(token)).To(BeTrue())
+		Expect(hint).To(Equal(TokenPrefix + "..." + token[len(token)-6:]))
+		Expect(hash).To(Equal(HashKey(token)))
+
+		got, err := signer.Verify(token, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.ID).To(Equal(int32(7)))
+		Expect(got.Nonce).NotTo(BeEmpty())
+		p, err := got.Principal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.KeyID).To(Equal(int32(7)))
+		Expect(p.Username).To(Equal("terraform"))
+		Expect(p.AllowedCIDRs).To(HaveLen(1))
+
+		_, err = signer.Verify(token, now.Add(time.Hour))
+		Expect(err).To(MatchError(ErrTokenExpired))
+	})
+
+	It("should reject tampered tokens and unknown keys", func() {
+		token, err := signer.Sign(claims)
+		Expect(err).NotTo(HaveOccurred())
+		parts := strings.Split(token, ".")
+		forged := claims
+		forged.Scopes = []string{ScopeUserAdmin, ScopeSmsSend}
+		other, err := signer.Sign(forged)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = signer.Verify(parts[0]+"."+strings.Split(other, ".")[1]+"."+parts[2], now)
+		Expect(err).To(MatchError(ErrInvalidToken))
+
+		rotated, err := NewSigner("k3", map[string][]byte{"k3": bytes.Repeat([]byte{3}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = rotated.Verify(token, now)
+		Expect(err).To(MatchError(ErrInvalidToken))
+	})
+
+	It("should verify with keys that no longer sign", func() {
+		old, err := NewSigner("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		token, err := old.Sign(claims)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = signer.Verify(token, now)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should generate random tokens without a signer", func() {
+		token, _, _, err := GenerateToken(nil, claims)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(HavePrefix(TokenPrefix))
+		Expect(Signed(token)).To(BeFalse())
+	})
+
+	It("should cap the lifetime of tokens", func() {
+		Expect(TokenExpiry(now, 48*time.Hour, 24*time.Hour)).To(Equal(now.Add(24 * time.Hour)))
+		Expect(TokenExpiry(now, time.Hour, 0)).To(Equal(now.Add(time.Hour)))
+	})
+
+	It("should parse the verification mode", func() {
+		Expect(ParseVerifyMode("")).To(Equal(VerifyOnline))
+		Expect(ParseVerifyMode(VerifyOffline)).To(Equal(VerifyOffline))
+		_, err := ParseVerifyMode("cached")
+		Expect(err).To(MatchError(ErrVerifyMode))
+	})
+})
@@ -0,0 +1,79 @@
+package carrier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownDriver = errors.New("unknown driver")
+
+// accountConfig is the configuration of a driver, as the credentials of a carrier
+// account are stored: the JSON of the driver's config struct.
+type accountConfig interface {
+	validate() error
+	// redact masks the secrets of the config
+	redact()
+	sender(ctx context.Context) (Sender, error)
+}
+
+// accountDrivers are the drivers carrier accounts can be registered for.
+var accountDrivers = map[string]func() accountConfig{
+	"vonage":    func() accountConfig { return &VonageConfig{} },
+	"kavenegar": func() accountConfig { return &KavenegarConfig{} },
+	"ucp":       func() accountConfig { return &UCPConfig{} },
+}
+
+func parseAccount(driver string, credentials []byte) (accountConfig, error) {
+	newConfig, ok := accountDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownDriver, driver)
+	}
+	cfg := newConfig()
+	dec := json.NewDecoder(bytes.NewReader(credentials))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s credentials: %w", driver, err)
+	}
+	return cfg, nil
+}
+
+// ValidateAccount checks the credentials of an account of driver, the JSON of its
+// config, e.g. {"api_key": "...", "api_secret": "..."} for vonage.
+func ValidateAccount(driver string, credentials []byte) error {
+	cfg, err := parseAccount(driver, credentials)
+	if err != nil {
+		return err
+	}
+	return cfg.validate()
+}
+
+// RedactAccount is credentials with the secrets masked, for display.
+func RedactAccount(driver string, credentials []byte) (json.RawMessage, error) {
+	cfg, err := parseAccount(driver, credentials)
+	if err != nil {
+		return nil, err
+	}
+	cfg.redact()
+	return json.Marshal(cfg)
+}
+
+// NewAccount builds the driver of an account from its credentials.
+func NewAccount(ctx context.Context, driver string, credentials []byte) (Sender, error) {
+	cfg, err := parseAccount(driver, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.sender(ctx)
+}
+
+// redact keeps the last 4 characters of long secrets.
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
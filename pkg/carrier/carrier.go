@@ -0,0 +1,236 @@
+package carrier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Message is an sms submitted to a carrier.
+type Message struct {
+	// ID is the id of the sms
+	ID int32
+	// MessageID is the public id of the sms, passed to the carrier as the client
+	// reference so its delivery reports can be matched without storing the
+	// carrier's id. empty for sms accepted before they had one
+	MessageID string
+	From      string
+	To        string
+	Text      string
+	// OTP is the one-time code in Text, if the sms carries one. drivers with a
+	// template for codes may send only the code through it.
+	OTP string
+}
+
+// Ref is the client reference of m: its public id, or its id when it has none.
+func (m Message) Ref() string {
+	if m.MessageID != "" {
+		return m.MessageID
+	}
+	return strconv.Itoa(int(m.ID))
+}
+
+// Sender submits sms to a carrier.
+type Sender interface {
+	// Send submits m and returns the carrier's id of the message. the outcome is
+	// reported later, by a delivery report. errors are *Error when the carrier
+	// refused the message, anything else is a transport error worth retrying.
+	Send(ctx context.Context, m Message) (string, error)
+}
+
+// Report is a delivery report a driver received itself.
+type Report struct {
+	// Ref is the carrier's id of the message, as returned by Send
+	Ref    string
+	Status status.Status
+	Reason string
+	// FailureReason is the code of the report in the normalized reasons of status,
+	// when the driver catalogs it
+	FailureReason string
+}
+
+// Reporter is a Sender receiving the delivery reports over its own connection to
+// the carrier, instead of the carrier posting them to the callbacks.
+type Reporter interface {
+	Sender
+	// OnReport sets the handler of the reports. reports it fails are refused, for
+	// the carrier to send them again.
+	OnReport(handler func(ctx context.Context, r Report) error)
+}
+
+// Checker is a Sender that can test its credentials with the carrier without
+// sending anything, see the doctor command.
+type Checker interface {
+	Sender
+	Check(ctx context.Context) error
+}
+
+// Error is a submission the carrier refused.
+type Error struct {
+	// Code is the carrier's error code
+	Code string
+	// Reason describes Code
+	Reason string
+	// Retry tells whether the same message may be accepted later, e.g. when the
+	// carrier throttled it
+	Retry bool
+	// FailureReason is Code in the normalized reasons of status, when the driver
+	// catalogs it
+	FailureReason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Reason, e.Code)
+}
+
+// Retryable reports whether submitting the message of err again may succeed.
+// everything but a permanent *Error is, timeouts and connection errors included.
+func Retryable(err error) bool {
+	var cErr *Error
+	if errors.As(err, &cErr) {
+		return cErr.Retry
+	}
+	return err != nil
+}
+
+// FailureReasonOf is the normalized reason of the submission err, "" unless the
+// carrier refused it with a cataloged code.
+func FailureReasonOf(err error) string {
+	var cErr *Error
+	if errors.As(err, &cErr) {
+		return cErr.FailureReason
+	}
+	return ""
+}
+
+// FromViper builds the Router of sms.provider: sms go to the driver of the
+// longest of sms.provider.routes matching their destination, or to
+// sms.provider.driver. sms.provider.sticky keeps recipients on one route.
+// it returns nil when no driver is set, sms are then handed to carriers outside
+// the gateway, which report back through the delivery report callbacks.
+func FromViper(ctx context.Context) (*Router, error) {
+	routes, fallback, err := routesFromViper()
+	if err != nil {
+		return nil, err
+	}
+	drivers := make(map[string]Sender)
+	for _, name := range append([]string{fallback}, routeDrivers(routes)...) {
+		if _, ok := drivers[name]; ok || name == "" {
+			continue
+		}
+		drivers[name], err = newDriver(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(drivers) == 0 {
+		return nil, nil
+	}
+	router, err := NewRouter(routes, drivers, fallback)
+	if err != nil {
+		return nil, err
+	}
+	router.Sticky = viper.GetBool("sms.provider.sticky")
+	return router, nil
+}
+
+// routesFromViper reads sms.provider.routes and the fallback driver of
+// sms.provider.driver, "" when it is none.
+func routesFromViper() ([]Route, string, error) {
+	var routes []Route
+	err := viper.UnmarshalKey("sms.provider.routes", &routes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read sms.provider.routes: %w", err)
+	}
+	fallback := viper.GetString("sms.provider.driver")
+	if fallback == "none" {
+		fallback = ""
+	}
+	return routes, fallback, nil
+}
+
+func routeDrivers(routes []Route) []string {
+	names := make([]string, len(routes))
+	for i, r := range routes {
+		names[i] = r.Driver
+	}
+	return names
+}
+
+// Drivers are the names of the drivers sms.provider can route to.
+var Drivers = []string{"log", "vonage", "kavenegar", "ucp"}
+
+// newDriver builds the driver name, "log", "vonage", "kavenegar" or "ucp", from
+// sms.provider.<name>.
+func newDriver(ctx context.Context, name string) (Sender, error) {
+	switch name {
+	case "log":
+		return Log{}, nil
+	case "vonage":
+		secret, err := secrets.Get(ctx, "sms.provider.vonage.apisecret")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sms.provider.vonage.apisecret: %w", err)
+		}
+		return NewVonage(VonageConfig{
+			Endpoint:  viper.GetString("sms.provider.vonage.endpoint"),
+			APIKey:    viper.GetString("sms.provider.vonage.apikey"),
+			APISecret: secret,
+			Callback:  viper.GetString("sms.provider.vonage.callback"),
+			Senders:   viper.GetStringMapString("sms.provider.vonage.senders"),
+		})
+	case "kavenegar":
+		key, err := secrets.Get(ctx, "sms.provider.kavenegar.apikey")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sms.provider.kavenegar.apikey: %w", err)
+		}
+		return NewKavenegar(KavenegarConfig{
+			Endpoint:       viper.GetString("sms.provider.kavenegar.endpoint"),
+			APIKey:         key,
+			Sender:         viper.GetString("sms.provider.kavenegar.sender"),
+			VerifyTemplate: viper.GetString("sms.provider.kavenegar.verify.template"),
+		})
+	case "ucp":
+		password, err := secrets.Get(ctx, "sms.provider.ucp.password")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sms.provider.ucp.password: %w", err)
+		}
+		return NewUCP(ctx, UCPConfig{
+			Address:   viper.GetString("sms.provider.ucp.address"),
+			Login:     viper.GetString("sms.provider.ucp.login"),
+			Password:  password,
+			TLS:       viper.GetBool("sms.provider.ucp.tls"),
+			Senders:   viper.GetStringMapString("sms.provider.ucp.senders"),
+			Keepalive: viper.GetDuration("sms.provider.ucp.keepalive"),
+			Timeout:   viper.GetDuration("sms.provider.ucp.timeout"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown sms provider driver %q", name)
+	}
+}
+
+// Log only logs the destination of every sms. it is meant for development and
+// never fails, the messages stay submitted.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, m Message) (string, error) {
+	logrus.WithField("to", mask.Phone(m.To)).Info("sms not sent, sms.provider.driver is log")
+	return "log-" + m.Ref(), nil
+}
+
+// SenderFor picks the sender of an sms to to: the one registered for the country of
+// to in senders, keyed by calling code, or from. some countries only pass sms
+// from senders registered with their carriers.
+func SenderFor(senders map[string]string, from, to string) string {
+	if s, ok := senders[phone.CountryCode(to)]; ok && s != "" {
+		return s
+	}
+	return from
+}
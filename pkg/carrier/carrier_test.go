@@ -0,0 +1,476 @@
+package carrier_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/status"
+)
+
+var _ = Describe("Carrier", func() {
+	It("rewrites the sender of countries with registered senders", func() {
+		senders := map[string]string{"1": "+15550199", "91": "ACME"}
+		Expect(carrier.SenderFor(senders, "+4915550100", "+1 555 0101")).To(Equal("+15550199"))
+		Expect(carrier.SenderFor(senders, "+4915550100", "+919876543210")).To(Equal("ACME"))
+		Expect(carrier.SenderFor(senders, "+4915550100", "+4915550101")).To(Equal("+4915550100"))
+		Expect(carrier.SenderFor(nil, "+4915550100", "+15550101")).To(Equal("+4915550100"))
+	})
+
+	It("references sms by their message id, or their id without one", func() {
+		m := carrier.Message{ID: 42, MessageID: "0199f0c2-6b7e-7a3c-9d1e-2f4a5b6c7d8e"}
+		Expect(m.Ref()).To(Equal("0199f0c2-6b7e-7a3c-9d1e-2f4a5b6c7d8e"))
+		m.MessageID = ""
+		Expect(m.Ref()).To(Equal("42"))
+	})
+
+	It("retries everything but permanent refusals", func() {
+		Expect(carrier.Retryable(errors.New("connection reset"))).To(BeTrue())
+		Expect(carrier.Retryable(carrier.VonageError("1", ""))).To(BeTrue())
+		Expect(carrier.Retryable(carrier.VonageError("3", ""))).To(BeFalse())
+		Expect(carrier.Retryable(carrier.VonageError("42", ""))).To(BeFalse())
+		Expect(carrier.Retryable(nil)).To(BeFalse())
+	})
+
+	It("catalogs the codes of the refusals and reports in the normalized reasons", func() {
+		Expect(carrier.FailureReasonOf(carrier.VonageError("33", ""))).To(Equal(status.ReasonInvalidNumber))
+		Expect(carrier.FailureReasonOf(carrier.VonageError("1", ""))).To(BeEmpty())
+		Expect(carrier.FailureReasonOf(errors.New("connection reset"))).To(BeEmpty())
+
+		Expect(carrier.VonageFailureReason("6")).To(Equal(status.ReasonSpamFiltered))
+		Expect(carrier.VonageFailureReason("3")).To(Equal(status.ReasonAbsentSubscriber))
+		Expect(carrier.VonageFailureReason("99")).To(BeEmpty())
+	})
+
+	Context("Vonage", func() {
+		m := carrier.Message{ID: 42, From: "+15550100", To: "+44 7700 900123", Text: "code 12"}
+
+		It("submits the message and returns its id", func() {
+			var form url.Values
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.URL.Path).To(Equal("/sms/json"))
+				Expect(r.ParseForm()).To(Succeed())
+				form = r.PostForm
+				w.Write([]byte(`{"message-count":"1","messages":[{"to":"447700900123","message-id":"0A00000001","status":"0"}]}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			v, err := carrier.NewVonage(carrier.VonageConfig{
+				Endpoint:  srv.URL,
+				APIKey:    "key",
+				APISecret: "secret",
+				Callback:  "https://example.com/callbacks/vonage/dlr",
+				Senders:   map[string]string{"44": "ACME"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			id, err := v.Send(context.Background(), m)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("0A00000001"))
+			Expect(form.Get("api_key")).To(Equal("key"))
+			Expect(form.Get("api_secret")).To(Equal("secret"))
+			Expect(form.Get("from")).To(Equal("ACME"))
+			Expect(form.Get("to")).To(Equal("447700900123"))
+			Expect(form.Get("client-ref")).To(Equal("42"))
+			Expect(form.Get("callback")).To(Equal("https://example.com/callbacks/vonage/dlr"))
+			Expect(form.Has("type")).To(BeFalse())
+		})
+
+		It("sends numbers as digits and non GSM text as unicode", func() {
+			var form url.Values
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.ParseForm()).To(Succeed())
+				form = r.PostForm
+				w.Write([]byte(`{"messages":[{"message-id":"1","status":"0"}]}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			v, err := carrier.NewVonage(carrier.VonageConfig{Endpoint: srv.URL, APIKey: "key", APISecret: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = v.Send(context.Background(), carrier.Message{ID: 1, From: "+15550100", To: "+15550101", Text: "سلام"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(form.Get("from")).To(Equal("15550100"))
+			Expect(form.Get("type")).To(Equal("unicode"))
+		})
+
+		It("maps refusals to retry semantics", func() {
+			code := "1"
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"messages":[{"status":"` + code + `","error-text":"refused"}]}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			v, err := carrier.NewVonage(carrier.VonageConfig{Endpoint: srv.URL, APIKey: "key", APISecret: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = v.Send(context.Background(), m)
+			var cErr *carrier.Error
+			Expect(errors.As(err, &cErr)).To(BeTrue())
+			Expect(cErr.Code).To(Equal("1"))
+			Expect(cErr.Reason).To(Equal("refused"))
+			Expect(carrier.Retryable(err)).To(BeTrue())
+
+			code = "15"
+			_, err = v.Send(context.Background(), m)
+			Expect(err).To(HaveOccurred())
+			Expect(carrier.Retryable(err)).To(BeFalse())
+		})
+
+		It("retries server errors", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			DeferCleanup(srv.Close)
+
+			v, err := carrier.NewVonage(carrier.VonageConfig{Endpoint: srv.URL, APIKey: "key", APISecret: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = v.Send(context.Background(), m)
+			Expect(err).To(MatchError(ContainSubstring("503")))
+			Expect(carrier.Retryable(err)).To(BeTrue())
+		})
+
+		It("checks the credentials against the balance of the account", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.URL.Path).To(Equal("/account/get-balance"))
+				if r.URL.Query().Get("api_secret") != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"value":10.28,"autoReload":false}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			v, err := carrier.NewVonage(carrier.VonageConfig{Endpoint: srv.URL, APIKey: "key", APISecret: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Check(context.Background())).To(Succeed())
+
+			v, err = carrier.NewVonage(carrier.VonageConfig{Endpoint: srv.URL, APIKey: "key", APISecret: "wrong"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Check(context.Background())).To(MatchError(ContainSubstring("401")))
+		})
+
+		It("needs credentials", func() {
+			_, err := carrier.NewVonage(carrier.VonageConfig{APIKey: "key"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("translates delivery reports", func() {
+			st, reason, ok := carrier.VonageDlr("delivered", "0")
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal(status.Delivered))
+			Expect(reason).To(BeEmpty())
+
+			st, reason, ok = carrier.VonageDlr("failed", "6")
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal(status.Failed))
+			Expect(reason).To(Equal("anti-spam rejection"))
+
+			st, _, ok = carrier.VonageDlr("rejected", "77")
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal(status.Failed))
+
+			_, _, ok = carrier.VonageDlr("buffered", "0")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("verifies signed requests", func() {
+			now := time.Unix(1760000000, 0)
+			params := url.Values{
+				"messageId":  {"0A00000001"},
+				"status":     {"delivered"},
+				"client-ref": {"42"},
+				"timestamp":  {strconv.FormatInt(now.Unix(), 10)},
+			}
+			params.Set("sig", carrier.SignVonage("secret", params))
+			Expect(carrier.VerifyVonage("secret", params, time.Minute, now)).To(Succeed())
+			Expect(carrier.VerifyVonage("other", params, time.Minute, now)).To(MatchError(carrier.ErrVonageSignature))
+			Expect(carrier.VerifyVonage("secret", params, time.Minute, now.Add(time.Hour))).To(MatchError(carrier.ErrVonageTimestamp))
+
+			params.Set("status", "failed")
+			Expect(carrier.VerifyVonage("secret", params, time.Minute, now)).To(MatchError(carrier.ErrVonageSignature))
+		})
+	})
+
+	Context("Router", func() {
+		drivers := map[string]carrier.Sender{
+			"vonage":    carrier.Log{},
+			"kavenegar": carrier.Log{},
+			"local":     carrier.Log{},
+		}
+
+		It("routes by the longest matching prefix", func() {
+			r, err := carrier.NewRouter([]carrier.Route{
+				{Prefix: "98", Driver: "kavenegar"},
+				{Prefix: "+98 912", Driver: "local"},
+			}, drivers, "vonage")
+			Expect(err).NotTo(HaveOccurred())
+			name, sender := r.Route("+989121234567")
+			Expect(name).To(Equal("local"))
+			Expect(sender).NotTo(BeNil())
+			name, _ = r.Route("00989351234567")
+			Expect(name).To(Equal("kavenegar"))
+			name, _ = r.Route("+4915112345678")
+			Expect(name).To(Equal("vonage"))
+		})
+
+		It("leaves unrouted destinations to outside carriers without a fallback", func() {
+			r, err := carrier.NewRouter([]carrier.Route{{Prefix: "98", Driver: "kavenegar"}}, drivers, "")
+			Expect(err).NotTo(HaveOccurred())
+			name, sender := r.Route("+4915112345678")
+			Expect(name).To(BeEmpty())
+			Expect(sender).To(BeNil())
+		})
+
+		It("refuses routes to unknown drivers", func() {
+			_, err := carrier.NewRouter([]carrier.Route{{Prefix: "98", Driver: "smpp"}}, drivers, "")
+			Expect(err).To(HaveOccurred())
+			_, err = carrier.NewRouter([]carrier.Route{{Prefix: "", Driver: "local"}}, drivers, "")
+			Expect(err).To(HaveOccurred())
+			_, err = carrier.NewRouter(nil, drivers, "smpp")
+			Expect(err).To(HaveOccurred())
+			_, err = carrier.NewRouter([]carrier.Route{{Prefix: "98", Driver: "local", Weight: -1}}, drivers, "")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("shares a prefix between its routes by weight", func() {
+			r, err := carrier.NewRouter([]carrier.Route{
+				{Prefix: "98", Driver: "kavenegar", Weight: 3},
+				{Prefix: "98", Driver: "local"},
+			}, drivers, "vonage")
+			Expect(err).NotTo(HaveOccurred())
+			picks := map[string]int{}
+			for range 4000 {
+				name, _ := r.Route("+989121234567")
+				picks[name]++
+			}
+			Expect(picks).To(HaveLen(2))
+			Expect(picks["kavenegar"]).To(BeNumerically("~", 3000, 200))
+			Expect(picks["local"]).To(BeNumerically("~", 1000, 200))
+		})
+
+		It("keeps every recipient on one route when sticky", func() {
+			r, err := carrier.NewRouter([]carrier.Route{
+				{Prefix: "98", Driver: "kavenegar"},
+				{Prefix: "98", Driver: "local"},
+			}, drivers, "vonage")
+			Expect(err).NotTo(HaveOccurred())
+			r.Sticky = true
+			picks := map[string]int{}
+			for i := range 1000 {
+				to := fmt.Sprintf("+98912%07d", i)
+				name, _ := r.Route(to)
+				for range 5 {
+					again, _ := r.Route(to)
+					Expect(again).To(Equal(name))
+				}
+				picks[name]++
+			}
+			Expect(picks["kavenegar"]).To(BeNumerically("~", 500, 100))
+			Expect(picks["local"]).To(BeNumerically("~", 500, 100))
+		})
+
+		It("lists the drivers receiving their own reports", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			u, err := carrier.NewUCP(ctx, carrier.UCPConfig{Address: "127.0.0.1:1", Login: "gateway"})
+			Expect(err).NotTo(HaveOccurred())
+			r, err := carrier.NewRouter([]carrier.Route{{Prefix: "31", Driver: "ucp"}}, map[string]carrier.Sender{
+				"ucp":   u,
+				"local": carrier.Log{},
+			}, "local")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Reporters()).To(HaveLen(1))
+			Expect(r.Reporters()).To(HaveKey("ucp"))
+			Expect(r.Checkers()).To(HaveLen(1))
+			Expect(r.Checkers()).To(HaveKey("ucp"))
+		})
+	})
+
+	Context("Kavenegar", func() {
+		var path string
+		var form url.Values
+		reply := `{"return":{"status":200,"message":"تایید شد"},"entries":[{"messageid":8792343,"status":1}]}`
+		newKavenegar := func(template string) *carrier.Kavenegar {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				path = r.URL.Path
+				Expect(r.ParseForm()).To(Succeed())
+				form = r.PostForm
+				w.Write([]byte(reply))
+			}))
+			DeferCleanup(srv.Close)
+			k, err := carrier.NewKavenegar(carrier.KavenegarConfig{
+				Endpoint:       srv.URL,
+				APIKey:         "key",
+				Sender:         "10004346",
+				VerifyTemplate: template,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return k
+		}
+
+		It("sends the message from the configured line", func() {
+			k := newKavenegar("")
+			id, err := k.Send(context.Background(), carrier.Message{ID: 7, MessageID: "0199f0c2-6b7e-7a3c-9d1e-2f4a5b6c7d8e", From: "+15550100", To: "+989121234567", Text: "code 1234", OTP: "1234"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("8792343"))
+			Expect(path).To(Equal("/v1/key/sms/send.json"))
+			Expect(form.Get("receptor")).To(Equal("09121234567"))
+			Expect(form.Get("sender")).To(Equal("10004346"))
+			Expect(form.Get("message")).To(Equal("code 1234"))
+			Expect(form.Get("localid")).To(Equal("7"))
+		})
+
+		It("sends codes through the verify template", func() {
+			k := newKavenegar("login")
+			_, err := k.Send(context.Background(), carrier.Message{ID: 7, To: "+4915112345678", Text: "code 1234", OTP: "1234"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/v1/key/verify/lookup.json"))
+			Expect(form.Get("receptor")).To(Equal("004915112345678"))
+			Expect(form.Get("token")).To(Equal("1234"))
+			Expect(form.Get("template")).To(Equal("login"))
+			Expect(form.Has("message")).To(BeFalse())
+
+			_, err = k.Send(context.Background(), carrier.Message{ID: 8, To: "+989121234567", Text: "hello"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/v1/key/sms/send.json"))
+		})
+
+		It("maps refusals to retry semantics", func() {
+			k := newKavenegar("")
+			reply = `{"return":{"status":418,"message":"اعتبار حساب شما کافی نیست"},"entries":null}`
+			DeferCleanup(func() { reply = `{"return":{"status":200},"entries":[{"messageid":8792343}]}` })
+			_, err := k.Send(context.Background(), carrier.Message{ID: 7, To: "+989121234567", Text: "hi"})
+			Expect(err).To(MatchError("insufficient credit (418)"))
+			Expect(carrier.Retryable(err)).To(BeTrue())
+
+			reply = `{"return":{"status":411,"message":"گیرنده نامعتبر است"},"entries":null}`
+			_, err = k.Send(context.Background(), carrier.Message{ID: 7, To: "+989121234567", Text: "hi"})
+			Expect(err).To(MatchError("invalid receptor (411)"))
+			Expect(carrier.Retryable(err)).To(BeFalse())
+		})
+
+		It("checks the api key against the info of the account", func() {
+			k := newKavenegar("")
+			reply = `{"return":{"status":200,"message":"تایید شد"},"entries":{"remaincredit":120000,"type":"master"}}`
+			DeferCleanup(func() { reply = `{"return":{"status":200},"entries":[{"messageid":8792343}]}` })
+			Expect(k.Check(context.Background())).To(Succeed())
+			Expect(path).To(Equal("/v1/key/account/info.json"))
+
+			reply = `{"return":{"status":403,"message":"کد شناسائی API-Key معتبر نمی‌باشد"},"entries":null}`
+			Expect(k.Check(context.Background())).To(MatchError("invalid api key (403)"))
+		})
+
+		It("needs an api key", func() {
+			_, err := carrier.NewKavenegar(carrier.KavenegarConfig{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("UCP", func() {
+		It("translates delivery notifications", func() {
+			st, reason, ok := carrier.UCPDlr("0", "000")
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal(status.Delivered))
+			Expect(reason).To(BeEmpty())
+
+			st, reason, ok = carrier.UCPDlr("2", "107")
+			Expect(ok).To(BeTrue())
+			Expect(st).To(Equal(status.Failed))
+			Expect(reason).To(Equal("absent subscriber"))
+
+			_, reason, _ = carrier.UCPDlr("2", "999")
+			Expect(reason).To(Equal("error 999"))
+
+			_, _, ok = carrier.UCPDlr("1", "")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("retries refusals of the session but not of the message", func() {
+			Expect(carrier.Retryable(carrier.UCPError("07"))).To(BeTrue())
+			Expect(carrier.Retryable(carrier.UCPError("24"))).To(BeFalse())
+			Expect(carrier.UCPError("24").Error()).To(Equal("message too long (24)"))
+		})
+
+		It("fails without a session, for the submission to be retried", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			u, err := carrier.NewUCP(ctx, carrier.UCPConfig{Address: "127.0.0.1:1", Login: "gateway"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = u.Send(ctx, carrier.Message{ID: 1, From: "15550100", To: "+31612345678", Text: "hi"})
+			Expect(err).To(HaveOccurred())
+			Expect(carrier.Retryable(err)).To(BeTrue())
+		})
+
+		It("needs an address and a login", func() {
+			_, err := carrier.NewUCP(context.Background(), carrier.UCPConfig{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Accounts", func() {
+		It("validates the credentials by the config of the driver", func() {
+			Expect(carrier.ValidateAccount("vonage", []byte(`{"api_key":"abcd","api_secret":"0123456789"}`))).To(Succeed())
+			Expect(carrier.ValidateAccount("vonage", []byte(`{"api_key":"abcd"}`))).NotTo(Succeed())
+			Expect(carrier.ValidateAccount("vonage", []byte(`{"api_key":"abcd","api_secret":"x","apisecret":"x"}`))).NotTo(Succeed())
+			Expect(carrier.ValidateAccount("smpp", []byte(`{}`))).To(MatchError(carrier.ErrUnknownDriver))
+		})
+
+		It("masks the secrets", func() {
+			redacted, err := carrier.RedactAccount("kavenegar", []byte(`{"api_key":"0123456789abcdef","sender":"10004346"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(redacted).To(MatchJSON(`{"api_key":"****cdef","sender":"10004346"}`))
+
+			redacted, err = carrier.RedactAccount("ucp", []byte(`{"address":"smsc:3000","login":"12345","password":"short"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(redacted).To(MatchJSON(`{"address":"smsc:3000","login":"12345","password":"****"}`))
+		})
+
+		It("builds the driver of an account", func() {
+			s, err := carrier.NewAccount(context.Background(), "kavenegar", []byte(`{"api_key":"0123456789abcdef"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(BeAssignableToTypeOf(&carrier.Kavenegar{}))
+		})
+	})
+
+	Context("Coverage", func() {
+		It("lists what every prefix and the fallback offer", func() {
+			coverage, err := carrier.CoverageOf([]carrier.Route{
+				{Prefix: "+98", Driver: "kavenegar", Weight: 3},
+				{Prefix: "98", Driver: "vonage"},
+				{Prefix: "44", Driver: "ucp"},
+			}, "vonage")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(coverage).To(HaveLen(3))
+
+			Expect(coverage[0].Prefix).To(Equal("44"))
+			Expect(coverage[0].SenderTypes()).To(Equal([]string{carrier.SenderNumeric, carrier.SenderAlphanumeric}))
+
+			Expect(coverage[1].Prefix).To(Equal("98"))
+			Expect(coverage[1].CountryCode()).To(Equal("98"))
+			Expect(coverage[1].Drivers).To(Equal([]string{"kavenegar", "vonage"}))
+			Expect(coverage[1].SenderTypes()).To(Equal([]string{carrier.SenderNumeric}))
+			Expect(coverage[1].TwoWay).To(BeFalse())
+
+			Expect(coverage[2].Prefix).To(BeEmpty())
+			Expect(coverage[2].Drivers).To(Equal([]string{"vonage"}))
+		})
+
+		It("refuses unknown drivers", func() {
+			_, err := carrier.CoverageOf([]carrier.Route{{Prefix: "98", Driver: "smpp"}}, "")
+			Expect(err).To(HaveOccurred())
+			_, err = carrier.CoverageOf(nil, "smpp")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
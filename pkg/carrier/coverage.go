@@ -0,0 +1,113 @@
+package carrier
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+)
+
+// the kinds of senders an sms can be sent from
+const (
+	SenderNumeric      = "numeric"
+	SenderAlphanumeric = "alphanumeric"
+)
+
+// Capability is what a driver can do besides sending from numbers.
+type Capability struct {
+	// Alphanumeric tells whether it sends from sender ids like "ACME"
+	Alphanumeric bool
+	// TwoWay tells whether the replies of the recipients are received
+	TwoWay bool
+}
+
+// capabilities are the Capability of every driver. none receives replies yet:
+// the UCP session refuses mobile originated messages and the others have no
+// inbound callback. Kavenegar only sends from the line numbers of the account.
+var capabilities = map[string]Capability{
+	"log":       {Alphanumeric: true},
+	"vonage":    {Alphanumeric: true},
+	"kavenegar": {},
+	"ucp":       {Alphanumeric: true},
+}
+
+// SenderTypes lists the senders c can send from.
+func (c Capability) SenderTypes() []string {
+	types := []string{SenderNumeric}
+	if c.Alphanumeric {
+		types = append(types, SenderAlphanumeric)
+	}
+	return types
+}
+
+// Coverage is what sms.provider offers the destinations starting with Prefix.
+type Coverage struct {
+	// Prefix is "" for the destinations no route matches
+	Prefix string
+	// Drivers share the sms of Prefix, see Route.Weight
+	Drivers []string
+	// Capability is what all of Drivers can do, an sms can't pick the route it
+	// takes by what it needs
+	Capability
+}
+
+// CountryCode is the calling code of the country of c, "" when unknown.
+func (c Coverage) CountryCode() string {
+	return phone.CountryCode(c.Prefix)
+}
+
+// CoverageOf lists what routes offer, by prefix, and what fallback offers the
+// destinations no route matches, last, unless it is "".
+func CoverageOf(routes []Route, fallback string) ([]Coverage, error) {
+	var coverage []Coverage
+	add := func(prefix, driver string) error {
+		c, ok := capabilities[driver]
+		if !ok {
+			return fmt.Errorf("route %s: unknown driver %q", prefix, driver)
+		}
+		n := slices.IndexFunc(coverage, func(c Coverage) bool { return c.Prefix == prefix })
+		if n < 0 {
+			coverage = append(coverage, Coverage{Prefix: prefix, Capability: c})
+			n = len(coverage) - 1
+		}
+		cov := &coverage[n]
+		if !slices.Contains(cov.Drivers, driver) {
+			cov.Drivers = append(cov.Drivers, driver)
+		}
+		cov.Alphanumeric = cov.Alphanumeric && c.Alphanumeric
+		cov.TwoWay = cov.TwoWay && c.TwoWay
+		return nil
+	}
+	for i, r := range routes {
+		prefix := phone.Normalize(r.Prefix)
+		if prefix == "" {
+			return nil, fmt.Errorf("route %d has no prefix", i)
+		}
+		err := add(prefix, r.Driver)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(coverage, func(a, b Coverage) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+	if fallback != "" {
+		c, ok := capabilities[fallback]
+		if !ok {
+			return nil, fmt.Errorf("unknown driver %q", fallback)
+		}
+		coverage = append(coverage, Coverage{Drivers: []string{fallback}, Capability: c})
+	}
+	return coverage, nil
+}
+
+// CoverageFromViper is the coverage of sms.provider, see FromViper. it builds no
+// driver, so it reads no credentials.
+func CoverageFromViper() ([]Coverage, error) {
+	routes, fallback, err := routesFromViper()
+	if err != nil {
+		return nil, err
+	}
+	return CoverageOf(routes, fallback)
+}
@@ -0,0 +1,200 @@
+package carrier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/status"
+)
+
+const kavenegarEndpoint = "https://api.kavenegar.com"
+
+// KavenegarConfig configures the Kavenegar driver, for destinations in Iran.
+type KavenegarConfig struct {
+	// Endpoint defaults to https://api.kavenegar.com
+	Endpoint string `json:"endpoint,omitempty"`
+	APIKey   string `json:"api_key"`
+	// Sender is the line sms are sent from, the account's default line when empty.
+	// Iranian carriers only pass sms from lines registered with them.
+	Sender string `json:"sender,omitempty"`
+	// VerifyTemplate, when set, is the template of the account's verify service that
+	// sms with an OTP are sent through, with the code as its token. verify sms skip
+	// the queue of the sending lines and reach numbers that block advertising.
+	VerifyTemplate string `json:"verify_template,omitempty"`
+}
+
+// Kavenegar submits sms through the Kavenegar REST api.
+type Kavenegar struct {
+	cfg    KavenegarConfig
+	client *http.Client
+}
+
+func NewKavenegar(cfg KavenegarConfig) (*Kavenegar, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = kavenegarEndpoint
+	}
+	return &Kavenegar{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (cfg *KavenegarConfig) validate() error {
+	if cfg.APIKey == "" {
+		return fmt.Errorf("kavenegar needs an api key")
+	}
+	return nil
+}
+
+func (cfg *KavenegarConfig) redact() {
+	cfg.APIKey = redact(cfg.APIKey)
+}
+
+func (cfg *KavenegarConfig) sender(ctx context.Context) (Sender, error) {
+	return NewKavenegar(*cfg)
+}
+
+// kavenegarErrors maps the return statuses of Kavenegar to what they mean for the
+// message, see https://kavenegar.com/rest.html. like with vonageErrors, errors of
+// the account or its configuration are retried.
+var kavenegarErrors = map[int]Error{
+	400: {Reason: "incomplete parameters"},
+	401: {Reason: "account deactivated", Retry: true},
+	402: {Reason: "operation failed", Retry: true},
+	403: {Reason: "invalid api key", Retry: true},
+	406: {Reason: "missing required parameters"},
+	407: {Reason: "access denied", Retry: true},
+	409: {Reason: "server unable to respond", Retry: true},
+	411: {Reason: "invalid receptor", FailureReason: status.ReasonInvalidNumber},
+	412: {Reason: "invalid sender", Retry: true},
+	413: {Reason: "message empty or too long"},
+	416: {Reason: "ip not allowed", Retry: true},
+	418: {Reason: "insufficient credit", Retry: true},
+	422: {Reason: "invalid characters"},
+	424: {Reason: "verify template not found", Retry: true},
+	426: {Reason: "method needs an advanced plan", Retry: true},
+	427: {Reason: "only allowed on a dedicated line", Retry: true},
+	431: {Reason: "invalid code structure"},
+	432: {Reason: "code parameter not found in message"},
+	451: {Reason: "too many requests", Retry: true},
+	501: {Reason: "test accounts only send to their owner"},
+}
+
+// KavenegarError is the *Error of a submission Kavenegar refused with code. the
+// messages of the api are in Persian, the reason is always the English one.
+func KavenegarError(code int) *Error {
+	e, ok := kavenegarErrors[code]
+	if !ok {
+		// unknown 5xx are the server's fault
+		e = Error{Reason: "unknown error", Retry: code >= 500}
+	}
+	e.Code = strconv.Itoa(code)
+	return &e
+}
+
+// Send submits m, through the verify template when it carries an OTP and one is
+// configured. the local id makes Kavenegar drop a resubmission of the same sms,
+// it is the id of the sms since Kavenegar only takes numbers.
+func (k *Kavenegar) Send(ctx context.Context, m Message) (string, error) {
+	method := "sms/send.json"
+	form := url.Values{
+		"receptor": {kavenegarReceptor(m.To)},
+		"message":  {m.Text},
+		"localid":  {strconv.Itoa(int(m.ID))},
+	}
+	if k.cfg.Sender != "" {
+		form.Set("sender", k.cfg.Sender)
+	}
+	if m.OTP != "" && k.cfg.VerifyTemplate != "" {
+		method = "verify/lookup.json"
+		form = url.Values{
+			"receptor": {kavenegarReceptor(m.To)},
+			"token":    {m.OTP},
+			"template": {k.cfg.VerifyTemplate},
+		}
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/%s", strings.TrimSuffix(k.cfg.Endpoint, "/"), url.PathEscape(k.cfg.APIKey), method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := k.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var reply struct {
+		Return struct {
+			Status int `json:"status"`
+		} `json:"return"`
+		Entries []struct {
+			MessageID int64 `json:"messageid"`
+		} `json:"entries"`
+	}
+	err = json.Unmarshal(body, &reply)
+	if err != nil || reply.Return.Status == 0 {
+		// not an answer of the api, e.g. a proxy in front of it
+		return "", fmt.Errorf("kavenegar: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	if reply.Return.Status != http.StatusOK {
+		return "", KavenegarError(reply.Return.Status)
+	}
+	if len(reply.Entries) == 0 {
+		return "", fmt.Errorf("kavenegar: no entry in the reply")
+	}
+	return strconv.FormatInt(reply.Entries[0].MessageID, 10), nil
+}
+
+// Check reads the info of the account, which Kavenegar refuses for an invalid
+// api key, a deactivated account or a server outside the allowed ips.
+func (k *Kavenegar) Check(ctx context.Context) error {
+	endpoint := fmt.Sprintf("%s/v1/%s/account/info.json", strings.TrimSuffix(k.cfg.Endpoint, "/"), url.PathEscape(k.cfg.APIKey))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var reply struct {
+		Return struct {
+			Status int `json:"status"`
+		} `json:"return"`
+	}
+	err = json.Unmarshal(body, &reply)
+	if err != nil || reply.Return.Status == 0 {
+		return fmt.Errorf("kavenegar: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	if reply.Return.Status != http.StatusOK {
+		return KavenegarError(reply.Return.Status)
+	}
+	return nil
+}
+
+// kavenegarReceptor is to the way Kavenegar takes it: Iranian numbers in national
+// format, others with the international prefix 00.
+func kavenegarReceptor(to string) string {
+	digits := phone.Normalize(to)
+	if national, ok := strings.CutPrefix(digits, "98"); ok {
+		return "0" + national
+	}
+	return "00" + digits
+}
@@ -0,0 +1,176 @@
+package carrier
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+)
+
+// Route sends the sms to destinations starting with Prefix through Driver.
+type Route struct {
+	// Prefix is matched against the digits of the destination, e.g. "98" or "4477"
+	Prefix string `mapstructure:"prefix"`
+	Driver string `mapstructure:"driver"`
+	// Weight shares the sms of Prefix between the routes of the same prefix in
+	// proportion to their weights, 1 when unset
+	Weight int `mapstructure:"weight"`
+}
+
+// group is the routes of one prefix.
+type group struct {
+	prefix string
+	routes []Route
+	total  int
+}
+
+// Router picks the driver of every sms by its destination. the longest matching
+// prefix wins, destinations no route matches go to the fallback driver. the sms
+// of a prefix with several routes are shared between them by weight.
+type Router struct {
+	groups   []group
+	drivers  map[string]Sender
+	fallback string
+	// Sticky keeps every destination on the same route of its prefix, so a
+	// conversation keeps its provider and sender, instead of picking one at random
+	// for every sms. routes added to or removed from a prefix only move the
+	// destinations they take or leave.
+	Sticky bool
+}
+
+// NewRouter routes to drivers by name. fallback may be empty, sms no route
+// matches are then left to carriers outside the gateway.
+func NewRouter(routes []Route, drivers map[string]Sender, fallback string) (*Router, error) {
+	var groups []group
+	for i, r := range routes {
+		r.Prefix = phone.Normalize(r.Prefix)
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("route %d has no prefix", i)
+		}
+		if _, ok := drivers[r.Driver]; !ok {
+			return nil, fmt.Errorf("route %s: unknown driver %q", r.Prefix, r.Driver)
+		}
+		if r.Weight < 0 {
+			return nil, fmt.Errorf("route %s: negative weight %d", r.Prefix, r.Weight)
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		n := slices.IndexFunc(groups, func(g group) bool { return g.prefix == r.Prefix })
+		if n < 0 {
+			groups = append(groups, group{prefix: r.Prefix})
+			n = len(groups) - 1
+		}
+		groups[n].routes = append(groups[n].routes, r)
+		groups[n].total += r.Weight
+	}
+	if _, ok := drivers[fallback]; fallback != "" && !ok {
+		return nil, fmt.Errorf("unknown driver %q", fallback)
+	}
+	slices.SortStableFunc(groups, func(a, b group) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	return &Router{
+		groups:   groups,
+		drivers:  drivers,
+		fallback: fallback,
+	}, nil
+}
+
+// Route returns the name and driver of the sms to to, nil when it isn't submitted
+// by the gateway.
+func (r *Router) Route(to string) (string, Sender) {
+	digits := phone.Normalize(to)
+	name := r.fallback
+	for _, g := range r.groups {
+		if strings.HasPrefix(digits, g.prefix) {
+			name = r.pick(g, digits).Driver
+			break
+		}
+	}
+	if name == "" {
+		return "", nil
+	}
+	return name, r.drivers[name]
+}
+
+// pick is the route of g the sms to digits is sent through.
+func (r *Router) pick(g group, digits string) Route {
+	if len(g.routes) == 1 {
+		return g.routes[0]
+	}
+	if r.Sticky {
+		return sticky(g, digits)
+	}
+	n := rand.IntN(g.total)
+	for _, route := range g.routes {
+		if n < route.Weight {
+			return route
+		}
+		n -= route.Weight
+	}
+	return g.routes[len(g.routes)-1]
+}
+
+// sticky picks the route of g with the highest weighted score for digits
+// (weighted rendezvous hashing): every destination keeps its route, and the
+// routes get destinations in proportion to their weights.
+func sticky(g group, digits string) Route {
+	best, bestScore := g.routes[0], math.Inf(-1)
+	for _, route := range g.routes {
+		h := fnv.New64a()
+		h.Write([]byte(route.Driver))
+		h.Write([]byte{0})
+		h.Write([]byte(digits))
+		// uniform in (0, 1)
+		u := (float64(mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -float64(route.Weight) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = route, score
+		}
+	}
+	return best
+}
+
+// mix spreads the bits of the fnv hash of destinations a digit apart, which
+// otherwise differ little in the high bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Driver returns the driver named name, if any route or the fallback uses it.
+func (r *Router) Driver(name string) (Sender, bool) {
+	d, ok := r.drivers[name]
+	return d, ok
+}
+
+// Reporters are the drivers routed to that receive their delivery reports, by name.
+func (r *Router) Reporters() map[string]Reporter {
+	reporters := make(map[string]Reporter)
+	for name, d := range r.drivers {
+		if rep, ok := d.(Reporter); ok {
+			reporters[name] = rep
+		}
+	}
+	return reporters
+}
+
+// Checkers are the drivers routed to that can test their credentials, by name.
+func (r *Router) Checkers() map[string]Checker {
+	checkers := make(map[string]Checker)
+	for name, d := range r.drivers {
+		if c, ok := d.(Checker); ok {
+			checkers[name] = c
+		}
+	}
+	return checkers
+}
@@ -0,0 +1,187 @@
+package carrier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/status"
+	"github.com/alireza-karampour/sms/pkg/ucp"
+)
+
+// UCPConfig configures the UCP/EMI driver, for aggregators whose SMSC only speaks
+// the legacy protocol.
+type UCPConfig struct {
+	// Address is the host:port of the SMSC
+	Address  string `json:"address"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	TLS      bool   `json:"tls,omitempty"`
+	// Senders are the registered senders to use instead of the sender of the sms,
+	// by calling code of the destination, see SenderFor
+	Senders map[string]string `json:"senders,omitempty"`
+	// Keepalive and Timeout default to 60s and 30s, see ucp.Config
+	Keepalive time.Duration `json:"-"`
+	Timeout   time.Duration `json:"-"`
+}
+
+// UCP submits sms over a UCP session it keeps open with the SMSC. the delivery
+// notifications come back over the same session instead of a callback, see Reporter.
+type UCP struct {
+	cfg    UCPConfig
+	client *ucp.Client
+}
+
+// NewUCP connects to the SMSC in the background until ctx is done. sms submitted
+// while there is no session fail with a retryable error.
+func NewUCP(ctx context.Context, cfg UCPConfig) (*UCP, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	u := &UCP{
+		cfg: cfg,
+		client: ucp.NewClient(ucp.Config{
+			Address:   cfg.Address,
+			Login:     cfg.Login,
+			Password:  cfg.Password,
+			TLS:       cfg.TLS,
+			Keepalive: cfg.Keepalive,
+			Timeout:   cfg.Timeout,
+		}),
+	}
+	go u.client.Run(ctx)
+	return u, nil
+}
+
+func (cfg *UCPConfig) validate() error {
+	if cfg.Address == "" || cfg.Login == "" {
+		return fmt.Errorf("ucp needs an address and a login")
+	}
+	return nil
+}
+
+func (cfg *UCPConfig) redact() {
+	cfg.Password = redact(cfg.Password)
+}
+
+func (cfg *UCPConfig) sender(ctx context.Context) (Sender, error) {
+	return NewUCP(ctx, *cfg)
+}
+
+// ucpErrors maps the error codes of a refused submission to what they mean for
+// the message. like with vonageErrors, errors of the session or the account are
+// retried.
+var ucpErrors = map[string]Error{
+	"01": {Reason: "checksum error", Retry: true},
+	"02": {Reason: "syntax error"},
+	"03": {Reason: "operation not supported", Retry: true},
+	"04": {Reason: "operation not allowed", Retry: true},
+	"05": {Reason: "call barring active"},
+	"06": {Reason: "invalid recipient", FailureReason: status.ReasonInvalidNumber},
+	"07": {Reason: "authentication failure", Retry: true},
+	"08": {Reason: "legitimisation code failure", Retry: true},
+	"23": {Reason: "message type not supported"},
+	"24": {Reason: "message too long"},
+	"26": {Reason: "message type not valid for the recipient"},
+}
+
+// UCPError is the *Error of a submission the SMSC refused with code.
+func UCPError(code string) *Error {
+	e, ok := ucpErrors[code]
+	if !ok {
+		e = Error{Reason: "unknown error"}
+	}
+	e.Code = code
+	return &e
+}
+
+// Send submits m, with a delivery notification requested. the id of the message
+// is the "<recipient>:<timestamp>" the SMSC answered with.
+func (u *UCP) Send(ctx context.Context, m Message) (string, error) {
+	id, err := u.client.Submit(ctx, SenderFor(u.cfg.Senders, m.From, m.To), phone.Normalize(m.To), m.Text)
+	var uErr *ucp.Error
+	if errors.As(err, &uErr) {
+		return "", UCPError(uErr.Code)
+	}
+	return id, err
+}
+
+// Check logs in to the SMSC on a session of its own and closes it again.
+func (u *UCP) Check(ctx context.Context) error {
+	return u.client.Check(ctx)
+}
+
+// OnReport passes the delivery notifications received over the session to
+// handler. those not moving the sms, e.g. buffered ones, are accepted unhandled.
+func (u *UCP) OnReport(handler func(context.Context, Report) error) {
+	u.client.OnNotification(func(ctx context.Context, n ucp.Notification) error {
+		st, reason, ok := UCPDlr(n.Status, n.Reason)
+		if !ok {
+			return nil
+		}
+		return handler(ctx, Report{
+			Ref:           n.MessageID(),
+			Status:        st,
+			Reason:        reason,
+			FailureReason: ucpDlrFailures[n.Reason],
+		})
+	})
+}
+
+// ucpDlrReasons describe the reason codes of UCP delivery notifications.
+var ucpDlrReasons = map[string]string{
+	"000": "unknown subscriber",
+	"001": "service temporarily unavailable",
+	"003": "service temporarily unavailable",
+	"004": "illegal error code",
+	"005": "network timeout",
+	"100": "facility not supported",
+	"101": "unknown subscriber",
+	"102": "facility not provided",
+	"103": "call barred",
+	"104": "operation barred",
+	"105": "SC congestion",
+	"106": "facility not supported",
+	"107": "absent subscriber",
+	"108": "delivery fail",
+	"109": "SC congestion",
+	"110": "protocol error",
+	"111": "MS not equipped",
+	"112": "unknown SC",
+	"113": "SC congestion",
+	"114": "illegal MS",
+	"115": "MS not a subscriber",
+	"116": "error in MS",
+	"117": "SMS lower layer not provisioned",
+	"118": "system fail",
+}
+
+// ucpDlrFailures catalogs the reason codes of UCP delivery notifications in the
+// normalized failure reasons, the others are status.ReasonUnknown.
+var ucpDlrFailures = map[string]string{
+	"000": status.ReasonInvalidNumber,
+	"101": status.ReasonInvalidNumber,
+	"107": status.ReasonAbsentSubscriber,
+	"114": status.ReasonInvalidNumber,
+	"115": status.ReasonInvalidNumber,
+}
+
+// UCPDlr translates the status and reason code of a delivery notification. ok is
+// false for buffered messages, the SMSC keeps trying and notifies again.
+func UCPDlr(dst, rsn string) (st status.Status, reason string, ok bool) {
+	switch dst {
+	case "0":
+		return status.Delivered, "", true
+	case "2":
+		reason = ucpDlrReasons[rsn]
+		if reason == "" {
+			reason = "error " + rsn
+		}
+		return status.Failed, reason, true
+	default:
+		return "", "", false
+	}
+}
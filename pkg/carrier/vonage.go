@@ -0,0 +1,318 @@
+package carrier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alireza-karampour/sms/pkg/gsm"
+	"github.com/alireza-karampour/sms/pkg/phone"
+	"github.com/alireza-karampour/sms/pkg/status"
+)
+
+const vonageEndpoint = "https://rest.nexmo.com"
+
+var (
+	ErrVonageSignature = errors.New("vonage: invalid signature")
+	ErrVonageTimestamp = errors.New("vonage: timestamp outside the tolerance")
+)
+
+// VonageConfig configures the Vonage (formerly Nexmo) SMS API driver.
+type VonageConfig struct {
+	// Endpoint defaults to https://rest.nexmo.com
+	Endpoint  string `json:"endpoint,omitempty"`
+	APIKey    string `json:"api_key"`
+	APISecret string `json:"api_secret"`
+	// Callback, when set, is where Vonage posts the delivery reports, instead of
+	// the url configured on the account. it should be /callbacks/vonage/dlr of the api.
+	Callback string `json:"callback,omitempty"`
+	// Senders are the registered senders to use instead of the sender of the sms,
+	// by calling code of the destination, see SenderFor
+	Senders map[string]string `json:"senders,omitempty"`
+}
+
+// Vonage submits sms through the Vonage SMS API.
+type Vonage struct {
+	cfg    VonageConfig
+	client *http.Client
+}
+
+func NewVonage(cfg VonageConfig) (*Vonage, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = vonageEndpoint
+	}
+	return &Vonage{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (cfg *VonageConfig) validate() error {
+	if cfg.APIKey == "" || cfg.APISecret == "" {
+		return fmt.Errorf("vonage needs an api key and an api secret")
+	}
+	return nil
+}
+
+func (cfg *VonageConfig) redact() {
+	cfg.APISecret = redact(cfg.APISecret)
+}
+
+func (cfg *VonageConfig) sender(ctx context.Context) (Sender, error) {
+	return NewVonage(*cfg)
+}
+
+// vonageErrors maps the status codes of a submission to what they mean for the
+// message, see https://developer.vonage.com/en/messaging/sms/guides/troubleshooting-sms.
+// errors of the account or its configuration are retried: the message is fine and
+// goes through once they are fixed, or fails when the attempts run out.
+var vonageErrors = map[string]Error{
+	"1":  {Reason: "throttled", Retry: true},
+	"2":  {Reason: "missing parameters"},
+	"3":  {Reason: "invalid parameters"},
+	"4":  {Reason: "invalid credentials", Retry: true},
+	"5":  {Reason: "internal error", Retry: true},
+	"6":  {Reason: "invalid message"},
+	"7":  {Reason: "number barred", FailureReason: status.ReasonInvalidNumber},
+	"8":  {Reason: "partner account barred", Retry: true},
+	"9":  {Reason: "partner quota violation", Retry: true},
+	"10": {Reason: "too many existing binds", Retry: true},
+	"11": {Reason: "account not enabled for http", Retry: true},
+	"12": {Reason: "message too long"},
+	"14": {Reason: "invalid signature", Retry: true},
+	"15": {Reason: "invalid sender address"},
+	"22": {Reason: "invalid network code"},
+	"23": {Reason: "invalid callback url", Retry: true},
+	"29": {Reason: "non-whitelisted destination"},
+	"32": {Reason: "signature and api secret disallowed", Retry: true},
+	"33": {Reason: "number de-activated", FailureReason: status.ReasonInvalidNumber},
+}
+
+// VonageError is the *Error of a submission Vonage refused with code.
+func VonageError(code, text string) *Error {
+	e, ok := vonageErrors[code]
+	if !ok {
+		e = Error{Reason: "unknown error"}
+	}
+	if text != "" {
+		e.Reason = text
+	}
+	e.Code = code
+	return &e
+}
+
+func (v *Vonage) Send(ctx context.Context, m Message) (string, error) {
+	form := url.Values{
+		"api_key":           {v.cfg.APIKey},
+		"api_secret":        {v.cfg.APISecret},
+		"from":              {vonageSender(SenderFor(v.cfg.Senders, m.From, m.To))},
+		"to":                {phone.Normalize(m.To)},
+		"text":              {m.Text},
+		"client-ref":        {m.Ref()},
+		"status-report-req": {"1"},
+	}
+	if !gsm.IsGSM7(m.Text) {
+		form.Set("type", "unicode")
+	}
+	if v.cfg.Callback != "" {
+		form.Set("callback", v.cfg.Callback)
+	}
+	endpoint := strings.TrimSuffix(v.cfg.Endpoint, "/") + "/sms/json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("vonage: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	var reply struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			MessageID string `json:"message-id"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	err = json.Unmarshal(body, &reply)
+	if err != nil {
+		return "", fmt.Errorf("vonage: %w", err)
+	}
+	if len(reply.Messages) == 0 {
+		return "", fmt.Errorf("vonage: no message in the reply")
+	}
+	// long messages are split into parts, each with its own id and report. the
+	// first one refused fails the message, the id of the first part stands for it
+	for _, part := range reply.Messages {
+		if part.Status != "0" {
+			return "", VonageError(part.Status, part.ErrorText)
+		}
+	}
+	return reply.Messages[0].MessageID, nil
+}
+
+// Check asks Vonage for the balance of the account, which it only answers with
+// valid credentials.
+func (v *Vonage) Check(ctx context.Context) error {
+	query := url.Values{
+		"api_key":    {v.cfg.APIKey},
+		"api_secret": {v.cfg.APISecret},
+	}
+	endpoint := strings.TrimSuffix(v.cfg.Endpoint, "/") + "/account/get-balance?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("vonage: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// vonageSender is from the way Vonage takes it: numbers as digits only, alphanumeric
+// sender ids as they are.
+func vonageSender(from string) string {
+	if strings.ContainsFunc(from, unicode.IsLetter) {
+		return from
+	}
+	return phone.Normalize(from)
+}
+
+// vonageDlrReasons describe the err-code of Vonage delivery reports.
+var vonageDlrReasons = map[string]string{
+	"1":  "unknown",
+	"2":  "absent subscriber, temporary",
+	"3":  "absent subscriber, permanent",
+	"4":  "call barred by user",
+	"5":  "portability error",
+	"6":  "anti-spam rejection",
+	"7":  "handset busy",
+	"8":  "network error",
+	"9":  "illegal number",
+	"10": "illegal message",
+	"11": "unroutable",
+	"12": "destination unreachable",
+	"13": "subscriber age restriction",
+	"14": "number blocked by carrier",
+	"15": "prepaid insufficient funds",
+	"16": "gateway quota exceeded",
+	"50": "entity filter",
+	"51": "header filter",
+	"52": "content filter",
+	"53": "consent filter",
+	"54": "regulation error",
+	"99": "general error",
+}
+
+// vonageDlrFailures catalogs the err-codes of Vonage delivery reports in the
+// normalized failure reasons, the others are status.ReasonUnknown.
+var vonageDlrFailures = map[string]string{
+	"2":  status.ReasonAbsentSubscriber,
+	"3":  status.ReasonAbsentSubscriber,
+	"6":  status.ReasonSpamFiltered,
+	"7":  status.ReasonAbsentSubscriber,
+	"9":  status.ReasonInvalidNumber,
+	"11": status.ReasonInvalidNumber,
+	"12": status.ReasonAbsentSubscriber,
+	"14": status.ReasonSpamFiltered,
+	"50": status.ReasonSpamFiltered,
+	"51": status.ReasonSpamFiltered,
+	"52": status.ReasonSpamFiltered,
+	"53": status.ReasonSpamFiltered,
+}
+
+// VonageFailureReason is the normalized reason of a Vonage delivery report with
+// errCode, see status.FailureReason.
+func VonageFailureReason(errCode string) string {
+	return vonageDlrFailures[errCode]
+}
+
+// VonageDlr translates the status and err-code of a Vonage delivery report. ok is
+// false for reports that don't move the sms, e.g. "buffered" or "unknown".
+func VonageDlr(dlrStatus, errCode string) (st status.Status, reason string, ok bool) {
+	switch dlrStatus {
+	case "delivered":
+		return status.Delivered, "", true
+	case "expired":
+		st = status.Expired
+	case "failed", "rejected":
+		st = status.Failed
+	case "accepted":
+		return status.Submitted, "", true
+	default:
+		return "", "", false
+	}
+	if errCode != "" && errCode != "0" {
+		reason = vonageDlrReasons[errCode]
+		if reason == "" {
+			reason = "error " + errCode
+		}
+	}
+	return st, reason, true
+}
+
+// SignVonage is the HMAC-SHA256 signature of params, the way Vonage signs the
+// requests it sends: the parameters but sig sorted by name, joined as "&name=value"
+// with '&' and '=' in values replaced by '_'.
+func SignVonage(secret string, params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "sig" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	clean := strings.NewReplacer("&", "_", "=", "_")
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString("&" + name + "=" + clean.Replace(params.Get(name)))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(b.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyVonage checks the sig of a signed Vonage request against secret, and that
+// its timestamp is within tolerance of now.
+func VerifyVonage(secret string, params url.Values, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(params.Get("timestamp"), 10, 64)
+	if err != nil {
+		return ErrVonageTimestamp
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrVonageTimestamp
+	}
+	want := SignVonage(secret, params)
+	if !hmac.Equal([]byte(strings.ToLower(params.Get("sig"))), []byte(want)) {
+		return ErrVonageSignature
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Package certs serves TLS certificates that can be replaced while the
+// listeners using them keep running.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader serves the key pair of a cert and a key file, and loads them again
+// when either file changes, e.g. when cert-manager or certbot renews them.
+// connections open before a reload keep their certificate.
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the key pair of certFile and keyFile.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both a cert and a key file are required")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	_, err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lastModified is the last time either file was modified.
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// Reload loads the key pair again if either file changed since it was last
+// loaded and reports whether it did. a pair that fails to load, e.g. a cert
+// written before its key, leaves the current one in place.
+func (r *Reloader) Reload() (bool, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := r.cert == nil || !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.cert = &pair
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate is the tls.Config callback serving the current key pair.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Run checks the files for changes every interval until ctx is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			logrus.Errorf("failed to reload the certificate %s: %s", r.certFile, err)
+			continue
+		}
+		if reloaded {
+			logrus.Infof("reloaded the certificate %s", r.certFile)
+		}
+	}
+}
+
+// Config is a server tls.Config serving the certificates of getCertificate.
+func Config(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
@@ -0,0 +1,89 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/certs"
+)
+
+// writePair writes a self-signed key pair with serial, modified at modTime.
+func writePair(certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "sms.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)).To(Succeed())
+	Expect(os.Chtimes(certFile, modTime, modTime)).To(Succeed())
+	Expect(os.Chtimes(keyFile, modTime, modTime)).To(Succeed())
+}
+
+func serial(r *certs.Reloader) int64 {
+	cert, err := r.GetCertificate(nil)
+	Expect(err).NotTo(HaveOccurred())
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	Expect(err).NotTo(HaveOccurred())
+	return leaf.SerialNumber.Int64()
+}
+
+var _ = Describe("Reloader", func() {
+	var certFile, keyFile string
+	start := time.Now().Add(-time.Minute)
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+		writePair(certFile, keyFile, 1, start)
+	})
+
+	It("should require both files", func() {
+		_, err := certs.NewReloader(certFile, "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should load the pair again once it changes", func() {
+		r, err := certs.NewReloader(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serial(r)).To(Equal(int64(1)))
+
+		reloaded, err := r.Reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded).To(BeFalse())
+
+		writePair(certFile, keyFile, 2, start.Add(time.Second))
+		reloaded, err = r.Reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded).To(BeTrue())
+		Expect(serial(r)).To(Equal(int64(2)))
+	})
+
+	It("should keep the current pair when the new one doesn't load", func() {
+		r, err := certs.NewReloader(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.WriteFile(keyFile, []byte("half written"), 0o600)).To(Succeed())
+		_, err = r.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(serial(r)).To(Equal(int64(1)))
+	})
+})
@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var ErrTemplate = errors.New("unknown template")
+
+// Capabilities is what a driver can deliver in one message. Send negotiates
+// every message down to them.
+type Capabilities struct {
+	// MaxLength is the longest text in runes, 0 is unlimited
+	MaxLength int
+	// MaxCaption is the longest text sent along with media
+	MaxCaption int
+	Media      bool
+	// Templates means the provider renders templates itself, e.g. the
+	// pre-approved templates of WhatsApp Business
+	Templates bool
+}
+
+// Template is a message template of one channel, configured under <channel>.templates.<name>.
+type Template struct {
+	// Name and Language identify the template at providers that render it
+	Name     string `mapstructure:"name"`
+	Language string `mapstructure:"language"`
+	// Text is rendered by Render for providers that don't, with {{1}}, {{2}}... replaced by the params
+	Text string `mapstructure:"text"`
+}
+
+// Message is a chat message to one recipient.
+type Message struct {
+	To       string
+	Text     string
+	MediaURL string
+	Template *Template
+	Params   []string
+}
+
+// Driver delivers chat messages through a provider.
+type Driver interface {
+	Capabilities() Capabilities
+	// Send delivers msg as is, it must fit the capabilities of the driver
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromViper builds the driver selected by <channel>.driver: "log" (default), or
+// "cloud" for whatsapp and "bot" for telegram.
+func FromViper(ctx context.Context, channel string) (Driver, error) {
+	switch driver := viper.GetString(channel + ".driver"); {
+	case driver == "" || driver == "log":
+		return Log{}, nil
+	case channel == "whatsapp" && driver == "cloud":
+		token, err := secrets.Get(ctx, "whatsapp.cloud.token")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read whatsapp.cloud.token: %w", err)
+		}
+		return NewWhatsApp(WhatsAppConfig{
+			Endpoint:      viper.GetString("whatsapp.cloud.endpoint"),
+			PhoneNumberID: viper.GetString("whatsapp.cloud.phonenumberid"),
+			Token:         token,
+		})
+	case channel == "telegram" && driver == "bot":
+		token, err := secrets.Get(ctx, "telegram.bot.token")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read telegram.bot.token: %w", err)
+		}
+		return NewTelegram(TelegramConfig{
+			Endpoint: viper.GetString("telegram.bot.endpoint"),
+			Token:    token,
+		})
+	default:
+		return nil, fmt.Errorf("unknown %s driver %q", channel, driver)
+	}
+}
+
+// LookupTemplate reads the template name of channel from <channel>.templates.<name>.
+func LookupTemplate(channel, name string) (*Template, error) {
+	key := channel + ".templates." + name
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("%w %q for %s", ErrTemplate, name, channel)
+	}
+	var t Template
+	err := viper.UnmarshalKey(key, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Name == "" {
+		t.Name = name
+	}
+	return &t, nil
+}
+
+// Render replaces the placeholders {{1}}, {{2}}... of text with params.
+func Render(text string, params []string) string {
+	pairs := make([]string, 0, 2*len(params))
+	for i, p := range params {
+		pairs = append(pairs, "{{"+strconv.Itoa(i+1)+"}}", p)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Negotiate turns msg into the messages d can deliver: templates the provider doesn't
+// render are rendered, media it can't send becomes a link, a caption that is too long
+// is sent after the media and text longer than MaxLength is split.
+func Negotiate(caps Capabilities, msg Message) []Message {
+	if msg.Template != nil {
+		if caps.Templates {
+			return []Message{msg}
+		}
+		text := Render(msg.Template.Text, msg.Params)
+		if msg.Text != "" {
+			text = strings.TrimSpace(text + "\n" + msg.Text)
+		}
+		msg.Text, msg.Template, msg.Params = text, nil, nil
+	}
+	if msg.MediaURL != "" && !caps.Media {
+		msg.Text = strings.TrimSpace(msg.Text + "\n" + msg.MediaURL)
+		msg.MediaURL = ""
+	}
+
+	var out []Message
+	if msg.MediaURL != "" {
+		media := Message{To: msg.To, MediaURL: msg.MediaURL}
+		if caps.MaxCaption == 0 || len([]rune(msg.Text)) <= caps.MaxCaption {
+			media.Text = msg.Text
+			return []Message{media}
+		}
+		out = append(out, media)
+	}
+	for _, part := range split(msg.Text, caps.MaxLength) {
+		out = append(out, Message{To: msg.To, Text: part})
+	}
+	return out
+}
+
+// Send negotiates msg down to the capabilities of d and sends the result in order.
+func Send(ctx context.Context, d Driver, msg Message) error {
+	for _, m := range Negotiate(d.Capabilities(), msg) {
+		err := d.Send(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split cuts text into parts of at most max runes, preferring to cut after whitespace.
+func split(text string, max int) []string {
+	runes := []rune(text)
+	if max <= 0 || len(runes) <= max {
+		return []string{text}
+	}
+	var parts []string
+	for len(runes) > max {
+		cut := max
+		for i := max; i > max/2; i-- {
+			if runes[i-1] == ' ' || runes[i-1] == '\n' {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// Log only logs the recipient of every message. it is meant for development and never fails.
+type Log struct{}
+
+func (Log) Capabilities() Capabilities {
+	return Capabilities{Media: true, Templates: true}
+}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	logrus.WithField("to", mask.Phone(msg.To)).Info("chat message not sent, driver is log")
+	return nil
+}
@@ -0,0 +1,161 @@
+package chat_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/chat"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Chat", func() {
+	otp := &chat.Template{Name: "otp_code", Language: "en_US", Text: "Your code is {{1}}"}
+
+	Context("Negotiate", func() {
+		It("leaves templates to providers that render them", func() {
+			msg := chat.Message{To: "1", Template: otp, Params: []string{"1234"}}
+			Expect(chat.Negotiate(chat.Capabilities{Templates: true}, msg)).To(Equal([]chat.Message{msg}))
+		})
+
+		It("renders templates for providers that don't", func() {
+			msgs := chat.Negotiate(chat.Capabilities{}, chat.Message{To: "1", Template: otp, Params: []string{"1234"}})
+			Expect(msgs).To(Equal([]chat.Message{{To: "1", Text: "Your code is 1234"}}))
+		})
+
+		It("turns media into a link when the provider can't send it", func() {
+			msgs := chat.Negotiate(chat.Capabilities{}, chat.Message{To: "1", Text: "receipt", MediaURL: "https://x/r.pdf"})
+			Expect(msgs).To(Equal([]chat.Message{{To: "1", Text: "receipt\nhttps://x/r.pdf"}}))
+		})
+
+		It("sends captions that are too long after the media", func() {
+			caps := chat.Capabilities{MaxCaption: 5, Media: true}
+			Expect(chat.Negotiate(caps, chat.Message{To: "1", Text: "hi", MediaURL: "https://x/a.png"})).To(Equal(
+				[]chat.Message{{To: "1", Text: "hi", MediaURL: "https://x/a.png"}},
+			))
+			Expect(chat.Negotiate(caps, chat.Message{To: "1", Text: "a long caption", MediaURL: "https://x/a.png"})).To(Equal(
+				[]chat.Message{{To: "1", MediaURL: "https://x/a.png"}, {To: "1", Text: "a long caption"}},
+			))
+		})
+
+		It("splits text longer than the provider allows, after whitespace", func() {
+			msgs := chat.Negotiate(chat.Capabilities{MaxLength: 10}, chat.Message{To: "1", Text: "hello there world"})
+			Expect(msgs).To(Equal([]chat.Message{{To: "1", Text: "hello "}, {To: "1", Text: "there "}, {To: "1", Text: "world"}}))
+			msgs = chat.Negotiate(chat.Capabilities{MaxLength: 4}, chat.Message{To: "1", Text: "ééééé"})
+			Expect(msgs).To(Equal([]chat.Message{{To: "1", Text: "éééé"}, {To: "1", Text: "é"}}))
+		})
+	})
+
+	It("looks templates up per channel", func() {
+		viper.Set("telegram.templates.otp", map[string]any{"text": "code: {{1}}"})
+		DeferCleanup(viper.Reset)
+		t, err := chat.LookupTemplate("telegram", "otp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*t).To(Equal(chat.Template{Name: "otp", Text: "code: {{1}}"}))
+		_, err = chat.LookupTemplate("whatsapp", "otp")
+		Expect(err).To(MatchError(chat.ErrTemplate))
+	})
+
+	Context("WhatsApp", func() {
+		var (
+			srv  *httptest.Server
+			got  map[string]any
+			auth string
+			path string
+		)
+
+		BeforeEach(func() {
+			got = nil
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				auth, path = r.Header.Get("Authorization"), r.URL.Path
+				Expect(json.NewDecoder(r.Body).Decode(&got)).To(Succeed())
+				if got["to"] == "0" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":{"message":"invalid recipient","code":131030}}`))
+					return
+				}
+				w.Write([]byte(`{"messages":[{"id":"wamid.1"}]}`))
+			}))
+			DeferCleanup(srv.Close)
+		})
+
+		send := func(msg chat.Message) error {
+			w, err := chat.NewWhatsApp(chat.WhatsAppConfig{Endpoint: srv.URL, PhoneNumberID: "100", Token: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			return chat.Send(context.Background(), w, msg)
+		}
+
+		It("sends templates with their params", func() {
+			Expect(send(chat.Message{To: "+15550101", Template: otp, Params: []string{"1234"}})).To(Succeed())
+			Expect(auth).To(Equal("Bearer secret"))
+			Expect(path).To(Equal("/100/messages"))
+			Expect(got).To(HaveKeyWithValue("to", "15550101"))
+			Expect(got).To(HaveKeyWithValue("type", "template"))
+			Expect(got["template"]).To(HaveKeyWithValue("name", "otp_code"))
+			Expect(got["template"]).To(HaveKeyWithValue("components", ContainElement(
+				HaveKeyWithValue("parameters", ContainElement(HaveKeyWithValue("text", "1234"))),
+			)))
+		})
+
+		It("sends media by type with a caption", func() {
+			Expect(send(chat.Message{To: "1", Text: "receipt", MediaURL: "https://x/r.PDF?sig=1"})).To(Succeed())
+			Expect(got).To(HaveKeyWithValue("type", "document"))
+			Expect(got["document"]).To(Equal(map[string]any{"link": "https://x/r.PDF?sig=1", "caption": "receipt"}))
+		})
+
+		It("fails with the message of the error", func() {
+			Expect(send(chat.Message{To: "0", Text: "hi"})).To(MatchError(ContainSubstring("invalid recipient")))
+		})
+	})
+
+	Context("Telegram", func() {
+		It("renders templates and sends photos to the right method", func() {
+			var methods []string
+			var bodies []map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				methods = append(methods, r.URL.Path)
+				var body map[string]string
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				bodies = append(bodies, body)
+				w.Write([]byte(`{"ok":true,"result":{}}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			t, err := chat.NewTelegram(chat.TelegramConfig{Endpoint: srv.URL, Token: "42:abc"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chat.Send(context.Background(), t, chat.Message{To: "99", Template: otp, Params: []string{"1234"}})).To(Succeed())
+			Expect(chat.Send(context.Background(), t, chat.Message{To: "99", Text: strings.Repeat("x", 1100), MediaURL: "https://x/a.jpg"})).To(Succeed())
+			Expect(methods).To(Equal([]string{"/bot42:abc/sendMessage", "/bot42:abc/sendPhoto", "/bot42:abc/sendMessage"}))
+			Expect(bodies[0]).To(Equal(map[string]string{"chat_id": "99", "text": "Your code is 1234"}))
+			Expect(bodies[1]).To(Equal(map[string]string{"chat_id": "99", "photo": "https://x/a.jpg"}))
+		})
+
+		It("fails with the description of the error", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"ok":false,"description":"Forbidden: bot was blocked by the user"}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			t, err := chat.NewTelegram(chat.TelegramConfig{Endpoint: srv.URL, Token: "42:abc"})
+			Expect(err).NotTo(HaveOccurred())
+			err = t.Send(context.Background(), chat.Message{To: "99", Text: "hi"})
+			Expect(err).To(MatchError(ContainSubstring("bot was blocked")))
+		})
+
+		It("keeps the token out of connection errors", func() {
+			t, err := chat.NewTelegram(chat.TelegramConfig{Endpoint: "http://127.0.0.1:1", Token: "42:abc"})
+			Expect(err).NotTo(HaveOccurred())
+			err = t.Send(context.Background(), chat.Message{To: "99", Text: "hi"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("42:abc"))
+		})
+	})
+})
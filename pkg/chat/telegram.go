@@ -0,0 +1,109 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const telegramEndpoint = "https://api.telegram.org"
+
+// TelegramConfig configures the Telegram bot driver.
+type TelegramConfig struct {
+	// Endpoint defaults to https://api.telegram.org
+	Endpoint string
+	Token    string
+}
+
+// Telegram sends messages as a Telegram bot. recipients are chat ids, or @usernames
+// of channels, and must have started a chat with the bot. Telegram has no templates,
+// so they are rendered before sending.
+type Telegram struct {
+	cfg    TelegramConfig
+	client *http.Client
+}
+
+func NewTelegram(cfg TelegramConfig) (*Telegram, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("telegram needs a bot token")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = telegramEndpoint
+	}
+	return &Telegram{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (t *Telegram) Capabilities() Capabilities {
+	return Capabilities{MaxLength: 4096, MaxCaption: 1024, Media: true}
+}
+
+func (t *Telegram) Send(ctx context.Context, msg Message) error {
+	method := "sendMessage"
+	body := map[string]string{"chat_id": msg.To}
+	if msg.MediaURL != "" {
+		switch mediaType(msg.MediaURL) {
+		case "image":
+			method = "sendPhoto"
+			body["photo"] = msg.MediaURL
+		case "video":
+			method = "sendVideo"
+			body["video"] = msg.MediaURL
+		default:
+			method = "sendDocument"
+			body["document"] = msg.MediaURL
+		}
+		if msg.Text != "" {
+			body["caption"] = msg.Text
+		}
+	} else {
+		body["text"] = msg.Text
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(t.cfg.Endpoint, "/"), t.cfg.Token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(req)
+	if err != nil {
+		// the url carries the token, keep it out of logs
+		return fmt.Errorf("telegram: %s failed: %w", method, unwrapURLError(err))
+	}
+	defer res.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var reply struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.Unmarshal(raw, &reply)
+	if res.StatusCode >= 200 && res.StatusCode < 300 && reply.OK {
+		return nil
+	}
+	if reply.Description == "" {
+		reply.Description = string(bytes.TrimSpace(raw))
+	}
+	return fmt.Errorf("telegram: %s: %s", res.Status, reply.Description)
+}
+
+// unwrapURLError drops the url, and with it the bot token, from errors of the http client.
+func unwrapURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
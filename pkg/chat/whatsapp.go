@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const whatsAppEndpoint = "https://graph.facebook.com/v21.0"
+
+// WhatsAppConfig configures the WhatsApp Business Cloud API driver.
+type WhatsAppConfig struct {
+	// Endpoint defaults to https://graph.facebook.com/v21.0
+	Endpoint string
+	// PhoneNumberID is the business number messages are sent from
+	PhoneNumberID string
+	Token         string
+}
+
+// WhatsApp sends messages through the WhatsApp Business Cloud API. recipients outside
+// the 24 hour customer service window only accept templates.
+type WhatsApp struct {
+	cfg    WhatsAppConfig
+	client *http.Client
+}
+
+func NewWhatsApp(cfg WhatsAppConfig) (*WhatsApp, error) {
+	if cfg.PhoneNumberID == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("whatsapp needs a phone number id and a token")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = whatsAppEndpoint
+	}
+	return &WhatsApp{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (w *WhatsApp) Capabilities() Capabilities {
+	return Capabilities{MaxLength: 4096, MaxCaption: 1024, Media: true, Templates: true}
+}
+
+func (w *WhatsApp) Send(ctx context.Context, msg Message) error {
+	body := map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                strings.TrimPrefix(msg.To, "+"),
+	}
+	switch {
+	case msg.Template != nil:
+		params := make([]map[string]string, 0, len(msg.Params))
+		for _, p := range msg.Params {
+			params = append(params, map[string]string{"type": "text", "text": p})
+		}
+		template := map[string]any{
+			"name":     msg.Template.Name,
+			"language": map[string]string{"code": msg.Template.Language},
+		}
+		if len(params) > 0 {
+			template["components"] = []map[string]any{{"type": "body", "parameters": params}}
+		}
+		body["type"] = "template"
+		body["template"] = template
+	case msg.MediaURL != "":
+		kind := mediaType(msg.MediaURL)
+		media := map[string]string{"link": msg.MediaURL}
+		if msg.Text != "" {
+			media["caption"] = msg.Text
+		}
+		body["type"] = kind
+		body[kind] = media
+	default:
+		body["type"] = "text"
+		body["text"] = map[string]string{"body": msg.Text}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/%s/messages", strings.TrimSuffix(w.cfg.Endpoint, "/"), url.PathEscape(w.cfg.PhoneNumberID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+w.cfg.Token)
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var reply struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(raw, &reply)
+	if reply.Error.Message == "" {
+		reply.Error.Message = string(bytes.TrimSpace(raw))
+	}
+	return fmt.Errorf("whatsapp: %s: %s", res.Status, reply.Error.Message)
+}
+
+// mediaType guesses the WhatsApp message type of a media url from its extension.
+func mediaType(rawURL string) string {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		p = u.Path
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return "image"
+	case ".mp4", ".3gp":
+		return "video"
+	case ".mp3", ".ogg", ".amr", ".aac":
+		return "audio"
+	default:
+		return "document"
+	}
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// SchemaVersion is the version of schema.sql this build expects, the latest
+// one recorded in the schema_version table.
+const SchemaVersion = 14
+
+// DSN builds the primary connection string from the <section>.postgres config.
+// the username and password may come from files or secret stores, see secrets.Get.
+func DSN(ctx context.Context, section string) (string, error) {
+	username, err := secrets.Get(ctx, section+".postgres.username")
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s.postgres.username: %w", section, err)
+	}
+	password, err := secrets.Get(ctx, section+".postgres.password")
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s.postgres.password: %w", section, err)
+	}
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(username, password),
+		Host:     fmt.Sprintf("%s:%d", viper.GetString(section+".postgres.address"), viper.GetInt(section+".postgres.port")),
+		Path:     "/postgres",
+		RawQuery: "sslmode=disable",
+	}
+	return u.String(), nil
+}
+
+// Cluster holds the primary pool and an optional read replica.
+// reads go to the replica while it is healthy and fall back to the primary otherwise.
+type Cluster struct {
+	Primary *pgxpool.Pool
+	Replica *pgxpool.Pool
+	// ReadOnly is set when every transaction of the pools is read only, see
+	// ConnectChecked
+	ReadOnly bool
+
+	replicaUp atomic.Bool
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewCluster(primary, replica *pgxpool.Pool) *Cluster {
+	c := &Cluster{
+		Primary: primary,
+		Replica: replica,
+		stop:    make(chan struct{}),
+	}
+	c.replicaUp.Store(replica != nil)
+	return c
+}
+
+// PoolConfig parses dsn and applies the <section>.postgres.pool settings on top of it.
+// zero values keep the pgxpool defaults.
+func PoolConfig(dsn, section string) (*pgxpool.Config, error) {
+	conf, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	prefix := section + ".postgres.pool."
+	if v := viper.GetInt32(prefix + "maxconns"); v > 0 {
+		conf.MaxConns = v
+	}
+	if v := viper.GetInt32(prefix + "minconns"); v > 0 {
+		conf.MinConns = v
+	}
+	if v := viper.GetDuration(prefix + "maxconnlifetime"); v > 0 {
+		conf.MaxConnLifetime = v
+	}
+	if v := viper.GetDuration(prefix + "maxconnidletime"); v > 0 {
+		conf.MaxConnIdleTime = v
+	}
+	if v := viper.GetDuration(prefix + "healthcheckperiod"); v > 0 {
+		conf.HealthCheckPeriod = v
+	}
+	if conf.MinConns > conf.MaxConns {
+		return nil, fmt.Errorf("%sminconns (%d) is greater than maxconns (%d)", prefix, conf.MinConns, conf.MaxConns)
+	}
+	return conf, nil
+}
+
+func newPool(ctx context.Context, dsn, section, role string, readOnly bool) (*pgxpool.Pool, error) {
+	conf, err := PoolConfig(dsn, section)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		conf.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	err = RegisterPoolMetrics(pool, section, role)
+	if err != nil {
+		logrus.Warnf("failed to register %s pool metrics: %s", role, err)
+	}
+	return pool, nil
+}
+
+// Connect opens the pools configured under <section>.postgres and verifies the primary.
+// an unreachable replica is not fatal; it is retried by the health check.
+func Connect(ctx context.Context, section string) (*Cluster, error) {
+	return connect(ctx, section, false)
+}
+
+func connect(ctx context.Context, section string, readOnly bool) (*Cluster, error) {
+	dsn, err := DSN(ctx, section)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := newPool(ctx, dsn, section, "primary", readOnly)
+	if err != nil {
+		return nil, err
+	}
+	err = primary.Ping(ctx)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+
+	replicaDSN, err := secrets.Get(ctx, section+".postgres.replica.dsn")
+	if err != nil {
+		primary.Close()
+		return nil, fmt.Errorf("failed to read %s.postgres.replica.dsn: %w", section, err)
+	}
+	if replicaDSN == "" {
+		c := NewCluster(primary, nil)
+		c.ReadOnly = readOnly
+		return c, nil
+	}
+	replica, err := newPool(ctx, replicaDSN, section, "replica", readOnly)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+
+	c := NewCluster(primary, replica)
+	c.ReadOnly = readOnly
+	c.checkReplica(ctx)
+	c.Watch(viper.GetDuration(section + ".postgres.replica.healthcheck"))
+	return c, nil
+}
+
+// Writer returns the pool every write must go to.
+func (c *Cluster) Writer() *pgxpool.Pool {
+	return c.Primary
+}
+
+// Reader returns the replica when it is configured and healthy, the primary otherwise.
+func (c *Cluster) Reader() *pgxpool.Pool {
+	if c.Replica != nil && c.replicaUp.Load() {
+		return c.Replica
+	}
+	return c.Primary
+}
+
+// Watch pings the replica every interval until Close is called.
+func (c *Cluster) Watch(interval time.Duration) {
+	if c.Replica == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-t.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				c.checkReplica(ctx)
+				cancel()
+			}
+		}
+	}()
+}
+
+func (c *Cluster) checkReplica(ctx context.Context) {
+	err := c.Replica.Ping(ctx)
+	up := err == nil
+	if c.replicaUp.Swap(up) != up {
+		if up {
+			logrus.Info("read replica is back, routing reads to it")
+		} else {
+			logrus.Warnf("read replica unavailable, falling back to primary: %s", err)
+		}
+	}
+}
+
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		if c.Replica != nil {
+			c.Replica.Close()
+		}
+		c.Primary.Close()
+	})
+}
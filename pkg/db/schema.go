@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// SchemaMode decides what the api and the worker do when the database isn't at
+// the SchemaVersion of the build, e.g. a binary rolled back after a migration.
+type SchemaMode string
+
+const (
+	// SchemaRefuse fails to start with a SchemaMismatchError
+	SchemaRefuse SchemaMode = "refuse"
+	// SchemaReadOnly starts with every transaction read only
+	SchemaReadOnly SchemaMode = "readonly"
+	// SchemaIgnore logs the mismatch and starts as usual
+	SchemaIgnore SchemaMode = "ignore"
+)
+
+var ErrSchemaMode = errors.New("unknown schema mode")
+
+func ParseSchemaMode(s string) (SchemaMode, error) {
+	switch m := SchemaMode(s); m {
+	case SchemaRefuse, SchemaReadOnly, SchemaIgnore:
+		return m, nil
+	case "":
+		return SchemaRefuse, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s, %s or %s", ErrSchemaMode, s, SchemaRefuse, SchemaReadOnly, SchemaIgnore)
+	}
+}
+
+// SchemaVersioner reads the version of the schema applied to the database.
+type SchemaVersioner interface {
+	GetSchemaVersion(ctx context.Context) (int32, error)
+}
+
+// SchemaMismatchError is a database at another version of schema.sql than the
+// build.
+type SchemaMismatchError struct {
+	Have, Want int32
+}
+
+func (e *SchemaMismatchError) Error() string {
+	if e.Have < e.Want {
+		return fmt.Sprintf("schema is at version %d, this build needs %d: apply schema.sql", e.Have, e.Want)
+	}
+	return fmt.Sprintf("schema is at version %d, newer than the %d of this build", e.Have, e.Want)
+}
+
+// CheckSchema fails unless the database is at version want of schema.sql, with
+// a SchemaMismatchError when it is at another one.
+func CheckSchema(ctx context.Context, q SchemaVersioner, want int32) error {
+	have, err := q.GetSchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version, is schema.sql applied? %w", err)
+	}
+	if have != want {
+		return &SchemaMismatchError{Have: have, Want: want}
+	}
+	return nil
+}
+
+// ConnectChecked connects like Connect, then checks the schema of the primary
+// against SchemaVersion and handles a mismatch by <section>.postgres.schema.mismatch.
+// in SchemaReadOnly mode the pools are opened again read only and the cluster
+// reports ReadOnly.
+func ConnectChecked(ctx context.Context, section string) (*Cluster, error) {
+	mode, err := ParseSchemaMode(viper.GetString(section + ".postgres.schema.mismatch"))
+	if err != nil {
+		return nil, err
+	}
+	c, err := Connect(ctx, section)
+	if err != nil {
+		return nil, err
+	}
+	err = CheckSchema(ctx, sqlc.New(c.Writer()), SchemaVersion)
+	var mismatch *SchemaMismatchError
+	if err == nil || (errors.As(err, &mismatch) && mode == SchemaIgnore) {
+		if err != nil {
+			logrus.Warnf("%s, starting anyway as %s.postgres.schema.mismatch is %s", err, section, mode)
+		}
+		return c, nil
+	}
+	c.Close()
+	if mismatch == nil || mode == SchemaRefuse {
+		return nil, err
+	}
+	logrus.Warnf("%s, starting read only", err)
+	return connect(ctx, section, true)
+}
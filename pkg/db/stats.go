@@ -0,0 +1,86 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	acquiredDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "acquired_conns"),
+		"number of currently acquired connections",
+		nil, nil,
+	)
+	idleDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "idle_conns"),
+		"number of currently idle connections",
+		nil, nil,
+	)
+	totalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "total_conns"),
+		"total number of connections in the pool",
+		nil, nil,
+	)
+	maxDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "max_conns"),
+		"maximum size of the pool",
+		nil, nil,
+	)
+	acquireCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "acquires_total"),
+		"cumulative count of successful acquires",
+		nil, nil,
+	)
+	emptyAcquireDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "empty_acquires_total"),
+		"cumulative count of acquires that had to wait for a connection",
+		nil, nil,
+	)
+	acquireWaitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "db_pool", "acquire_wait_seconds_total"),
+		"cumulative time spent waiting for a connection",
+		nil, nil,
+	)
+)
+
+// poolCollector exports pgxpool.Stat of a single pool.
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- acquiredDesc
+	ch <- idleDesc
+	ch <- totalDesc
+	ch <- maxDesc
+	ch <- acquireCountDesc
+	ch <- emptyAcquireDesc
+	ch <- acquireWaitDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(acquiredDesc, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(idleDesc, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(totalDesc, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(maxDesc, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(acquireCountDesc, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(emptyAcquireDesc, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(acquireWaitDesc, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
+
+// RegisterPoolMetrics exports the stats of pool labeled with the config section and role (primary/replica).
+func RegisterPoolMetrics(pool *pgxpool.Pool, section, role string) error {
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{
+		"section": section,
+		"role":    role,
+	}, prometheus.DefaultRegisterer)
+	err := reg.Register(poolCollector{pool: pool})
+	if are := (prometheus.AlreadyRegisteredError{}); errors.As(err, &are) {
+		return nil
+	}
+	return err
+}
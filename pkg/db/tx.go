@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/viper"
+)
+
+// postgres errors of a transaction that lost a race against a concurrent one.
+// they may succeed when run again.
+var retryableCodes = map[string]string{
+	"40001": "serialization_failure",
+	"40P01": "deadlock_detected",
+}
+
+// TxOptions configures the transactions of WithTx.
+type TxOptions struct {
+	// IsoLevel is the isolation level, the server's default when empty
+	IsoLevel pgx.TxIsoLevel
+	// MaxAttempts bounds how often a transaction is run, retries included
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled before every other
+	Backoff time.Duration
+}
+
+var txOptions atomic.Pointer[TxOptions]
+
+func init() {
+	txOptions.Store(&TxOptions{MaxAttempts: 3, Backoff: 10 * time.Millisecond})
+}
+
+// SetTxOptions configures the transactions of every later WithTx.
+func SetTxOptions(o TxOptions) {
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+	txOptions.Store(&o)
+}
+
+// TxOptionsFromViper reads <section>.postgres.tx: isolation (read committed,
+// repeatable read or serializable), maxattempts and backoff.
+func TxOptionsFromViper(section string) (TxOptions, error) {
+	prefix := section + ".postgres.tx."
+	o := TxOptions{
+		MaxAttempts: viper.GetInt(prefix + "maxattempts"),
+		Backoff:     viper.GetDuration(prefix + "backoff"),
+	}
+	switch level := pgx.TxIsoLevel(strings.ToLower(viper.GetString(prefix + "isolation"))); level {
+	case "", pgx.ReadCommitted, pgx.RepeatableRead, pgx.Serializable:
+		o.IsoLevel = level
+	default:
+		return o, fmt.Errorf("%sisolation: unknown isolation level %q", prefix, level)
+	}
+	return o, nil
+}
+
+// WithTx runs fn in a transaction on pool and commits it when fn returns nil. a
+// transaction that fails to serialize or is chosen as a deadlock victim is run
+// again from the start, so fn may run more than once: its effects outside of tx
+// must be safe to repeat.
+func WithTx(ctx context.Context, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) error {
+	o := txOptions.Load()
+	return retry(ctx, o, func() error {
+		return runTx(ctx, pool, o.IsoLevel, fn)
+	})
+}
+
+// retry calls run until it succeeds, fails for a reason not worth retrying or
+// was called o.MaxAttempts times.
+func retry(ctx context.Context, o *TxOptions, run func() error) error {
+	backoff := o.Backoff
+	for attempt := 1; ; attempt++ {
+		err := run()
+		reason, ok := retryable(err)
+		if !ok || attempt >= o.MaxAttempts {
+			return err
+		}
+		metrics.DBTxRetries.WithLabelValues(reason).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func runTx(ctx context.Context, pool *pgxpool.Pool, level pgx.TxIsoLevel, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: level})
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() {
+		// ctx may already be cancelled here, rollback must still reach the server
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		tx.Rollback(rctx)
+	}()
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether err is worth running the transaction again for, and why.
+func retryable(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	reason, ok := retryableCodes[pgErr.Code]
+	return reason, ok
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+)
+
+var _ = Describe("retryable", func() {
+	It("should retry serialization failures and deadlocks", func() {
+		reason, ok := retryable(&pgconn.PgError{Code: "40001"})
+		Expect(ok).To(BeTrue())
+		Expect(reason).To(Equal("serialization_failure"))
+
+		reason, ok = retryable(fmt.Errorf("failed to commit tx: %w", &pgconn.PgError{Code: "40P01"}))
+		Expect(ok).To(BeTrue())
+		Expect(reason).To(Equal("deadlock_detected"))
+	})
+
+	It("should not retry other postgres errors", func() {
+		_, ok := retryable(&pgconn.PgError{Code: "23505"})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should not retry errors that aren't postgres errors", func() {
+		_, ok := retryable(fmt.Errorf("failed to begin tx: %w", errors.New("connection refused")))
+		Expect(ok).To(BeFalse())
+		_, ok = retryable(nil)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("TxOptionsFromViper", func() {
+	AfterEach(func() {
+		viper.Reset()
+	})
+
+	It("should read the isolation level, attempts and backoff", func() {
+		viper.Set("worker.postgres.tx.isolation", "Serializable")
+		viper.Set("worker.postgres.tx.maxattempts", 5)
+		viper.Set("worker.postgres.tx.backoff", "20ms")
+		Expect(TxOptionsFromViper("worker")).To(Equal(TxOptions{
+			IsoLevel:    pgx.Serializable,
+			MaxAttempts: 5,
+			Backoff:     20 * time.Millisecond,
+		}))
+	})
+
+	It("should keep the server's isolation level when unset", func() {
+		o, err := TxOptionsFromViper("api")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.IsoLevel).To(BeEmpty())
+	})
+
+	It("should refuse an unknown isolation level", func() {
+		viper.Set("api.postgres.tx.isolation", "snapshot")
+		_, err := TxOptionsFromViper("api")
+		Expect(err).To(MatchError(ContainSubstring(`api.postgres.tx.isolation: unknown isolation level "snapshot"`)))
+	})
+})
+
+var _ = Describe("retry", func() {
+	o := &TxOptions{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	It("should run the transaction again after a serialization failure", func() {
+		retries := testutil.ToFloat64(metrics.DBTxRetries.WithLabelValues("serialization_failure"))
+		calls := 0
+		err := retry(context.Background(), o, func() error {
+			calls++
+			if calls == 1 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal(2))
+		Expect(testutil.ToFloat64(metrics.DBTxRetries.WithLabelValues("serialization_failure"))).To(Equal(retries + 1))
+	})
+
+	It("should stop after MaxAttempts", func() {
+		retries := testutil.ToFloat64(metrics.DBTxRetries.WithLabelValues("deadlock_detected"))
+		calls := 0
+		err := retry(context.Background(), o, func() error {
+			calls++
+			return &pgconn.PgError{Code: "40P01"}
+		})
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("40P01"))
+		Expect(calls).To(Equal(3))
+		Expect(testutil.ToFloat64(metrics.DBTxRetries.WithLabelValues("deadlock_detected"))).To(Equal(retries + 2))
+	})
+
+	It("should not retry other errors", func() {
+		calls := 0
+		err := retry(context.Background(), o, func() error {
+			calls++
+			return &pgconn.PgError{Code: "23505"}
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should stop waiting for a retry when ctx is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := retry(ctx, &TxOptions{MaxAttempts: 3, Backoff: time.Hour}, func() error {
+			calls++
+			return &pgconn.PgError{Code: "40001"}
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+})
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Message is a plain text email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email through a provider.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromViper builds the driver selected by email.driver: "log" (default), "smtp" or "ses".
+func FromViper(ctx context.Context) (Sender, error) {
+	switch driver := viper.GetString("email.driver"); driver {
+	case "", "log":
+		return Log{}, nil
+	case "smtp":
+		password, err := secrets.Get(ctx, "email.smtp.password")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email.smtp.password: %w", err)
+		}
+		return NewSMTP(SMTPConfig{
+			Address:  viper.GetString("email.smtp.address"),
+			Username: viper.GetString("email.smtp.username"),
+			Password: password,
+		})
+	case "ses":
+		accessKey, err := secrets.Get(ctx, "email.ses.accesskey")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email.ses.accesskey: %w", err)
+		}
+		secretKey, err := secrets.Get(ctx, "email.ses.secretkey")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read email.ses.secretkey: %w", err)
+		}
+		return NewSES(SESConfig{
+			Endpoint:  viper.GetString("email.ses.endpoint"),
+			Region:    viper.GetString("email.ses.region"),
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown email driver %q", driver)
+	}
+}
+
+// Log only logs the envelope of every message. it is meant for development and never fails.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	logrus.WithFields(logrus.Fields{
+		"from":    msg.From,
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Info("email not sent, email.driver is log")
+	return nil
+}
@@ -0,0 +1,137 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRelay is a plain text SMTP server that accepts one message per connection.
+type fakeRelay struct {
+	ln   net.Listener
+	rcpt chan string
+	data chan string
+}
+
+func newFakeRelay() *fakeRelay {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	r := &fakeRelay{ln: ln, rcpt: make(chan string, 1), data: make(chan string, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRelay) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake relay")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 fake relay")
+		case "RCPT":
+			r.rcpt <- line
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, _ := io.ReadAll(tp.DotReader())
+			r.data <- string(b)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+var _ = Describe("Email", func() {
+	msg := Message{
+		From:    "otp@example.com",
+		To:      "user@example.com",
+		Subject: "Your code",
+		Body:    "code: 1234",
+	}
+
+	Context("SMTP", func() {
+		var relay *fakeRelay
+
+		BeforeEach(func() {
+			relay = newFakeRelay()
+			DeferCleanup(relay.ln.Close)
+		})
+
+		It("sends the message through the relay", func() {
+			s, err := NewSMTP(SMTPConfig{Address: relay.ln.Addr().String()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.Send(context.Background(), msg)).To(Succeed())
+			Expect(<-relay.rcpt).To(ContainSubstring("<user@example.com>"))
+			data := <-relay.data
+			Expect(data).To(ContainSubstring("Subject: Your code\n"))
+			Expect(data).To(HaveSuffix("\ncode: 1234\n"))
+		})
+
+		It("doesn't send credentials without TLS", func() {
+			s, err := NewSMTP(SMTPConfig{Address: relay.ln.Addr().String(), Username: "u", Password: "p"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.Send(context.Background(), msg)).To(MatchError(ContainSubstring("STARTTLS")))
+		})
+	})
+
+	Context("SES", func() {
+		It("posts a signed SendEmail request", func() {
+			var (
+				auth string
+				body sesRequest
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.URL.Path).To(Equal(sesPath))
+				auth = r.Header.Get("Authorization")
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				w.Write([]byte(`{"MessageId":"1"}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			s, err := NewSES(SESConfig{Endpoint: srv.URL, Region: "eu-west-1", AccessKey: "AKID", SecretKey: "secret"})
+			Expect(err).NotTo(HaveOccurred())
+			s.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+			Expect(s.Send(context.Background(), msg)).To(Succeed())
+			Expect(auth).To(HavePrefix("AWS4-HMAC-SHA256 Credential=AKID/20250102/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="))
+			Expect(body.Destination.ToAddresses).To(Equal([]string{"user@example.com"}))
+			Expect(body.Content.Simple.Body.Text.Data).To(Equal("code: 1234"))
+		})
+
+		It("returns the error of rejected requests", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"message":"Email address is not verified."}`, http.StatusBadRequest)
+			}))
+			DeferCleanup(srv.Close)
+			s, err := NewSES(SESConfig{Endpoint: srv.URL})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.Send(context.Background(), msg)).To(MatchError(ContainSubstring("not verified")))
+		})
+	})
+})
@@ -0,0 +1,157 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sesService    = "ses"
+	sesPath       = "/v2/email/outbound-emails"
+	sigAlgorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+)
+
+type SESConfig struct {
+	// Endpoint defaults to https://email.<region>.amazonaws.com
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// SES sends email through the Amazon SES v2 API, signing requests with AWS Signature Version 4.
+type SES struct {
+	conf     SESConfig
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewSES(conf SESConfig) (*SES, error) {
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+	if conf.Endpoint == "" {
+		conf.Endpoint = "https://email." + conf.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ses endpoint %q", conf.Endpoint)
+	}
+	return &SES{
+		conf:     conf,
+		endpoint: u,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+type sesRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Simple struct {
+			Subject sesContent `json:"Subject"`
+			Body    struct {
+				Text sesContent `json:"Text"`
+			} `json:"Body"`
+		} `json:"Simple"`
+	} `json:"Content"`
+}
+
+func (s *SES) Send(ctx context.Context, msg Message) error {
+	var body sesRequest
+	body.FromEmailAddress = msg.From
+	body.Destination.ToAddresses = []string{msg.To}
+	body.Content.Simple.Subject = sesContent{Data: msg.Subject, Charset: "UTF-8"}
+	body.Content.Simple.Body.Text = sesContent{Data: msg.Body, Charset: "UTF-8"}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + sesPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, payload)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	errMsg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("ses: %s: %s", res.Status, bytes.TrimSpace(errMsg))
+}
+
+// sign sets the Authorization header of req. the signed headers are fixed: content-type, host and x-amz-date.
+func (s *SES) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	headers := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-date:" + req.Header.Get("X-Amz-Date") + "\n"
+	signed := "content-type;host;x-amz-date"
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		headers,
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.conf.Region + "/" + sesService + "/aws4_request"
+	creqSum := sha256.Sum256([]byte(creq))
+	toSign := strings.Join([]string{
+		sigAlgorithm,
+		now.Format(amzDateFormat),
+		scope,
+		hex.EncodeToString(creqSum[:]),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.conf.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.conf.Region)
+	key = hmacSHA256(key, sesService)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, s.conf.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
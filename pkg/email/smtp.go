@@ -0,0 +1,106 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+type SMTPConfig struct {
+	// Address is the host:port of the relay, e.g. smtp.example.com:587
+	Address  string
+	Username string
+	Password string
+}
+
+// SMTP sends email through a relay, upgrading the connection with STARTTLS when
+// the relay offers it. credentials are only sent over TLS.
+type SMTP struct {
+	conf SMTPConfig
+	host string
+	now  func() time.Time
+}
+
+func NewSMTP(conf SMTPConfig) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address %q: %w", conf.Address, err)
+	}
+	return &SMTP{conf: conf, host: host, now: time.Now}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.conf.Address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: s.host})
+		if err != nil {
+			return err
+		}
+	}
+	if s.conf.Username != "" {
+		if _, ok := c.TLSConnectionState(); !ok {
+			return errors.New("smtp relay doesn't support STARTTLS, refusing to send credentials")
+		}
+		err = c.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.host))
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(msg.From)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(s.format(msg))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format renders msg as an RFC 5322 message with a UTF-8 plain text body.
+func (s *SMTP) format(msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,164 @@
+package gsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// information elements of the user data header announcing a concatenated message
+const (
+	ieConcat8  = 0x00
+	ieConcat16 = 0x08
+)
+
+var ErrUDH = errors.New("malformed user data header")
+
+// Concat is the concatenation element of the user data header of one part of a
+// long message. the zero value is a message that came in one piece.
+type Concat struct {
+	// Ref is the same in every part of one message of a sender
+	Ref uint16
+	// Total is the number of parts and Seq the number of this one, from 1
+	Total, Seq int
+}
+
+// SplitUDH splits the user data of a message sent with the UDHI flag into its
+// concatenation element and the data after the header. headers without one give
+// the zero Concat.
+func SplitUDH(ud []byte) (Concat, []byte, error) {
+	if len(ud) == 0 || int(ud[0])+1 > len(ud) {
+		return Concat{}, nil, ErrUDH
+	}
+	header, data := ud[1:ud[0]+1], ud[ud[0]+1:]
+	var c Concat
+	for len(header) > 0 {
+		if len(header) < 2 || int(header[1])+2 > len(header) {
+			return Concat{}, nil, ErrUDH
+		}
+		id, value := header[0], header[2:header[1]+2]
+		switch {
+		case id == ieConcat8 && len(value) == 3:
+			c = Concat{Ref: uint16(value[0]), Total: int(value[1]), Seq: int(value[2])}
+		case id == ieConcat16 && len(value) == 4:
+			c = Concat{Ref: uint16(value[0])<<8 | uint16(value[1]), Total: int(value[2]), Seq: int(value[3])}
+		}
+		header = header[header[1]+2:]
+	}
+	if c.Total > 0 && (c.Seq < 1 || c.Seq > c.Total) {
+		return Concat{}, nil, fmt.Errorf("%w: part %d of %d", ErrUDH, c.Seq, c.Total)
+	}
+	return c, data, nil
+}
+
+// Message is a reassembled message.
+type Message struct {
+	From string
+	To   string
+	Text string
+	// Parts is the number of parts the message was sent in
+	Parts int
+	// Missing lists the parts that never arrived, in order. their text is left
+	// out of Text
+	Missing []int
+	// FirstAt is when the first part arrived
+	FirstAt time.Time
+}
+
+// Incomplete reports whether parts of m are missing.
+func (m Message) Incomplete() bool {
+	return len(m.Missing) > 0
+}
+
+type partsKey struct {
+	from, to string
+	ref      uint16
+	total    int
+}
+
+type pending struct {
+	parts   map[int]string
+	firstAt time.Time
+}
+
+// Reassembler joins the parts of long messages. parts are keyed by sender,
+// recipient, reference and number of parts, so one sender reusing a reference
+// for another recipient doesn't mix the messages up. it is safe for concurrent use.
+type Reassembler struct {
+	// Timeout is how long the parts of a message are waited for after the first
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[partsKey]*pending
+}
+
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{Timeout: timeout, pending: make(map[partsKey]*pending)}
+}
+
+// Add adds a part received at now. it reports the message once its last part
+// arrived; messages in one piece are complete right away. a part received twice
+// replaces the first.
+func (r *Reassembler) Add(from, to string, c Concat, text string, now time.Time) (Message, bool) {
+	if c.Total <= 1 {
+		return Message{From: from, To: to, Text: text, Parts: 1, FirstAt: now}, true
+	}
+	key := partsKey{from: from, to: to, ref: c.Ref, total: c.Total}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pending[key]
+	if !ok {
+		p = &pending{parts: make(map[int]string, c.Total), firstAt: now}
+		r.pending[key] = p
+	}
+	p.parts[c.Seq] = text
+	if len(p.parts) < c.Total {
+		return Message{}, false
+	}
+	delete(r.pending, key)
+	return p.message(key), true
+}
+
+// Expire gives up on the messages whose first part arrived more than Timeout
+// before now, returning them with their missing parts, oldest first.
+func (r *Reassembler) Expire(now time.Time) []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []Message
+	for key, p := range r.pending {
+		if now.Sub(p.firstAt) <= r.Timeout {
+			continue
+		}
+		delete(r.pending, key)
+		expired = append(expired, p.message(key))
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].FirstAt.Before(expired[j].FirstAt)
+	})
+	return expired
+}
+
+// Pending is the number of messages waiting for parts.
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+func (p *pending) message(key partsKey) Message {
+	m := Message{From: key.from, To: key.to, Parts: key.total, FirstAt: p.firstAt}
+	var text strings.Builder
+	for seq := 1; seq <= key.total; seq++ {
+		part, ok := p.parts[seq]
+		if !ok {
+			m.Missing = append(m.Missing, seq)
+			continue
+		}
+		text.WriteString(part)
+	}
+	m.Text = text.String()
+	return m
+}
@@ -0,0 +1,88 @@
+package gsm_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/gsm"
+)
+
+var _ = Describe("Concat", func() {
+	It("should split the 8 and 16 bit concatenation elements off the data", func() {
+		c, data, err := SplitUDH([]byte{0x05, 0x00, 0x03, 0x2a, 0x03, 0x02, 'h', 'i'})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(Concat{Ref: 0x2a, Total: 3, Seq: 2}))
+		Expect(string(data)).To(Equal("hi"))
+
+		c, data, err = SplitUDH([]byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x02, 0x01, 'y', 'o'})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(Concat{Ref: 0x0102, Total: 2, Seq: 1}))
+		Expect(string(data)).To(Equal("yo"))
+	})
+
+	It("should skip other elements", func() {
+		c, data, err := SplitUDH([]byte{0x04, 0x24, 0x02, 0x01, 0x01, 'x'})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(BeZero())
+		Expect(string(data)).To(Equal("x"))
+	})
+
+	It("should refuse malformed headers", func() {
+		_, _, err := SplitUDH([]byte{0x05, 0x00, 0x03})
+		Expect(err).To(MatchError(ErrUDH))
+		_, _, err = SplitUDH([]byte{0x05, 0x00, 0x03, 0x2a, 0x02, 0x03})
+		Expect(err).To(MatchError(ErrUDH))
+	})
+
+	Context("Reassembler", func() {
+		var (
+			r   *Reassembler
+			now time.Time
+		)
+
+		BeforeEach(func() {
+			r = NewReassembler(time.Minute)
+			now = time.Unix(1700000000, 0)
+		})
+
+		It("should join the parts in order once all arrived", func() {
+			_, ok := r.Add("+4412", "+9812", Concat{Ref: 7, Total: 3, Seq: 3}, "c", now)
+			Expect(ok).To(BeFalse())
+			_, ok = r.Add("+4412", "+9812", Concat{Ref: 7, Total: 3, Seq: 1}, "a", now)
+			Expect(ok).To(BeFalse())
+			// another sender with the same reference
+			_, ok = r.Add("+4499", "+9812", Concat{Ref: 7, Total: 3, Seq: 2}, "x", now)
+			Expect(ok).To(BeFalse())
+
+			m, ok := r.Add("+4412", "+9812", Concat{Ref: 7, Total: 3, Seq: 2}, "b", now.Add(time.Second))
+			Expect(ok).To(BeTrue())
+			Expect(m.Text).To(Equal("abc"))
+			Expect(m.Parts).To(Equal(3))
+			Expect(m.FirstAt).To(Equal(now))
+			Expect(m.Incomplete()).To(BeFalse())
+			Expect(r.Pending()).To(Equal(1))
+		})
+
+		It("should pass messages in one piece through", func() {
+			m, ok := r.Add("+4412", "+9812", Concat{}, "short", now)
+			Expect(ok).To(BeTrue())
+			Expect(m.Text).To(Equal("short"))
+			Expect(r.Pending()).To(BeZero())
+		})
+
+		It("should give up on missing parts after the timeout", func() {
+			r.Add("+4412", "+9812", Concat{Ref: 1, Total: 3, Seq: 1}, "a", now)
+			r.Add("+4412", "+9812", Concat{Ref: 2, Total: 2, Seq: 2}, "z", now.Add(30*time.Second))
+			Expect(r.Expire(now.Add(time.Minute))).To(BeEmpty())
+
+			expired := r.Expire(now.Add(time.Minute + time.Second))
+			Expect(expired).To(HaveLen(1))
+			Expect(expired[0].Text).To(Equal("a"))
+			Expect(expired[0].Missing).To(Equal([]int{2, 3}))
+			Expect(expired[0].Incomplete()).To(BeTrue())
+			Expect(r.Pending()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package gsm
+
+import "unicode/utf16"
+
+const (
+	singleGSM7 = 160
+	multiGSM7  = 153
+	singleUCS2 = 70
+	multiUCS2  = 67
+)
+
+// basic is the GSM 03.38 default alphabet.
+const basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// extension characters take two septets (escape + char).
+const extension = "^{}\\[~]|€\f"
+
+var septets = func() map[rune]int {
+	m := make(map[rune]int)
+	for _, r := range basic {
+		m[r] = 1
+	}
+	for _, r := range extension {
+		m[r] = 2
+	}
+	return m
+}()
+
+// IsGSM7 reports whether text can be encoded with the GSM 7-bit alphabet.
+func IsGSM7(text string) bool {
+	for _, r := range text {
+		if _, ok := septets[r]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Length returns the number of encoding units text occupies: septets for
+// GSM 7-bit text, UTF-16 code units otherwise.
+func Length(text string) int {
+	if !IsGSM7(text) {
+		return len(utf16.Encode([]rune(text)))
+	}
+	n := 0
+	for _, r := range text {
+		n += septets[r]
+	}
+	return n
+}
+
+// Segments returns how many SMS parts text is split into.
+func Segments(text string) int {
+	n := Length(text)
+	single, multi := singleGSM7, multiGSM7
+	if !IsGSM7(text) {
+		single, multi = singleUCS2, multiUCS2
+	}
+	if n <= single {
+		return 1
+	}
+	return (n + multi - 1) / multi
+}
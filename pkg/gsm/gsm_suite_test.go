@@ -0,0 +1,13 @@
+package gsm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestGsm(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gsm Suite")
+}
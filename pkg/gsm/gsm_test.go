@@ -0,0 +1,30 @@
+package gsm_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/gsm"
+)
+
+var _ = Describe("Gsm", func() {
+	It("should fit 160 GSM characters in one segment", func() {
+		Expect(Segments(strings.Repeat("a", 160))).To(Equal(1))
+		Expect(Segments(strings.Repeat("a", 161))).To(Equal(2))
+		Expect(Segments(strings.Repeat("a", 306))).To(Equal(2))
+		Expect(Segments(strings.Repeat("a", 307))).To(Equal(3))
+	})
+
+	It("should count extension characters twice", func() {
+		Expect(Length("€")).To(Equal(2))
+		Expect(Segments(strings.Repeat("€", 81))).To(Equal(2))
+	})
+
+	It("should switch to UCS-2 for other characters", func() {
+		Expect(IsGSM7("سلام")).To(BeFalse())
+		Expect(Segments(strings.Repeat("س", 70))).To(Equal(1))
+		Expect(Segments(strings.Repeat("س", 71))).To(Equal(2))
+	})
+})
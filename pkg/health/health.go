@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// checkTimeout bounds every readiness check
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Checker answers liveness and readiness probes. the process is live as long
+// as it answers; it is ready while every check passes and it isn't draining.
+type Checker struct {
+	mu       sync.RWMutex
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+func New() *Checker {
+	return &Checker{
+		checks: make(map[string]Check),
+	}
+}
+
+// Add registers check under name, replacing any check with the same name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Drain makes readiness fail from now on, so load balancers stop sending
+// traffic while the process shuts down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready runs every check concurrently and returns the errors by check name.
+func (c *Checker) Ready(ctx context.Context) map[string]error {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			if err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// NATS fails while nc isn't connected, e.g. while it is reconnecting.
+func NATS(nc *nats.Conn) Check {
+	return func(ctx context.Context) error {
+		if status := nc.Status(); status != nats.CONNECTED {
+			return fmt.Errorf("nats connection is %s", status)
+		}
+		return nil
+	}
+}
+
+// LiveHandler always answers 200.
+func (c *Checker) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, response{Status: "ok"})
+}
+
+// ReadyHandler answers 200 when the process is ready and 503 with the failing checks otherwise.
+func (c *Checker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		write(w, http.StatusServiceUnavailable, response{Status: "draining"})
+		return
+	}
+	errs := c.Ready(r.Context())
+	if len(errs) == 0 {
+		write(w, http.StatusOK, response{Status: "ok"})
+		return
+	}
+	res := response{Status: "unavailable", Checks: make(map[string]string, len(errs))}
+	for name, err := range errs {
+		res.Checks[name] = err.Error()
+	}
+	write(w, http.StatusServiceUnavailable, res)
+}
+
+// Register serves /healthz and /readyz on mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.LiveHandler)
+	mux.HandleFunc("/readyz", c.ReadyHandler)
+}
+
+func write(w http.ResponseWriter, code int, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
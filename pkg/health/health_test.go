@@ -0,0 +1,79 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/nats-io/nats.go"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		checker *health.Checker
+		mux     *http.ServeMux
+	)
+
+	get := func(path string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body := map[string]any{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+		return rec.Code, body
+	}
+
+	BeforeEach(func() {
+		checker = health.New()
+		mux = http.NewServeMux()
+		checker.Register(mux)
+		checker.Add("postgres", func(context.Context) error { return nil })
+	})
+
+	It("is live and ready while every check passes", func() {
+		code, _ := get("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		code, body := get("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body["status"]).To(Equal("ok"))
+	})
+
+	It("isn't ready while a check fails", func() {
+		checker.Add("nats", func(context.Context) error { return errors.New("disconnected") })
+		code, body := get("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["checks"]).To(Equal(map[string]any{"nats": "disconnected"}))
+
+		code, _ = get("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("gives up on slow checks", func() {
+		checker.Add("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(checker.Ready(ctx)).To(HaveKey("slow"))
+	})
+
+	It("isn't ready while draining", func() {
+		checker.Drain()
+		code, body := get("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["status"]).To(Equal("draining"))
+	})
+
+	It("fails the NATS check while disconnected", func() {
+		nc, err := nats.Connect("nats://127.0.0.1:1", nats.RetryOnFailedConnect(true), nats.NoReconnect())
+		Expect(err).NotTo(HaveOccurred())
+		defer nc.Close()
+		Expect(health.NATS(nc)(context.Background())).To(MatchError(ContainSubstring("nats connection is")))
+	})
+})
@@ -0,0 +1,169 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Probe is the last outcome of a provider's check.
+type Probe struct {
+	Healthy   bool
+	Latency   time.Duration
+	Error     string
+	CheckedAt time.Time
+	// Uptime is the share of healthy runs among the last ones the prober keeps
+	Uptime float64
+}
+
+type probeHistory struct {
+	last Probe
+	// runs holds the outcome of the latest runs, oldest first
+	runs []bool
+}
+
+// Prober checks external providers in the background, e.g. with test lookups or
+// their health endpoints, and keeps the latest outcomes for a status page. unlike
+// readiness checks, failing probes don't take the process out of service.
+type Prober struct {
+	timeout time.Duration
+	history int
+	now     func() time.Time
+
+	mu      sync.RWMutex
+	checks  map[string]Check
+	results map[string]*probeHistory
+}
+
+// NewProber bounds every check by timeout and computes the uptime over the last history runs.
+func NewProber(timeout time.Duration, history int) *Prober {
+	if timeout <= 0 {
+		timeout = checkTimeout
+	}
+	if history <= 0 {
+		history = 1
+	}
+	return &Prober{
+		timeout: timeout,
+		history: history,
+		now:     time.Now,
+		checks:  make(map[string]Check),
+		results: make(map[string]*probeHistory),
+	}
+}
+
+// Add registers check under name, replacing any check with the same name.
+func (p *Prober) Add(name string, check Check) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks[name] = check
+}
+
+// Run runs every check once, concurrently.
+func (p *Prober) Run(ctx context.Context) {
+	p.mu.RLock()
+	checks := make(map[string]Check, len(p.checks))
+	for name, check := range p.checks {
+		checks[name] = check
+	}
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+			start := p.now()
+			err := check(cctx)
+			p.record(name, start, p.now().Sub(start), err)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Prober) record(name string, at time.Time, latency time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.results[name]
+	if !ok {
+		h = &probeHistory{last: Probe{Healthy: true}}
+		p.results[name] = h
+	}
+	// log transitions only, a provider that is down would flood the logs every interval
+	if h.last.Healthy && err != nil {
+		logrus.Warnf("provider %s failed its probe: %s", name, err)
+	} else if !h.last.Healthy && err == nil {
+		logrus.Infof("provider %s passes its probe again", name)
+	}
+	h.runs = append(h.runs, err == nil)
+	if len(h.runs) > p.history {
+		h.runs = h.runs[len(h.runs)-p.history:]
+	}
+	healthy := 0
+	for _, ok := range h.runs {
+		if ok {
+			healthy++
+		}
+	}
+	h.last = Probe{
+		Healthy:   err == nil,
+		Latency:   latency,
+		CheckedAt: at.UTC(),
+		Uptime:    float64(healthy) / float64(len(h.runs)),
+	}
+	if err != nil {
+		h.last.Error = err.Error()
+	}
+}
+
+// Start runs the checks every interval until ctx is done.
+func (p *Prober) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.Run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Results returns the last probe of every provider that was checked at least once.
+func (p *Prober) Results() map[string]Probe {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make(map[string]Probe, len(p.results))
+	for name, h := range p.results {
+		res[name] = h.last
+	}
+	return res
+}
+
+// HTTP fails unless url answers a GET with 2xx.
+func HTTP(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("health endpoint answered %s", res.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/health"
+)
+
+var _ = Describe("Prober", func() {
+	var prober *health.Prober
+
+	BeforeEach(func() {
+		prober = health.NewProber(50*time.Millisecond, 4)
+	})
+
+	It("keeps the last outcome and the uptime of every provider", func() {
+		var fail bool
+		prober.Add("hlr", func(context.Context) error { return nil })
+		prober.Add("email", func(context.Context) error {
+			if fail {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		Expect(prober.Results()).To(BeEmpty())
+
+		prober.Run(context.Background())
+		fail = true
+		prober.Run(context.Background())
+
+		res := prober.Results()
+		Expect(res["hlr"].Healthy).To(BeTrue())
+		Expect(res["hlr"].Uptime).To(Equal(1.0))
+		Expect(res["email"].Healthy).To(BeFalse())
+		Expect(res["email"].Error).To(Equal("connection refused"))
+		Expect(res["email"].Uptime).To(Equal(0.5))
+		Expect(res["email"].CheckedAt).NotTo(BeZero())
+	})
+
+	It("computes the uptime over the last runs only", func() {
+		var fail bool
+		prober.Add("voice", func(context.Context) error {
+			if fail {
+				return errors.New("down")
+			}
+			return nil
+		})
+		fail = true
+		prober.Run(context.Background())
+		fail = false
+		for range 4 {
+			prober.Run(context.Background())
+		}
+		Expect(prober.Results()["voice"].Uptime).To(Equal(1.0))
+	})
+
+	It("gives up on slow checks", func() {
+		prober.Add("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		prober.Run(context.Background())
+		Expect(prober.Results()["slow"].Error).To(Equal(context.DeadlineExceeded.Error()))
+	})
+
+	It("probes health endpoints over http", func() {
+		code := http.StatusOK
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		defer srv.Close()
+		check := health.HTTP(srv.Client(), srv.URL)
+
+		Expect(check(context.Background())).To(Succeed())
+		code = http.StatusServiceUnavailable
+		Expect(check(context.Background())).To(MatchError(ContainSubstring("503")))
+	})
+})
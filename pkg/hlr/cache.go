@@ -0,0 +1,61 @@
+package hlr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+)
+
+type cacheEntry struct {
+	res     *Result
+	expires time.Time
+}
+
+// Cache remembers lookup results of the wrapped provider for ttl.
+// failed lookups are not cached.
+type Cache struct {
+	Provider
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCache(p Provider, ttl time.Duration) *Cache {
+	return &Cache{
+		Provider: p,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+func (c *Cache) Lookup(ctx context.Context, number string) (*Result, error) {
+	key := phone.Normalize(number)
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.res, nil
+	}
+
+	res, err := c.Provider.Lookup(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{res: res, expires: now.Add(c.ttl)}
+	// drop expired entries once in a while so the map doesn't grow forever
+	if len(c.entries)%1024 == 0 {
+		for k, v := range c.entries {
+			if now.After(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package hlr_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/hlr"
+)
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Lookup(ctx context.Context, number string) (*Result, error) {
+	p.calls++
+	return NewSimulator().Lookup(ctx, number)
+}
+
+var _ = Describe("Cache", func() {
+	It("should serve repeated lookups from the cache", func() {
+		p := &countingProvider{}
+		c := NewCache(p, time.Minute)
+		first, err := c.Lookup(context.Background(), "+98 912 123 4567")
+		Expect(err).NotTo(HaveOccurred())
+		second, err := c.Lookup(context.Background(), "+989121234567")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+		Expect(p.calls).To(Equal(1))
+	})
+
+	It("should look up again after the ttl", func() {
+		p := &countingProvider{}
+		c := NewCache(p, time.Nanosecond)
+		_, err := c.Lookup(context.Background(), "+989121234567")
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(time.Millisecond)
+		_, err = c.Lookup(context.Background(), "+989121234567")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.calls).To(Equal(2))
+	})
+
+	It("should not cache failures", func() {
+		p := &countingProvider{}
+		c := NewCache(p, time.Minute)
+		_, err := c.Lookup(context.Background(), "123")
+		Expect(err).To(MatchError(ErrInvalidNumber))
+		_, err = c.Lookup(context.Background(), "123")
+		Expect(err).To(MatchError(ErrInvalidNumber))
+		Expect(p.calls).To(Equal(2))
+	})
+})
+
+var _ = Describe("Simulator", func() {
+	It("should report numbers ending in 0 as unreachable", func() {
+		res, err := NewSimulator().Lookup(context.Background(), "+989121234560")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Reachable).To(BeFalse())
+		Expect(res.Country).To(Equal("98"))
+	})
+})
@@ -0,0 +1,37 @@
+package hlr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidNumber = errors.New("invalid phone number")
+)
+
+// Result is what a home location register knows about a subscriber.
+type Result struct {
+	Number    string    `json:"number"`
+	Country   string    `json:"country"`
+	Carrier   string    `json:"carrier"`
+	Ported    bool      `json:"ported"`
+	Reachable bool      `json:"reachable"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Provider performs number lookups against an HLR service.
+type Provider interface {
+	Lookup(ctx context.Context, number string) (*Result, error)
+}
+
+// New returns the provider registered under name.
+func New(name string) (Provider, error) {
+	switch name {
+	case "", "simulator":
+		return NewSimulator(), nil
+	default:
+		return nil, fmt.Errorf("unknown hlr provider %q", name)
+	}
+}
@@ -0,0 +1,13 @@
+package hlr_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHlr(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hlr Suite")
+}
@@ -0,0 +1,38 @@
+package hlr
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/phone"
+)
+
+var simulatedCarriers = []string{"Carrier A", "Carrier B", "Carrier C"}
+
+// Simulator answers lookups deterministically from the number itself.
+// it is meant for development and tests where no HLR service is available.
+// numbers ending in 0 are reported unreachable and numbers ending in 9 as ported.
+type Simulator struct{}
+
+func NewSimulator() *Simulator {
+	return &Simulator{}
+}
+
+func (s *Simulator) Lookup(ctx context.Context, number string) (*Result, error) {
+	digits := phone.Normalize(number)
+	if len(digits) < 6 {
+		return nil, ErrInvalidNumber
+	}
+	h := fnv.New32a()
+	h.Write([]byte(digits))
+	last := digits[len(digits)-1]
+	return &Result{
+		Number:    "+" + digits,
+		Country:   phone.CountryCode(digits),
+		Carrier:   simulatedCarriers[h.Sum32()%uint32(len(simulatedCarriers))],
+		Ported:    last == '9',
+		Reachable: last != '0',
+		CheckedAt: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,54 @@
+// Package ids makes the public ids of messages. unlike the serial ids of the
+// database they don't tell how many messages the gateway sends, and ids made
+// in different regions never collide.
+package ids
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// Generator makes ids.
+type Generator interface {
+	New() (uuid.UUID, error)
+}
+
+// GeneratorFunc is a func used as a Generator.
+type GeneratorFunc func() (uuid.UUID, error)
+
+func (f GeneratorFunc) New() (uuid.UUID, error) {
+	return f()
+}
+
+var (
+	// UUIDv7 ids start with the millisecond they were made in, so they sort by
+	// time and keep the indexes on them compact
+	UUIDv7 Generator = GeneratorFunc(uuid.NewV7)
+	// UUIDv4 ids are random, they don't even tell when a message was sent
+	UUIDv4 Generator = GeneratorFunc(uuid.NewRandom)
+)
+
+// FromViper is the generator sms.ids.generator names, "uuidv7" or "uuidv4".
+// defaults to UUIDv7.
+func FromViper() (Generator, error) {
+	switch name := viper.GetString("sms.ids.generator"); name {
+	case "", "uuidv7":
+		return UUIDv7, nil
+	case "uuidv4":
+		return UUIDv4, nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", name)
+	}
+}
+
+// Time is when a UUIDv7 id was made, false for other versions.
+func Time(id uuid.UUID) (time.Time, bool) {
+	if id.Version() != 7 {
+		return time.Time{}, false
+	}
+	sec, nsec := id.Time().UnixTime()
+	return time.Unix(sec, nsec), true
+}
@@ -0,0 +1,54 @@
+package ids_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/ids"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Ids", func() {
+	It("makes UUIDv7 ids that sort by the time they were made", func() {
+		first, err := ids.UUIDv7.New()
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(2 * time.Millisecond)
+		second, err := ids.UUIDv7.New()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Version()).To(BeEquivalentTo(7))
+		Expect(first.String() < second.String()).To(BeTrue())
+
+		made, ok := ids.Time(first)
+		Expect(ok).To(BeTrue())
+		Expect(made).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("has no time for random ids", func() {
+		id, err := ids.UUIDv4.New()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id.Version()).To(BeEquivalentTo(4))
+		_, ok := ids.Time(id)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("picks the generator of the config", func() {
+		DeferCleanup(viper.Set, "sms.ids.generator", "")
+
+		g, err := ids.FromViper()
+		Expect(err).NotTo(HaveOccurred())
+		id, _ := g.New()
+		Expect(id.Version()).To(BeEquivalentTo(7))
+
+		viper.Set("sms.ids.generator", "uuidv4")
+		g, err = ids.FromViper()
+		Expect(err).NotTo(HaveOccurred())
+		id, _ = g.New()
+		Expect(id.Version()).To(BeEquivalentTo(4))
+
+		viper.Set("sms.ids.generator", "snowflake")
+		_, err = ids.FromViper()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,41 @@
+// Package mask redacts the personal data of sms, their destination numbers and
+// bodies, for logs and for callers who may not see them in full.
+package mask
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Dot replaces every masked character of a number.
+const Dot = "•"
+
+// Phone masks number but for its country code and its last digits, e.g.
+// +491701231234 is +49••••••1234. short numbers only keep their last two.
+func Phone(number string) string {
+	r := []rune(number)
+	head, tail := 0, 4
+	if len(r) >= 10 {
+		head = 2
+		if r[0] == '+' {
+			head = 3
+		}
+	}
+	if len(r) < 8 {
+		tail = 2
+	}
+	if head+tail >= len(r) {
+		head, tail = 0, len(r)/2
+	}
+	return string(r[:head]) + strings.Repeat(Dot, len(r)-head-tail) + string(r[len(r)-tail:])
+}
+
+// Body replaces body with a note of its length, which is all that is left to
+// tell messages apart, e.g. [redacted 42 chars].
+func Body(body string) string {
+	if body == "" {
+		return ""
+	}
+	return fmt.Sprintf("[redacted %d chars]", utf8.RuneCountInString(body))
+}
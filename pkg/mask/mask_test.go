@@ -0,0 +1,27 @@
+package mask_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/mask"
+)
+
+var _ = Describe("Mask", func() {
+	DescribeTable("numbers keep their country code and last digits",
+		func(number, masked string) {
+			Expect(mask.Phone(number)).To(Equal(masked))
+		},
+		Entry("e164", "+491701231234", "+49••••••1234"),
+		Entry("national", "09121234567", "09•••••4567"),
+		Entry("short code", "30001", "•••01"),
+		Entry("tiny", "12", "•2"),
+		Entry("empty", "", ""),
+	)
+
+	It("should only keep the length of bodies", func() {
+		Expect(mask.Body("your code is 1234")).To(Equal("[redacted 17 chars]"))
+		Expect(mask.Body("سلام")).To(Equal("[redacted 4 chars]"))
+		Expect(mask.Body("")).To(BeEmpty())
+	})
+})
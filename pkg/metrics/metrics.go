@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	Namespace = "sms"
+)
+
+// the labels of the metrics about single sms, named alike so dashboards can
+// filter and join them the same way
+const (
+	// LabelOrg is the id of the user who sent the sms, see OrgLabels
+	LabelOrg = "org"
+	// LabelPriority is the priority of the sms, normal or express
+	LabelPriority = "priority"
+	// LabelProvider is the provider that submitted the sms, empty before that
+	LabelProvider = "provider"
+)
+
+// OrgLabels fills the org label in, see metrics.labels.org. it is left empty by
+// default as a series per user may be more than prometheus can hold.
+var OrgLabels bool
+
+// Org is the value of the org label of the sms of user.
+func Org(user int32) string {
+	if !OrgLabels {
+		return ""
+	}
+	return strconv.FormatInt(int64(user), 10)
+}
+
+var (
+	WorkerPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "backpressure_pauses_total",
+		Help:      "number of times the worker paused consuming because the database was degraded",
+	})
+	WorkerPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "backpressure_paused",
+		Help:      "1 while the worker is paused by backpressure, 0 otherwise",
+	})
+	WorkerTxDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "tx_duration_seconds",
+		Help:      "duration of sms processing transactions",
+		Buckets:   prometheus.DefBuckets,
+	})
+	WorkerTxErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "tx_errors_total",
+		Help:      "number of failed sms processing transactions",
+	})
+	WorkerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "messages_total",
+		Help:      "number of messages handled by the workers by subject and outcome (ack, nak, term or none)",
+	}, []string{"subject", "outcome"})
+	WorkerMessageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "message_duration_seconds",
+		Help:      "duration of message handlers by subject",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subject"})
+	WorkerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "handler_panics_total",
+		Help:      "number of panics recovered in message handlers by subject",
+	}, []string{"subject"})
+	WorkerBoosted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "aged_boosts_total",
+		Help:      "number of normal sms scheduled as express because they waited longer than worker.scheduler.aging",
+	})
+	TenantSms = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "tenant_sms_total",
+		Help:      "number of sms let through the rate limit by org, priority and share (reserved, on the reservation of the org, or shared)",
+	}, []string{LabelOrg, LabelPriority, "share"})
+	TenantSlotWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "tenant_slot_wait_seconds",
+		Help:      "time sms waited for a slot of the scheduler by org and the priority they were scheduled as",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{LabelOrg, LabelPriority})
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "queue",
+		Name:      "depth",
+		Help:      "messages in the work queue of each priority, including the ones being handled",
+	}, []string{LabelPriority})
+	DBTxRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "db",
+		Name:      "tx_retries_total",
+		Help:      "number of transactions run again by reason (serialization_failure or deadlock_detected)",
+	}, []string{"reason"})
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "webhook",
+		Name:      "deliveries_total",
+		Help:      "number of webhook delivery attempts by outcome (delivered, retried or failed)",
+	}, []string{"outcome"})
+	ForwardedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "forward",
+		Name:      "events_total",
+		Help:      "number of attempts forwarding events to the nats servers of users by org and outcome (forwarded, retried, failed or dropped)",
+	}, []string{LabelOrg, "outcome"})
+	FraudAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "fraud",
+		Name:      "alerts_total",
+		Help:      "number of api keys flagged by the fraud detector by reason and action taken",
+	}, []string{"reason", "action"})
+	DeliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "delivery",
+		Name:      "latency_seconds",
+		Help:      "latency of delivered sms by org, priority, provider and stage (pickup, submit, dlr or end_to_end)",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 15),
+	}, []string{LabelOrg, LabelPriority, LabelProvider, "stage"})
+	DlrCallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "callback",
+		Name:      "dlr_total",
+		Help:      "number of provider delivery reports by outcome (accepted, duplicate or rejected)",
+	}, []string{LabelProvider, "outcome"})
+	Submissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "submissions_total",
+		Help:      "number of sms submissions to the carrier by org, priority, provider and outcome (submitted, retried, failed or expired)",
+	}, []string{LabelOrg, LabelPriority, LabelProvider, "outcome"})
+	DeadlineBreaches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "deadline_breaches_total",
+		Help:      "number of sms not submitted within the deadline of their priority, by org and priority",
+	}, []string{LabelOrg, LabelPriority})
+	SmsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "stored_total",
+		Help:      "number of sms stored and charged by org and priority",
+	}, []string{LabelOrg, LabelPriority})
+	StatusChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "status_changes_total",
+		Help:      "number of sms moved to status by org, priority and the provider reporting it",
+	}, []string{LabelOrg, LabelPriority, LabelProvider, "status"})
+	ExpressAdmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "api",
+		Name:      "express_admissions_total",
+		Help:      "number of express sms refused or downgraded to normal while the express queue was backed up, by outcome (rejected or downgraded)",
+	}, []string{"outcome"})
+	RateQuotas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "api",
+		Name:      "rate_quotas_total",
+		Help:      "number of sms sent on burst credits or refused under the quota policies of their users, by priority and outcome (credit or refused)",
+	}, []string{LabelPriority, "outcome"})
+	MqttPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "publishes_total",
+		Help:      "number of send requests devices published over mqtt by outcome (accepted, refused, quota or forbidden)",
+	}, []string{"outcome"})
+	EmailMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "email",
+		Name:      "sms_total",
+		Help:      "number of sms requested by mail by outcome (accepted, refused or forbidden)",
+	}, []string{"outcome"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// NewServeMux returns a mux exposing the default registry on /metrics.
+func NewServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return mux
+}
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrOriginNotAllowed = errors.New("api key is not allowed from this address")
+)
+
+// RestrictOrigin rejects requests whose client address is outside the CIDR ranges
+// the API key is restricted to. it must run after Authenticate.
+// the client address honors X-Forwarded-For only from the engine's trusted proxies.
+func RestrictOrigin(ctx *gin.Context) {
+	p, ok := Principal(ctx)
+	if !ok {
+		ctx.Next()
+		return
+	}
+	ip, err := netip.ParseAddr(ctx.ClientIP())
+	if err == nil && p.AllowsIP(ip) {
+		ctx.Next()
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"key_id":    p.KeyID,
+		"user_id":   p.UserID,
+		"client_ip": ctx.ClientIP(),
+		"method":    ctx.Request.Method,
+		"path":      ctx.Request.URL.Path,
+	}).Warn("audit: rejected api key used outside its allowed cidrs")
+	abortJSON(ctx, http.StatusForbidden, ErrOriginNotAllowed)
+}
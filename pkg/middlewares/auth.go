@@ -0,0 +1,166 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const principalKey = "auth.principal"
+
+var (
+	ErrMissingCredentials = errors.New("missing api key")
+	ErrInvalidCredentials = errors.New("invalid api key")
+	ErrForbidden          = errors.New("not allowed to access this resource")
+	ErrKeySuspended       = errors.New("api key is suspended")
+)
+
+// KeyLookup resolves the hash of an API key to its principal.
+// it must return ErrInvalidCredentials for unknown or revoked keys and ErrKeySuspended for suspended ones.
+type KeyLookup func(ctx context.Context, hash string) (*auth.Principal, error)
+
+type principalContextKey struct{}
+
+// WithPrincipal authenticates requests made with ctx as p, for requests the
+// process makes to its own router on behalf of a caller it authenticated itself.
+func WithPrincipal(ctx context.Context, p *auth.Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// credential returns the API key or service token of the request, from the
+// Authorization (Bearer) or X-API-Key header. clients that only speak Basic auth,
+// like Twilio's, send it as the password; the username is ignored.
+func credential(ctx *gin.Context) string {
+	key := ctx.GetHeader("X-API-Key")
+	if h := ctx.GetHeader("Authorization"); key == "" && strings.HasPrefix(h, "Bearer ") {
+		key = strings.TrimPrefix(h, "Bearer ")
+	}
+	if _, password, ok := ctx.Request.BasicAuth(); key == "" && ok {
+		key = password
+	}
+	return key
+}
+
+// Authenticate reads the API key of the request, see credential, and stores the
+// resolved principal on the context. requests made with WithPrincipal keep their
+// principal, and so do the ones a middleware before it authenticated, like
+// Tokens.Verify.
+func Authenticate(lookup KeyLookup) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if p, ok := ctx.Request.Context().Value(principalContextKey{}).(*auth.Principal); ok {
+			ctx.Set(principalKey, p)
+			ctx.Next()
+			return
+		}
+		if _, ok := Principal(ctx); ok {
+			ctx.Next()
+			return
+		}
+		key := credential(ctx)
+		if key == "" {
+			abortJSON(ctx, http.StatusUnauthorized, ErrMissingCredentials)
+			return
+		}
+		p, err := lookup(ctx, auth.HashKey(key))
+		if err != nil {
+			if errors.Is(err, ErrInvalidCredentials) {
+				abortJSON(ctx, http.StatusUnauthorized, err)
+				return
+			}
+			if errors.Is(err, ErrKeySuspended) {
+				abortJSON(ctx, http.StatusForbidden, err)
+				return
+			}
+			abortJSON(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		ctx.Set(principalKey, p)
+		ctx.Next()
+	}
+}
+
+// RequireScopes rejects requests whose principal lacks any of scopes.
+// it lets every request through while api.auth.enabled is off.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	enabled := viper.GetBool("api.auth.enabled")
+	return func(ctx *gin.Context) {
+		if !enabled {
+			ctx.Next()
+			return
+		}
+		p, ok := Principal(ctx)
+		if !ok {
+			ctx.AbortWithError(http.StatusUnauthorized, ErrMissingCredentials)
+			return
+		}
+		for _, s := range scopes {
+			if !p.Has(s) {
+				ctx.AbortWithError(http.StatusForbidden, fmt.Errorf("missing scope %q", s))
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// Principal returns the caller set by Authenticate, if any.
+func Principal(ctx *gin.Context) (*auth.Principal, bool) {
+	v, ok := ctx.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*auth.Principal)
+	return p, ok
+}
+
+// Owns aborts the request with 403 unless the caller may act on the given user's resources.
+// requests without a principal are allowed, as they only happen while authentication is off.
+func Owns(ctx *gin.Context, userID int32) bool {
+	p, ok := Principal(ctx)
+	if !ok || p.Owns(userID) {
+		return true
+	}
+	ctx.AbortWithError(http.StatusForbidden, ErrForbidden)
+	return false
+}
+
+// OwnsUsername is Owns for routes that address the user by username.
+func OwnsUsername(ctx *gin.Context, username string) bool {
+	p, ok := Principal(ctx)
+	if !ok || p.OwnsUsername(username) {
+		return true
+	}
+	ctx.AbortWithError(http.StatusForbidden, ErrForbidden)
+	return false
+}
+
+// Unmasked reports whether the caller may see destination numbers and message
+// bodies in full, which takes auth.ScopeSmsReadFull. requests without a
+// principal may, as they only happen while authentication is off.
+func Unmasked(ctx *gin.Context) bool {
+	p, ok := Principal(ctx)
+	return !ok || p.Has(auth.ScopeSmsReadFull)
+}
+
+// abortJSON answers in the WriteErrorBody format for middlewares that run before it.
+func abortJSON(ctx *gin.Context, code int, err error) {
+	ctx.Error(err)
+	res := gin.H{
+		"status": code,
+		"errors": []string{err.Error()},
+	}
+	if id := GetRequestID(ctx); id != "" {
+		res["request_id"] = id
+	}
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		res["code"] = coded.Code
+	}
+	ctx.AbortWithStatusJSON(code, res)
+}
@@ -0,0 +1,84 @@
+package middlewares_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("Authenticate", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(Authenticate(func(_ context.Context, hash string) (*auth.Principal, error) {
+			if hash == auth.HashKey("sms_valid") {
+				return &auth.Principal{KeyID: 1}, nil
+			}
+			return nil, ErrInvalidCredentials
+		}))
+		r.GET("/sms", func(ctx *gin.Context) {
+			p, _ := Principal(ctx)
+			ctx.JSON(200, p.KeyID)
+		})
+	})
+
+	It("should resolve the key of the request", func() {
+		req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+		req.Header.Set("Authorization", "Bearer sms_valid")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.String()).To(Equal("1"))
+
+		req.Header.Set("Authorization", "Bearer sms_forged")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should keep the principal of requests made with WithPrincipal", func() {
+		ctx := WithPrincipal(context.Background(), &auth.Principal{KeyID: 7})
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/sms", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.String()).To(Equal("7"))
+	})
+})
+
+var _ = Describe("Unmasked", func() {
+	unmasked := func(p *auth.Principal) bool {
+		r := gin.New()
+		r.Use(Authenticate(nil))
+		var got bool
+		r.GET("/sms", func(ctx *gin.Context) { got = Unmasked(ctx) })
+		ctx := context.Background()
+		if p != nil {
+			ctx = WithPrincipal(ctx, p)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/sms", nil))
+		return got
+	}
+
+	It("should take the full read scope", func() {
+		Expect(unmasked(&auth.Principal{Scopes: []string{auth.ScopeSmsRead}})).To(BeFalse())
+		Expect(unmasked(&auth.Principal{Scopes: []string{auth.ScopeSmsRead, auth.ScopeSmsReadFull}})).To(BeTrue())
+		Expect(unmasked(&auth.Principal{Scopes: []string{auth.ScopeUserAdmin}})).To(BeTrue())
+	})
+
+	It("should not mask while authentication is off", func() {
+		r := gin.New()
+		var got bool
+		r.GET("/sms", func(ctx *gin.Context) { got = Unmasked(ctx) })
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sms", nil))
+		Expect(got).To(BeTrue())
+	})
+})
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to call the API. "*" allows any origin
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers browsers let scripts read
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses
+	MaxAge time.Duration
+}
+
+func CORSConfigFromViper() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins:   viper.GetStringSlice("api.cors.allowedorigins"),
+		AllowedMethods:   viper.GetStringSlice("api.cors.allowedmethods"),
+		AllowedHeaders:   viper.GetStringSlice("api.cors.allowedheaders"),
+		ExposedHeaders:   viper.GetStringSlice("api.cors.exposedheaders"),
+		AllowCredentials: viper.GetBool("api.cors.allowcredentials"),
+		MaxAge:           viper.GetDuration("api.cors.maxage"),
+	}
+}
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+// CORS answers preflight requests and adds the Access-Control headers for allowed origins.
+// requests from other origins get no CORS headers, so browsers block them.
+// it must be installed on the engine so preflight requests reach it for every path.
+func CORS(conf CORSConfig) gin.HandlerFunc {
+	methods := strings.Join(conf.AllowedMethods, ", ")
+	headers := strings.Join(conf.AllowedHeaders, ", ")
+	exposed := strings.Join(conf.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(conf.MaxAge.Seconds()))
+
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+		h := ctx.Writer.Header()
+		h.Add("Vary", "Origin")
+		if !conf.allowsOrigin(origin) {
+			if ctx.Request.Method == http.MethodOptions {
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		// a wildcard can't be combined with credentials, so echo the origin instead
+		if slices.Contains(conf.AllowedOrigins, "*") && !conf.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if conf.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			h.Set("Access-Control-Expose-Headers", exposed)
+		}
+		ctx.Next()
+	}
+}
+
+// SecurityHeaders sets the standard hardening headers for a JSON API.
+// Strict-Transport-Security is only sent when hsts is positive, i.e. behind TLS.
+func SecurityHeaders(hsts time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		h := ctx.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		h.Set("Cross-Origin-Resource-Policy", "same-site")
+		if hsts > 0 {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hsts.Seconds()))+"; includeSubDomains")
+		}
+		ctx.Next()
+	}
+}
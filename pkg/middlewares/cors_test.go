@@ -0,0 +1,75 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("CORS", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(SecurityHeaders(time.Hour), CORS(CORSConfig{
+			AllowedOrigins: []string{"https://dashboard.example.com"},
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"Authorization", "Content-Type"},
+			ExposedHeaders: []string{"X-RateLimit-Remaining"},
+			MaxAge:         10 * time.Minute,
+		}))
+		r.GET("/sms", func(ctx *gin.Context) { ctx.Status(200) })
+	})
+
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/sms", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should answer preflight requests from allowed origins", func() {
+		w := serve(http.MethodOptions, "https://dashboard.example.com")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://dashboard.example.com"))
+		Expect(w.Header().Get("Access-Control-Allow-Methods")).To(Equal("GET, POST"))
+		Expect(w.Header().Get("Access-Control-Allow-Headers")).To(Equal("Authorization, Content-Type"))
+		Expect(w.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+	})
+
+	It("should reject preflight requests from other origins", func() {
+		w := serve(http.MethodOptions, "https://evil.example.com")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("should add CORS headers to actual requests", func() {
+		w := serve(http.MethodGet, "https://dashboard.example.com")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://dashboard.example.com"))
+		Expect(w.Header().Get("Access-Control-Expose-Headers")).To(Equal("X-RateLimit-Remaining"))
+
+		w = serve(http.MethodGet, "https://evil.example.com")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("should set security headers", func() {
+		w := serve(http.MethodGet, "")
+		Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(w.Header().Get("X-Frame-Options")).To(Equal("DENY"))
+		Expect(w.Header().Get("Strict-Transport-Security")).To(Equal("max-age=3600; includeSubDomains"))
+	})
+})
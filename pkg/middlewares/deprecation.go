@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrSunset = errors.New("endpoint was retired")
+
+// Deprecation describes retired endpoints.
+type Deprecation struct {
+	// At is when they were deprecated, sent as the Deprecation header (RFC 9745)
+	At time.Time
+	// Sunset is when they stop answering, sent as the Sunset header (RFC 8594). zero until it is decided
+	Sunset time.Time
+	// Link points to the migration guide
+	Link string
+}
+
+// Deprecated announces d on every response. once d.Sunset passed, requests are answered with 410 Gone.
+func Deprecated(d Deprecation) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !d.At.IsZero() {
+			ctx.Header("Deprecation", fmt.Sprintf("@%d", d.At.Unix()))
+		}
+		if !d.Sunset.IsZero() {
+			ctx.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Link != "" {
+			ctx.Header("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", d.Link))
+		}
+		if !d.Sunset.IsZero() && !time.Now().Before(d.Sunset) {
+			abortJSON(ctx, http.StatusGone, ErrSunset)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("Deprecated", func() {
+	at := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	serve := func(d Deprecation) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.GET("/sms", Deprecated(d), func(ctx *gin.Context) { ctx.Status(200) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sms", nil))
+		return w
+	}
+
+	It("should announce the deprecation and the sunset", func() {
+		sunset := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		w := serve(Deprecation{At: at, Sunset: sunset, Link: "https://docs.example.com/v1"})
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Deprecation")).To(Equal("@1792195200"))
+		Expect(w.Header().Get("Sunset")).To(Equal(sunset.UTC().Format(http.TimeFormat)))
+		Expect(w.Header().Get("Link")).To(Equal(`<https://docs.example.com/v1>; rel="deprecation"`))
+	})
+
+	It("should leave out the sunset until it is decided", func() {
+		w := serve(Deprecation{At: at})
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Sunset")).To(BeEmpty())
+		Expect(w.Header().Get("Link")).To(BeEmpty())
+	})
+
+	It("should answer 410 after the sunset", func() {
+		w := serve(Deprecation{At: at, Sunset: time.Now().Add(-time.Minute)})
+		Expect(w.Code).To(Equal(http.StatusGone))
+		Expect(w.Body.String()).To(ContainSubstring(ErrSunset.Error()))
+	})
+})
@@ -1,11 +1,32 @@
 package middlewares
 
 import (
+	"errors"
 	"slices"
 
 	"github.com/gin-gonic/gin"
 )
 
+// CodedError carries a stable code clients can tell errors apart by, independent of
+// the http status and the message. WriteErrorBody reports it as "code".
+type CodedError struct {
+	Code string
+	Err  error
+}
+
+// WithCode tags err with code.
+func WithCode(code string, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
 func WriteErrorBody(ctx *gin.Context) {
 	ctx.Next()
 	if len(ctx.Errors) > 0 {
@@ -13,7 +34,14 @@ func WriteErrorBody(ctx *gin.Context) {
 			"status": ctx.Writer.Status(),
 			"errors": make([]string, 0, len(ctx.Errors)),
 		}
+		if id := GetRequestID(ctx); id != "" {
+			res["request_id"] = id
+		}
 		for _, v := range ctx.Errors {
+			var coded *CodedError
+			if _, ok := res["code"]; !ok && errors.As(v.Err, &coded) {
+				res["code"] = coded.Code
+			}
 			if slices.Contains(res["errors"].([]string), v.Error()) {
 				continue
 			}
@@ -0,0 +1,47 @@
+package middlewares_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("WriteErrorBody", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(WriteErrorBody)
+		r.GET("/plain", func(ctx *gin.Context) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("bad"))
+		})
+		r.GET("/coded", func(ctx *gin.Context) {
+			err := WithCode("recipient_flood", errors.New("too many"))
+			ctx.AbortWithError(http.StatusTooManyRequests, fmt.Errorf("wrapped: %w", err))
+		})
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	It("should leave the code out for plain errors", func() {
+		w := serve("/plain")
+		Expect(w.Body.String()).To(MatchJSON(`{"status": 400, "errors": ["bad"]}`))
+	})
+
+	It("should report the code of wrapped coded errors", func() {
+		w := serve("/coded")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": 429, "code": "recipient_flood", "errors": ["wrapped: too many"]}`))
+	})
+})
@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TouchKeys records when the keys of ids were last used, at the same index of at.
+type TouchKeys func(ctx context.Context, ids []int32, at []time.Time) error
+
+// LastUsed tracks when every API key and service token was last used. the times
+// are collected in memory and written in one batch every interval, so requests
+// don't wait for a write.
+type LastUsed struct {
+	touch TouchKeys
+
+	mu   sync.Mutex
+	used map[int32]time.Time
+}
+
+func NewLastUsed(touch TouchKeys) *LastUsed {
+	return &LastUsed{touch: touch, used: make(map[int32]time.Time)}
+}
+
+// Track records the use of the key of the request. it must come after Authenticate.
+func (l *LastUsed) Track(ctx *gin.Context) {
+	if p, ok := Principal(ctx); ok && p.KeyID != 0 {
+		l.mu.Lock()
+		l.used[p.KeyID] = time.Now()
+		l.mu.Unlock()
+	}
+	ctx.Next()
+}
+
+// Flush writes the uses tracked since the last flush. they are tracked again when
+// the write fails.
+func (l *LastUsed) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	used := l.used
+	l.used = make(map[int32]time.Time, len(used))
+	l.mu.Unlock()
+	if len(used) == 0 {
+		return nil
+	}
+	ids := make([]int32, 0, len(used))
+	at := make([]time.Time, 0, len(used))
+	for id, t := range used {
+		ids = append(ids, id)
+		at = append(at, t)
+	}
+	err := l.touch(ctx, ids, at)
+	if err != nil {
+		l.mu.Lock()
+		for id, t := range used {
+			if newer, ok := l.used[id]; !ok || newer.Before(t) {
+				l.used[id] = t
+			}
+		}
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes every interval until ctx is done, then once more.
+func (l *LastUsed) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := l.Flush(flushCtx)
+			if err != nil {
+				logrus.Errorf("failed to record when keys were last used: %s", err)
+			}
+			return
+		case <-ticker.C:
+		}
+		err := l.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to record when keys were last used: %s", err)
+		}
+	}
+}
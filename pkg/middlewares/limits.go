@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit rejects request bodies larger than n bytes with 413.
+// the body is buffered up to the limit, so handlers never read more than n bytes from a client.
+func BodyLimit(n int64) gin.HandlerFunc {
+	return BodyLimits(n, nil)
+}
+
+// BodyLimits is BodyLimit with the limits of uploads in routes, by full path. uploads
+// are streamed to their handler instead of buffered; reading past their limit fails
+// with a *http.MaxBytesError.
+func BodyLimits(n int64, routes map[string]int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limit := n
+		upload, streamed := routes[ctx.FullPath()]
+		if streamed {
+			limit = upload
+		}
+		if limit <= 0 || ctx.Request.Body == nil || ctx.Request.Body == http.NoBody {
+			ctx.Next()
+			return
+		}
+		tooLarge := fmt.Errorf("request body is larger than %d bytes", limit)
+		if ctx.Request.ContentLength > limit {
+			abortJSON(ctx, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		if streamed {
+			ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+			ctx.Next()
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				abortJSON(ctx, http.StatusRequestEntityTooLarge, tooLarge)
+				return
+			}
+			abortJSON(ctx, http.StatusBadRequest, err)
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		ctx.Next()
+	}
+}
@@ -0,0 +1,90 @@
+package middlewares_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("BodyLimit", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(BodyLimit(16))
+		r.POST("/sms", func(ctx *gin.Context) {
+			body, err := io.ReadAll(ctx.Request.Body)
+			Expect(err).NotTo(HaveOccurred())
+			ctx.String(200, string(body))
+		})
+	})
+
+	serve := func(body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sms", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should pass bodies within the limit through", func() {
+		w := serve(`{"message":"hi"}`, false)
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.String()).To(Equal(`{"message":"hi"}`))
+	})
+
+	It("should reject bodies over the limit with 413", func() {
+		Expect(serve(strings.Repeat("a", 17), false).Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should reject bodies without a length once they cross the limit", func() {
+		Expect(serve(strings.Repeat("a", 17), true).Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(serve(strings.Repeat("a", 16), true).Code).To(Equal(200))
+	})
+})
+
+var _ = Describe("BodyLimits", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(BodyLimits(16, map[string]int64{"/import": 64}))
+		handler := func(ctx *gin.Context) {
+			body, err := io.ReadAll(ctx.Request.Body)
+			if err != nil {
+				ctx.String(http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			ctx.String(200, string(body))
+		}
+		r.POST("/sms", handler)
+		r.POST("/import", handler)
+	})
+
+	serve := func(path, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	It("should stream uploads up to their own limit", func() {
+		Expect(serve("/import", strings.Repeat("a", 64))).To(Equal(200))
+		Expect(serve("/import", strings.Repeat("a", 65))).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should keep the default limit for other routes", func() {
+		Expect(serve("/import", strings.Repeat("a", 64))).To(Equal(200))
+		Expect(serve("/sms", strings.Repeat("a", 17))).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+})
@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// RateLimitStore counts requests in fixed windows. implementations backed by a shared
+// store let several API instances enforce one limit.
+type RateLimitStore interface {
+	// Take counts one request against key and reports whether it is within limit,
+	// the requests left in the window and when the window resets.
+	Take(key string, limit int, window time.Duration) (ok bool, remaining int, reset time.Time)
+}
+
+type rateWindow struct {
+	count int
+	reset time.Time
+}
+
+// MemoryStore keeps the counters in process memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows: make(map[string]*rateWindow),
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryStore) Take(key string, limit int, window time.Duration) (bool, int, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now, window)
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &rateWindow{reset: now.Add(window)}
+		m.windows[key] = w
+	}
+	if w.count >= limit {
+		return false, 0, w.reset
+	}
+	w.count++
+	return true, limit - w.count, w.reset
+}
+
+// sweep drops expired windows at most once per window so idle clients don't pile up.
+func (m *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(m.lastSweep) < window {
+		return
+	}
+	m.lastSweep = now
+	for k, w := range m.windows {
+		if !now.Before(w.reset) {
+			delete(m.windows, k)
+		}
+	}
+}
+
+// RateLimitKey names the counter a request is charged to. requests it returns false for are not limited.
+type RateLimitKey func(ctx *gin.Context) (string, bool)
+
+// ByIP limits each client address.
+func ByIP(ctx *gin.Context) (string, bool) {
+	return "ip:" + ctx.ClientIP(), true
+}
+
+// ByApiKey limits each API key. it must run after Authenticate.
+func ByApiKey(ctx *gin.Context) (string, bool) {
+	p, ok := Principal(ctx)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("key:%d", p.KeyID), true
+}
+
+// RateLimit allows limit requests per window for each key and answers 429 with Retry-After beyond that.
+// the X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers report the limit state.
+func RateLimit(store RateLimitStore, limit int, window time.Duration, key RateLimitKey) gin.HandlerFunc {
+	return rateLimit(store, window, key, func(*gin.Context) int { return limit })
+}
+
+// RateLimitKeys is RateLimit by ByApiKey, except that keys with a RateLimit of their
+// own are held to it instead of limit.
+func RateLimitKeys(store RateLimitStore, limit int, window time.Duration) gin.HandlerFunc {
+	return rateLimit(store, window, ByApiKey, func(ctx *gin.Context) int {
+		if p, ok := Principal(ctx); ok && p.RateLimit > 0 {
+			return p.RateLimit
+		}
+		return limit
+	})
+}
+
+func rateLimit(store RateLimitStore, window time.Duration, key RateLimitKey, limitOf func(ctx *gin.Context) int) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limit := limitOf(ctx)
+		if limit <= 0 || window <= 0 {
+			ctx.Next()
+			return
+		}
+		k, ok := key(ctx)
+		if !ok {
+			ctx.Next()
+			return
+		}
+		ok, remaining, reset := store.Take(k, limit, window)
+		resetIn := strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds())))
+		h := ctx.Writer.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", resetIn)
+		if !ok {
+			h.Set("Retry-After", resetIn)
+			abortJSON(ctx, http.StatusTooManyRequests, ErrRateLimited)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,98 @@
+package middlewares_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("RateLimit", func() {
+	var r *gin.Engine
+
+	setup := func(window time.Duration) {
+		r = gin.New()
+		r.Use(RateLimit(NewMemoryStore(), 2, window, ByIP))
+		r.GET("/sms", func(ctx *gin.Context) { ctx.Status(200) })
+	}
+
+	serve := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should reject requests over the limit with Retry-After", func() {
+		setup(time.Minute)
+		w := serve("10.0.0.1")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("X-RateLimit-Limit")).To(Equal("2"))
+		Expect(w.Header().Get("X-RateLimit-Remaining")).To(Equal("1"))
+		Expect(serve("10.0.0.1").Header().Get("X-RateLimit-Remaining")).To(Equal("0"))
+
+		w = serve("10.0.0.1")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("60"))
+	})
+
+	It("should count each client separately", func() {
+		setup(time.Minute)
+		serve("10.0.0.1")
+		serve("10.0.0.1")
+		Expect(serve("10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+		Expect(serve("10.0.0.2").Code).To(Equal(200))
+	})
+
+	It("should allow requests again once the window resets", func() {
+		setup(50 * time.Millisecond)
+		serve("10.0.0.1")
+		serve("10.0.0.1")
+		Expect(serve("10.0.0.1").Code).To(Equal(http.StatusTooManyRequests))
+		Eventually(func() int { return serve("10.0.0.1").Code }).Should(Equal(200))
+	})
+})
+
+var _ = Describe("RateLimitKeys", func() {
+	var r *gin.Engine
+
+	BeforeEach(func() {
+		keys := map[string]*auth.Principal{
+			auth.HashKey("sms_regular"):   {KeyID: 1},
+			auth.HashKey("sms_throttled"): {KeyID: 2, RateLimit: 1},
+		}
+		r = gin.New()
+		r.Use(Authenticate(func(_ context.Context, hash string) (*auth.Principal, error) {
+			return keys[hash], nil
+		}), RateLimitKeys(NewMemoryStore(), 3, time.Minute))
+		r.GET("/sms", func(ctx *gin.Context) { ctx.Status(200) })
+	})
+
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should hold keys with a limit of their own to it", func() {
+		w := serve("sms_throttled")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("X-RateLimit-Limit")).To(Equal("1"))
+		Expect(serve("sms_throttled").Code).To(Equal(http.StatusTooManyRequests))
+
+		for range 3 {
+			Expect(serve("sms_regular").Code).To(Equal(200))
+		}
+		Expect(serve("sms_regular").Code).To(Equal(http.StatusTooManyRequests))
+	})
+})
@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CodeReadOnly is the code of requests refused by ReadOnly.
+const CodeReadOnly = "read_only"
+
+var ErrReadOnly = errors.New("the api is read only until the database schema matches this version")
+
+// ReadOnly answers 503 to every request that may write, anything but GET, HEAD
+// and OPTIONS, while the api runs on a database of another schema version.
+func ReadOnly() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		abortJSON(ctx, http.StatusServiceUnavailable, WithCode(CodeReadOnly, ErrReadOnly))
+	}
+}
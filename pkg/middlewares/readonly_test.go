@@ -0,0 +1,39 @@
+package middlewares_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("ReadOnly", func() {
+	serve := func(method string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(ReadOnly())
+		r.Handle(method, "/sms", func(ctx *gin.Context) { ctx.Status(200) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/sms", nil))
+		return w
+	}
+
+	It("should serve reads", func() {
+		Expect(serve(http.MethodGet).Code).To(Equal(200))
+		Expect(serve(http.MethodHead).Code).To(Equal(200))
+	})
+
+	It("should refuse writes with its code", func() {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			w := serve(method)
+			Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+			var res map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+			Expect(res["code"]).To(Equal(CodeReadOnly))
+		}
+	})
+})
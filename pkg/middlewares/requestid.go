@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderRequestID is accepted from clients and echoed on every response.
+const HeaderRequestID = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// ids clients may choose. others are replaced, so they can't smuggle anything into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID gives every request an id: the client's X-Request-ID when it is valid,
+// a random one otherwise. it is echoed in the response header and error bodies.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(HeaderRequestID)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		ctx.Set(requestIDKey, id)
+		ctx.Header(HeaderRequestID, id)
+		ctx.Next()
+	}
+}
+
+// GetRequestID returns the id RequestID gave the request.
+func GetRequestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
+
+// LogFormatter is gin's default access log line with the request id appended.
+func LogFormatter(p gin.LogFormatterParams) string {
+	if p.Latency > time.Minute {
+		p.Latency = p.Latency.Truncate(time.Second)
+	}
+	id, _ := p.Keys[requestIDKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | request_id=%s\n%s",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		p.StatusCode,
+		p.Latency,
+		p.ClientIP,
+		p.Method,
+		p.Path,
+		id,
+		p.ErrorMessage,
+	)
+}
@@ -0,0 +1,63 @@
+package middlewares_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("RequestID", func() {
+	var (
+		r    *gin.Engine
+		seen string
+	)
+
+	BeforeEach(func() {
+		r = gin.New()
+		r.Use(RequestID(), WriteErrorBody)
+		r.GET("/sms", func(ctx *gin.Context) {
+			seen = GetRequestID(ctx)
+			ctx.Status(200)
+		})
+		r.GET("/fail", func(ctx *gin.Context) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("bad"))
+		})
+	})
+
+	serve := func(path, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if id != "" {
+			req.Header.Set(HeaderRequestID, id)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should keep the client's id", func() {
+		w := serve("/sms", "support-ticket-42")
+		Expect(w.Header().Get(HeaderRequestID)).To(Equal("support-ticket-42"))
+		Expect(seen).To(Equal("support-ticket-42"))
+	})
+
+	It("should generate an id when there is none or it is invalid", func() {
+		w := serve("/sms", "")
+		Expect(w.Header().Get(HeaderRequestID)).To(HaveLen(32))
+		Expect(seen).To(Equal(w.Header().Get(HeaderRequestID)))
+
+		w = serve("/sms", "id with\nnewline")
+		Expect(w.Header().Get(HeaderRequestID)).To(HaveLen(32))
+	})
+
+	It("should include the id in error bodies", func() {
+		w := serve("/fail", "req-1")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": 400, "errors": ["bad"], "request_id": "req-1"}`))
+	})
+})
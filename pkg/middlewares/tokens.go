@@ -0,0 +1,99 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DisabledTokens lists the ids of the service tokens that were revoked, suspended
+// or rotated out while their signature is still valid.
+type DisabledTokens func(ctx context.Context) ([]int32, error)
+
+// Tokens verifies signed service tokens offline: by their signature and expiry,
+// without looking them up. revocations and suspensions are only seen once the
+// disabled tokens were refreshed, see Run.
+type Tokens struct {
+	signer *auth.Signer
+	load   DisabledTokens
+
+	mu       sync.RWMutex
+	disabled map[int32]struct{}
+}
+
+func NewTokens(signer *auth.Signer, load DisabledTokens) *Tokens {
+	return &Tokens{
+		signer:   signer,
+		load:     load,
+		disabled: make(map[int32]struct{}),
+	}
+}
+
+// Refresh loads the disabled tokens.
+func (t *Tokens) Refresh(ctx context.Context) error {
+	ids, err := t.load(ctx)
+	if err != nil {
+		return err
+	}
+	disabled := make(map[int32]struct{}, len(ids))
+	for _, id := range ids {
+		disabled[id] = struct{}{}
+	}
+	t.mu.Lock()
+	t.disabled = disabled
+	t.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the disabled tokens every interval until ctx is done. a failed
+// refresh keeps the previous ones.
+func (t *Tokens) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := t.Refresh(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("failed to refresh disabled service tokens: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify authenticates the requests made with a signed service token and leaves
+// the others to Authenticate, which must come after it.
+func (t *Tokens) Verify(ctx *gin.Context) {
+	token := credential(ctx)
+	if !auth.Signed(token) {
+		ctx.Next()
+		return
+	}
+	claims, err := t.signer.Verify(token, time.Now())
+	if err != nil {
+		abortJSON(ctx, http.StatusUnauthorized, fmt.Errorf("%w: %w", ErrInvalidCredentials, err))
+		return
+	}
+	t.mu.RLock()
+	_, disabled := t.disabled[claims.ID]
+	t.mu.RUnlock()
+	if disabled {
+		abortJSON(ctx, http.StatusUnauthorized, ErrInvalidCredentials)
+		return
+	}
+	p, err := claims.Principal()
+	if err != nil {
+		abortJSON(ctx, http.StatusUnauthorized, fmt.Errorf("%w: %w", ErrInvalidCredentials, err))
+		return
+	}
+	ctx.Set(principalKey, p)
+	ctx.Next()
+}
@@ -0,0 +1,131 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/auth"
+	. "github.com/alireza-karampour/sms/pkg/middlewares"
+)
+
+var _ = Describe("Tokens", func() {
+	var (
+		r      *gin.Engine
+		signer *auth.Signer
+	)
+
+	sign := func(id int32, ttl time.Duration) string {
+		token, err := signer.Sign(auth.Claims{
+			ID:        id,
+			UserID:    3,
+			Scopes:    []string{auth.ScopeSmsSend},
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return token
+	}
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		var err error
+		signer, err = auth.NewSigner("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		tokens := NewTokens(signer, func(context.Context) ([]int32, error) { return nil, nil })
+		r = gin.New()
+		r.Use(tokens.Verify, Authenticate(func(_ context.Context, hash string) (*auth.Principal, error) {
+			if hash == auth.HashKey("sms_valid") {
+				return &auth.Principal{KeyID: 1}, nil
+			}
+			return nil, ErrInvalidCredentials
+		}))
+		r.GET("/sms", func(ctx *gin.Context) {
+			p, _ := Principal(ctx)
+			ctx.JSON(200, p.KeyID)
+		})
+	})
+
+	It("should authenticate signed tokens without a lookup", func() {
+		w := get(sign(9, time.Hour))
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.String()).To(Equal("9"))
+	})
+
+	It("should leave other keys to Authenticate", func() {
+		w := get("sms_valid")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.String()).To(Equal("1"))
+	})
+
+	It("should reject expired and forged tokens", func() {
+		Expect(get(sign(9, -time.Minute)).Code).To(Equal(http.StatusUnauthorized))
+		var err error
+		signer, err = auth.NewSigner("k1", map[string][]byte{"k1": bytes.Repeat([]byte{2}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get(sign(9, time.Hour)).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject disabled tokens once refreshed", func() {
+		tokens := NewTokens(signer, func(context.Context) ([]int32, error) { return []int32{9}, nil })
+		r := gin.New()
+		r.Use(tokens.Verify)
+		r.GET("/sms", func(ctx *gin.Context) { ctx.Status(200) })
+		req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+		req.Header.Set("X-API-Key", sign(9, time.Hour))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(200))
+
+		Expect(tokens.Refresh(context.Background())).To(Succeed())
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
+
+var _ = Describe("LastUsed", func() {
+	It("should flush the last use of every key once", func() {
+		var ids []int32
+		var fail error
+		l := NewLastUsed(func(_ context.Context, got []int32, at []time.Time) error {
+			if fail != nil {
+				return fail
+			}
+			Expect(at).To(HaveLen(len(got)))
+			ids = append(ids, got...)
+			return nil
+		})
+		r := gin.New()
+		r.Use(Authenticate(nil), l.Track)
+		r.GET("/sms", func(ctx *gin.Context) { ctx.Status(200) })
+		for _, id := range []int32{1, 2, 1} {
+			ctx := WithPrincipal(context.Background(), &auth.Principal{KeyID: id})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/sms", nil))
+		}
+
+		fail = errors.New("database down")
+		Expect(l.Flush(context.Background())).To(MatchError(fail))
+		Expect(ids).To(BeEmpty())
+
+		fail = nil
+		Expect(l.Flush(context.Background())).To(Succeed())
+		Expect(ids).To(ConsistOf(int32(1), int32(2)))
+		Expect(l.Flush(context.Background())).To(Succeed())
+		Expect(ids).To(HaveLen(2))
+	})
+})
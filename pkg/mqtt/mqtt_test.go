@@ -0,0 +1,178 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/mqtt"
+)
+
+type publication struct {
+	client  string
+	topic   string
+	payload string
+}
+
+type fakeHandler struct {
+	mu    sync.Mutex
+	pubs  []publication
+	reply bool
+}
+
+func (h *fakeHandler) Connect(ctx context.Context, c *Client, username, password string) byte {
+	if password != "secret" {
+		return RefusedCredentials
+	}
+	return Accepted
+}
+
+func (h *fakeHandler) Publish(ctx context.Context, c *Client, topic string, payload []byte) {
+	h.mu.Lock()
+	h.pubs = append(h.pubs, publication{c.ID, topic, string(payload)})
+	h.mu.Unlock()
+	c.Publish("result/"+c.ID, []byte("ok"))
+}
+
+func (h *fakeHandler) Subscribe(c *Client, filter string) bool {
+	return filter == "result/"+c.ID
+}
+
+func (h *fakeHandler) Publications() []publication {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]publication(nil), h.pubs...)
+}
+
+func str(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func write(conn net.Conn, first byte, body []byte) {
+	_, err := conn.Write(append([]byte{first, byte(len(body))}, body...))
+	Expect(err).ToNot(HaveOccurred())
+}
+
+// read returns the first byte and the body of the next packet, bodies are short
+func read(r *bufio.Reader) (byte, []byte) {
+	first, err := r.ReadByte()
+	Expect(err).ToNot(HaveOccurred())
+	n, err := r.ReadByte()
+	Expect(err).ToNot(HaveOccurred())
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	Expect(err).ToNot(HaveOccurred())
+	return first, body
+}
+
+func connect(conn net.Conn, clientID, password string) {
+	body := append(str("MQTT"), 4, 0xc2, 0, 30)
+	body = append(body, str(clientID)...)
+	body = append(body, str(clientID)...)
+	body = append(body, str(password)...)
+	write(conn, 0x10, body)
+}
+
+var _ = Describe("Server", func() {
+	var (
+		h      *fakeHandler
+		addr   string
+		cancel context.CancelFunc
+		done   chan error
+	)
+
+	BeforeEach(func() {
+		h = &fakeHandler{}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = l.Addr().String()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan error, 1)
+		go func() {
+			done <- NewServer(h, 0).Serve(ctx, l)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		Expect(err).ToNot(HaveOccurred())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		DeferCleanup(conn.Close)
+		return conn, bufio.NewReader(conn)
+	}
+
+	It("should refuse bad credentials", func() {
+		conn, r := dial()
+		connect(conn, "device-1", "wrong")
+		first, body := read(r)
+		Expect(first).To(BeEquivalentTo(0x20))
+		Expect(body).To(Equal([]byte{0, RefusedCredentials}))
+		_, err := r.ReadByte()
+		Expect(err).To(MatchError(io.EOF))
+	})
+
+	It("should refuse other protocol levels", func() {
+		conn, r := dial()
+		body := append(str("MQTT"), 5, 0x02, 0, 30)
+		write(conn, 0x10, append(body, str("device-1")...))
+		_, body = read(r)
+		Expect(body).To(Equal([]byte{0, RefusedProtocol}))
+	})
+
+	It("should hand publications to the handler and acknowledge them", func() {
+		conn, r := dial()
+		connect(conn, "device-1", "secret")
+		_, body := read(r)
+		Expect(body).To(Equal([]byte{0, Accepted}))
+
+		// subscribe to the own results and someone else's
+		sub := []byte{0, 1}
+		sub = append(append(sub, str("result/device-1")...), 0)
+		sub = append(append(sub, str("result/device-2")...), 1)
+		write(conn, 0x82, sub)
+		first, body := read(r)
+		Expect(first).To(BeEquivalentTo(0x90))
+		Expect(body).To(Equal([]byte{0, 1, 0, 0x80}))
+
+		pub := append(str("sms/send/key"), 0, 7)
+		write(conn, 0x32, append(pub, `{"to":"+4912345"}`...))
+		first, body = read(r)
+		Expect(first).To(BeEquivalentTo(0x30))
+		Expect(body).To(Equal(append(str("result/device-1"), "ok"...)))
+		first, body = read(r)
+		Expect(first).To(BeEquivalentTo(0x40))
+		Expect(body).To(Equal([]byte{0, 7}))
+		Expect(h.Publications()).To(Equal([]publication{{"device-1", "sms/send/key", `{"to":"+4912345"}`}}))
+
+		write(conn, 0xc0, nil)
+		first, _ = read(r)
+		Expect(first).To(BeEquivalentTo(0xd0))
+	})
+
+	It("should complete QoS 2 publications", func() {
+		conn, r := dial()
+		connect(conn, "device-1", "secret")
+		read(r)
+		write(conn, 0x34, append(append(str("sms/send/key"), 0, 9), "{}"...))
+		first, body := read(r)
+		Expect(first).To(BeEquivalentTo(0x50))
+		Expect(body).To(Equal([]byte{0, 9}))
+		write(conn, 0x62, []byte{0, 9})
+		first, body = read(r)
+		Expect(first).To(BeEquivalentTo(0x70))
+		Expect(body).To(Equal([]byte{0, 9}))
+	})
+})
@@ -0,0 +1,183 @@
+// Package mqtt serves the subset of MQTT 3.1.1 devices need to publish to a
+// server and hear back from it: no retained messages, wills or sessions, and
+// clients only receive what the server publishes to them.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// control packet types
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typePubrec      = 5
+	typePubrel      = 6
+	typePubcomp     = 7
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// return codes of CONNACK
+const (
+	Accepted             byte = 0
+	RefusedProtocol      byte = 1
+	RefusedIdentifier    byte = 2
+	RefusedUnavailable   byte = 3
+	RefusedCredentials   byte = 4
+	RefusedNotAuthorized byte = 5
+)
+
+// subackFailure refuses a subscription in SUBACK
+const subackFailure = 0x80
+
+var (
+	ErrMalformed = errors.New("mqtt: malformed packet")
+	ErrTooLarge  = errors.New("mqtt: packet too large")
+)
+
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a packet whose body is at most max bytes.
+func readPacket(r *bufio.Reader, max int) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	// the remaining length is a base 128 varint of at most 4 bytes
+	length, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, ErrMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > max {
+		return packet{}, ErrTooLarge
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return packet{}, err
+	}
+	return packet{typ: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+func (p packet) encode() []byte {
+	b := []byte{p.typ<<4 | p.flags}
+	n := len(p.body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+// reader consumes the fields of a packet body.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := int(r.uint16())
+	if r.err != nil || len(r.b) < n {
+		r.err = ErrMalformed
+		return ""
+	}
+	v := string(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+type connect struct {
+	clientID  string
+	username  string
+	password  string
+	keepAlive uint16
+}
+
+// parseConnect reads a CONNECT of protocol level 4. wills are read and ignored.
+func parseConnect(body []byte) (connect, byte, error) {
+	r := &reader{b: body}
+	name := r.string()
+	level := r.byte()
+	flags := r.byte()
+	c := connect{keepAlive: r.uint16()}
+	if r.err != nil || name != "MQTT" {
+		return c, 0, ErrMalformed
+	}
+	if level != 4 {
+		return c, RefusedProtocol, fmt.Errorf("mqtt: unsupported protocol level %d", level)
+	}
+	c.clientID = r.string()
+	if flags&0x04 != 0 {
+		r.string()
+		r.string()
+	}
+	if flags&0x80 != 0 {
+		c.username = r.string()
+	}
+	if flags&0x40 != 0 {
+		c.password = r.string()
+	}
+	if r.err != nil {
+		return c, 0, r.err
+	}
+	return c, Accepted, nil
+}
@@ -0,0 +1,240 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler decides who connects and what their publications do.
+type Handler interface {
+	// Connect authenticates c and returns the return code of its CONNACK,
+	// Accepted lets it in
+	Connect(ctx context.Context, c *Client, username, password string) byte
+	// Publish handles a publication of c. it is acknowledged once Publish returns
+	Publish(ctx context.Context, c *Client, topic string, payload []byte)
+	// Subscribe reports whether c may subscribe to filter. filters are topics,
+	// wildcards only match when the handler accepts them as they are
+	Subscribe(c *Client, filter string) bool
+}
+
+// Client is a connected client.
+type Client struct {
+	ID         string
+	RemoteAddr net.Addr
+	// Session is what the handler keeps about the client, e.g. set on Connect
+	Session any
+
+	conn net.Conn
+	wmu  sync.Mutex
+	mu   sync.Mutex
+	subs map[string]bool
+}
+
+// Publish sends payload to the client on topic with QoS 0, if it subscribed to topic.
+func (c *Client) Publish(topic string, payload []byte) error {
+	c.mu.Lock()
+	subscribed := c.subs[topic]
+	c.mu.Unlock()
+	if !subscribed {
+		return nil
+	}
+	body := appendString(nil, topic)
+	return c.write(packet{typ: typePublish, body: append(body, payload...)})
+}
+
+func (c *Client) write(p packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(p.encode())
+	return err
+}
+
+const (
+	// connectTimeout is how long a new connection has to send its CONNECT
+	connectTimeout = 10 * time.Second
+	writeTimeout   = 10 * time.Second
+)
+
+// Server accepts clients and hands their packets to a Handler.
+type Server struct {
+	handler Handler
+	// maxPacket is the largest body of a packet accepted
+	maxPacket int
+
+	wg sync.WaitGroup
+}
+
+func NewServer(handler Handler, maxPacket int) *Server {
+	if maxPacket <= 0 {
+		maxPacket = 64 << 10
+	}
+	return &Server{handler: handler, maxPacket: maxPacket}
+}
+
+// Serve accepts clients on l until ctx is done, then closes l and every
+// connection and waits for the handlers to return.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	defer s.wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	c := &Client{RemoteAddr: conn.RemoteAddr(), conn: conn, subs: map[string]bool{}}
+	r := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(r, s.maxPacket)
+	if err != nil || p.typ != typeConnect {
+		return
+	}
+	cp, code, err := parseConnect(p.body)
+	if err == nil && cp.clientID == "" {
+		// clients without an id would need a session of the server's choosing
+		code = RefusedIdentifier
+	}
+	if err == nil && code == Accepted {
+		c.ID = cp.clientID
+		code = s.handler.Connect(ctx, c, cp.username, cp.password)
+	}
+	if err != nil && code == Accepted {
+		return
+	}
+	err = c.write(packet{typ: typeConnack, body: []byte{0, code}})
+	if err != nil || code != Accepted {
+		return
+	}
+
+	// clients must send something within one and a half keep alive periods
+	var idle time.Duration
+	if cp.keepAlive > 0 {
+		idle = time.Duration(cp.keepAlive) * 1500 * time.Millisecond
+	}
+	for {
+		if idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(idle))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(r, s.maxPacket)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				logrus.Debugf("mqtt client %s: %s", c.ID, err)
+			}
+			return
+		}
+		err = s.handle(ctx, c, p)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a packet, an error closes the connection.
+func (s *Server) handle(ctx context.Context, c *Client, p packet) error {
+	switch p.typ {
+	case typePublish:
+		qos := (p.flags >> 1) & 0x03
+		r := &reader{b: p.body}
+		topic := r.string()
+		var id uint16
+		if qos > 0 {
+			id = r.uint16()
+		}
+		if r.err != nil || qos > 2 {
+			return ErrMalformed
+		}
+		s.handler.Publish(ctx, c, topic, r.b)
+		switch qos {
+		case 1:
+			return c.write(packet{typ: typePuback, body: binary.BigEndian.AppendUint16(nil, id)})
+		case 2:
+			return c.write(packet{typ: typePubrec, body: binary.BigEndian.AppendUint16(nil, id)})
+		}
+		return nil
+	case typePubrel:
+		return c.write(packet{typ: typePubcomp, body: p.body})
+	case typeSubscribe:
+		r := &reader{b: p.body}
+		id := r.uint16()
+		codes := binary.BigEndian.AppendUint16(nil, id)
+		for len(r.b) > 0 && r.err == nil {
+			filter := r.string()
+			r.byte()
+			if r.err != nil {
+				break
+			}
+			if !s.handler.Subscribe(c, filter) {
+				codes = append(codes, subackFailure)
+				continue
+			}
+			c.mu.Lock()
+			c.subs[filter] = true
+			c.mu.Unlock()
+			// publications to clients are QoS 0 only
+			codes = append(codes, 0)
+		}
+		if r.err != nil || len(codes) == 2 {
+			return ErrMalformed
+		}
+		return c.write(packet{typ: typeSuback, body: codes})
+	case typeUnsubscribe:
+		r := &reader{b: p.body}
+		id := r.uint16()
+		for len(r.b) > 0 && r.err == nil {
+			filter := r.string()
+			c.mu.Lock()
+			delete(c.subs, filter)
+			c.mu.Unlock()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		return c.write(packet{typ: typeUnsuback, body: binary.BigEndian.AppendUint16(nil, id)})
+	case typePingreq:
+		return c.write(packet{typ: typePingresp})
+	case typeDisconnect:
+		return errDisconnect
+	default:
+		return ErrMalformed
+	}
+}
+
+var errDisconnect = errors.New("mqtt: disconnected")
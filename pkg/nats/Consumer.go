@@ -2,15 +2,32 @@ package nats
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// closeTimeout bounds how long Close waits for consumers to drain
+const closeTimeout = 30 * time.Second
+
+var (
+	ErrUnknownConsumer = errors.New("unknown consumer")
+	ErrNoHandler       = errors.New("no handler for consumer")
+)
+
+// Handler processes one message. ctx is the one given to StartConsumers.
+type Handler func(ctx context.Context, msg jetstream.Msg)
+
 type StreamConsumersConfig struct {
 	Stream    jetstream.StreamConfig
 	Consumers []jetstream.ConsumerConfig
+	// Handlers maps consumer names to the handler of their messages. consumers
+	// without one use the handler given to StartConsumers.
+	Handlers map[string]Handler
 }
 
 type StreamConsumers struct {
@@ -19,30 +36,47 @@ type StreamConsumers struct {
 }
 
 func (s *StreamConsumers) AddConsumer(consumer jetstream.Consumer) {
-	sync.OnceFunc(func() {
-		s.Consumers = make([]jetstream.Consumer, 0, 1)
-	})()
 	s.Consumers = append(s.Consumers, consumer)
 }
 
+// Consumer binds durable consumers to their streams and runs a handler on them.
 type Consumer struct {
-	*Base
-	Consumers map[string]*StreamConsumers
-	ctxs      []jetstream.ConsumeContext
+	*Manager
+	Consumers map[StreamName]*StreamConsumers
+	// named indexes the bound consumers by their name
+	named map[string]jetstream.Consumer
+	// ctxs holds the consume context of every consumer that is pulling
+	ctxs map[string]jetstream.ConsumeContext
+	// stopped consumers are not restarted by Resume
+	stopped  map[string]bool
+	handlers map[string]Handler
+	// handler runs the messages of consumers bound without their own handler
+	handler Handler
+	// middlewares wrap every handler, see Use
+	middlewares []Middleware
+	ctx         context.Context
+	opts        []jetstream.PullConsumeOpt
+	started     bool
+	mu          sync.Mutex
 }
 
-func NewConsumer(nc *nats.Conn) (*Consumer, error) {
-	b, err := NewBase(nc)
+func NewConsumer(ctx context.Context, nc *nats.Conn, opts ...Option) (*Consumer, error) {
+	m, err := NewManager(ctx, nc, opts...)
 	if err != nil {
 		return nil, err
 	}
+	return newConsumer(m), nil
+}
 
-	c := &Consumer{
-		Base:      b,
-		Consumers: make(map[string]*StreamConsumers),
-		ctxs:      make([]jetstream.ConsumeContext, 0, 1),
+func newConsumer(m *Manager) *Consumer {
+	return &Consumer{
+		Manager:   m,
+		Consumers: make(map[StreamName]*StreamConsumers),
+		named:     make(map[string]jetstream.Consumer),
+		ctxs:      make(map[string]jetstream.ConsumeContext),
+		stopped:   make(map[string]bool),
+		handlers:  make(map[string]Handler),
 	}
-	return c, nil
 }
 
 func (c *Consumer) BindConsumers(ctx context.Context, streams ...*StreamConsumersConfig) error {
@@ -54,7 +88,7 @@ func (c *Consumer) BindConsumers(ctx context.Context, streams ...*StreamConsumer
 		}
 
 		for _, consumerConf := range conf.Consumers {
-			cons, err := c.CreateOrUpdateConsumer(ctx, strName, consumerConf)
+			cons, err := c.reconcileConsumer(ctx, strName, consumerConf)
 			if err != nil {
 				return err
 			}
@@ -65,20 +99,154 @@ func (c *Consumer) BindConsumers(ctx context.Context, streams ...*StreamConsumer
 			}
 			consumers.Stream = c.Streams[strName]
 			consumers.AddConsumer(cons)
+
+			name := consumerConf.Name
+			if name == "" {
+				name = consumerConf.Durable
+			}
+			c.mu.Lock()
+			c.named[name] = cons
+			if h := conf.Handlers[name]; h != nil {
+				c.handlers[name] = h
+			}
+			c.mu.Unlock()
 		}
 	}
 	return nil
 }
 
-func (c *Consumer) StartConsumers(ctx context.Context, consumeHandler func(msg jetstream.Msg), opts ...jetstream.PullConsumeOpt) error {
-	for _, consumers := range c.Consumers {
-		for _, consumer := range consumers.Consumers {
-			ctx, err := consumer.Consume(consumeHandler, opts...)
-			if err != nil {
-				return err
-			}
-			c.ctxs = append(c.ctxs, ctx)
+// StartConsumers starts pulling on every bound consumer. consumeHandler may be nil
+// when every consumer was bound with its own handler.
+func (c *Consumer) StartConsumers(ctx context.Context, consumeHandler Handler, opts ...jetstream.PullConsumeOpt) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+	c.handler = consumeHandler
+	c.opts = opts
+	c.started = true
+	return c.consume()
+}
+
+// consume starts every consumer that is neither pulling nor stopped.
+func (c *Consumer) consume() error {
+	for name, consumer := range c.named {
+		if c.stopped[name] || c.ctxs[name] != nil {
+			continue
+		}
+		handler := c.handlers[name]
+		if handler == nil {
+			handler = c.handler
+		}
+		if handler == nil {
+			return fmt.Errorf("%w: %s", ErrNoHandler, name)
+		}
+		handler = Chain(handler, c.middlewares...)
+		ctx := c.ctx
+		cc, err := consumer.Consume(func(msg jetstream.Msg) {
+			handler(ctx, msg)
+		}, c.opts...)
+		if err != nil {
+			return err
+		}
+		c.ctxs[name] = cc
+	}
+	return nil
+}
+
+// Pause stops pulling messages on every consumer. messages already handed to
+// the handler are not affected. it is safe to call Pause from inside the handler.
+func (c *Consumer) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cc := range c.ctxs {
+		cc.Stop()
+		delete(c.ctxs, name)
+	}
+}
+
+// Resume starts pulling again with the handlers and options given to StartConsumers.
+// consumers stopped with Stop or StopConsumer stay stopped.
+func (c *Consumer) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return errors.New("consumers were never started")
+	}
+	return c.consume()
+}
+
+// Stop drains every consumer: no new messages are pulled, buffered ones are
+// still handed to the handler. it returns once all of them are done or ctx ends.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	ctxs := make([]jetstream.ConsumeContext, 0, len(c.ctxs))
+	for name := range c.named {
+		c.stopped[name] = true
+		if cc := c.ctxs[name]; cc != nil {
+			ctxs = append(ctxs, cc)
+			delete(c.ctxs, name)
+		}
+	}
+	c.mu.Unlock()
+	// the handler may call Pause while draining, so wait without holding the lock
+	return drainAll(ctx, ctxs)
+}
+
+// StopConsumer drains the consumer called name like Stop, leaving the others running.
+func (c *Consumer) StopConsumer(ctx context.Context, name string) error {
+	c.mu.Lock()
+	if _, ok := c.named[name]; !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownConsumer, name)
+	}
+	c.stopped[name] = true
+	cc := c.ctxs[name]
+	delete(c.ctxs, name)
+	c.mu.Unlock()
+	if cc == nil {
+		return nil
+	}
+	return drainAll(ctx, []jetstream.ConsumeContext{cc})
+}
+
+// Lag is the number of messages waiting to be delivered to every bound consumer,
+// by name, as the server reports them.
+func (c *Consumer) Lag(ctx context.Context) (map[string]uint64, error) {
+	c.mu.Lock()
+	named := make(map[string]jetstream.Consumer, len(c.named))
+	for name, cons := range c.named {
+		named[name] = cons
+	}
+	c.mu.Unlock()
+	lag := make(map[string]uint64, len(named))
+	for name, cons := range named {
+		info, err := cons.Info(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("consumer %s: %w", name, err)
+		}
+		lag[name] = info.NumPending
+	}
+	return lag, nil
+}
+
+func drainAll(ctx context.Context, ctxs []jetstream.ConsumeContext) error {
+	for _, cc := range ctxs {
+		cc.Drain()
+	}
+	for _, cc := range ctxs {
+		select {
+		case <-cc.Closed():
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 	return nil
 }
+
+// Close drains the consumers, waiting up to 30 seconds, then closes the Manager.
+// it is safe to call more than once.
+func (c *Consumer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return errors.Join(c.Stop(ctx), c.Manager.Close())
+}
@@ -0,0 +1,122 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type options struct {
+	streams   []jetstream.StreamConfig
+	ownConn   bool
+	reconcile ReconcileMode
+}
+
+// Option configures a Manager and the Publisher or Consumer built on it.
+type Option func(*options)
+
+// WithStreams creates or updates streams when the Manager is built.
+func WithStreams(streams ...jetstream.StreamConfig) Option {
+	return func(o *options) {
+		o.streams = append(o.streams, streams...)
+	}
+}
+
+// WithOwnedConn makes Close drain the connection too. use it when the
+// connection was dialed just for this Manager and nothing else shares it.
+func WithOwnedConn() Option {
+	return func(o *options) {
+		o.ownConn = true
+	}
+}
+
+// Manager holds a connection, its JetStream context and the streams bound through it.
+type Manager struct {
+	*nats.Conn
+	jetstream.JetStream
+	Streams map[StreamName]jetstream.Stream
+	opts    options
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func NewManager(ctx context.Context, nc *nats.Conn, opts ...Option) (*Manager, error) {
+	jsi, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	return newManager(ctx, nc, jsi, opts...)
+}
+
+func newManager(ctx context.Context, nc *nats.Conn, js jetstream.JetStream, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		Conn:      nc,
+		JetStream: js,
+		Streams:   make(map[StreamName]jetstream.Stream),
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	var err error
+	m.opts.reconcile, err = ParseReconcileMode(string(m.opts.reconcile))
+	if err != nil {
+		return nil, err
+	}
+	err = m.BindStreams(ctx, m.opts.streams...)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BindStreams creates the streams, or reconciles them with their config if they exist.
+func (m *Manager) BindStreams(ctx context.Context, streams ...jetstream.StreamConfig) error {
+	for _, str := range streams {
+		jss, err := m.reconcileStream(ctx, str)
+		if err != nil {
+			return err
+		}
+		m.Streams[str.Name] = jss
+	}
+	return nil
+}
+
+// Close releases the Manager. the connection is only drained and closed when it
+// was handed over with WithOwnedConn, shared connections stay open.
+// calling Close again returns the result of the first call.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		if m.opts.ownConn && m.Conn != nil {
+			m.closeErr = drain(m.Conn)
+		}
+	})
+	return m.closeErr
+}
+
+// drain flushes pending publishes and lets subscriptions finish before closing nc.
+// nats closes the connection itself once its DrainTimeout passes.
+func drain(nc *nats.Conn) error {
+	err := nc.Drain()
+	if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrConnectionReconnecting) {
+		// already closed, or closed by Drain because there was nothing to drain
+		return nil
+	}
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	deadline := time.Now().Add(nc.Opts.DrainTimeout + time.Second)
+	for !nc.IsClosed() {
+		if time.Now().After(deadline) {
+			nc.Close()
+			return nats.ErrDrainTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// HeaderTraceParent is the W3C trace context header publishers may attach
+const HeaderTraceParent = "Traceparent"
+
+// HeaderRequestID carries the id of the http request a message originates from
+const HeaderRequestID = "Sms-Request-Id"
+
+// outcomes of a handled message, as logged and counted by Logger and Metrics
+const (
+	OutcomeAck  = "ack"
+	OutcomeNak  = "nak"
+	OutcomeTerm = "term"
+	// OutcomeNone is a message the handler didn't settle. JetStream redelivers it after AckWait
+	OutcomeNone = "none"
+)
+
+// Middleware wraps a Handler, like gin middlewares wrap routes.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in mws. the first middleware is the outermost one.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Use wraps every handler of c in mws, after the ones added before.
+// it takes effect the next time consumers start, i.e. call it before StartConsumers.
+func (c *Consumer) Use(mws ...Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.middlewares = append(c.middlewares, mws...)
+}
+
+// settleMsg remembers how the handler settled a message so middlewares can act on it.
+type settleMsg struct {
+	jetstream.Msg
+	mu      sync.Mutex
+	outcome string
+}
+
+func (m *settleMsg) set(outcome string, err error) error {
+	if err == nil {
+		m.mu.Lock()
+		m.outcome = outcome
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *settleMsg) Ack() error { return m.set(OutcomeAck, m.Msg.Ack()) }
+func (m *settleMsg) DoubleAck(ctx context.Context) error {
+	return m.set(OutcomeAck, m.Msg.DoubleAck(ctx))
+}
+func (m *settleMsg) Nak() error { return m.set(OutcomeNak, m.Msg.Nak()) }
+func (m *settleMsg) NakWithDelay(delay time.Duration) error {
+	return m.set(OutcomeNak, m.Msg.NakWithDelay(delay))
+}
+func (m *settleMsg) Term() error { return m.set(OutcomeTerm, m.Msg.Term()) }
+func (m *settleMsg) TermWithReason(reason string) error {
+	return m.set(OutcomeTerm, m.Msg.TermWithReason(reason))
+}
+
+func (m *settleMsg) settled() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.outcome == "" {
+		return OutcomeNone
+	}
+	return m.outcome
+}
+
+// tracked wraps msg so its outcome can be read back. messages
+// that are already tracked are returned as is, so every middleware shares one wrapper.
+func tracked(msg jetstream.Msg) *settleMsg {
+	if m, ok := msg.(*settleMsg); ok {
+		return m
+	}
+	return &settleMsg{Msg: msg}
+}
+
+// track makes next see a tracked message and hands the same one to after.
+func track(next Handler, after func(ctx context.Context, msg *settleMsg, took time.Duration)) Handler {
+	return func(ctx context.Context, msg jetstream.Msg) {
+		m := tracked(msg)
+		start := time.Now()
+		next(ctx, m)
+		after(ctx, m, time.Since(start))
+	}
+}
+
+type (
+	traceParentKey struct{}
+	requestIDKey   struct{}
+)
+
+// Trace copies the traceparent and request id headers of a message into ctx, see
+// TraceParent and RequestID.
+func Trace() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) {
+			if h := msg.Headers(); h != nil {
+				if tp := h.Get(HeaderTraceParent); tp != "" {
+					ctx = context.WithValue(ctx, traceParentKey{}, tp)
+				}
+				if id := h.Get(HeaderRequestID); id != "" {
+					ctx = WithRequestID(ctx, id)
+				}
+			}
+			next(ctx, msg)
+		}
+	}
+}
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id extracted by Trace, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// TraceParent returns the traceparent extracted by Trace, if any.
+func TraceParent(ctx context.Context) string {
+	tp, _ := ctx.Value(traceParentKey{}).(string)
+	return tp
+}
+
+// Logger logs every message with its outcome and duration at debug level.
+func Logger() Middleware {
+	return func(next Handler) Handler {
+		return track(next, func(ctx context.Context, msg *settleMsg, took time.Duration) {
+			fields := logrus.Fields{
+				"subject":  msg.Subject(),
+				"outcome":  msg.settled(),
+				"duration": took,
+			}
+			if md, err := msg.Metadata(); err == nil {
+				fields["stream"] = md.Stream
+				fields["sequence"] = md.Sequence.Stream
+				fields["deliveries"] = md.NumDelivered
+			}
+			if tp := TraceParent(ctx); tp != "" {
+				fields["traceparent"] = tp
+			}
+			if id := RequestID(ctx); id != "" {
+				fields["request_id"] = id
+			}
+			logrus.WithFields(fields).Debug("handled message")
+		})
+	}
+}
+
+// Metrics counts messages by subject and outcome and observes how long they took.
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return track(next, func(ctx context.Context, msg *settleMsg, took time.Duration) {
+			metrics.WorkerMessages.WithLabelValues(msg.Subject(), msg.settled()).Inc()
+			metrics.WorkerMessageDuration.WithLabelValues(msg.Subject()).Observe(took.Seconds())
+		})
+	}
+}
+
+// Count adds every handled message to n, whatever its outcome.
+func Count(n *atomic.Uint64) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) {
+			next(ctx, msg)
+			n.Add(1)
+		}
+	}
+}
+
+// SettlePolicy decides what happens to messages handlers leave unsettled.
+type SettlePolicy struct {
+	// MaxDeliveries terminates a message instead of handling it once it was
+	// delivered more often. 0 keeps retrying forever.
+	MaxDeliveries uint64
+	// Backoff is the NAK delay of unsettled messages by delivery, the last one
+	// repeats. unsettled messages are NAKed without delay when it is empty.
+	Backoff []time.Duration
+}
+
+// delay is the backoff of the given delivery, counting from 1.
+func (p SettlePolicy) delay(delivery uint64) time.Duration {
+	if len(p.Backoff) == 0 {
+		return 0
+	}
+	if delivery == 0 {
+		delivery = 1
+	}
+	return p.Backoff[min(delivery, uint64(len(p.Backoff)))-1]
+}
+
+// Settle applies p: messages delivered more than p.MaxDeliveries times are
+// terminated unhandled, and messages the handler didn't settle are NAKed with
+// the backoff of their delivery instead of waiting for AckWait.
+func Settle(p SettlePolicy) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) {
+			m := tracked(msg)
+			var delivery uint64
+			if md, err := m.Metadata(); err == nil {
+				delivery = md.NumDelivered
+			}
+			if p.MaxDeliveries > 0 && delivery > p.MaxDeliveries {
+				err := m.TermWithReason("too many deliveries")
+				if err != nil {
+					logrus.Errorf("failed to terminate msg: %s", err)
+				}
+				return
+			}
+			next(ctx, m)
+			if m.settled() != OutcomeNone {
+				return
+			}
+			err := m.NakWithDelay(p.delay(delivery))
+			if err != nil {
+				logrus.Errorf("failed to NAK unsettled msg: %s", err)
+			}
+		}
+	}
+}
+
+// Recover keeps the consumer alive when a handler panics. it logs the stack, counts
+// the panic and settles the message unless the handler already did: it is NAKed
+// after delay, or terminated once it panicked on its maxDeliveries-th delivery so
+// a message that always panics isn't retried forever. 0 never terminates.
+func Recover(maxDeliveries uint64, delay time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) {
+			m := tracked(msg)
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				metrics.WorkerPanics.WithLabelValues(m.Subject()).Inc()
+				logrus.WithField("subject", m.Subject()).Errorf("handler panicked: %v\n%s", r, debug.Stack())
+				if m.settled() != OutcomeNone {
+					return
+				}
+				var delivery uint64
+				if md, err := m.Metadata(); err == nil {
+					delivery = md.NumDelivered
+				}
+				var err error
+				if maxDeliveries > 0 && delivery >= maxDeliveries {
+					err = m.TermWithReason(fmt.Sprintf("handler panicked: %v", r))
+				} else {
+					err = m.NakWithDelay(delay)
+				}
+				if err != nil {
+					logrus.Errorf("failed to settle msg after panic: %s", err)
+				}
+			}()
+			next(ctx, m)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package nats
 
 import (
 	"fmt"
+
 	"github.com/nats-io/nats.go"
 )
 
+type StreamName = string
+type Subject = string
+
 func Connect(addr string) (*nats.Conn, error) {
 	nc, err := nats.Connect(fmt.Sprintf("nats://%s", addr))
 	if err != nil {
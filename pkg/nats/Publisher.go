@@ -7,31 +7,28 @@ import (
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-type StreamName = string
-type Subject = string
-
+// Publisher publishes to JetStream streams. streams it publishes to are
+// usually bound with WithStreams so publishing never races their creation.
 type Publisher struct {
-	*Base
+	*Manager
 }
 
-func NewSimplePublisher(nc *nats.Conn) (*Publisher, error) {
-	b, err := NewBase(nc)
+func NewPublisher(ctx context.Context, nc *nats.Conn, opts ...Option) (*Publisher, error) {
+	m, err := NewManager(ctx, nc, opts...)
 	if err != nil {
 		return nil, err
 	}
-
 	return &Publisher{
-		Base: b,
+		Manager: m,
 	}, nil
 }
 
-func (sp *Publisher) BindStreams(ctx context.Context, streams ...jetstream.StreamConfig) error {
-	for _, str := range streams {
-		jss, err := sp.CreateOrUpdateStream(ctx, str)
-		if err != nil {
-			return err
-		}
-		sp.Streams[str.Name] = jss
-	}
-	return nil
+// Publish publishes data through JetStream and waits for the stream to store it.
+func (p *Publisher) Publish(ctx context.Context, subject Subject, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return p.JetStream.Publish(ctx, subject, data, opts...)
+}
+
+// PublishMsg is Publish for messages with headers.
+func (p *Publisher) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return p.JetStream.PublishMsg(ctx, msg, opts...)
 }
@@ -0,0 +1,258 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// maxCASRetries bounds how often Reserve retries when other workers update the same key concurrently
+const maxCASRetries = 32
+
+var ErrContended = errors.New("rate limiter key is too contended")
+
+// Rate is a token bucket configuration: PerSecond tokens are added every second up to Burst.
+// once the tokens ran out, up to Credits burst credits may be spent above the rate,
+// which recharge by Recharge every second.
+type Rate struct {
+	PerSecond float64
+	Burst     float64
+	Credits   float64
+	Recharge  float64
+}
+
+// RechargeEvery is the Recharge of credits that recharge one every d.
+func RechargeEvery(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(d)
+}
+
+// Admission is the answer of Admit.
+type Admission struct {
+	OK bool
+	// Credit is set when a burst credit was spent, the tokens had run out
+	Credit bool
+	// Credits is the number of whole burst credits left
+	Credits int
+	// RetryAfter is how long until a refused call would be admitted
+	RetryAfter time.Duration
+}
+
+// bucket is the state stored under each key.
+type bucket struct {
+	Tokens float64 `json:"tokens"`
+	// Credits are the burst credits left
+	Credits float64 `json:"credits,omitempty"`
+	// At is the unix time in nanoseconds Tokens was computed at
+	At int64 `json:"at"`
+}
+
+// refill adds the tokens and credits due up to now.
+func (b bucket) refill(rate Rate, now time.Time) bucket {
+	if b.At == 0 {
+		b.Tokens = rate.Burst
+		b.Credits = rate.Credits
+	} else if elapsed := now.UnixNano() - b.At; elapsed > 0 {
+		seconds := float64(elapsed) / float64(time.Second)
+		b.Tokens = math.Min(rate.Burst, b.Tokens+seconds*rate.PerSecond)
+		b.Credits = math.Min(rate.Credits, b.Credits+seconds*rate.Recharge)
+	}
+	if now.UnixNano() > b.At {
+		b.At = now.UnixNano()
+	}
+	return b
+}
+
+// take refills b up to now and removes one token, or a burst credit once the tokens
+// ran out. tokens may go negative, which reserves a future slot: the returned
+// duration is how long the caller has to wait for it.
+func (b bucket) take(rate Rate, now time.Time) (bucket, time.Duration) {
+	b = b.refill(rate, now)
+	if b.Tokens < 1 && b.Credits >= 1 {
+		b.Credits--
+		return b, 0
+	}
+	b.Tokens--
+	if b.Tokens >= 0 {
+		return b, 0
+	}
+	return b, time.Duration(-b.Tokens / rate.PerSecond * float64(time.Second))
+}
+
+// admit is take for callers that refuse instead of waiting: it never reserves a
+// future slot, a refused call only refills b.
+func (b bucket) admit(rate Rate, now time.Time) (bucket, Admission) {
+	b = b.refill(rate, now)
+	switch {
+	case b.Tokens >= 1:
+		b.Tokens--
+		return b, Admission{OK: true, Credits: int(b.Credits)}
+	case b.Credits >= 1:
+		b.Credits--
+		return b, Admission{OK: true, Credit: true, Credits: int(b.Credits)}
+	}
+	retry := time.Duration((1 - b.Tokens) / rate.PerSecond * float64(time.Second))
+	if rate.Recharge > 0 && rate.Credits >= 1 {
+		retry = min(retry, time.Duration((1-b.Credits)/rate.Recharge*float64(time.Second)))
+	}
+	return b, Admission{RetryAfter: retry}
+}
+
+// RateLimiter hands out the tokens of a token bucket per key.
+type RateLimiter interface {
+	// Reserve takes a token from key and returns how long to wait before using it.
+	Reserve(ctx context.Context, key string, rate Rate) (time.Duration, error)
+	// Admit takes a token from key, or a burst credit once they ran out, and
+	// refuses when there is neither instead of reserving one.
+	Admit(ctx context.Context, key string, rate Rate) (Admission, error)
+}
+
+// MemoryRateLimiter keeps its token buckets in the memory of the process, for
+// workers that don't share their rate limits.
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	now     func() time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]bucket), now: time.Now}
+}
+
+// Reserve takes a token from key and returns how long to wait before using it.
+// callers reserving while the bucket is empty queue up behind each other.
+func (l *MemoryRateLimiter) Reserve(ctx context.Context, key string, rate Rate) (time.Duration, error) {
+	if rate.PerSecond <= 0 {
+		return 0, nil
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, wait := l.buckets[key].take(rate, l.now())
+	l.buckets[key] = state
+	return wait, nil
+}
+
+// Admit takes a token or a burst credit from key, or refuses when there is neither.
+func (l *MemoryRateLimiter) Admit(ctx context.Context, key string, rate Rate) (Admission, error) {
+	if rate.PerSecond <= 0 {
+		return Admission{OK: true}, nil
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, admission := l.buckets[key].admit(rate, l.now())
+	l.buckets[key] = state
+	return admission, nil
+}
+
+// KVRateLimiter is a token bucket shared by every process using the same JetStream
+// KV bucket. each key is updated with compare-and-set on its revision, so concurrent
+// workers never hand out the same token twice.
+type KVRateLimiter struct {
+	kv  jetstream.KeyValue
+	now func() time.Time
+}
+
+// NewKVRateLimiter binds to the KV bucket named bucket, creating it if needed.
+func NewKVRateLimiter(ctx context.Context, js jetstream.JetStream, bucket string) (*KVRateLimiter, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "shared token buckets of the worker rate limiter",
+		History:     1,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KVRateLimiter{kv: kv, now: time.Now}, nil
+}
+
+// Reserve takes a token from key and returns how long to wait before using it.
+func (l *KVRateLimiter) Reserve(ctx context.Context, key string, rate Rate) (time.Duration, error) {
+	if rate.PerSecond <= 0 {
+		return 0, nil
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	var wait time.Duration
+	err := l.update(ctx, key, func(state bucket) bucket {
+		state, wait = state.take(rate, l.now())
+		return state
+	})
+	return wait, err
+}
+
+// Admit takes a token or a burst credit from key, or refuses when there is neither.
+func (l *KVRateLimiter) Admit(ctx context.Context, key string, rate Rate) (Admission, error) {
+	if rate.PerSecond <= 0 {
+		return Admission{OK: true}, nil
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	var admission Admission
+	err := l.update(ctx, key, func(state bucket) bucket {
+		state, admission = state.admit(rate, l.now())
+		return state
+	})
+	return admission, err
+}
+
+// update replaces the bucket of key with what fn makes of it, starting over when
+// another process wrote the key in between.
+func (l *KVRateLimiter) update(ctx context.Context, key string, fn func(bucket) bucket) error {
+	for range maxCASRetries {
+		var state bucket
+		var rev uint64
+		entry, err := l.kv.Get(ctx, key)
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+		case err != nil:
+			return err
+		default:
+			rev = entry.Revision()
+			err = json.Unmarshal(entry.Value(), &state)
+			if err != nil {
+				// overwrite garbage with a fresh bucket
+				state = bucket{}
+			}
+		}
+
+		data, err := json.Marshal(fn(state))
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = l.kv.Create(ctx, key, data)
+		} else {
+			_, err = l.kv.Update(ctx, key, data, rev)
+		}
+		if err == nil {
+			return nil
+		}
+		if !isWrongSequence(err) {
+			return err
+		}
+		// somebody else took a token in between, start over with their state
+	}
+	return ErrContended
+}
+
+// isWrongSequence matches failed compare-and-set writes, including ErrKeyExists from Create.
+func isWrongSequence(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
@@ -0,0 +1,165 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// ReconcileMode decides what happens when a stream or consumer on the server
+// doesn't match the config it is bound with, e.g. because someone edited it by hand.
+type ReconcileMode string
+
+const (
+	// ReconcileApply updates the server to the desired config
+	ReconcileApply ReconcileMode = "apply"
+	// ReconcileRefuse fails with a DriftError and leaves the server untouched
+	ReconcileRefuse ReconcileMode = "refuse"
+)
+
+var ErrReconcileMode = errors.New("unknown reconcile mode")
+
+func ParseReconcileMode(s string) (ReconcileMode, error) {
+	switch m := ReconcileMode(s); m {
+	case ReconcileApply, ReconcileRefuse:
+		return m, nil
+	case "":
+		return ReconcileApply, nil
+	default:
+		return "", fmt.Errorf("%w %q, want %s or %s", ErrReconcileMode, s, ReconcileApply, ReconcileRefuse)
+	}
+}
+
+// WithReconcile sets how drift is handled when binding streams and consumers. defaults to ReconcileApply.
+func WithReconcile(mode ReconcileMode) Option {
+	return func(o *options) {
+		o.reconcile = mode
+	}
+}
+
+// Drift is one field that differs between the desired and the existing config.
+type Drift struct {
+	Field string
+	Want  any
+	Have  any
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: want %v, have %v", d.Field, d.Want, d.Have)
+}
+
+// DriftError is returned by ReconcileRefuse.
+type DriftError struct {
+	// Kind is "stream" or "consumer"
+	Kind   string
+	Name   string
+	Drifts []Drift
+}
+
+func (e *DriftError) Error() string {
+	parts := make([]string, 0, len(e.Drifts))
+	for _, d := range e.Drifts {
+		parts = append(parts, d.String())
+	}
+	return fmt.Sprintf("%s %s drifted from its config: %s", e.Kind, e.Name, strings.Join(parts, "; "))
+}
+
+// zero values of these fields are meaningful, so they are compared even when the desired config leaves them unset
+var (
+	streamAlways   = []string{"Retention", "Storage", "Discard"}
+	consumerAlways = []string{"AckPolicy", "DeliverPolicy"}
+)
+
+// StreamDrift lists the fields of have that differ from want. fields want leaves
+// at their zero value are filled in by the server and only compared when listed in streamAlways.
+func StreamDrift(want, have jetstream.StreamConfig) []Drift {
+	want.Subjects = slices.Sorted(slices.Values(want.Subjects))
+	have.Subjects = slices.Sorted(slices.Values(have.Subjects))
+	return drift(want, have, streamAlways)
+}
+
+// ConsumerDrift is StreamDrift for consumers.
+func ConsumerDrift(want, have jetstream.ConsumerConfig) []Drift {
+	return drift(want, have, consumerAlways)
+}
+
+func drift(want, have any, always []string) []Drift {
+	var drifts []Drift
+	wv, hv := reflect.ValueOf(want), reflect.ValueOf(have)
+	t := wv.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		w, h := wv.Field(i), hv.Field(i)
+		if w.IsZero() && !slices.Contains(always, f.Name) {
+			continue
+		}
+		if !reflect.DeepEqual(w.Interface(), h.Interface()) {
+			drifts = append(drifts, Drift{Field: f.Name, Want: w.Interface(), Have: h.Interface()})
+		}
+	}
+	return drifts
+}
+
+// reconcileStream binds the stream described by want, creating or updating it as the mode allows.
+func (m *Manager) reconcileStream(ctx context.Context, want jetstream.StreamConfig) (jetstream.Stream, error) {
+	existing, err := m.Stream(ctx, want.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return m.CreateOrUpdateStream(ctx, want)
+	}
+	if err != nil {
+		return nil, err
+	}
+	drifts := StreamDrift(want, existing.CachedInfo().Config)
+	if len(drifts) == 0 {
+		return existing, nil
+	}
+	err = m.reportDrift(&DriftError{Kind: "stream", Name: want.Name, Drifts: drifts})
+	if err != nil {
+		return nil, err
+	}
+	return m.CreateOrUpdateStream(ctx, want)
+}
+
+// reconcileConsumer is reconcileStream for consumers.
+func (m *Manager) reconcileConsumer(ctx context.Context, stream string, want jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	name := want.Name
+	if name == "" {
+		name = want.Durable
+	}
+	existing, err := m.Consumer(ctx, stream, name)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return m.CreateOrUpdateConsumer(ctx, stream, want)
+	}
+	if err != nil {
+		return nil, err
+	}
+	drifts := ConsumerDrift(want, existing.CachedInfo().Config)
+	if len(drifts) == 0 {
+		return existing, nil
+	}
+	err = m.reportDrift(&DriftError{Kind: "consumer", Name: stream + "/" + name, Drifts: drifts})
+	if err != nil {
+		return nil, err
+	}
+	return m.CreateOrUpdateConsumer(ctx, stream, want)
+}
+
+// reportDrift logs drift and returns it when the mode refuses to apply it.
+func (m *Manager) reportDrift(d *DriftError) error {
+	if m.opts.reconcile == ReconcileRefuse {
+		return d
+	}
+	for _, diff := range d.Drifts {
+		logrus.Warnf("%s %s drifted from its config, updating %s", d.Kind, d.Name, diff)
+	}
+	return nil
+}
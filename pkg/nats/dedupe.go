@@ -0,0 +1,54 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// KVDedupe remembers keys for window in a JetStream KV bucket shared by every
+// process using it, whichever stream the messages they identify go to.
+type KVDedupe struct {
+	kv jetstream.KeyValue
+}
+
+// NewKVDedupe binds to the KV bucket named bucket, creating it if needed. keys
+// are forgotten window after they were claimed, 2m when it is not positive like
+// the duplicate window of a stream.
+func NewKVDedupe(ctx context.Context, js jetstream.JetStream, bucket string, window time.Duration) (*KVDedupe, error) {
+	if window <= 0 {
+		window = 2 * time.Minute
+	}
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "keys of the messages sent within the dedupe window",
+		History:     1,
+		TTL:         window,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KVDedupe{kv: kv}, nil
+}
+
+// Claim records key and reports false when it was already claimed within the
+// window. concurrent claims of one key are settled by the bucket, one wins.
+func (d *KVDedupe) Claim(ctx context.Context, key string) (bool, error) {
+	_, err := d.kv.Create(ctx, key, nil)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release forgets key, so the message it was claimed for may be sent again
+// when sending it failed.
+func (d *KVDedupe) Release(ctx context.Context, key string) error {
+	return d.kv.Delete(ctx, key)
+}
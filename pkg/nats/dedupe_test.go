@@ -0,0 +1,55 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KVDedupe", func() {
+	var dedupe *KVDedupe
+
+	BeforeEach(func() {
+		dedupe = &KVDedupe{kv: newFakeKV()}
+	})
+
+	It("should let the first claim of a key through", func() {
+		Expect(dedupe.Claim(context.Background(), "a")).To(BeTrue())
+		Expect(dedupe.Claim(context.Background(), "b")).To(BeTrue())
+	})
+
+	It("should refuse a key claimed before", func() {
+		Expect(dedupe.Claim(context.Background(), "a")).To(BeTrue())
+		Expect(dedupe.Claim(context.Background(), "a")).To(BeFalse())
+	})
+
+	It("should let a released key be claimed again", func() {
+		Expect(dedupe.Claim(context.Background(), "a")).To(BeTrue())
+		Expect(dedupe.Release(context.Background(), "a")).To(Succeed())
+		Expect(dedupe.Claim(context.Background(), "a")).To(BeTrue())
+	})
+
+	It("should let one of concurrent claims through", func() {
+		var (
+			wg  sync.WaitGroup
+			won atomic.Int32
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				ok, err := dedupe.Claim(context.Background(), "a")
+				Expect(err).NotTo(HaveOccurred())
+				if ok {
+					won.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(won.Load()).To(Equal(int32(1)))
+	})
+})
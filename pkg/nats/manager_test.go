@@ -0,0 +1,369 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeStream struct {
+	jetstream.Stream
+	cfg jetstream.StreamConfig
+}
+
+func (s *fakeStream) CachedInfo() *jetstream.StreamInfo {
+	return &jetstream.StreamInfo{Config: s.cfg}
+}
+
+type fakeConsumeContext struct {
+	mu      sync.Mutex
+	stopped bool
+	drained bool
+	// stuck keeps Drain from finishing, like a handler that never returns
+	stuck bool
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newFakeConsumeContext() *fakeConsumeContext {
+	return &fakeConsumeContext{done: make(chan struct{})}
+}
+
+func (cc *fakeConsumeContext) Stop() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.stopped = true
+	cc.once.Do(func() { close(cc.done) })
+}
+
+func (cc *fakeConsumeContext) Drain() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.drained = true
+	if !cc.stuck {
+		cc.once.Do(func() { close(cc.done) })
+	}
+}
+
+func (cc *fakeConsumeContext) Closed() <-chan struct{} {
+	return cc.done
+}
+
+func (cc *fakeConsumeContext) Stopped() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.stopped
+}
+
+func (cc *fakeConsumeContext) Drained() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.drained
+}
+
+type fakeConsumer struct {
+	jetstream.Consumer
+	cfg     jetstream.ConsumerConfig
+	handler jetstream.MessageHandler
+	ctxs    []*fakeConsumeContext
+	stuck   bool
+}
+
+func (c *fakeConsumer) CachedInfo() *jetstream.ConsumerInfo {
+	return &jetstream.ConsumerInfo{Config: c.cfg}
+}
+
+func (c *fakeConsumer) Consume(handler jetstream.MessageHandler, opts ...jetstream.PullConsumeOpt) (jetstream.ConsumeContext, error) {
+	c.handler = handler
+	cc := newFakeConsumeContext()
+	cc.stuck = c.stuck
+	c.ctxs = append(c.ctxs, cc)
+	return cc, nil
+}
+
+// fakeJS records the streams, consumers and publishes made through it.
+type fakeJS struct {
+	jetstream.JetStream
+	// streams lists the streams created or updated, in order
+	streams   []string
+	existing  map[string]*fakeStream
+	consumers map[string]*fakeConsumer
+	published []string
+	failOn    string
+}
+
+func newFakeJS() *fakeJS {
+	return &fakeJS{
+		existing:  map[string]*fakeStream{},
+		consumers: map[string]*fakeConsumer{},
+	}
+}
+
+func (js *fakeJS) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	s, ok := js.existing[name]
+	if !ok {
+		return nil, jetstream.ErrStreamNotFound
+	}
+	return s, nil
+}
+
+func (js *fakeJS) CreateOrUpdateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	if cfg.Name == js.failOn {
+		return nil, errors.New("stream failed")
+	}
+	js.streams = append(js.streams, cfg.Name)
+	s := &fakeStream{cfg: cfg}
+	js.existing[cfg.Name] = s
+	return s, nil
+}
+
+func (js *fakeJS) Consumer(ctx context.Context, stream string, name string) (jetstream.Consumer, error) {
+	c, ok := js.consumers[name]
+	if !ok {
+		return nil, jetstream.ErrConsumerNotFound
+	}
+	return c, nil
+}
+
+func (js *fakeJS) CreateOrUpdateConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Durable
+	}
+	c := &fakeConsumer{cfg: cfg}
+	js.consumers[name] = c
+	return c, nil
+}
+
+func (js *fakeJS) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.published = append(js.published, subject)
+	return &jetstream.PubAck{Sequence: uint64(len(js.published))}, nil
+}
+
+func (js *fakeJS) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return js.Publish(ctx, msg.Subject, msg.Data, opts...)
+}
+
+// disconnectedConn returns a real connection that never reached a server,
+// so close semantics can be checked without one.
+func disconnectedConn() *nats.Conn {
+	nc, err := nats.Connect("nats://127.0.0.1:1", nats.RetryOnFailedConnect(true), nats.NoReconnect())
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(nc.Close)
+	return nc
+}
+
+func noop(context.Context, jetstream.Msg) {}
+
+type ctxKey struct{}
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx context.Context
+		js  *fakeJS
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		js = newFakeJS()
+	})
+
+	It("binds the streams given with WithStreams", func() {
+		m, err := newManager(ctx, nil, js, WithStreams(
+			jetstream.StreamConfig{Name: "a"},
+			jetstream.StreamConfig{Name: "b"},
+		))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(js.streams).To(Equal([]string{"a", "b"}))
+		Expect(m.Streams).To(HaveKey("a"))
+		Expect(m.Streams).To(HaveKey("b"))
+	})
+
+	It("fails when a stream can't be bound", func() {
+		js.failOn = "b"
+		_, err := newManager(ctx, nil, js, WithStreams(
+			jetstream.StreamConfig{Name: "a"},
+			jetstream.StreamConfig{Name: "b"},
+		))
+		Expect(err).To(MatchError("stream failed"))
+	})
+
+	It("leaves shared connections open on Close", func() {
+		nc := disconnectedConn()
+		m, err := newManager(ctx, nc, js)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Close()).To(Succeed())
+		Expect(nc.IsClosed()).To(BeFalse())
+	})
+
+	It("closes owned connections on Close", func() {
+		nc := disconnectedConn()
+		m, err := newManager(ctx, nc, js, WithOwnedConn())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Close()).To(Succeed())
+		Expect(nc.IsClosed()).To(BeTrue())
+		Expect(m.Close()).To(Succeed())
+	})
+})
+
+var _ = Describe("Publisher", func() {
+	It("publishes through JetStream", func() {
+		js := newFakeJS()
+		m, err := newManager(context.Background(), nil, js)
+		Expect(err).NotTo(HaveOccurred())
+		p := &Publisher{Manager: m}
+
+		ack, err := p.Publish(context.Background(), "sms.send.request", []byte("{}"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ack.Sequence).To(BeEquivalentTo(1))
+		_, err = p.PublishMsg(context.Background(), &nats.Msg{Subject: "sms.ex.send.request"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(js.published).To(Equal([]string{"sms.send.request", "sms.ex.send.request"}))
+	})
+})
+
+var _ = Describe("Consumer", func() {
+	var (
+		ctx context.Context
+		js  *fakeJS
+		c   *Consumer
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		js = newFakeJS()
+		m, err := newManager(ctx, nil, js)
+		Expect(err).NotTo(HaveOccurred())
+		c = newConsumer(m)
+		err = c.BindConsumers(ctx,
+			&StreamConsumersConfig{
+				Stream:    jetstream.StreamConfig{Name: "normal"},
+				Consumers: []jetstream.ConsumerConfig{{Name: "normal"}},
+			},
+			&StreamConsumersConfig{
+				Stream:    jetstream.StreamConfig{Name: "express"},
+				Consumers: []jetstream.ConsumerConfig{{Name: "express"}},
+			},
+		)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("groups consumers by stream", func() {
+		Expect(c.Consumers).To(HaveLen(2))
+		Expect(c.Consumers["normal"].Consumers).To(HaveLen(1))
+		Expect(c.Consumers["express"].Stream).To(Equal(c.Streams["express"]))
+	})
+
+	It("runs the handler on every consumer", func() {
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+		Expect(js.consumers["normal"].handler).NotTo(BeNil())
+		Expect(js.consumers["express"].handler).NotTo(BeNil())
+	})
+
+	It("routes messages to the handler bound with each consumer", func() {
+		var got []string
+		record := func(name string) Handler {
+			return func(ctx context.Context, msg jetstream.Msg) {
+				Expect(ctx.Value(ctxKey{})).To(Equal("start"))
+				got = append(got, name)
+			}
+		}
+		js = newFakeJS()
+		m, err := newManager(ctx, nil, js)
+		Expect(err).NotTo(HaveOccurred())
+		c = newConsumer(m)
+		Expect(c.BindConsumers(ctx,
+			&StreamConsumersConfig{
+				Stream:    jetstream.StreamConfig{Name: "normal"},
+				Consumers: []jetstream.ConsumerConfig{{Name: "normal"}},
+				Handlers:  map[string]Handler{"normal": record("normal")},
+			},
+			&StreamConsumersConfig{
+				Stream:    jetstream.StreamConfig{Name: "express"},
+				Consumers: []jetstream.ConsumerConfig{{Name: "express"}},
+			},
+		)).To(Succeed())
+
+		startCtx := context.WithValue(ctx, ctxKey{}, "start")
+		Expect(c.StartConsumers(startCtx, record("default"))).To(Succeed())
+		js.consumers["normal"].handler(nil)
+		js.consumers["express"].handler(nil)
+		Expect(got).To(Equal([]string{"normal", "default"}))
+	})
+
+	It("fails to start consumers without a handler", func() {
+		Expect(c.StartConsumers(ctx, nil)).To(MatchError(ErrNoHandler))
+	})
+
+	It("stops and restarts pulling on Pause and Resume", func() {
+		Expect(c.Resume()).To(HaveOccurred())
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+
+		c.Pause()
+		Expect(js.consumers["normal"].ctxs[0].Stopped()).To(BeTrue())
+		Expect(c.Resume()).To(Succeed())
+		Expect(js.consumers["normal"].ctxs).To(HaveLen(2))
+		// resuming twice doesn't consume twice
+		Expect(c.Resume()).To(Succeed())
+		Expect(js.consumers["normal"].ctxs).To(HaveLen(2))
+	})
+
+	It("drains every consumer on Stop and keeps them stopped", func() {
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+		Expect(c.Stop(ctx)).To(Succeed())
+		Expect(js.consumers["normal"].ctxs[0].Drained()).To(BeTrue())
+		Expect(js.consumers["express"].ctxs[0].Drained()).To(BeTrue())
+
+		Expect(c.Resume()).To(Succeed())
+		Expect(js.consumers["normal"].ctxs).To(HaveLen(1))
+		Expect(js.consumers["express"].ctxs).To(HaveLen(1))
+	})
+
+	It("stops a single consumer", func() {
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+		Expect(c.StopConsumer(ctx, "express")).To(Succeed())
+		Expect(js.consumers["express"].ctxs[0].Drained()).To(BeTrue())
+		Expect(js.consumers["normal"].ctxs[0].Drained()).To(BeFalse())
+
+		// a pause and resume restarts only the consumer that wasn't stopped
+		c.Pause()
+		Expect(c.Resume()).To(Succeed())
+		Expect(js.consumers["normal"].ctxs).To(HaveLen(2))
+		Expect(js.consumers["express"].ctxs).To(HaveLen(1))
+
+		Expect(c.StopConsumer(ctx, "missing")).To(MatchError(ErrUnknownConsumer))
+	})
+
+	It("gives up waiting for a drain when ctx ends", func() {
+		js.consumers["normal"].stuck = true
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+
+		stopCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		Expect(c.Stop(stopCtx)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("drains consumers and the owned connection on Close, once", func() {
+		nc := disconnectedConn()
+		m, err := newManager(ctx, nc, js, WithOwnedConn())
+		Expect(err).NotTo(HaveOccurred())
+		c = newConsumer(m)
+		Expect(c.BindConsumers(ctx, &StreamConsumersConfig{
+			Stream:    jetstream.StreamConfig{Name: "normal"},
+			Consumers: []jetstream.ConsumerConfig{{Durable: "normal"}},
+		})).To(Succeed())
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+
+		Expect(c.Close()).To(Succeed())
+		Expect(js.consumers["normal"].ctxs[0].Drained()).To(BeTrue())
+		Expect(nc.IsClosed()).To(BeTrue())
+		Expect(c.Close()).To(Succeed())
+	})
+})
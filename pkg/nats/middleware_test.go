@@ -0,0 +1,222 @@
+package nats
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/metrics"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeMsg records how it was settled.
+type fakeMsg struct {
+	jetstream.Msg
+	subject    string
+	header     nats.Header
+	deliveries uint64
+	settled    []string
+	delay      time.Duration
+}
+
+func (m *fakeMsg) Subject() string      { return m.subject }
+func (m *fakeMsg) Headers() nats.Header { return m.header }
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{Stream: "Sms", NumDelivered: m.deliveries}, nil
+}
+func (m *fakeMsg) Ack() error {
+	m.settled = append(m.settled, OutcomeAck)
+	return nil
+}
+func (m *fakeMsg) Nak() error {
+	m.settled = append(m.settled, OutcomeNak)
+	return nil
+}
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.delay = delay
+	return m.Nak()
+}
+func (m *fakeMsg) TermWithReason(reason string) error {
+	m.settled = append(m.settled, OutcomeTerm)
+	return nil
+}
+
+var _ = Describe("Middleware", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("chains middlewares outermost first", func() {
+		var calls []string
+		mw := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return func(ctx context.Context, msg jetstream.Msg) {
+					calls = append(calls, name)
+					next(ctx, msg)
+				}
+			}
+		}
+		h := Chain(func(context.Context, jetstream.Msg) {
+			calls = append(calls, "handler")
+		}, mw("a"), mw("b"))
+		h(ctx, &fakeMsg{})
+		Expect(calls).To(Equal([]string{"a", "b", "handler"}))
+	})
+
+	It("wraps consumer handlers with the middlewares given to Use", func() {
+		js := newFakeJS()
+		m, err := newManager(ctx, nil, js)
+		Expect(err).NotTo(HaveOccurred())
+		c := newConsumer(m)
+		Expect(c.BindConsumers(ctx, &StreamConsumersConfig{
+			Stream:    jetstream.StreamConfig{Name: "normal"},
+			Consumers: []jetstream.ConsumerConfig{{Name: "normal"}},
+		})).To(Succeed())
+
+		wrapped := false
+		c.Use(func(next Handler) Handler {
+			return func(ctx context.Context, msg jetstream.Msg) {
+				wrapped = true
+				next(ctx, msg)
+			}
+		})
+		Expect(c.StartConsumers(ctx, noop)).To(Succeed())
+		js.consumers["normal"].handler(&fakeMsg{})
+		Expect(wrapped).To(BeTrue())
+	})
+
+	It("extracts the traceparent header", func() {
+		var got string
+		h := Chain(func(ctx context.Context, msg jetstream.Msg) {
+			got = TraceParent(ctx)
+		}, Trace())
+		h(ctx, &fakeMsg{header: nats.Header{HeaderTraceParent: []string{"00-abc-def-01"}}})
+		Expect(got).To(Equal("00-abc-def-01"))
+		h(ctx, &fakeMsg{})
+		Expect(got).To(BeEmpty())
+	})
+
+	It("extracts the request id header", func() {
+		var got string
+		h := Chain(func(ctx context.Context, msg jetstream.Msg) {
+			got = RequestID(ctx)
+		}, Trace())
+		h(ctx, &fakeMsg{header: nats.Header{HeaderRequestID: []string{"req-1"}}})
+		Expect(got).To(Equal("req-1"))
+	})
+
+	It("reports how the handler settled the message", func() {
+		var outcome string
+		observe := func(next Handler) Handler {
+			return track(next, func(ctx context.Context, msg *settleMsg, took time.Duration) {
+				outcome = msg.settled()
+			})
+		}
+		Chain(func(ctx context.Context, msg jetstream.Msg) {
+			msg.TermWithReason("bad input")
+		}, observe)(ctx, &fakeMsg{})
+		Expect(outcome).To(Equal(OutcomeTerm))
+
+		Chain(noop, observe)(ctx, &fakeMsg{})
+		Expect(outcome).To(Equal(OutcomeNone))
+	})
+
+	It("counts messages by subject and outcome", func() {
+		counter := metrics.WorkerMessages.WithLabelValues("test.metrics", OutcomeAck)
+		before := testutil.ToFloat64(counter)
+		Chain(func(ctx context.Context, msg jetstream.Msg) {
+			msg.Ack()
+		}, Metrics())(ctx, &fakeMsg{subject: "test.metrics"})
+		Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+	})
+
+	Context("Recover", func() {
+		boom := func(context.Context, jetstream.Msg) {
+			panic("boom")
+		}
+
+		It("keeps going and naks the message after a panic", func() {
+			counter := metrics.WorkerPanics.WithLabelValues("test.recover")
+			before := testutil.ToFloat64(counter)
+			msg := &fakeMsg{subject: "test.recover", deliveries: 1}
+			Expect(func() {
+				Chain(boom, Recover(3, time.Second))(ctx, msg)
+			}).NotTo(Panic())
+			Expect(msg.settled).To(Equal([]string{OutcomeNak}))
+			Expect(msg.delay).To(Equal(time.Second))
+			Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+		})
+
+		It("terminates messages that keep panicking", func() {
+			msg := &fakeMsg{deliveries: 3}
+			Chain(boom, Recover(3, time.Second))(ctx, msg)
+			Expect(msg.settled).To(Equal([]string{OutcomeTerm}))
+		})
+
+		It("doesn't settle messages the handler settled before panicking", func() {
+			msg := &fakeMsg{deliveries: 1}
+			Chain(func(ctx context.Context, msg jetstream.Msg) {
+				msg.Ack()
+				panic("boom")
+			}, Recover(3, time.Second))(ctx, msg)
+			Expect(msg.settled).To(Equal([]string{OutcomeAck}))
+		})
+
+		It("lets outer middlewares see the outcome", func() {
+			msg := &fakeMsg{deliveries: 1}
+			Chain(boom, Settle(SettlePolicy{}), Recover(0, 0))(ctx, msg)
+			// Settle doesn't NAK again
+			Expect(msg.settled).To(Equal([]string{OutcomeNak}))
+		})
+	})
+
+	Context("Settle", func() {
+		policy := SettlePolicy{
+			MaxDeliveries: 3,
+			Backoff:       []time.Duration{time.Second, 5 * time.Second},
+		}
+
+		It("naks unsettled messages with the backoff of their delivery", func() {
+			h := Chain(noop, Settle(policy))
+			msg := &fakeMsg{deliveries: 1}
+			h(ctx, msg)
+			Expect(msg.settled).To(Equal([]string{OutcomeNak}))
+			Expect(msg.delay).To(Equal(time.Second))
+
+			msg = &fakeMsg{deliveries: 3}
+			h(ctx, msg)
+			Expect(msg.delay).To(Equal(5 * time.Second))
+		})
+
+		It("leaves settled messages alone", func() {
+			msg := &fakeMsg{deliveries: 1}
+			Chain(func(ctx context.Context, msg jetstream.Msg) {
+				msg.Ack()
+			}, Settle(policy))(ctx, msg)
+			Expect(msg.settled).To(Equal([]string{OutcomeAck}))
+		})
+
+		It("terminates messages delivered too often without handling them", func() {
+			handled := false
+			msg := &fakeMsg{deliveries: 4}
+			Chain(func(ctx context.Context, msg jetstream.Msg) {
+				handled = true
+			}, Settle(policy))(ctx, msg)
+			Expect(handled).To(BeFalse())
+			Expect(msg.settled).To(Equal([]string{OutcomeTerm}))
+		})
+
+		It("naks without delay when there is no backoff", func() {
+			msg := &fakeMsg{deliveries: 7}
+			Chain(noop, Settle(SettlePolicy{}))(ctx, msg)
+			Expect(msg.settled).To(Equal([]string{OutcomeNak}))
+			Expect(msg.delay).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,225 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeEntry struct {
+	jetstream.KeyValueEntry
+	value []byte
+	rev   uint64
+}
+
+func (e *fakeEntry) Value() []byte    { return e.value }
+func (e *fakeEntry) Revision() uint64 { return e.rev }
+
+// fakeKV implements the compare-and-set semantics of a JetStream KV bucket in memory.
+type fakeKV struct {
+	jetstream.KeyValue
+	mu      sync.Mutex
+	entries map[string]*fakeEntry
+	seq     uint64
+	// beforeWrite runs once before the next write, simulating a concurrent writer
+	beforeWrite func()
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{entries: map[string]*fakeEntry{}}
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	e, ok := kv.entries[key]
+	if !ok {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return &fakeEntry{value: e.value, rev: e.rev}, nil
+}
+
+func (kv *fakeKV) Create(ctx context.Context, key string, value []byte, _ ...jetstream.KVCreateOpt) (uint64, error) {
+	return kv.Update(ctx, key, value, 0)
+}
+
+func (kv *fakeKV) Delete(ctx context.Context, key string, _ ...jetstream.KVDeleteOpt) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.entries, key)
+	return nil
+}
+
+func (kv *fakeKV) Update(ctx context.Context, key string, value []byte, rev uint64) (uint64, error) {
+	if f := kv.beforeWrite; f != nil {
+		kv.beforeWrite = nil
+		f()
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var last uint64
+	if e, ok := kv.entries[key]; ok {
+		last = e.rev
+	}
+	if last != rev {
+		return 0, jetstream.ErrKeyExists
+	}
+	kv.seq++
+	kv.entries[key] = &fakeEntry{value: value, rev: kv.seq}
+	return kv.seq, nil
+}
+
+var _ = Describe("KVRateLimiter", func() {
+	var (
+		kv      *fakeKV
+		limiter *KVRateLimiter
+		now     time.Time
+		rate    = Rate{PerSecond: 2, Burst: 2}
+	)
+
+	BeforeEach(func() {
+		kv = newFakeKV()
+		now = time.Unix(1700000000, 0)
+		limiter = &KVRateLimiter{kv: kv, now: func() time.Time { return now }}
+	})
+
+	reserve := func() time.Duration {
+		wait, err := limiter.Reserve(context.Background(), "sms.normal.default", rate)
+		Expect(err).ToNot(HaveOccurred())
+		return wait
+	}
+
+	It("should allow the burst and then space tokens by the rate", func() {
+		Expect(reserve()).To(BeZero())
+		Expect(reserve()).To(BeZero())
+		Expect(reserve()).To(Equal(500 * time.Millisecond))
+		Expect(reserve()).To(Equal(time.Second))
+	})
+
+	It("should refill tokens over time", func() {
+		reserve()
+		reserve()
+		now = now.Add(time.Second)
+		Expect(reserve()).To(BeZero())
+		Expect(reserve()).To(BeZero())
+		Expect(reserve()).To(Equal(500 * time.Millisecond))
+	})
+
+	It("should retry when another worker took a token concurrently", func() {
+		reserve()
+		kv.beforeWrite = func() {
+			other := &KVRateLimiter{kv: kv, now: limiter.now}
+			_, err := other.Reserve(context.Background(), "sms.normal.default", rate)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		// the other worker got the second token of the burst
+		Expect(reserve()).To(Equal(500 * time.Millisecond))
+	})
+
+	It("should keep keys apart", func() {
+		reserve()
+		reserve()
+		wait, err := limiter.Reserve(context.Background(), "sms.express.default", rate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wait).To(BeZero())
+	})
+
+	It("should admit with burst credits and refuse once they ran out", func() {
+		quota := Rate{PerSecond: 1, Burst: 1, Credits: 1, Recharge: 0.1}
+		admit := func() Admission {
+			a, err := limiter.Admit(context.Background(), "quota.1.normal", quota)
+			Expect(err).ToNot(HaveOccurred())
+			return a
+		}
+		Expect(admit()).To(Equal(Admission{OK: true, Credits: 1}))
+		Expect(admit()).To(Equal(Admission{OK: true, Credit: true}))
+		Expect(admit()).To(Equal(Admission{RetryAfter: time.Second}))
+		now = now.Add(time.Second)
+		Expect(admit()).To(Equal(Admission{OK: true}))
+	})
+})
+
+var _ = Describe("MemoryRateLimiter", func() {
+	var (
+		limiter *MemoryRateLimiter
+		now     time.Time
+		// one sms every 100ms, like sms.<priority>.ratelimit: 100
+		rate = Rate{PerSecond: 10, Burst: 1}
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		limiter = NewMemoryRateLimiter()
+		limiter.now = func() time.Time { return now }
+	})
+
+	reserve := func(key string) time.Duration {
+		wait, err := limiter.Reserve(context.Background(), key, rate)
+		Expect(err).ToNot(HaveOccurred())
+		return wait
+	}
+
+	It("should space messages by the rate", func() {
+		Expect(reserve("sms.normal.default")).To(BeZero())
+		Expect(reserve("sms.normal.default")).To(Equal(100 * time.Millisecond))
+		Expect(reserve("sms.normal.default")).To(Equal(200 * time.Millisecond))
+		now = now.Add(time.Second)
+		Expect(reserve("sms.normal.default")).To(BeZero())
+	})
+
+	It("should give every priority a bucket of its own", func() {
+		Expect(reserve("sms.normal.default")).To(BeZero())
+		Expect(reserve("sms.normal.default")).To(Equal(100 * time.Millisecond))
+		Expect(reserve("sms.express.default")).To(BeZero())
+	})
+
+	It("should spend burst credits before waiting and recharge them", func() {
+		burst := Rate{PerSecond: 10, Burst: 1, Credits: 2, Recharge: 1}
+		for range 3 {
+			wait, err := limiter.Reserve(context.Background(), "sms.normal.default", burst)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wait).To(BeZero())
+		}
+		wait, err := limiter.Reserve(context.Background(), "sms.normal.default", burst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wait).To(Equal(100 * time.Millisecond))
+
+		now = now.Add(2 * time.Second)
+		for range 3 {
+			wait, err = limiter.Reserve(context.Background(), "sms.normal.default", burst)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wait).To(BeZero())
+		}
+	})
+
+	It("should tell when a refused call is admitted", func() {
+		quota := Rate{PerSecond: 0.5, Burst: 1, Credits: 1, Recharge: 1}
+		for range 2 {
+			a, err := limiter.Admit(context.Background(), "quota.1.normal", quota)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a.OK).To(BeTrue())
+		}
+		a, err := limiter.Admit(context.Background(), "quota.1.normal", quota)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.OK).To(BeFalse())
+		// the credit recharges before the next token
+		Expect(a.RetryAfter).To(Equal(time.Second))
+		now = now.Add(time.Second)
+		a, err = limiter.Admit(context.Background(), "quota.1.normal", quota)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a).To(Equal(Admission{OK: true, Credit: true}))
+	})
+
+	It("should not limit without a rate", func() {
+		for range 3 {
+			wait, err := limiter.Reserve(context.Background(), "sms.normal.default", Rate{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(wait).To(BeZero())
+		}
+	})
+})
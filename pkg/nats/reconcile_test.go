@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var _ = Describe("Reconcile", func() {
+	var (
+		ctx     context.Context
+		js      *fakeJS
+		desired jetstream.StreamConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		js = newFakeJS()
+		desired = jetstream.StreamConfig{
+			Name:       "Sms",
+			Subjects:   []string{"sms.send.request", "sms.send.status"},
+			Retention:  jetstream.WorkQueuePolicy,
+			Duplicates: time.Minute,
+		}
+	})
+
+	Context("StreamDrift", func() {
+		It("ignores fields the server fills in and the order of subjects", func() {
+			have := desired
+			have.Subjects = []string{"sms.send.status", "sms.send.request"}
+			have.MaxMsgs = -1
+			have.Replicas = 1
+			Expect(StreamDrift(desired, have)).To(BeEmpty())
+		})
+
+		It("reports changed fields", func() {
+			have := desired
+			have.Duplicates = 2 * time.Minute
+			have.Storage = jetstream.MemoryStorage
+			Expect(StreamDrift(desired, have)).To(ConsistOf(
+				Drift{Field: "Storage", Want: jetstream.FileStorage, Have: jetstream.MemoryStorage},
+				Drift{Field: "Duplicates", Want: time.Minute, Have: 2 * time.Minute},
+			))
+		})
+	})
+
+	It("reports changed consumer fields", func() {
+		want := jetstream.ConsumerConfig{Durable: "Sms", AckWait: time.Minute}
+		have := want
+		have.AckWait = time.Second
+		have.AckPolicy = jetstream.AckNonePolicy
+		Expect(ConsumerDrift(want, have)).To(HaveLen(2))
+	})
+
+	It("rejects unknown modes", func() {
+		_, err := newManager(ctx, nil, js, WithReconcile("maybe"))
+		Expect(err).To(MatchError(ErrReconcileMode))
+	})
+
+	It("leaves matching streams alone", func() {
+		js.existing["Sms"] = &fakeStream{cfg: desired}
+		m, err := newManager(ctx, nil, js, WithReconcile(ReconcileRefuse), WithStreams(desired))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(js.streams).To(BeEmpty())
+		Expect(m.Streams).To(HaveKey("Sms"))
+	})
+
+	It("updates drifted streams when applying", func() {
+		edited := desired
+		edited.Duplicates = 0
+		js.existing["Sms"] = &fakeStream{cfg: edited}
+		_, err := newManager(ctx, nil, js, WithStreams(desired))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(js.streams).To(Equal([]string{"Sms"}))
+		Expect(js.existing["Sms"].cfg.Duplicates).To(Equal(time.Minute))
+	})
+
+	It("refuses to update drifted streams", func() {
+		edited := desired
+		edited.Subjects = []string{"sms.send.request"}
+		js.existing["Sms"] = &fakeStream{cfg: edited}
+		_, err := newManager(ctx, nil, js, WithReconcile(ReconcileRefuse), WithStreams(desired))
+		var drift *DriftError
+		Expect(err).To(BeAssignableToTypeOf(drift))
+		Expect(err.Error()).To(ContainSubstring("stream Sms drifted"))
+		Expect(js.streams).To(BeEmpty())
+	})
+
+	It("refuses to update drifted consumers", func() {
+		m, err := newManager(ctx, nil, js, WithReconcile(ReconcileRefuse))
+		Expect(err).NotTo(HaveOccurred())
+		js.consumers["Sms"] = &fakeConsumer{cfg: jetstream.ConsumerConfig{Durable: "Sms", AckWait: time.Second}}
+		err = newConsumer(m).BindConsumers(ctx, &StreamConsumersConfig{
+			Stream:    desired,
+			Consumers: []jetstream.ConsumerConfig{{Durable: "Sms", AckWait: time.Minute}},
+		})
+		Expect(err).To(MatchError(ContainSubstring("consumer Sms/Sms drifted")))
+		Expect(js.consumers["Sms"].cfg.AckWait).To(Equal(time.Second))
+	})
+})
@@ -0,0 +1,118 @@
+package phone
+
+import (
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// countryZones maps E.164 country calling codes to the timezone most of the
+// country's population lives in. countries spanning several zones get their
+// most populous one.
+var countryZones = map[string]string{
+	"1":   "America/New_York",
+	"7":   "Europe/Moscow",
+	"20":  "Africa/Cairo",
+	"27":  "Africa/Johannesburg",
+	"30":  "Europe/Athens",
+	"31":  "Europe/Amsterdam",
+	"32":  "Europe/Brussels",
+	"33":  "Europe/Paris",
+	"34":  "Europe/Madrid",
+	"36":  "Europe/Budapest",
+	"39":  "Europe/Rome",
+	"40":  "Europe/Bucharest",
+	"41":  "Europe/Zurich",
+	"43":  "Europe/Vienna",
+	"44":  "Europe/London",
+	"45":  "Europe/Copenhagen",
+	"46":  "Europe/Stockholm",
+	"47":  "Europe/Oslo",
+	"48":  "Europe/Warsaw",
+	"49":  "Europe/Berlin",
+	"51":  "America/Lima",
+	"52":  "America/Mexico_City",
+	"54":  "America/Argentina/Buenos_Aires",
+	"55":  "America/Sao_Paulo",
+	"56":  "America/Santiago",
+	"57":  "America/Bogota",
+	"60":  "Asia/Kuala_Lumpur",
+	"61":  "Australia/Sydney",
+	"62":  "Asia/Jakarta",
+	"63":  "Asia/Manila",
+	"64":  "Pacific/Auckland",
+	"65":  "Asia/Singapore",
+	"66":  "Asia/Bangkok",
+	"81":  "Asia/Tokyo",
+	"82":  "Asia/Seoul",
+	"84":  "Asia/Ho_Chi_Minh",
+	"86":  "Asia/Shanghai",
+	"90":  "Europe/Istanbul",
+	"91":  "Asia/Kolkata",
+	"92":  "Asia/Karachi",
+	"93":  "Asia/Kabul",
+	"94":  "Asia/Colombo",
+	"95":  "Asia/Yangon",
+	"98":  "Asia/Tehran",
+	"212": "Africa/Casablanca",
+	"213": "Africa/Algiers",
+	"216": "Africa/Tunis",
+	"234": "Africa/Lagos",
+	"254": "Africa/Nairobi",
+	"351": "Europe/Lisbon",
+	"353": "Europe/Dublin",
+	"358": "Europe/Helsinki",
+	"380": "Europe/Kyiv",
+	"420": "Europe/Prague",
+	"880": "Asia/Dhaka",
+	"964": "Asia/Baghdad",
+	"965": "Asia/Kuwait",
+	"966": "Asia/Riyadh",
+	"968": "Asia/Muscat",
+	"971": "Asia/Dubai",
+	"972": "Asia/Jerusalem",
+	"974": "Asia/Qatar",
+}
+
+// Normalize strips formatting characters and the international prefix,
+// returning only the digits of an E.164 number.
+func Normalize(number string) string {
+	number = strings.TrimSpace(number)
+	number = strings.TrimPrefix(number, "+")
+	number = strings.TrimPrefix(number, "00")
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// CountryCode returns the calling code of number or "" if it is unknown.
+func CountryCode(number string) string {
+	digits := Normalize(number)
+	for n := 3; n > 0; n-- {
+		if len(digits) < n {
+			continue
+		}
+		if _, ok := countryZones[digits[:n]]; ok {
+			return digits[:n]
+		}
+	}
+	return ""
+}
+
+// Timezone infers the destination timezone of number from its country code.
+// unknown numbers are treated as UTC.
+func Timezone(number string) *time.Location {
+	name, ok := countryZones[CountryCode(number)]
+	if !ok {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,13 @@
+package phone_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPhone(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Phone Suite")
+}
@@ -0,0 +1,36 @@
+package phone_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/phone"
+)
+
+var _ = Describe("Phone", func() {
+	Context("Normalize", func() {
+		It("should strip formatting and international prefixes", func() {
+			Expect(Normalize("+49 151-1234 5678")).To(Equal("4915112345678"))
+			Expect(Normalize("0049151")).To(Equal("49151"))
+		})
+	})
+	Context("CountryCode", func() {
+		It("should prefer the longest matching code", func() {
+			Expect(CountryCode("+971501234567")).To(Equal("971"))
+			Expect(CountryCode("+79161234567")).To(Equal("7"))
+		})
+		It("should return empty for unknown codes", func() {
+			Expect(CountryCode("+999")).To(BeEmpty())
+		})
+	})
+	Context("Timezone", func() {
+		It("should fall back to UTC", func() {
+			Expect(Timezone("+999")).To(Equal(time.UTC))
+		})
+		It("should infer the zone from the country code", func() {
+			Expect(Timezone("+989121234567").String()).To(Equal("Asia/Tehran"))
+		})
+	})
+})
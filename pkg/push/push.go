@@ -0,0 +1,46 @@
+package push
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Notification is a push notification to one device.
+type Notification struct {
+	Token string `json:"token"`
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+}
+
+// Sender delivers push notifications through a provider.
+type Sender interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// FromViper builds the driver selected by push.driver: "log" (default) or "webhook".
+func FromViper(ctx context.Context) (Sender, error) {
+	switch driver := viper.GetString("push.driver"); driver {
+	case "", "log":
+		return Log{}, nil
+	case "webhook":
+		token, err := secrets.Get(ctx, "push.webhook.token")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push.webhook.token: %w", err)
+		}
+		return NewWebhook(viper.GetString("push.webhook.url"), token)
+	default:
+		return nil, fmt.Errorf("unknown push driver %q", driver)
+	}
+}
+
+// Log only logs the title of every notification. it is meant for development and never fails.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, n Notification) error {
+	logrus.WithField("title", n.Title).Info("push notification not sent, push.driver is log")
+	return nil
+}
@@ -0,0 +1,13 @@
+package push_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPush(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Push Suite")
+}
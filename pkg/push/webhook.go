@@ -0,0 +1,61 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Webhook hands notifications to a push relay, e.g. a service fronting FCM and APNs,
+// by posting them as JSON. the relay must answer 2xx once it accepted the notification.
+type Webhook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewWebhook posts to rawURL. token, when set, is sent as a bearer token.
+func NewWebhook(rawURL, token string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid push webhook url %q", rawURL)
+	}
+	return &Webhook{
+		url:    rawURL,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (w *Webhook) Send(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("push webhook: %s: %s", res.Status, bytes.TrimSpace(msg))
+}
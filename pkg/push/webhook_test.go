@@ -0,0 +1,53 @@
+package push_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/push"
+)
+
+var _ = Describe("Webhook", func() {
+	n := push.Notification{Token: "device-1", Title: "Login", Body: "code: 1234"}
+
+	It("posts the notification with the bearer token", func() {
+		var (
+			got  push.Notification
+			auth string
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			auth = r.Header.Get("Authorization")
+			Expect(json.NewDecoder(r.Body).Decode(&got)).To(Succeed())
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		DeferCleanup(srv.Close)
+
+		w, err := push.NewWebhook(srv.URL, "secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Send(context.Background(), n)).To(Succeed())
+		Expect(got).To(Equal(n))
+		Expect(auth).To(Equal("Bearer secret"))
+	})
+
+	It("fails when the relay rejects the notification", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unregistered token", http.StatusGone)
+		}))
+		DeferCleanup(srv.Close)
+
+		w, err := push.NewWebhook(srv.URL, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Send(context.Background(), n)).To(MatchError(ContainSubstring("unregistered token")))
+	})
+
+	It("rejects relative urls", func() {
+		_, err := push.NewWebhook("/push", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,351 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNoMasterKey = errors.New("no master key configured, see secrets.envelope")
+	ErrDecrypt     = errors.New("failed to decrypt")
+)
+
+// KeyWrapper encrypts the data keys of an Envelope with a master key that never
+// leaves it. wrapped keys start with its scheme and name the master key they were
+// wrapped with, so they can be unwrapped after the master key is rotated.
+type KeyWrapper interface {
+	Scheme() string
+	Wrap(ctx context.Context, key []byte) (string, error)
+	Unwrap(ctx context.Context, wrapped string) ([]byte, error)
+}
+
+// Sealed is a value encrypted by an Envelope.
+type Sealed struct {
+	// Ciphertext is the value encrypted with a data key of its own
+	Ciphertext []byte
+	// DataKey is the data key, wrapped with the master key
+	DataKey string
+}
+
+// Envelope encrypts values with a random data key each, and the data keys with
+// the master key of wrappers[0]. the others only unwrap the keys sealed before a
+// change of master key, until the values are sealed again.
+type Envelope struct {
+	wrappers []KeyWrapper
+}
+
+func NewEnvelope(wrappers ...KeyWrapper) *Envelope {
+	return &Envelope{wrappers: wrappers}
+}
+
+// EnvelopeFromViper configures the master keys from secrets.envelope: master, "local"
+// or "vault", wraps the new data keys, the other one, when configured, still
+// unwraps the old ones. it returns nil when no master is set.
+func EnvelopeFromViper(ctx context.Context) (*Envelope, error) {
+	master := viper.GetString("secrets.envelope.master")
+	if master == "" {
+		return nil, nil
+	}
+	wrappers := make([]KeyWrapper, 0, 2)
+	for _, scheme := range []string{"local", "vault"} {
+		if scheme != master && !viper.IsSet("secrets.envelope."+scheme) {
+			continue
+		}
+		var w KeyWrapper
+		var err error
+		switch scheme {
+		case "local":
+			w, err = localKeysFromViper(ctx)
+		case "vault":
+			w, err = vaultTransitFromViper()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if scheme == master {
+			wrappers = append([]KeyWrapper{w}, wrappers...)
+		} else {
+			wrappers = append(wrappers, w)
+		}
+	}
+	if len(wrappers) == 0 || wrappers[0].Scheme() != master {
+		return nil, fmt.Errorf("unknown secrets.envelope.master %q", master)
+	}
+	return NewEnvelope(wrappers...), nil
+}
+
+// Seal encrypts plaintext. aad, e.g. the id of the row it is stored in, has to be
+// passed to Open again, so sealed values can't be swapped.
+func (e *Envelope) Seal(ctx context.Context, plaintext, aad []byte) (Sealed, error) {
+	if e == nil || len(e.wrappers) == 0 {
+		return Sealed{}, ErrNoMasterKey
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return Sealed{}, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := e.wrappers[0].Wrap(ctx, key)
+	if err != nil {
+		return Sealed{}, fmt.Errorf("failed to wrap the data key: %w", err)
+	}
+	return Sealed{
+		Ciphertext: seal(aead, plaintext, aad),
+		DataKey:    wrapped,
+	}, nil
+}
+
+// Open decrypts s, sealed with aad.
+func (e *Envelope) Open(ctx context.Context, s Sealed, aad []byte) ([]byte, error) {
+	key, err := e.unwrap(ctx, s.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, s.Ciphertext, aad)
+}
+
+// Rewrap wraps the data key of s with the current master key, the ciphertext
+// stays as it is.
+func (e *Envelope) Rewrap(ctx context.Context, s Sealed) (Sealed, error) {
+	key, err := e.unwrap(ctx, s.DataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	s.DataKey, err = e.wrappers[0].Wrap(ctx, key)
+	if err != nil {
+		return Sealed{}, fmt.Errorf("failed to wrap the data key: %w", err)
+	}
+	return s, nil
+}
+
+func (e *Envelope) unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	if e == nil {
+		return nil, ErrNoMasterKey
+	}
+	scheme, _, _ := strings.Cut(wrapped, ":")
+	for _, w := range e.wrappers {
+		if w.Scheme() != scheme {
+			continue
+		}
+		key, err := w.Unwrap(ctx, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: no %q master key", ErrNoMasterKey, scheme)
+}
+
+// Current reports whether s was sealed with the current master key.
+func (e *Envelope) Current(s Sealed) bool {
+	if e == nil || len(e.wrappers) == 0 {
+		return false
+	}
+	w := e.wrappers[0]
+	if l, ok := w.(*LocalKeys); ok {
+		return strings.HasPrefix(s.DataKey, "local:"+l.current+":")
+	}
+	return strings.HasPrefix(s.DataKey, w.Scheme()+":")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal is the nonce followed by the ciphertext of plaintext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// LocalKeys wraps data keys with AES-256-GCM master keys held in the config, by id.
+// keys are "local:<id>:<base64 of the sealed key>".
+type LocalKeys struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeys wraps with the key current of keys, 32 bytes each.
+func NewLocalKeys(current string, keys map[string][]byte) (*LocalKeys, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("no local master key %q", current)
+	}
+	l := &LocalKeys{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(key) != 32 || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("local master key %q must be 32 bytes with an id without ':'", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		l.keys[id] = aead
+	}
+	return l, nil
+}
+
+// localKeysFromViper reads secrets.envelope.local: keys, by id the base64 of every
+// key or a reference to it, and current, the id of the one wrapping new keys.
+func localKeysFromViper(ctx context.Context) (*LocalKeys, error) {
+	keys := make(map[string][]byte)
+	for id := range viper.GetStringMap("secrets.envelope.local.keys") {
+		value, err := Get(ctx, "secrets.envelope.local.keys."+id)
+		if err != nil {
+			return nil, err
+		}
+		keys[id], err = base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("local master key %q is not base64: %w", id, err)
+		}
+	}
+	return NewLocalKeys(viper.GetString("secrets.envelope.local.current"), keys)
+}
+
+func (l *LocalKeys) Scheme() string {
+	return "local"
+}
+
+func (l *LocalKeys) Wrap(ctx context.Context, key []byte) (string, error) {
+	sealed := seal(l.keys[l.current], key, []byte(l.current))
+	return "local:" + l.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (l *LocalKeys) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	parts := strings.SplitN(wrapped, ":", 3)
+	if len(parts) != 3 || parts[0] != "local" {
+		return nil, ErrDecrypt
+	}
+	aead, ok := l.keys[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("%w: no local master key %q", ErrDecrypt, parts[1])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return open(aead, sealed, []byte(parts[1]))
+}
+
+// VaultTransit wraps data keys with a key of Vault's transit secrets engine, the
+// master key never leaves Vault. wrapped keys are Vault's ciphertexts,
+// "vault:v<version>:...", which Vault decrypts with every version of the key.
+type VaultTransit struct {
+	*Vault
+	// Mount is the path the engine is mounted at, "transit" by default
+	Mount string
+	Key   string
+}
+
+// vaultTransitFromViper reads secrets.envelope.vault.{mount,key}, the connection
+// is the one of the vault: references.
+func vaultTransitFromViper() (*VaultTransit, error) {
+	v, err := NewVaultFromViper()
+	if err != nil {
+		return nil, err
+	}
+	key := viper.GetString("secrets.envelope.vault.key")
+	if key == "" {
+		return nil, errors.New("secrets.envelope.vault.key is not configured")
+	}
+	mount := viper.GetString("secrets.envelope.vault.mount")
+	if mount == "" {
+		mount = "transit"
+	}
+	return &VaultTransit{Vault: v, Mount: mount, Key: key}, nil
+}
+
+func (v *VaultTransit) Scheme() string {
+	return "vault"
+}
+
+func (v *VaultTransit) Wrap(ctx context.Context, key []byte) (string, error) {
+	var res struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.transit(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &res)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(res.Ciphertext, "vault:") {
+		return "", fmt.Errorf("unexpected ciphertext from vault")
+	}
+	return res.Ciphertext, nil
+}
+
+func (v *VaultTransit) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	var res struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := v.transit(ctx, "decrypt", map[string]string{"ciphertext": wrapped}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Plaintext)
+}
+
+// transit posts body to the op endpoint of the key and reads the data of the
+// response into out.
+func (v *VaultTransit) transit(ctx context.Context, op string, body map[string]string, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", v.Address, strings.Trim(v.Mount, "/"), op, v.Key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault responded with %s to %s with %s", res.Status, op, v.Key)
+	}
+	var wrapper struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&wrapper)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(wrapper.Data, out)
+}
@@ -0,0 +1,122 @@
+package secrets_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	. "github.com/alireza-karampour/sms/pkg/secrets"
+)
+
+var _ = Describe("Envelope", func() {
+	var ctx context.Context
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		viper.Reset()
+	})
+
+	local := func(current string) *LocalKeys {
+		l, err := NewLocalKeys(current, map[string][]byte{"k1": key1, "k2": key2})
+		Expect(err).NotTo(HaveOccurred())
+		return l
+	}
+
+	It("should seal values with a data key wrapped by the master key", func() {
+		env := NewEnvelope(local("k1"))
+		s, err := env.Seal(ctx, []byte(`{"api_key":"abc"}`), []byte("row 1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.DataKey).To(HavePrefix("local:k1:"))
+		Expect(string(s.Ciphertext)).NotTo(ContainSubstring("abc"))
+		Expect(env.Current(s)).To(BeTrue())
+
+		Expect(env.Open(ctx, s, []byte("row 1"))).To(Equal([]byte(`{"api_key":"abc"}`)))
+		_, err = env.Open(ctx, s, []byte("row 2"))
+		Expect(err).To(MatchError(ErrDecrypt))
+	})
+
+	It("should rewrap data keys with a rotated master key", func() {
+		s, err := NewEnvelope(local("k1")).Seal(ctx, []byte("secret"), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		env := NewEnvelope(local("k2"))
+		Expect(env.Current(s)).To(BeFalse())
+		Expect(env.Open(ctx, s, nil)).To(Equal([]byte("secret")))
+		rewrapped, err := env.Rewrap(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rewrapped.DataKey).To(HavePrefix("local:k2:"))
+		Expect(rewrapped.Ciphertext).To(Equal(s.Ciphertext))
+		Expect(env.Open(ctx, rewrapped, nil)).To(Equal([]byte("secret")))
+
+		retired, err := NewLocalKeys("k2", map[string][]byte{"k2": key2})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = NewEnvelope(retired).Open(ctx, s, nil)
+		Expect(err).To(MatchError(ErrDecrypt))
+	})
+
+	It("should refuse to seal without a master key", func() {
+		var env *Envelope
+		_, err := env.Seal(ctx, []byte("secret"), nil)
+		Expect(err).To(MatchError(ErrNoMasterKey))
+		_, err = NewLocalKeys("k1", map[string][]byte{"k1": []byte("short")})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should wrap data keys with vault transit", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.Header.Get("X-Vault-Token") != "root" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// a reversible stand-in for the transit key
+			switch r.URL.Path {
+			case "/v1/transit/encrypt/sms":
+				json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+			case "/v1/transit/decrypt/sms":
+				json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer srv.Close()
+		transit := &VaultTransit{Vault: &Vault{Address: srv.URL, Token: "root", Client: srv.Client()}, Mount: "transit", Key: "sms"}
+
+		s, err := NewEnvelope(local("k1")).Seal(ctx, []byte("secret"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		env := NewEnvelope(transit, local("k1"))
+		s, err = env.Rewrap(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.DataKey).To(HavePrefix("vault:v1:"))
+		Expect(env.Current(s)).To(BeTrue())
+		Expect(env.Open(ctx, s, nil)).To(Equal([]byte("secret")))
+	})
+
+	It("should configure the master keys from viper", func() {
+		Expect(EnvelopeFromViper(ctx)).To(BeNil())
+
+		viper.Set("secrets.envelope.master", "local")
+		viper.Set("secrets.envelope.local.current", "k1")
+		viper.Set("secrets.envelope.local.keys.k1", base64.StdEncoding.EncodeToString(key1))
+		env, err := EnvelopeFromViper(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		s, err := env.Seal(ctx, []byte("secret"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.DataKey).To(HavePrefix("local:k1:"))
+
+		viper.Set("secrets.envelope.master", "kms")
+		_, err = EnvelopeFromViper(ctx)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes reads Secret objects from the cluster API with the pod's service account.
+type Kubernetes struct {
+	Host string
+	// Namespace is used for references without one
+	Namespace string
+	Token     string
+	Client    *http.Client
+}
+
+// NewKubernetes configures the in-cluster API client.
+func NewKubernetes() (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside kubernetes")
+	}
+	token, err := readFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := readFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse kubernetes ca certificate")
+	}
+	return &Kubernetes{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		Namespace: namespace,
+		Token:     token,
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// Get returns the key field of the Secret at path, which is either <name> or <namespace>/<name>.
+func (k *Kubernetes) Get(ctx context.Context, path, field string) (string, error) {
+	namespace, name, ok := strings.Cut(path, "/")
+	if !ok {
+		namespace, name = k.Namespace, path
+	}
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.Host, namespace, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.Token)
+	res, err := k.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: k8s:%s", ErrNotFound, path)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kubernetes responded with %s for secret %s", res.Status, path)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&secret)
+	if err != nil {
+		return "", err
+	}
+	encoded, ok := secret.Data[field]
+	if !ok {
+		return "", fmt.Errorf("%w: k8s:%s#%s", ErrNotFound, path, field)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNotFound        = errors.New("secret not found")
+	ErrUnknownProvider = errors.New("unknown secret provider")
+)
+
+// Provider fetches a field of a secret stored in an external secret store.
+type Provider interface {
+	Get(ctx context.Context, path, field string) (string, error)
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]func() (Provider, error){
+		"vault": func() (Provider, error) { return NewVaultFromViper() },
+		"k8s":   func() (Provider, error) { return NewKubernetes() },
+	}
+	instances = map[string]Provider{}
+)
+
+// Register makes a provider available to references with the given scheme.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	instances[scheme] = p
+}
+
+func provider(scheme string) (Provider, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := instances[scheme]; ok {
+		return p, nil
+	}
+	newProvider, ok := providers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, scheme)
+	}
+	p, err := newProvider()
+	if err != nil {
+		return nil, err
+	}
+	instances[scheme] = p
+	return p, nil
+}
+
+// Get resolves the config value at key. in order:
+//   - the file named by the SMS_<KEY>_FILE environment variable or the <key>_file config value
+//   - references in the value: file:<path>, vault:<path>#<field> or k8s:[<namespace>/]<name>#<key>
+//   - the plain value
+func Get(ctx context.Context, key string) (string, error) {
+	file := os.Getenv(EnvName(key) + "_FILE")
+	if file == "" {
+		file = viper.GetString(key + "_file")
+	}
+	if file != "" {
+		return readFile(file)
+	}
+	return Resolve(ctx, viper.GetString(key))
+}
+
+// Resolve returns the secret a reference points to. values without a known scheme are returned as is.
+func Resolve(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, nil
+	}
+	switch scheme {
+	case "file":
+		return readFile(ref)
+	case "vault", "k8s":
+		path, field, ok := strings.Cut(ref, "#")
+		if !ok || field == "" {
+			return "", fmt.Errorf("secret reference %q is missing a #field", value)
+		}
+		p, err := provider(scheme)
+		if err != nil {
+			return "", err
+		}
+		return p.Get(ctx, path, field)
+	default:
+		// not a reference, e.g. nats://host:4222 or host:port
+		return value, nil
+	}
+}
+
+// EnvName is the environment variable for a config key, e.g. SMS_API_POSTGRES_PASSWORD.
+func EnvName(key string) string {
+	return "SMS_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func readFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
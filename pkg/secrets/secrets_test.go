@@ -0,0 +1,99 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	. "github.com/alireza-karampour/sms/pkg/secrets"
+)
+
+var _ = Describe("Secrets", func() {
+	var ctx context.Context
+	var dir string
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+		viper.Reset()
+	})
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	It("should return plain values unchanged", func() {
+		viper.Set("api.nats.address", "nats://127.0.0.1:4222")
+		Expect(Get(ctx, "api.nats.address")).To(Equal("nats://127.0.0.1:4222"))
+	})
+
+	It("should prefer the file named by the _FILE environment variable", func() {
+		viper.Set("api.postgres.password", "plain")
+		GinkgoT().Setenv("SMS_API_POSTGRES_PASSWORD_FILE", writeFile("password", "from-env-file\n"))
+		Expect(Get(ctx, "api.postgres.password")).To(Equal("from-env-file"))
+	})
+
+	It("should read the file named by the _file config value", func() {
+		viper.Set("api.postgres.password_file", writeFile("password", "from-config-file"))
+		Expect(Get(ctx, "api.postgres.password")).To(Equal("from-config-file"))
+	})
+
+	It("should resolve file references", func() {
+		viper.Set("api.postgres.password", "file:"+writeFile("password", "from-reference\n"))
+		Expect(Get(ctx, "api.postgres.password")).To(Equal("from-reference"))
+	})
+
+	It("should read kv v2 secrets from vault", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/secret/data/sms" || r.Header.Get("X-Vault-Token") != "root" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{"data": map[string]any{"password": "from-vault"}},
+			})
+		}))
+		defer srv.Close()
+		Register("vault", &Vault{Address: srv.URL, Token: "root", Client: srv.Client()})
+
+		Expect(Resolve(ctx, "vault:secret/data/sms#password")).To(Equal("from-vault"))
+		_, err := Resolve(ctx, "vault:secret/data/sms#username")
+		Expect(err).To(MatchError(ErrNotFound))
+		_, err = Resolve(ctx, "vault:secret/data/other#password")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	It("should read kubernetes secrets", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/namespaces/sms/secrets/postgres" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]string{"password": base64.StdEncoding.EncodeToString([]byte("from-k8s"))},
+			})
+		}))
+		defer srv.Close()
+		Register("k8s", &Kubernetes{Host: srv.URL, Namespace: "sms", Client: srv.Client()})
+
+		Expect(Resolve(ctx, "k8s:postgres#password")).To(Equal("from-k8s"))
+		Expect(Resolve(ctx, "k8s:sms/postgres#password")).To(Equal("from-k8s"))
+		_, err := Resolve(ctx, "k8s:default/postgres#password")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	It("should require a field in store references", func() {
+		_, err := Resolve(ctx, "vault:secret/data/sms")
+		Expect(err).To(HaveOccurred())
+	})
+})
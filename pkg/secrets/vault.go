@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Vault reads secrets from HashiCorp Vault's HTTP API. both KV v1 and v2 mounts are supported;
+// for v2 the path must include data/, e.g. secret/data/sms.
+type Vault struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// NewVaultFromViper configures Vault from secrets.vault.{address,token,tokenfile},
+// falling back to the VAULT_ADDR and VAULT_TOKEN environment variables.
+func NewVaultFromViper() (*Vault, error) {
+	addr := viper.GetString("secrets.vault.address")
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("vault address is not configured")
+	}
+	token := viper.GetString("secrets.vault.token")
+	if f := viper.GetString("secrets.vault.tokenfile"); token == "" && f != "" {
+		t, err := readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		token = t
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	return &Vault{
+		Address: strings.TrimRight(addr, "/"),
+		Token:   token,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (v *Vault) Get(ctx context.Context, path, field string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Address+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: vault:%s", ErrNotFound, path)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded with %s for %s", res.Status, path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	data := body.Data
+	// KV v2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: vault:%s#%s", ErrNotFound, path, field)
+	}
+	return value, nil
+}
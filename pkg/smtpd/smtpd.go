@@ -0,0 +1,325 @@
+// Package smtpd receives mail over SMTP for a Handler, like the last hop of a
+// relay: no relaying, authentication or queueing of its own.
+package smtpd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error is a refusal with the SMTP reply code it is answered with.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+var (
+	ErrMailboxUnavailable = &Error{Code: 550, Message: "mailbox unavailable"}
+	ErrTransactionFailed  = &Error{Code: 554, Message: "transaction failed"}
+	errTooManyRecipients  = &Error{Code: 452, Message: "too many recipients"}
+	errTooLarge           = &Error{Code: 552, Message: "message exceeds fixed maximum message size"}
+)
+
+// Envelope is a received mail.
+type Envelope struct {
+	RemoteAddr net.Addr
+	From       string
+	To         []string
+	// Data is the message as received, headers and body
+	Data []byte
+}
+
+// Handler accepts mail. an error of type *Error is answered with its code,
+// others with 451 so the sender tries again later.
+type Handler interface {
+	// Rcpt decides whether mail from from to to is accepted
+	Rcpt(ctx context.Context, from, to string) error
+	// Deliver takes a mail whose recipients were all accepted by Rcpt
+	Deliver(ctx context.Context, env Envelope) error
+}
+
+type Config struct {
+	// Hostname is announced in the greeting and EHLO reply
+	Hostname string
+	// MaxSize is the largest message accepted, in bytes
+	MaxSize int64
+	// MaxRecipients is how many recipients a mail may have
+	MaxRecipients int
+	// TLS enables STARTTLS when set
+	TLS *tls.Config
+	// Timeout bounds every command and the data of a mail
+	Timeout time.Duration
+}
+
+// Server serves SMTP sessions for a Handler.
+type Server struct {
+	cfg     Config
+	handler Handler
+
+	wg sync.WaitGroup
+}
+
+func NewServer(cfg Config, handler Handler) *Server {
+	if cfg.Hostname == "" {
+		cfg.Hostname = "localhost"
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	if cfg.MaxRecipients <= 0 {
+		cfg.MaxRecipients = 100
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	return &Server{cfg: cfg, handler: handler}
+}
+
+// Serve accepts connections on l until ctx is done, then closes l and every
+// session and waits for them to return.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	defer s.wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+type session struct {
+	conn net.Conn
+	text *textproto.Conn
+	tls  bool
+
+	helo string
+	from string
+	// mail is set between MAIL and the end of the mail
+	mail bool
+	to   []string
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	ss := &session{conn: conn, text: textproto.NewConn(conn)}
+	ss.reply(220, s.cfg.Hostname+" ESMTP ready")
+	for {
+		conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ss.reset()
+			ss.helo = arg
+			ss.reply(250, s.cfg.Hostname)
+		case "EHLO":
+			ss.reset()
+			ss.helo = arg
+			ext := []string{s.cfg.Hostname, "8BITMIME", "SIZE " + strconv.FormatInt(s.cfg.MaxSize, 10)}
+			if s.cfg.TLS != nil && !ss.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			ss.reply(250, ext...)
+		case "STARTTLS":
+			if s.cfg.TLS == nil || ss.tls {
+				ss.reply(502, "command not implemented")
+				continue
+			}
+			ss.reply(220, "ready to start TLS")
+			tlsConn := tls.Server(conn, s.cfg.TLS)
+			err := tlsConn.HandshakeContext(ctx)
+			if err != nil {
+				return
+			}
+			conn = tlsConn
+			// the session starts over on the encrypted connection
+			*ss = session{conn: tlsConn, text: textproto.NewConn(tlsConn), tls: true}
+		case "MAIL":
+			s.mail(ss, arg)
+		case "RCPT":
+			s.rcpt(ctx, ss, arg)
+		case "DATA":
+			if !s.data(ctx, ss) {
+				return
+			}
+		case "RSET":
+			ss.reset()
+			ss.reply(250, "OK")
+		case "NOOP":
+			ss.reply(250, "OK")
+		case "VRFY":
+			ss.reply(252, "cannot verify, send some mail")
+		case "QUIT":
+			ss.reply(221, "bye")
+			return
+		default:
+			ss.reply(502, "command not implemented")
+		}
+	}
+}
+
+func (ss *session) reset() {
+	ss.from, ss.mail, ss.to = "", false, nil
+}
+
+func (ss *session) reply(code int, lines ...string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		ss.text.PrintfLine("%d%s%s", code, sep, line)
+	}
+}
+
+func (ss *session) replyErr(err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		ss.reply(e.Code, e.Message)
+		return
+	}
+	ss.reply(451, "local error, try again later")
+}
+
+func (s *Server) mail(ss *session, arg string) {
+	if ss.helo == "" {
+		ss.reply(503, "say hello first")
+		return
+	}
+	if ss.mail {
+		ss.reply(503, "nested MAIL command")
+		return
+	}
+	addr, params, ok := path(arg, "FROM:")
+	if !ok {
+		ss.reply(501, "syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(k, "SIZE") {
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err == nil && size > s.cfg.MaxSize {
+				ss.replyErr(errTooLarge)
+				return
+			}
+		}
+	}
+	ss.from, ss.mail = addr, true
+	ss.reply(250, "OK")
+}
+
+func (s *Server) rcpt(ctx context.Context, ss *session, arg string) {
+	if !ss.mail {
+		ss.reply(503, "need MAIL before RCPT")
+		return
+	}
+	addr, _, ok := path(arg, "TO:")
+	if !ok || addr == "" {
+		ss.reply(501, "syntax: RCPT TO:<address>")
+		return
+	}
+	if len(ss.to) >= s.cfg.MaxRecipients {
+		ss.replyErr(errTooManyRecipients)
+		return
+	}
+	err := s.handler.Rcpt(ctx, ss.from, addr)
+	if err != nil {
+		ss.replyErr(err)
+		return
+	}
+	ss.to = append(ss.to, addr)
+	ss.reply(250, "OK")
+}
+
+// data receives a mail and reports whether the session may go on.
+func (s *Server) data(ctx context.Context, ss *session) bool {
+	if !ss.mail || len(ss.to) == 0 {
+		ss.reply(503, "need RCPT before DATA")
+		return true
+	}
+	ss.reply(354, "end data with <CR><LF>.<CR><LF>")
+	r := ss.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(r, s.cfg.MaxSize+1))
+	if err != nil {
+		return false
+	}
+	defer ss.reset()
+	if int64(len(data)) > s.cfg.MaxSize {
+		// drain the rest of the mail so the session stays in sync
+		_, err = io.Copy(io.Discard, r)
+		if err != nil {
+			return false
+		}
+		ss.replyErr(errTooLarge)
+		return true
+	}
+	err = s.handler.Deliver(ctx, Envelope{
+		RemoteAddr: ss.conn.RemoteAddr(),
+		From:       ss.from,
+		To:         ss.to,
+		Data:       data,
+	})
+	if err != nil {
+		ss.replyErr(err)
+		return true
+	}
+	ss.reply(250, "OK")
+	return true
+}
+
+// path parses "FROM:<addr> PARAMS" after the verb of MAIL and RCPT. the null
+// path <> is the empty address.
+func path(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
@@ -0,0 +1,13 @@
+package smtpd_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSmtpd(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Smtpd Suite")
+}
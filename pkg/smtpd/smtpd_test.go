@@ -0,0 +1,93 @@
+package smtpd_test
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/alireza-karampour/sms/pkg/smtpd"
+)
+
+type fakeHandler struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+}
+
+func (h *fakeHandler) Rcpt(ctx context.Context, from, to string) error {
+	if !strings.HasSuffix(to, "@sms.example.com") {
+		return ErrMailboxUnavailable
+	}
+	return nil
+}
+
+func (h *fakeHandler) Deliver(ctx context.Context, env Envelope) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.envelopes = append(h.envelopes, env)
+	return nil
+}
+
+var _ = Describe("Server", func() {
+	var (
+		h      *fakeHandler
+		addr   string
+		cancel context.CancelFunc
+		done   chan error
+	)
+
+	BeforeEach(func() {
+		h = &fakeHandler{}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = l.Addr().String()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan error, 1)
+		go func() {
+			done <- NewServer(Config{Hostname: "mx.example.com", MaxSize: 1024}, h).Serve(ctx, l)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should hand accepted mails to the handler", func() {
+		msg := "Subject: hi\r\n\r\nfirst line\r\n.leading dot\r\n"
+		err := smtp.SendMail(addr, nil, "ops@example.com", []string{"+4912345678@sms.example.com", "+4987654321@sms.example.com"}, []byte(msg))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.envelopes).To(HaveLen(1))
+		env := h.envelopes[0]
+		Expect(env.From).To(Equal("ops@example.com"))
+		Expect(env.To).To(Equal([]string{"+4912345678@sms.example.com", "+4987654321@sms.example.com"}))
+		// lines end in LF once received, dots leading a line are unstuffed
+		Expect(string(env.Data)).To(Equal("Subject: hi\n\nfirst line\n.leading dot\n"))
+	})
+
+	It("should answer refused recipients with the handler's code", func() {
+		c, err := smtp.Dial(addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(c.Mail("ops@example.com")).To(Succeed())
+		err = c.Rcpt("someone@example.com")
+		var tpErr *textproto.Error
+		Expect(err).To(BeAssignableToTypeOf(tpErr))
+		Expect(err.(*textproto.Error).Code).To(Equal(550))
+		err = c.Rcpt("+4912345678@sms.example.com")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should refuse mails over the size limit", func() {
+		err := smtp.SendMail(addr, nil, "ops@example.com", []string{"+4912345678@sms.example.com"}, []byte(strings.Repeat("x", 2048)))
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*textproto.Error).Code).To(Equal(552))
+		Expect(h.envelopes).To(BeEmpty())
+	})
+})
@@ -0,0 +1,111 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Status is the delivery state of an sms.
+type Status string
+
+const (
+	// Accepted is set by the api on requests it queued. messages never carry it in the database.
+	Accepted Status = "accepted"
+	// Pending is set by the worker once the message is stored and charged, until it is submitted.
+	Pending   Status = "pending"
+	Submitted Status = "submitted"
+	Delivered Status = "delivered"
+	Failed    Status = "failed"
+	Expired   Status = "expired"
+	Cancelled Status = "cancelled"
+)
+
+var (
+	ErrUnknown    = errors.New("unknown sms status")
+	ErrTransition = errors.New("invalid sms status transition")
+)
+
+// transitions lists the statuses each status may move to. statuses without an entry are final.
+var transitions = map[Status][]Status{
+	Accepted:  {Pending, Expired},
+	Pending:   {Submitted, Failed, Expired, Cancelled},
+	Submitted: {Delivered, Failed, Expired},
+}
+
+// All lists every status in lifecycle order.
+var All = []Status{Accepted, Pending, Submitted, Delivered, Failed, Expired, Cancelled}
+
+// Parse validates s.
+func Parse(s string) (Status, error) {
+	st := Status(s)
+	if !slices.Contains(All, st) {
+		return "", fmt.Errorf("%w: %q", ErrUnknown, s)
+	}
+	return st, nil
+}
+
+// Final reports whether s can't change anymore.
+func (s Status) Final() bool {
+	_, ok := transitions[s]
+	return !ok
+}
+
+// CanTransition reports whether an sms in s may move to to.
+func (s Status) CanTransition(to Status) bool {
+	return slices.Contains(transitions[s], to)
+}
+
+// Transition validates moving from s to to.
+func (s Status) Transition(to Status) error {
+	if !s.CanTransition(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrTransition, s, to)
+	}
+	return nil
+}
+
+func (s Status) String() string {
+	return string(s)
+}
+
+// Update is published on the status subjects of a priority to move an sms to a new status,
+// e.g. when a carrier reports delivery.
+type Update struct {
+	ID       int32  `json:"id"`
+	Status   Status `json:"status"`
+	Provider string `json:"provider,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// FailureReason is Reason normalized across carriers, see FailureReason
+	FailureReason string `json:"failure_reason,omitempty"`
+	// Ref is the provider's id of the message, when the update carries it
+	Ref string `json:"ref,omitempty"`
+}
+
+// normalized reasons an sms failed for, the same whichever carrier reported it
+const (
+	ReasonInvalidNumber    = "invalid_number"
+	ReasonAbsentSubscriber = "absent_subscriber"
+	ReasonSpamFiltered     = "spam_filtered"
+	ReasonExpired          = "expired"
+	ReasonUnknown          = "unknown"
+)
+
+// Reasons lists every normalized failure reason.
+var Reasons = []string{ReasonInvalidNumber, ReasonAbsentSubscriber, ReasonSpamFiltered, ReasonExpired, ReasonUnknown}
+
+// FailureReason is the normalized reason of an sms moving to to, reason when the
+// carrier's code was cataloged. expired sms are ReasonExpired and failed ones
+// ReasonUnknown otherwise, other statuses have none.
+func FailureReason(to Status, reason string) string {
+	switch to {
+	case Expired:
+		return ReasonExpired
+	case Failed:
+		if slices.Contains(Reasons, reason) {
+			return reason
+		}
+		return ReasonUnknown
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,54 @@
+package status_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/status"
+)
+
+var _ = Describe("Status", func() {
+	It("should parse known statuses only", func() {
+		for _, s := range status.All {
+			Expect(status.Parse(s.String())).To(Equal(s))
+		}
+		_, err := status.Parse("sent")
+		Expect(err).To(MatchError(status.ErrUnknown))
+	})
+
+	DescribeTable("transitions",
+		func(from, to status.Status, allowed bool) {
+			Expect(from.CanTransition(to)).To(Equal(allowed))
+			if allowed {
+				Expect(from.Transition(to)).To(Succeed())
+			} else {
+				Expect(from.Transition(to)).To(MatchError(status.ErrTransition))
+			}
+		},
+		Entry("the worker stores accepted requests", status.Accepted, status.Pending, true),
+		Entry("requests may expire on the queue", status.Accepted, status.Expired, true),
+		Entry("the api can't skip the worker", status.Accepted, status.Submitted, false),
+		Entry("pending messages are submitted", status.Pending, status.Submitted, true),
+		Entry("pending messages can be cancelled", status.Pending, status.Cancelled, true),
+		Entry("submitted messages are delivered", status.Submitted, status.Delivered, true),
+		Entry("submitted messages can't be cancelled", status.Submitted, status.Cancelled, false),
+		Entry("delivered is final", status.Delivered, status.Failed, false),
+		Entry("there is no way back", status.Pending, status.Accepted, false),
+	)
+
+	It("should normalize the reasons of failed and expired sms only", func() {
+		Expect(status.FailureReason(status.Failed, status.ReasonInvalidNumber)).To(Equal(status.ReasonInvalidNumber))
+		Expect(status.FailureReason(status.Failed, "")).To(Equal(status.ReasonUnknown))
+		Expect(status.FailureReason(status.Failed, "number barred")).To(Equal(status.ReasonUnknown))
+		Expect(status.FailureReason(status.Expired, status.ReasonAbsentSubscriber)).To(Equal(status.ReasonExpired))
+		Expect(status.FailureReason(status.Delivered, status.ReasonUnknown)).To(BeEmpty())
+	})
+
+	It("should only leave open statuses", func() {
+		Expect(status.Accepted.Final()).To(BeFalse())
+		Expect(status.Submitted.Final()).To(BeFalse())
+		Expect(status.Delivered.Final()).To(BeTrue())
+		Expect(status.Expired.Final()).To(BeTrue())
+		Expect(status.Cancelled.Final()).To(BeTrue())
+	})
+})
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+	// ErrPresignUnsupported is returned by backends that can't hand out direct download links.
+	// callers should stream the object through Open instead.
+	ErrPresignUnsupported = errors.New("storage backend does not support presigned urls")
+)
+
+// Storage keeps files such as exports and archives under slash separated keys.
+type Storage interface {
+	// Put stores body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL anyone can download key from until ttl passes.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// FromViper builds the backend selected by storage.backend: "local" (default) or "s3".
+func FromViper(ctx context.Context) (Storage, error) {
+	switch backend := viper.GetString("storage.backend"); backend {
+	case "", "local":
+		return NewLocal(viper.GetString("storage.local.dir")), nil
+	case "s3":
+		accessKey, err := secrets.Get(ctx, "storage.s3.accesskey")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage.s3.accesskey: %w", err)
+		}
+		secretKey, err := secrets.Get(ctx, "storage.s3.secretkey")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage.s3.secretkey: %w", err)
+		}
+		return NewS3(S3Config{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Region:    viper.GetString("storage.s3.region"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			PathStyle: viper.GetBool("storage.s3.pathstyle"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -0,0 +1,342 @@
+package ucp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoSession is returned while the client is (re)connecting
+	ErrNoSession = errors.New("ucp: no session")
+	// ErrWindow is returned when all 100 transaction numbers are in use
+	ErrWindow = errors.New("ucp: too many operations in flight")
+	ErrClosed = errors.New("ucp: session closed")
+)
+
+// Config configures a Client.
+type Config struct {
+	// Address is the host:port of the SMSC
+	Address  string
+	Login    string
+	Password string
+	TLS      bool
+	// Keepalive is the interval of the alerts keeping the session open, 60s when zero
+	Keepalive time.Duration
+	// Timeout bounds connecting and waiting for the result of an operation, 30s when zero
+	Timeout time.Duration
+}
+
+// Client keeps a session with an SMSC open, reconnecting with backoff when it
+// breaks, and receives the delivery notifications sent over it.
+type Client struct {
+	cfg Config
+
+	mu      sync.Mutex
+	session *session
+	handler func(context.Context, Notification) error
+}
+
+func NewClient(cfg Config) *Client {
+	if cfg.Keepalive <= 0 {
+		cfg.Keepalive = time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &Client{cfg: cfg}
+}
+
+// OnNotification sets the handler of delivery notifications. notifications are
+// refused while there is none or it fails, the SMSC sends them again later.
+func (c *Client) OnNotification(handler func(context.Context, Notification) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+// Run opens a session and keeps it open until ctx is done.
+func (c *Client) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		s, err := c.open(ctx)
+		if err == nil {
+			backoff = time.Second
+			c.setSession(s)
+			err = c.keepalive(ctx, s)
+			c.setSession(nil)
+			s.close(err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Warnf("ucp session with %s lost, reconnecting in %s: %s", c.cfg.Address, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// Submit submits text to to and returns the id the SMSC gave the message.
+func (c *Client) Submit(ctx context.Context, from, to, text string) (string, error) {
+	c.mu.Lock()
+	s := c.session
+	c.mu.Unlock()
+	if s == nil {
+		return "", ErrNoSession
+	}
+	res, err := s.call(ctx, Submit(from, to, text), c.cfg.Timeout)
+	if err != nil {
+		return "", err
+	}
+	if e := res.Error(); e != nil {
+		return "", e
+	}
+	return res.SM(), nil
+}
+
+// Check opens a session besides the one Run keeps open and closes it once logged
+// in, to test the address and the credentials.
+func (c *Client) Check(ctx context.Context) error {
+	s, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	s.close(nil)
+	return nil
+}
+
+func (c *Client) setSession(s *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = s
+}
+
+// open connects and logs in.
+func (c *Client) open(ctx context.Context) (*session, error) {
+	dialer := &net.Dialer{Timeout: c.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if c.cfg.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", c.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.cfg.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		conn:    conn,
+		pending: make(map[int]chan Frame),
+		done:    make(chan struct{}),
+		notify:  c.notify,
+	}
+	go s.read(ctx)
+	res, err := s.call(ctx, Session(c.cfg.Login, c.cfg.Password), c.cfg.Timeout)
+	if err == nil {
+		if e := res.Error(); e != nil {
+			err = fmt.Errorf("login refused: %w", e)
+		}
+	}
+	if err != nil {
+		s.close(err)
+		return nil, err
+	}
+	logrus.Infof("ucp session with %s open", c.cfg.Address)
+	return s, nil
+}
+
+// keepalive alerts the SMSC every Keepalive until the session breaks.
+func (c *Client) keepalive(ctx context.Context, s *session) error {
+	ticker := time.NewTicker(c.cfg.Keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return s.err
+		case <-ticker.C:
+			_, err := s.call(ctx, Alert(c.cfg.Login), c.cfg.Timeout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) notify(ctx context.Context, n Notification) error {
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+	if handler == nil {
+		return errors.New("no handler")
+	}
+	return handler(ctx, n)
+}
+
+// session is one connection, results are paired with their operations by TRN.
+type session struct {
+	conn   net.Conn
+	notify func(context.Context, Notification) error
+
+	mu      sync.Mutex
+	pending map[int]chan Frame
+	trn     int
+	done    chan struct{}
+	err     error
+}
+
+// call sends op and waits for its result.
+func (s *session) call(ctx context.Context, op Frame, timeout time.Duration) (Frame, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return Frame{}, s.err
+	}
+	trn, ok := s.nextTRN()
+	if !ok {
+		s.mu.Unlock()
+		return Frame{}, ErrWindow
+	}
+	op.TRN = trn
+	ch := make(chan Frame, 1)
+	s.pending[trn] = ch
+	err := s.write(op, timeout)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, trn)
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		s.close(err)
+		return Frame{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-s.done:
+		return Frame{}, s.err
+	case <-ctx.Done():
+		return Frame{}, ctx.Err()
+	case <-timer.C:
+		return Frame{}, fmt.Errorf("ucp: no result of operation %s in %s", op.OT, timeout)
+	}
+}
+
+// nextTRN is the next transaction number not in flight, s.mu is held.
+func (s *session) nextTRN() (int, bool) {
+	for range 100 {
+		trn := s.trn
+		s.trn = (s.trn + 1) % 100
+		if _, ok := s.pending[trn]; !ok {
+			return trn, true
+		}
+	}
+	return 0, false
+}
+
+// write sends f, s.mu is held.
+func (s *session) write(f Frame, timeout time.Duration) error {
+	s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := s.conn.Write(Encode(f))
+	return err
+}
+
+// read dispatches the results and answers the operations of the SMSC until the
+// connection breaks.
+func (s *session) read(ctx context.Context) {
+	r := bufio.NewReader(s.conn)
+	for {
+		b, err := r.ReadBytes(etx)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		// anything before STX is line noise
+		start := -1
+		for i, c := range b {
+			if c == stx {
+				start = i
+			}
+		}
+		if start < 0 {
+			continue
+		}
+		f, err := Decode(b[start+1 : len(b)-1])
+		if err != nil {
+			logrus.Warnf("ucp: dropping frame: %s", err)
+			continue
+		}
+		if f.Result {
+			s.mu.Lock()
+			ch, ok := s.pending[f.TRN]
+			s.mu.Unlock()
+			if ok {
+				select {
+				case ch <- f:
+				default:
+				}
+			}
+			continue
+		}
+		s.answer(ctx, f)
+	}
+}
+
+// answer handles an operation of the SMSC.
+func (s *session) answer(ctx context.Context, op Frame) {
+	res := Ack(op, "")
+	switch op.OT {
+	case OpAlert:
+	case OpNotify:
+		n, err := ParseNotification(op)
+		if err != nil {
+			res = Nack(op, ECSyntax)
+			break
+		}
+		err = s.notify(ctx, n)
+		if err != nil {
+			logrus.Warnf("ucp: refusing notification of %s: %s", n.MessageID(), err)
+			res = Nack(op, ECNotAllowed)
+		}
+	default:
+		// mobile originated messages aren't received, the SMSC keeps them
+		res = Nack(op, ECNotSupported)
+	}
+	s.mu.Lock()
+	err := s.write(res, 30*time.Second)
+	s.mu.Unlock()
+	if err != nil {
+		s.close(err)
+	}
+}
+
+// close closes the connection once, failing the operations in flight with err.
+func (s *session) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err == nil {
+		err = ErrClosed
+	}
+	s.err = err
+	s.conn.Close()
+	close(s.done)
+}
@@ -0,0 +1,304 @@
+// Package ucp speaks UCP/EMI, the text protocol SMSCs of some aggregators still
+// require instead of SMPP or HTTP.
+package ucp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	stx = 0x02
+	etx = 0x03
+)
+
+// operation types
+const (
+	OpAlert    = "31"
+	OpSubmit   = "51"
+	OpDeliver  = "52"
+	OpNotify   = "53"
+	OpSession  = "60"
+	fields5x   = 33
+	fieldsOp60 = 12
+)
+
+// error codes of negative results
+const (
+	ECChecksum     = "01"
+	ECSyntax       = "02"
+	ECNotSupported = "03"
+	ECNotAllowed   = "04"
+	ECAuth         = "07"
+)
+
+var (
+	ErrFrame    = errors.New("ucp: malformed frame")
+	ErrChecksum = errors.New("ucp: checksum mismatch")
+)
+
+// Frame is an operation or the result of one.
+type Frame struct {
+	// TRN pairs a result with its operation, 00 to 99
+	TRN int
+	// Result is set on results ("R"), operations are "O"
+	Result bool
+	OT     string
+	Fields []string
+}
+
+// Ack is the positive result of op.
+func Ack(op Frame, sm string) Frame {
+	return Frame{TRN: op.TRN, Result: true, OT: op.OT, Fields: []string{"A", "", sm}}
+}
+
+// Nack is the negative result of op with the error code ec.
+func Nack(op Frame, ec string) Frame {
+	return Frame{TRN: op.TRN, Result: true, OT: op.OT, Fields: []string{"N", ec, ""}}
+}
+
+// Positive reports whether f is a positive result.
+func (f Frame) Positive() bool {
+	return f.Result && len(f.Fields) > 0 && f.Fields[0] == "A"
+}
+
+// Error is the error of a negative result.
+func (f Frame) Error() *Error {
+	if !f.Result || f.Positive() {
+		return nil
+	}
+	e := &Error{}
+	if len(f.Fields) > 1 {
+		e.Code = f.Fields[1]
+	}
+	if len(f.Fields) > 2 {
+		e.Message = f.Fields[2]
+	}
+	return e
+}
+
+// SM is the system message of a result, e.g. the id of a submitted message.
+func (f Frame) SM() string {
+	if len(f.Fields) < 3 {
+		return ""
+	}
+	return f.Fields[len(f.Fields)-1]
+}
+
+// Error is a negative result.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "ucp: error " + e.Code
+	}
+	return fmt.Sprintf("ucp: error %s: %s", e.Code, e.Message)
+}
+
+// Encode frames f: STX, the header and fields separated by '/', the checksum, ETX.
+func Encode(f Frame) []byte {
+	kind := "O"
+	if f.Result {
+		kind = "R"
+	}
+	data := strings.Join(f.Fields, "/")
+	// the length counts everything between STX and ETX: header, data and checksum
+	length := len("00/00000/O/00/") + len(data) + len("/00")
+	body := fmt.Sprintf("%02d/%05d/%s/%s/%s/", f.TRN%100, length, kind, f.OT, data)
+	out := make([]byte, 0, length+2)
+	out = append(out, stx)
+	out = append(out, body...)
+	out = append(out, Checksum([]byte(body))...)
+	return append(out, etx)
+}
+
+// Decode parses a frame without its STX and ETX and verifies its checksum.
+func Decode(b []byte) (Frame, error) {
+	if len(b) < len("00/00000/O/00/00") {
+		return Frame{}, ErrFrame
+	}
+	body, sum := b[:len(b)-2], string(b[len(b)-2:])
+	if Checksum(body) != sum {
+		return Frame{}, ErrChecksum
+	}
+	parts := strings.Split(strings.TrimSuffix(string(body), "/"), "/")
+	if len(parts) < 4 {
+		return Frame{}, ErrFrame
+	}
+	trn, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Frame{}, ErrFrame
+	}
+	length, err := strconv.Atoi(parts[1])
+	if err != nil || length != len(b) {
+		return Frame{}, ErrFrame
+	}
+	if parts[2] != "O" && parts[2] != "R" {
+		return Frame{}, ErrFrame
+	}
+	return Frame{
+		TRN:    trn,
+		Result: parts[2] == "R",
+		OT:     parts[3],
+		Fields: parts[4:],
+	}, nil
+}
+
+// Checksum is the sum of the bytes of body modulo 256, as two upper case hex digits.
+func Checksum(body []byte) string {
+	var sum byte
+	for _, c := range body {
+		sum += c
+	}
+	return fmt.Sprintf("%02X", sum)
+}
+
+// IRA encodes text the way UCP carries alphanumeric messages and passwords: every
+// character as two hex digits.
+func IRA(text string) string {
+	return strings.ToUpper(hex.EncodeToString([]byte(text)))
+}
+
+// DecodeIRA reverses IRA.
+func DecodeIRA(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
+
+// Session is the operation 60 opening a session as login with password.
+func Session(login, password string) Frame {
+	fields := make([]string, fieldsOp60)
+	fields[0] = login
+	fields[1] = "6" // OTON: abbreviated number
+	fields[2] = "5" // ONPI: private
+	fields[3] = "1" // STYP: open session
+	fields[4] = IRA(password)
+	fields[6] = "0100" // VERS
+	return Frame{OT: OpSession, Fields: fields}
+}
+
+// Alert is the operation 31 that keeps a session alive.
+func Alert(login string) Frame {
+	return Frame{OT: OpAlert, Fields: []string{login, "0539"}}
+}
+
+// Submit is the operation 51 submitting text from from to to, with a delivery
+// notification requested. text the IRA can't carry is sent as UCS-2.
+func Submit(from, to, text string) Frame {
+	fields := make([]string, fields5x)
+	fields[0] = to
+	fields[1] = from
+	fields[3] = "1"    // NRq: notification requested
+	fields[5] = "1"    // NT: delivery notification
+	fields[6] = "0539" // NPID: over the same connection
+	if isAlphanumeric(from) {
+		fields[1] = alphanumericAddress(from)
+		fields[28] = "5039" // OTOA: alphanumeric originator
+	}
+	if isASCII(text) {
+		fields[18] = "3" // MT: alphanumeric
+		fields[20] = IRA(text)
+	} else {
+		units := utf16.Encode([]rune(text))
+		b := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			b = append(b, byte(u>>8), byte(u))
+		}
+		fields[18] = "4" // MT: transparent data
+		fields[19] = strconv.Itoa(8 * len(b))
+		fields[20] = strings.ToUpper(hex.EncodeToString(b))
+		fields[30] = "020108" // XSer: data coding scheme UCS-2
+	}
+	return Frame{OT: OpSubmit, Fields: fields}
+}
+
+// Notification is a delivery notification, operation 53.
+type Notification struct {
+	// Recipient and Sent identify the submitted message, see MessageID
+	Recipient string
+	Sent      string
+	// Status is 0 for delivered, 1 for buffered and 2 for not delivered
+	Status string
+	// Reason is the three digit reason code
+	Reason string
+	// DeliveredAt is the time of Status, DDMMYYhhmmss
+	DeliveredAt string
+	Text        string
+}
+
+// MessageID is the id the SMSC gave the message in the result of its submission,
+// "<recipient>:<timestamp>".
+func (n Notification) MessageID() string {
+	return n.Recipient + ":" + n.Sent
+}
+
+// ParseNotification reads the fields of operation 53.
+func ParseNotification(f Frame) (Notification, error) {
+	if f.Result || f.OT != OpNotify || len(f.Fields) < 21 {
+		return Notification{}, ErrFrame
+	}
+	return Notification{
+		Recipient:   f.Fields[0],
+		Sent:        f.Fields[14],
+		Status:      f.Fields[15],
+		Reason:      f.Fields[16],
+		DeliveredAt: f.Fields[17],
+		Text:        DecodeIRA(f.Fields[20]),
+	}, nil
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 0x7e || (r < 0x20 && r != '\n' && r != '\r') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != '+' {
+			return true
+		}
+	}
+	return false
+}
+
+// alphanumericAddress encodes an alphanumeric originator: the number of semi-octets
+// of the GSM 7-bit packed address followed by the packed address, in hex.
+func alphanumericAddress(s string) string {
+	packed := pack7([]byte(s))
+	semiOctets := (len(s)*7 + 3) / 4
+	return fmt.Sprintf("%02X", semiOctets) + strings.ToUpper(hex.EncodeToString(packed))
+}
+
+// pack7 packs the low 7 bits of every byte of s into octets.
+func pack7(s []byte) []byte {
+	out := make([]byte, 0, (len(s)*7+7)/8)
+	var acc uint16
+	bits := 0
+	for _, c := range s {
+		acc |= uint16(c&0x7f) << bits
+		bits += 7
+		for bits >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			bits -= 8
+		}
+	}
+	if bits > 0 {
+		out = append(out, byte(acc))
+	}
+	return out
+}
@@ -0,0 +1,13 @@
+package ucp_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestUcp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "UCP Suite")
+}
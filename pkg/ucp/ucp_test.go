@@ -0,0 +1,275 @@
+package ucp_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/ucp"
+)
+
+// smsc is a fake SMSC accepting one connection at a time.
+type smsc struct {
+	ln     net.Listener
+	frames chan ucp.Frame
+	// answer is the result of every operation of the client
+	answer func(op ucp.Frame) ucp.Frame
+	conns  chan net.Conn
+}
+
+func newSMSC() *smsc {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	s := &smsc{
+		ln:     ln,
+		frames: make(chan ucp.Frame, 100),
+		conns:  make(chan net.Conn, 10),
+		answer: func(op ucp.Frame) ucp.Frame { return ucp.Ack(op, "") },
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.serve(conn)
+		}
+	}()
+	DeferCleanup(ln.Close)
+	return s
+}
+
+func (s *smsc) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		b, err := r.ReadBytes(0x03)
+		if err != nil {
+			return
+		}
+		f, err := ucp.Decode(b[1 : len(b)-1])
+		Expect(err).NotTo(HaveOccurred())
+		s.frames <- f
+		if !f.Result {
+			conn.Write(ucp.Encode(s.answer(f)))
+		}
+	}
+}
+
+// next is the next frame of op type ot the client sent.
+func (s *smsc) next(ot string) ucp.Frame {
+	for {
+		var f ucp.Frame
+		Eventually(s.frames).Should(Receive(&f))
+		if f.OT == ot {
+			return f
+		}
+	}
+}
+
+// frame unwraps an encoded frame.
+func frame(b []byte) []byte {
+	Expect(b[0]).To(Equal(byte(0x02)))
+	Expect(b[len(b)-1]).To(Equal(byte(0x03)))
+	return b[1 : len(b)-1]
+}
+
+var _ = Describe("UCP", func() {
+	It("frames operations with their length and checksum", func() {
+		b := ucp.Encode(ucp.Alert("12345"))
+		Expect(string(frame(b))).To(Equal("00/00027/O/31/12345/0539/" + ucp.Checksum([]byte("00/00027/O/31/12345/0539/"))))
+
+		f, err := ucp.Decode(frame(b))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.OT).To(Equal(ucp.OpAlert))
+		Expect(f.Result).To(BeFalse())
+		Expect(f.Fields).To(Equal([]string{"12345", "0539"}))
+	})
+
+	It("rejects frames with a bad checksum or length", func() {
+		b := frame(ucp.Encode(ucp.Alert("12345")))
+		b[len(b)-1] ^= 1
+		_, err := ucp.Decode(b)
+		Expect(err).To(MatchError(ucp.ErrChecksum))
+
+		_, err = ucp.Decode([]byte("00/00099/O/31/1/0539/" + ucp.Checksum([]byte("00/00099/O/31/1/0539/"))))
+		Expect(err).To(MatchError(ucp.ErrFrame))
+	})
+
+	It("sums the bytes for the checksum", func() {
+		Expect(ucp.Checksum([]byte("AB"))).To(Equal("83"))
+	})
+
+	It("submits text as IRA and with a delivery notification requested", func() {
+		f := ucp.Submit("15550100", "447700900123", "hi")
+		Expect(f.OT).To(Equal(ucp.OpSubmit))
+		Expect(f.Fields).To(HaveLen(33))
+		Expect(f.Fields[0]).To(Equal("447700900123"))
+		Expect(f.Fields[1]).To(Equal("15550100"))
+		Expect(f.Fields[3]).To(Equal("1"))
+		Expect(f.Fields[18]).To(Equal("3"))
+		Expect(f.Fields[20]).To(Equal("6869"))
+	})
+
+	It("submits other text as UCS-2 and packs alphanumeric senders", func() {
+		f := ucp.Submit("hellohello", "447700900123", "سلام")
+		Expect(f.Fields[1]).To(Equal("12E8329BFD4697D9EC37"))
+		Expect(f.Fields[28]).To(Equal("5039"))
+		Expect(f.Fields[18]).To(Equal("4"))
+		Expect(f.Fields[19]).To(Equal("64"))
+		Expect(f.Fields[20]).To(Equal("0633064406270645"))
+		Expect(f.Fields[30]).To(Equal("020108"))
+	})
+
+	It("parses delivery notifications", func() {
+		fields := make([]string, 33)
+		fields[0] = "447700900123"
+		fields[14] = "170126101500"
+		fields[15] = "2"
+		fields[16] = "107"
+		fields[17] = "170126101730"
+		fields[20] = ucp.IRA("Message not delivered")
+		n, err := ucp.ParseNotification(ucp.Frame{OT: ucp.OpNotify, Fields: fields})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n.MessageID()).To(Equal("447700900123:170126101500"))
+		Expect(n.Status).To(Equal("2"))
+		Expect(n.Reason).To(Equal("107"))
+		Expect(n.Text).To(Equal("Message not delivered"))
+
+		_, err = ucp.ParseNotification(ucp.Frame{OT: ucp.OpSubmit, Fields: fields})
+		Expect(err).To(MatchError(ucp.ErrFrame))
+	})
+
+	Context("Client", func() {
+		var (
+			server *smsc
+			client *ucp.Client
+			ctx    context.Context
+		)
+
+		BeforeEach(func() {
+			server = newSMSC()
+			client = ucp.NewClient(ucp.Config{
+				Address:   server.ln.Addr().String(),
+				Login:     "gateway",
+				Password:  "secret",
+				Keepalive: 100 * time.Millisecond,
+				Timeout:   time.Second,
+			})
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+		})
+
+		submit := func() (string, error) {
+			var id string
+			var err error
+			Eventually(func() bool {
+				id, err = client.Submit(ctx, "15550100", "447700900123", "hi")
+				return errors.Is(err, ucp.ErrNoSession)
+			}).Should(BeFalse())
+			return id, err
+		}
+
+		It("logs in, submits and keeps the session alive", func() {
+			server.answer = func(op ucp.Frame) ucp.Frame {
+				if op.OT == ucp.OpSubmit {
+					return ucp.Ack(op, "447700900123:170126101500")
+				}
+				return ucp.Ack(op, "")
+			}
+			go client.Run(ctx)
+
+			login := server.next(ucp.OpSession)
+			Expect(login.Fields[0]).To(Equal("gateway"))
+			Expect(login.Fields[4]).To(Equal(ucp.IRA("secret")))
+
+			id, err := submit()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("447700900123:170126101500"))
+
+			server.next(ucp.OpAlert)
+		})
+
+		It("returns the refusals of the SMSC", func() {
+			server.answer = func(op ucp.Frame) ucp.Frame {
+				if op.OT == ucp.OpSubmit {
+					return ucp.Frame{TRN: op.TRN, Result: true, OT: op.OT, Fields: []string{"N", "24", "too long"}}
+				}
+				return ucp.Ack(op, "")
+			}
+			go client.Run(ctx)
+
+			_, err := submit()
+			var uErr *ucp.Error
+			Expect(errors.As(err, &uErr)).To(BeTrue())
+			Expect(uErr.Code).To(Equal("24"))
+		})
+
+		It("checks the login on a session of its own", func() {
+			Expect(client.Check(ctx)).To(Succeed())
+			login := server.next(ucp.OpSession)
+			Expect(login.Fields[0]).To(Equal("gateway"))
+
+			server.answer = func(op ucp.Frame) ucp.Frame {
+				return ucp.Frame{TRN: op.TRN, Result: true, OT: op.OT, Fields: []string{"N", "07", "bad password"}}
+			}
+			err := client.Check(ctx)
+			var uErr *ucp.Error
+			Expect(errors.As(err, &uErr)).To(BeTrue())
+			Expect(uErr.Code).To(Equal("07"))
+		})
+
+		It("reconnects when the session breaks", func() {
+			go client.Run(ctx)
+			var conn net.Conn
+			Eventually(server.conns).Should(Receive(&conn))
+			server.next(ucp.OpSession)
+			conn.Close()
+
+			Eventually(server.conns, 5*time.Second).Should(Receive())
+			server.next(ucp.OpSession)
+			_, err := submit()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("acks the notifications its handler takes and refuses the others", func() {
+			var got []ucp.Notification
+			client.OnNotification(func(ctx context.Context, n ucp.Notification) error {
+				if n.Status == "1" {
+					return errors.New("not now")
+				}
+				got = append(got, n)
+				return nil
+			})
+			go client.Run(ctx)
+			var conn net.Conn
+			Eventually(server.conns).Should(Receive(&conn))
+			server.next(ucp.OpSession)
+
+			fields := make([]string, 33)
+			fields[0] = "447700900123"
+			fields[14] = "170126101500"
+			fields[15] = "0"
+			_, err := conn.Write(ucp.Encode(ucp.Frame{TRN: 7, OT: ucp.OpNotify, Fields: fields}))
+			Expect(err).NotTo(HaveOccurred())
+			res := server.next(ucp.OpNotify)
+			Expect(res.TRN).To(Equal(7))
+			Expect(res.Positive()).To(BeTrue())
+			Expect(got).To(HaveLen(1))
+			Expect(got[0].MessageID()).To(Equal("447700900123:170126101500"))
+
+			fields[15] = "1"
+			_, err = conn.Write(ucp.Encode(ucp.Frame{TRN: 8, OT: ucp.OpNotify, Fields: fields}))
+			Expect(err).NotTo(HaveOccurred())
+			res = server.next(ucp.OpNotify)
+			Expect(res.Positive()).To(BeFalse())
+			Expect(res.Error().Code).To(Equal(ucp.ECNotAllowed))
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const twilioEndpoint = "https://api.twilio.com"
+
+// TwilioConfig configures the Twilio Programmable Voice driver.
+type TwilioConfig struct {
+	// Endpoint defaults to https://api.twilio.com
+	Endpoint   string
+	AccountSID string
+	AuthToken  string
+	// StatusCallback, when set, is where Twilio reports how the call ended.
+	// it should translate the report to a status update on the voice status subject.
+	StatusCallback string
+}
+
+// Twilio places calls through the Twilio Calls API, reading the text with <Say>.
+type Twilio struct {
+	cfg    TwilioConfig
+	client *http.Client
+}
+
+func NewTwilio(cfg TwilioConfig) (*Twilio, error) {
+	if cfg.AccountSID == "" || cfg.AuthToken == "" {
+		return nil, fmt.Errorf("twilio needs an account sid and an auth token")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = twilioEndpoint
+	}
+	return &Twilio{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (t *Twilio) Call(ctx context.Context, c Call) (string, error) {
+	form := url.Values{
+		"To":    {c.To},
+		"From":  {c.From},
+		"Twiml": {TwiML(c)},
+	}
+	if t.cfg.StatusCallback != "" {
+		form.Set("StatusCallback", t.cfg.StatusCallback)
+	}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", strings.TrimSuffix(t.cfg.Endpoint, "/"), url.PathEscape(t.cfg.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.cfg.AccountSID, t.cfg.AuthToken)
+	res, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var reply struct {
+		SID     string `json:"sid"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &reply)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		if reply.Message == "" {
+			reply.Message = string(bytes.TrimSpace(body))
+		}
+		return "", fmt.Errorf("twilio: %s: %s", res.Status, reply.Message)
+	}
+	return reply.SID, nil
+}
+
+// TwiML is the document Twilio executes for c: the text, spelled with Speakable,
+// read c.Repeat times with a pause in between.
+func TwiML(c Call) string {
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(Speakable(c.Text)))
+	say := "<Say>"
+	if c.Language != "" {
+		var lang bytes.Buffer
+		xml.EscapeText(&lang, []byte(c.Language))
+		say = fmt.Sprintf(`<Say language="%s">`, lang.String())
+	}
+	var b strings.Builder
+	b.WriteString("<Response>")
+	for i := range max(c.Repeat, 1) {
+		if i > 0 {
+			b.WriteString(`<Pause length="1"/>`)
+		}
+		b.WriteString(say + text.String() + "</Say>")
+	}
+	b.WriteString("</Response>")
+	return b.String()
+}
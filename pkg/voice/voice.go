@@ -0,0 +1,74 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/alireza-karampour/sms/pkg/mask"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Call is a text-to-speech call reading Text to To.
+type Call struct {
+	From string
+	To   string
+	Text string
+	// Language is the BCP 47 tag of the voice, the provider's default when empty
+	Language string
+	// Repeat is how often Text is read, at least once
+	Repeat int
+}
+
+// Caller places calls through a provider.
+type Caller interface {
+	// Call places c and returns the provider's id of the call. the outcome of the
+	// call is reported later, on the voice status subject.
+	Call(ctx context.Context, c Call) (string, error)
+}
+
+// FromViper builds the driver selected by voice.driver: "log" (default) or "twilio".
+func FromViper(ctx context.Context) (Caller, error) {
+	switch driver := viper.GetString("voice.driver"); driver {
+	case "", "log":
+		return Log{}, nil
+	case "twilio":
+		token, err := secrets.Get(ctx, "voice.twilio.authtoken")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read voice.twilio.authtoken: %w", err)
+		}
+		return NewTwilio(TwilioConfig{
+			Endpoint:       viper.GetString("voice.twilio.endpoint"),
+			AccountSID:     viper.GetString("voice.twilio.accountsid"),
+			AuthToken:      token,
+			StatusCallback: viper.GetString("voice.twilio.statuscallback"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown voice driver %q", driver)
+	}
+}
+
+// Log only logs the destination of every call. it is meant for development and never fails.
+type Log struct{}
+
+func (Log) Call(ctx context.Context, c Call) (string, error) {
+	logrus.WithField("to", mask.Phone(c.To)).Info("call not placed, voice.driver is log")
+	return "", nil
+}
+
+// Speakable spells out runs of digits so text-to-speech reads a code like 4821 as
+// "4, 8, 2, 1" instead of a number in the thousands.
+func Speakable(text string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i, r := range runes {
+		b.WriteRune(r)
+		if unicode.IsDigit(r) && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+			b.WriteString(", ")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package voice_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alireza-karampour/sms/pkg/voice"
+)
+
+var _ = Describe("Voice", func() {
+	It("spells out digits", func() {
+		Expect(voice.Speakable("your code is 4821.")).To(Equal("your code is 4, 8, 2, 1."))
+		Expect(voice.Speakable("no code")).To(Equal("no code"))
+	})
+
+	It("repeats the escaped text in TwiML", func() {
+		Expect(voice.TwiML(voice.Call{Text: "a<b 12", Language: "en-US", Repeat: 2})).To(Equal(
+			`<Response><Say language="en-US">a&lt;b 1, 2</Say><Pause length="1"/><Say language="en-US">a&lt;b 1, 2</Say></Response>`,
+		))
+		Expect(voice.TwiML(voice.Call{Text: "hi"})).To(Equal("<Response><Say>hi</Say></Response>"))
+	})
+
+	Context("Twilio", func() {
+		c := voice.Call{From: "+15550100", To: "+15550101", Text: "code 12"}
+
+		It("places the call and returns its sid", func() {
+			var user, pass, path string
+			var form map[string][]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				user, pass, _ = r.BasicAuth()
+				path = r.URL.Path
+				Expect(r.ParseForm()).To(Succeed())
+				form = r.PostForm
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"sid":"CA123","status":"queued"}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			t, err := voice.NewTwilio(voice.TwilioConfig{
+				Endpoint:       srv.URL,
+				AccountSID:     "AC1",
+				AuthToken:      "token",
+				StatusCallback: "https://example.com/voice",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			sid, err := t.Call(context.Background(), c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sid).To(Equal("CA123"))
+			Expect(user).To(Equal("AC1"))
+			Expect(pass).To(Equal("token"))
+			Expect(path).To(Equal("/2010-04-01/Accounts/AC1/Calls.json"))
+			Expect(form["To"]).To(Equal([]string{"+15550101"}))
+			Expect(form["Twiml"]).To(Equal([]string{"<Response><Say>code 1, 2</Say></Response>"}))
+			Expect(form["StatusCallback"]).To(Equal([]string{"https://example.com/voice"}))
+		})
+
+		It("fails with the message of rejected calls", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"code":21211,"message":"invalid To number"}`))
+			}))
+			DeferCleanup(srv.Close)
+
+			t, err := voice.NewTwilio(voice.TwilioConfig{Endpoint: srv.URL, AccountSID: "AC1", AuthToken: "token"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = t.Call(context.Background(), c)
+			Expect(err).To(MatchError(ContainSubstring("invalid To number")))
+		})
+
+		It("needs credentials", func() {
+			_, err := voice.NewTwilio(voice.TwilioConfig{AccountSID: "AC1"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -16,12 +16,28 @@ VALUES (
         $2
     );
 
+-- name: LockPhoneNumber :exec
+-- serializes the transactions adding phone_number until they end
+SELECT pg_advisory_xact_lock(hashtext(@phone_number::text));
+
+-- name: IsPhoneNumberTaken :one
+SELECT EXISTS (
+        SELECT 1
+        FROM phone_numbers
+        WHERE
+            phone_number = $1
+            AND user_id <> $2
+    );
+
 -- name: GetPhoneNumber :one
 SELECT id, user_id, phone_number FROM phone_numbers WHERE id = $1;
 
 -- name: DeletePhoneNumber :one
 DELETE FROM phone_numbers WHERE id = $1 RETURNING id;
 
+-- name: GetPhoneNumbersByUserId :many
+SELECT id, user_id, phone_number FROM phone_numbers WHERE user_id = $1;
+
 -- name: GetPhoneNumbersByUsername :many
 SELECT pn.id, pn.user_id, pn.phone_number
 FROM phone_numbers pn
@@ -29,6 +45,56 @@ FROM phone_numbers pn
 WHERE
     u.username = $1;
 
+-- name: AddPhoneNumberPool :one
+INSERT INTO phone_number_pools (user_id, name, strategy) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetPhoneNumberPool :one
+SELECT * FROM phone_number_pools WHERE id = $1;
+
+-- name: GetPhoneNumberPoolsByUsername :many
+SELECT p.* FROM phone_number_pools p
+    JOIN users u ON p.user_id = u.id
+WHERE u.username = $1 ORDER BY p.id;
+
+-- name: SetPhoneNumberPoolStrategy :one
+UPDATE phone_number_pools SET strategy = $2 WHERE id = $1 RETURNING *;
+
+-- name: DeletePhoneNumberPool :one
+DELETE FROM phone_number_pools WHERE id = $1 RETURNING id;
+
+-- name: AddPoolNumber :one
+INSERT INTO phone_number_pool_members (pool_id, phone_number_id)
+SELECT p.id, pn.id FROM phone_number_pools p
+    JOIN phone_numbers pn ON pn.user_id = p.user_id
+WHERE p.id = @pool_id AND pn.id = @phone_number_id
+RETURNING phone_number_id;
+
+-- name: DeletePoolNumber :one
+DELETE FROM phone_number_pool_members WHERE pool_id = $1 AND phone_number_id = $2 RETURNING phone_number_id;
+
+-- name: GetPoolNumbers :many
+SELECT m.phone_number_id, pn.phone_number, m.last_used_at
+FROM phone_number_pool_members m
+    JOIN phone_numbers pn ON pn.id = m.phone_number_id
+WHERE m.pool_id = $1 ORDER BY m.phone_number_id;
+
+-- name: GetPoolNumberIds :many
+SELECT phone_number_id FROM phone_number_pool_members WHERE pool_id = $1 ORDER BY phone_number_id;
+
+-- name: NextPoolTurn :one
+UPDATE phone_number_pools SET turns = turns + 1 WHERE id = $1 RETURNING turns;
+
+-- name: TakeLeastRecentlyUsedNumber :one
+UPDATE phone_number_pool_members SET last_used_at = CURRENT_TIMESTAMP
+WHERE pool_id = $1 AND phone_number_id = (
+    SELECT phone_number_id FROM phone_number_pool_members
+    WHERE pool_id = $1 ORDER BY last_used_at NULLS FIRST, phone_number_id LIMIT 1
+)
+RETURNING phone_number_id;
+
+-- name: TouchPoolNumber :exec
+UPDATE phone_number_pool_members SET last_used_at = CURRENT_TIMESTAMP WHERE pool_id = $1 AND phone_number_id = $2;
+
 -- name: AddUser :exec
 INSERT INTO users (username, balance) VALUES ($1, $2);
 
@@ -44,23 +110,859 @@ RETURNING
 -- name: GetUserId :one
 SELECT id FROM users u WHERE u.username = $1;
 
--- name: AddSms :exec
-INSERT INTO sms (user_id,phone_number_id,to_phone_number,status,message) VALUES ($1, $2, $3, $4, $5);
+-- name: GetUser :one
+SELECT * FROM users WHERE username = $1;
+
+-- name: ListUsers :many
+SELECT * FROM users WHERE id > @after ORDER BY id LIMIT @lim;
+
+-- name: UpdateUser :one
+UPDATE users
+SET
+    username = COALESCE(sqlc.narg(username), username),
+    footer = CASE WHEN @set_footer::boolean THEN sqlc.narg(footer) ELSE footer END
+WHERE
+    id = @id
+RETURNING *;
+
+-- name: GetUserStatus :one
+SELECT status FROM users WHERE id = $1;
+
+-- name: GetRecipientLimit :one
+SELECT recipient_hourly_limit FROM users WHERE id = $1;
+
+-- name: SetRecipientLimit :one
+UPDATE users SET recipient_hourly_limit = sqlc.narg(recipient_hourly_limit) WHERE id = @id RETURNING *;
+
+-- name: GetExpressAdmission :one
+SELECT express_admission FROM users WHERE id = $1;
+
+-- name: SetExpressAdmission :one
+UPDATE users SET express_admission = sqlc.narg(express_admission) WHERE id = @id RETURNING *;
+
+-- name: SetUserStatus :one
+UPDATE users SET status = @status WHERE id = @id RETURNING *;
+
+-- name: AddSms :one
+INSERT INTO sms (user_id,phone_number_id,to_phone_number,status,message,category,expires_at,priority,cost,external_id,metadata,tags,message_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT DO NOTHING
+RETURNING id;
+
+-- the balance queries charge pooled subaccounts from the balance of their parent
 
 -- name: SubBalance :one
-UPDATE users SET balance = balance - @amount WHERE id = @user_id RETURNING balance;
+UPDATE users SET balance = balance - @amount
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = @user_id)
+RETURNING balance;
+
+-- name: RefundBalance :one
+UPDATE users SET balance = balance + @amount
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = @user_id)
+RETURNING balance;
+
+-- name: ChargeBalance :one
+UPDATE users SET balance = balance - @amount
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = @user_id)
+    AND balance >= @amount
+RETURNING balance;
 
 -- name: GetBalance :one
-SELECT balance FROM users WHERE id = @user_id;
+SELECT b.balance FROM users u
+JOIN users b ON b.id = CASE WHEN u.pooled THEN u.parent_id ELSE u.id END
+WHERE u.id = @user_id;
+
+-- name: GetBalanceByUsername :one
+SELECT b.balance FROM users u
+JOIN users b ON b.id = CASE WHEN u.pooled THEN u.parent_id ELSE u.id END
+WHERE u.username = $1;
+
+-- name: WithdrawBalance :one
+-- takes amount from the balance of a user that isn't pooled, if it covers it
+UPDATE users SET balance = balance - @amount WHERE id = @user_id AND NOT pooled AND balance >= @amount RETURNING balance;
+
+-- name: DepositBalance :one
+UPDATE users SET balance = balance + @amount WHERE id = @user_id AND NOT pooled RETURNING balance;
+
+-- name: AddSubaccount :one
+INSERT INTO users (username, balance, parent_id, pooled, monthly_quota)
+SELECT @username, 0, p.id, @pooled, sqlc.narg(monthly_quota)
+FROM users p
+WHERE p.id = @parent_id AND p.parent_id IS NULL
+RETURNING *;
+
+-- name: GetSubaccounts :many
+SELECT * FROM users WHERE parent_id = $1 ORDER BY id;
+
+-- name: GetSubaccount :one
+SELECT * FROM users WHERE parent_id = @parent_id AND username = @username;
+
+-- name: UpdateSubaccount :one
+UPDATE users
+SET
+    pooled = COALESCE(sqlc.narg(pooled), pooled),
+    monthly_quota = CASE WHEN @set_monthly_quota::boolean THEN sqlc.narg(monthly_quota) ELSE monthly_quota END
+WHERE
+    id = @id
+RETURNING *;
+
+-- name: GetSubaccountUsage :many
+SELECT u.id AS user_id, u.username,
+    COUNT(s.id) AS messages,
+    COUNT(s.id) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(s.id) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (COALESCE(SUM(s.cost), 0) * 100)::BIGINT AS cents
+FROM users u
+LEFT JOIN sms s ON s.user_id = u.id AND s.delivered_at >= @since AND s.delivered_at < @until
+WHERE u.id = @parent_id OR u.parent_id = @parent_id
+GROUP BY u.id, u.username
+ORDER BY u.id;
+
+-- name: GetSmsPrice :one
+-- what an sms of a user costs: the price their reseller set, else cost plus the
+-- margin of their reseller. subaccounts pay the price of their parent
+SELECT COALESCE(u.sms_price, ROUND(@cost::DECIMAL * (1 + COALESCE(r.margin, 0) / 100), 2))::DECIMAL(10,2) AS price
+FROM users s
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE s.id = @user_id;
+
+-- name: GetReseller :one
+SELECT * FROM resellers WHERE user_id = $1;
+
+-- name: AddReseller :one
+-- makes a user a reseller unless it is a customer or a subaccount
+INSERT INTO resellers (user_id)
+SELECT id FROM users WHERE id = $1 AND parent_id IS NULL AND reseller_id IS NULL
+ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+RETURNING *;
+
+-- name: DeleteReseller :execrows
+DELETE FROM resellers WHERE user_id = $1;
+
+-- name: UpdateReseller :one
+UPDATE resellers
+SET
+    margin = COALESCE(sqlc.narg(margin), margin),
+    sender = CASE WHEN @set_sender::boolean THEN sqlc.narg(sender) ELSE sender END,
+    footer = CASE WHEN @set_footer::boolean THEN sqlc.narg(footer) ELSE footer END
+WHERE
+    user_id = @user_id
+RETURNING *;
+
+-- name: AddCustomer :one
+INSERT INTO users (username, balance, reseller_id, sms_price, sender)
+VALUES (@username, 0, @reseller_id, sqlc.narg(sms_price), sqlc.narg(sender))
+RETURNING *;
+
+-- name: GetCustomers :many
+SELECT * FROM users WHERE reseller_id = $1 ORDER BY id;
+
+-- name: GetCustomer :one
+SELECT * FROM users WHERE reseller_id = @reseller_id AND username = @username;
+
+-- name: UpdateCustomer :one
+UPDATE users
+SET
+    sms_price = CASE WHEN @set_sms_price::boolean THEN sqlc.narg(sms_price) ELSE sms_price END,
+    sender = CASE WHEN @set_sender::boolean THEN sqlc.narg(sender) ELSE sender END
+WHERE
+    id = @id
+RETURNING *;
+
+-- name: GetResellerUsage :many
+-- the sms of every customer of a reseller and their subaccounts
+SELECT c.id AS user_id, c.username,
+    COUNT(s.id) AS messages,
+    COUNT(s.id) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(s.id) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (COALESCE(SUM(s.cost), 0) * 100)::BIGINT AS cents
+FROM users c
+JOIN users a ON a.id = c.id OR a.parent_id = c.id
+LEFT JOIN sms s ON s.user_id = a.id AND s.delivered_at >= @since AND s.delivered_at < @until
+WHERE c.reseller_id = @reseller_id
+GROUP BY c.id, c.username
+ORDER BY c.id;
+
+-- name: GetQuotaUsage :one
+-- the monthly quota of a user and the sms charged to them this month
+SELECT u.monthly_quota,
+    (SELECT COUNT(*) FROM sms s WHERE s.user_id = u.id AND s.delivered_at >= date_trunc('month', LOCALTIMESTAMP) AND s.cost > 0) AS used
+FROM users u
+WHERE u.id = $1;
 
 -- name: GetPhoneNumberId :one
 SELECT id FROM phone_numbers WHERE user_id = $1 AND phone_number = $2;
 
 -- name: GetLastSmsMessages :many
-SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
 FROM sms 
 WHERE user_id = $1 
 ORDER BY delivered_at DESC 
 LIMIT $2;
 
+-- name: GetLastSmsMessagesByTag :many
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = @user_id AND tags @> ARRAY[@tag::text]
+ORDER BY delivered_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetTagUsage :many
+SELECT t.tag::text AS tag,
+    COUNT(*) AS messages,
+    COUNT(*) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(*) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (SUM(s.cost) * 100)::BIGINT AS cents
+FROM sms s, unnest(s.tags) AS t(tag)
+WHERE s.user_id = @user_id AND s.delivered_at >= @since AND s.delivered_at < @until
+GROUP BY t.tag
+ORDER BY messages DESC, t.tag;
+
+-- name: GetFailureReasons :many
+SELECT COALESCE(failure_reason, 'unknown')::text AS reason, COUNT(*) AS messages
+FROM sms
+WHERE user_id = @user_id AND status IN ('failed', 'expired')
+    AND delivered_at >= @since AND delivered_at < @until
+GROUP BY 1
+ORDER BY messages DESC, reason;
+
+-- name: GetSmsByMessageId :one
+SELECT s.id, s.to_phone_number, s.message, s.status, s.delivered_at, s.cost, p.phone_number
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+JOIN phone_numbers p ON p.id = s.phone_number_id
+WHERE e.event = 'created' AND e.metadata->>'message_id' = ANY(@message_ids::text[]) AND s.user_id = @user_id;
+
+-- name: GetSmsByExternalId :one
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = $1 AND external_id = $2;
+
+-- name: AddQuietHours :one
+INSERT INTO quiet_hours (user_id, category, start_time, end_time, action) VALUES ($1, $2, $3, $4, $5) RETURNING id, user_id, category, start_time, end_time, action;
+
+-- name: GetQuietHours :many
+SELECT id, user_id, category, start_time, end_time, action FROM quiet_hours WHERE user_id = $1 ORDER BY id;
+
+-- name: DeleteQuietHours :one
+DELETE FROM quiet_hours WHERE id = $1 AND user_id = $2 RETURNING id;
+
+-- name: AddBlockedDestination :one
+INSERT INTO blocked_destinations (user_id, kind, value, reason) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: GetBlockedDestinations :many
+SELECT * FROM blocked_destinations WHERE user_id = $1 ORDER BY id;
+
+-- name: GetGlobalBlockedDestinations :many
+SELECT * FROM blocked_destinations WHERE user_id IS NULL ORDER BY id;
+
+-- name: GetBlockingRules :many
+SELECT * FROM blocked_destinations WHERE user_id = $1 OR user_id IS NULL ORDER BY user_id NULLS FIRST, id;
+
+-- name: DeleteBlockedDestination :one
+DELETE FROM blocked_destinations WHERE id = $1 AND user_id = $2 RETURNING id;
+
+-- name: DeleteGlobalBlockedDestination :one
+DELETE FROM blocked_destinations WHERE id = $1 AND user_id IS NULL RETURNING id;
+
+-- name: GetFooter :one
+-- the footer of a user, or the one their reseller brands its customers with
+SELECT COALESCE(NULLIF(s.footer, ''), r.footer)::VARCHAR AS footer
+FROM users s
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE s.id = $1;
+
+-- name: SetFooter :one
+UPDATE users SET footer = $1 WHERE username = $2 RETURNING footer;
+
+-- name: CountSmsLastMinuteByPriority :many
+SELECT priority, COUNT(*) AS count
+FROM sms
+WHERE delivered_at > CURRENT_TIMESTAMP - INTERVAL '1 minute'
+GROUP BY priority
+ORDER BY priority;
+
+-- name: CountSmsLastHourByStatus :many
+SELECT status, COUNT(*) AS count
+FROM sms
+WHERE delivered_at > CURRENT_TIMESTAMP - INTERVAL '1 hour'
+GROUP BY status
+ORDER BY status;
+
+-- name: GetTopSendersToday :many
+SELECT u.id, u.username, COUNT(s.id) AS messages, SUM(s.cost)::DECIMAL AS spent
+FROM sms s
+    JOIN users u ON u.id = s.user_id
+WHERE s.delivered_at >= date_trunc('day', CURRENT_TIMESTAMP)
+GROUP BY u.id, u.username
+ORDER BY messages DESC
+LIMIT $1;
+
+-- name: GetRevenueToday :one
+SELECT COALESCE(SUM(cost), 0)::DECIMAL AS revenue
+FROM sms
+WHERE delivered_at >= date_trunc('day', CURRENT_TIMESTAMP);
+
+-- name: AddApiKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_cidrs) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from;
+
+-- name: GetApiKeyByHash :one
+SELECT api_keys.id, api_keys.user_id, users.username, api_keys.scopes, api_keys.allowed_cidrs,
+    (CASE WHEN api_keys.throttled_until > CURRENT_TIMESTAMP THEN api_keys.throttle_limit END)::INT AS throttle_limit,
+    api_keys.suspended_at IS NOT NULL AS suspended,
+    ARRAY(SELECT s.id FROM users s WHERE s.parent_id = users.id ORDER BY s.id)::INT[] AS subaccount_ids,
+    ARRAY(SELECT s.username FROM users s WHERE s.parent_id = users.id ORDER BY s.id)::TEXT[] AS subaccount_usernames
+FROM api_keys
+JOIN users ON users.id = api_keys.user_id
+WHERE api_keys.key_hash = $1 AND api_keys.revoked_at IS NULL
+    AND (api_keys.expires_at IS NULL OR api_keys.expires_at > CURRENT_TIMESTAMP);
+
+-- name: GetApiKeysByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from FROM api_keys WHERE user_id = $1 ORDER BY id;
+
+-- name: RevokeApiKey :one
+UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL RETURNING id;
+
+-- name: SetApiKeyAllowedCidrs :one
+UPDATE api_keys SET allowed_cidrs = $1 WHERE id = $2 AND revoked_at IS NULL RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from;
+
+-- name: AddJob :one
+INSERT INTO jobs (user_id, kind, params) VALUES ($1, $2, $3) RETURNING id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at;
+
+-- name: GetJob :one
+SELECT id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at FROM jobs WHERE id = $1;
+
+-- name: StartJob :one
+UPDATE jobs SET status = 'running', updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status IN ('queued', 'running') RETURNING id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at;
+
+-- name: UpdateJobProgress :exec
+UPDATE jobs SET progress = $1, row_count = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3;
+
+-- name: FinishJob :exec
+UPDATE jobs SET status = 'done', progress = 100, row_count = $1, result = $2, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP WHERE id = $3;
+
+-- name: FailJob :exec
+UPDATE jobs SET status = 'failed', error = $1, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP WHERE id = $2;
+
+-- name: CountSmsForExport :one
+SELECT COUNT(*) FROM sms WHERE user_id = $1 AND delivered_at >= $2 AND delivered_at < $3;
+
+-- name: GetSmsForExport :many
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = $1 AND delivered_at >= $2 AND delivered_at < $3 AND id > $4
+ORDER BY id
+LIMIT $5;
+
+-- name: GetUsersToInvoice :many
+SELECT DISTINCT s.user_id
+FROM sms s
+WHERE s.delivered_at >= @period_start::DATE AND s.delivered_at < @period_end::DATE AND s.cost > 0
+    AND NOT EXISTS (SELECT 1 FROM invoices i WHERE i.user_id = s.user_id AND i.period_start = @period_start::DATE)
+ORDER BY s.user_id;
+
+-- name: GetUsageForInvoice :many
+SELECT priority, to_phone_number, COUNT(*) AS quantity, (SUM(cost) * 100)::BIGINT AS cents
+FROM sms
+WHERE user_id = @user_id AND delivered_at >= @period_start::DATE AND delivered_at < @period_end::DATE AND cost > 0
+GROUP BY priority, to_phone_number;
+
+-- name: AddInvoice :one
+INSERT INTO invoices (user_id, period_start, period_end, message_count, total) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, period_start) DO NOTHING
+RETURNING id, user_id, period_start, period_end, message_count, total, created_at;
+
+-- name: AddInvoiceItem :exec
+INSERT INTO invoice_items (invoice_id, priority, country, quantity, amount) VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetInvoice :one
+SELECT id, user_id, period_start, period_end, message_count, total, created_at FROM invoices WHERE id = $1;
+
+-- name: GetInvoicesByUser :many
+SELECT id, user_id, period_start, period_end, message_count, total, created_at FROM invoices WHERE user_id = $1 ORDER BY period_start DESC;
+
+-- name: GetInvoiceItems :many
+SELECT id, invoice_id, priority, country, quantity, amount FROM invoice_items WHERE invoice_id = $1 ORDER BY priority, country;
+
+-- name: GetSmsOwner :one
+SELECT user_id FROM sms WHERE id = $1;
+
+-- name: GetSmsPriority :one
+SELECT priority FROM sms WHERE id = $1;
+
+-- name: AddSmsEvent :exec
+INSERT INTO sms_events (sms_id, event, actor, provider, metadata, occurred_at, request_id) VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetSmsEvents :many
+SELECT id, sms_id, event, actor, provider, metadata, occurred_at, request_id FROM sms_events WHERE sms_id = $1 ORDER BY occurred_at, id;
+
+-- name: GetSmsEventsByRequestId :many
+SELECT id, sms_id, event, actor, provider, metadata, occurred_at, request_id FROM sms_events
+WHERE sms_id IN (SELECT sms_id FROM sms_events e WHERE e.request_id = $1)
+ORDER BY sms_id, occurred_at, id;
+
+-- name: MarkMessageProcessed :execrows
+INSERT INTO processed_messages (message_id, sms_id) VALUES ($1, $2) ON CONFLICT (message_id) DO NOTHING;
+
+-- name: PurgeProcessedMessages :execrows
+DELETE FROM processed_messages WHERE processed_at < $1;
+
+-- name: GetSmsByRef :one
+SELECT s.id, s.priority
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'submitted' AND e.provider = @provider::text AND e.metadata->>'ref' = @ref::text
+ORDER BY e.id DESC
+LIMIT 1;
+
+-- name: GetSmsSubmission :one
+-- the provider the sms was last submitted through and the id it gave the sms
+SELECT CAST(COALESCE(provider, '') AS VARCHAR) AS provider, CAST(COALESCE(metadata->>'ref', '') AS VARCHAR) AS ref
+FROM sms_events
+WHERE sms_id = $1 AND event = 'submitted'
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: GetSmsForSubmit :one
+-- phone_number is the sender of the sms: that of its user or their reseller when
+-- they have one, else the number it was sent from
+SELECT sms.id, sms.status, sms.priority, COALESCE(u.sender, r.sender, phone_numbers.phone_number)::VARCHAR AS phone_number, sms.to_phone_number, sms.message, sms.message_id, sms.user_id, sms.expires_at
+FROM sms
+JOIN phone_numbers ON phone_numbers.id = sms.phone_number_id
+JOIN users s ON s.id = sms.user_id
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE sms.id = $1;
+
+-- name: GetSmsStatusForUpdate :one
+SELECT status, user_id FROM sms WHERE id = $1 FOR UPDATE;
+
+-- name: SetSmsStatus :exec
+UPDATE sms SET status = $1, failure_reason = $3 WHERE id = $2;
+
+-- name: CancelSms :one
+UPDATE sms SET status = 'cancelled', cost = 0
+FROM (SELECT id, cost FROM sms WHERE id = $1 FOR UPDATE) old
+WHERE sms.id = old.id AND sms.status = 'pending'
+RETURNING sms.user_id, old.cost;
+
+-- name: GetOverdueSubmittedSms :many
+-- submitted sms whose validity period ended before $1 without a final report,
+-- oldest first
+SELECT id, priority FROM sms
+WHERE status = 'submitted' AND expires_at < $1
+ORDER BY expires_at
+LIMIT $2;
+
+-- name: ExpirePendingSms :one
+-- expires an sms that was never submitted, its cost is refunded like that of a
+-- cancelled one
+UPDATE sms SET status = 'expired', cost = 0
+FROM (SELECT id, cost FROM sms WHERE id = $1 FOR UPDATE) old
+WHERE sms.id = old.id AND sms.status = 'pending'
+RETURNING sms.user_id, old.cost;
+
+-- name: AddNotification :one
+INSERT INTO notifications (user_id, channel, recipient, subject, message, status, error, sms_id, cost, provider_ref) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;
+
+-- name: GetNotificationStatusForUpdate :one
+SELECT status FROM notifications WHERE id = $1 FOR UPDATE;
+
+-- name: SetNotificationStatus :exec
+UPDATE notifications SET status = $1, error = COALESCE($2, error) WHERE id = $3;
+
+-- name: AddSmsFallback :exec
+INSERT INTO sms_fallbacks (sms_id, channel, recipient, subject, after_seconds) VALUES ($1, $2, $3, $4, $5);
+
+-- name: FireSmsFallback :one
+UPDATE sms_fallbacks f SET fired_at = CURRENT_TIMESTAMP
+FROM sms s
+WHERE f.sms_id = $1 AND s.id = f.sms_id AND f.fired_at IS NULL
+RETURNING f.sms_id, f.channel, f.recipient, f.subject, s.user_id, s.message;
+
+-- name: AddWebhook :one
+INSERT INTO webhooks (user_id, url, secret) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetWebhooks :many
+SELECT * FROM webhooks WHERE user_id = $1 ORDER BY id;
+
+-- name: DeleteWebhook :one
+DELETE FROM webhooks WHERE id = $1 AND user_id = $2 RETURNING id;
+
+-- name: RotateWebhookSecret :one
+UPDATE webhooks
+SET previous_secret = secret, previous_secret_expires_at = $3, secret = $4
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: GetWebhookSecrets :one
+SELECT secret, previous_secret, previous_secret_expires_at FROM webhooks WHERE id = $1;
+
+-- name: GetWebhooksForSms :many
+SELECT w.id, w.url FROM webhooks w
+    JOIN sms s ON s.user_id = w.user_id
+WHERE s.id = $1
+ORDER BY w.id;
+
+-- name: AddWebhookFailure :execrows
+INSERT INTO webhook_failures (webhook_id, event, payload, attempts, last_error)
+SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = $1);
+
+-- name: GetWebhookFailures :many
+SELECT f.id, f.webhook_id, w.url, f.event, f.payload, f.attempts, f.last_error, f.failed_at
+FROM webhook_failures f
+    JOIN webhooks w ON w.id = f.webhook_id
+WHERE w.user_id = $1
+ORDER BY f.id DESC
+LIMIT $2;
+
+-- name: GetWebhookFailure :one
+SELECT f.id, f.webhook_id, w.url, f.event, f.payload, f.attempts, f.last_error, f.failed_at
+FROM webhook_failures f
+    JOIN webhooks w ON w.id = f.webhook_id
+WHERE f.id = $1 AND w.user_id = $2;
+
+-- name: DeleteWebhookFailure :exec
+DELETE FROM webhook_failures WHERE id = $1;
+
+-- name: GetKeyActivity :many
+SELECT CAST(SUBSTRING(e.actor FROM 5) AS INT) AS api_key_id, s.user_id, s.to_phone_number,
+    COUNT(*) AS sent,
+    COUNT(*) FILTER (WHERE s.status = 'failed') AS failed
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'created' AND e.actor LIKE 'key:%' AND e.occurred_at >= @since AND e.occurred_at < @until
+GROUP BY e.actor, s.user_id, s.to_phone_number;
+
+-- name: GetKeySendCounts :many
+SELECT CAST(SUBSTRING(actor FROM 5) AS INT) AS api_key_id, COUNT(*) AS sent
+FROM sms_events
+WHERE event = 'created' AND actor LIKE 'key:%' AND occurred_at >= @since AND occurred_at < @until
+GROUP BY actor;
+
+-- name: ThrottleApiKey :one
+UPDATE api_keys SET throttle_limit = @throttle_limit, throttled_until = @throttled_until
+WHERE id = @id AND revoked_at IS NULL AND suspended_at IS NULL AND (throttled_until IS NULL OR throttled_until <= CURRENT_TIMESTAMP)
+RETURNING id;
+
+-- name: SuspendApiKey :one
+UPDATE api_keys SET suspended_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL AND suspended_at IS NULL RETURNING id;
+
+-- name: LiftApiKeyRestrictions :one
+UPDATE api_keys SET suspended_at = NULL, throttle_limit = NULL, throttled_until = NULL
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from;
+
+-- name: AddFraudAlert :one
+INSERT INTO fraud_alerts (api_key_id, user_id, reason, detail, action) VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: HasFraudAlertSince :one
+SELECT EXISTS (SELECT 1 FROM fraud_alerts WHERE api_key_id = $1 AND created_at >= $2);
+
+-- name: ListFraudAlerts :many
+SELECT * FROM fraud_alerts WHERE id < @before ORDER BY id DESC LIMIT @lim;
+
+-- name: GetSmsLifecycle :one
+SELECT s.priority, s.user_id,
+    CAST(MIN(e.provider) FILTER (WHERE e.event = 'submitted') AS VARCHAR) AS provider,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'created') AS TIMESTAMP) AS created,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'stored') AS TIMESTAMP) AS stored,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'submitted') AS TIMESTAMP) AS submitted,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'delivered') AS TIMESTAMP) AS delivered
+FROM sms s
+LEFT JOIN sms_events e ON e.sms_id = s.id
+WHERE s.id = $1
+GROUP BY s.priority, s.user_id;
+
+-- name: GetDeliveryLatency :one
+SELECT COUNT(*) AS delivered,
+    COUNT(*) FILTER (WHERE l.seconds > @threshold::float8) AS slow,
+    CAST(COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p50,
+    CAST(COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p95,
+    CAST(COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p99
+FROM (
+    SELECT EXTRACT(EPOCH FROM d.occurred_at - c.occurred_at) AS seconds
+    FROM sms_events d
+    JOIN sms_events c ON c.sms_id = d.sms_id AND c.event = 'created'
+    JOIN sms s ON s.id = d.sms_id
+    WHERE d.event = 'delivered' AND d.occurred_at >= @since AND s.priority = @priority
+) l;
+
+-- name: AddImportRows :copyfrom
+INSERT INTO sms_import_rows (job_id, external_id, phone_number_id, to_phone_number, message, status, delivered_at, cost, priority, category) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: MoveImportRows :execrows
+INSERT INTO sms (user_id, external_id, phone_number_id, to_phone_number, message, status, delivered_at, cost, priority, category)
+SELECT @user_id::int, external_id, phone_number_id, to_phone_number, message, status, delivered_at, cost, priority, category
+FROM sms_import_rows
+WHERE job_id = @job_id
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING;
+
+-- name: ClearImportRows :exec
+DELETE FROM sms_import_rows WHERE job_id = $1;
+
+-- name: AddProviderAccount :one
+INSERT INTO provider_accounts (user_id, name, driver, credentials, data_key, prefixes, is_default) VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default;
+
+-- name: GetProviderAccount :one
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts WHERE id = $1;
+
+-- name: ListProviderAccounts :many
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts WHERE user_id = $1 ORDER BY name;
+
+-- name: ListAllProviderAccounts :many
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts ORDER BY id;
+
+-- name: UpdateProviderAccount :one
+UPDATE provider_accounts SET credentials = $2, data_key = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default;
+
+-- name: RewrapProviderAccount :execrows
+UPDATE provider_accounts SET data_key = @data_key WHERE id = @id AND data_key = @previous;
+
+-- name: DeleteProviderAccount :execrows
+DELETE FROM provider_accounts WHERE id = $1;
+
+-- name: SetProviderRouting :one
+UPDATE provider_accounts SET prefixes = $2, is_default = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default;
+
+-- name: ListProviderRoutes :many
+SELECT id, prefixes, is_default FROM provider_accounts
+WHERE user_id = $1 AND (is_default OR cardinality(prefixes) > 0);
+
+-- name: ListSmsToArchive :many
+SELECT s.id, s.user_id, s.phone_number_id, s.to_phone_number, s.status, s.category, s.priority, s.cost,
+    s.external_id, s.tags, s.delivered_at, CAST(e.occurred_at AS TIMESTAMP) AS created_at
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'created' AND e.occurred_at >= @since AND e.occurred_at < @until
+    AND (s.user_id, s.id) > (@after_user_id::int, @after_id::int)
+ORDER BY s.user_id, s.id
+LIMIT @batch_size;
+
+-- name: GetArchiveStart :one
+SELECT CAST(COALESCE(
+    (SELECT MAX(day) + 1 FROM sms_archives),
+    (SELECT MIN(occurred_at)::date FROM sms_events WHERE event = 'created')
+) AS DATE) AS day;
+
+-- name: AddSmsArchive :exec
+INSERT INTO sms_archives (day, row_count, file_count) VALUES ($1, $2, $3)
+ON CONFLICT (day) DO UPDATE SET row_count = EXCLUDED.row_count, file_count = EXCLUDED.file_count, archived_at = CURRENT_TIMESTAMP;
+
+-- name: AddMqttDevice :one
+INSERT INTO mqtt_devices (api_key_id, client_id, secret_hash, daily_quota) VALUES ($1, $2, $3, $4)
+RETURNING id, api_key_id, client_id, secret_hash, daily_quota, quota_day, quota_used, created_at, revoked_at;
+
+-- name: GetMqttDevicesByApiKey :many
+SELECT id, api_key_id, client_id, secret_hash, daily_quota, quota_day, quota_used, created_at, revoked_at FROM mqtt_devices WHERE api_key_id = $1 ORDER BY id;
+
+-- name: GetMqttDevice :one
+SELECT mqtt_devices.id, mqtt_devices.api_key_id, api_keys.user_id, mqtt_devices.secret_hash, api_keys.key_hash,
+    api_keys.suspended_at IS NOT NULL AS suspended
+FROM mqtt_devices
+JOIN api_keys ON api_keys.id = mqtt_devices.api_key_id
+WHERE mqtt_devices.client_id = $1 AND mqtt_devices.revoked_at IS NULL AND api_keys.revoked_at IS NULL;
+
+-- name: RevokeMqttDevice :one
+UPDATE mqtt_devices SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND api_key_id = $2 AND revoked_at IS NULL RETURNING id;
+
+-- name: TakeMqttDeviceQuota :one
+UPDATE mqtt_devices
+SET quota_used = CASE WHEN quota_day = CURRENT_DATE THEN quota_used + 1 ELSE 1 END,
+    quota_day = CURRENT_DATE
+WHERE id = $1 AND (daily_quota IS NULL OR quota_day IS DISTINCT FROM CURRENT_DATE OR quota_used < daily_quota)
+RETURNING quota_used;
+
+-- name: ReleaseMqttDeviceQuota :exec
+UPDATE mqtt_devices SET quota_used = quota_used - 1 WHERE id = $1 AND quota_day = CURRENT_DATE AND quota_used > 0;
+
+-- name: AddEmailSender :one
+INSERT INTO email_senders (api_key_id, address, phone_number_id)
+SELECT api_keys.id, @address, phone_numbers.id
+FROM api_keys
+JOIN phone_numbers ON phone_numbers.user_id = api_keys.user_id
+WHERE api_keys.id = @api_key_id AND phone_numbers.id = @phone_number_id
+RETURNING id, api_key_id, address, phone_number_id, created_at;
+
+-- name: GetEmailSendersByApiKey :many
+SELECT id, api_key_id, address, phone_number_id, created_at FROM email_senders WHERE api_key_id = $1 ORDER BY id;
+
+-- name: GetEmailSender :one
+SELECT email_senders.id, email_senders.phone_number_id, api_keys.user_id, api_keys.key_hash
+FROM email_senders
+JOIN api_keys ON api_keys.id = email_senders.api_key_id
+WHERE email_senders.address = $1 AND api_keys.revoked_at IS NULL;
+
+-- name: DeleteEmailSender :one
+DELETE FROM email_senders WHERE id = $1 AND api_key_id = $2 RETURNING id;
+
+-- name: AddWorkerError :exec
+INSERT INTO worker_errors (message_id, subject, sms_id, priority, stage, class, error, attempt, final, worker, occurred_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (message_id, stage, attempt) DO NOTHING;
+
+-- name: ListWorkerErrors :many
+SELECT * FROM worker_errors
+WHERE id < @before
+    AND (sqlc.narg(stage)::text IS NULL OR stage = sqlc.narg(stage))
+    AND (sqlc.narg(class)::text IS NULL OR class = sqlc.narg(class))
+    AND (sqlc.narg(sms_id)::int IS NULL OR sms_id = sqlc.narg(sms_id))
+ORDER BY id DESC LIMIT @lim;
+
+-- name: PurgeWorkerErrors :execrows
+DELETE FROM worker_errors WHERE created_at < $1;
+
+-- name: GetSchemaVersion :one
+SELECT COALESCE(MAX(version), 0)::int FROM schema_version;
+
+-- name: GetSmsIdByMessageId :one
+SELECT id FROM sms WHERE message_id = $1;
+
+-- name: AddAlertRule :one
+INSERT INTO alert_rules (user_id, kind, threshold, email) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: GetAlertRules :many
+SELECT * FROM alert_rules WHERE user_id = $1 ORDER BY id;
+
+-- name: ListAlertRules :many
+SELECT * FROM alert_rules ORDER BY id;
+
+-- name: DeleteAlertRule :one
+DELETE FROM alert_rules WHERE id = $1 AND user_id = $2 RETURNING id;
+
+-- name: ClaimAlertRule :one
+-- claims firing a rule that didn't fire since @cooldown, for one worker only
+UPDATE alert_rules SET fired_at = @fired_at
+WHERE id = @id AND (fired_at IS NULL OR fired_at < @cooldown)
+RETURNING id;
+
+-- name: GetAlertUsage :many
+-- what the users with alert rules sent: their spend since @day, their messages
+-- and failures since @since and their messages from @baseline to @since
+SELECT s.user_id,
+    COALESCE(SUM(s.cost) FILTER (WHERE s.delivered_at >= @day), 0)::float8 AS spent,
+    COUNT(*) FILTER (WHERE s.delivered_at >= @since) AS sent,
+    COUNT(*) FILTER (WHERE s.delivered_at >= @since AND s.status IN ('failed', 'expired')) AS failed,
+    COUNT(*) FILTER (WHERE s.delivered_at >= @baseline AND s.delivered_at < @since) AS baseline
+FROM sms s
+WHERE s.user_id IN (SELECT user_id FROM alert_rules)
+    AND s.delivered_at >= @earliest AND s.delivered_at < @until
+GROUP BY s.user_id;
+
+-- name: AddAlert :one
+INSERT INTO alerts (rule_id, user_id, kind, threshold, detail) VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: ListAlerts :many
+SELECT * FROM alerts WHERE user_id = @user_id AND id < @before ORDER BY id DESC LIMIT @lim;
+
+-- name: GetQuotaPolicy :one
+SELECT * FROM quota_policies WHERE user_id = $1 AND priority = $2;
+
+-- name: GetQuotaPolicies :many
+SELECT * FROM quota_policies WHERE user_id = $1 ORDER BY priority;
+
+-- name: SetQuotaPolicy :one
+-- returns no rows for an unknown user
+INSERT INTO quota_policies (user_id, priority, sms_limit, window_seconds, burst_credits, recharge_seconds)
+SELECT id, @priority, @sms_limit, @window_seconds, @burst_credits, @recharge_seconds FROM users WHERE id = @user_id
+ON CONFLICT (user_id, priority) DO UPDATE SET
+    sms_limit = EXCLUDED.sms_limit,
+    window_seconds = EXCLUDED.window_seconds,
+    burst_credits = EXCLUDED.burst_credits,
+    recharge_seconds = EXCLUDED.recharge_seconds,
+    updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: DeleteQuotaPolicy :execrows
+DELETE FROM quota_policies WHERE user_id = $1 AND priority = $2;
+
+-- name: GetNatsForward :one
+SELECT * FROM nats_forwards WHERE user_id = $1;
+
+-- name: ListNatsForwards :many
+SELECT * FROM nats_forwards ORDER BY user_id;
+
+-- name: GetNatsForwardUserForSms :one
+-- returns no rows when the owner of the sms forwards nothing
+SELECT f.user_id FROM nats_forwards f
+    JOIN sms s ON s.user_id = f.user_id
+WHERE s.id = $1;
+
+-- name: SetNatsForward :one
+-- returns no rows for an unknown user
+INSERT INTO nats_forwards (user_id, url, subject, jetstream, credentials, data_key)
+SELECT id, @url, @subject, @jetstream, @credentials, @data_key FROM users WHERE id = @user_id
+ON CONFLICT (user_id) DO UPDATE SET
+    url = EXCLUDED.url,
+    subject = EXCLUDED.subject,
+    jetstream = EXCLUDED.jetstream,
+    credentials = EXCLUDED.credentials,
+    data_key = EXCLUDED.data_key,
+    updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: RewrapNatsForward :execrows
+UPDATE nats_forwards SET data_key = @data_key WHERE user_id = @user_id AND data_key = @previous;
+
+-- name: DeleteNatsForward :execrows
+DELETE FROM nats_forwards WHERE user_id = $1;
+
+-- name: NextApiKeyId :one
+-- reserves the id of a key, so signed tokens can carry it
+SELECT nextval(pg_get_serial_sequence('api_keys', 'id'))::INT AS id;
+
+-- name: AddServiceToken :one
+INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, allowed_cidrs, expires_at, service, rotated_from)
+VALUES (@id, @user_id, @name, @prefix, @key_hash, @scopes, @allowed_cidrs, @expires_at, true, @rotated_from)
+RETURNING *;
+
+-- name: GetServiceToken :one
+SELECT api_keys.id, api_keys.user_id, users.username, api_keys.name, api_keys.scopes, api_keys.allowed_cidrs,
+    api_keys.created_at, api_keys.expires_at, api_keys.revoked_at
+FROM api_keys
+JOIN users ON users.id = api_keys.user_id
+WHERE api_keys.id = $1 AND api_keys.service;
+
+-- name: GetServiceTokensByUser :many
+SELECT * FROM api_keys WHERE user_id = $1 AND service ORDER BY id;
+
+-- name: ExpireApiKey :one
+-- brings the expiry of a key forward to expires_at, never back
+UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, @expires_at), @expires_at)
+WHERE id = @id AND revoked_at IS NULL
+RETURNING expires_at;
+
+-- name: TouchApiKeys :exec
+UPDATE api_keys SET last_used_at = u.used_at
+FROM unnest(@ids::INT[], @used_at::TIMESTAMP[]) AS u (id, used_at)
+WHERE api_keys.id = u.id AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < u.used_at);
+
+-- name: GetDisabledServiceTokens :many
+-- the tokens issued after issued_after that were revoked, suspended, or expired
+-- early by a rotation, while their signature may still be valid
+SELECT id FROM api_keys
+WHERE service AND created_at > @issued_after
+    AND (revoked_at IS NOT NULL OR suspended_at IS NOT NULL OR expires_at <= CURRENT_TIMESTAMP);
+
+-- name: RecordWorkerHeartbeat :exec
+-- heartbeats arriving out of order never move last_seen_at back
+INSERT INTO workers (instance_id, hostname, version, started_at, last_seen_at, processed, lag)
+VALUES (@instance_id, @hostname, @version, @started_at, @last_seen_at, @processed, @lag)
+ON CONFLICT (instance_id) DO UPDATE SET
+    hostname = EXCLUDED.hostname,
+    version = EXCLUDED.version,
+    started_at = EXCLUDED.started_at,
+    last_seen_at = EXCLUDED.last_seen_at,
+    processed = EXCLUDED.processed,
+    lag = EXCLUDED.lag
+WHERE workers.last_seen_at < EXCLUDED.last_seen_at;
+
+-- name: ListWorkers :many
+SELECT * FROM workers WHERE last_seen_at >= @seen_since ORDER BY hostname, started_at;
 
+-- name: DeleteWorkersSeenBefore :execrows
+DELETE FROM workers WHERE last_seen_at < @seen_before;
@@ -9,7 +9,7 @@ CREATE TABLE IF NOT EXISTS users (
 CREATE TABLE IF NOT EXISTS phone_numbers (
     id SERIAL PRIMARY KEY,
     user_id INT NOT NULL REFERENCES users (id),
-    phone_number VARCHAR(255) NOT NULL UNIQUE
+    phone_number VARCHAR(255) NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS sms (
@@ -22,3 +22,453 @@ CREATE TABLE IF NOT EXISTS sms (
     delivered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS quiet_hours (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    category VARCHAR(32) NOT NULL DEFAULT 'marketing',
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    action VARCHAR(16) NOT NULL DEFAULT 'defer'
+);
+
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS category VARCHAR(32) NOT NULL DEFAULT 'transactional';
+
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+-- submitted sms still waiting for a report when their validity period ends are
+-- expired by the worker, see workers.Sms.expireSubmitted
+CREATE INDEX IF NOT EXISTS sms_submitted_expires_at_idx ON sms (expires_at) WHERE status = 'submitted' AND expires_at IS NOT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS footer VARCHAR(160);
+
+-- active, suspended or closed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active';
+
+-- messages to one number per hour, api.flood.limit when null
+ALTER TABLE users ADD COLUMN IF NOT EXISTS recipient_hourly_limit INT;
+
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS priority VARCHAR(16) NOT NULL DEFAULT 'normal';
+
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS cost DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs CIDR[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    kind VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    params JSONB NOT NULL DEFAULT '{}',
+    progress INT NOT NULL DEFAULT 0,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    result TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    total DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    id SERIAL PRIMARY KEY,
+    invoice_id INT NOT NULL REFERENCES invoices (id) ON DELETE CASCADE,
+    priority VARCHAR(16) NOT NULL,
+    country VARCHAR(4) NOT NULL,
+    quantity BIGINT NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sms_events (
+    id BIGSERIAL PRIMARY KEY,
+    sms_id INT NOT NULL REFERENCES sms (id) ON DELETE CASCADE,
+    event VARCHAR(16) NOT NULL,
+    actor VARCHAR(64) NOT NULL,
+    provider VARCHAR(64),
+    metadata JSONB NOT NULL DEFAULT '{}',
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sms_events_sms_id_idx ON sms_events (sms_id, occurred_at);
+
+CREATE TABLE IF NOT EXISTS processed_messages (
+    message_id VARCHAR(64) PRIMARY KEY,
+    sms_id INT REFERENCES sms (id) ON DELETE SET NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    channel VARCHAR(16) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL DEFAULT '',
+    message TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    sms_id INT REFERENCES sms (id) ON DELETE SET NULL,
+    cost DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sms_fallbacks (
+    sms_id INT PRIMARY KEY REFERENCES sms (id) ON DELETE CASCADE,
+    channel VARCHAR(16) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+-- seconds after which the fallback fires when the sms still isn't delivered, 0 only falls back on failure
+ALTER TABLE sms_fallbacks ADD COLUMN IF NOT EXISTS after_seconds INT NOT NULL DEFAULT 0;
+
+-- set once the fallback was handed to its channel, so the timeout and a failure can't both fire it
+ALTER TABLE sms_fallbacks ADD COLUMN IF NOT EXISTS fired_at TIMESTAMP;
+
+-- the provider's id of a voice call, whose status is reported after it was placed
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS provider_ref VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- dead letters: deliveries that failed on every attempt
+CREATE TABLE IF NOT EXISTS webhook_failures (
+    id SERIAL PRIMARY KEY,
+    webhook_id INT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT NOT NULL,
+    failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_failures_webhook_id_idx ON webhook_failures (webhook_id);
+
+-- signing secrets. after a rotation the previous secret stays valid until it expires
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS previous_secret VARCHAR(64);
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMP;
+
+-- the http request behind a transition, to trace a message from the request that sent it
+ALTER TABLE sms_events ADD COLUMN IF NOT EXISTS request_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS sms_events_request_id_idx ON sms_events (request_id);
+
+-- destination countries and prefixes sends are refused to
+CREATE TABLE IF NOT EXISTS blocked_destinations (
+    id SERIAL PRIMARY KEY,
+    -- null for the global fraud deny-list
+    user_id INT REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    value VARCHAR(16) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS blocked_destinations_rule_idx ON blocked_destinations (COALESCE(user_id, 0), kind, value);
+
+-- restrictions set by the fraud detector
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS throttle_limit INT;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS throttled_until TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS fraud_alerts (
+    id SERIAL PRIMARY KEY,
+    api_key_id INT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason VARCHAR(32) NOT NULL,
+    detail TEXT NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sms_events_created_idx ON sms_events (occurred_at) WHERE event = 'created';
+
+CREATE INDEX IF NOT EXISTS sms_events_delivered_idx ON sms_events (occurred_at) WHERE event = 'delivered';
+
+-- the client's reference of a message, or its id in the system it was imported from
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS external_id VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sms_external_id_idx ON sms (user_id, external_id) WHERE external_id IS NOT NULL;
+
+-- import jobs copy each batch here and move it to sms in the same transaction,
+-- skipping the external ids that were imported before
+CREATE UNLOGGED TABLE IF NOT EXISTS sms_import_rows (
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    external_id VARCHAR(64) NOT NULL,
+    phone_number_id INT NOT NULL,
+    to_phone_number VARCHAR(255) NOT NULL,
+    message VARCHAR(255) NOT NULL,
+    status VARCHAR(255) NOT NULL,
+    delivered_at TIMESTAMP NOT NULL,
+    cost DECIMAL(10, 2) NOT NULL,
+    priority VARCHAR(16) NOT NULL,
+    category VARCHAR(32) NOT NULL
+);
+
+-- free form metadata and tags clients attach to a message, tags feed the usage reports
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS tags TEXT[];
+
+CREATE INDEX IF NOT EXISTS sms_tags_idx ON sms USING GIN (tags);
+
+-- finds messages by the id the api gave them before they were stored
+CREATE INDEX IF NOT EXISTS sms_events_message_id_idx ON sms_events ((metadata->>'message_id')) WHERE event = 'created';
+
+-- finds messages by the id the carrier gave them, for drivers receiving reports without the client's reference
+CREATE INDEX IF NOT EXISTS sms_events_ref_idx ON sms_events (provider, (metadata->>'ref')) WHERE event = 'submitted';
+
+-- carrier accounts users bring, the credentials sealed with a data key of their own
+-- that is wrapped with the master key, see secrets.Envelope
+CREATE TABLE IF NOT EXISTS provider_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    driver VARCHAR(32) NOT NULL,
+    credentials BYTEA NOT NULL,
+    data_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+-- the sms of the user to destinations starting with one of prefixes are sent through
+-- the account, the others through the account that is_default, see providers.Routes
+ALTER TABLE provider_accounts ADD COLUMN IF NOT EXISTS prefixes TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE provider_accounts ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT false;
+CREATE UNIQUE INDEX IF NOT EXISTS provider_accounts_default_idx ON provider_accounts (user_id) WHERE is_default;
+
+-- the days whose sms were archived to the storage as parquet, see archive.Archiver
+CREATE TABLE IF NOT EXISTS sms_archives (
+    day DATE PRIMARY KEY,
+    row_count BIGINT NOT NULL,
+    file_count INT NOT NULL,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- devices publishing sends over mqtt with an api key, each with a secret and a
+-- quota of its own, see ingest.MQTT
+CREATE TABLE IF NOT EXISTS mqtt_devices (
+    id SERIAL PRIMARY KEY,
+    api_key_id INT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    secret_hash VARCHAR(64) NOT NULL,
+    -- sends per day (UTC), unlimited when null
+    daily_quota INT,
+    quota_day DATE,
+    quota_used INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- addresses whose mails to <number>@api.smtp.domain are sent as sms with an api
+-- key, from one of the key user's numbers, see ingest.Email
+CREATE TABLE IF NOT EXISTS email_senders (
+    id SERIAL PRIMARY KEY,
+    api_key_id INT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    address VARCHAR(254) NOT NULL UNIQUE,
+    phone_number_id INT NOT NULL REFERENCES phone_numbers (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- failures of the workers, published on the error subjects of the sms work
+-- queues, see failures.Failure. a delivery of a message fails once per stage
+CREATE TABLE IF NOT EXISTS worker_errors (
+    id SERIAL PRIMARY KEY,
+    message_id VARCHAR(128) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    -- not a reference, the sms may not be stored or already be purged
+    sms_id INT,
+    priority VARCHAR(16) NOT NULL,
+    stage VARCHAR(32) NOT NULL,
+    class VARCHAR(32) NOT NULL,
+    error TEXT NOT NULL,
+    attempt INT NOT NULL,
+    final BOOLEAN NOT NULL DEFAULT FALSE,
+    worker VARCHAR(255) NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (message_id, stage, attempt)
+);
+
+CREATE INDEX IF NOT EXISTS worker_errors_sms_idx ON worker_errors (sms_id);
+
+CREATE INDEX IF NOT EXISTS worker_errors_created_idx ON worker_errors (created_at);
+
+-- the public id of an sms, returned when it is accepted and used as the reference
+-- with the carriers. sms accepted before it was added have none
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS message_id UUID;
+
+CREATE UNIQUE INDEX IF NOT EXISTS sms_message_id_idx ON sms (message_id);
+
+-- why a failed or expired sms wasn't delivered, normalized across the carriers:
+-- invalid_number, absent_subscriber, spam_filtered, expired or unknown
+ALTER TABLE sms ADD COLUMN IF NOT EXISTS failure_reason VARCHAR(32);
+
+-- phone numbers are unique per user. whether users may share one, like a short
+-- code, is api.phonenumbers.unique, checked when they are added
+ALTER TABLE phone_numbers DROP CONSTRAINT IF EXISTS phone_numbers_phone_number_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS phone_numbers_user_id_phone_number_idx ON phone_numbers (user_id, phone_number);
+
+CREATE INDEX IF NOT EXISTS phone_numbers_phone_number_idx ON phone_numbers (phone_number);
+
+-- subaccounts belong to a parent user, e.g. the teams of a company. pooled
+-- subaccounts are charged from the balance of their parent, the others hold
+-- their own. subaccounts have no subaccounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES users (id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pooled BOOLEAN NOT NULL DEFAULT false;
+
+-- sms a user may send per calendar month, unlimited when NULL
+ALTER TABLE users ADD COLUMN IF NOT EXISTS monthly_quota INT;
+
+CREATE INDEX IF NOT EXISTS users_parent_id_idx ON users (parent_id);
+
+-- resellers manage customers of their own under their brand. their customers pay
+-- sms.cost plus margin percent unless the reseller priced them, and send from
+-- sender with footer unless they have their own
+CREATE TABLE IF NOT EXISTS resellers (
+    user_id INT PRIMARY KEY REFERENCES users (id),
+    margin DECIMAL(5,2) NOT NULL DEFAULT 0,
+    sender VARCHAR(15),
+    footer VARCHAR(160)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reseller_id INT REFERENCES users (id);
+
+-- what an sms of a customer costs, set by its reseller
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sms_price DECIMAL(10,2);
+
+-- the sender the sms of a customer are submitted from in place of their number
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sender VARCHAR(15);
+
+CREATE INDEX IF NOT EXISTS users_reseller_id_idx ON users (reseller_id);
+
+-- what happens to the express sms of a user while the express queue is backed
+-- up: off, reject or downgrade. api.express.admission.policy when null
+ALTER TABLE users ADD COLUMN IF NOT EXISTS express_admission VARCHAR(16);
+
+-- numbers of a user its sms are sent from in turn, to spread their volume.
+-- strategy picks the number of every sms, see policy.ParsePoolStrategy; turns
+-- counts the sms of the pool for round_robin
+CREATE TABLE IF NOT EXISTS phone_number_pools (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    name VARCHAR(64) NOT NULL,
+    strategy VARCHAR(16) NOT NULL DEFAULT 'round_robin',
+    turns BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS phone_number_pool_members (
+    pool_id INT NOT NULL REFERENCES phone_number_pools (id) ON DELETE CASCADE,
+    phone_number_id INT NOT NULL REFERENCES phone_numbers (id) ON DELETE CASCADE,
+    last_used_at TIMESTAMP,
+    PRIMARY KEY (pool_id, phone_number_id)
+);
+
+-- usage alert rules of the users, evaluated by the worker every worker.alerts.interval.
+-- fired_at is claimed by the worker firing the rule, so it fires once per cooldown
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    email VARCHAR(255),
+    fired_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the alerts the rules fired, kept when their rule is deleted
+CREATE TABLE IF NOT EXISTS alerts (
+    id SERIAL PRIMARY KEY,
+    rule_id INT REFERENCES alert_rules (id) ON DELETE SET NULL,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    detail TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS alerts_user_idx ON alerts (user_id, id);
+
+-- soft quotas of the users per priority: sms_limit sms per window_seconds, and
+-- once they're used up burst_credits more, one recharged every recharge_seconds
+CREATE TABLE IF NOT EXISTS quota_policies (
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    priority VARCHAR(16) NOT NULL,
+    sms_limit INT NOT NULL,
+    window_seconds INT NOT NULL,
+    burst_credits INT NOT NULL DEFAULT 0,
+    recharge_seconds INT NOT NULL DEFAULT 60,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, priority)
+);
+
+-- the nats server the delivery events of a user are forwarded to, its credentials
+-- sealed like those of provider_accounts, see forwards.Targets
+CREATE TABLE IF NOT EXISTS nats_forwards (
+    user_id INT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    jetstream BOOLEAN NOT NULL DEFAULT false,
+    credentials BYTEA NOT NULL,
+    data_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- keys stop working at expires_at when it is set. service tokens are keys for
+-- tooling: they always expire, are rotated through the api and may be verified
+-- without the database, see auth.Signer. rotated_from is the token one replaced
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS service BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_from INT REFERENCES api_keys (id);
+
+-- the worker processes as of their last heartbeat, see heartbeats.Record. lag
+-- is the number of messages waiting for each consumer of the worker, by name
+CREATE TABLE IF NOT EXISTS workers (
+    instance_id VARCHAR(64) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    version VARCHAR(64) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    processed BIGINT NOT NULL DEFAULT 0,
+    lag JSONB NOT NULL DEFAULT '{}'
+);
+
+-- the versions of this file applied to the database, the doctor command compares
+-- the latest with db.SchemaVersion. bump both with every change of the schema
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INT PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO schema_version (version) VALUES (14) ON CONFLICT DO NOTHING;
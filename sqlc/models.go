@@ -5,15 +5,222 @@
 package sqlc
 
 import (
+	"encoding/json"
+	"net/netip"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Alert struct {
+	ID        int32            `db:"id" json:"id"`
+	RuleID    pgtype.Int4      `db:"rule_id" json:"rule_id"`
+	UserID    int32            `db:"user_id" json:"user_id"`
+	Kind      string           `db:"kind" json:"kind"`
+	Threshold float64          `db:"threshold" json:"threshold"`
+	Detail    string           `db:"detail" json:"detail"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type AlertRule struct {
+	ID        int32            `db:"id" json:"id"`
+	UserID    int32            `db:"user_id" json:"user_id"`
+	Kind      string           `db:"kind" json:"kind"`
+	Threshold float64          `db:"threshold" json:"threshold"`
+	Email     pgtype.Text      `db:"email" json:"email"`
+	FiredAt   pgtype.Timestamp `db:"fired_at" json:"fired_at"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type ApiKey struct {
+	ID           int32            `db:"id" json:"id"`
+	UserID       int32            `db:"user_id" json:"user_id"`
+	Name         string           `db:"name" json:"name"`
+	Prefix       string           `db:"prefix" json:"prefix"`
+	KeyHash      string           `db:"key_hash" json:"key_hash"`
+	Scopes       []string         `db:"scopes" json:"scopes"`
+	CreatedAt    pgtype.Timestamp `db:"created_at" json:"created_at"`
+	RevokedAt    pgtype.Timestamp `db:"revoked_at" json:"revoked_at"`
+	AllowedCidrs []netip.Prefix   `db:"allowed_cidrs" json:"allowed_cidrs"`
+	// ThrottleLimit replaces the key's rate limit until ThrottledUntil
+	ThrottleLimit  pgtype.Int4      `db:"throttle_limit" json:"throttle_limit"`
+	ThrottledUntil pgtype.Timestamp `db:"throttled_until" json:"throttled_until"`
+	SuspendedAt    pgtype.Timestamp `db:"suspended_at" json:"suspended_at"`
+	ExpiresAt      pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	LastUsedAt     pgtype.Timestamp `db:"last_used_at" json:"last_used_at"`
+	Service        bool             `db:"service" json:"service"`
+	RotatedFrom    pgtype.Int4      `db:"rotated_from" json:"rotated_from"`
+}
+
+type BlockedDestination struct {
+	ID        int32            `db:"id" json:"id"`
+	UserID    pgtype.Int4      `db:"user_id" json:"user_id"`
+	Kind      string           `db:"kind" json:"kind"`
+	Value     string           `db:"value" json:"value"`
+	Reason    string           `db:"reason" json:"reason"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type EmailSender struct {
+	ID            int32            `db:"id" json:"id"`
+	ApiKeyID      int32            `db:"api_key_id" json:"api_key_id"`
+	Address       string           `db:"address" json:"address"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	CreatedAt     pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type FraudAlert struct {
+	ID        int32            `db:"id" json:"id"`
+	ApiKeyID  int32            `db:"api_key_id" json:"api_key_id"`
+	UserID    int32            `db:"user_id" json:"user_id"`
+	Reason    string           `db:"reason" json:"reason"`
+	Detail    string           `db:"detail" json:"detail"`
+	Action    string           `db:"action" json:"action"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type Invoice struct {
+	ID           int32            `db:"id" json:"id"`
+	UserID       int32            `db:"user_id" json:"user_id"`
+	PeriodStart  pgtype.Date      `db:"period_start" json:"period_start"`
+	PeriodEnd    pgtype.Date      `db:"period_end" json:"period_end"`
+	MessageCount int64            `db:"message_count" json:"message_count"`
+	Total        pgtype.Numeric   `db:"total" json:"total"`
+	CreatedAt    pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type InvoiceItem struct {
+	ID        int32          `db:"id" json:"id"`
+	InvoiceID int32          `db:"invoice_id" json:"invoice_id"`
+	Priority  string         `db:"priority" json:"priority"`
+	Country   string         `db:"country" json:"country"`
+	Quantity  int64          `db:"quantity" json:"quantity"`
+	Amount    pgtype.Numeric `db:"amount" json:"amount"`
+}
+
+type Job struct {
+	ID         int32            `db:"id" json:"id"`
+	UserID     int32            `db:"user_id" json:"user_id"`
+	Kind       string           `db:"kind" json:"kind"`
+	Status     string           `db:"status" json:"status"`
+	Params     []byte           `db:"params" json:"params"`
+	Progress   int32            `db:"progress" json:"progress"`
+	RowCount   int64            `db:"row_count" json:"row_count"`
+	Result     pgtype.Text      `db:"result" json:"result"`
+	Error      pgtype.Text      `db:"error" json:"error"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt  pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	FinishedAt pgtype.Timestamp `db:"finished_at" json:"finished_at"`
+}
+
+type MqttDevice struct {
+	ID         int32            `db:"id" json:"id"`
+	ApiKeyID   int32            `db:"api_key_id" json:"api_key_id"`
+	ClientID   string           `db:"client_id" json:"client_id"`
+	SecretHash string           `db:"secret_hash" json:"secret_hash"`
+	DailyQuota pgtype.Int4      `db:"daily_quota" json:"daily_quota"`
+	QuotaDay   pgtype.Date      `db:"quota_day" json:"quota_day"`
+	QuotaUsed  int32            `db:"quota_used" json:"quota_used"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
+	RevokedAt  pgtype.Timestamp `db:"revoked_at" json:"revoked_at"`
+}
+
+type NatsForward struct {
+	UserID      int32            `db:"user_id" json:"user_id"`
+	Url         string           `db:"url" json:"url"`
+	Subject     string           `db:"subject" json:"subject"`
+	Jetstream   bool             `db:"jetstream" json:"jetstream"`
+	Credentials []byte           `db:"credentials" json:"credentials"`
+	DataKey     string           `db:"data_key" json:"data_key"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt   pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+}
+
+type Notification struct {
+	ID          int32            `db:"id" json:"id"`
+	UserID      int32            `db:"user_id" json:"user_id"`
+	Channel     string           `db:"channel" json:"channel"`
+	Recipient   string           `db:"recipient" json:"recipient"`
+	Subject     string           `db:"subject" json:"subject"`
+	Message     string           `db:"message" json:"message"`
+	Status      string           `db:"status" json:"status"`
+	Error       pgtype.Text      `db:"error" json:"error"`
+	SmsID       pgtype.Int4      `db:"sms_id" json:"sms_id"`
+	Cost        pgtype.Numeric   `db:"cost" json:"cost"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+	ProviderRef pgtype.Text      `db:"provider_ref" json:"provider_ref"`
+}
+
 type PhoneNumber struct {
 	ID          int32  `db:"id" json:"id"`
 	UserID      int32  `db:"user_id" json:"user_id"`
 	PhoneNumber string `db:"phone_number" json:"phone_number"`
 }
 
+type PhoneNumberPool struct {
+	ID        int32            `db:"id" json:"id"`
+	UserID    int32            `db:"user_id" json:"user_id"`
+	Name      string           `db:"name" json:"name"`
+	Strategy  string           `db:"strategy" json:"strategy"`
+	Turns     int64            `db:"turns" json:"turns"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type PhoneNumberPoolMember struct {
+	PoolID        int32            `db:"pool_id" json:"pool_id"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	LastUsedAt    pgtype.Timestamp `db:"last_used_at" json:"last_used_at"`
+}
+
+type ProcessedMessage struct {
+	MessageID   string           `db:"message_id" json:"message_id"`
+	SmsID       pgtype.Int4      `db:"sms_id" json:"sms_id"`
+	ProcessedAt pgtype.Timestamp `db:"processed_at" json:"processed_at"`
+}
+
+type ProviderAccount struct {
+	ID          int32            `db:"id" json:"id"`
+	UserID      int32            `db:"user_id" json:"user_id"`
+	Name        string           `db:"name" json:"name"`
+	Driver      string           `db:"driver" json:"driver"`
+	Credentials []byte           `db:"credentials" json:"credentials"`
+	DataKey     string           `db:"data_key" json:"data_key"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt   pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	Prefixes    []string         `db:"prefixes" json:"prefixes"`
+	IsDefault   bool             `db:"is_default" json:"is_default"`
+}
+
+type QuietHour struct {
+	ID        int32       `db:"id" json:"id"`
+	UserID    int32       `db:"user_id" json:"user_id"`
+	Category  string      `db:"category" json:"category"`
+	StartTime pgtype.Time `db:"start_time" json:"start_time"`
+	EndTime   pgtype.Time `db:"end_time" json:"end_time"`
+	Action    string      `db:"action" json:"action"`
+}
+
+type QuotaPolicy struct {
+	UserID          int32            `db:"user_id" json:"user_id"`
+	Priority        string           `db:"priority" json:"priority"`
+	SmsLimit        int32            `db:"sms_limit" json:"sms_limit"`
+	WindowSeconds   int32            `db:"window_seconds" json:"window_seconds"`
+	BurstCredits    int32            `db:"burst_credits" json:"burst_credits"`
+	RechargeSeconds int32            `db:"recharge_seconds" json:"recharge_seconds"`
+	UpdatedAt       pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+}
+
+type Reseller struct {
+	UserID int32          `db:"user_id" json:"user_id"`
+	Margin pgtype.Numeric `db:"margin" json:"margin"`
+	Sender pgtype.Text    `db:"sender" json:"sender"`
+	Footer pgtype.Text    `db:"footer" json:"footer"`
+}
+
+type SchemaVersion struct {
+	Version   int32            `db:"version" json:"version"`
+	AppliedAt pgtype.Timestamp `db:"applied_at" json:"applied_at"`
+}
+
 type Sm struct {
 	ID            int32            `db:"id" json:"id"`
 	UserID        int32            `db:"user_id" json:"user_id"`
@@ -22,10 +229,124 @@ type Sm struct {
 	Message       string           `db:"message" json:"message"`
 	Status        string           `db:"status" json:"status"`
 	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	Category      string           `db:"category" json:"category"`
+	ExpiresAt     pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Priority      string           `db:"priority" json:"priority"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	ExternalID    pgtype.Text      `db:"external_id" json:"external_id"`
+	Metadata      json.RawMessage  `db:"metadata" json:"metadata"`
+	Tags          []string         `db:"tags" json:"tags"`
+	MessageID     pgtype.UUID      `db:"message_id" json:"message_id"`
+	FailureReason pgtype.Text      `db:"failure_reason" json:"failure_reason"`
+}
+
+type SmsImportRow struct {
+	JobID         int32            `db:"job_id" json:"job_id"`
+	ExternalID    string           `db:"external_id" json:"external_id"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Message       string           `db:"message" json:"message"`
+	Status        string           `db:"status" json:"status"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	Priority      string           `db:"priority" json:"priority"`
+	Category      string           `db:"category" json:"category"`
+}
+
+type SmsArchive struct {
+	Day        pgtype.Date      `db:"day" json:"day"`
+	RowCount   int64            `db:"row_count" json:"row_count"`
+	FileCount  int32            `db:"file_count" json:"file_count"`
+	ArchivedAt pgtype.Timestamp `db:"archived_at" json:"archived_at"`
+}
+
+type SmsEvent struct {
+	ID         int64            `db:"id" json:"id"`
+	SmsID      int32            `db:"sms_id" json:"sms_id"`
+	Event      string           `db:"event" json:"event"`
+	Actor      string           `db:"actor" json:"actor"`
+	Provider   pgtype.Text      `db:"provider" json:"provider"`
+	Metadata   []byte           `db:"metadata" json:"metadata"`
+	OccurredAt pgtype.Timestamp `db:"occurred_at" json:"occurred_at"`
+	RequestID  pgtype.Text      `db:"request_id" json:"request_id"`
+}
+
+type SmsFallback struct {
+	SmsID        int32            `db:"sms_id" json:"sms_id"`
+	Channel      string           `db:"channel" json:"channel"`
+	Recipient    string           `db:"recipient" json:"recipient"`
+	Subject      string           `db:"subject" json:"subject"`
+	AfterSeconds int32            `db:"after_seconds" json:"after_seconds"`
+	FiredAt      pgtype.Timestamp `db:"fired_at" json:"fired_at"`
 }
 
 type User struct {
 	ID       int32          `db:"id" json:"id"`
 	Username string         `binding:"required,alphanum" db:"username" json:"username"`
 	Balance  pgtype.Numeric `db:"balance" json:"balance"`
+	Footer   pgtype.Text    `db:"footer" json:"footer"`
+	Status   string         `db:"status" json:"status"`
+	// RecipientHourlyLimit caps the messages to one number per hour, api.flood.limit when null
+	RecipientHourlyLimit pgtype.Int4 `db:"recipient_hourly_limit" json:"recipient_hourly_limit"`
+	// ParentID is the user a subaccount belongs to
+	ParentID pgtype.Int4 `db:"parent_id" json:"parent_id"`
+	// Pooled subaccounts are charged from the balance of their parent
+	Pooled bool `db:"pooled" json:"pooled"`
+	// MonthlyQuota caps the sms sent per month, unlimited when null
+	MonthlyQuota pgtype.Int4 `db:"monthly_quota" json:"monthly_quota"`
+	// ResellerID is the reseller managing a customer
+	ResellerID pgtype.Int4 `db:"reseller_id" json:"reseller_id"`
+	// SmsPrice is what an sms of a customer costs, set by its reseller
+	SmsPrice pgtype.Numeric `db:"sms_price" json:"sms_price"`
+	// Sender replaces the number the sms of a customer are submitted from
+	Sender pgtype.Text `db:"sender" json:"sender"`
+	// ExpressAdmission is what happens to express sms while the express queue is
+	// backed up, api.express.admission.policy when null
+	ExpressAdmission pgtype.Text `db:"express_admission" json:"express_admission"`
+}
+
+type Webhook struct {
+	ID                      int32            `db:"id" json:"id"`
+	UserID                  int32            `db:"user_id" json:"user_id"`
+	Url                     string           `db:"url" json:"url"`
+	CreatedAt               pgtype.Timestamp `db:"created_at" json:"created_at"`
+	Secret                  string           `db:"secret" json:"secret"`
+	PreviousSecret          pgtype.Text      `db:"previous_secret" json:"previous_secret"`
+	PreviousSecretExpiresAt pgtype.Timestamp `db:"previous_secret_expires_at" json:"previous_secret_expires_at"`
+}
+
+type WebhookFailure struct {
+	ID        int32            `db:"id" json:"id"`
+	WebhookID int32            `db:"webhook_id" json:"webhook_id"`
+	Event     string           `db:"event" json:"event"`
+	Payload   []byte           `db:"payload" json:"payload"`
+	Attempts  int32            `db:"attempts" json:"attempts"`
+	LastError string           `db:"last_error" json:"last_error"`
+	FailedAt  pgtype.Timestamp `db:"failed_at" json:"failed_at"`
+}
+
+type Worker struct {
+	InstanceID string           `db:"instance_id" json:"instance_id"`
+	Hostname   string           `db:"hostname" json:"hostname"`
+	Version    string           `db:"version" json:"version"`
+	StartedAt  pgtype.Timestamp `db:"started_at" json:"started_at"`
+	LastSeenAt pgtype.Timestamp `db:"last_seen_at" json:"last_seen_at"`
+	Processed  int64            `db:"processed" json:"processed"`
+	Lag        []byte           `db:"lag" json:"lag"`
+}
+
+type WorkerError struct {
+	ID         int32            `db:"id" json:"id"`
+	MessageID  string           `db:"message_id" json:"message_id"`
+	Subject    string           `db:"subject" json:"subject"`
+	SmsID      pgtype.Int4      `db:"sms_id" json:"sms_id"`
+	Priority   string           `db:"priority" json:"priority"`
+	Stage      string           `db:"stage" json:"stage"`
+	Class      string           `db:"class" json:"class"`
+	Error      string           `db:"error" json:"error"`
+	Attempt    int32            `db:"attempt" json:"attempt"`
+	Final      bool             `db:"final" json:"final"`
+	Worker     string           `db:"worker" json:"worker"`
+	OccurredAt pgtype.Timestamp `db:"occurred_at" json:"occurred_at"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
 }
@@ -7,10 +7,120 @@ package sqlc
 
 import (
 	"context"
+	"encoding/json"
+	"net/netip"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addAlert = `-- name: AddAlert :one
+INSERT INTO alerts (rule_id, user_id, kind, threshold, detail) VALUES ($1, $2, $3, $4, $5) RETURNING id, rule_id, user_id, kind, threshold, detail, created_at
+`
+
+type AddAlertParams struct {
+	RuleID    pgtype.Int4 `db:"rule_id" json:"rule_id"`
+	UserID    int32       `db:"user_id" json:"user_id"`
+	Kind      string      `db:"kind" json:"kind"`
+	Threshold float64     `db:"threshold" json:"threshold"`
+	Detail    string      `db:"detail" json:"detail"`
+}
+
+func (q *Queries) AddAlert(ctx context.Context, arg AddAlertParams) (Alert, error) {
+	row := q.db.QueryRow(ctx, addAlert,
+		arg.RuleID,
+		arg.UserID,
+		arg.Kind,
+		arg.Threshold,
+		arg.Detail,
+	)
+	var i Alert
+	err := row.Scan(
+		&i.ID,
+		&i.RuleID,
+		&i.UserID,
+		&i.Kind,
+		&i.Threshold,
+		&i.Detail,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const addAlertRule = `-- name: AddAlertRule :one
+INSERT INTO alert_rules (user_id, kind, threshold, email) VALUES ($1, $2, $3, $4) RETURNING id, user_id, kind, threshold, email, fired_at, created_at
+`
+
+type AddAlertRuleParams struct {
+	UserID    int32       `db:"user_id" json:"user_id"`
+	Kind      string      `db:"kind" json:"kind"`
+	Threshold float64     `db:"threshold" json:"threshold"`
+	Email     pgtype.Text `db:"email" json:"email"`
+}
+
+func (q *Queries) AddAlertRule(ctx context.Context, arg AddAlertRuleParams) (AlertRule, error) {
+	row := q.db.QueryRow(ctx, addAlertRule,
+		arg.UserID,
+		arg.Kind,
+		arg.Threshold,
+		arg.Email,
+	)
+	var i AlertRule
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.Threshold,
+		&i.Email,
+		&i.FiredAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const addApiKey = `-- name: AddApiKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_cidrs) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from
+`
+
+type AddApiKeyParams struct {
+	UserID       int32          `db:"user_id" json:"user_id"`
+	Name         string         `db:"name" json:"name"`
+	Prefix       string         `db:"prefix" json:"prefix"`
+	KeyHash      string         `db:"key_hash" json:"key_hash"`
+	Scopes       []string       `db:"scopes" json:"scopes"`
+	AllowedCidrs []netip.Prefix `db:"allowed_cidrs" json:"allowed_cidrs"`
+}
+
+func (q *Queries) AddApiKey(ctx context.Context, arg AddApiKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, addApiKey,
+		arg.UserID,
+		arg.Name,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.AllowedCidrs,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.AllowedCidrs,
+		&i.ThrottleLimit,
+		&i.ThrottledUntil,
+		&i.SuspendedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.Service,
+		&i.RotatedFrom,
+	)
+	return i, err
+}
+
 const addBalance = `-- name: AddBalance :one
 UPDATE users
 SET
@@ -33,6 +143,306 @@ func (q *Queries) AddBalance(ctx context.Context, arg AddBalanceParams) (pgtype.
 	return balance, err
 }
 
+const addBlockedDestination = `-- name: AddBlockedDestination :one
+INSERT INTO blocked_destinations (user_id, kind, value, reason) VALUES ($1, $2, $3, $4) RETURNING id, user_id, kind, value, reason, created_at
+`
+
+type AddBlockedDestinationParams struct {
+	UserID pgtype.Int4 `db:"user_id" json:"user_id"`
+	Kind   string      `db:"kind" json:"kind"`
+	Value  string      `db:"value" json:"value"`
+	Reason string      `db:"reason" json:"reason"`
+}
+
+func (q *Queries) AddBlockedDestination(ctx context.Context, arg AddBlockedDestinationParams) (BlockedDestination, error) {
+	row := q.db.QueryRow(ctx, addBlockedDestination,
+		arg.UserID,
+		arg.Kind,
+		arg.Value,
+		arg.Reason,
+	)
+	var i BlockedDestination
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.Value,
+		&i.Reason,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const addCustomer = `-- name: AddCustomer :one
+INSERT INTO users (username, balance, reseller_id, sms_price, sender)
+VALUES ($1, 0, $2, $3, $4)
+RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type AddCustomerParams struct {
+	Username   string         `db:"username" json:"username"`
+	ResellerID pgtype.Int4    `db:"reseller_id" json:"reseller_id"`
+	SmsPrice   pgtype.Numeric `db:"sms_price" json:"sms_price"`
+	Sender     pgtype.Text    `db:"sender" json:"sender"`
+}
+
+func (q *Queries) AddCustomer(ctx context.Context, arg AddCustomerParams) (User, error) {
+	row := q.db.QueryRow(ctx, addCustomer,
+		arg.Username,
+		arg.ResellerID,
+		arg.SmsPrice,
+		arg.Sender,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const addEmailSender = `-- name: AddEmailSender :one
+INSERT INTO email_senders (api_key_id, address, phone_number_id)
+SELECT api_keys.id, $1, phone_numbers.id
+FROM api_keys
+JOIN phone_numbers ON phone_numbers.user_id = api_keys.user_id
+WHERE api_keys.id = $2 AND phone_numbers.id = $3
+RETURNING id, api_key_id, address, phone_number_id, created_at
+`
+
+type AddEmailSenderParams struct {
+	Address       string `db:"address" json:"address"`
+	ApiKeyID      int32  `db:"api_key_id" json:"api_key_id"`
+	PhoneNumberID int32  `db:"phone_number_id" json:"phone_number_id"`
+}
+
+func (q *Queries) AddEmailSender(ctx context.Context, arg AddEmailSenderParams) (EmailSender, error) {
+	row := q.db.QueryRow(ctx, addEmailSender, arg.Address, arg.ApiKeyID, arg.PhoneNumberID)
+	var i EmailSender
+	err := row.Scan(
+		&i.ID,
+		&i.ApiKeyID,
+		&i.Address,
+		&i.PhoneNumberID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const addFraudAlert = `-- name: AddFraudAlert :one
+INSERT INTO fraud_alerts (api_key_id, user_id, reason, detail, action) VALUES ($1, $2, $3, $4, $5) RETURNING id, api_key_id, user_id, reason, detail, action, created_at
+`
+
+type AddFraudAlertParams struct {
+	ApiKeyID int32  `db:"api_key_id" json:"api_key_id"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+	Reason   string `db:"reason" json:"reason"`
+	Detail   string `db:"detail" json:"detail"`
+	Action   string `db:"action" json:"action"`
+}
+
+func (q *Queries) AddFraudAlert(ctx context.Context, arg AddFraudAlertParams) (FraudAlert, error) {
+	row := q.db.QueryRow(ctx, addFraudAlert,
+		arg.ApiKeyID,
+		arg.UserID,
+		arg.Reason,
+		arg.Detail,
+		arg.Action,
+	)
+	var i FraudAlert
+	err := row.Scan(
+		&i.ID,
+		&i.ApiKeyID,
+		&i.UserID,
+		&i.Reason,
+		&i.Detail,
+		&i.Action,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+type AddImportRowsParams struct {
+	JobID         int32            `db:"job_id" json:"job_id"`
+	ExternalID    string           `db:"external_id" json:"external_id"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Message       string           `db:"message" json:"message"`
+	Status        string           `db:"status" json:"status"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	Priority      string           `db:"priority" json:"priority"`
+	Category      string           `db:"category" json:"category"`
+}
+
+const addInvoice = `-- name: AddInvoice :one
+INSERT INTO invoices (user_id, period_start, period_end, message_count, total) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, period_start) DO NOTHING
+RETURNING id, user_id, period_start, period_end, message_count, total, created_at
+`
+
+type AddInvoiceParams struct {
+	UserID       int32          `db:"user_id" json:"user_id"`
+	PeriodStart  pgtype.Date    `db:"period_start" json:"period_start"`
+	PeriodEnd    pgtype.Date    `db:"period_end" json:"period_end"`
+	MessageCount int64          `db:"message_count" json:"message_count"`
+	Total        pgtype.Numeric `db:"total" json:"total"`
+}
+
+func (q *Queries) AddInvoice(ctx context.Context, arg AddInvoiceParams) (Invoice, error) {
+	row := q.db.QueryRow(ctx, addInvoice,
+		arg.UserID,
+		arg.PeriodStart,
+		arg.PeriodEnd,
+		arg.MessageCount,
+		arg.Total,
+	)
+	var i Invoice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.PeriodStart,
+		&i.PeriodEnd,
+		&i.MessageCount,
+		&i.Total,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const addInvoiceItem = `-- name: AddInvoiceItem :exec
+INSERT INTO invoice_items (invoice_id, priority, country, quantity, amount) VALUES ($1, $2, $3, $4, $5)
+`
+
+type AddInvoiceItemParams struct {
+	InvoiceID int32          `db:"invoice_id" json:"invoice_id"`
+	Priority  string         `db:"priority" json:"priority"`
+	Country   string         `db:"country" json:"country"`
+	Quantity  int64          `db:"quantity" json:"quantity"`
+	Amount    pgtype.Numeric `db:"amount" json:"amount"`
+}
+
+func (q *Queries) AddInvoiceItem(ctx context.Context, arg AddInvoiceItemParams) error {
+	_, err := q.db.Exec(ctx, addInvoiceItem,
+		arg.InvoiceID,
+		arg.Priority,
+		arg.Country,
+		arg.Quantity,
+		arg.Amount,
+	)
+	return err
+}
+
+const addJob = `-- name: AddJob :one
+INSERT INTO jobs (user_id, kind, params) VALUES ($1, $2, $3) RETURNING id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at
+`
+
+type AddJobParams struct {
+	UserID int32  `db:"user_id" json:"user_id"`
+	Kind   string `db:"kind" json:"kind"`
+	Params []byte `db:"params" json:"params"`
+}
+
+func (q *Queries) AddJob(ctx context.Context, arg AddJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, addJob, arg.UserID, arg.Kind, arg.Params)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.Status,
+		&i.Params,
+		&i.Progress,
+		&i.RowCount,
+		&i.Result,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const addMqttDevice = `-- name: AddMqttDevice :one
+INSERT INTO mqtt_devices (api_key_id, client_id, secret_hash, daily_quota) VALUES ($1, $2, $3, $4)
+RETURNING id, api_key_id, client_id, secret_hash, daily_quota, quota_day, quota_used, created_at, revoked_at
+`
+
+type AddMqttDeviceParams struct {
+	ApiKeyID   int32       `db:"api_key_id" json:"api_key_id"`
+	ClientID   string      `db:"client_id" json:"client_id"`
+	SecretHash string      `db:"secret_hash" json:"secret_hash"`
+	DailyQuota pgtype.Int4 `db:"daily_quota" json:"daily_quota"`
+}
+
+func (q *Queries) AddMqttDevice(ctx context.Context, arg AddMqttDeviceParams) (MqttDevice, error) {
+	row := q.db.QueryRow(ctx, addMqttDevice,
+		arg.ApiKeyID,
+		arg.ClientID,
+		arg.SecretHash,
+		arg.DailyQuota,
+	)
+	var i MqttDevice
+	err := row.Scan(
+		&i.ID,
+		&i.ApiKeyID,
+		&i.ClientID,
+		&i.SecretHash,
+		&i.DailyQuota,
+		&i.QuotaDay,
+		&i.QuotaUsed,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const addNotification = `-- name: AddNotification :one
+INSERT INTO notifications (user_id, channel, recipient, subject, message, status, error, sms_id, cost, provider_ref) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+`
+
+type AddNotificationParams struct {
+	UserID      int32          `db:"user_id" json:"user_id"`
+	Channel     string         `db:"channel" json:"channel"`
+	Recipient   string         `db:"recipient" json:"recipient"`
+	Subject     string         `db:"subject" json:"subject"`
+	Message     string         `db:"message" json:"message"`
+	Status      string         `db:"status" json:"status"`
+	Error       pgtype.Text    `db:"error" json:"error"`
+	SmsID       pgtype.Int4    `db:"sms_id" json:"sms_id"`
+	Cost        pgtype.Numeric `db:"cost" json:"cost"`
+	ProviderRef pgtype.Text    `db:"provider_ref" json:"provider_ref"`
+}
+
+func (q *Queries) AddNotification(ctx context.Context, arg AddNotificationParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addNotification,
+		arg.UserID,
+		arg.Channel,
+		arg.Recipient,
+		arg.Subject,
+		arg.Message,
+		arg.Status,
+		arg.Error,
+		arg.SmsID,
+		arg.Cost,
+		arg.ProviderRef,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
 const addPhoneNumber = `-- name: AddPhoneNumber :exec
 INSERT INTO
     phone_numbers (user_id, phone_number)
@@ -73,183 +483,4779 @@ func (q *Queries) AddPhoneNumberByUsername(ctx context.Context, arg AddPhoneNumb
 	return err
 }
 
-const addSms = `-- name: AddSms :exec
-INSERT INTO sms (user_id,phone_number_id,to_phone_number,status,message) VALUES ($1, $2, $3, $4, $5)
+const addPhoneNumberPool = `-- name: AddPhoneNumberPool :one
+INSERT INTO phone_number_pools (user_id, name, strategy) VALUES ($1, $2, $3) RETURNING id, user_id, name, strategy, turns, created_at
 `
 
-type AddSmsParams struct {
-	UserID        int32  `db:"user_id" json:"user_id"`
-	PhoneNumberID int32  `db:"phone_number_id" json:"phone_number_id"`
-	ToPhoneNumber string `db:"to_phone_number" json:"to_phone_number"`
-	Status        string `db:"status" json:"status"`
-	Message       string `db:"message" json:"message"`
+type AddPhoneNumberPoolParams struct {
+	UserID   int32  `db:"user_id" json:"user_id"`
+	Name     string `db:"name" json:"name"`
+	Strategy string `db:"strategy" json:"strategy"`
 }
 
-func (q *Queries) AddSms(ctx context.Context, arg AddSmsParams) error {
-	_, err := q.db.Exec(ctx, addSms,
-		arg.UserID,
-		arg.PhoneNumberID,
-		arg.ToPhoneNumber,
-		arg.Status,
-		arg.Message,
+func (q *Queries) AddPhoneNumberPool(ctx context.Context, arg AddPhoneNumberPoolParams) (PhoneNumberPool, error) {
+	row := q.db.QueryRow(ctx, addPhoneNumberPool, arg.UserID, arg.Name, arg.Strategy)
+	var i PhoneNumberPool
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Strategy,
+		&i.Turns,
+		&i.CreatedAt,
 	)
-	return err
+	return i, err
 }
 
-const addUser = `-- name: AddUser :exec
-INSERT INTO users (username, balance) VALUES ($1, $2)
+const addPoolNumber = `-- name: AddPoolNumber :one
+INSERT INTO phone_number_pool_members (pool_id, phone_number_id)
+SELECT p.id, pn.id FROM phone_number_pools p
+    JOIN phone_numbers pn ON pn.user_id = p.user_id
+WHERE p.id = $1 AND pn.id = $2
+RETURNING phone_number_id
 `
 
-type AddUserParams struct {
-	Username string         `binding:"required,alphanum" db:"username" json:"username"`
-	Balance  pgtype.Numeric `db:"balance" json:"balance"`
+type AddPoolNumberParams struct {
+	PoolID        int32 `db:"pool_id" json:"pool_id"`
+	PhoneNumberID int32 `db:"phone_number_id" json:"phone_number_id"`
 }
 
-func (q *Queries) AddUser(ctx context.Context, arg AddUserParams) error {
-	_, err := q.db.Exec(ctx, addUser, arg.Username, arg.Balance)
-	return err
+func (q *Queries) AddPoolNumber(ctx context.Context, arg AddPoolNumberParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addPoolNumber, arg.PoolID, arg.PhoneNumberID)
+	var phone_number_id int32
+	err := row.Scan(&phone_number_id)
+	return phone_number_id, err
 }
 
-const deletePhoneNumber = `-- name: DeletePhoneNumber :one
-DELETE FROM phone_numbers WHERE id = $1 RETURNING id
+const addProviderAccount = `-- name: AddProviderAccount :one
+INSERT INTO provider_accounts (user_id, name, driver, credentials, data_key, prefixes, is_default) VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default
 `
 
-func (q *Queries) DeletePhoneNumber(ctx context.Context, id int32) (int32, error) {
-	row := q.db.QueryRow(ctx, deletePhoneNumber, id)
-	err := row.Scan(&id)
-	return id, err
+type AddProviderAccountParams struct {
+	UserID      int32    `db:"user_id" json:"user_id"`
+	Name        string   `db:"name" json:"name"`
+	Driver      string   `db:"driver" json:"driver"`
+	Credentials []byte   `db:"credentials" json:"credentials"`
+	DataKey     string   `db:"data_key" json:"data_key"`
+	Prefixes    []string `db:"prefixes" json:"prefixes"`
+	IsDefault   bool     `db:"is_default" json:"is_default"`
 }
 
-const getBalance = `-- name: GetBalance :one
-SELECT balance FROM users WHERE id = $1
-`
-
-func (q *Queries) GetBalance(ctx context.Context, userID int32) (pgtype.Numeric, error) {
-	row := q.db.QueryRow(ctx, getBalance, userID)
-	var balance pgtype.Numeric
-	err := row.Scan(&balance)
-	return balance, err
+func (q *Queries) AddProviderAccount(ctx context.Context, arg AddProviderAccountParams) (ProviderAccount, error) {
+	row := q.db.QueryRow(ctx, addProviderAccount,
+		arg.UserID,
+		arg.Name,
+		arg.Driver,
+		arg.Credentials,
+		arg.DataKey,
+		arg.Prefixes,
+		arg.IsDefault,
+	)
+	var i ProviderAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Driver,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Prefixes,
+		&i.IsDefault,
+	)
+	return i, err
 }
 
-const getLastSmsMessages = `-- name: GetLastSmsMessages :many
-SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at
-FROM sms 
-WHERE user_id = $1 
-ORDER BY delivered_at DESC 
-LIMIT $2
+const addQuietHours = `-- name: AddQuietHours :one
+INSERT INTO quiet_hours (user_id, category, start_time, end_time, action) VALUES ($1, $2, $3, $4, $5) RETURNING id, user_id, category, start_time, end_time, action
 `
 
-type GetLastSmsMessagesParams struct {
-	UserID int32 `db:"user_id" json:"user_id"`
-	Limit  int32 `db:"limit" json:"limit"`
+type AddQuietHoursParams struct {
+	UserID    int32       `db:"user_id" json:"user_id"`
+	Category  string      `db:"category" json:"category"`
+	StartTime pgtype.Time `db:"start_time" json:"start_time"`
+	EndTime   pgtype.Time `db:"end_time" json:"end_time"`
+	Action    string      `db:"action" json:"action"`
 }
 
-func (q *Queries) GetLastSmsMessages(ctx context.Context, arg GetLastSmsMessagesParams) ([]Sm, error) {
-	rows, err := q.db.Query(ctx, getLastSmsMessages, arg.UserID, arg.Limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Sm
-	for rows.Next() {
-		var i Sm
-		if err := rows.Scan(
-			&i.ID,
-			&i.UserID,
-			&i.PhoneNumberID,
-			&i.ToPhoneNumber,
-			&i.Message,
-			&i.Status,
-			&i.DeliveredAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) AddQuietHours(ctx context.Context, arg AddQuietHoursParams) (QuietHour, error) {
+	row := q.db.QueryRow(ctx, addQuietHours,
+		arg.UserID,
+		arg.Category,
+		arg.StartTime,
+		arg.EndTime,
+		arg.Action,
+	)
+	var i QuietHour
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Category,
+		&i.StartTime,
+		&i.EndTime,
+		&i.Action,
+	)
+	return i, err
 }
 
-const getPhoneNumber = `-- name: GetPhoneNumber :one
-SELECT id, user_id, phone_number FROM phone_numbers WHERE id = $1
+const addReseller = `-- name: AddReseller :one
+INSERT INTO resellers (user_id)
+SELECT id FROM users WHERE id = $1 AND parent_id IS NULL AND reseller_id IS NULL
+ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+RETURNING user_id, margin, sender, footer
 `
 
-func (q *Queries) GetPhoneNumber(ctx context.Context, id int32) (PhoneNumber, error) {
-	row := q.db.QueryRow(ctx, getPhoneNumber, id)
-	var i PhoneNumber
-	err := row.Scan(&i.ID, &i.UserID, &i.PhoneNumber)
+// makes a user a reseller unless it is a customer or a subaccount
+func (q *Queries) AddReseller(ctx context.Context, userID int32) (Reseller, error) {
+	row := q.db.QueryRow(ctx, addReseller, userID)
+	var i Reseller
+	err := row.Scan(
+		&i.UserID,
+		&i.Margin,
+		&i.Sender,
+		&i.Footer,
+	)
 	return i, err
 }
 
-const getPhoneNumberId = `-- name: GetPhoneNumberId :one
-SELECT id FROM phone_numbers WHERE user_id = $1 AND phone_number = $2
+const addServiceToken = `-- name: AddServiceToken :one
+INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, allowed_cidrs, expires_at, service, rotated_from)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true, $9)
+RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from
 `
 
-type GetPhoneNumberIdParams struct {
-	UserID      int32  `db:"user_id" json:"user_id"`
-	PhoneNumber string `db:"phone_number" json:"phone_number"`
+type AddServiceTokenParams struct {
+	ID           int32            `db:"id" json:"id"`
+	UserID       int32            `db:"user_id" json:"user_id"`
+	Name         string           `db:"name" json:"name"`
+	Prefix       string           `db:"prefix" json:"prefix"`
+	KeyHash      string           `db:"key_hash" json:"key_hash"`
+	Scopes       []string         `db:"scopes" json:"scopes"`
+	AllowedCidrs []netip.Prefix   `db:"allowed_cidrs" json:"allowed_cidrs"`
+	ExpiresAt    pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	RotatedFrom  pgtype.Int4      `db:"rotated_from" json:"rotated_from"`
 }
 
-func (q *Queries) GetPhoneNumberId(ctx context.Context, arg GetPhoneNumberIdParams) (int32, error) {
-	row := q.db.QueryRow(ctx, getPhoneNumberId, arg.UserID, arg.PhoneNumber)
-	var id int32
-	err := row.Scan(&id)
-	return id, err
+func (q *Queries) AddServiceToken(ctx context.Context, arg AddServiceTokenParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, addServiceToken,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.AllowedCidrs,
+		arg.ExpiresAt,
+		arg.RotatedFrom,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.AllowedCidrs,
+		&i.ThrottleLimit,
+		&i.ThrottledUntil,
+		&i.SuspendedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.Service,
+		&i.RotatedFrom,
+	)
+	return i, err
 }
 
-const getPhoneNumbersByUsername = `-- name: GetPhoneNumbersByUsername :many
-SELECT pn.id, pn.user_id, pn.phone_number
-FROM phone_numbers pn
-    JOIN users u ON pn.user_id = u.id
-WHERE
-    u.username = $1
+const addSms = `-- name: AddSms :one
+INSERT INTO sms (user_id,phone_number_id,to_phone_number,status,message,category,expires_at,priority,cost,external_id,metadata,tags,message_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT DO NOTHING
+RETURNING id
 `
 
-func (q *Queries) GetPhoneNumbersByUsername(ctx context.Context, username string) ([]PhoneNumber, error) {
-	rows, err := q.db.Query(ctx, getPhoneNumbersByUsername, username)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []PhoneNumber
-	for rows.Next() {
-		var i PhoneNumber
-		if err := rows.Scan(&i.ID, &i.UserID, &i.PhoneNumber); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type AddSmsParams struct {
+	UserID        int32            `db:"user_id" json:"user_id"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Status        string           `db:"status" json:"status"`
+	Message       string           `db:"message" json:"message"`
+	Category      string           `db:"category" json:"category"`
+	ExpiresAt     pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Priority      string           `db:"priority" json:"priority"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	ExternalID    pgtype.Text      `db:"external_id" json:"external_id"`
+	Metadata      json.RawMessage  `db:"metadata" json:"metadata"`
+	Tags          []string         `db:"tags" json:"tags"`
+	MessageID     pgtype.UUID      `db:"message_id" json:"message_id"`
 }
 
-const getUserId = `-- name: GetUserId :one
-SELECT id FROM users u WHERE u.username = $1
-`
-
-func (q *Queries) GetUserId(ctx context.Context, username string) (int32, error) {
-	row := q.db.QueryRow(ctx, getUserId, username)
+func (q *Queries) AddSms(ctx context.Context, arg AddSmsParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addSms,
+		arg.UserID,
+		arg.PhoneNumberID,
+		arg.ToPhoneNumber,
+		arg.Status,
+		arg.Message,
+		arg.Category,
+		arg.ExpiresAt,
+		arg.Priority,
+		arg.Cost,
+		arg.ExternalID,
+		arg.Metadata,
+		arg.Tags,
+		arg.MessageID,
+	)
 	var id int32
 	err := row.Scan(&id)
 	return id, err
 }
 
-const subBalance = `-- name: SubBalance :one
-UPDATE users SET balance = balance - $1 WHERE id = $2 RETURNING balance
+const addSmsArchive = `-- name: AddSmsArchive :exec
+INSERT INTO sms_archives (day, row_count, file_count) VALUES ($1, $2, $3)
+ON CONFLICT (day) DO UPDATE SET row_count = EXCLUDED.row_count, file_count = EXCLUDED.file_count, archived_at = CURRENT_TIMESTAMP
 `
 
-type SubBalanceParams struct {
-	Amount pgtype.Numeric `db:"amount" json:"amount"`
-	UserID int32          `db:"user_id" json:"user_id"`
+type AddSmsArchiveParams struct {
+	Day       pgtype.Date `db:"day" json:"day"`
+	RowCount  int64       `db:"row_count" json:"row_count"`
+	FileCount int32       `db:"file_count" json:"file_count"`
 }
 
-func (q *Queries) SubBalance(ctx context.Context, arg SubBalanceParams) (pgtype.Numeric, error) {
+func (q *Queries) AddSmsArchive(ctx context.Context, arg AddSmsArchiveParams) error {
+	_, err := q.db.Exec(ctx, addSmsArchive, arg.Day, arg.RowCount, arg.FileCount)
+	return err
+}
+
+const addSmsEvent = `-- name: AddSmsEvent :exec
+INSERT INTO sms_events (sms_id, event, actor, provider, metadata, occurred_at, request_id) VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type AddSmsEventParams struct {
+	SmsID      int32            `db:"sms_id" json:"sms_id"`
+	Event      string           `db:"event" json:"event"`
+	Actor      string           `db:"actor" json:"actor"`
+	Provider   pgtype.Text      `db:"provider" json:"provider"`
+	Metadata   []byte           `db:"metadata" json:"metadata"`
+	OccurredAt pgtype.Timestamp `db:"occurred_at" json:"occurred_at"`
+	RequestID  pgtype.Text      `db:"request_id" json:"request_id"`
+}
+
+func (q *Queries) AddSmsEvent(ctx context.Context, arg AddSmsEventParams) error {
+	_, err := q.db.Exec(ctx, addSmsEvent,
+		arg.SmsID,
+		arg.Event,
+		arg.Actor,
+		arg.Provider,
+		arg.Metadata,
+		arg.OccurredAt,
+		arg.RequestID,
+	)
+	return err
+}
+
+const addSmsFallback = `-- name: AddSmsFallback :exec
+INSERT INTO sms_fallbacks (sms_id, channel, recipient, subject, after_seconds) VALUES ($1, $2, $3, $4, $5)
+`
+
+type AddSmsFallbackParams struct {
+	SmsID        int32  `db:"sms_id" json:"sms_id"`
+	Channel      string `db:"channel" json:"channel"`
+	Recipient    string `db:"recipient" json:"recipient"`
+	Subject      string `db:"subject" json:"subject"`
+	AfterSeconds int32  `db:"after_seconds" json:"after_seconds"`
+}
+
+func (q *Queries) AddSmsFallback(ctx context.Context, arg AddSmsFallbackParams) error {
+	_, err := q.db.Exec(ctx, addSmsFallback,
+		arg.SmsID,
+		arg.Channel,
+		arg.Recipient,
+		arg.Subject,
+		arg.AfterSeconds,
+	)
+	return err
+}
+
+const addSubaccount = `-- name: AddSubaccount :one
+INSERT INTO users (username, balance, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender)
+SELECT $1, 0, p.id, $2, $3
+FROM users p
+WHERE p.id = $4 AND p.parent_id IS NULL
+RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type AddSubaccountParams struct {
+	Username     string      `db:"username" json:"username"`
+	Pooled       bool        `db:"pooled" json:"pooled"`
+	MonthlyQuota pgtype.Int4 `db:"monthly_quota" json:"monthly_quota"`
+	ParentID     int32       `db:"parent_id" json:"parent_id"`
+}
+
+func (q *Queries) AddSubaccount(ctx context.Context, arg AddSubaccountParams) (User, error) {
+	row := q.db.QueryRow(ctx, addSubaccount,
+		arg.Username,
+		arg.Pooled,
+		arg.MonthlyQuota,
+		arg.ParentID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const addUser = `-- name: AddUser :exec
+INSERT INTO users (username, balance) VALUES ($1, $2)
+`
+
+type AddUserParams struct {
+	Username string         `binding:"required,alphanum" db:"username" json:"username"`
+	Balance  pgtype.Numeric `db:"balance" json:"balance"`
+}
+
+func (q *Queries) AddUser(ctx context.Context, arg AddUserParams) error {
+	_, err := q.db.Exec(ctx, addUser, arg.Username, arg.Balance)
+	return err
+}
+
+const addWebhook = `-- name: AddWebhook :one
+INSERT INTO webhooks (user_id, url, secret) VALUES ($1, $2, $3) RETURNING id, user_id, url, created_at, secret, previous_secret, previous_secret_expires_at
+`
+
+type AddWebhookParams struct {
+	UserID int32  `db:"user_id" json:"user_id"`
+	Url    string `db:"url" json:"url"`
+	Secret string `db:"secret" json:"secret"`
+}
+
+func (q *Queries) AddWebhook(ctx context.Context, arg AddWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, addWebhook, arg.UserID, arg.Url, arg.Secret)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.CreatedAt,
+		&i.Secret,
+		&i.PreviousSecret,
+		&i.PreviousSecretExpiresAt,
+	)
+	return i, err
+}
+
+const addWebhookFailure = `-- name: AddWebhookFailure :execrows
+INSERT INTO webhook_failures (webhook_id, event, payload, attempts, last_error)
+SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = $1)
+`
+
+type AddWebhookFailureParams struct {
+	WebhookID int32  `db:"webhook_id" json:"webhook_id"`
+	Event     string `db:"event" json:"event"`
+	Payload   []byte `db:"payload" json:"payload"`
+	Attempts  int32  `db:"attempts" json:"attempts"`
+	LastError string `db:"last_error" json:"last_error"`
+}
+
+func (q *Queries) AddWebhookFailure(ctx context.Context, arg AddWebhookFailureParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addWebhookFailure,
+		arg.WebhookID,
+		arg.Event,
+		arg.Payload,
+		arg.Attempts,
+		arg.LastError,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const addWorkerError = `-- name: AddWorkerError :exec
+INSERT INTO worker_errors (message_id, subject, sms_id, priority, stage, class, error, attempt, final, worker, occurred_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (message_id, stage, attempt) DO NOTHING
+`
+
+type AddWorkerErrorParams struct {
+	MessageID  string           `db:"message_id" json:"message_id"`
+	Subject    string           `db:"subject" json:"subject"`
+	SmsID      pgtype.Int4      `db:"sms_id" json:"sms_id"`
+	Priority   string           `db:"priority" json:"priority"`
+	Stage      string           `db:"stage" json:"stage"`
+	Class      string           `db:"class" json:"class"`
+	Error      string           `db:"error" json:"error"`
+	Attempt    int32            `db:"attempt" json:"attempt"`
+	Final      bool             `db:"final" json:"final"`
+	Worker     string           `db:"worker" json:"worker"`
+	OccurredAt pgtype.Timestamp `db:"occurred_at" json:"occurred_at"`
+}
+
+func (q *Queries) AddWorkerError(ctx context.Context, arg AddWorkerErrorParams) error {
+	_, err := q.db.Exec(ctx, addWorkerError,
+		arg.MessageID,
+		arg.Subject,
+		arg.SmsID,
+		arg.Priority,
+		arg.Stage,
+		arg.Class,
+		arg.Error,
+		arg.Attempt,
+		arg.Final,
+		arg.Worker,
+		arg.OccurredAt,
+	)
+	return err
+}
+
+const cancelSms = `-- name: CancelSms :one
+UPDATE sms SET status = 'cancelled', cost = 0
+FROM (SELECT id, cost FROM sms WHERE id = $1 FOR UPDATE) old
+WHERE sms.id = old.id AND sms.status = 'pending'
+RETURNING sms.user_id, old.cost
+`
+
+type CancelSmsRow struct {
+	UserID int32          `db:"user_id" json:"user_id"`
+	Cost   pgtype.Numeric `db:"cost" json:"cost"`
+}
+
+func (q *Queries) CancelSms(ctx context.Context, id int32) (CancelSmsRow, error) {
+	row := q.db.QueryRow(ctx, cancelSms, id)
+	var i CancelSmsRow
+	err := row.Scan(&i.UserID, &i.Cost)
+	return i, err
+}
+
+const chargeBalance = `-- name: ChargeBalance :one
+UPDATE users SET balance = balance - $1
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = $2)
+    AND balance >= $1
+RETURNING balance
+`
+
+type ChargeBalanceParams struct {
+	Amount pgtype.Numeric `db:"amount" json:"amount"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) ChargeBalance(ctx context.Context, arg ChargeBalanceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, chargeBalance, arg.Amount, arg.UserID)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const claimAlertRule = `-- name: ClaimAlertRule :one
+UPDATE alert_rules SET fired_at = $1
+WHERE id = $2 AND (fired_at IS NULL OR fired_at < $3)
+RETURNING id
+`
+
+type ClaimAlertRuleParams struct {
+	FiredAt  pgtype.Timestamp `db:"fired_at" json:"fired_at"`
+	ID       int32            `db:"id" json:"id"`
+	Cooldown pgtype.Timestamp `db:"cooldown" json:"cooldown"`
+}
+
+// claims firing a rule that didn't fire since @cooldown, for one worker only
+func (q *Queries) ClaimAlertRule(ctx context.Context, arg ClaimAlertRuleParams) (int32, error) {
+	row := q.db.QueryRow(ctx, claimAlertRule, arg.FiredAt, arg.ID, arg.Cooldown)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const clearImportRows = `-- name: ClearImportRows :exec
+DELETE FROM sms_import_rows WHERE job_id = $1
+`
+
+func (q *Queries) ClearImportRows(ctx context.Context, jobID int32) error {
+	_, err := q.db.Exec(ctx, clearImportRows, jobID)
+	return err
+}
+
+const countSmsForExport = `-- name: CountSmsForExport :one
+SELECT COUNT(*) FROM sms WHERE user_id = $1 AND delivered_at >= $2 AND delivered_at < $3
+`
+
+type CountSmsForExportParams struct {
+	UserID        int32            `db:"user_id" json:"user_id"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	DeliveredAt_2 pgtype.Timestamp `db:"delivered_at_2" json:"delivered_at_2"`
+}
+
+func (q *Queries) CountSmsForExport(ctx context.Context, arg CountSmsForExportParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countSmsForExport, arg.UserID, arg.DeliveredAt, arg.DeliveredAt_2)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countSmsLastHourByStatus = `-- name: CountSmsLastHourByStatus :many
+SELECT status, COUNT(*) AS count
+FROM sms
+WHERE delivered_at > CURRENT_TIMESTAMP - INTERVAL '1 hour'
+GROUP BY status
+ORDER BY status
+`
+
+type CountSmsLastHourByStatusRow struct {
+	Status string `db:"status" json:"status"`
+	Count  int64  `db:"count" json:"count"`
+}
+
+func (q *Queries) CountSmsLastHourByStatus(ctx context.Context) ([]CountSmsLastHourByStatusRow, error) {
+	rows, err := q.db.Query(ctx, countSmsLastHourByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountSmsLastHourByStatusRow
+	for rows.Next() {
+		var i CountSmsLastHourByStatusRow
+		if err := rows.Scan(&i.Status, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countSmsLastMinuteByPriority = `-- name: CountSmsLastMinuteByPriority :many
+SELECT priority, COUNT(*) AS count
+FROM sms
+WHERE delivered_at > CURRENT_TIMESTAMP - INTERVAL '1 minute'
+GROUP BY priority
+ORDER BY priority
+`
+
+type CountSmsLastMinuteByPriorityRow struct {
+	Priority string `db:"priority" json:"priority"`
+	Count    int64  `db:"count" json:"count"`
+}
+
+func (q *Queries) CountSmsLastMinuteByPriority(ctx context.Context) ([]CountSmsLastMinuteByPriorityRow, error) {
+	rows, err := q.db.Query(ctx, countSmsLastMinuteByPriority)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountSmsLastMinuteByPriorityRow
+	for rows.Next() {
+		var i CountSmsLastMinuteByPriorityRow
+		if err := rows.Scan(&i.Priority, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteAlertRule = `-- name: DeleteAlertRule :one
+DELETE FROM alert_rules WHERE id = $1 AND user_id = $2 RETURNING id
+`
+
+type DeleteAlertRuleParams struct {
+	ID     int32 `db:"id" json:"id"`
+	UserID int32 `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) DeleteAlertRule(ctx context.Context, arg DeleteAlertRuleParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteAlertRule, arg.ID, arg.UserID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteBlockedDestination = `-- name: DeleteBlockedDestination :one
+DELETE FROM blocked_destinations WHERE id = $1 AND user_id = $2 RETURNING id
+`
+
+type DeleteBlockedDestinationParams struct {
+	ID     int32       `db:"id" json:"id"`
+	UserID pgtype.Int4 `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) DeleteBlockedDestination(ctx context.Context, arg DeleteBlockedDestinationParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteBlockedDestination, arg.ID, arg.UserID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteEmailSender = `-- name: DeleteEmailSender :one
+DELETE FROM email_senders WHERE id = $1 AND api_key_id = $2 RETURNING id
+`
+
+type DeleteEmailSenderParams struct {
+	ID       int32 `db:"id" json:"id"`
+	ApiKeyID int32 `db:"api_key_id" json:"api_key_id"`
+}
+
+func (q *Queries) DeleteEmailSender(ctx context.Context, arg DeleteEmailSenderParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteEmailSender, arg.ID, arg.ApiKeyID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteGlobalBlockedDestination = `-- name: DeleteGlobalBlockedDestination :one
+DELETE FROM blocked_destinations WHERE id = $1 AND user_id IS NULL RETURNING id
+`
+
+func (q *Queries) DeleteGlobalBlockedDestination(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteGlobalBlockedDestination, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteNatsForward = `-- name: DeleteNatsForward :execrows
+DELETE FROM nats_forwards WHERE user_id = $1
+`
+
+func (q *Queries) DeleteNatsForward(ctx context.Context, userID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteNatsForward, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePhoneNumber = `-- name: DeletePhoneNumber :one
+DELETE FROM phone_numbers WHERE id = $1 RETURNING id
+`
+
+func (q *Queries) DeletePhoneNumber(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, deletePhoneNumber, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deletePhoneNumberPool = `-- name: DeletePhoneNumberPool :one
+DELETE FROM phone_number_pools WHERE id = $1 RETURNING id
+`
+
+func (q *Queries) DeletePhoneNumberPool(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, deletePhoneNumberPool, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deletePoolNumber = `-- name: DeletePoolNumber :one
+DELETE FROM phone_number_pool_members WHERE pool_id = $1 AND phone_number_id = $2 RETURNING phone_number_id
+`
+
+type DeletePoolNumberParams struct {
+	PoolID        int32 `db:"pool_id" json:"pool_id"`
+	PhoneNumberID int32 `db:"phone_number_id" json:"phone_number_id"`
+}
+
+func (q *Queries) DeletePoolNumber(ctx context.Context, arg DeletePoolNumberParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deletePoolNumber, arg.PoolID, arg.PhoneNumberID)
+	var phone_number_id int32
+	err := row.Scan(&phone_number_id)
+	return phone_number_id, err
+}
+
+const deleteProviderAccount = `-- name: DeleteProviderAccount :execrows
+DELETE FROM provider_accounts WHERE id = $1
+`
+
+func (q *Queries) DeleteProviderAccount(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProviderAccount, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteQuietHours = `-- name: DeleteQuietHours :one
+DELETE FROM quiet_hours WHERE id = $1 AND user_id = $2 RETURNING id
+`
+
+type DeleteQuietHoursParams struct {
+	ID     int32 `db:"id" json:"id"`
+	UserID int32 `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) DeleteQuietHours(ctx context.Context, arg DeleteQuietHoursParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteQuietHours, arg.ID, arg.UserID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteQuotaPolicy = `-- name: DeleteQuotaPolicy :execrows
+DELETE FROM quota_policies WHERE user_id = $1 AND priority = $2
+`
+
+type DeleteQuotaPolicyParams struct {
+	UserID   int32  `db:"user_id" json:"user_id"`
+	Priority string `db:"priority" json:"priority"`
+}
+
+func (q *Queries) DeleteQuotaPolicy(ctx context.Context, arg DeleteQuotaPolicyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteQuotaPolicy, arg.UserID, arg.Priority)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteReseller = `-- name: DeleteReseller :execrows
+DELETE FROM resellers WHERE user_id = $1
+`
+
+func (q *Queries) DeleteReseller(ctx context.Context, userID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteReseller, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteWebhook = `-- name: DeleteWebhook :one
+DELETE FROM webhooks WHERE id = $1 AND user_id = $2 RETURNING id
+`
+
+type DeleteWebhookParams struct {
+	ID     int32 `db:"id" json:"id"`
+	UserID int32 `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) DeleteWebhook(ctx context.Context, arg DeleteWebhookParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteWebhook, arg.ID, arg.UserID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteWebhookFailure = `-- name: DeleteWebhookFailure :exec
+DELETE FROM webhook_failures WHERE id = $1
+`
+
+func (q *Queries) DeleteWebhookFailure(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteWebhookFailure, id)
+	return err
+}
+
+const deleteWorkersSeenBefore = `-- name: DeleteWorkersSeenBefore :execrows
+DELETE FROM workers WHERE last_seen_at < $1
+`
+
+func (q *Queries) DeleteWorkersSeenBefore(ctx context.Context, seenBefore pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWorkersSeenBefore, seenBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const depositBalance = `-- name: DepositBalance :one
+UPDATE users SET balance = balance + $1 WHERE id = $2 AND NOT pooled RETURNING balance
+`
+
+type DepositBalanceParams struct {
+	Amount pgtype.Numeric `db:"amount" json:"amount"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) DepositBalance(ctx context.Context, arg DepositBalanceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, depositBalance, arg.Amount, arg.UserID)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const expireApiKey = `-- name: ExpireApiKey :one
+UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $1), $1)
+WHERE id = $2 AND revoked_at IS NULL
+RETURNING expires_at
+`
+
+type ExpireApiKeyParams struct {
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	ID        int32            `db:"id" json:"id"`
+}
+
+// brings the expiry of a key forward to expires_at, never back
+func (q *Queries) ExpireApiKey(ctx context.Context, arg ExpireApiKeyParams) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, expireApiKey, arg.ExpiresAt, arg.ID)
+	var expires_at pgtype.Timestamp
+	err := row.Scan(&expires_at)
+	return expires_at, err
+}
+
+const expirePendingSms = `-- name: ExpirePendingSms :one
+UPDATE sms SET status = 'expired', cost = 0
+FROM (SELECT id, cost FROM sms WHERE id = $1 FOR UPDATE) old
+WHERE sms.id = old.id AND sms.status = 'pending'
+RETURNING sms.user_id, old.cost
+`
+
+type ExpirePendingSmsRow struct {
+	UserID int32          `db:"user_id" json:"user_id"`
+	Cost   pgtype.Numeric `db:"cost" json:"cost"`
+}
+
+// expires an sms that was never submitted, its cost is refunded like that of a
+// cancelled one
+func (q *Queries) ExpirePendingSms(ctx context.Context, id int32) (ExpirePendingSmsRow, error) {
+	row := q.db.QueryRow(ctx, expirePendingSms, id)
+	var i ExpirePendingSmsRow
+	err := row.Scan(&i.UserID, &i.Cost)
+	return i, err
+}
+
+const failJob = `-- name: FailJob :exec
+UPDATE jobs SET status = 'failed', error = $1, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP WHERE id = $2
+`
+
+type FailJobParams struct {
+	Error pgtype.Text `db:"error" json:"error"`
+	ID    int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) FailJob(ctx context.Context, arg FailJobParams) error {
+	_, err := q.db.Exec(ctx, failJob, arg.Error, arg.ID)
+	return err
+}
+
+const finishJob = `-- name: FinishJob :exec
+UPDATE jobs SET status = 'done', progress = 100, row_count = $1, result = $2, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP WHERE id = $3
+`
+
+type FinishJobParams struct {
+	RowCount int64       `db:"row_count" json:"row_count"`
+	Result   pgtype.Text `db:"result" json:"result"`
+	ID       int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) FinishJob(ctx context.Context, arg FinishJobParams) error {
+	_, err := q.db.Exec(ctx, finishJob, arg.RowCount, arg.Result, arg.ID)
+	return err
+}
+
+const fireSmsFallback = `-- name: FireSmsFallback :one
+UPDATE sms_fallbacks f SET fired_at = CURRENT_TIMESTAMP
+FROM sms s
+WHERE f.sms_id = $1 AND s.id = f.sms_id AND f.fired_at IS NULL
+RETURNING f.sms_id, f.channel, f.recipient, f.subject, s.user_id, s.message
+`
+
+type FireSmsFallbackRow struct {
+	SmsID     int32  `db:"sms_id" json:"sms_id"`
+	Channel   string `db:"channel" json:"channel"`
+	Recipient string `db:"recipient" json:"recipient"`
+	Subject   string `db:"subject" json:"subject"`
+	UserID    int32  `db:"user_id" json:"user_id"`
+	Message   string `db:"message" json:"message"`
+}
+
+func (q *Queries) FireSmsFallback(ctx context.Context, smsID int32) (FireSmsFallbackRow, error) {
+	row := q.db.QueryRow(ctx, fireSmsFallback, smsID)
+	var i FireSmsFallbackRow
+	err := row.Scan(
+		&i.SmsID,
+		&i.Channel,
+		&i.Recipient,
+		&i.Subject,
+		&i.UserID,
+		&i.Message,
+	)
+	return i, err
+}
+
+const getAlertRules = `-- name: GetAlertRules :many
+SELECT id, user_id, kind, threshold, email, fired_at, created_at FROM alert_rules WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetAlertRules(ctx context.Context, userID int32) ([]AlertRule, error) {
+	rows, err := q.db.Query(ctx, getAlertRules, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AlertRule
+	for rows.Next() {
+		var i AlertRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.Threshold,
+			&i.Email,
+			&i.FiredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAlertUsage = `-- name: GetAlertUsage :many
+SELECT s.user_id,
+    COALESCE(SUM(s.cost) FILTER (WHERE s.delivered_at >= $1), 0)::float8 AS spent,
+    COUNT(*) FILTER (WHERE s.delivered_at >= $2) AS sent,
+    COUNT(*) FILTER (WHERE s.delivered_at >= $2 AND s.status IN ('failed', 'expired')) AS failed,
+    COUNT(*) FILTER (WHERE s.delivered_at >= $3 AND s.delivered_at < $2) AS baseline
+FROM sms s
+WHERE s.user_id IN (SELECT user_id FROM alert_rules)
+    AND s.delivered_at >= $4 AND s.delivered_at < $5
+GROUP BY s.user_id
+`
+
+type GetAlertUsageParams struct {
+	Day      pgtype.Timestamp `db:"day" json:"day"`
+	Since    pgtype.Timestamp `db:"since" json:"since"`
+	Baseline pgtype.Timestamp `db:"baseline" json:"baseline"`
+	Earliest pgtype.Timestamp `db:"earliest" json:"earliest"`
+	Until    pgtype.Timestamp `db:"until" json:"until"`
+}
+
+type GetAlertUsageRow struct {
+	UserID   int32   `db:"user_id" json:"user_id"`
+	Spent    float64 `db:"spent" json:"spent"`
+	Sent     int64   `db:"sent" json:"sent"`
+	Failed   int64   `db:"failed" json:"failed"`
+	Baseline int64   `db:"baseline" json:"baseline"`
+}
+
+// what the users with alert rules sent: their spend since @day, their messages
+// and failures since @since and their messages from @baseline to @since
+func (q *Queries) GetAlertUsage(ctx context.Context, arg GetAlertUsageParams) ([]GetAlertUsageRow, error) {
+	rows, err := q.db.Query(ctx, getAlertUsage,
+		arg.Day,
+		arg.Since,
+		arg.Baseline,
+		arg.Earliest,
+		arg.Until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAlertUsageRow
+	for rows.Next() {
+		var i GetAlertUsageRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Spent,
+			&i.Sent,
+			&i.Failed,
+			&i.Baseline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getApiKeyByHash = `-- name: GetApiKeyByHash :one
+SELECT api_keys.id, api_keys.user_id, users.username, api_keys.scopes, api_keys.allowed_cidrs,
+    (CASE WHEN api_keys.throttled_until > CURRENT_TIMESTAMP THEN api_keys.throttle_limit END)::INT AS throttle_limit,
+    api_keys.suspended_at IS NOT NULL AS suspended,
+    ARRAY(SELECT s.id FROM users s WHERE s.parent_id = users.id ORDER BY s.id)::INT[] AS subaccount_ids,
+    ARRAY(SELECT s.username FROM users s WHERE s.parent_id = users.id ORDER BY s.id)::TEXT[] AS subaccount_usernames
+FROM api_keys
+JOIN users ON users.id = api_keys.user_id
+WHERE api_keys.key_hash = $1 AND api_keys.revoked_at IS NULL
+    AND (api_keys.expires_at IS NULL OR api_keys.expires_at > CURRENT_TIMESTAMP)
+`
+
+type GetApiKeyByHashRow struct {
+	ID                  int32          `db:"id" json:"id"`
+	UserID              int32          `db:"user_id" json:"user_id"`
+	Username            string         `db:"username" json:"username"`
+	Scopes              []string       `db:"scopes" json:"scopes"`
+	AllowedCidrs        []netip.Prefix `db:"allowed_cidrs" json:"allowed_cidrs"`
+	ThrottleLimit       pgtype.Int4    `db:"throttle_limit" json:"throttle_limit"`
+	Suspended           bool           `db:"suspended" json:"suspended"`
+	SubaccountIds       []int32        `db:"subaccount_ids" json:"subaccount_ids"`
+	SubaccountUsernames []string       `db:"subaccount_usernames" json:"subaccount_usernames"`
+}
+
+func (q *Queries) GetApiKeyByHash(ctx context.Context, keyHash string) (GetApiKeyByHashRow, error) {
+	row := q.db.QueryRow(ctx, getApiKeyByHash, keyHash)
+	var i GetApiKeyByHashRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Username,
+		&i.Scopes,
+		&i.AllowedCidrs,
+		&i.ThrottleLimit,
+		&i.Suspended,
+		&i.SubaccountIds,
+		&i.SubaccountUsernames,
+	)
+	return i, err
+}
+
+const getApiKeysByUser = `-- name: GetApiKeysByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from FROM api_keys WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetApiKeysByUser(ctx context.Context, userID int32) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, getApiKeysByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.RevokedAt,
+			&i.AllowedCidrs,
+			&i.ThrottleLimit,
+			&i.ThrottledUntil,
+			&i.SuspendedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.Service,
+			&i.RotatedFrom,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getArchiveStart = `-- name: GetArchiveStart :one
+SELECT CAST(COALESCE(
+    (SELECT MAX(day) + 1 FROM sms_archives),
+    (SELECT MIN(occurred_at)::date FROM sms_events WHERE event = 'created')
+) AS DATE) AS day
+`
+
+func (q *Queries) GetArchiveStart(ctx context.Context) (pgtype.Date, error) {
+	row := q.db.QueryRow(ctx, getArchiveStart)
+	var day pgtype.Date
+	err := row.Scan(&day)
+	return day, err
+}
+
+const getBalance = `-- name: GetBalance :one
+SELECT b.balance FROM users u
+JOIN users b ON b.id = CASE WHEN u.pooled THEN u.parent_id ELSE u.id END
+WHERE u.id = $1
+`
+
+func (q *Queries) GetBalance(ctx context.Context, userID int32) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, getBalance, userID)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const getBalanceByUsername = `-- name: GetBalanceByUsername :one
+SELECT b.balance FROM users u
+JOIN users b ON b.id = CASE WHEN u.pooled THEN u.parent_id ELSE u.id END
+WHERE u.username = $1
+`
+
+func (q *Queries) GetBalanceByUsername(ctx context.Context, username string) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, getBalanceByUsername, username)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const getBlockedDestinations = `-- name: GetBlockedDestinations :many
+SELECT id, user_id, kind, value, reason, created_at FROM blocked_destinations WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetBlockedDestinations(ctx context.Context, userID pgtype.Int4) ([]BlockedDestination, error) {
+	rows, err := q.db.Query(ctx, getBlockedDestinations, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BlockedDestination
+	for rows.Next() {
+		var i BlockedDestination
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.Value,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBlockingRules = `-- name: GetBlockingRules :many
+SELECT id, user_id, kind, value, reason, created_at FROM blocked_destinations WHERE user_id = $1 OR user_id IS NULL ORDER BY user_id NULLS FIRST, id
+`
+
+func (q *Queries) GetBlockingRules(ctx context.Context, userID pgtype.Int4) ([]BlockedDestination, error) {
+	rows, err := q.db.Query(ctx, getBlockingRules, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BlockedDestination
+	for rows.Next() {
+		var i BlockedDestination
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.Value,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCustomer = `-- name: GetCustomer :one
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE reseller_id = $1 AND username = $2
+`
+
+type GetCustomerParams struct {
+	ResellerID pgtype.Int4 `db:"reseller_id" json:"reseller_id"`
+	Username   string      `db:"username" json:"username"`
+}
+
+func (q *Queries) GetCustomer(ctx context.Context, arg GetCustomerParams) (User, error) {
+	row := q.db.QueryRow(ctx, getCustomer, arg.ResellerID, arg.Username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const getCustomers = `-- name: GetCustomers :many
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE reseller_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetCustomers(ctx context.Context, resellerID pgtype.Int4) ([]User, error) {
+	rows, err := q.db.Query(ctx, getCustomers, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Footer,
+			&i.Status,
+			&i.RecipientHourlyLimit,
+			&i.ParentID,
+			&i.Pooled,
+			&i.MonthlyQuota,
+			&i.ResellerID,
+			&i.SmsPrice,
+			&i.Sender,
+			&i.ExpressAdmission,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeliveryLatency = `-- name: GetDeliveryLatency :one
+SELECT COUNT(*) AS delivered,
+    COUNT(*) FILTER (WHERE l.seconds > $1::float8) AS slow,
+    CAST(COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p50,
+    CAST(COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p95,
+    CAST(COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY l.seconds), 0) AS FLOAT8) AS p99
+FROM (
+    SELECT EXTRACT(EPOCH FROM d.occurred_at - c.occurred_at) AS seconds
+    FROM sms_events d
+    JOIN sms_events c ON c.sms_id = d.sms_id AND c.event = 'created'
+    JOIN sms s ON s.id = d.sms_id
+    WHERE d.event = 'delivered' AND d.occurred_at >= $2 AND s.priority = $3
+) l
+`
+
+type GetDeliveryLatencyParams struct {
+	Threshold float64          `db:"threshold" json:"threshold"`
+	Since     pgtype.Timestamp `db:"since" json:"since"`
+	Priority  string           `db:"priority" json:"priority"`
+}
+
+type GetDeliveryLatencyRow struct {
+	Delivered int64   `db:"delivered" json:"delivered"`
+	Slow      int64   `db:"slow" json:"slow"`
+	P50       float64 `db:"p50" json:"p50"`
+	P95       float64 `db:"p95" json:"p95"`
+	P99       float64 `db:"p99" json:"p99"`
+}
+
+func (q *Queries) GetDeliveryLatency(ctx context.Context, arg GetDeliveryLatencyParams) (GetDeliveryLatencyRow, error) {
+	row := q.db.QueryRow(ctx, getDeliveryLatency, arg.Threshold, arg.Since, arg.Priority)
+	var i GetDeliveryLatencyRow
+	err := row.Scan(
+		&i.Delivered,
+		&i.Slow,
+		&i.P50,
+		&i.P95,
+		&i.P99,
+	)
+	return i, err
+}
+
+const getDisabledServiceTokens = `-- name: GetDisabledServiceTokens :many
+SELECT id FROM api_keys
+WHERE service AND created_at > $1
+    AND (revoked_at IS NOT NULL OR suspended_at IS NOT NULL OR expires_at <= CURRENT_TIMESTAMP)
+`
+
+// the tokens issued after issued_after that were revoked, suspended, or expired
+// early by a rotation, while their signature may still be valid
+func (q *Queries) GetDisabledServiceTokens(ctx context.Context, issuedAfter pgtype.Timestamp) ([]int32, error) {
+	rows, err := q.db.Query(ctx, getDisabledServiceTokens, issuedAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEmailSender = `-- name: GetEmailSender :one
+SELECT email_senders.id, email_senders.phone_number_id, api_keys.user_id, api_keys.key_hash
+FROM email_senders
+JOIN api_keys ON api_keys.id = email_senders.api_key_id
+WHERE email_senders.address = $1 AND api_keys.revoked_at IS NULL
+`
+
+type GetEmailSenderRow struct {
+	ID            int32  `db:"id" json:"id"`
+	PhoneNumberID int32  `db:"phone_number_id" json:"phone_number_id"`
+	UserID        int32  `db:"user_id" json:"user_id"`
+	KeyHash       string `db:"key_hash" json:"key_hash"`
+}
+
+func (q *Queries) GetEmailSender(ctx context.Context, address string) (GetEmailSenderRow, error) {
+	row := q.db.QueryRow(ctx, getEmailSender, address)
+	var i GetEmailSenderRow
+	err := row.Scan(
+		&i.ID,
+		&i.PhoneNumberID,
+		&i.UserID,
+		&i.KeyHash,
+	)
+	return i, err
+}
+
+const getEmailSendersByApiKey = `-- name: GetEmailSendersByApiKey :many
+SELECT id, api_key_id, address, phone_number_id, created_at FROM email_senders WHERE api_key_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetEmailSendersByApiKey(ctx context.Context, apiKeyID int32) ([]EmailSender, error) {
+	rows, err := q.db.Query(ctx, getEmailSendersByApiKey, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EmailSender
+	for rows.Next() {
+		var i EmailSender
+		if err := rows.Scan(
+			&i.ID,
+			&i.ApiKeyID,
+			&i.Address,
+			&i.PhoneNumberID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExpressAdmission = `-- name: GetExpressAdmission :one
+SELECT express_admission FROM users WHERE id = $1
+`
+
+func (q *Queries) GetExpressAdmission(ctx context.Context, id int32) (pgtype.Text, error) {
+	row := q.db.QueryRow(ctx, getExpressAdmission, id)
+	var express_admission pgtype.Text
+	err := row.Scan(&express_admission)
+	return express_admission, err
+}
+
+const getFailureReasons = `-- name: GetFailureReasons :many
+SELECT COALESCE(failure_reason, 'unknown')::text AS reason, COUNT(*) AS messages
+FROM sms
+WHERE user_id = $1 AND status IN ('failed', 'expired')
+    AND delivered_at >= $2 AND delivered_at < $3
+GROUP BY 1
+ORDER BY messages DESC, reason
+`
+
+type GetFailureReasonsParams struct {
+	UserID int32            `db:"user_id" json:"user_id"`
+	Since  pgtype.Timestamp `db:"since" json:"since"`
+	Until  pgtype.Timestamp `db:"until" json:"until"`
+}
+
+type GetFailureReasonsRow struct {
+	Reason   string `db:"reason" json:"reason"`
+	Messages int64  `db:"messages" json:"messages"`
+}
+
+func (q *Queries) GetFailureReasons(ctx context.Context, arg GetFailureReasonsParams) ([]GetFailureReasonsRow, error) {
+	rows, err := q.db.Query(ctx, getFailureReasons, arg.UserID, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFailureReasonsRow
+	for rows.Next() {
+		var i GetFailureReasonsRow
+		if err := rows.Scan(&i.Reason, &i.Messages); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFooter = `-- name: GetFooter :one
+SELECT COALESCE(NULLIF(s.footer, ''), r.footer)::VARCHAR AS footer
+FROM users s
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE s.id = $1
+`
+
+// the footer of a user, or the one their reseller brands its customers with
+func (q *Queries) GetFooter(ctx context.Context, id int32) (pgtype.Text, error) {
+	row := q.db.QueryRow(ctx, getFooter, id)
+	var footer pgtype.Text
+	err := row.Scan(&footer)
+	return footer, err
+}
+
+const getGlobalBlockedDestinations = `-- name: GetGlobalBlockedDestinations :many
+SELECT id, user_id, kind, value, reason, created_at FROM blocked_destinations WHERE user_id IS NULL ORDER BY id
+`
+
+func (q *Queries) GetGlobalBlockedDestinations(ctx context.Context) ([]BlockedDestination, error) {
+	rows, err := q.db.Query(ctx, getGlobalBlockedDestinations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BlockedDestination
+	for rows.Next() {
+		var i BlockedDestination
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.Value,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getInvoice = `-- name: GetInvoice :one
+SELECT id, user_id, period_start, period_end, message_count, total, created_at FROM invoices WHERE id = $1
+`
+
+func (q *Queries) GetInvoice(ctx context.Context, id int32) (Invoice, error) {
+	row := q.db.QueryRow(ctx, getInvoice, id)
+	var i Invoice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.PeriodStart,
+		&i.PeriodEnd,
+		&i.MessageCount,
+		&i.Total,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getInvoiceItems = `-- name: GetInvoiceItems :many
+SELECT id, invoice_id, priority, country, quantity, amount FROM invoice_items WHERE invoice_id = $1 ORDER BY priority, country
+`
+
+func (q *Queries) GetInvoiceItems(ctx context.Context, invoiceID int32) ([]InvoiceItem, error) {
+	rows, err := q.db.Query(ctx, getInvoiceItems, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InvoiceItem
+	for rows.Next() {
+		var i InvoiceItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.InvoiceID,
+			&i.Priority,
+			&i.Country,
+			&i.Quantity,
+			&i.Amount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getInvoicesByUser = `-- name: GetInvoicesByUser :many
+SELECT id, user_id, period_start, period_end, message_count, total, created_at FROM invoices WHERE user_id = $1 ORDER BY period_start DESC
+`
+
+func (q *Queries) GetInvoicesByUser(ctx context.Context, userID int32) ([]Invoice, error) {
+	rows, err := q.db.Query(ctx, getInvoicesByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Invoice
+	for rows.Next() {
+		var i Invoice
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PeriodStart,
+			&i.PeriodEnd,
+			&i.MessageCount,
+			&i.Total,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getJob = `-- name: GetJob :one
+SELECT id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at FROM jobs WHERE id = $1
+`
+
+func (q *Queries) GetJob(ctx context.Context, id int32) (Job, error) {
+	row := q.db.QueryRow(ctx, getJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.Status,
+		&i.Params,
+		&i.Progress,
+		&i.RowCount,
+		&i.Result,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const getKeyActivity = `-- name: GetKeyActivity :many
+SELECT CAST(SUBSTRING(e.actor FROM 5) AS INT) AS api_key_id, s.user_id, s.to_phone_number,
+    COUNT(*) AS sent,
+    COUNT(*) FILTER (WHERE s.status = 'failed') AS failed
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'created' AND e.actor LIKE 'key:%' AND e.occurred_at >= $1 AND e.occurred_at < $2
+GROUP BY e.actor, s.user_id, s.to_phone_number
+`
+
+type GetKeyActivityParams struct {
+	Since pgtype.Timestamp `db:"since" json:"since"`
+	Until pgtype.Timestamp `db:"until" json:"until"`
+}
+
+type GetKeyActivityRow struct {
+	ApiKeyID      int32  `db:"api_key_id" json:"api_key_id"`
+	UserID        int32  `db:"user_id" json:"user_id"`
+	ToPhoneNumber string `db:"to_phone_number" json:"to_phone_number"`
+	Sent          int64  `db:"sent" json:"sent"`
+	Failed        int64  `db:"failed" json:"failed"`
+}
+
+func (q *Queries) GetKeyActivity(ctx context.Context, arg GetKeyActivityParams) ([]GetKeyActivityRow, error) {
+	rows, err := q.db.Query(ctx, getKeyActivity, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetKeyActivityRow
+	for rows.Next() {
+		var i GetKeyActivityRow
+		if err := rows.Scan(
+			&i.ApiKeyID,
+			&i.UserID,
+			&i.ToPhoneNumber,
+			&i.Sent,
+			&i.Failed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getKeySendCounts = `-- name: GetKeySendCounts :many
+SELECT CAST(SUBSTRING(actor FROM 5) AS INT) AS api_key_id, COUNT(*) AS sent
+FROM sms_events
+WHERE event = 'created' AND actor LIKE 'key:%' AND occurred_at >= $1 AND occurred_at < $2
+GROUP BY actor
+`
+
+type GetKeySendCountsParams struct {
+	Since pgtype.Timestamp `db:"since" json:"since"`
+	Until pgtype.Timestamp `db:"until" json:"until"`
+}
+
+type GetKeySendCountsRow struct {
+	ApiKeyID int32 `db:"api_key_id" json:"api_key_id"`
+	Sent     int64 `db:"sent" json:"sent"`
+}
+
+func (q *Queries) GetKeySendCounts(ctx context.Context, arg GetKeySendCountsParams) ([]GetKeySendCountsRow, error) {
+	rows, err := q.db.Query(ctx, getKeySendCounts, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetKeySendCountsRow
+	for rows.Next() {
+		var i GetKeySendCountsRow
+		if err := rows.Scan(&i.ApiKeyID, &i.Sent); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLastSmsMessages = `-- name: GetLastSmsMessages :many
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms 
+WHERE user_id = $1 
+ORDER BY delivered_at DESC 
+LIMIT $2
+`
+
+type GetLastSmsMessagesParams struct {
+	UserID int32 `db:"user_id" json:"user_id"`
+	Limit  int32 `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetLastSmsMessages(ctx context.Context, arg GetLastSmsMessagesParams) ([]Sm, error) {
+	rows, err := q.db.Query(ctx, getLastSmsMessages, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Sm
+	for rows.Next() {
+		var i Sm
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PhoneNumberID,
+			&i.ToPhoneNumber,
+			&i.Message,
+			&i.Status,
+			&i.DeliveredAt,
+			&i.Category,
+			&i.ExpiresAt,
+			&i.Priority,
+			&i.Cost,
+			&i.ExternalID,
+			&i.Metadata,
+			&i.Tags,
+			&i.MessageID,
+			&i.FailureReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLastSmsMessagesByTag = `-- name: GetLastSmsMessagesByTag :many
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = $1 AND tags @> ARRAY[$2::text]
+ORDER BY delivered_at DESC
+LIMIT $3
+`
+
+type GetLastSmsMessagesByTagParams struct {
+	UserID int32  `db:"user_id" json:"user_id"`
+	Tag    string `db:"tag" json:"tag"`
+	Limit  int32  `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetLastSmsMessagesByTag(ctx context.Context, arg GetLastSmsMessagesByTagParams) ([]Sm, error) {
+	rows, err := q.db.Query(ctx, getLastSmsMessagesByTag, arg.UserID, arg.Tag, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Sm
+	for rows.Next() {
+		var i Sm
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PhoneNumberID,
+			&i.ToPhoneNumber,
+			&i.Message,
+			&i.Status,
+			&i.DeliveredAt,
+			&i.Category,
+			&i.ExpiresAt,
+			&i.Priority,
+			&i.Cost,
+			&i.ExternalID,
+			&i.Metadata,
+			&i.Tags,
+			&i.MessageID,
+			&i.FailureReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMqttDevice = `-- name: GetMqttDevice :one
+SELECT mqtt_devices.id, mqtt_devices.api_key_id, api_keys.user_id, mqtt_devices.secret_hash, api_keys.key_hash,
+    api_keys.suspended_at IS NOT NULL AS suspended
+FROM mqtt_devices
+JOIN api_keys ON api_keys.id = mqtt_devices.api_key_id
+WHERE mqtt_devices.client_id = $1 AND mqtt_devices.revoked_at IS NULL AND api_keys.revoked_at IS NULL
+`
+
+type GetMqttDeviceRow struct {
+	ID         int32  `db:"id" json:"id"`
+	ApiKeyID   int32  `db:"api_key_id" json:"api_key_id"`
+	UserID     int32  `db:"user_id" json:"user_id"`
+	SecretHash string `db:"secret_hash" json:"secret_hash"`
+	KeyHash    string `db:"key_hash" json:"key_hash"`
+	Suspended  bool   `db:"suspended" json:"suspended"`
+}
+
+func (q *Queries) GetMqttDevice(ctx context.Context, clientID string) (GetMqttDeviceRow, error) {
+	row := q.db.QueryRow(ctx, getMqttDevice, clientID)
+	var i GetMqttDeviceRow
+	err := row.Scan(
+		&i.ID,
+		&i.ApiKeyID,
+		&i.UserID,
+		&i.SecretHash,
+		&i.KeyHash,
+		&i.Suspended,
+	)
+	return i, err
+}
+
+const getMqttDevicesByApiKey = `-- name: GetMqttDevicesByApiKey :many
+SELECT id, api_key_id, client_id, secret_hash, daily_quota, quota_day, quota_used, created_at, revoked_at FROM mqtt_devices WHERE api_key_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetMqttDevicesByApiKey(ctx context.Context, apiKeyID int32) ([]MqttDevice, error) {
+	rows, err := q.db.Query(ctx, getMqttDevicesByApiKey, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MqttDevice
+	for rows.Next() {
+		var i MqttDevice
+		if err := rows.Scan(
+			&i.ID,
+			&i.ApiKeyID,
+			&i.ClientID,
+			&i.SecretHash,
+			&i.DailyQuota,
+			&i.QuotaDay,
+			&i.QuotaUsed,
+			&i.CreatedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNatsForward = `-- name: GetNatsForward :one
+SELECT user_id, url, subject, jetstream, credentials, data_key, created_at, updated_at FROM nats_forwards WHERE user_id = $1
+`
+
+func (q *Queries) GetNatsForward(ctx context.Context, userID int32) (NatsForward, error) {
+	row := q.db.QueryRow(ctx, getNatsForward, userID)
+	var i NatsForward
+	err := row.Scan(
+		&i.UserID,
+		&i.Url,
+		&i.Subject,
+		&i.Jetstream,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getNatsForwardUserForSms = `-- name: GetNatsForwardUserForSms :one
+SELECT f.user_id FROM nats_forwards f
+    JOIN sms s ON s.user_id = f.user_id
+WHERE s.id = $1
+`
+
+// returns no rows when the owner of the sms forwards nothing
+func (q *Queries) GetNatsForwardUserForSms(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, getNatsForwardUserForSms, id)
+	var user_id int32
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const getNotificationStatusForUpdate = `-- name: GetNotificationStatusForUpdate :one
+SELECT status FROM notifications WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetNotificationStatusForUpdate(ctx context.Context, id int32) (string, error) {
+	row := q.db.QueryRow(ctx, getNotificationStatusForUpdate, id)
+	var status string
+	err := row.Scan(&status)
+	return status, err
+}
+
+const getOverdueSubmittedSms = `-- name: GetOverdueSubmittedSms :many
+SELECT id, priority FROM sms
+WHERE status = 'submitted' AND expires_at < $1
+ORDER BY expires_at
+LIMIT $2
+`
+
+type GetOverdueSubmittedSmsParams struct {
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Limit     int32            `db:"limit" json:"limit"`
+}
+
+type GetOverdueSubmittedSmsRow struct {
+	ID       int32  `db:"id" json:"id"`
+	Priority string `db:"priority" json:"priority"`
+}
+
+// submitted sms whose validity period ended before $1 without a final report,
+// oldest first
+func (q *Queries) GetOverdueSubmittedSms(ctx context.Context, arg GetOverdueSubmittedSmsParams) ([]GetOverdueSubmittedSmsRow, error) {
+	rows, err := q.db.Query(ctx, getOverdueSubmittedSms, arg.ExpiresAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetOverdueSubmittedSmsRow
+	for rows.Next() {
+		var i GetOverdueSubmittedSmsRow
+		if err := rows.Scan(&i.ID, &i.Priority); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPhoneNumber = `-- name: GetPhoneNumber :one
+SELECT id, user_id, phone_number FROM phone_numbers WHERE id = $1
+`
+
+func (q *Queries) GetPhoneNumber(ctx context.Context, id int32) (PhoneNumber, error) {
+	row := q.db.QueryRow(ctx, getPhoneNumber, id)
+	var i PhoneNumber
+	err := row.Scan(&i.ID, &i.UserID, &i.PhoneNumber)
+	return i, err
+}
+
+const getPhoneNumberId = `-- name: GetPhoneNumberId :one
+SELECT id FROM phone_numbers WHERE user_id = $1 AND phone_number = $2
+`
+
+type GetPhoneNumberIdParams struct {
+	UserID      int32  `db:"user_id" json:"user_id"`
+	PhoneNumber string `db:"phone_number" json:"phone_number"`
+}
+
+func (q *Queries) GetPhoneNumberId(ctx context.Context, arg GetPhoneNumberIdParams) (int32, error) {
+	row := q.db.QueryRow(ctx, getPhoneNumberId, arg.UserID, arg.PhoneNumber)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getPhoneNumberPool = `-- name: GetPhoneNumberPool :one
+SELECT id, user_id, name, strategy, turns, created_at FROM phone_number_pools WHERE id = $1
+`
+
+func (q *Queries) GetPhoneNumberPool(ctx context.Context, id int32) (PhoneNumberPool, error) {
+	row := q.db.QueryRow(ctx, getPhoneNumberPool, id)
+	var i PhoneNumberPool
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Strategy,
+		&i.Turns,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPhoneNumberPoolsByUsername = `-- name: GetPhoneNumberPoolsByUsername :many
+SELECT p.id, p.user_id, p.name, p.strategy, p.turns, p.created_at FROM phone_number_pools p
+    JOIN users u ON p.user_id = u.id
+WHERE u.username = $1 ORDER BY p.id
+`
+
+func (q *Queries) GetPhoneNumberPoolsByUsername(ctx context.Context, username string) ([]PhoneNumberPool, error) {
+	rows, err := q.db.Query(ctx, getPhoneNumberPoolsByUsername, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PhoneNumberPool
+	for rows.Next() {
+		var i PhoneNumberPool
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Strategy,
+			&i.Turns,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPhoneNumbersByUserId = `-- name: GetPhoneNumbersByUserId :many
+SELECT id, user_id, phone_number FROM phone_numbers WHERE user_id = $1
+`
+
+func (q *Queries) GetPhoneNumbersByUserId(ctx context.Context, userID int32) ([]PhoneNumber, error) {
+	rows, err := q.db.Query(ctx, getPhoneNumbersByUserId, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PhoneNumber
+	for rows.Next() {
+		var i PhoneNumber
+		if err := rows.Scan(&i.ID, &i.UserID, &i.PhoneNumber); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPhoneNumbersByUsername = `-- name: GetPhoneNumbersByUsername :many
+SELECT pn.id, pn.user_id, pn.phone_number
+FROM phone_numbers pn
+    JOIN users u ON pn.user_id = u.id
+WHERE
+    u.username = $1
+`
+
+func (q *Queries) GetPhoneNumbersByUsername(ctx context.Context, username string) ([]PhoneNumber, error) {
+	rows, err := q.db.Query(ctx, getPhoneNumbersByUsername, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PhoneNumber
+	for rows.Next() {
+		var i PhoneNumber
+		if err := rows.Scan(&i.ID, &i.UserID, &i.PhoneNumber); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPoolNumberIds = `-- name: GetPoolNumberIds :many
+SELECT phone_number_id FROM phone_number_pool_members WHERE pool_id = $1 ORDER BY phone_number_id
+`
+
+func (q *Queries) GetPoolNumberIds(ctx context.Context, poolID int32) ([]int32, error) {
+	rows, err := q.db.Query(ctx, getPoolNumberIds, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var phone_number_id int32
+		if err := rows.Scan(&phone_number_id); err != nil {
+			return nil, err
+		}
+		items = append(items, phone_number_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPoolNumbers = `-- name: GetPoolNumbers :many
+SELECT m.phone_number_id, pn.phone_number, m.last_used_at
+FROM phone_number_pool_members m
+    JOIN phone_numbers pn ON pn.id = m.phone_number_id
+WHERE m.pool_id = $1 ORDER BY m.phone_number_id
+`
+
+type GetPoolNumbersRow struct {
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	PhoneNumber   string           `db:"phone_number" json:"phone_number"`
+	LastUsedAt    pgtype.Timestamp `db:"last_used_at" json:"last_used_at"`
+}
+
+func (q *Queries) GetPoolNumbers(ctx context.Context, poolID int32) ([]GetPoolNumbersRow, error) {
+	rows, err := q.db.Query(ctx, getPoolNumbers, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPoolNumbersRow
+	for rows.Next() {
+		var i GetPoolNumbersRow
+		if err := rows.Scan(&i.PhoneNumberID, &i.PhoneNumber, &i.LastUsedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProviderAccount = `-- name: GetProviderAccount :one
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts WHERE id = $1
+`
+
+func (q *Queries) GetProviderAccount(ctx context.Context, id int32) (ProviderAccount, error) {
+	row := q.db.QueryRow(ctx, getProviderAccount, id)
+	var i ProviderAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Driver,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Prefixes,
+		&i.IsDefault,
+	)
+	return i, err
+}
+
+const getQuietHours = `-- name: GetQuietHours :many
+SELECT id, user_id, category, start_time, end_time, action FROM quiet_hours WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetQuietHours(ctx context.Context, userID int32) ([]QuietHour, error) {
+	rows, err := q.db.Query(ctx, getQuietHours, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []QuietHour
+	for rows.Next() {
+		var i QuietHour
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Category,
+			&i.StartTime,
+			&i.EndTime,
+			&i.Action,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getQuotaPolicies = `-- name: GetQuotaPolicies :many
+SELECT user_id, priority, sms_limit, window_seconds, burst_credits, recharge_seconds, updated_at FROM quota_policies WHERE user_id = $1 ORDER BY priority
+`
+
+func (q *Queries) GetQuotaPolicies(ctx context.Context, userID int32) ([]QuotaPolicy, error) {
+	rows, err := q.db.Query(ctx, getQuotaPolicies, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []QuotaPolicy
+	for rows.Next() {
+		var i QuotaPolicy
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Priority,
+			&i.SmsLimit,
+			&i.WindowSeconds,
+			&i.BurstCredits,
+			&i.RechargeSeconds,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getQuotaPolicy = `-- name: GetQuotaPolicy :one
+SELECT user_id, priority, sms_limit, window_seconds, burst_credits, recharge_seconds, updated_at FROM quota_policies WHERE user_id = $1 AND priority = $2
+`
+
+type GetQuotaPolicyParams struct {
+	UserID   int32  `db:"user_id" json:"user_id"`
+	Priority string `db:"priority" json:"priority"`
+}
+
+func (q *Queries) GetQuotaPolicy(ctx context.Context, arg GetQuotaPolicyParams) (QuotaPolicy, error) {
+	row := q.db.QueryRow(ctx, getQuotaPolicy, arg.UserID, arg.Priority)
+	var i QuotaPolicy
+	err := row.Scan(
+		&i.UserID,
+		&i.Priority,
+		&i.SmsLimit,
+		&i.WindowSeconds,
+		&i.BurstCredits,
+		&i.RechargeSeconds,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getQuotaUsage = `-- name: GetQuotaUsage :one
+SELECT u.monthly_quota,
+    (SELECT COUNT(*) FROM sms s WHERE s.user_id = u.id AND s.delivered_at >= date_trunc('month', LOCALTIMESTAMP) AND s.cost > 0) AS used
+FROM users u
+WHERE u.id = $1
+`
+
+type GetQuotaUsageRow struct {
+	MonthlyQuota pgtype.Int4 `db:"monthly_quota" json:"monthly_quota"`
+	Used         int64       `db:"used" json:"used"`
+}
+
+// the monthly quota of a user and the sms charged to them this month
+func (q *Queries) GetQuotaUsage(ctx context.Context, id int32) (GetQuotaUsageRow, error) {
+	row := q.db.QueryRow(ctx, getQuotaUsage, id)
+	var i GetQuotaUsageRow
+	err := row.Scan(&i.MonthlyQuota, &i.Used)
+	return i, err
+}
+
+const getRecipientLimit = `-- name: GetRecipientLimit :one
+SELECT recipient_hourly_limit FROM users WHERE id = $1
+`
+
+func (q *Queries) GetRecipientLimit(ctx context.Context, id int32) (pgtype.Int4, error) {
+	row := q.db.QueryRow(ctx, getRecipientLimit, id)
+	var recipient_hourly_limit pgtype.Int4
+	err := row.Scan(&recipient_hourly_limit)
+	return recipient_hourly_limit, err
+}
+
+const getReseller = `-- name: GetReseller :one
+SELECT user_id, margin, sender, footer FROM resellers WHERE user_id = $1
+`
+
+func (q *Queries) GetReseller(ctx context.Context, userID int32) (Reseller, error) {
+	row := q.db.QueryRow(ctx, getReseller, userID)
+	var i Reseller
+	err := row.Scan(
+		&i.UserID,
+		&i.Margin,
+		&i.Sender,
+		&i.Footer,
+	)
+	return i, err
+}
+
+const getResellerUsage = `-- name: GetResellerUsage :many
+SELECT c.id AS user_id, c.username,
+    COUNT(s.id) AS messages,
+    COUNT(s.id) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(s.id) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (COALESCE(SUM(s.cost), 0) * 100)::BIGINT AS cents
+FROM users c
+JOIN users a ON a.id = c.id OR a.parent_id = c.id
+LEFT JOIN sms s ON s.user_id = a.id AND s.delivered_at >= $1 AND s.delivered_at < $2
+WHERE c.reseller_id = $3
+GROUP BY c.id, c.username
+ORDER BY c.id
+`
+
+type GetResellerUsageParams struct {
+	Since      pgtype.Timestamp `db:"since" json:"since"`
+	Until      pgtype.Timestamp `db:"until" json:"until"`
+	ResellerID pgtype.Int4      `db:"reseller_id" json:"reseller_id"`
+}
+
+type GetResellerUsageRow struct {
+	UserID    int32  `db:"user_id" json:"user_id"`
+	Username  string `db:"username" json:"username"`
+	Messages  int64  `db:"messages" json:"messages"`
+	Delivered int64  `db:"delivered" json:"delivered"`
+	Failed    int64  `db:"failed" json:"failed"`
+	Cents     int64  `db:"cents" json:"cents"`
+}
+
+// the sms of every customer of a reseller and their subaccounts
+func (q *Queries) GetResellerUsage(ctx context.Context, arg GetResellerUsageParams) ([]GetResellerUsageRow, error) {
+	rows, err := q.db.Query(ctx, getResellerUsage, arg.Since, arg.Until, arg.ResellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetResellerUsageRow
+	for rows.Next() {
+		var i GetResellerUsageRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Username,
+			&i.Messages,
+			&i.Delivered,
+			&i.Failed,
+			&i.Cents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRevenueToday = `-- name: GetRevenueToday :one
+SELECT COALESCE(SUM(cost), 0)::DECIMAL AS revenue
+FROM sms
+WHERE delivered_at >= date_trunc('day', CURRENT_TIMESTAMP)
+`
+
+func (q *Queries) GetRevenueToday(ctx context.Context) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, getRevenueToday)
+	var revenue pgtype.Numeric
+	err := row.Scan(&revenue)
+	return revenue, err
+}
+
+const getSchemaVersion = `-- name: GetSchemaVersion :one
+SELECT COALESCE(MAX(version), 0)::int FROM schema_version
+`
+
+func (q *Queries) GetSchemaVersion(ctx context.Context) (int32, error) {
+	row := q.db.QueryRow(ctx, getSchemaVersion)
+	var column_1 int32
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getServiceToken = `-- name: GetServiceToken :one
+SELECT api_keys.id, api_keys.user_id, users.username, api_keys.name, api_keys.scopes, api_keys.allowed_cidrs,
+    api_keys.created_at, api_keys.expires_at, api_keys.revoked_at
+FROM api_keys
+JOIN users ON users.id = api_keys.user_id
+WHERE api_keys.id = $1 AND api_keys.service
+`
+
+type GetServiceTokenRow struct {
+	ID           int32            `db:"id" json:"id"`
+	UserID       int32            `db:"user_id" json:"user_id"`
+	Username     string           `db:"username" json:"username"`
+	Name         string           `db:"name" json:"name"`
+	Scopes       []string         `db:"scopes" json:"scopes"`
+	AllowedCidrs []netip.Prefix   `db:"allowed_cidrs" json:"allowed_cidrs"`
+	CreatedAt    pgtype.Timestamp `db:"created_at" json:"created_at"`
+	ExpiresAt    pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	RevokedAt    pgtype.Timestamp `db:"revoked_at" json:"revoked_at"`
+}
+
+func (q *Queries) GetServiceToken(ctx context.Context, id int32) (GetServiceTokenRow, error) {
+	row := q.db.QueryRow(ctx, getServiceToken, id)
+	var i GetServiceTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Username,
+		&i.Name,
+		&i.Scopes,
+		&i.AllowedCidrs,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getServiceTokensByUser = `-- name: GetServiceTokensByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from FROM api_keys WHERE user_id = $1 AND service ORDER BY id
+`
+
+func (q *Queries) GetServiceTokensByUser(ctx context.Context, userID int32) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, getServiceTokensByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.RevokedAt,
+			&i.AllowedCidrs,
+			&i.ThrottleLimit,
+			&i.ThrottledUntil,
+			&i.SuspendedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.Service,
+			&i.RotatedFrom,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSmsByExternalId = `-- name: GetSmsByExternalId :one
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = $1 AND external_id = $2
+`
+
+type GetSmsByExternalIdParams struct {
+	UserID     int32       `db:"user_id" json:"user_id"`
+	ExternalID pgtype.Text `db:"external_id" json:"external_id"`
+}
+
+func (q *Queries) GetSmsByExternalId(ctx context.Context, arg GetSmsByExternalIdParams) (Sm, error) {
+	row := q.db.QueryRow(ctx, getSmsByExternalId, arg.UserID, arg.ExternalID)
+	var i Sm
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.PhoneNumberID,
+		&i.ToPhoneNumber,
+		&i.Message,
+		&i.Status,
+		&i.DeliveredAt,
+		&i.Category,
+		&i.ExpiresAt,
+		&i.Priority,
+		&i.Cost,
+		&i.ExternalID,
+		&i.Metadata,
+		&i.Tags,
+		&i.MessageID,
+		&i.FailureReason,
+	)
+	return i, err
+}
+
+const getSmsByMessageId = `-- name: GetSmsByMessageId :one
+SELECT s.id, s.to_phone_number, s.message, s.status, s.delivered_at, s.cost, p.phone_number
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+JOIN phone_numbers p ON p.id = s.phone_number_id
+WHERE e.event = 'created' AND e.metadata->>'message_id' = ANY($1::text[]) AND s.user_id = $2
+`
+
+type GetSmsByMessageIdParams struct {
+	MessageIds []string `db:"message_ids" json:"message_ids"`
+	UserID     int32    `db:"user_id" json:"user_id"`
+}
+
+type GetSmsByMessageIdRow struct {
+	ID            int32            `db:"id" json:"id"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Message       string           `db:"message" json:"message"`
+	Status        string           `db:"status" json:"status"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	PhoneNumber   string           `db:"phone_number" json:"phone_number"`
+}
+
+func (q *Queries) GetSmsByMessageId(ctx context.Context, arg GetSmsByMessageIdParams) (GetSmsByMessageIdRow, error) {
+	row := q.db.QueryRow(ctx, getSmsByMessageId, arg.MessageIds, arg.UserID)
+	var i GetSmsByMessageIdRow
+	err := row.Scan(
+		&i.ID,
+		&i.ToPhoneNumber,
+		&i.Message,
+		&i.Status,
+		&i.DeliveredAt,
+		&i.Cost,
+		&i.PhoneNumber,
+	)
+	return i, err
+}
+
+const getSmsByRef = `-- name: GetSmsByRef :one
+SELECT s.id, s.priority
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'submitted' AND e.provider = $1::text AND e.metadata->>'ref' = $2::text
+ORDER BY e.id DESC
+LIMIT 1
+`
+
+type GetSmsByRefParams struct {
+	Provider string `db:"provider" json:"provider"`
+	Ref      string `db:"ref" json:"ref"`
+}
+
+type GetSmsByRefRow struct {
+	ID       int32  `db:"id" json:"id"`
+	Priority string `db:"priority" json:"priority"`
+}
+
+func (q *Queries) GetSmsByRef(ctx context.Context, arg GetSmsByRefParams) (GetSmsByRefRow, error) {
+	row := q.db.QueryRow(ctx, getSmsByRef, arg.Provider, arg.Ref)
+	var i GetSmsByRefRow
+	err := row.Scan(&i.ID, &i.Priority)
+	return i, err
+}
+
+const getSmsEvents = `-- name: GetSmsEvents :many
+SELECT id, sms_id, event, actor, provider, metadata, occurred_at, request_id FROM sms_events WHERE sms_id = $1 ORDER BY occurred_at, id
+`
+
+func (q *Queries) GetSmsEvents(ctx context.Context, smsID int32) ([]SmsEvent, error) {
+	rows, err := q.db.Query(ctx, getSmsEvents, smsID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SmsEvent
+	for rows.Next() {
+		var i SmsEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.SmsID,
+			&i.Event,
+			&i.Actor,
+			&i.Provider,
+			&i.Metadata,
+			&i.OccurredAt,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSmsEventsByRequestId = `-- name: GetSmsEventsByRequestId :many
+SELECT id, sms_id, event, actor, provider, metadata, occurred_at, request_id FROM sms_events
+WHERE sms_id IN (SELECT sms_id FROM sms_events e WHERE e.request_id = $1)
+ORDER BY sms_id, occurred_at, id
+`
+
+func (q *Queries) GetSmsEventsByRequestId(ctx context.Context, requestID pgtype.Text) ([]SmsEvent, error) {
+	rows, err := q.db.Query(ctx, getSmsEventsByRequestId, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SmsEvent
+	for rows.Next() {
+		var i SmsEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.SmsID,
+			&i.Event,
+			&i.Actor,
+			&i.Provider,
+			&i.Metadata,
+			&i.OccurredAt,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSmsForExport = `-- name: GetSmsForExport :many
+SELECT id, user_id, phone_number_id, to_phone_number, message, status, delivered_at, category, expires_at, priority, cost, external_id, metadata, tags, message_id, failure_reason
+FROM sms
+WHERE user_id = $1 AND delivered_at >= $2 AND delivered_at < $3 AND id > $4
+ORDER BY id
+LIMIT $5
+`
+
+type GetSmsForExportParams struct {
+	UserID        int32            `db:"user_id" json:"user_id"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	DeliveredAt_2 pgtype.Timestamp `db:"delivered_at_2" json:"delivered_at_2"`
+	ID            int32            `db:"id" json:"id"`
+	Limit         int32            `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetSmsForExport(ctx context.Context, arg GetSmsForExportParams) ([]Sm, error) {
+	rows, err := q.db.Query(ctx, getSmsForExport,
+		arg.UserID,
+		arg.DeliveredAt,
+		arg.DeliveredAt_2,
+		arg.ID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Sm
+	for rows.Next() {
+		var i Sm
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PhoneNumberID,
+			&i.ToPhoneNumber,
+			&i.Message,
+			&i.Status,
+			&i.DeliveredAt,
+			&i.Category,
+			&i.ExpiresAt,
+			&i.Priority,
+			&i.Cost,
+			&i.ExternalID,
+			&i.Metadata,
+			&i.Tags,
+			&i.MessageID,
+			&i.FailureReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSmsForSubmit = `-- name: GetSmsForSubmit :one
+SELECT sms.id, sms.status, sms.priority, COALESCE(u.sender, r.sender, phone_numbers.phone_number)::VARCHAR AS phone_number, sms.to_phone_number, sms.message, sms.message_id, sms.user_id, sms.expires_at
+FROM sms
+JOIN phone_numbers ON phone_numbers.id = sms.phone_number_id
+JOIN users s ON s.id = sms.user_id
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE sms.id = $1
+`
+
+type GetSmsForSubmitRow struct {
+	ID            int32            `db:"id" json:"id"`
+	Status        string           `db:"status" json:"status"`
+	Priority      string           `db:"priority" json:"priority"`
+	PhoneNumber   string           `db:"phone_number" json:"phone_number"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Message       string           `db:"message" json:"message"`
+	MessageID     pgtype.UUID      `db:"message_id" json:"message_id"`
+	UserID        int32            `db:"user_id" json:"user_id"`
+	ExpiresAt     pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+}
+
+// phone_number is the sender of the sms: that of its user or their reseller when
+// they have one, else the number it was sent from
+func (q *Queries) GetSmsForSubmit(ctx context.Context, id int32) (GetSmsForSubmitRow, error) {
+	row := q.db.QueryRow(ctx, getSmsForSubmit, id)
+	var i GetSmsForSubmitRow
+	err := row.Scan(
+		&i.ID,
+		&i.Status,
+		&i.Priority,
+		&i.PhoneNumber,
+		&i.ToPhoneNumber,
+		&i.Message,
+		&i.MessageID,
+		&i.UserID,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getSmsIdByMessageId = `-- name: GetSmsIdByMessageId :one
+SELECT id FROM sms WHERE message_id = $1
+`
+
+func (q *Queries) GetSmsIdByMessageId(ctx context.Context, messageID pgtype.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, getSmsIdByMessageId, messageID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getSmsLifecycle = `-- name: GetSmsLifecycle :one
+SELECT s.priority, s.user_id,
+    CAST(MIN(e.provider) FILTER (WHERE e.event = 'submitted') AS VARCHAR) AS provider,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'created') AS TIMESTAMP) AS created,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'stored') AS TIMESTAMP) AS stored,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'submitted') AS TIMESTAMP) AS submitted,
+    CAST(MIN(e.occurred_at) FILTER (WHERE e.event = 'delivered') AS TIMESTAMP) AS delivered
+FROM sms s
+LEFT JOIN sms_events e ON e.sms_id = s.id
+WHERE s.id = $1
+GROUP BY s.priority, s.user_id
+`
+
+type GetSmsLifecycleRow struct {
+	Priority  string           `db:"priority" json:"priority"`
+	UserID    int32            `db:"user_id" json:"user_id"`
+	Provider  pgtype.Text      `db:"provider" json:"provider"`
+	Created   pgtype.Timestamp `db:"created" json:"created"`
+	Stored    pgtype.Timestamp `db:"stored" json:"stored"`
+	Submitted pgtype.Timestamp `db:"submitted" json:"submitted"`
+	Delivered pgtype.Timestamp `db:"delivered" json:"delivered"`
+}
+
+func (q *Queries) GetSmsLifecycle(ctx context.Context, id int32) (GetSmsLifecycleRow, error) {
+	row := q.db.QueryRow(ctx, getSmsLifecycle, id)
+	var i GetSmsLifecycleRow
+	err := row.Scan(
+		&i.Priority,
+		&i.UserID,
+		&i.Provider,
+		&i.Created,
+		&i.Stored,
+		&i.Submitted,
+		&i.Delivered,
+	)
+	return i, err
+}
+
+const getSmsOwner = `-- name: GetSmsOwner :one
+SELECT user_id FROM sms WHERE id = $1
+`
+
+func (q *Queries) GetSmsOwner(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, getSmsOwner, id)
+	var user_id int32
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const getSmsPrice = `-- name: GetSmsPrice :one
+SELECT COALESCE(u.sms_price, ROUND($1::DECIMAL * (1 + COALESCE(r.margin, 0) / 100), 2))::DECIMAL(10,2) AS price
+FROM users s
+JOIN users u ON u.id = COALESCE(s.parent_id, s.id)
+LEFT JOIN resellers r ON r.user_id = u.reseller_id
+WHERE s.id = $2
+`
+
+type GetSmsPriceParams struct {
+	Cost   pgtype.Numeric `db:"cost" json:"cost"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+// what an sms of a user costs: the price their reseller set, else cost plus the
+// margin of their reseller. subaccounts pay the price of their parent
+func (q *Queries) GetSmsPrice(ctx context.Context, arg GetSmsPriceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, getSmsPrice, arg.Cost, arg.UserID)
+	var price pgtype.Numeric
+	err := row.Scan(&price)
+	return price, err
+}
+
+const getSmsPriority = `-- name: GetSmsPriority :one
+SELECT priority FROM sms WHERE id = $1
+`
+
+func (q *Queries) GetSmsPriority(ctx context.Context, id int32) (string, error) {
+	row := q.db.QueryRow(ctx, getSmsPriority, id)
+	var priority string
+	err := row.Scan(&priority)
+	return priority, err
+}
+
+const getSmsStatusForUpdate = `-- name: GetSmsStatusForUpdate :one
+SELECT status, user_id FROM sms WHERE id = $1 FOR UPDATE
+`
+
+type GetSmsStatusForUpdateRow struct {
+	Status string `db:"status" json:"status"`
+	UserID int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) GetSmsStatusForUpdate(ctx context.Context, id int32) (GetSmsStatusForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getSmsStatusForUpdate, id)
+	var i GetSmsStatusForUpdateRow
+	err := row.Scan(&i.Status, &i.UserID)
+	return i, err
+}
+
+const getSmsSubmission = `-- name: GetSmsSubmission :one
+SELECT CAST(COALESCE(provider, '') AS VARCHAR) AS provider, CAST(COALESCE(metadata->>'ref', '') AS VARCHAR) AS ref
+FROM sms_events
+WHERE sms_id = $1 AND event = 'submitted'
+ORDER BY id DESC
+LIMIT 1
+`
+
+type GetSmsSubmissionRow struct {
+	Provider string `db:"provider" json:"provider"`
+	Ref      string `db:"ref" json:"ref"`
+}
+
+// the provider the sms was last submitted through and the id it gave the sms
+func (q *Queries) GetSmsSubmission(ctx context.Context, smsID int32) (GetSmsSubmissionRow, error) {
+	row := q.db.QueryRow(ctx, getSmsSubmission, smsID)
+	var i GetSmsSubmissionRow
+	err := row.Scan(&i.Provider, &i.Ref)
+	return i, err
+}
+
+const getSubaccount = `-- name: GetSubaccount :one
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE parent_id = $1 AND username = $2
+`
+
+type GetSubaccountParams struct {
+	ParentID pgtype.Int4 `db:"parent_id" json:"parent_id"`
+	Username string      `db:"username" json:"username"`
+}
+
+func (q *Queries) GetSubaccount(ctx context.Context, arg GetSubaccountParams) (User, error) {
+	row := q.db.QueryRow(ctx, getSubaccount, arg.ParentID, arg.Username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const getSubaccountUsage = `-- name: GetSubaccountUsage :many
+SELECT u.id AS user_id, u.username,
+    COUNT(s.id) AS messages,
+    COUNT(s.id) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(s.id) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (COALESCE(SUM(s.cost), 0) * 100)::BIGINT AS cents
+FROM users u
+LEFT JOIN sms s ON s.user_id = u.id AND s.delivered_at >= $1 AND s.delivered_at < $2
+WHERE u.id = $3 OR u.parent_id = $3
+GROUP BY u.id, u.username
+ORDER BY u.id
+`
+
+type GetSubaccountUsageParams struct {
+	Since    pgtype.Timestamp `db:"since" json:"since"`
+	Until    pgtype.Timestamp `db:"until" json:"until"`
+	ParentID int32            `db:"parent_id" json:"parent_id"`
+}
+
+type GetSubaccountUsageRow struct {
+	UserID    int32  `db:"user_id" json:"user_id"`
+	Username  string `db:"username" json:"username"`
+	Messages  int64  `db:"messages" json:"messages"`
+	Delivered int64  `db:"delivered" json:"delivered"`
+	Failed    int64  `db:"failed" json:"failed"`
+	Cents     int64  `db:"cents" json:"cents"`
+}
+
+func (q *Queries) GetSubaccountUsage(ctx context.Context, arg GetSubaccountUsageParams) ([]GetSubaccountUsageRow, error) {
+	rows, err := q.db.Query(ctx, getSubaccountUsage, arg.Since, arg.Until, arg.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSubaccountUsageRow
+	for rows.Next() {
+		var i GetSubaccountUsageRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Username,
+			&i.Messages,
+			&i.Delivered,
+			&i.Failed,
+			&i.Cents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubaccounts = `-- name: GetSubaccounts :many
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE parent_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetSubaccounts(ctx context.Context, parentID pgtype.Int4) ([]User, error) {
+	rows, err := q.db.Query(ctx, getSubaccounts, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Footer,
+			&i.Status,
+			&i.RecipientHourlyLimit,
+			&i.ParentID,
+			&i.Pooled,
+			&i.MonthlyQuota,
+			&i.ResellerID,
+			&i.SmsPrice,
+			&i.Sender,
+			&i.ExpressAdmission,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTagUsage = `-- name: GetTagUsage :many
+SELECT t.tag::text AS tag,
+    COUNT(*) AS messages,
+    COUNT(*) FILTER (WHERE s.status = 'delivered') AS delivered,
+    COUNT(*) FILTER (WHERE s.status IN ('failed', 'expired')) AS failed,
+    (SUM(s.cost) * 100)::BIGINT AS cents
+FROM sms s, unnest(s.tags) AS t(tag)
+WHERE s.user_id = $1 AND s.delivered_at >= $2 AND s.delivered_at < $3
+GROUP BY t.tag
+ORDER BY messages DESC, t.tag
+`
+
+type GetTagUsageParams struct {
+	UserID int32            `db:"user_id" json:"user_id"`
+	Since  pgtype.Timestamp `db:"since" json:"since"`
+	Until  pgtype.Timestamp `db:"until" json:"until"`
+}
+
+type GetTagUsageRow struct {
+	Tag       string `db:"tag" json:"tag"`
+	Messages  int64  `db:"messages" json:"messages"`
+	Delivered int64  `db:"delivered" json:"delivered"`
+	Failed    int64  `db:"failed" json:"failed"`
+	Cents     int64  `db:"cents" json:"cents"`
+}
+
+func (q *Queries) GetTagUsage(ctx context.Context, arg GetTagUsageParams) ([]GetTagUsageRow, error) {
+	rows, err := q.db.Query(ctx, getTagUsage, arg.UserID, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTagUsageRow
+	for rows.Next() {
+		var i GetTagUsageRow
+		if err := rows.Scan(
+			&i.Tag,
+			&i.Messages,
+			&i.Delivered,
+			&i.Failed,
+			&i.Cents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTopSendersToday = `-- name: GetTopSendersToday :many
+SELECT u.id, u.username, COUNT(s.id) AS messages, SUM(s.cost)::DECIMAL AS spent
+FROM sms s
+    JOIN users u ON u.id = s.user_id
+WHERE s.delivered_at >= date_trunc('day', CURRENT_TIMESTAMP)
+GROUP BY u.id, u.username
+ORDER BY messages DESC
+LIMIT $1
+`
+
+type GetTopSendersTodayRow struct {
+	ID       int32          `db:"id" json:"id"`
+	Username string         `binding:"required,alphanum" db:"username" json:"username"`
+	Messages int64          `db:"messages" json:"messages"`
+	Spent    pgtype.Numeric `db:"spent" json:"spent"`
+}
+
+func (q *Queries) GetTopSendersToday(ctx context.Context, limit int32) ([]GetTopSendersTodayRow, error) {
+	rows, err := q.db.Query(ctx, getTopSendersToday, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTopSendersTodayRow
+	for rows.Next() {
+		var i GetTopSendersTodayRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Messages,
+			&i.Spent,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsageForInvoice = `-- name: GetUsageForInvoice :many
+SELECT priority, to_phone_number, COUNT(*) AS quantity, (SUM(cost) * 100)::BIGINT AS cents
+FROM sms
+WHERE user_id = $1 AND delivered_at >= $2::DATE AND delivered_at < $3::DATE AND cost > 0
+GROUP BY priority, to_phone_number
+`
+
+type GetUsageForInvoiceParams struct {
+	UserID      int32       `db:"user_id" json:"user_id"`
+	PeriodStart pgtype.Date `db:"period_start" json:"period_start"`
+	PeriodEnd   pgtype.Date `db:"period_end" json:"period_end"`
+}
+
+type GetUsageForInvoiceRow struct {
+	Priority      string `db:"priority" json:"priority"`
+	ToPhoneNumber string `db:"to_phone_number" json:"to_phone_number"`
+	Quantity      int64  `db:"quantity" json:"quantity"`
+	Cents         int64  `db:"cents" json:"cents"`
+}
+
+func (q *Queries) GetUsageForInvoice(ctx context.Context, arg GetUsageForInvoiceParams) ([]GetUsageForInvoiceRow, error) {
+	rows, err := q.db.Query(ctx, getUsageForInvoice, arg.UserID, arg.PeriodStart, arg.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUsageForInvoiceRow
+	for rows.Next() {
+		var i GetUsageForInvoiceRow
+		if err := rows.Scan(
+			&i.Priority,
+			&i.ToPhoneNumber,
+			&i.Quantity,
+			&i.Cents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE username = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRow(ctx, getUser, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const getUserId = `-- name: GetUserId :one
+SELECT id FROM users u WHERE u.username = $1
+`
+
+func (q *Queries) GetUserId(ctx context.Context, username string) (int32, error) {
+	row := q.db.QueryRow(ctx, getUserId, username)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getUserStatus = `-- name: GetUserStatus :one
+SELECT status FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserStatus(ctx context.Context, id int32) (string, error) {
+	row := q.db.QueryRow(ctx, getUserStatus, id)
+	var status string
+	err := row.Scan(&status)
+	return status, err
+}
+
+const getUsersToInvoice = `-- name: GetUsersToInvoice :many
+SELECT DISTINCT s.user_id
+FROM sms s
+WHERE s.delivered_at >= $1::DATE AND s.delivered_at < $2::DATE AND s.cost > 0
+    AND NOT EXISTS (SELECT 1 FROM invoices i WHERE i.user_id = s.user_id AND i.period_start = $1::DATE)
+ORDER BY s.user_id
+`
+
+type GetUsersToInvoiceParams struct {
+	PeriodStart pgtype.Date `db:"period_start" json:"period_start"`
+	PeriodEnd   pgtype.Date `db:"period_end" json:"period_end"`
+}
+
+func (q *Queries) GetUsersToInvoice(ctx context.Context, arg GetUsersToInvoiceParams) ([]int32, error) {
+	rows, err := q.db.Query(ctx, getUsersToInvoice, arg.PeriodStart, arg.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var user_id int32
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookFailure = `-- name: GetWebhookFailure :one
+SELECT f.id, f.webhook_id, w.url, f.event, f.payload, f.attempts, f.last_error, f.failed_at
+FROM webhook_failures f
+    JOIN webhooks w ON w.id = f.webhook_id
+WHERE f.id = $1 AND w.user_id = $2
+`
+
+type GetWebhookFailureParams struct {
+	ID     int32 `db:"id" json:"id"`
+	UserID int32 `db:"user_id" json:"user_id"`
+}
+
+type GetWebhookFailureRow struct {
+	ID        int32            `db:"id" json:"id"`
+	WebhookID int32            `db:"webhook_id" json:"webhook_id"`
+	Url       string           `db:"url" json:"url"`
+	Event     string           `db:"event" json:"event"`
+	Payload   []byte           `db:"payload" json:"payload"`
+	Attempts  int32            `db:"attempts" json:"attempts"`
+	LastError string           `db:"last_error" json:"last_error"`
+	FailedAt  pgtype.Timestamp `db:"failed_at" json:"failed_at"`
+}
+
+func (q *Queries) GetWebhookFailure(ctx context.Context, arg GetWebhookFailureParams) (GetWebhookFailureRow, error) {
+	row := q.db.QueryRow(ctx, getWebhookFailure, arg.ID, arg.UserID)
+	var i GetWebhookFailureRow
+	err := row.Scan(
+		&i.ID,
+		&i.WebhookID,
+		&i.Url,
+		&i.Event,
+		&i.Payload,
+		&i.Attempts,
+		&i.LastError,
+		&i.FailedAt,
+	)
+	return i, err
+}
+
+const getWebhookFailures = `-- name: GetWebhookFailures :many
+SELECT f.id, f.webhook_id, w.url, f.event, f.payload, f.attempts, f.last_error, f.failed_at
+FROM webhook_failures f
+    JOIN webhooks w ON w.id = f.webhook_id
+WHERE w.user_id = $1
+ORDER BY f.id DESC
+LIMIT $2
+`
+
+type GetWebhookFailuresParams struct {
+	UserID int32 `db:"user_id" json:"user_id"`
+	Limit  int32 `db:"limit" json:"limit"`
+}
+
+type GetWebhookFailuresRow struct {
+	ID        int32            `db:"id" json:"id"`
+	WebhookID int32            `db:"webhook_id" json:"webhook_id"`
+	Url       string           `db:"url" json:"url"`
+	Event     string           `db:"event" json:"event"`
+	Payload   []byte           `db:"payload" json:"payload"`
+	Attempts  int32            `db:"attempts" json:"attempts"`
+	LastError string           `db:"last_error" json:"last_error"`
+	FailedAt  pgtype.Timestamp `db:"failed_at" json:"failed_at"`
+}
+
+func (q *Queries) GetWebhookFailures(ctx context.Context, arg GetWebhookFailuresParams) ([]GetWebhookFailuresRow, error) {
+	rows, err := q.db.Query(ctx, getWebhookFailures, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWebhookFailuresRow
+	for rows.Next() {
+		var i GetWebhookFailuresRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.WebhookID,
+			&i.Url,
+			&i.Event,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookSecrets = `-- name: GetWebhookSecrets :one
+SELECT secret, previous_secret, previous_secret_expires_at FROM webhooks WHERE id = $1
+`
+
+type GetWebhookSecretsRow struct {
+	Secret                  string           `db:"secret" json:"secret"`
+	PreviousSecret          pgtype.Text      `db:"previous_secret" json:"previous_secret"`
+	PreviousSecretExpiresAt pgtype.Timestamp `db:"previous_secret_expires_at" json:"previous_secret_expires_at"`
+}
+
+func (q *Queries) GetWebhookSecrets(ctx context.Context, id int32) (GetWebhookSecretsRow, error) {
+	row := q.db.QueryRow(ctx, getWebhookSecrets, id)
+	var i GetWebhookSecretsRow
+	err := row.Scan(&i.Secret, &i.PreviousSecret, &i.PreviousSecretExpiresAt)
+	return i, err
+}
+
+const getWebhooks = `-- name: GetWebhooks :many
+SELECT id, user_id, url, created_at, secret, previous_secret, previous_secret_expires_at FROM webhooks WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetWebhooks(ctx context.Context, userID int32) ([]Webhook, error) {
+	rows, err := q.db.Query(ctx, getWebhooks, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Url,
+			&i.CreatedAt,
+			&i.Secret,
+			&i.PreviousSecret,
+			&i.PreviousSecretExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhooksForSms = `-- name: GetWebhooksForSms :many
+SELECT w.id, w.url FROM webhooks w
+    JOIN sms s ON s.user_id = w.user_id
+WHERE s.id = $1
+ORDER BY w.id
+`
+
+type GetWebhooksForSmsRow struct {
+	ID  int32  `db:"id" json:"id"`
+	Url string `db:"url" json:"url"`
+}
+
+func (q *Queries) GetWebhooksForSms(ctx context.Context, id int32) ([]GetWebhooksForSmsRow, error) {
+	rows, err := q.db.Query(ctx, getWebhooksForSms, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWebhooksForSmsRow
+	for rows.Next() {
+		var i GetWebhooksForSmsRow
+		if err := rows.Scan(&i.ID, &i.Url); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const hasFraudAlertSince = `-- name: HasFraudAlertSince :one
+SELECT EXISTS (SELECT 1 FROM fraud_alerts WHERE api_key_id = $1 AND created_at >= $2)
+`
+
+type HasFraudAlertSinceParams struct {
+	ApiKeyID  int32            `db:"api_key_id" json:"api_key_id"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+func (q *Queries) HasFraudAlertSince(ctx context.Context, arg HasFraudAlertSinceParams) (bool, error) {
+	row := q.db.QueryRow(ctx, hasFraudAlertSince, arg.ApiKeyID, arg.CreatedAt)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const isPhoneNumberTaken = `-- name: IsPhoneNumberTaken :one
+SELECT EXISTS (
+        SELECT 1
+        FROM phone_numbers
+        WHERE
+            phone_number = $1
+            AND user_id <> $2
+    )
+`
+
+type IsPhoneNumberTakenParams struct {
+	PhoneNumber string `db:"phone_number" json:"phone_number"`
+	UserID      int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) IsPhoneNumberTaken(ctx context.Context, arg IsPhoneNumberTakenParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isPhoneNumberTaken, arg.PhoneNumber, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const liftApiKeyRestrictions = `-- name: LiftApiKeyRestrictions :one
+UPDATE api_keys SET suspended_at = NULL, throttle_limit = NULL, throttled_until = NULL
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from
+`
+
+func (q *Queries) LiftApiKeyRestrictions(ctx context.Context, id int32) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, liftApiKeyRestrictions, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.AllowedCidrs,
+		&i.ThrottleLimit,
+		&i.ThrottledUntil,
+		&i.SuspendedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.Service,
+		&i.RotatedFrom,
+	)
+	return i, err
+}
+
+const listAlertRules = `-- name: ListAlertRules :many
+SELECT id, user_id, kind, threshold, email, fired_at, created_at FROM alert_rules ORDER BY id
+`
+
+func (q *Queries) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	rows, err := q.db.Query(ctx, listAlertRules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AlertRule
+	for rows.Next() {
+		var i AlertRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.Threshold,
+			&i.Email,
+			&i.FiredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAlerts = `-- name: ListAlerts :many
+SELECT id, rule_id, user_id, kind, threshold, detail, created_at FROM alerts WHERE user_id = $1 AND id < $2 ORDER BY id DESC LIMIT $3
+`
+
+type ListAlertsParams struct {
+	UserID int32 `db:"user_id" json:"user_id"`
+	Before int32 `db:"before" json:"before"`
+	Lim    int32 `db:"lim" json:"lim"`
+}
+
+func (q *Queries) ListAlerts(ctx context.Context, arg ListAlertsParams) ([]Alert, error) {
+	rows, err := q.db.Query(ctx, listAlerts, arg.UserID, arg.Before, arg.Lim)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Alert
+	for rows.Next() {
+		var i Alert
+		if err := rows.Scan(
+			&i.ID,
+			&i.RuleID,
+			&i.UserID,
+			&i.Kind,
+			&i.Threshold,
+			&i.Detail,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAllProviderAccounts = `-- name: ListAllProviderAccounts :many
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts ORDER BY id
+`
+
+func (q *Queries) ListAllProviderAccounts(ctx context.Context) ([]ProviderAccount, error) {
+	rows, err := q.db.Query(ctx, listAllProviderAccounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ProviderAccount
+	for rows.Next() {
+		var i ProviderAccount
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Driver,
+			&i.Credentials,
+			&i.DataKey,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Prefixes,
+			&i.IsDefault,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFraudAlerts = `-- name: ListFraudAlerts :many
+SELECT id, api_key_id, user_id, reason, detail, action, created_at FROM fraud_alerts WHERE id < $1 ORDER BY id DESC LIMIT $2
+`
+
+type ListFraudAlertsParams struct {
+	Before int32 `db:"before" json:"before"`
+	Lim    int32 `db:"lim" json:"lim"`
+}
+
+func (q *Queries) ListFraudAlerts(ctx context.Context, arg ListFraudAlertsParams) ([]FraudAlert, error) {
+	rows, err := q.db.Query(ctx, listFraudAlerts, arg.Before, arg.Lim)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FraudAlert
+	for rows.Next() {
+		var i FraudAlert
+		if err := rows.Scan(
+			&i.ID,
+			&i.ApiKeyID,
+			&i.UserID,
+			&i.Reason,
+			&i.Detail,
+			&i.Action,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNatsForwards = `-- name: ListNatsForwards :many
+SELECT user_id, url, subject, jetstream, credentials, data_key, created_at, updated_at FROM nats_forwards ORDER BY user_id
+`
+
+func (q *Queries) ListNatsForwards(ctx context.Context) ([]NatsForward, error) {
+	rows, err := q.db.Query(ctx, listNatsForwards)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NatsForward
+	for rows.Next() {
+		var i NatsForward
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Url,
+			&i.Subject,
+			&i.Jetstream,
+			&i.Credentials,
+			&i.DataKey,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProviderAccounts = `-- name: ListProviderAccounts :many
+SELECT id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default FROM provider_accounts WHERE user_id = $1 ORDER BY name
+`
+
+func (q *Queries) ListProviderAccounts(ctx context.Context, userID int32) ([]ProviderAccount, error) {
+	rows, err := q.db.Query(ctx, listProviderAccounts, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ProviderAccount
+	for rows.Next() {
+		var i ProviderAccount
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Driver,
+			&i.Credentials,
+			&i.DataKey,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Prefixes,
+			&i.IsDefault,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProviderRoutes = `-- name: ListProviderRoutes :many
+SELECT id, prefixes, is_default FROM provider_accounts
+WHERE user_id = $1 AND (is_default OR cardinality(prefixes) > 0)
+`
+
+type ListProviderRoutesRow struct {
+	ID        int32    `db:"id" json:"id"`
+	Prefixes  []string `db:"prefixes" json:"prefixes"`
+	IsDefault bool     `db:"is_default" json:"is_default"`
+}
+
+func (q *Queries) ListProviderRoutes(ctx context.Context, userID int32) ([]ListProviderRoutesRow, error) {
+	rows, err := q.db.Query(ctx, listProviderRoutes, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListProviderRoutesRow
+	for rows.Next() {
+		var i ListProviderRoutesRow
+		if err := rows.Scan(&i.ID, &i.Prefixes, &i.IsDefault); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSmsToArchive = `-- name: ListSmsToArchive :many
+SELECT s.id, s.user_id, s.phone_number_id, s.to_phone_number, s.status, s.category, s.priority, s.cost,
+    s.external_id, s.tags, s.delivered_at, CAST(e.occurred_at AS TIMESTAMP) AS created_at
+FROM sms_events e
+JOIN sms s ON s.id = e.sms_id
+WHERE e.event = 'created' AND e.occurred_at >= $1 AND e.occurred_at < $2
+    AND (s.user_id, s.id) > ($3::int, $4::int)
+ORDER BY s.user_id, s.id
+LIMIT $5
+`
+
+type ListSmsToArchiveParams struct {
+	Since       pgtype.Timestamp `db:"since" json:"since"`
+	Until       pgtype.Timestamp `db:"until" json:"until"`
+	AfterUserID int32            `db:"after_user_id" json:"after_user_id"`
+	AfterID     int32            `db:"after_id" json:"after_id"`
+	BatchSize   int32            `db:"batch_size" json:"batch_size"`
+}
+
+type ListSmsToArchiveRow struct {
+	ID            int32            `db:"id" json:"id"`
+	UserID        int32            `db:"user_id" json:"user_id"`
+	PhoneNumberID int32            `db:"phone_number_id" json:"phone_number_id"`
+	ToPhoneNumber string           `db:"to_phone_number" json:"to_phone_number"`
+	Status        string           `db:"status" json:"status"`
+	Category      string           `db:"category" json:"category"`
+	Priority      string           `db:"priority" json:"priority"`
+	Cost          pgtype.Numeric   `db:"cost" json:"cost"`
+	ExternalID    pgtype.Text      `db:"external_id" json:"external_id"`
+	Tags          []string         `db:"tags" json:"tags"`
+	DeliveredAt   pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	CreatedAt     pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+func (q *Queries) ListSmsToArchive(ctx context.Context, arg ListSmsToArchiveParams) ([]ListSmsToArchiveRow, error) {
+	rows, err := q.db.Query(ctx, listSmsToArchive,
+		arg.Since,
+		arg.Until,
+		arg.AfterUserID,
+		arg.AfterID,
+		arg.BatchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSmsToArchiveRow
+	for rows.Next() {
+		var i ListSmsToArchiveRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PhoneNumberID,
+			&i.ToPhoneNumber,
+			&i.Status,
+			&i.Category,
+			&i.Priority,
+			&i.Cost,
+			&i.ExternalID,
+			&i.Tags,
+			&i.DeliveredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission FROM users WHERE id > $1 ORDER BY id LIMIT $2
+`
+
+type ListUsersParams struct {
+	After int32 `db:"after" json:"after"`
+	Lim   int32 `db:"lim" json:"lim"`
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsers, arg.After, arg.Lim)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Footer,
+			&i.Status,
+			&i.RecipientHourlyLimit,
+			&i.ParentID,
+			&i.Pooled,
+			&i.MonthlyQuota,
+			&i.ResellerID,
+			&i.SmsPrice,
+			&i.Sender,
+			&i.ExpressAdmission,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkerErrors = `-- name: ListWorkerErrors :many
+SELECT id, message_id, subject, sms_id, priority, stage, class, error, attempt, final, worker, occurred_at, created_at FROM worker_errors
+WHERE id < $1
+    AND ($2::text IS NULL OR stage = $2)
+    AND ($3::text IS NULL OR class = $3)
+    AND ($4::int IS NULL OR sms_id = $4)
+ORDER BY id DESC LIMIT $5
+`
+
+type ListWorkerErrorsParams struct {
+	Before int32       `db:"before" json:"before"`
+	Stage  pgtype.Text `db:"stage" json:"stage"`
+	Class  pgtype.Text `db:"class" json:"class"`
+	SmsID  pgtype.Int4 `db:"sms_id" json:"sms_id"`
+	Lim    int32       `db:"lim" json:"lim"`
+}
+
+func (q *Queries) ListWorkerErrors(ctx context.Context, arg ListWorkerErrorsParams) ([]WorkerError, error) {
+	rows, err := q.db.Query(ctx, listWorkerErrors,
+		arg.Before,
+		arg.Stage,
+		arg.Class,
+		arg.SmsID,
+		arg.Lim,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WorkerError
+	for rows.Next() {
+		var i WorkerError
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Subject,
+			&i.SmsID,
+			&i.Priority,
+			&i.Stage,
+			&i.Class,
+			&i.Error,
+			&i.Attempt,
+			&i.Final,
+			&i.Worker,
+			&i.OccurredAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkers = `-- name: ListWorkers :many
+SELECT instance_id, hostname, version, started_at, last_seen_at, processed, lag FROM workers WHERE last_seen_at >= $1 ORDER BY hostname, started_at
+`
+
+func (q *Queries) ListWorkers(ctx context.Context, seenSince pgtype.Timestamp) ([]Worker, error) {
+	rows, err := q.db.Query(ctx, listWorkers, seenSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Worker
+	for rows.Next() {
+		var i Worker
+		if err := rows.Scan(
+			&i.InstanceID,
+			&i.Hostname,
+			&i.Version,
+			&i.StartedAt,
+			&i.LastSeenAt,
+			&i.Processed,
+			&i.Lag,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockPhoneNumber = `-- name: LockPhoneNumber :exec
+SELECT pg_advisory_xact_lock(hashtext($1::text))
+`
+
+// serializes the transactions adding phone_number until they end
+func (q *Queries) LockPhoneNumber(ctx context.Context, phoneNumber string) error {
+	_, err := q.db.Exec(ctx, lockPhoneNumber, phoneNumber)
+	return err
+}
+
+const markMessageProcessed = `-- name: MarkMessageProcessed :execrows
+INSERT INTO processed_messages (message_id, sms_id) VALUES ($1, $2) ON CONFLICT (message_id) DO NOTHING
+`
+
+type MarkMessageProcessedParams struct {
+	MessageID string      `db:"message_id" json:"message_id"`
+	SmsID     pgtype.Int4 `db:"sms_id" json:"sms_id"`
+}
+
+func (q *Queries) MarkMessageProcessed(ctx context.Context, arg MarkMessageProcessedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markMessageProcessed, arg.MessageID, arg.SmsID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const moveImportRows = `-- name: MoveImportRows :execrows
+INSERT INTO sms (user_id, external_id, phone_number_id, to_phone_number, message, status, delivered_at, cost, priority, category)
+SELECT $1::int, external_id, phone_number_id, to_phone_number, message, status, delivered_at, cost, priority, category
+FROM sms_import_rows
+WHERE job_id = $2
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+`
+
+type MoveImportRowsParams struct {
+	UserID int32 `db:"user_id" json:"user_id"`
+	JobID  int32 `db:"job_id" json:"job_id"`
+}
+
+func (q *Queries) MoveImportRows(ctx context.Context, arg MoveImportRowsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, moveImportRows, arg.UserID, arg.JobID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const nextApiKeyId = `-- name: NextApiKeyId :one
+SELECT nextval(pg_get_serial_sequence('api_keys', 'id'))::INT AS id
+`
+
+// reserves the id of a key, so signed tokens can carry it
+func (q *Queries) NextApiKeyId(ctx context.Context) (int32, error) {
+	row := q.db.QueryRow(ctx, nextApiKeyId)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const nextPoolTurn = `-- name: NextPoolTurn :one
+UPDATE phone_number_pools SET turns = turns + 1 WHERE id = $1 RETURNING turns
+`
+
+func (q *Queries) NextPoolTurn(ctx context.Context, id int32) (int64, error) {
+	row := q.db.QueryRow(ctx, nextPoolTurn, id)
+	var turns int64
+	err := row.Scan(&turns)
+	return turns, err
+}
+
+const purgeProcessedMessages = `-- name: PurgeProcessedMessages :execrows
+DELETE FROM processed_messages WHERE processed_at < $1
+`
+
+func (q *Queries) PurgeProcessedMessages(ctx context.Context, processedAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeProcessedMessages, processedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeWorkerErrors = `-- name: PurgeWorkerErrors :execrows
+DELETE FROM worker_errors WHERE created_at < $1
+`
+
+func (q *Queries) PurgeWorkerErrors(ctx context.Context, createdAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeWorkerErrors, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordWorkerHeartbeat = `-- name: RecordWorkerHeartbeat :exec
+INSERT INTO workers (instance_id, hostname, version, started_at, last_seen_at, processed, lag)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (instance_id) DO UPDATE SET
+    hostname = EXCLUDED.hostname,
+    version = EXCLUDED.version,
+    started_at = EXCLUDED.started_at,
+    last_seen_at = EXCLUDED.last_seen_at,
+    processed = EXCLUDED.processed,
+    lag = EXCLUDED.lag
+WHERE workers.last_seen_at < EXCLUDED.last_seen_at
+`
+
+type RecordWorkerHeartbeatParams struct {
+	InstanceID string           `db:"instance_id" json:"instance_id"`
+	Hostname   string           `db:"hostname" json:"hostname"`
+	Version    string           `db:"version" json:"version"`
+	StartedAt  pgtype.Timestamp `db:"started_at" json:"started_at"`
+	LastSeenAt pgtype.Timestamp `db:"last_seen_at" json:"last_seen_at"`
+	Processed  int64            `db:"processed" json:"processed"`
+	Lag        []byte           `db:"lag" json:"lag"`
+}
+
+// heartbeats arriving out of order never move last_seen_at back
+func (q *Queries) RecordWorkerHeartbeat(ctx context.Context, arg RecordWorkerHeartbeatParams) error {
+	_, err := q.db.Exec(ctx, recordWorkerHeartbeat,
+		arg.InstanceID,
+		arg.Hostname,
+		arg.Version,
+		arg.StartedAt,
+		arg.LastSeenAt,
+		arg.Processed,
+		arg.Lag,
+	)
+	return err
+}
+
+const refundBalance = `-- name: RefundBalance :one
+UPDATE users SET balance = balance + $1
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = $2)
+RETURNING balance
+`
+
+type RefundBalanceParams struct {
+	Amount pgtype.Numeric `db:"amount" json:"amount"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) RefundBalance(ctx context.Context, arg RefundBalanceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, refundBalance, arg.Amount, arg.UserID)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const releaseMqttDeviceQuota = `-- name: ReleaseMqttDeviceQuota :exec
+UPDATE mqtt_devices SET quota_used = quota_used - 1 WHERE id = $1 AND quota_day = CURRENT_DATE AND quota_used > 0
+`
+
+func (q *Queries) ReleaseMqttDeviceQuota(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, releaseMqttDeviceQuota, id)
+	return err
+}
+
+const revokeApiKey = `-- name: RevokeApiKey :one
+UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL RETURNING id
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, revokeApiKey, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const revokeMqttDevice = `-- name: RevokeMqttDevice :one
+UPDATE mqtt_devices SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND api_key_id = $2 AND revoked_at IS NULL RETURNING id
+`
+
+type RevokeMqttDeviceParams struct {
+	ID       int32 `db:"id" json:"id"`
+	ApiKeyID int32 `db:"api_key_id" json:"api_key_id"`
+}
+
+func (q *Queries) RevokeMqttDevice(ctx context.Context, arg RevokeMqttDeviceParams) (int32, error) {
+	row := q.db.QueryRow(ctx, revokeMqttDevice, arg.ID, arg.ApiKeyID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const rewrapNatsForward = `-- name: RewrapNatsForward :execrows
+UPDATE nats_forwards SET data_key = $1 WHERE user_id = $2 AND data_key = $3
+`
+
+type RewrapNatsForwardParams struct {
+	DataKey  string `db:"data_key" json:"data_key"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+	Previous string `db:"previous" json:"previous"`
+}
+
+func (q *Queries) RewrapNatsForward(ctx context.Context, arg RewrapNatsForwardParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rewrapNatsForward, arg.DataKey, arg.UserID, arg.Previous)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rewrapProviderAccount = `-- name: RewrapProviderAccount :execrows
+UPDATE provider_accounts SET data_key = $1 WHERE id = $2 AND data_key = $3
+`
+
+type RewrapProviderAccountParams struct {
+	DataKey  string `db:"data_key" json:"data_key"`
+	ID       int32  `db:"id" json:"id"`
+	Previous string `db:"previous" json:"previous"`
+}
+
+func (q *Queries) RewrapProviderAccount(ctx context.Context, arg RewrapProviderAccountParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rewrapProviderAccount, arg.DataKey, arg.ID, arg.Previous)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rotateWebhookSecret = `-- name: RotateWebhookSecret :one
+UPDATE webhooks
+SET previous_secret = secret, previous_secret_expires_at = $3, secret = $4
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, url, created_at, secret, previous_secret, previous_secret_expires_at
+`
+
+type RotateWebhookSecretParams struct {
+	ID                      int32            `db:"id" json:"id"`
+	UserID                  int32            `db:"user_id" json:"user_id"`
+	PreviousSecretExpiresAt pgtype.Timestamp `db:"previous_secret_expires_at" json:"previous_secret_expires_at"`
+	Secret                  string           `db:"secret" json:"secret"`
+}
+
+func (q *Queries) RotateWebhookSecret(ctx context.Context, arg RotateWebhookSecretParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, rotateWebhookSecret,
+		arg.ID,
+		arg.UserID,
+		arg.PreviousSecretExpiresAt,
+		arg.Secret,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.CreatedAt,
+		&i.Secret,
+		&i.PreviousSecret,
+		&i.PreviousSecretExpiresAt,
+	)
+	return i, err
+}
+
+const setApiKeyAllowedCidrs = `-- name: SetApiKeyAllowedCidrs :one
+UPDATE api_keys SET allowed_cidrs = $1 WHERE id = $2 AND revoked_at IS NULL RETURNING id, user_id, name, prefix, key_hash, scopes, created_at, revoked_at, allowed_cidrs, throttle_limit, throttled_until, suspended_at, expires_at, last_used_at, service, rotated_from
+`
+
+type SetApiKeyAllowedCidrsParams struct {
+	AllowedCidrs []netip.Prefix `db:"allowed_cidrs" json:"allowed_cidrs"`
+	ID           int32          `db:"id" json:"id"`
+}
+
+func (q *Queries) SetApiKeyAllowedCidrs(ctx context.Context, arg SetApiKeyAllowedCidrsParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setApiKeyAllowedCidrs, arg.AllowedCidrs, arg.ID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.AllowedCidrs,
+		&i.ThrottleLimit,
+		&i.ThrottledUntil,
+		&i.SuspendedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.Service,
+		&i.RotatedFrom,
+	)
+	return i, err
+}
+
+const setExpressAdmission = `-- name: SetExpressAdmission :one
+UPDATE users SET express_admission = $1 WHERE id = $2 RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type SetExpressAdmissionParams struct {
+	ExpressAdmission pgtype.Text `db:"express_admission" json:"express_admission"`
+	ID               int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) SetExpressAdmission(ctx context.Context, arg SetExpressAdmissionParams) (User, error) {
+	row := q.db.QueryRow(ctx, setExpressAdmission, arg.ExpressAdmission, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const setFooter = `-- name: SetFooter :one
+UPDATE users SET footer = $1 WHERE username = $2 RETURNING footer
+`
+
+type SetFooterParams struct {
+	Footer   pgtype.Text `db:"footer" json:"footer"`
+	Username string      `binding:"required,alphanum" db:"username" json:"username"`
+}
+
+func (q *Queries) SetFooter(ctx context.Context, arg SetFooterParams) (pgtype.Text, error) {
+	row := q.db.QueryRow(ctx, setFooter, arg.Footer, arg.Username)
+	var footer pgtype.Text
+	err := row.Scan(&footer)
+	return footer, err
+}
+
+const setNatsForward = `-- name: SetNatsForward :one
+INSERT INTO nats_forwards (user_id, url, subject, jetstream, credentials, data_key)
+SELECT id, $1, $2, $3, $4, $5 FROM users WHERE id = $6
+ON CONFLICT (user_id) DO UPDATE SET
+    url = EXCLUDED.url,
+    subject = EXCLUDED.subject,
+    jetstream = EXCLUDED.jetstream,
+    credentials = EXCLUDED.credentials,
+    data_key = EXCLUDED.data_key,
+    updated_at = CURRENT_TIMESTAMP
+RETURNING user_id, url, subject, jetstream, credentials, data_key, created_at, updated_at
+`
+
+type SetNatsForwardParams struct {
+	Url         string `db:"url" json:"url"`
+	Subject     string `db:"subject" json:"subject"`
+	Jetstream   bool   `db:"jetstream" json:"jetstream"`
+	Credentials []byte `db:"credentials" json:"credentials"`
+	DataKey     string `db:"data_key" json:"data_key"`
+	UserID      int32  `db:"user_id" json:"user_id"`
+}
+
+// returns no rows for an unknown user
+func (q *Queries) SetNatsForward(ctx context.Context, arg SetNatsForwardParams) (NatsForward, error) {
+	row := q.db.QueryRow(ctx, setNatsForward,
+		arg.Url,
+		arg.Subject,
+		arg.Jetstream,
+		arg.Credentials,
+		arg.DataKey,
+		arg.UserID,
+	)
+	var i NatsForward
+	err := row.Scan(
+		&i.UserID,
+		&i.Url,
+		&i.Subject,
+		&i.Jetstream,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const setNotificationStatus = `-- name: SetNotificationStatus :exec
+UPDATE notifications SET status = $1, error = COALESCE($2, error) WHERE id = $3
+`
+
+type SetNotificationStatusParams struct {
+	Status string      `db:"status" json:"status"`
+	Error  pgtype.Text `db:"error" json:"error"`
+	ID     int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) SetNotificationStatus(ctx context.Context, arg SetNotificationStatusParams) error {
+	_, err := q.db.Exec(ctx, setNotificationStatus, arg.Status, arg.Error, arg.ID)
+	return err
+}
+
+const setPhoneNumberPoolStrategy = `-- name: SetPhoneNumberPoolStrategy :one
+UPDATE phone_number_pools SET strategy = $2 WHERE id = $1 RETURNING id, user_id, name, strategy, turns, created_at
+`
+
+type SetPhoneNumberPoolStrategyParams struct {
+	ID       int32  `db:"id" json:"id"`
+	Strategy string `db:"strategy" json:"strategy"`
+}
+
+func (q *Queries) SetPhoneNumberPoolStrategy(ctx context.Context, arg SetPhoneNumberPoolStrategyParams) (PhoneNumberPool, error) {
+	row := q.db.QueryRow(ctx, setPhoneNumberPoolStrategy, arg.ID, arg.Strategy)
+	var i PhoneNumberPool
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Strategy,
+		&i.Turns,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const setProviderRouting = `-- name: SetProviderRouting :one
+UPDATE provider_accounts SET prefixes = $2, is_default = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default
+`
+
+type SetProviderRoutingParams struct {
+	ID        int32    `db:"id" json:"id"`
+	Prefixes  []string `db:"prefixes" json:"prefixes"`
+	IsDefault bool     `db:"is_default" json:"is_default"`
+}
+
+func (q *Queries) SetProviderRouting(ctx context.Context, arg SetProviderRoutingParams) (ProviderAccount, error) {
+	row := q.db.QueryRow(ctx, setProviderRouting, arg.ID, arg.Prefixes, arg.IsDefault)
+	var i ProviderAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Driver,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Prefixes,
+		&i.IsDefault,
+	)
+	return i, err
+}
+
+const setQuotaPolicy = `-- name: SetQuotaPolicy :one
+INSERT INTO quota_policies (user_id, priority, sms_limit, window_seconds, burst_credits, recharge_seconds)
+SELECT id, $1, $2, $3, $4, $5 FROM users WHERE id = $6
+ON CONFLICT (user_id, priority) DO UPDATE SET
+    sms_limit = EXCLUDED.sms_limit,
+    window_seconds = EXCLUDED.window_seconds,
+    burst_credits = EXCLUDED.burst_credits,
+    recharge_seconds = EXCLUDED.recharge_seconds,
+    updated_at = CURRENT_TIMESTAMP
+RETURNING user_id, priority, sms_limit, window_seconds, burst_credits, recharge_seconds, updated_at
+`
+
+type SetQuotaPolicyParams struct {
+	Priority        string `db:"priority" json:"priority"`
+	SmsLimit        int32  `db:"sms_limit" json:"sms_limit"`
+	WindowSeconds   int32  `db:"window_seconds" json:"window_seconds"`
+	BurstCredits    int32  `db:"burst_credits" json:"burst_credits"`
+	RechargeSeconds int32  `db:"recharge_seconds" json:"recharge_seconds"`
+	UserID          int32  `db:"user_id" json:"user_id"`
+}
+
+// returns no rows for an unknown user
+func (q *Queries) SetQuotaPolicy(ctx context.Context, arg SetQuotaPolicyParams) (QuotaPolicy, error) {
+	row := q.db.QueryRow(ctx, setQuotaPolicy,
+		arg.Priority,
+		arg.SmsLimit,
+		arg.WindowSeconds,
+		arg.BurstCredits,
+		arg.RechargeSeconds,
+		arg.UserID,
+	)
+	var i QuotaPolicy
+	err := row.Scan(
+		&i.UserID,
+		&i.Priority,
+		&i.SmsLimit,
+		&i.WindowSeconds,
+		&i.BurstCredits,
+		&i.RechargeSeconds,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const setRecipientLimit = `-- name: SetRecipientLimit :one
+UPDATE users SET recipient_hourly_limit = $1 WHERE id = $2 RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type SetRecipientLimitParams struct {
+	RecipientHourlyLimit pgtype.Int4 `db:"recipient_hourly_limit" json:"recipient_hourly_limit"`
+	ID                   int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) SetRecipientLimit(ctx context.Context, arg SetRecipientLimitParams) (User, error) {
+	row := q.db.QueryRow(ctx, setRecipientLimit, arg.RecipientHourlyLimit, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const setSmsStatus = `-- name: SetSmsStatus :exec
+UPDATE sms SET status = $1, failure_reason = $3 WHERE id = $2
+`
+
+type SetSmsStatusParams struct {
+	Status        string      `db:"status" json:"status"`
+	ID            int32       `db:"id" json:"id"`
+	FailureReason pgtype.Text `db:"failure_reason" json:"failure_reason"`
+}
+
+func (q *Queries) SetSmsStatus(ctx context.Context, arg SetSmsStatusParams) error {
+	_, err := q.db.Exec(ctx, setSmsStatus, arg.Status, arg.ID, arg.FailureReason)
+	return err
+}
+
+const setUserStatus = `-- name: SetUserStatus :one
+UPDATE users SET status = $1 WHERE id = $2 RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type SetUserStatusParams struct {
+	Status string `db:"status" json:"status"`
+	ID     int32  `db:"id" json:"id"`
+}
+
+func (q *Queries) SetUserStatus(ctx context.Context, arg SetUserStatusParams) (User, error) {
+	row := q.db.QueryRow(ctx, setUserStatus, arg.Status, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const startJob = `-- name: StartJob :one
+UPDATE jobs SET status = 'running', updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status IN ('queued', 'running') RETURNING id, user_id, kind, status, params, progress, row_count, result, error, created_at, updated_at, finished_at
+`
+
+func (q *Queries) StartJob(ctx context.Context, id int32) (Job, error) {
+	row := q.db.QueryRow(ctx, startJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.Status,
+		&i.Params,
+		&i.Progress,
+		&i.RowCount,
+		&i.Result,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const subBalance = `-- name: SubBalance :one
+UPDATE users SET balance = balance - $1
+WHERE id = (SELECT CASE WHEN u.pooled THEN u.parent_id ELSE u.id END FROM users u WHERE u.id = $2)
+RETURNING balance
+`
+
+type SubBalanceParams struct {
+	Amount pgtype.Numeric `db:"amount" json:"amount"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) SubBalance(ctx context.Context, arg SubBalanceParams) (pgtype.Numeric, error) {
 	row := q.db.QueryRow(ctx, subBalance, arg.Amount, arg.UserID)
 	var balance pgtype.Numeric
 	err := row.Scan(&balance)
 	return balance, err
 }
+
+const suspendApiKey = `-- name: SuspendApiKey :one
+UPDATE api_keys SET suspended_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL AND suspended_at IS NULL RETURNING id
+`
+
+func (q *Queries) SuspendApiKey(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, suspendApiKey, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const takeLeastRecentlyUsedNumber = `-- name: TakeLeastRecentlyUsedNumber :one
+UPDATE phone_number_pool_members SET last_used_at = CURRENT_TIMESTAMP
+WHERE pool_id = $1 AND phone_number_id = (
+    SELECT phone_number_id FROM phone_number_pool_members
+    WHERE pool_id = $1 ORDER BY last_used_at NULLS FIRST, phone_number_id LIMIT 1
+)
+RETURNING phone_number_id
+`
+
+func (q *Queries) TakeLeastRecentlyUsedNumber(ctx context.Context, poolID int32) (int32, error) {
+	row := q.db.QueryRow(ctx, takeLeastRecentlyUsedNumber, poolID)
+	var phone_number_id int32
+	err := row.Scan(&phone_number_id)
+	return phone_number_id, err
+}
+
+const takeMqttDeviceQuota = `-- name: TakeMqttDeviceQuota :one
+UPDATE mqtt_devices
+SET quota_used = CASE WHEN quota_day = CURRENT_DATE THEN quota_used + 1 ELSE 1 END,
+    quota_day = CURRENT_DATE
+WHERE id = $1 AND (daily_quota IS NULL OR quota_day IS DISTINCT FROM CURRENT_DATE OR quota_used < daily_quota)
+RETURNING quota_used
+`
+
+func (q *Queries) TakeMqttDeviceQuota(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, takeMqttDeviceQuota, id)
+	var quota_used int32
+	err := row.Scan(&quota_used)
+	return quota_used, err
+}
+
+const throttleApiKey = `-- name: ThrottleApiKey :one
+UPDATE api_keys SET throttle_limit = $1, throttled_until = $2
+WHERE id = $3 AND revoked_at IS NULL AND suspended_at IS NULL AND (throttled_until IS NULL OR throttled_until <= CURRENT_TIMESTAMP)
+RETURNING id
+`
+
+type ThrottleApiKeyParams struct {
+	ThrottleLimit  pgtype.Int4      `db:"throttle_limit" json:"throttle_limit"`
+	ThrottledUntil pgtype.Timestamp `db:"throttled_until" json:"throttled_until"`
+	ID             int32            `db:"id" json:"id"`
+}
+
+func (q *Queries) ThrottleApiKey(ctx context.Context, arg ThrottleApiKeyParams) (int32, error) {
+	row := q.db.QueryRow(ctx, throttleApiKey, arg.ThrottleLimit, arg.ThrottledUntil, arg.ID)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const touchApiKeys = `-- name: TouchApiKeys :exec
+UPDATE api_keys SET last_used_at = u.used_at
+FROM unnest($1::INT[], $2::TIMESTAMP[]) AS u (id, used_at)
+WHERE api_keys.id = u.id AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < u.used_at)
+`
+
+type TouchApiKeysParams struct {
+	Ids    []int32            `db:"ids" json:"ids"`
+	UsedAt []pgtype.Timestamp `db:"used_at" json:"used_at"`
+}
+
+func (q *Queries) TouchApiKeys(ctx context.Context, arg TouchApiKeysParams) error {
+	_, err := q.db.Exec(ctx, touchApiKeys, arg.Ids, arg.UsedAt)
+	return err
+}
+
+const touchPoolNumber = `-- name: TouchPoolNumber :exec
+UPDATE phone_number_pool_members SET last_used_at = CURRENT_TIMESTAMP WHERE pool_id = $1 AND phone_number_id = $2
+`
+
+type TouchPoolNumberParams struct {
+	PoolID        int32 `db:"pool_id" json:"pool_id"`
+	PhoneNumberID int32 `db:"phone_number_id" json:"phone_number_id"`
+}
+
+func (q *Queries) TouchPoolNumber(ctx context.Context, arg TouchPoolNumberParams) error {
+	_, err := q.db.Exec(ctx, touchPoolNumber, arg.PoolID, arg.PhoneNumberID)
+	return err
+}
+
+const updateCustomer = `-- name: UpdateCustomer :one
+UPDATE users
+SET
+    sms_price = CASE WHEN $1::boolean THEN $2 ELSE sms_price END,
+    sender = CASE WHEN $3::boolean THEN $4 ELSE sender END
+WHERE
+    id = $5
+RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type UpdateCustomerParams struct {
+	SetSmsPrice bool           `db:"set_sms_price" json:"set_sms_price"`
+	SmsPrice    pgtype.Numeric `db:"sms_price" json:"sms_price"`
+	SetSender   bool           `db:"set_sender" json:"set_sender"`
+	Sender      pgtype.Text    `db:"sender" json:"sender"`
+	ID          int32          `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateCustomer(ctx context.Context, arg UpdateCustomerParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateCustomer,
+		arg.SetSmsPrice,
+		arg.SmsPrice,
+		arg.SetSender,
+		arg.Sender,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const updateJobProgress = `-- name: UpdateJobProgress :exec
+UPDATE jobs SET progress = $1, row_count = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3
+`
+
+type UpdateJobProgressParams struct {
+	Progress int32 `db:"progress" json:"progress"`
+	RowCount int64 `db:"row_count" json:"row_count"`
+	ID       int32 `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateJobProgress(ctx context.Context, arg UpdateJobProgressParams) error {
+	_, err := q.db.Exec(ctx, updateJobProgress, arg.Progress, arg.RowCount, arg.ID)
+	return err
+}
+
+const updateProviderAccount = `-- name: UpdateProviderAccount :one
+UPDATE provider_accounts SET credentials = $2, data_key = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+RETURNING id, user_id, name, driver, credentials, data_key, created_at, updated_at, prefixes, is_default
+`
+
+type UpdateProviderAccountParams struct {
+	ID          int32  `db:"id" json:"id"`
+	Credentials []byte `db:"credentials" json:"credentials"`
+	DataKey     string `db:"data_key" json:"data_key"`
+}
+
+func (q *Queries) UpdateProviderAccount(ctx context.Context, arg UpdateProviderAccountParams) (ProviderAccount, error) {
+	row := q.db.QueryRow(ctx, updateProviderAccount, arg.ID, arg.Credentials, arg.DataKey)
+	var i ProviderAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Driver,
+		&i.Credentials,
+		&i.DataKey,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Prefixes,
+		&i.IsDefault,
+	)
+	return i, err
+}
+
+const updateReseller = `-- name: UpdateReseller :one
+UPDATE resellers
+SET
+    margin = COALESCE($1, margin),
+    sender = CASE WHEN $2::boolean THEN $3 ELSE sender END,
+    footer = CASE WHEN $4::boolean THEN $5 ELSE footer END
+WHERE
+    user_id = $6
+RETURNING user_id, margin, sender, footer
+`
+
+type UpdateResellerParams struct {
+	Margin    pgtype.Numeric `db:"margin" json:"margin"`
+	SetSender bool           `db:"set_sender" json:"set_sender"`
+	Sender    pgtype.Text    `db:"sender" json:"sender"`
+	SetFooter bool           `db:"set_footer" json:"set_footer"`
+	Footer    pgtype.Text    `db:"footer" json:"footer"`
+	UserID    int32          `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) UpdateReseller(ctx context.Context, arg UpdateResellerParams) (Reseller, error) {
+	row := q.db.QueryRow(ctx, updateReseller,
+		arg.Margin,
+		arg.SetSender,
+		arg.Sender,
+		arg.SetFooter,
+		arg.Footer,
+		arg.UserID,
+	)
+	var i Reseller
+	err := row.Scan(
+		&i.UserID,
+		&i.Margin,
+		&i.Sender,
+		&i.Footer,
+	)
+	return i, err
+}
+
+const updateSubaccount = `-- name: UpdateSubaccount :one
+UPDATE users
+SET
+    pooled = COALESCE($1, pooled),
+    monthly_quota = CASE WHEN $2::boolean THEN $3 ELSE monthly_quota END
+WHERE
+    id = $4
+RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type UpdateSubaccountParams struct {
+	Pooled          pgtype.Bool `db:"pooled" json:"pooled"`
+	SetMonthlyQuota bool        `db:"set_monthly_quota" json:"set_monthly_quota"`
+	MonthlyQuota    pgtype.Int4 `db:"monthly_quota" json:"monthly_quota"`
+	ID              int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateSubaccount(ctx context.Context, arg UpdateSubaccountParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateSubaccount,
+		arg.Pooled,
+		arg.SetMonthlyQuota,
+		arg.MonthlyQuota,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users
+SET
+    username = COALESCE($1, username),
+    footer = CASE WHEN $2::boolean THEN $3 ELSE footer END
+WHERE
+    id = $4
+RETURNING id, username, balance, footer, status, recipient_hourly_limit, parent_id, pooled, monthly_quota, reseller_id, sms_price, sender, express_admission
+`
+
+type UpdateUserParams struct {
+	Username  pgtype.Text `db:"username" json:"username"`
+	SetFooter bool        `db:"set_footer" json:"set_footer"`
+	Footer    pgtype.Text `db:"footer" json:"footer"`
+	ID        int32       `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUser,
+		arg.Username,
+		arg.SetFooter,
+		arg.Footer,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Footer,
+		&i.Status,
+		&i.RecipientHourlyLimit,
+		&i.ParentID,
+		&i.Pooled,
+		&i.MonthlyQuota,
+		&i.ResellerID,
+		&i.SmsPrice,
+		&i.Sender,
+		&i.ExpressAdmission,
+	)
+	return i, err
+}
+
+const withdrawBalance = `-- name: WithdrawBalance :one
+UPDATE users SET balance = balance - $1 WHERE id = $2 AND NOT pooled AND balance >= $1 RETURNING balance
+`
+
+type WithdrawBalanceParams struct {
+	Amount pgtype.Numeric `db:"amount" json:"amount"`
+	UserID int32          `db:"user_id" json:"user_id"`
+}
+
+// takes amount from the balance of a user that isn't pooled, if it covers it
+func (q *Queries) WithdrawBalance(ctx context.Context, arg WithdrawBalanceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, withdrawBalance, arg.Amount, arg.UserID)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
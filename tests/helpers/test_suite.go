@@ -31,7 +31,7 @@ type TestConfig struct {
 // TestSuite provides common setup and teardown for tests
 type TestSuite struct {
 	DB       *pgxpool.Pool
-	NATSConn *nats.Base
+	NATSConn *nats.Manager
 	TestDB   string
 	Cleanup  func()
 	Config   *TestConfig
@@ -118,7 +118,7 @@ func SetupTestSuite() *TestSuite {
 	natsConnRaw, err := nats.Connect(config.NATS.Address)
 	Expect(err).NotTo(HaveOccurred())
 
-	natsConn, err := nats.NewBase(natsConnRaw)
+	natsConn, err := nats.NewManager(context.Background(), natsConnRaw, nats.WithOwnedConn())
 	Expect(err).NotTo(HaveOccurred())
 
 	cleanup := func() {
@@ -192,6 +192,104 @@ func runSchemaMigrations(pool *pgxpool.Pool) error {
 		status VARCHAR(255) NOT NULL DEFAULT 'pending',
 		delivered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS quiet_hours (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users (id),
+		category VARCHAR(32) NOT NULL DEFAULT 'marketing',
+		start_time TIME NOT NULL,
+		end_time TIME NOT NULL,
+		action VARCHAR(16) NOT NULL DEFAULT 'defer'
+	);
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS category VARCHAR(32) NOT NULL DEFAULT 'transactional';
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+	CREATE INDEX IF NOT EXISTS sms_submitted_expires_at_idx ON sms (expires_at) WHERE status = 'submitted' AND expires_at IS NOT NULL;
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS footer VARCHAR(160);
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active';
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS recipient_hourly_limit INT;
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS priority VARCHAR(16) NOT NULL DEFAULT 'normal';
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS cost DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS external_id VARCHAR(64);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS sms_external_id_idx ON sms (user_id, external_id) WHERE external_id IS NOT NULL;
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+	ALTER TABLE sms ADD COLUMN IF NOT EXISTS tags TEXT[];
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users (id),
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP
+	);
+
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs CIDR[] NOT NULL DEFAULT '{}';
+
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS throttle_limit INT;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS throttled_until TIMESTAMP;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
+
+	CREATE TABLE IF NOT EXISTS sms_events (
+		id BIGSERIAL PRIMARY KEY,
+		sms_id INT NOT NULL REFERENCES sms (id) ON DELETE CASCADE,
+		event VARCHAR(16) NOT NULL,
+		actor VARCHAR(64) NOT NULL,
+		provider VARCHAR(64),
+		metadata JSONB NOT NULL DEFAULT '{}',
+		occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		request_id VARCHAR(64)
+	);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users (id),
+		kind VARCHAR(32) NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'queued',
+		params JSONB NOT NULL DEFAULT '{}',
+		progress INT NOT NULL DEFAULT 0,
+		row_count BIGINT NOT NULL DEFAULT 0,
+		result TEXT,
+		error TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS blocked_destinations (
+		id SERIAL PRIMARY KEY,
+		-- null for the global fraud deny-list
+		user_id INT REFERENCES users (id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		value VARCHAR(16) NOT NULL,
+		reason VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS blocked_destinations_rule_idx ON blocked_destinations (COALESCE(user_id, 0), kind, value);
+
+	CREATE TABLE IF NOT EXISTS workers (
+		instance_id VARCHAR(64) PRIMARY KEY,
+		hostname VARCHAR(255) NOT NULL,
+		version VARCHAR(64) NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		last_seen_at TIMESTAMP NOT NULL,
+		processed BIGINT NOT NULL DEFAULT 0,
+		lag JSONB NOT NULL DEFAULT '{}'
+	);
 	`
 
 	_, err := pool.Exec(context.Background(), schema)
@@ -203,7 +301,19 @@ func (ts *TestSuite) CleanupTestData() {
 	ctx := context.Background()
 
 	// Clean up database in reverse order of dependencies
+	ts.DB.Exec(ctx, "DELETE FROM workers")
+	ts.DB.Exec(ctx, "DELETE FROM nats_forwards")
+	ts.DB.Exec(ctx, "DELETE FROM quota_policies")
+	ts.DB.Exec(ctx, "DELETE FROM alerts")
+	ts.DB.Exec(ctx, "DELETE FROM alert_rules")
+	ts.DB.Exec(ctx, "DELETE FROM webhooks")
+	ts.DB.Exec(ctx, "DELETE FROM notifications")
 	ts.DB.Exec(ctx, "DELETE FROM sms")
+	ts.DB.Exec(ctx, "DELETE FROM quiet_hours")
+	ts.DB.Exec(ctx, "DELETE FROM blocked_destinations")
+	ts.DB.Exec(ctx, "DELETE FROM api_keys")
+	ts.DB.Exec(ctx, "DELETE FROM jobs")
+	ts.DB.Exec(ctx, "DELETE FROM phone_number_pools")
 	ts.DB.Exec(ctx, "DELETE FROM phone_numbers")
 	ts.DB.Exec(ctx, "DELETE FROM users")
 
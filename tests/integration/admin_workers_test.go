@@ -0,0 +1,130 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/heartbeats"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Admin Workers Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		adminKey  string
+	)
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+		cluster := db.NewCluster(testSuite.DB, nil)
+		viper.Set("api.workers.timeout", "1m")
+		viper.Set("api.workers.retention", "24h")
+		DeferCleanup(viper.Set, "api.workers.timeout", "")
+		DeferCleanup(viper.Set, "api.workers.retention", "")
+
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		root := router.Group("/", middlewares.Authenticate(controllers.LookupApiKey(cluster)))
+		_, err := controllers.NewAdmin(controllers.NewVersions(root), cluster, testSuite.NATSConn.Conn, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		balance := pgtype.Numeric{}
+		balance.Scan("100.00")
+		err = queries.AddUser(context.Background(), sqlc.AddUserParams{Username: "workersadmin", Balance: balance})
+		Expect(err).NotTo(HaveOccurred())
+		adminID, err := queries.GetUserId(context.Background(), "workersadmin")
+		Expect(err).NotTo(HaveOccurred())
+		adminKey, _, err = controllers.CreateKey(context.Background(), queries, adminID, "admin", []string{auth.ScopeUserAdmin}, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	record := func(id string, seen time.Time, processed uint64, lag map[string]uint64) {
+		params, err := heartbeats.Params(heartbeats.Heartbeat{
+			InstanceID: id,
+			Hostname:   "worker-" + id,
+			Version:    "v1.0.0",
+			StartedAt:  seen.Add(-time.Hour),
+			SentAt:     seen,
+			Processed:  processed,
+			Lag:        lag,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(queries.RecordWorkerHeartbeat(context.Background(), params)).To(Succeed())
+	}
+
+	type listed struct {
+		Workers []struct {
+			InstanceID string            `json:"instance_id"`
+			Alive      bool              `json:"alive"`
+			Processed  int64             `json:"processed"`
+			Lag        map[string]uint64 `json:"lag"`
+			TotalLag   uint64            `json:"total_lag"`
+		} `json:"workers"`
+		Alive int `json:"alive"`
+	}
+	list := func(path string) listed {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var body listed
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body
+	}
+
+	It("lists the workers with their liveness and lag", func() {
+		now := time.Now().UTC()
+		record("a", now, 10, map[string]uint64{"normal": 2, "express": 1})
+		record("b", now.Add(-10*time.Minute), 5, nil)
+		record("c", now.Add(-48*time.Hour), 1, nil)
+
+		body := list("/v1/admin/workers")
+		Expect(body.Alive).To(Equal(1))
+		Expect(body.Workers).To(HaveLen(2))
+		byID := map[string]bool{}
+		for _, w := range body.Workers {
+			byID[w.InstanceID] = w.Alive
+			if w.InstanceID == "a" {
+				Expect(w.Processed).To(BeEquivalentTo(10))
+				Expect(w.TotalLag).To(BeEquivalentTo(3))
+			}
+		}
+		Expect(byID).To(Equal(map[string]bool{"a": true, "b": false}))
+
+		alive := list("/v1/admin/workers?alive=true")
+		Expect(alive.Workers).To(HaveLen(1))
+		Expect(alive.Workers[0].InstanceID).To(Equal("a"))
+	})
+
+	It("keeps the latest heartbeat of a worker", func() {
+		now := time.Now().UTC()
+		record("a", now, 10, nil)
+		record("a", now.Add(-time.Minute), 3, nil)
+
+		body := list("/v1/admin/workers")
+		Expect(body.Workers).To(HaveLen(1))
+		Expect(body.Workers[0].Processed).To(BeEquivalentTo(10))
+	})
+})
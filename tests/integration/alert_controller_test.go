@@ -0,0 +1,143 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/alerts"
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/workers"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/email"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Alert Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		userID    int32
+	)
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		controllers.NewAlert(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil))
+
+		balance := pgtype.Numeric{}
+		balance.Scan("100.00")
+		err := queries.AddUser(context.Background(), sqlc.AddUserParams{
+			Username: "alertuser",
+			Balance:  balance,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		userID, err = queries.GetUserId(context.Background(), "alertuser")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	addRule := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/alerts/rules", helpers.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should add, list and delete rules", func() {
+		Expect(addRule(map[string]interface{}{"user_id": userID, "kind": "latency", "threshold": 1}).Code).To(Equal(http.StatusBadRequest))
+		Expect(addRule(map[string]interface{}{"user_id": userID, "kind": alerts.KindFailureRate, "threshold": 150}).Code).To(Equal(http.StatusBadRequest))
+
+		w := addRule(map[string]interface{}{"user_id": userID, "kind": alerts.KindSpend, "threshold": 50, "email": "ops@example.com"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var rule map[string]interface{}
+		Expect(helpers.ParseJSONResponse(w.Result(), &rule)).To(Succeed())
+		Expect(rule).To(HaveKeyWithValue("kind", alerts.KindSpend))
+		Expect(rule).To(HaveKeyWithValue("email", "ops@example.com"))
+
+		req := httptest.NewRequest("GET", "/v1/alerts/rules?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var rules []map[string]interface{}
+		Expect(helpers.ParseJSONResponse(w.Result(), &rules)).To(Succeed())
+		Expect(rules).To(HaveLen(1))
+
+		id := helpers.Int32ToString(int32(rule["id"].(float64)))
+		req = httptest.NewRequest("DELETE", "/v1/alerts/rules/"+id+"?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest("DELETE", "/v1/alerts/rules/"+id+"?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should fire a tripped rule once per cooldown and list its alert", func() {
+		Expect(addRule(map[string]interface{}{"user_id": userID, "kind": alerts.KindSpend, "threshold": 8}).Code).To(Equal(http.StatusOK))
+		viper.Set("worker.alerts.window", time.Hour)
+		viper.Set("worker.alerts.cooldown", 24*time.Hour)
+		DeferCleanup(viper.Set, "worker.alerts.window", time.Duration(0))
+		DeferCleanup(viper.Set, "worker.alerts.cooldown", time.Duration(0))
+
+		err := queries.AddPhoneNumber(context.Background(), sqlc.AddPhoneNumberParams{
+			UserID:      userID,
+			PhoneNumber: "+1234567890",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		phoneID, err := queries.GetPhoneNumberId(context.Background(), sqlc.GetPhoneNumberIdParams{
+			UserID:      userID,
+			PhoneNumber: "+1234567890",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		cost := pgtype.Numeric{}
+		cost.Scan("5.00")
+		for range 2 {
+			_, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+1111111111",
+				Message:       "Spent message",
+				Status:        "delivered",
+				Cost:          cost,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		alerter, err := workers.NewAlerter(context.Background(), testSuite.NATSConn.Conn, testSuite.DB, email.Log{})
+		Expect(err).NotTo(HaveOccurred())
+		now := time.Now().Add(time.Minute)
+		Expect(alerter.Run(context.Background(), now)).To(Succeed())
+		Expect(alerter.Run(context.Background(), now.Add(time.Minute))).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/v1/alerts?user_id="+helpers.Int32ToString(userID), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var response struct {
+			Alerts []map[string]interface{} `json:"alerts"`
+		}
+		Expect(helpers.ParseJSONResponse(w.Result(), &response)).To(Succeed())
+		Expect(response.Alerts).To(HaveLen(1))
+		Expect(response.Alerts[0]).To(HaveKeyWithValue("kind", alerts.KindSpend))
+		Expect(response.Alerts[0]["detail"]).To(ContainSubstring("10.00"))
+	})
+})
@@ -0,0 +1,105 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/health"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Coverage Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		userID    int32
+	)
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries := sqlc.New(testSuite.DB)
+
+		viper.Set("sms.provider.routes", []map[string]any{
+			{"prefix": "98", "driver": "kavenegar", "weight": 3},
+			{"prefix": "98", "driver": "vonage"},
+			{"prefix": "44", "driver": "ucp"},
+		})
+		viper.Set("sms.provider.driver", "vonage")
+		DeferCleanup(viper.Set, "sms.provider.routes", []map[string]any{})
+		DeferCleanup(viper.Set, "sms.provider.driver", "")
+
+		prober := health.NewProber(time.Second, 1)
+		prober.Add("kavenegar", func(context.Context) error { return nil })
+		prober.Add("vonage", func(context.Context) error { return errors.New("timeout") })
+		prober.Run(context.Background())
+
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		_, err := controllers.NewCoverage(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil), prober)
+		Expect(err).NotTo(HaveOccurred())
+
+		balance := pgtype.Numeric{}
+		balance.Scan("100.00")
+		err = queries.AddUser(context.Background(), sqlc.AddUserParams{
+			Username: "coverageuser",
+			Balance:  balance,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		userID, err = queries.GetUserId(context.Background(), "coverageuser")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	It("should list the routed countries with their senders and provider health", func() {
+		req := httptest.NewRequest("GET", "/v1/coverage?user_id="+helpers.Int32ToString(userID), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var response struct {
+			Countries []map[string]interface{} `json:"countries"`
+			Default   map[string]interface{}   `json:"default"`
+		}
+		err := helpers.ParseJSONResponse(w.Result(), &response)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Countries).To(HaveLen(2))
+
+		iran := response.Countries[1]
+		Expect(iran).To(HaveKeyWithValue("prefix", "98"))
+		Expect(iran).To(HaveKeyWithValue("price", "5.00"))
+		Expect(iran).To(HaveKeyWithValue("sender_types", ConsistOf("numeric")))
+		Expect(iran).To(HaveKeyWithValue("two_way", false))
+		Expect(iran["providers"]).To(ConsistOf(
+			map[string]interface{}{"name": "kavenegar", "status": "up"},
+			map[string]interface{}{"name": "vonage", "status": "down"},
+		))
+
+		Expect(response.Countries[0]["providers"]).To(ConsistOf(
+			map[string]interface{}{"name": "ucp", "status": "unknown"},
+		))
+		Expect(response.Default).To(HaveKeyWithValue("sender_types", ConsistOf("numeric", "alphanumeric")))
+	})
+
+	It("should return 404 for the prices of an unknown user", func() {
+		req := httptest.NewRequest("GET", "/v1/coverage?user_id=999999", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,113 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/forwards"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/secrets"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Forward Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		envelope  *secrets.Envelope
+		userID    int32
+	)
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+
+		keys, err := secrets.NewLocalKeys("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		envelope = secrets.NewEnvelope(keys)
+
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		controllers.NewForward(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil), envelope)
+
+		balance := pgtype.Numeric{}
+		balance.Scan("100.00")
+		err = queries.AddUser(context.Background(), sqlc.AddUserParams{
+			Username: "forwarduser",
+			Balance:  balance,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		userID, err = queries.GetUserId(context.Background(), "forwarduser")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	setForward := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/v1/forwards", helpers.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should set up, show and delete the target without its secrets", func() {
+		Expect(setForward(map[string]interface{}{"user_id": userID, "url": "http://nats.example.com", "subject": "acme.dlr"}).Code).To(Equal(http.StatusBadRequest))
+		Expect(setForward(map[string]interface{}{"user_id": userID, "url": "nats://nats.example.com", "subject": "acme.*"}).Code).To(Equal(http.StatusBadRequest))
+
+		w := setForward(map[string]interface{}{
+			"user_id":     userID,
+			"url":         "nats://nats.example.com:4222",
+			"subject":     "acme.dlr",
+			"jetstream":   true,
+			"credentials": map[string]string{"user": "acme", "password": "s3cret"},
+		})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("s3cret"))
+		var view map[string]interface{}
+		Expect(helpers.ParseJSONResponse(w.Result(), &view)).To(Succeed())
+		Expect(view).To(HaveKeyWithValue("auth", forwards.AuthPassword))
+		Expect(view).To(HaveKeyWithValue("user", "acme"))
+		Expect(view).To(HaveKeyWithValue("jetstream", true))
+
+		fwd, err := queries.GetNatsForward(context.Background(), userID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(fwd.Credentials)).NotTo(ContainSubstring("s3cret"))
+		target, err := forwards.Open(context.Background(), envelope, fwd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Credentials.Password).To(Equal("s3cret"))
+
+		req := httptest.NewRequest("GET", "/v1/forwards?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("s3cret"))
+
+		req = httptest.NewRequest("DELETE", "/v1/forwards?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest("GET", "/v1/forwards?user_id="+helpers.Int32ToString(userID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should refuse targets of unknown users", func() {
+		w := setForward(map[string]interface{}{"user_id": userID + 1000, "url": "nats://nats.example.com", "subject": "acme.dlr"})
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
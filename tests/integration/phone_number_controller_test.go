@@ -0,0 +1,141 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Phone Number Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		alice     int32
+		bob       int32
+	)
+
+	newRouter := func() {
+		router = gin.New()
+		versions := controllers.NewVersions(router.Group("/"))
+		_ = controllers.NewPhoneNumber(versions, db.NewCluster(testSuite.DB, nil))
+		_ = controllers.NewPhoneNumberPool(versions, db.NewCluster(testSuite.DB, nil))
+	}
+
+	add := func(userID int32, number string) int {
+		req := httptest.NewRequest("POST", "/v1/phone-number", helpers.JSONBody(map[string]interface{}{
+			"user_id":      userID,
+			"phone_number": number,
+		}))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+		gin.SetMode(gin.TestMode)
+
+		for _, username := range []string{"alice", "bob"} {
+			Expect(queries.AddUser(context.Background(), sqlc.AddUserParams{
+				Username: username,
+				Balance:  pgtype.Numeric{},
+			})).To(Succeed())
+		}
+		var err error
+		alice, err = queries.GetUserId(context.Background(), "alice")
+		Expect(err).NotTo(HaveOccurred())
+		bob, err = queries.GetUserId(context.Background(), "bob")
+		Expect(err).NotTo(HaveOccurred())
+		newRouter()
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	It("should refuse a number another user registered by default", func() {
+		Expect(add(alice, "12345")).To(Equal(http.StatusOK))
+		Expect(add(bob, "12345")).To(Equal(http.StatusConflict))
+		Expect(add(alice, "12345")).To(Equal(http.StatusConflict))
+	})
+
+	It("should let users share a number when it is unique per user", func() {
+		viper.Set("api.phonenumbers.unique", controllers.PhoneNumbersUniquePerUser)
+		DeferCleanup(viper.Set, "api.phonenumbers.unique", "global")
+		newRouter()
+
+		Expect(add(alice, "12345")).To(Equal(http.StatusOK))
+		Expect(add(bob, "12345")).To(Equal(http.StatusOK))
+		Expect(add(alice, "12345")).To(Equal(http.StatusConflict))
+
+		id, err := queries.GetPhoneNumberId(context.Background(), sqlc.GetPhoneNumberIdParams{UserID: bob, PhoneNumber: "12345"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).To(BeNumerically(">", 0))
+	})
+
+	Context("Pools", func() {
+		request := func(method, path string, body map[string]interface{}) (int, map[string]interface{}) {
+			req := httptest.NewRequest(method, path, helpers.JSONBody(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var response map[string]interface{}
+			_ = helpers.ParseJSONResponse(w.Result(), &response)
+			return w.Code, response
+		}
+		numberOf := func(userID int32, number string) int32 {
+			Expect(add(userID, number)).To(Equal(http.StatusOK))
+			id, err := queries.GetPhoneNumberId(context.Background(), sqlc.GetPhoneNumberIdParams{UserID: userID, PhoneNumber: number})
+			Expect(err).NotTo(HaveOccurred())
+			return id
+		}
+
+		It("should only pool numbers of the user of the pool", func() {
+			code, pool := request("POST", "/v1/phone-number/pools", map[string]interface{}{"user_id": alice, "name": "marketing"})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(pool["strategy"]).To(Equal(policy.PoolRoundRobin))
+			path := "/v1/phone-number/pools/" + helpers.Int32ToString(int32(pool["id"].(float64)))
+
+			code, _ = request("POST", "/v1/phone-number/pools", map[string]interface{}{"user_id": alice, "name": "marketing"})
+			Expect(code).To(Equal(http.StatusConflict))
+			code, _ = request("POST", "/v1/phone-number/pools", map[string]interface{}{"user_id": alice, "name": "otp", "strategy": "random"})
+			Expect(code).To(Equal(http.StatusBadRequest))
+
+			own := numberOf(alice, "11111")
+			code, _ = request("POST", path+"/numbers", map[string]interface{}{"phone_number_id": own})
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = request("POST", path+"/numbers", map[string]interface{}{"phone_number_id": own})
+			Expect(code).To(Equal(http.StatusConflict))
+			code, _ = request("POST", path+"/numbers", map[string]interface{}{"phone_number_id": numberOf(bob, "22222")})
+			Expect(code).To(Equal(http.StatusNotFound))
+
+			code, pool = request("PUT", path, map[string]interface{}{"strategy": policy.PoolSticky})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(pool["strategy"]).To(Equal(policy.PoolSticky))
+
+			code, pool = request("GET", path, nil)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(pool["numbers"]).To(HaveLen(1))
+
+			code, _ = request("DELETE", path+"/numbers/"+helpers.Int32ToString(own), nil)
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = request("DELETE", path, nil)
+			Expect(code).To(Equal(http.StatusOK))
+		})
+	})
+})
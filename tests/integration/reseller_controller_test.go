@@ -0,0 +1,135 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reseller Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		ctx       = context.Background()
+	)
+
+	do := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, helpers.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var res map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		_ = controllers.NewReseller(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil))
+
+		for _, username := range []string{"acme", "other"} {
+			Expect(queries.AddUser(ctx, sqlc.AddUserParams{
+				Username: username,
+				Balance:  billing.Amount(10000),
+			})).To(Succeed())
+		}
+		id, err := queries.GetUserId(ctx, "acme")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = queries.AddReseller(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.DB.Exec(ctx, "DELETE FROM resellers")
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	It("should price customers with the margin of their reseller unless it set a price", func() {
+		code, _ := do("PATCH", "/v1/reseller/acme", map[string]interface{}{"margin": 20})
+		Expect(code).To(Equal(http.StatusOK))
+
+		code, customer := do("POST", "/v1/reseller/acme/customers", map[string]interface{}{"username": "bakery"})
+		Expect(code).To(Equal(http.StatusCreated))
+		id := int32(customer["id"].(float64))
+
+		price, err := queries.GetSmsPrice(ctx, sqlc.GetSmsPriceParams{Cost: billing.Amount(500), UserID: id})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(price)).To(Equal(int64(600)))
+
+		code, customer = do("PATCH", "/v1/reseller/acme/customers/bakery", map[string]interface{}{"sms_price": "4.25"})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(customer["sms_price"]).To(Equal("4.25"))
+		price, err = queries.GetSmsPrice(ctx, sqlc.GetSmsPriceParams{Cost: billing.Amount(500), UserID: id})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(price)).To(Equal(int64(425)))
+	})
+
+	It("should fund customers out of the balance of the reseller", func() {
+		code, _ := do("POST", "/v1/reseller/acme/customers", map[string]interface{}{"username": "bakery"})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		code, res := do("POST", "/v1/reseller/acme/customers/bakery/balance", map[string]interface{}{"amount": "40.00"})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res["reseller_balance"]).To(Equal("60.00"))
+		Expect(res["balance"]).To(Equal("40.00"))
+
+		code, _ = do("POST", "/v1/reseller/acme/customers/bakery/balance", map[string]interface{}{"amount": "100.00"})
+		Expect(code).To(Equal(http.StatusConflict))
+
+		code, _ = do("POST", "/v1/reseller/acme/customers/bakery/balance", map[string]interface{}{"amount": "1e17"})
+		Expect(code).To(Equal(http.StatusBadRequest))
+		code, _ = do("POST", "/v1/reseller/acme/customers/bakery/balance", map[string]interface{}{"amount": "0.005"})
+		Expect(code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should only take positive prices in cents", func() {
+		code, _ := do("POST", "/v1/reseller/acme/customers", map[string]interface{}{"username": "bakery"})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		for _, price := range []string{"0", "-1.00", "4.255", "1e17"} {
+			code, _ = do("PATCH", "/v1/reseller/acme/customers/bakery", map[string]interface{}{"sms_price": price})
+			Expect(code).To(Equal(http.StatusBadRequest), price)
+		}
+	})
+
+	It("should only manage its own customers", func() {
+		code, _ := do("GET", "/v1/reseller/other/customers", nil)
+		Expect(code).To(Equal(http.StatusForbidden))
+
+		code, _ = do("PATCH", "/v1/reseller/acme/customers/other", map[string]interface{}{"sender": "ACME"})
+		Expect(code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should not hand out admin scopes to customers", func() {
+		code, _ := do("POST", "/v1/reseller/acme/customers", map[string]interface{}{"username": "bakery"})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		code, _ = do("POST", "/v1/reseller/acme/customers/bakery/keys", map[string]interface{}{
+			"name":   "prod",
+			"scopes": []string{"sms:send", "user:admin"},
+		})
+		Expect(code).To(Equal(http.StatusBadRequest))
+
+		code, res := do("POST", "/v1/reseller/acme/customers/bakery/keys", map[string]interface{}{
+			"name":   "prod",
+			"scopes": []string{"sms:send"},
+		})
+		Expect(code).To(Equal(http.StatusCreated))
+		Expect(res["key"]).NotTo(BeEmpty())
+	})
+})
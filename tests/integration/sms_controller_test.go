@@ -1,26 +1,44 @@
 package integration_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/internal/policy"
+	"github.com/alireza-karampour/sms/internal/streams"
+	"github.com/alireza-karampour/sms/internal/webhooks"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/carrier"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/pkg/status"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/alireza-karampour/sms/tests/helpers"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
 )
 
 var _ = Describe("SMS Controller Integration Tests", func() {
 	var (
-		testSuite *helpers.TestSuite
-		router    *gin.Engine
-		queries   *sqlc.Queries
-		userID    int32
-		phoneID   int32
+		testSuite     *helpers.TestSuite
+		router        *gin.Engine
+		queries       *sqlc.Queries
+		smsController *controllers.Sms
+		userID        int32
+		phoneID       int32
 	)
 
 	BeforeEach(func() {
@@ -33,7 +51,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		// Create SMS controller
 		var err error
-		_, err = controllers.NewSms(router.Group("/"), testSuite.DB, testSuite.NATSConn.Conn)
+		smsController, err = controllers.NewSms(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn)
 		Expect(err).NotTo(HaveOccurred())
 
 		// Create test user and phone number
@@ -69,7 +87,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 	Context("SMS Sending", func() {
 		It("should send normal SMS successfully", func() {
 			// Create HTTP request
-			req := httptest.NewRequest("POST", "/sms",
+			req := httptest.NewRequest("POST", "/v1/sms",
 				helpers.JSONBody(map[string]interface{}{
 					"user_id":         userID,
 					"phone_number_id": phoneID,
@@ -92,11 +110,21 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			err := helpers.ParseJSONResponse(w.Result(), &response)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(response["msg"]).To(Equal("OK"))
+			Expect(response).To(HaveKey("remaining_balance"))
+			messageID, err := uuid.Parse(response["message_id"].(string))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messageID.Version()).To(BeEquivalentTo(7))
+			Expect(response["priority"]).To(Equal("normal"))
+			Expect(response["estimated_cost"]).To(Equal("5.00"))
+			Expect(response["queue_position"]).To(BeEquivalentTo(1))
+			dispatchAt, err := time.Parse(time.RFC3339, response["estimated_dispatch_at"].(string))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dispatchAt).To(BeTemporally("~", time.Now(), time.Minute))
 		})
 
 		It("should send express SMS successfully", func() {
 			// Create HTTP request with express query parameter
-			req := httptest.NewRequest("POST", "/sms?express=true",
+			req := httptest.NewRequest("POST", "/v1/sms?express=true",
 				helpers.JSONBody(map[string]interface{}{
 					"user_id":         userID,
 					"phone_number_id": phoneID,
@@ -119,6 +147,72 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			err := helpers.ParseJSONResponse(w.Result(), &response)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(response["msg"]).To(Equal("OK"))
+			Expect(response["priority"]).To(Equal("express"))
+		})
+
+		It("should honor the express flag along with the body's options", func() {
+			req := httptest.NewRequest("POST", "/v1/sms?express=true",
+				helpers.JSONBody(map[string]interface{}{
+					"channel":         "sms",
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Express SMS with validity",
+					"validity_period": 60,
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			Expect(helpers.ParseJSONResponse(w.Result(), &response)).To(Succeed())
+			Expect(response["priority"]).To(Equal("express"))
+		})
+
+		It("should reject an invalid express flag", func() {
+			req := httptest.NewRequest("POST", "/v1/sms?express=maybe",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Express SMS message",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should not fill body fields from the query", func() {
+			req := httptest.NewRequest("POST", "/v1/sms?message=hi&Message=hi",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should not fill query fields from the body", func() {
+			list := func(path string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", path, helpers.JSONBody(map[string]interface{}{
+					"UserID": userID,
+					"userid": userID,
+					"Limit":  1,
+				}))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w
+			}
+			Expect(list("/v1/sms").Code).To(Equal(http.StatusBadRequest))
+			Expect(list(fmt.Sprintf("/v1/sms?user_id=%d", userID)).Code).To(Equal(http.StatusOK))
 		})
 
 		It("should fail to send SMS with insufficient balance", func() {
@@ -153,7 +247,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			AddReportEntry("UserBalance: ", string(val))
 
 			// Create HTTP request
-			req := httptest.NewRequest("POST", "/sms",
+			req := httptest.NewRequest("POST", "/v1/sms",
 				helpers.JSONBody(map[string]interface{}{
 					"user_id":         lowBalanceUserID,
 					"phone_number_id": lowBalancePhoneID,
@@ -172,9 +266,31 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			Expect(w.Code).To(Equal(http.StatusForbidden))
 		})
 
+		It("should refuse sends of suspended users", func() {
+			_, err := queries.SetUserStatus(context.Background(), sqlc.SetUserStatusParams{
+				Status: policy.AccountSuspended,
+				ID:     userID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Test SMS message",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+			Expect(w.Body.String()).To(ContainSubstring(policy.ErrAccountSuspended.Error()))
+		})
+
 		It("should fail with invalid JSON", func() {
 			// Create HTTP request with invalid JSON
-			req := httptest.NewRequest("POST", "/sms",
+			req := httptest.NewRequest("POST", "/v1/sms",
 				helpers.JSONBody("invalid json"))
 			req.Header.Set("Content-Type", "application/json")
 
@@ -190,7 +306,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		It("should fail with missing required fields", func() {
 			// Create HTTP request with missing fields
-			req := httptest.NewRequest("POST", "/sms",
+			req := httptest.NewRequest("POST", "/v1/sms",
 				helpers.JSONBody(map[string]interface{}{
 					"user_id": userID,
 					// Missing phone_number_id, to_phone_number, message
@@ -215,7 +331,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			// Send SMS
-			req := httptest.NewRequest("POST", "/sms",
+			req := httptest.NewRequest("POST", "/v1/sms",
 				helpers.JSONBody(map[string]interface{}{
 					"user_id":         userID,
 					"phone_number_id": phoneID,
@@ -241,7 +357,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 	Context("SMS Retrieval", func() {
 		BeforeEach(func() {
 			// Add some test SMS messages to the database
-			err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+			_, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
 				UserID:        userID,
 				PhoneNumberID: phoneID,
 				ToPhoneNumber: "+1111111111",
@@ -250,7 +366,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+			_, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
 				UserID:        userID,
 				PhoneNumberID: phoneID,
 				ToPhoneNumber: "+2222222222",
@@ -259,7 +375,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+			_, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
 				UserID:        userID,
 				PhoneNumberID: phoneID,
 				ToPhoneNumber: "+3333333333",
@@ -271,7 +387,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		It("should retrieve SMS messages for a user", func() {
 			// Create HTTP request
-			req := httptest.NewRequest("GET", "/sms?user_id="+helpers.Int32ToString(userID), nil)
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID), nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -303,9 +419,35 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			Expect(firstMessage["to_phone_number"]).To(Equal("+3333333333"))
 		})
 
+		It("should mask destinations and bodies without the full read scope", func() {
+			r := gin.New()
+			r.Use(middlewares.Authenticate(nil))
+			_, err := controllers.NewSms(controllers.NewVersions(r.Group("/")), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn)
+			Expect(err).NotTo(HaveOccurred())
+
+			list := func(scopes ...string) map[string]interface{} {
+				ctx := middlewares.WithPrincipal(context.Background(), &auth.Principal{UserID: userID, Scopes: scopes})
+				req := httptest.NewRequestWithContext(ctx, "GET", "/v1/sms?user_id="+helpers.Int32ToString(userID), nil)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				var response map[string]interface{}
+				Expect(helpers.ParseJSONResponse(w.Result(), &response)).To(Succeed())
+				return response["messages"].([]interface{})[0].(map[string]interface{})
+			}
+
+			message := list(auth.ScopeSmsRead)
+			Expect(message["to_phone_number"]).To(Equal("+33••••3333"))
+			Expect(message["message"]).To(Equal("[redacted 18 chars]"))
+
+			message = list(auth.ScopeSmsRead, auth.ScopeSmsReadFull)
+			Expect(message["to_phone_number"]).To(Equal("+3333333333"))
+			Expect(message["message"]).To(Equal("Third test message"))
+		})
+
 		It("should respect limit parameter", func() {
 			// Create HTTP request with limit
-			req := httptest.NewRequest("GET", "/sms?user_id="+helpers.Int32ToString(userID)+"&limit=2", nil)
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID)+"&limit=2", nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -330,7 +472,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		It("should use default limit when not provided", func() {
 			// Create HTTP request without limit
-			req := httptest.NewRequest("GET", "/sms?user_id="+helpers.Int32ToString(userID), nil)
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID), nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -356,7 +498,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		It("should enforce maximum limit", func() {
 			// Create HTTP request with limit exceeding maximum
-			req := httptest.NewRequest("GET", "/sms?user_id="+helpers.Int32ToString(userID)+"&limit=200", nil)
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID)+"&limit=200", nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -382,7 +524,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 
 		It("should fail with missing user_id", func() {
 			// Create HTTP request without user_id
-			req := httptest.NewRequest("GET", "/sms", nil)
+			req := httptest.NewRequest("GET", "/v1/sms", nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -408,7 +550,7 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			// Create HTTP request for user with no messages
-			req := httptest.NewRequest("GET", "/sms?user_id="+helpers.Int32ToString(emptyUserID), nil)
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(emptyUserID), nil)
 
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -431,4 +573,838 @@ var _ = Describe("SMS Controller Integration Tests", func() {
 			Expect(count).To(Equal(float64(0)))
 		})
 	})
+
+	Context("SMS Cancellation", func() {
+		var pendingID, deliveredID int32
+		messageID := uuid.Must(uuid.NewV7())
+
+		BeforeEach(func() {
+			cost := pgtype.Numeric{}
+			cost.Scan("5.00")
+			var err error
+			pendingID, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+1111111111",
+				Message:       "Pending message",
+				Status:        "pending",
+				Cost:          cost,
+				MessageID:     pgtype.UUID{Bytes: messageID, Valid: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			deliveredID, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+2222222222",
+				Message:       "Delivered message",
+				Status:        "delivered",
+				Cost:          cost,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should cancel a pending SMS and refund it", func() {
+			req := httptest.NewRequest("DELETE", "/v1/sms/"+helpers.Int32ToString(pendingID), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["refunded"]).To(Equal("5.00"))
+
+			st, err := queries.GetSmsStatusForUpdate(context.Background(), pendingID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st).To(Equal("cancelled"))
+			balance, err := queries.GetBalance(context.Background(), userID)
+			Expect(err).NotTo(HaveOccurred())
+			f, _ := balance.Float64Value()
+			Expect(f.Float64).To(Equal(105.0))
+		})
+
+		It("should cancel an SMS by its message_id", func() {
+			req := httptest.NewRequest("DELETE", "/v1/sms/"+messageID.String(), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			st, err := queries.GetSmsStatusForUpdate(context.Background(), pendingID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(st).To(Equal("cancelled"))
+
+			req = httptest.NewRequest("DELETE", "/v1/sms/"+uuid.Must(uuid.NewV7()).String(), nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should refuse to cancel an SMS that isn't pending", func() {
+			req := httptest.NewRequest("DELETE", "/v1/sms/"+helpers.Int32ToString(deliveredID), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should return 404 for unknown SMS", func() {
+			req := httptest.NewRequest("DELETE", "/v1/sms/999999", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("External IDs", func() {
+		send := func(externalID string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Order " + externalID + " shipped",
+					"external_id":     externalID,
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		BeforeEach(func() {
+			_, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+1111111111",
+				Message:       "Order 41 shipped",
+				Status:        "delivered",
+				ExternalID:    pgtype.Text{String: "order-41", Valid: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse an external_id the user sent before", func() {
+			Expect(send("order-41").Code).To(Equal(http.StatusConflict))
+			Expect(send("order-42").Code).To(Equal(http.StatusOK))
+			// accepted but not stored yet, caught by the publish
+			Expect(send("order-42").Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should look messages up by external_id", func() {
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID)+"&external_id=order-41", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["count"]).To(Equal(float64(1)))
+			message := response["messages"].([]interface{})[0].(map[string]interface{})
+			Expect(message["external_id"]).To(Equal("order-41"))
+
+			req = httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID)+"&external_id=order-99", nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			err = helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["count"]).To(Equal(float64(0)))
+		})
+	})
+
+	Context("Tags", func() {
+		BeforeEach(func() {
+			cost := pgtype.Numeric{}
+			cost.Scan("5.00")
+			for i, tags := range [][]string{{"order-confirmation"}, {"order-confirmation", "eu"}, nil} {
+				_, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+					UserID:        userID,
+					PhoneNumberID: phoneID,
+					ToPhoneNumber: "+1111111111",
+					Message:       "Message " + helpers.Int32ToString(int32(i)),
+					Status:        "delivered",
+					Cost:          cost,
+					Metadata:      json.RawMessage(`{"order": "41"}`),
+					Tags:          tags,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("should list the messages carrying a tag", func() {
+			req := httptest.NewRequest("GET", "/v1/sms?user_id="+helpers.Int32ToString(userID)+"&tag=eu", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["count"]).To(Equal(float64(1)))
+			message := response["messages"].([]interface{})[0].(map[string]interface{})
+			Expect(message["tags"]).To(ConsistOf("order-confirmation", "eu"))
+			Expect(message["metadata"]).To(Equal(map[string]interface{}{"order": "41"}))
+		})
+
+		It("should report usage per tag", func() {
+			req := httptest.NewRequest("GET", "/v1/sms/usage?user_id="+helpers.Int32ToString(userID)+"&from=2000-01-01T00:00:00Z", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response struct {
+				Tags []map[string]interface{} `json:"tags"`
+			}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Tags).To(HaveLen(2))
+			Expect(response.Tags[0]).To(HaveKeyWithValue("tag", "order-confirmation"))
+			Expect(response.Tags[0]).To(HaveKeyWithValue("messages", float64(2)))
+			Expect(response.Tags[0]).To(HaveKeyWithValue("delivered", float64(2)))
+			Expect(response.Tags[0]).To(HaveKeyWithValue("cost", "10.00"))
+			Expect(response.Tags[1]).To(HaveKeyWithValue("tag", "eu"))
+		})
+
+		It("should count the failures by their normalized reason", func() {
+			for _, reason := range []string{status.ReasonInvalidNumber, status.ReasonInvalidNumber, ""} {
+				id, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+					UserID:        userID,
+					PhoneNumberID: phoneID,
+					ToPhoneNumber: "+1111111111",
+					Message:       "Failed message",
+					Status:        "failed",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				err = queries.SetSmsStatus(context.Background(), sqlc.SetSmsStatusParams{
+					Status:        "failed",
+					ID:            id,
+					FailureReason: pgtype.Text{String: reason, Valid: reason != ""},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			req := httptest.NewRequest("GET", "/v1/sms/usage?user_id="+helpers.Int32ToString(userID)+"&from=2000-01-01T00:00:00Z", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response struct {
+				Failures map[string]int64 `json:"failures"`
+			}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Failures).To(Equal(map[string]int64{
+				status.ReasonInvalidNumber: 2,
+				status.ReasonUnknown:       1,
+			}))
+		})
+
+		It("should refuse too many tags", func() {
+			tags := make([]string, 11)
+			for i := range tags {
+				tags[i] = "tag-" + helpers.Int32ToString(int32(i))
+			}
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Tagged",
+					"tags":            tags,
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("Twilio Compatibility", func() {
+		messages := "/twilio/2010-04-01/Accounts/smstestuser/Messages"
+
+		create := func(form url.Values) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", messages+".json", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		BeforeEach(func() {
+			controllers.NewTwilio(router.Group("/"), smsController, db.NewCluster(testSuite.DB, nil))
+		})
+
+		It("should queue a message sent the Twilio way", func() {
+			w := create(url.Values{"To": {"+0987654321"}, "From": {"+1234567890"}, "Body": {"Hello"}})
+
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["sid"]).To(HavePrefix("SM"))
+			Expect(response["account_sid"]).To(Equal("smstestuser"))
+			Expect(response["status"]).To(Equal("queued"))
+			Expect(response["num_segments"]).To(Equal("1"))
+			Expect(response["uri"]).To(Equal("/2010-04-01/Accounts/smstestuser/Messages/" + response["sid"].(string) + ".json"))
+		})
+
+		It("should answer errors with Twilio codes", func() {
+			w := create(url.Values{"To": {"+0987654321"}, "From": {"+1999999999"}, "Body": {"Hello"}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["code"]).To(Equal(float64(21606)))
+			Expect(response["more_info"]).To(Equal("https://www.twilio.com/docs/errors/21606"))
+
+			w = create(url.Values{"To": {"+0987654321"}, "From": {"+1234567890"}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			err = helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["code"]).To(Equal(float64(21602)))
+
+			req := httptest.NewRequest("GET", messages+"/SM00000000000000000000000000000000.json", nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+			err = helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["code"]).To(Equal(float64(20404)))
+		})
+	})
+
+	Context("Recipient Flood Limit", func() {
+		send := func(to string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": to,
+					"message":         "Flood test",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		BeforeEach(func() {
+			_, err := queries.SetRecipientLimit(context.Background(), sqlc.SetRecipientLimitParams{
+				RecipientHourlyLimit: pgtype.Int4{Int32: 2, Valid: true},
+				ID:                   userID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse messages to a number over the user's limit", func() {
+			Expect(send("+0987654321").Code).To(Equal(http.StatusOK))
+			Expect(send("+098 765 4321").Code).To(Equal(http.StatusOK))
+
+			w := send("+0987654321")
+			Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(w.Header().Get("Retry-After")).NotTo(BeEmpty())
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["code"]).To(Equal(controllers.CodeRecipientFlood))
+
+			Expect(send("+1122334455").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Rate Quota", func() {
+		send := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Quota test",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		BeforeEach(func() {
+			_, err := queries.SetQuotaPolicy(context.Background(), sqlc.SetQuotaPolicyParams{
+				Priority:        "normal",
+				SmsLimit:        2,
+				WindowSeconds:   3600,
+				BurstCredits:    1,
+				RechargeSeconds: 3600,
+				UserID:          userID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should spend the burst credits over the limit and then refuse", func() {
+			Expect(send().Header().Get(controllers.HeaderBurstCredits)).To(Equal("1"))
+			Expect(send().Code).To(Equal(http.StatusOK))
+
+			w := send()
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get(controllers.HeaderBurstCredits)).To(Equal("0"))
+
+			w = send()
+			Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(w.Header().Get("Retry-After")).NotTo(BeEmpty())
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["code"]).To(Equal(controllers.CodeRateQuotaExceeded))
+		})
+
+		It("should not limit the other priority", func() {
+			for range 3 {
+				send()
+			}
+			req := httptest.NewRequest("POST", "/v1/sms?express=true",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": "+0987654321",
+					"message":         "Quota test",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get(controllers.HeaderBurstCredits)).To(BeEmpty())
+		})
+	})
+
+	Context("Express Admission", func() {
+		send := func(body map[string]interface{}) (int, map[string]interface{}) {
+			body["user_id"] = userID
+			body["phone_number_id"] = phoneID
+			body["to_phone_number"] = "+0987654321"
+			body["message"] = "Express admission test"
+			req := httptest.NewRequest("POST", "/v1/sms?express=true", helpers.JSONBody(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var response map[string]interface{}
+			_ = helpers.ParseJSONResponse(w.Result(), &response)
+			return w.Code, response
+		}
+
+		BeforeEach(func() {
+			viper.Set("api.express.admission.policy", policy.AdmissionReject)
+			viper.Set("api.express.admission.backlog", 1)
+			DeferCleanup(viper.Set, "api.express.admission.policy", policy.AdmissionOff)
+			DeferCleanup(viper.Set, "api.express.admission.backlog", 0)
+
+			router = gin.New()
+			var err error
+			smsController, err = controllers.NewSms(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn)
+			Expect(err).NotTo(HaveOccurred())
+			smsController.Depth = streams.NewDepth(smsController.Streams())
+		})
+
+		It("should refuse express sms while the express queue is backed up, or downgrade them with consent", func() {
+			for range 2 {
+				code, _ := send(map[string]interface{}{})
+				Expect(code).To(Equal(http.StatusOK))
+			}
+			Expect(smsController.Depth.Refresh(context.Background())).To(Succeed())
+
+			code, response := send(map[string]interface{}{})
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(response["code"]).To(Equal(controllers.CodeExpressBackedUp))
+
+			code, response = send(map[string]interface{}{"allow_downgrade": true})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["priority"]).To(Equal("normal"))
+			Expect(response["downgraded"]).To(BeTrue())
+		})
+
+		It("should apply the policy of the user over the default", func() {
+			_, err := queries.SetExpressAdmission(context.Background(), sqlc.SetExpressAdmissionParams{
+				ExpressAdmission: pgtype.Text{String: policy.AdmissionDowngrade, Valid: true},
+				ID:               userID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			for range 2 {
+				code, _ := send(map[string]interface{}{})
+				Expect(code).To(Equal(http.StatusOK))
+			}
+			Expect(smsController.Depth.Refresh(context.Background())).To(Succeed())
+
+			code, response := send(map[string]interface{}{})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["priority"]).To(Equal("normal"))
+		})
+	})
+
+	Context("Deduplication", func() {
+		send := func(path, message string) (int, map[string]interface{}) {
+			req := httptest.NewRequest("POST", path, helpers.JSONBody(map[string]interface{}{
+				"user_id":         userID,
+				"phone_number_id": phoneID,
+				"to_phone_number": "+0987654321",
+				"message":         message,
+			}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var response map[string]interface{}
+			_ = helpers.ParseJSONResponse(w.Result(), &response)
+			return w.Code, response
+		}
+
+		bind := func() {
+			router = gin.New()
+			var err error
+			smsController, err = controllers.NewSms(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			viper.Set("sms.dedupe.enabled", true)
+			viper.Set("sms.dedupe.window", "2m")
+			viper.Set("sms.dedupe.bucket", "sms_dedupe")
+			DeferCleanup(viper.Set, "sms.dedupe.enabled", false)
+			DeferCleanup(viper.Set, "sms.dedupe.mode", "reject")
+			DeferCleanup(viper.Set, "sms.dedupe.window", "2m")
+			bind()
+		})
+
+		It("should refuse an identical sms within the window", func() {
+			message := fmt.Sprintf("Dedupe reject %d", time.Now().UnixNano())
+			code, _ := send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusConflict))
+		})
+
+		It("should answer an identical sms as a duplicate in indicate mode", func() {
+			viper.Set("sms.dedupe.mode", "indicate")
+			message := fmt.Sprintf("Dedupe indicate %d", time.Now().UnixNano())
+			code, response := send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response).NotTo(HaveKey("duplicate"))
+			code, response = send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["duplicate"]).To(BeTrue())
+		})
+
+		It("should refuse an identical sms sent with the other priority", func() {
+			message := fmt.Sprintf("Dedupe priorities %d", time.Now().UnixNano())
+			code, response := send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["priority"]).To(Equal("normal"))
+			code, _ = send("/v1/sms?express=true", message)
+			Expect(code).To(Equal(http.StatusConflict))
+		})
+
+		It("should send an identical sms again once the window passed", func() {
+			viper.Set("sms.dedupe.window", "1s")
+			bind()
+			message := fmt.Sprintf("Dedupe window %d", time.Now().UnixNano())
+			code, _ := send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = send("/v1/sms", message)
+			Expect(code).To(Equal(http.StatusConflict))
+			Eventually(func() int {
+				code, _ := send("/v1/sms", message)
+				return code
+			}, 5*time.Second, 250*time.Millisecond).Should(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Provider Override", func() {
+		send := func(provider string, scopes ...string) int {
+			r := gin.New()
+			r.Use(middlewares.Authenticate(nil))
+			_, err := controllers.NewSms(controllers.NewVersions(r.Group("/")), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx := middlewares.WithPrincipal(context.Background(), &auth.Principal{UserID: userID, Scopes: scopes})
+			req := httptest.NewRequestWithContext(ctx, "POST", "/v1/sms", helpers.JSONBody(map[string]interface{}{
+				"user_id":         userID,
+				"phone_number_id": phoneID,
+				"to_phone_number": "+0987654321",
+				"message":         "Provider override test",
+				"provider":        provider,
+			}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		It("should only let admin keys choose the provider", func() {
+			Expect(send("log", auth.ScopeSmsSend)).To(Equal(http.StatusForbidden))
+			Expect(send("smpp", auth.ScopeUserAdmin)).To(Equal(http.StatusBadRequest))
+			Expect(send("log", auth.ScopeUserAdmin)).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Sender Pools", func() {
+		It("should send from the numbers of a round robin pool in turn", func() {
+			ctx := context.Background()
+			Expect(queries.AddPhoneNumber(ctx, sqlc.AddPhoneNumberParams{UserID: userID, PhoneNumber: "+1234567891"})).To(Succeed())
+			second, err := queries.GetPhoneNumberId(ctx, sqlc.GetPhoneNumberIdParams{UserID: userID, PhoneNumber: "+1234567891"})
+			Expect(err).NotTo(HaveOccurred())
+			pool, err := queries.AddPhoneNumberPool(ctx, sqlc.AddPhoneNumberPoolParams{UserID: userID, Name: "pool", Strategy: policy.PoolRoundRobin})
+			Expect(err).NotTo(HaveOccurred())
+
+			send := func() (int, map[string]interface{}) {
+				req := httptest.NewRequest("POST", "/v1/sms", helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"pool_id":         pool.ID,
+					"to_phone_number": "+0987654321",
+					"message":         "Sender pool test",
+				}))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				var response map[string]interface{}
+				_ = helpers.ParseJSONResponse(w.Result(), &response)
+				return w.Code, response
+			}
+
+			code, _ := send()
+			Expect(code).To(Equal(http.StatusUnprocessableEntity))
+
+			for _, id := range []int32{phoneID, second} {
+				_, err = queries.AddPoolNumber(ctx, sqlc.AddPoolNumberParams{PoolID: pool.ID, PhoneNumberID: id})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			var picked []float64
+			for range 3 {
+				code, response := send()
+				Expect(code).To(Equal(http.StatusOK))
+				picked = append(picked, response["phone_number_id"].(float64))
+			}
+			Expect(picked).To(Equal([]float64{float64(phoneID), float64(second), float64(phoneID)}))
+		})
+	})
+
+	Context("Blocked Destinations", func() {
+		send := func(to string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/v1/sms",
+				helpers.JSONBody(map[string]interface{}{
+					"user_id":         userID,
+					"phone_number_id": phoneID,
+					"to_phone_number": to,
+					"message":         "Blocklist test",
+				}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		BeforeEach(func() {
+			_, err := queries.AddBlockedDestination(context.Background(), sqlc.AddBlockedDestinationParams{
+				UserID: pgtype.Int4{Int32: userID, Valid: true},
+				Kind:   policy.BlockCountry,
+				Value:  "98",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = queries.AddBlockedDestination(context.Background(), sqlc.AddBlockedDestinationParams{
+				Kind:   policy.BlockPrefix,
+				Value:  "1900",
+				Reason: "premium rate",
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should refuse destinations blocked by the user or the deny-list before charging", func() {
+			for to, code := range map[string]string{
+				"+989121234567": controllers.CodeDestinationBlocked,
+				"+19005550100":  controllers.CodeFraudRange,
+			} {
+				w := send(to)
+				Expect(w.Code).To(Equal(http.StatusForbidden))
+				var response map[string]interface{}
+				err := helpers.ParseJSONResponse(w.Result(), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["code"]).To(Equal(code))
+			}
+
+			balance, err := queries.GetBalance(context.Background(), userID)
+			Expect(err).NotTo(HaveOccurred())
+			f, _ := balance.Float64Value()
+			Expect(f.Float64).To(Equal(100.0))
+
+			Expect(send("+15550100").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Delivery Callbacks", func() {
+		const secret = "dlr-test-secret"
+		var smsID int32
+
+		report := func(sent time.Time, key string, body map[string]interface{}) *httptest.ResponseRecorder {
+			raw, err := json.Marshal(body)
+			Expect(err).NotTo(HaveOccurred())
+			req := httptest.NewRequest("POST", "/callbacks/dlr/simulator", bytes.NewReader(raw))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(webhooks.HeaderTimestamp, strconv.FormatInt(sent.Unix(), 10))
+			req.Header.Set(webhooks.HeaderSignature, webhooks.Sign(sent, raw, key))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+		submitted := func(id int32, provider, ref string) {
+			metadata := []byte(`{}`)
+			if ref != "" {
+				metadata = []byte(fmt.Sprintf(`{"ref": %q}`, ref))
+			}
+			err := queries.AddSmsEvent(context.Background(), sqlc.AddSmsEventParams{
+				SmsID:      id,
+				Event:      "submitted",
+				Actor:      "worker",
+				Provider:   pgtype.Text{String: provider, Valid: true},
+				Metadata:   metadata,
+				OccurredAt: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			cost := pgtype.Numeric{}
+			cost.Scan("5.00")
+			var err error
+			smsID, err = queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+3333333333",
+				Message:       "Submitted message",
+				Status:        "submitted",
+				Cost:          cost,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			submitted(smsID, "simulator", "")
+			_, err = controllers.NewCallback(router.Group("/"), db.NewCluster(testSuite.DB, nil), testSuite.NATSConn.Conn,
+				map[string]string{"simulator": secret, "vonage": secret})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should queue a signed report once and ignore its replays", func() {
+			body := map[string]interface{}{
+				"message_id": "prov-1",
+				"sms_id":     smsID,
+				"status":     "delivered",
+			}
+			Expect(report(time.Now(), secret, body).Code).To(Equal(http.StatusAccepted))
+
+			w := report(time.Now(), secret, body)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["msg"]).To(Equal("duplicate"))
+		})
+
+		It("should reject spoofed, stale and unknown reports", func() {
+			body := map[string]interface{}{
+				"message_id": "prov-2",
+				"sms_id":     smsID,
+				"status":     "failed",
+			}
+			Expect(report(time.Now(), "wrong-secret", body).Code).To(Equal(http.StatusUnauthorized))
+			Expect(report(time.Now().Add(-time.Hour), secret, body).Code).To(Equal(http.StatusUnauthorized))
+
+			body["sms_id"] = 999999
+			Expect(report(time.Now(), secret, body).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should refuse reports of a provider the sms wasn't submitted through", func() {
+			reportAs := func(provider string, body map[string]interface{}) *httptest.ResponseRecorder {
+				raw, err := json.Marshal(body)
+				Expect(err).NotTo(HaveOccurred())
+				sent := time.Now()
+				req := httptest.NewRequest("POST", "/callbacks/dlr/"+provider, bytes.NewReader(raw))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(webhooks.HeaderTimestamp, strconv.FormatInt(sent.Unix(), 10))
+				req.Header.Set(webhooks.HeaderSignature, webhooks.Sign(sent, raw, secret))
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w
+			}
+			body := map[string]interface{}{
+				"message_id": "prov-4",
+				"sms_id":     smsID,
+				"status":     "delivered",
+			}
+			// submitted through the simulator, reported by vonage
+			Expect(reportAs("vonage", body).Code).To(Equal(http.StatusConflict))
+
+			// submitted through vonage under a message id of its own
+			submitted(smsID, "vonage", "0A00000002")
+			Expect(reportAs("vonage", body).Code).To(Equal(http.StatusConflict))
+			body["message_id"] = "0A00000002"
+			Expect(reportAs("vonage", body).Code).To(Equal(http.StatusAccepted))
+
+			// never submitted
+			cost := pgtype.Numeric{}
+			cost.Scan("5.00")
+			pending, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+3333333333",
+				Message:       "Pending message",
+				Status:        "pending",
+				Cost:          cost,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			body["sms_id"] = pending
+			Expect(reportAs("simulator", body).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should only accept the normalized failure reasons", func() {
+			body := map[string]interface{}{
+				"message_id":     "prov-3",
+				"sms_id":         smsID,
+				"status":         "failed",
+				"failure_reason": "handset busy",
+			}
+			Expect(report(time.Now(), secret, body).Code).To(Equal(http.StatusBadRequest))
+
+			body["failure_reason"] = "absent_subscriber"
+			Expect(report(time.Now(), secret, body).Code).To(Equal(http.StatusAccepted))
+		})
+
+		It("should queue signed Vonage reports by their client-ref", func() {
+			submitted(smsID, "vonage", "0A00000001")
+			vonage := func(key string, params url.Values) *httptest.ResponseRecorder {
+				params.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+				params.Set("sig", carrier.SignVonage(key, params))
+				req := httptest.NewRequest("GET", "/callbacks/vonage/dlr?"+params.Encode(), nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w
+			}
+			params := url.Values{
+				"messageId":  {"0A00000001"},
+				"client-ref": {strconv.Itoa(int(smsID))},
+				"status":     {"delivered"},
+				"err-code":   {"0"},
+			}
+			Expect(vonage(secret, params).Code).To(Equal(http.StatusOK))
+			w := vonage(secret, params)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["msg"]).To(Equal("OK"))
+
+			params.Set("status", "buffered")
+			w = vonage(secret, params)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			err = helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["msg"]).To(Equal("ignored"))
+
+			Expect(vonage("wrong-secret", params).Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
 })
@@ -3,15 +3,18 @@ package integration_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/alireza-karampour/sms/internal/streams"
 	. "github.com/alireza-karampour/sms/internal/subjects"
 	"github.com/alireza-karampour/sms/internal/workers"
-	. "github.com/alireza-karampour/sms/pkg/utils"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -38,7 +41,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 
 		// Create SMS worker
 		var err error
-		worker, err = workers.NewSms(context.Background(), "127.0.0.1:4223", testSuite.DB)
+		worker, err = workers.NewSms(context.Background(), "127.0.0.1:4223", testSuite.DB, nil, nil)
 		Expect(err).NotTo(HaveOccurred())
 
 		// Create test user and phone number
@@ -130,7 +133,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Publish message to normal SMS subject
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 			smsJSON, err := json.Marshal(smsData)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -180,7 +183,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Publish message to express SMS subject
-			subject := MakeSubject(SMS, EX, SEND, REQ)
+			subject := ExpressSendReq()
 			smsJSON, err := json.Marshal(smsData)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -201,6 +204,77 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 
 	})
 
+	Context("Expiry", func() {
+		It("should expire an sms whose validity period already ended without charging it", func() {
+			initialBalance, err := queries.GetBalance(context.Background(), userID)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				defer GinkgoRecover()
+				Expect(worker.Start(ctx)).To(Succeed())
+			}()
+			time.Sleep(100 * time.Millisecond)
+
+			smsJSON, err := json.Marshal(sqlc.Sm{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+0987654321",
+				Message:       "Already expired SMS",
+				Status:        "pending",
+				ExpiresAt:     pgtype.Timestamp{Time: time.Now().UTC().Add(-time.Minute), Valid: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testSuite.NATSConn.Conn.Publish(NormalSendReq(), smsJSON)).To(Succeed())
+			time.Sleep(500 * time.Millisecond)
+
+			smsMessages, err := queries.GetLastSmsMessages(context.Background(), sqlc.GetLastSmsMessagesParams{
+				UserID: userID,
+				Limit:  1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(smsMessages).To(HaveLen(1))
+			Expect(smsMessages[0].Status).To(Equal("expired"))
+			cost, err := smsMessages[0].Cost.Float64Value()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cost.Float64).To(BeZero())
+
+			balance, err := queries.GetBalance(context.Background(), userID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(balance).To(Equal(initialBalance))
+		})
+
+		It("should expire submitted sms whose validity period ended without a report", func() {
+			viper.Set("worker.expiry.interval", "100ms")
+			DeferCleanup(viper.Set, "worker.expiry.interval", "1m")
+
+			id, err := queries.AddSms(context.Background(), sqlc.AddSmsParams{
+				UserID:        userID,
+				PhoneNumberID: phoneID,
+				ToPhoneNumber: "+0987654321",
+				Status:        "submitted",
+				Message:       "Overdue SMS",
+				Category:      "transactional",
+				Priority:      workers.PriorityNormal,
+				ExpiresAt:     pgtype.Timestamp{Time: time.Now().UTC().Add(-time.Minute), Valid: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				defer GinkgoRecover()
+				Expect(worker.Start(ctx)).To(Succeed())
+			}()
+
+			Eventually(func() (string, error) {
+				sms, err := queries.GetSmsStatusForUpdate(context.Background(), id)
+				return sms.Status, err
+			}, 5*time.Second, 100*time.Millisecond).Should(Equal("expired"))
+		})
+	})
+
 	Context("Error Handling", func() {
 		It("should handle invalid JSON in SMS request", func() {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -216,7 +290,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Publish invalid JSON
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 			err := testSuite.NATSConn.Conn.Publish(subject, []byte("invalid json"))
 			Expect(err).NotTo(HaveOccurred())
 
@@ -250,7 +324,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Publish message
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 			smsJSON, err := json.Marshal(smsData)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -283,7 +357,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Send 2 SMS messages rapidly
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 
 			for i := 0; i < 2; i++ {
 				smsData := sqlc.Sm{
@@ -337,7 +411,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Send 2 express SMS messages rapidly
-			subject := MakeSubject(SMS, EX, SEND, REQ)
+			subject := ExpressSendReq()
 
 			for i := 0; i < 2; i++ {
 				smsData := sqlc.Sm{
@@ -391,7 +465,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Test normal SMS rate limit - send 2 messages
-			normalSubject := MakeSubject(SMS, SEND, REQ)
+			normalSubject := NormalSendReq()
 			for i := 0; i < 2; i++ {
 				smsData := sqlc.Sm{
 					UserID:        userID,
@@ -422,7 +496,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			normalTimeDiff := normalMessages[0].DeliveredAt.Time.Sub(normalMessages[1].DeliveredAt.Time)
 
 			// Test express SMS rate limit - send 2 messages
-			expressSubject := MakeSubject(SMS, EX, SEND, REQ)
+			expressSubject := ExpressSendReq()
 			for i := 0; i < 2; i++ {
 				smsData := sqlc.Sm{
 					UserID:        userID,
@@ -477,7 +551,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 			time.Sleep(100 * time.Millisecond)
 
 			// Send 3 normal SMS messages rapidly
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 
 			for i := 0; i < 3; i++ {
 				smsData := sqlc.Sm{
@@ -517,6 +591,123 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 		})
 	})
 
+	Context("Concurrent Charges", func() {
+		var poorUserID, poorPhoneID int32
+
+		BeforeEach(func() {
+			balance := pgtype.Numeric{}
+			balance.Scan("12.00")
+			Expect(queries.AddUser(context.Background(), sqlc.AddUserParams{
+				Username: "pooruser",
+				Balance:  balance,
+			})).To(Succeed())
+			var err error
+			poorUserID, err = queries.GetUserId(context.Background(), "pooruser")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queries.AddPhoneNumber(context.Background(), sqlc.AddPhoneNumberParams{
+				UserID:      poorUserID,
+				PhoneNumber: "+1234567899",
+			})).To(Succeed())
+			poorPhoneID, err = queries.GetPhoneNumberId(context.Background(), sqlc.GetPhoneNumberIdParams{
+				UserID:      poorUserID,
+				PhoneNumber: "+1234567899",
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		balanceOf := func(id int32) float64 {
+			balance, err := queries.GetBalance(context.Background(), id)
+			Expect(err).NotTo(HaveOccurred())
+			f, err := balance.Float64Value()
+			Expect(err).NotTo(HaveOccurred())
+			return f.Float64
+		}
+
+		It("should never charge the balance below zero", func() {
+			amount := pgtype.Numeric{}
+			amount.Scan("5.00")
+			var (
+				wg      sync.WaitGroup
+				charged atomic.Int32
+			)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := queries.ChargeBalance(context.Background(), sqlc.ChargeBalanceParams{
+						Amount: amount,
+						UserID: poorUserID,
+					})
+					if errors.Is(err, pgx.ErrNoRows) {
+						return
+					}
+					Expect(err).NotTo(HaveOccurred())
+					charged.Add(1)
+				}()
+			}
+			wg.Wait()
+
+			Expect(charged.Load()).To(Equal(int32(2)))
+			Expect(balanceOf(poorUserID)).To(Equal(2.0))
+		})
+
+		It("should store the sms the user can't pay for as failed at no cost", func() {
+			// a second worker on the same consumers charges concurrently with the first
+			other, err := workers.NewSms(context.Background(), "127.0.0.1:4223", testSuite.DB, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer other.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for _, w := range []*workers.Sms{worker, other} {
+				go func() {
+					defer GinkgoRecover()
+					Expect(w.Start(ctx)).To(Succeed())
+				}()
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			const sent = 6
+			for i := 0; i < sent; i++ {
+				smsJSON, err := json.Marshal(sqlc.Sm{
+					UserID:        poorUserID,
+					PhoneNumberID: poorPhoneID,
+					ToPhoneNumber: "+0987654321",
+					Message:       fmt.Sprintf("Concurrent charge %d", i+1),
+					Status:        "pending",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(testSuite.NATSConn.Conn.Publish(ExpressSendReq(), smsJSON)).To(Succeed())
+			}
+
+			var smsMessages []sqlc.Sm
+			Eventually(func() ([]sqlc.Sm, error) {
+				smsMessages, err = queries.GetLastSmsMessages(context.Background(), sqlc.GetLastSmsMessagesParams{
+					UserID: poorUserID,
+					Limit:  sent + 1,
+				})
+				return smsMessages, err
+			}, 5*time.Second, 100*time.Millisecond).Should(HaveLen(sent))
+
+			var stored, refused int
+			for _, sms := range smsMessages {
+				cost, err := sms.Cost.Float64Value()
+				Expect(err).NotTo(HaveOccurred())
+				if sms.Status == "failed" {
+					Expect(cost.Float64).To(BeZero())
+					refused++
+					continue
+				}
+				Expect(cost.Float64).To(Equal(5.0))
+				stored++
+			}
+			Expect(stored).To(Equal(2))
+			Expect(refused).To(Equal(sent - 2))
+			Expect(balanceOf(poorUserID)).To(Equal(2.0))
+		})
+	})
+
 	Context("Concurrent Processing", func() {
 		It("should handle multiple SMS messages concurrently", func() {
 			// Get initial balance
@@ -537,7 +728,7 @@ var _ = Describe("SMS Worker Integration Tests", func() {
 
 			// Send multiple SMS messages
 			numMessages := 3
-			subject := MakeSubject(SMS, SEND, REQ)
+			subject := NormalSendReq()
 
 			for i := 0; i < numMessages; i++ {
 				smsData := sqlc.Sm{
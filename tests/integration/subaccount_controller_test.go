@@ -0,0 +1,159 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/alireza-karampour/sms/internal/billing"
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subaccount Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		ctx       = context.Background()
+	)
+
+	do := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, helpers.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var res map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		_ = controllers.NewUser(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil))
+
+		Expect(queries.AddUser(ctx, sqlc.AddUserParams{
+			Username: "acme",
+			Balance:  billing.Amount(10000),
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	It("should charge a pooled subaccount from the balance of its parent", func() {
+		code, sub := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{
+			"username": "marketing",
+			"pooled":   true,
+		})
+		Expect(code).To(Equal(http.StatusCreated))
+		Expect(sub["pooled"]).To(BeTrue())
+		Expect(sub["parent_id"]).NotTo(BeNil())
+
+		subID := int32(sub["id"].(float64))
+		_, err := queries.ChargeBalance(ctx, sqlc.ChargeBalanceParams{Amount: billing.Amount(2500), UserID: subID})
+		Expect(err).NotTo(HaveOccurred())
+
+		balance, err := queries.GetBalanceByUsername(ctx, "acme")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(balance)).To(Equal(int64(7500)))
+		balance, err = queries.GetBalanceByUsername(ctx, "marketing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(balance)).To(Equal(int64(7500)))
+
+		code, _ = do("POST", "/v1/user/acme/subaccounts/marketing/transfer", map[string]interface{}{"amount": "10.00"})
+		Expect(code).To(Equal(http.StatusConflict))
+	})
+
+	It("should move funds between a parent and a subaccount with its own balance", func() {
+		code, _ := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{"username": "support"})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		code, res := do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "30.00"})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res["parent_balance"]).To(Equal("70.00"))
+		Expect(res["balance"]).To(Equal("30.00"))
+
+		code, res = do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "-10.00"})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res["parent_balance"]).To(Equal("80.00"))
+		Expect(res["balance"]).To(Equal("20.00"))
+
+		code, _ = do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "-50.00"})
+		Expect(code).To(Equal(http.StatusConflict))
+	})
+
+	It("should refuse amounts a balance can't hold", func() {
+		code, _ := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{"username": "support"})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		for _, amount := range []string{"0", "0.001", "1e17", "-100000000.00", "99999999999999999999", "12.5.0", "NaN"} {
+			code, _ = do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": amount})
+			Expect(code).To(Equal(http.StatusBadRequest), amount)
+		}
+		code, _ = do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "99999999.99"})
+		Expect(code).To(Equal(http.StatusConflict))
+
+		balance, err := queries.GetBalanceByUsername(ctx, "acme")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(balance)).To(Equal(int64(10000)))
+	})
+
+	It("should refuse transfers that overflow the balance they are moved to", func() {
+		code, sub := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{"username": "support"})
+		Expect(code).To(Equal(http.StatusCreated))
+		code, _ = do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "50.00"})
+		Expect(code).To(Equal(http.StatusOK))
+		_, err := queries.RefundBalance(ctx, sqlc.RefundBalanceParams{Amount: billing.Amount(9999994999), UserID: int32(sub["id"].(float64))})
+		Expect(err).NotTo(HaveOccurred())
+
+		code, res := do("POST", "/v1/user/acme/subaccounts/support/transfer", map[string]interface{}{"amount": "10.00"})
+		Expect(code).To(Equal(http.StatusConflict))
+		Expect(res["errors"]).To(ContainElement(controllers.ErrBalanceOverflow.Error()))
+
+		balance, err := queries.GetBalanceByUsername(ctx, "acme")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(balance)).To(Equal(int64(5000)))
+		balance, err = queries.GetBalanceByUsername(ctx, "support")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(billing.Cents(balance)).To(Equal(int64(9999999999)))
+	})
+
+	It("should refuse subaccounts of subaccounts", func() {
+		code, _ := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{"username": "support"})
+		Expect(code).To(Equal(http.StatusCreated))
+		code, _ = do("POST", "/v1/user/support/subaccounts", map[string]interface{}{"username": "nested"})
+		Expect(code).To(Equal(http.StatusConflict))
+	})
+
+	It("should update the quota and list the subaccounts", func() {
+		code, _ := do("POST", "/v1/user/acme/subaccounts", map[string]interface{}{
+			"username":      "support",
+			"monthly_quota": 100,
+		})
+		Expect(code).To(Equal(http.StatusCreated))
+
+		code, sub := do("PATCH", "/v1/user/acme/subaccounts/support", map[string]interface{}{"monthly_quota": 0})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(sub).NotTo(HaveKey("monthly_quota"))
+
+		code, res := do("GET", "/v1/user/acme/subaccounts", nil)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res["subaccounts"]).To(HaveLen(1))
+
+		code, res = do("GET", "/v1/user/acme/subaccounts/usage?from=2024-01-01T00:00:00Z", nil)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res["accounts"]).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,178 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/auth"
+	"github.com/alireza-karampour/sms/pkg/db"
+	"github.com/alireza-karampour/sms/pkg/middlewares"
+	"github.com/alireza-karampour/sms/sqlc"
+	"github.com/alireza-karampour/sms/tests/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Token Controller Integration Tests", func() {
+	var (
+		testSuite *helpers.TestSuite
+		router    *gin.Engine
+		queries   *sqlc.Queries
+		cluster   *db.Cluster
+		adminKey  string
+	)
+
+	BeforeEach(func() {
+		testSuite = helpers.SetupTestSuite()
+		queries = sqlc.New(testSuite.DB)
+		cluster = db.NewCluster(testSuite.DB, nil)
+		viper.Set("api.auth.tokens.defaultttl", "720h")
+		viper.Set("api.auth.tokens.maxttl", "8760h")
+		viper.Set("api.auth.tokens.rotation.grace", "1h")
+		DeferCleanup(viper.Set, "api.auth.tokens.defaultttl", "")
+		DeferCleanup(viper.Set, "api.auth.tokens.maxttl", "")
+		DeferCleanup(viper.Set, "api.auth.tokens.rotation.grace", "")
+
+		signer, err := auth.NewSigner("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+		Expect(err).NotTo(HaveOccurred())
+
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		root := router.Group("/", middlewares.Authenticate(controllers.LookupApiKey(cluster)))
+		controllers.NewToken(controllers.NewVersions(root), cluster, signer)
+
+		balance := pgtype.Numeric{}
+		balance.Scan("100.00")
+		for _, username := range []string{"tokenadmin", "terraform"} {
+			err = queries.AddUser(context.Background(), sqlc.AddUserParams{
+				Username: username,
+				Balance:  balance,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		adminID, err := queries.GetUserId(context.Background(), "tokenadmin")
+		Expect(err).NotTo(HaveOccurred())
+		adminKey, _, err = controllers.CreateKey(context.Background(), queries, adminID, "admin", []string{auth.ScopeUserAdmin}, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		testSuite.CleanupTestData()
+		testSuite.Cleanup()
+	})
+
+	do := func(method, path, key string, body map[string]interface{}) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, helpers.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type issued struct {
+		Token        string `json:"token"`
+		ServiceToken struct {
+			ID          int32  `json:"id"`
+			Hint        string `json:"hint"`
+			ExpiresAt   string `json:"expires_at"`
+			RotatedFrom int32  `json:"rotated_from"`
+		} `json:"service_token"`
+	}
+	issue := func(body map[string]interface{}) issued {
+		w := do("POST", "/v1/tokens", adminKey, body)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var got issued
+		Expect(helpers.ParseJSONResponse(w.Result(), &got)).To(Succeed())
+		return got
+	}
+
+	It("should issue signed tokens carrying their id and expiry", func() {
+		got := issue(map[string]interface{}{
+			"username":    "terraform",
+			"name":        "ci",
+			"scopes":      []string{auth.ScopeUserRead},
+			"ttl_seconds": 3600,
+		})
+		Expect(auth.Signed(got.Token)).To(BeTrue())
+		Expect(got.ServiceToken.Hint).To(Equal(auth.TokenHint(got.Token)))
+		expires, err := time.Parse(time.RFC3339, got.ServiceToken.ExpiresAt)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expires).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		w := do("GET", "/v1/tokens/user/terraform", adminKey, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(got.ServiceToken.Hint))
+		Expect(w.Body.String()).NotTo(ContainSubstring(got.Token))
+
+		// service tokens can't manage tokens without user:admin
+		Expect(do("GET", "/v1/tokens/user/terraform", got.Token, nil).Code).To(Equal(http.StatusForbidden))
+		Expect(do("POST", "/v1/tokens", adminKey, map[string]interface{}{"username": "nobody", "name": "ci", "scopes": []string{auth.ScopeUserRead}}).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should rotate a token and expire the old one after the grace period", func() {
+		old := issue(map[string]interface{}{
+			"username": "terraform",
+			"name":     "ci",
+			"scopes":   []string{auth.ScopeUserRead},
+		})
+
+		w := do("POST", "/v1/tokens/rotate", old.Token, map[string]interface{}{"grace_seconds": 0})
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var rotated issued
+		Expect(helpers.ParseJSONResponse(w.Result(), &rotated)).To(Succeed())
+		Expect(rotated.ServiceToken.RotatedFrom).To(Equal(old.ServiceToken.ID))
+		Expect(rotated.ServiceToken.ExpiresAt).NotTo(BeEmpty())
+
+		Expect(do("POST", "/v1/tokens/rotate", old.Token, nil).Code).To(Equal(http.StatusUnauthorized))
+		// offline verification rejects it too, its signature didn't expire
+		disabled, err := controllers.DisabledTokens(cluster)(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(disabled).To(ContainElement(old.ServiceToken.ID))
+		Expect(do("POST", fmt.Sprintf("/v1/tokens/%d/rotate", old.ServiceToken.ID), adminKey, nil).Code).To(Equal(http.StatusNotFound))
+
+		w = do("POST", fmt.Sprintf("/v1/tokens/%d/rotate", rotated.ServiceToken.ID), adminKey, map[string]interface{}{"ttl_seconds": 600})
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		// the old one keeps working during the default grace period
+		Expect(do("POST", "/v1/tokens/rotate", rotated.Token, map[string]interface{}{"grace_seconds": 0}).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should revoke tokens but not API keys", func() {
+		got := issue(map[string]interface{}{
+			"username": "terraform",
+			"name":     "ci",
+			"scopes":   []string{auth.ScopeUserRead},
+		})
+		Expect(do("DELETE", fmt.Sprintf("/v1/tokens/%d", got.ServiceToken.ID), adminKey, nil).Code).To(Equal(http.StatusOK))
+		Expect(do("DELETE", fmt.Sprintf("/v1/tokens/%d", got.ServiceToken.ID), adminKey, nil).Code).To(Equal(http.StatusNotFound))
+		Expect(do("POST", "/v1/tokens/rotate", got.Token, nil).Code).To(Equal(http.StatusUnauthorized))
+
+		disabled, err := controllers.DisabledTokens(cluster)(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(disabled).To(ContainElement(got.ServiceToken.ID))
+
+		Expect(do("POST", "/v1/tokens/rotate", adminKey, nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should record when keys were last used", func() {
+		got := issue(map[string]interface{}{
+			"username": "terraform",
+			"name":     "ci",
+			"scopes":   []string{auth.ScopeUserRead},
+		})
+		at := time.Now().UTC().Truncate(time.Second)
+		Expect(controllers.TouchKeys(cluster)(context.Background(), []int32{got.ServiceToken.ID}, []time.Time{at})).To(Succeed())
+		Expect(controllers.TouchKeys(cluster)(context.Background(), []int32{got.ServiceToken.ID}, []time.Time{at.Add(-time.Hour)})).To(Succeed())
+
+		w := do("GET", "/v1/tokens/user/terraform", adminKey, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(at.Format(time.RFC3339)))
+	})
+})
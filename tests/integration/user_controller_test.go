@@ -6,12 +6,14 @@ import (
 	"net/http/httptest"
 
 	"github.com/alireza-karampour/sms/internal/controllers"
+	"github.com/alireza-karampour/sms/pkg/db"
 	"github.com/alireza-karampour/sms/sqlc"
 	"github.com/alireza-karampour/sms/tests/helpers"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgtype"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
 )
 
 var _ = Describe("User Controller Integration Tests", func() {
@@ -30,7 +32,7 @@ var _ = Describe("User Controller Integration Tests", func() {
 		router = gin.New()
 		
 		// Create user controller
-		_ = controllers.NewUser(router.Group("/"), testSuite.DB)
+		_ = controllers.NewUser(controllers.NewVersions(router.Group("/")), db.NewCluster(testSuite.DB, nil))
 	})
 
 	AfterEach(func() {
@@ -140,7 +142,7 @@ var _ = Describe("User Controller Integration Tests", func() {
 	Context("HTTP API Tests", func() {
 		It("should create user via HTTP POST", func() {
 			// Create HTTP request
-			req := httptest.NewRequest("POST", "/user", 
+			req := httptest.NewRequest("POST", "/v1/user", 
 				helpers.JSONBody(map[string]interface{}{
 					"username": "httptestuser",
 					"balance":  "100.00",
@@ -173,7 +175,7 @@ var _ = Describe("User Controller Integration Tests", func() {
 			Expect(err).NotTo(HaveOccurred())
 			
 			// Create HTTP request
-			req := httptest.NewRequest("GET", "/user/gettestuser", nil)
+			req := httptest.NewRequest("GET", "/v1/user/gettestuser", nil)
 			
 			// Create response recorder
 			w := httptest.NewRecorder()
@@ -203,7 +205,7 @@ var _ = Describe("User Controller Integration Tests", func() {
 			Expect(err).NotTo(HaveOccurred())
 			
 			// Create HTTP request
-			req := httptest.NewRequest("PUT", "/user/balance", 
+			req := httptest.NewRequest("PUT", "/v1/user/balance", 
 				helpers.JSONBody(map[string]interface{}{
 					"username": username,
 					"balance":  "50.00",
@@ -227,4 +229,155 @@ var _ = Describe("User Controller Integration Tests", func() {
 			Expect(response["new_balance"]).To(Equal("150.00"))
 		})
 	})
-})
\ No newline at end of file
+
+	Context("Balance", func() {
+		BeforeEach(func() {
+			balance := pgtype.Numeric{}
+			balance.Scan("100.00")
+			err := queries.AddUser(context.Background(), sqlc.AddUserParams{
+				Username: "balanceuser",
+				Balance:  balance,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should return the balance via HTTP GET", func() {
+			req := httptest.NewRequest("GET", "/v1/user/balanceuser/balance", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get(controllers.HeaderLowBalance)).To(BeEmpty())
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["balance"]).To(Equal("100.00"))
+			Expect(response["low_balance"]).To(BeFalse())
+		})
+
+		It("should flag a low balance", func() {
+			viper.Set("api.balance.lowthreshold", 500)
+			DeferCleanup(viper.Set, "api.balance.lowthreshold", 0)
+
+			req := httptest.NewRequest("GET", "/v1/user/balanceuser/balance", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get(controllers.HeaderLowBalance)).To(Equal("true"))
+		})
+
+		It("should return 404 for unknown users", func() {
+			req := httptest.NewRequest("GET", "/v1/user/nobody/balance", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("Listing and Updates", func() {
+		var ids []int32
+
+		BeforeEach(func() {
+			ids = nil
+			for _, name := range []string{"listone", "listtwo", "listthree"} {
+				balance := pgtype.Numeric{}
+				balance.Scan("10.00")
+				err := queries.AddUser(context.Background(), sqlc.AddUserParams{
+					Username: name,
+					Balance:  balance,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				id, err := queries.GetUserId(context.Background(), name)
+				Expect(err).NotTo(HaveOccurred())
+				ids = append(ids, id)
+			}
+		})
+
+		It("should return the full user via HTTP GET", func() {
+			req := httptest.NewRequest("GET", "/v1/user/listone", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := helpers.ParseJSONResponse(w.Result(), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["username"]).To(Equal("listone"))
+			Expect(response["balance"]).To(Equal("10.00"))
+		})
+
+		It("should return 404 for unknown users", func() {
+			req := httptest.NewRequest("GET", "/v1/user/nobody", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should page through users", func() {
+			req := httptest.NewRequest("GET", "/v1/users?limit=2", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var page struct {
+				Users []struct {
+					ID int32 `json:"id"`
+				} `json:"users"`
+				Next int32 `json:"next"`
+			}
+			err := helpers.ParseJSONResponse(w.Result(), &page)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Users).To(HaveLen(2))
+			Expect(page.Next).To(Equal(ids[1]))
+
+			req = httptest.NewRequest("GET", "/v1/users?limit=2&after="+helpers.Int32ToString(page.Next), nil)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			page.Next = 0
+			err = helpers.ParseJSONResponse(w.Result(), &page)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(page.Users).To(HaveLen(1))
+			Expect(page.Users[0].ID).To(Equal(ids[2]))
+			Expect(page.Next).To(BeZero())
+		})
+
+		It("should rename a user via HTTP PATCH", func() {
+			body := helpers.JSONBody(map[string]interface{}{"username": "renamed", "footer": "bye"})
+			req := httptest.NewRequest("PATCH", "/v1/user/"+helpers.Int32ToString(ids[0]), body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			user, err := queries.GetUser(context.Background(), "renamed")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(user.ID).To(Equal(ids[0]))
+			Expect(user.Footer.String).To(Equal("bye"))
+		})
+
+		It("should answer 409 when renaming to a taken username", func() {
+			body := helpers.JSONBody(map[string]interface{}{"username": "listtwo"})
+			req := httptest.NewRequest("PATCH", "/v1/user/"+helpers.Int32ToString(ids[0]), body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should return 404 when updating an unknown user", func() {
+			body := helpers.JSONBody(map[string]interface{}{"footer": ""})
+			req := httptest.NewRequest("PATCH", "/v1/user/999999", body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})